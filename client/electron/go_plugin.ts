@@ -18,13 +18,29 @@ import koffi from 'koffi';
 
 import {pathToBackendLibrary} from './app_paths';
 
+let backendLib: koffi.IKoffiLib | undefined;
 let invokeMethodFunc: Function | undefined;
+let invokeMethodWithCancelerFunc: Function | undefined;
+let newCancelerFunc: Function | undefined;
+let cancelFunc: Function | undefined;
+let subscribeFunc: Function | undefined;
+let unsubscribeFunc: Function | undefined;
+let eventCallbackProto: koffi.IKoffiCType | undefined;
+
+function getBackendLib(): koffi.IKoffiLib {
+  if (!backendLib) {
+    backendLib = koffi.load(pathToBackendLibrary());
+  }
+  return backendLib;
+}
 
 /**
  * Calls a Go function by invoking the `InvokeMethod` function in the native backend library.
  *
  * @param method The name of the Go method to invoke.
  * @param input The input string to pass to the API.
+ * @param signal An optional signal aborting the call, which then fails with
+ *   ERR_OPERATION_CANCELED_BY_USER. Only the Go methods making network requests can be aborted.
  * @returns A Promise that resolves to the output string returned by the API.
  * @throws An Error containing PlatformError details if the API call fails.
  *
@@ -34,10 +50,11 @@ let invokeMethodFunc: Function | undefined;
  */
 export async function invokeMethod(
   method: string,
-  input: string
+  input: string,
+  signal?: AbortSignal
 ): Promise<string> {
-  if (!invokeMethodFunc) {
-    const backendLib = koffi.load(pathToBackendLibrary());
+  if (!invokeMethodFunc || !invokeMethodWithCancelerFunc) {
+    const backendLib = getBackendLib();
 
     // Define C strings and setup auto release
     const cgoString = koffi.disposable(
@@ -54,13 +71,74 @@ export async function invokeMethod(
     invokeMethodFunc = promisify(
       backendLib.func('InvokeMethod', invokeMethodResult, ['str', 'str']).async
     );
+    invokeMethodWithCancelerFunc = promisify(
+      backendLib.func('InvokeMethodWithCanceler', invokeMethodResult, [
+        'str',
+        'str',
+        'int',
+      ]).async
+    );
+    newCancelerFunc = backendLib.func('NewCanceler', 'int', []);
+    cancelFunc = backendLib.func('Cancel', 'void', ['int']);
   }
 
   console.debug(`[Backend] - calling InvokeMethod "${method}" ...`);
-  const result = await invokeMethodFunc(method, input);
+  let result;
+  if (signal) {
+    const cancelerId = newCancelerFunc!();
+    const onAbort = () => cancelFunc!(cancelerId);
+    signal.addEventListener('abort', onAbort, {once: true});
+    if (signal.aborted) {
+      onAbort();
+    }
+    try {
+      result = await invokeMethodWithCancelerFunc(method, input, cancelerId);
+    } finally {
+      signal.removeEventListener('abort', onAbort);
+    }
+  } else {
+    result = await invokeMethodFunc(method, input);
+  }
   console.debug(`[Backend] - InvokeMethod "${method}" returned`, result);
   if (result.ErrorJson) {
     throw Error(result.ErrorJson);
   }
   return result.Output;
 }
+
+/**
+ * Subscribes to the events of the given type emitted by the native backend library.
+ *
+ * @param eventType The type of the events to receive, see the `Event*` constants in Go.
+ * @param listener The function called with the event type and the JSON string of the event data.
+ * @returns A function that cancels the subscription.
+ *
+ * @remarks
+ * Ensure that the function signatures are consistent with the C definitions in
+ * `./client/go/outline/electron/go_plugin.go` and `./client/go/outline/electron/event_callback.go`.
+ */
+export function subscribeEvent(
+  eventType: string,
+  listener: (eventType: string, data: string) => void
+): () => void {
+  if (!subscribeFunc || !unsubscribeFunc || !eventCallbackProto) {
+    const backendLib = getBackendLib();
+    eventCallbackProto = koffi.proto(
+      'void EventCallback(const char *eventType, const char *data)'
+    );
+    subscribeFunc = backendLib.func('Subscribe', 'int', [
+      'str',
+      koffi.pointer(eventCallbackProto),
+    ]);
+    unsubscribeFunc = backendLib.func('Unsubscribe', 'void', ['int']);
+  }
+
+  const callback = koffi.register(listener, koffi.pointer(eventCallbackProto));
+  const id = subscribeFunc(eventType, callback);
+  console.debug(`[Backend] - subscribed to "${eventType}" events`);
+  return () => {
+    unsubscribeFunc!(id);
+    koffi.unregister(callback);
+    console.debug(`[Backend] - unsubscribed from "${eventType}" events`);
+  };
+}
@@ -20,6 +20,7 @@ import {pathToEmbeddedTun2socksBinary} from './app_paths';
 import {checkUDPConnectivity} from './go_helpers';
 import {ChildProcessHelper, ProcessTerminatedSignalError} from './process';
 import {RoutingDaemon} from './routing_service';
+import {Tun2socksService} from './tun2socks_service';
 import {VpnTunnel} from './vpn_tunnel';
 import {TransportConfigJson} from '../src/www/app/outline_server_repository/config';
 import {TunnelStatus} from '../src/www/app/outline_server_repository/vpn';
@@ -42,12 +43,15 @@ const DNS_RESOLVERS = ['1.1.1.1', '9.9.9.9'];
 //
 // |TAP| <-> |outline-go-tun2socks| <-> |Outline proxy|
 //
+// On Windows, outline-go-tun2socks runs as a service instead, which the app controls through a
+// named pipe, so that the app doesn't need to launch a process with administrator rights.
+//
 // In addition to the basic lifecycle of the helper processes, this class restarts tun2socks
 // on unexpected failures and network changes if necessary.
 // Follows the Mediator pattern in that none of the "helpers" know anything
 // about the others.
 export class GoVpnTunnel implements VpnTunnel {
-  private readonly tun2socks: GoTun2socks;
+  private readonly tun2socks: GoTun2socks | Tun2socksService;
   private isDebugMode = false;
 
   // See #resumeListener.
@@ -66,7 +70,7 @@ export class GoVpnTunnel implements VpnTunnel {
     private readonly routing: RoutingDaemon,
     readonly transportConfig: TransportConfigJson
   ) {
-    this.tun2socks = new GoTun2socks();
+    this.tun2socks = isWindows ? new Tun2socksService() : new GoTun2socks();
 
     // This promise, tied to both helper process' exits, is key to the instance's
     // lifecycle:
@@ -357,7 +357,25 @@ async function createVpnTunnel(
     throw new errors.IllegalServerConfiguration('host is missing');
   }
   const hostIp = await lookupIp(host);
-  const routing = new RoutingDaemon(hostIp || '', isAutoConnect);
+  // The transport may connect to other hosts directly too, e.g. when its TCP connections go
+  // through an outbound proxy, so they are excluded from the tunnel as well.
+  const extraHosts = new Set(
+    [
+      ...(tunnelConfig.firstHopTcp ?? []),
+      ...(tunnelConfig.firstHopUdp ?? []),
+    ].map(hop => hop.host)
+  );
+  extraHosts.delete(host);
+  const extraHostIps = new Set<string>();
+  for (const extraHost of extraHosts) {
+    const extraHostIp = await lookupIp(extraHost);
+    if (extraHostIp && extraHostIp !== hostIp) {
+      extraHostIps.add(extraHostIp);
+    }
+  }
+  const routing = new RoutingDaemon(hostIp || '', isAutoConnect, [
+    ...extraHostIps,
+  ]);
   // Make sure the transport will use the IP we will allowlist.
   const resolvedTransport =
     config.setTransportConfigHost(tunnelConfig.transport, hostIp) ??
@@ -12,9 +12,11 @@
 :: See the License for the specific language governing permissions and
 :: limitations under the License.
 
-:: Stops/uninstalls and starts/reinstalls OutlineService.
+:: Stops/uninstalls and starts/reinstalls OutlineService and OutlineTun2socks.
 :: Intended to be called by both the installer and client.
 ::
+:: The path of tun2socks.exe can be passed as the first argument, e.g. during development.
+::
 :: Does *not* fail if any step fails: the caller must check
 :: whether the service is actually running (see final exit statement).
 
@@ -32,6 +34,17 @@ set PWD=%~dp0%
 %SystemRoot%\System32\sc create OutlineService binpath= "\"%PWD%OutlineService.exe\"" displayname= "OutlineService" start= "auto"
 %SystemRoot%\System32\net start OutlineService
 
+:: Same for the tun2socks service, which relays the traffic of the TAP device.
+:: Its flags must be kept in sync with the TAP device of go_vpn_tunnel.ts.
+set TUN2SOCKS=%PWD%resources\app.asar.unpacked\client\output\build\windows\tun2socks.exe
+if not "%~1"=="" set TUN2SOCKS=%~1
+:: Only the installing user, besides the system and the administrators, may use its control pipe.
+for /f "tokens=2 delims=," %%i in ('%SystemRoot%\System32\whoami /user /fo csv /nh') do set USER_SID=%%~i
+%SystemRoot%\System32\net stop OutlineTun2socks
+%SystemRoot%\System32\sc delete OutlineTun2socks
+%SystemRoot%\System32\sc create OutlineTun2socks binpath= "\"%TUN2SOCKS%\" -service -tunName outline-tap0 -tunAddr 10.0.85.2 -tunGw 10.0.85.1 -tunMask 255.255.255.0 -tunDNS 1.1.1.1,9.9.9.9 -controlUser !USER_SID!" displayname= "OutlineTun2socks" start= "auto"
+%SystemRoot%\System32\net start OutlineTun2socks
+
 :: This is for the client: sudo-prompt discards stdout/stderr if the script
 :: exits with a non-zero return code *which will happen if any of the previous
 :: commands failed*.
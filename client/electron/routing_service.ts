@@ -20,7 +20,10 @@ import * as path from 'path';
 import * as fsextra from 'fs-extra';
 import * as sudo from 'sudo-prompt';
 
-import {pathToEmbeddedOutlineService} from './app_paths';
+import {
+  pathToEmbeddedOutlineService,
+  pathToEmbeddedTun2socksBinary,
+} from './app_paths';
 import {TunnelStatus} from '../src/www/app/outline_server_repository/vpn';
 import {ErrorCode} from '../src/www/model/errors';
 import {
@@ -85,9 +88,12 @@ export class RoutingDaemon {
 
   private networkChangeListener?: (status: TunnelStatus) => void;
 
+  // extraProxyAddresses are the other hosts the transport connects to directly, like the outbound
+  // proxy of its TCP connections, which are excluded from the tunnel too.
   constructor(
     private proxyAddress: string,
-    private isAutoConnect: boolean
+    private isAutoConnect: boolean,
+    private extraProxyAddresses: string[] = []
   ) {}
 
   // Fulfills once a connection is established with the routing daemon *and* it has successfully
@@ -149,6 +155,7 @@ export class RoutingDaemon {
             action: RoutingServiceAction.CONFIGURE_ROUTING,
             parameters: {
               proxyIp: this.proxyAddress,
+              extraProxyIps: this.extraProxyAddresses.join(','),
               isAutoConnect: this.isAutoConnect,
             },
           } as RoutingServiceRequest)
@@ -303,7 +310,7 @@ function installWindowsRoutingServices(): Promise<void> {
   const script = `"${path.join(
     pathToEmbeddedOutlineService(),
     WINDOWS_INSTALLER_FILENAME
-  )}"`;
+  )}" "${pathToEmbeddedTun2socksBinary()}"`;
   return executeCommandAsRoot(script);
 }
 
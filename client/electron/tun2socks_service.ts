@@ -0,0 +1,103 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import {createConnection} from 'node:net';
+
+import {TransportConfigJson} from '../src/www/app/outline_server_repository/config';
+import {
+  PlatformError,
+  ROUTING_SERVICE_NOT_RUNNING,
+} from '../src/www/model/platform_error';
+
+// Must be kept in sync with `defaultServicePipe` in
+// `./client/go/outline/electron/service_windows.go`.
+const TUN2SOCKS_SERVICE_PIPE = '\\\\.\\pipe\\OutlineTun2socksPipe';
+
+interface ControlResponse {
+  id: number;
+  result?: unknown;
+  error?: object;
+}
+
+// Calls a method of the control channel of the tun2socks Windows service, see
+// `./client/go/outline/control`. Each message is a 4-byte big-endian length followed by a JSON
+// object of that length.
+function callService(method: string, params?: object): Promise<unknown> {
+  return new Promise((resolve, reject) => {
+    let received = Buffer.alloc(0);
+    let connected = false;
+    const socket = createConnection(TUN2SOCKS_SERVICE_PIPE, () => {
+      connected = true;
+      const data = Buffer.from(JSON.stringify({id: 1, method, params}));
+      const header = Buffer.alloc(4);
+      header.writeUInt32BE(data.length);
+      socket.write(Buffer.concat([header, data]));
+    });
+    socket.on('data', chunk => {
+      received = Buffer.concat([received, chunk]);
+      if (
+        received.length < 4 ||
+        received.length < 4 + received.readUInt32BE()
+      ) {
+        return;
+      }
+      socket.end();
+      const response: ControlResponse = JSON.parse(
+        received.subarray(4, 4 + received.readUInt32BE()).toString()
+      );
+      if (response.error) {
+        reject(new Error(JSON.stringify(response.error)));
+      } else {
+        resolve(response.result);
+      }
+    });
+    socket.once('error', err => {
+      if (connected) {
+        reject(err);
+        return;
+      }
+      // Raising ROUTING_SERVICE_NOT_RUNNING makes the app (re)install the services.
+      const perr = new PlatformError(
+        ROUTING_SERVICE_NOT_RUNNING,
+        'tun2socks service is not running',
+        {cause: err}
+      );
+      reject(new Error(perr.toJSON()));
+    });
+  });
+}
+
+// Runs tun2socks in the Windows service installed by `install_windows_service.bat`, which opens
+// the TAP device and relays its traffic to the Outline proxy server on behalf of the app.
+export class Tun2socksService {
+  // Connects the tunnel, or replaces its transport if it is already connected.
+  async start(
+    config: TransportConfigJson,
+    isUdpEnabled: boolean
+  ): Promise<void> {
+    console.debug('[tun2socks] - connecting through the service ...');
+    await callService('Connect', {
+      transport: JSON.stringify(config),
+      dnsFallback: !isUdpEnabled,
+    });
+    console.debug('[tun2socks] - started');
+  }
+
+  async stop(): Promise<void> {
+    await callService('Disconnect');
+  }
+
+  // The logging level of the service is set when it is installed.
+  enableDebugMode() {}
+}
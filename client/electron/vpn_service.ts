@@ -24,7 +24,13 @@ interface VpnConfig {
   interfaceName: string;
   connectionName: string;
   ipAddress: string;
+  // Unique local IPv6 address of the TUN device. IPv6 traffic is only routed to the VPN if set.
+  ipv6Address?: string;
   dnsServers: string[];
+  // MTU of the TUN device, or the system default if unset.
+  mtu?: number;
+  // Discovers the largest MTU the proxy path carries, overriding `mtu` on success.
+  probeMtu?: boolean;
   routingTableId: number;
   routingPriority: number;
   protectionMark: number;
@@ -40,8 +40,9 @@ using Newtonsoft.Json;
  * Requests
  *
  * configureRouting: Modifies the system's routing table to route all traffic through the TAP device
- * except that destined for proxyIp. Disables IPv6 traffic.
- *    { action: "configureRouting", parameters: {"proxyIp": <IPv4 address>, "isAutoConnect": "false" }}
+ * except that destined for proxyIp and the optional extraProxyIps, the other hosts the transport
+ * connects to directly, e.g. the outbound proxy of its TCP connections. Disables IPv6 traffic.
+ *    { action: "configureRouting", parameters: {"proxyIp": <IPv4 address>, "extraProxyIps": "<IPv4 address>,...", "isAutoConnect": "false" }}
  *
  *  resetRouting: Restores the system's default routing.
  *    { action: "resetRouting"}
@@ -73,6 +74,7 @@ namespace OutlineService
         private const string ACTION_RESET_ROUTING = "resetRouting";
         private const string ACTION_STATUS_CHANGED = "statusChanged";
         private const string PARAM_PROXY_IP = "proxyIp";
+        private const string PARAM_EXTRA_PROXY_IPS = "extraProxyIps";
         private const string PARAM_AUTO_CONNECT = "isAutoConnect";
 
         private static string[] IPV4_SUBNETS = { "0.0.0.0/1", "128.0.0.0/1" };
@@ -103,6 +105,7 @@ namespace OutlineService
         private EventLog eventLog;
         private NamedPipeServerStream pipe;
         private string proxyIp;
+        private string[] extraProxyIps = new string[0];
         private string gatewayIp;
         private int gatewayInterfaceIndex;
 
@@ -332,7 +335,10 @@ namespace OutlineService
             switch (request.action)
             {
                 case ACTION_CONFIGURE_ROUTING:
-                    ConfigureRouting(request.parameters[PARAM_PROXY_IP], Boolean.Parse(request.parameters[PARAM_AUTO_CONNECT]));
+                    string extraProxyIpsParam;
+                    request.parameters.TryGetValue(PARAM_EXTRA_PROXY_IPS, out extraProxyIpsParam);
+                    var extraProxyIps = (extraProxyIpsParam ?? "").Split(new[] { ',' }, StringSplitOptions.RemoveEmptyEntries);
+                    ConfigureRouting(request.parameters[PARAM_PROXY_IP], extraProxyIps, Boolean.Parse(request.parameters[PARAM_AUTO_CONNECT]));
                     break;
                 case ACTION_RESET_ROUTING:
                     ResetRouting(proxyIp, gatewayInterfaceIndex);
@@ -390,7 +396,7 @@ namespace OutlineService
         // TODO: The client needs to handle certain autoconnect failures better,
         //       e.g. if IPv4 redirect fails then the client is not really in
         //       the reconnecting state; the system is leaking traffic.
-        public void ConfigureRouting(string proxyIp, bool isAutoConnect)
+        public void ConfigureRouting(string proxyIp, string[] extraProxyIps, bool isAutoConnect)
         {
             try
             {
@@ -412,6 +418,10 @@ namespace OutlineService
                 try
                 {
                     AddOrUpdateProxyRoute(proxyIp, gatewayIp, gatewayInterfaceIndex);
+                    foreach (string extraProxyIp in extraProxyIps)
+                    {
+                        AddOrUpdateProxyRoute(extraProxyIp, gatewayIp, gatewayInterfaceIndex);
+                    }
                     eventLog.WriteEntry($"created route to proxy");
                 }
                 catch (Exception e)
@@ -419,6 +429,7 @@ namespace OutlineService
                     throw new Exception($"could not create route to proxy: {e.Message}");
                 }
                 this.proxyIp = proxyIp;
+                this.extraProxyIps = extraProxyIps;
 
                 try
                 {
@@ -507,6 +518,10 @@ namespace OutlineService
                 try
                 {
                     DeleteProxyRoute(proxyIp);
+                    foreach (string extraProxyIp in extraProxyIps)
+                    {
+                        DeleteProxyRoute(extraProxyIp);
+                    }
                     eventLog.WriteEntry($"deleted route to proxy");
                 }
                 catch (Exception e)
@@ -514,6 +529,7 @@ namespace OutlineService
                     eventLog.WriteEntry($"failed to delete route to proxy: {e.Message}", EventLogEntryType.Error);
                 }
                 this.proxyIp = null;
+                this.extraProxyIps = new string[0];
             }
 
             try
@@ -975,6 +991,10 @@ namespace OutlineService
             try
             {
                 AddOrUpdateProxyRoute(proxyIp, gatewayIp, gatewayInterfaceIndex);
+                foreach (string extraProxyIp in extraProxyIps)
+                {
+                    AddOrUpdateProxyRoute(extraProxyIp, gatewayIp, gatewayInterfaceIndex);
+                }
                 eventLog.WriteEntry($"updated route to proxy");
             }
             catch (Exception e)
@@ -0,0 +1,127 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// exportAccessKeyJSON is the input of [MethodExportAccessKey].
+type exportAccessKeyJSON struct {
+	// Transport is the transport config to export.
+	Transport json.RawMessage `json:"transport"`
+
+	// Name is the name of the server, exported as the fragment of the access key.
+	Name string `json:"name,omitempty"`
+}
+
+// exportAccessKey converts the transport config of the JSON string input into a SIP002 ss://
+// access key, so that it can be shared with other users.
+func exportAccessKey(input string) (string, error) {
+	var req exportAccessKeyJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid export access key request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	conf, err := parseConfigFromJSON(string(req.Transport))
+	if err != nil {
+		return "", err
+	}
+	return conf.accessKey(req.Name)
+}
+
+// accessKey returns the canonical SIP002 ss:// access key of the Shadowsocks server of the
+// config, named name:
+//
+//	ss://base64url(method:password)@host:port/?prefix=...#name
+//
+// The userinfo of the 2022 ciphers is percent-encoded instead of base64-encoded, as SIP002
+// requires. A prefix, either legacy or from a "prefix" obfs layer, is exported as the "prefix"
+// query parameter understood by the Outline apps. Other obfs layers, and rotating prefixes, have
+// no ss:// representation.
+//
+// The client-side options, like routing or DNS, are not part of the access key.
+func (conf *configJSON) accessKey(name string) (string, error) {
+	if conf.Type != "" && conf.Type != transportTypeShadowsocks {
+		return "", newIllegalConfigErrorWithDetails("transport cannot be exported to an access key",
+			"$type", conf.Type, transportTypeShadowsocks, nil)
+	}
+	if err := validateConfig(conf.Host, int(conf.Port), conf.Method, conf.Password); err != nil {
+		return "", err
+	}
+	obfs, err := conf.obfsConfig()
+	if err != nil {
+		return "", err
+	}
+
+	method, _ := canonicalCipherName(conf.Method)
+
+	var key strings.Builder
+	key.WriteString("ss://")
+	if strings.HasPrefix(method, "2022-") {
+		key.WriteString(url.UserPassword(method, conf.Password).String())
+	} else {
+		key.WriteString(base64.RawURLEncoding.EncodeToString([]byte(method + ":" + conf.Password)))
+	}
+	key.WriteByte('@')
+	key.WriteString(net.JoinHostPort(conf.Host, strconv.Itoa(int(conf.Port))))
+	key.WriteByte('/')
+
+	if obfs != nil {
+		if obfs.Type != obfsTypePrefix || len(obfs.Prefixes) > 0 {
+			return "", newIllegalConfigErrorWithDetails("obfs cannot be exported to an access key",
+				"obfs.$type", obfs.Type, fmt.Sprintf("%q or no obfs", obfsTypePrefix), nil)
+		}
+		prefix, err := ParseConfigPrefixFromString(obfs.Prefix)
+		if err != nil {
+			return "", err
+		}
+		key.WriteString("?prefix=")
+		key.WriteString(escapePrefix(prefix))
+	}
+	if name != "" {
+		key.WriteByte('#')
+		key.WriteString(url.PathEscape(name))
+	}
+	return key.String(), nil
+}
+
+// escapePrefix percent-encodes every byte of prefix that is not unreserved in URLs. Unlike
+// [url.QueryEscape], spaces are encoded as "%20", which all the parsers decode the same way.
+func escapePrefix(prefix []byte) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for _, c := range prefix {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAccessKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "basic",
+			input: `{"transport":{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234"}}`,
+			want:  "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTphYmNkMTIzNA@192.0.2.1:8080/",
+		},
+		{
+			name:  "IPv6 with name",
+			input: `{"transport":{"host":"2001:db8::1","port":443,"method":"aes-128-gcm","password":"pw"},"name":"My server"}`,
+			want:  "ss://YWVzLTEyOC1nY206cHc@[2001:db8::1]:443/#My%20server",
+		},
+		{
+			name:  "legacy prefix",
+			input: `{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","prefix":"\u0016\u0003\u0001 a"}}`,
+			want:  "ss://YWVzLTEyOC1nY206cHc@example.com:443/?prefix=%16%03%01%20a",
+		},
+		{
+			name:  "prefix obfs",
+			input: `{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","obfs":{"$type":"prefix","prefix":"POST /"}}}`,
+			want:  "ss://YWVzLTEyOC1nY206cHc@example.com:443/?prefix=POST%20%2F",
+		},
+		{
+			name:  "2022 cipher",
+			input: `{"transport":{"host":"example.com","port":443,"method":"2022-blake3-aes-128-gcm","password":"YctPZ6U7xPPcU+gp3u+0tx=="}}`,
+			want:  "ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU+gp3u+0tx==@example.com:443/",
+		},
+		{
+			name:  "legacy cipher name",
+			input: `{"transport":{"host":"example.com","port":443,"method":"AEAD_AES_128_GCM","password":"pw"}}`,
+			want:  "ss://YWVzLTEyOC1nY206cHc@example.com:443/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exportAccessKey(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			_, err = url.Parse(got)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestExportAccessKey_Errors(t *testing.T) {
+	for _, input := range []string{
+		`not json`,
+		`{"transport":{"port":443,"method":"aes-128-gcm","password":"pw"}}`,
+		`{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","obfs":{"$type":"padding","length":8}}}`,
+		`{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","obfs":{"$type":"prefix","prefixes":["a","b"]}}}`,
+		`{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","prefix":"a","obfs":{"$type":"prefix","prefix":"b"}}}`,
+	} {
+		_, err := exportAccessKey(input)
+		require.Error(t, err, input)
+	}
+}
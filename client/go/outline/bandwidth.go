@@ -0,0 +1,172 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+const (
+	defaultBandwidthTestTimeout  = 15 * time.Second
+	defaultBandwidthTestMaxBytes = 10 * 1024 * 1024
+)
+
+// bandwidthTestJSON is the input of [MethodTestBandwidth].
+type bandwidthTestJSON struct {
+	// Transport is the transport config to test.
+	Transport json.RawMessage `json:"transport"`
+
+	// URL of the resource to download through the transport.
+	URL string `json:"url"`
+
+	// MaxBytes is the maximum number of bytes to download. Defaults to 10MB.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+
+	// TimeoutSeconds limits the duration of the whole test. Defaults to 15 seconds.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// bandwidthTestResultJSON is the output of [MethodTestBandwidth].
+type bandwidthTestResultJSON struct {
+	Bytes      int64   `json:"bytes"`
+	Mbps       float64 `json:"mbps"`
+	TTFBMs     int64   `json:"ttfbMs"`
+	DurationMs int64   `json:"durationMs"`
+}
+
+// testBandwidth downloads the URL in the JSON string input through the given transport, and
+// returns a JSON string of bandwidthTestResultJSON.
+func testBandwidth(ctx context.Context, input string) (string, error) {
+	var req bandwidthTestJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid bandwidth test request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if req.URL == "" {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "bandwidth test URL is required",
+		}
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultBandwidthTestMaxBytes
+	}
+	timeout := defaultBandwidthTestTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	result := NewClient(string(req.Transport))
+	if result.Error != nil {
+		return "", result.Error
+	}
+	res, err := measureBandwidth(ctx, result.Client, req.URL, maxBytes, timeout)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(res)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal bandwidth test result",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// measureBandwidth downloads up to maxBytes from url through dialer, and measures the
+// time-to-first-byte and the download throughput.
+//
+// Reaching the timeout after the first byte is not an error, the throughput is computed from
+// what has been downloaded so far.
+func measureBandwidth(
+	ctx context.Context, dialer transport.StreamDialer, url string, maxBytes int64, timeout time.Duration,
+) (*bandwidthTestResultJSON, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialStream(ctx, addr)
+		},
+		DisableCompression: true,
+		DisableKeepAlives:  true,
+	}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "bandwidth test URL is not valid",
+			Details: platerrors.ErrorDetails{"url": url},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerUnreachable,
+			Message: "failed to request the bandwidth test URL",
+			Details: platerrors.ErrorDetails{"url": url},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerReadFailed,
+			Message: "non-successful HTTP status",
+			Details: platerrors.ErrorDetails{"url": url, "status": resp.Status},
+		}
+	}
+
+	firstByte := time.Now()
+	n, err := io.CopyN(io.Discard, resp.Body, maxBytes)
+	elapsed := time.Since(firstByte)
+	if err != nil && err != io.EOF && ctx.Err() == nil {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerReadFailed,
+			Message: "failed to download the bandwidth test URL",
+			Details: platerrors.ErrorDetails{"url": url},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	res := &bandwidthTestResultJSON{
+		Bytes:      n,
+		TTFBMs:     firstByte.Sub(start).Milliseconds(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if elapsed > 0 {
+		res.Mbps = float64(n*8) / elapsed.Seconds() / 1e6
+	}
+	return res, nil
+}
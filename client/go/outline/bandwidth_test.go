@@ -0,0 +1,61 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureBandwidth(t *testing.T) {
+	payload := make([]byte, 64*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	res, err := measureBandwidth(context.Background(), &transport.TCPDialer{}, server.URL, 1024*1024, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(payload)), res.Bytes)
+	require.GreaterOrEqual(t, res.DurationMs, res.TTFBMs)
+
+	res, err = measureBandwidth(context.Background(), &transport.TCPDialer{}, server.URL, 1024, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, int64(1024), res.Bytes)
+}
+
+func TestMeasureBandwidth_HTTPStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var perr platerrors.PlatformError
+	_, err := measureBandwidth(context.Background(), &transport.TCPDialer{}, server.URL, 1024, 5*time.Second)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ProxyServerReadFailed, perr.Code)
+}
+
+func TestTestBandwidth_MissingURL(t *testing.T) {
+	_, err := testBandwidth(context.Background(), `{"transport":{"host":"192.0.2.1"}}`)
+	require.Error(t, err)
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// Statuses of [MethodDetectCaptivePortal].
+const (
+	captivePortalOpen    = "open"
+	captivePortalCaptive = "captive"
+	captivePortalOffline = "offline"
+)
+
+const (
+	defaultCaptivePortalTimeout = 5 * time.Second
+	// maxCaptivePortalBody is how much of the probe responses is read.
+	maxCaptivePortalBody = 64 * 1024
+)
+
+// captivePortalProbeJSON is a request whose response is known, unless a captive portal
+// intercepts it.
+type captivePortalProbeJSON struct {
+	// URL is the plain HTTP URL of the probe.
+	URL string `json:"url"`
+
+	// ExpectedStatus is the status code of the response. Defaults to 200.
+	ExpectedStatus int `json:"expectedStatus,omitempty"`
+
+	// ExpectedBody is a string the response body contains, if not empty.
+	ExpectedBody string `json:"expectedBody,omitempty"`
+}
+
+// defaultCaptivePortalProbes are the probes of the operating systems.
+var defaultCaptivePortalProbes = []captivePortalProbeJSON{
+	{URL: "http://connectivitycheck.gstatic.com/generate_204", ExpectedStatus: http.StatusNoContent},
+	{URL: "http://captive.apple.com/hotspot-detect.html", ExpectedBody: "Success"},
+	{URL: "http://www.msftconnecttest.com/connecttest.txt", ExpectedBody: "Microsoft Connect Test"},
+}
+
+// captivePortalRequestJSON is the input of [MethodDetectCaptivePortal].
+type captivePortalRequestJSON struct {
+	// Probes replace the default probes, e.g. for networks blocking them.
+	Probes []captivePortalProbeJSON `json:"probes,omitempty"`
+
+	// TimeoutMs is the timeout of each probe. Defaults to 5 seconds.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+}
+
+// captivePortalResultJSON is the output of [MethodDetectCaptivePortal].
+type captivePortalResultJSON struct {
+	// Status is "open" if a probe got its expected response, "captive" if a probe got another
+	// response, and "offline" if no probe got a response.
+	Status string `json:"status"`
+
+	// PortalURL is where the captive portal redirected a probe to, if it did. The app can open it
+	// for the user to authenticate.
+	PortalURL string `json:"portalUrl,omitempty"`
+
+	Probes []captivePortalProbeResultJSON `json:"probes"`
+}
+
+// captivePortalProbeResultJSON is the result of a probe of [MethodDetectCaptivePortal].
+type captivePortalProbeResultJSON struct {
+	URL        string                    `json:"url"`
+	StatusCode int                       `json:"statusCode,omitempty"`
+	Captive    bool                      `json:"captive"`
+	DurationMs int64                     `json:"durationMs"`
+	Error      *platerrors.PlatformError `json:"error,omitempty"`
+
+	location string
+}
+
+// detectCaptivePortal runs the probes of the request in input concurrently, outside of the VPN,
+// and returns a JSON string of captivePortalResultJSON.
+func detectCaptivePortal(ctx context.Context, input string) (string, error) {
+	var req captivePortalRequestJSON
+	if input != "" {
+		if err := json.Unmarshal([]byte(input), &req); err != nil {
+			return "", platerrors.PlatformError{
+				Code:    platerrors.IllegalConfig,
+				Message: "invalid captive portal detection request",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	probes := req.Probes
+	if len(probes) == 0 {
+		probes = defaultCaptivePortalProbes
+	}
+	for _, p := range probes {
+		if !strings.HasPrefix(p.URL, "http://") {
+			return "", newIllegalConfigErrorWithDetails("captive portal probe URL is not valid",
+				"url", p.URL, "a plain http:// URL", nil)
+		}
+	}
+	timeout := defaultCaptivePortalTimeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+
+	result := runCaptivePortalProbes(ctx, probes, timeout)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal captive portal detection result",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+func runCaptivePortalProbes(ctx context.Context, probes []captivePortalProbeJSON, timeout time.Duration) captivePortalResultJSON {
+	dialer := newDirectDialer()
+	transport := &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// The redirects of the captive portals are part of the response.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	result := captivePortalResultJSON{Status: captivePortalOffline, Probes: make([]captivePortalProbeResultJSON, len(probes))}
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		i, p := i, p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.Probes[i] = runCaptivePortalProbe(ctx, client, p)
+		}()
+	}
+	wg.Wait()
+
+	for _, p := range result.Probes {
+		switch {
+		case p.Captive:
+			result.Status = captivePortalCaptive
+			if result.PortalURL == "" {
+				result.PortalURL = p.location
+			}
+		case p.Error == nil && result.Status == captivePortalOffline:
+			result.Status = captivePortalOpen
+		}
+	}
+	return result
+}
+
+func runCaptivePortalProbe(ctx context.Context, client *http.Client, p captivePortalProbeJSON) captivePortalProbeResultJSON {
+	res := captivePortalProbeResultJSON{URL: p.URL}
+	start := time.Now()
+	defer func() { res.DurationMs = time.Since(start).Milliseconds() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		res.Error = platerrors.ToPlatformError(err)
+		return res
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = platerrors.ToPlatformError(newFetchError(p.URL, err))
+		return res
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCaptivePortalBody))
+	if err != nil {
+		res.Error = platerrors.ToPlatformError(err)
+		return res
+	}
+
+	res.StatusCode = resp.StatusCode
+	expectedStatus := p.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+	res.Captive = resp.StatusCode != expectedStatus || !strings.Contains(string(body), p.ExpectedBody)
+	if res.Captive {
+		if location, err := resp.Location(); err == nil {
+			res.location = location.String()
+		}
+	}
+	return res
+}
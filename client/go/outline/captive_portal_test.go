@@ -0,0 +1,121 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func runTestCaptivePortalDetection(t *testing.T, req captivePortalRequestJSON) captivePortalResultJSON {
+	input, err := json.Marshal(req)
+	require.NoError(t, err)
+	out, err := detectCaptivePortal(context.Background(), string(input))
+	require.NoError(t, err)
+	var result captivePortalResultJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	return result
+}
+
+func TestDetectCaptivePortal(t *testing.T) {
+	open := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate_204" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("<HTML><BODY>Success</BODY></HTML>"))
+	}))
+	defer open.Close()
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.Write([]byte("Please log in"))
+			return
+		}
+		http.Redirect(w, r, "http://portal.example/login", http.StatusFound)
+	}))
+	defer portal.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	tests := []struct {
+		name      string
+		probes    []captivePortalProbeJSON
+		status    string
+		portalURL string
+	}{
+		{
+			name: "open",
+			probes: []captivePortalProbeJSON{
+				{URL: open.URL + "/generate_204", ExpectedStatus: http.StatusNoContent},
+				{URL: open.URL + "/hotspot-detect.html", ExpectedBody: "Success"},
+				{URL: offline.URL},
+			},
+			status: captivePortalOpen,
+		},
+		{
+			name: "redirect",
+			probes: []captivePortalProbeJSON{
+				{URL: open.URL + "/generate_204", ExpectedStatus: http.StatusNoContent},
+				{URL: portal.URL + "/generate_204", ExpectedStatus: http.StatusNoContent},
+			},
+			status:    captivePortalCaptive,
+			portalURL: "http://portal.example/login",
+		},
+		{
+			name:   "unexpected body",
+			probes: []captivePortalProbeJSON{{URL: portal.URL + "/login", ExpectedBody: "Success"}},
+			status: captivePortalCaptive,
+		},
+		{
+			name:   "offline",
+			probes: []captivePortalProbeJSON{{URL: offline.URL}},
+			status: captivePortalOffline,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := runTestCaptivePortalDetection(t, captivePortalRequestJSON{Probes: tt.probes, TimeoutMs: 2000})
+			require.Equal(t, tt.status, result.Status)
+			require.Equal(t, tt.portalURL, result.PortalURL)
+			require.Len(t, result.Probes, len(tt.probes))
+			for i, p := range result.Probes {
+				require.Equal(t, tt.probes[i].URL, p.URL)
+			}
+		})
+	}
+}
+
+func TestDetectCaptivePortal_InvalidRequest(t *testing.T) {
+	_, err := detectCaptivePortal(context.Background(), `{"probes":[{"url":"https://example.com"}]}`)
+	perr, ok := err.(platerrors.PlatformError)
+	require.True(t, ok)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+
+	_, err = detectCaptivePortal(context.Background(), `not json`)
+	require.Error(t, err)
+}
+
+func TestDetectCaptivePortal_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := detectCaptivePortal(ctx, `{"probes":[{"url":"http://127.0.0.1:1"}]}`)
+	require.ErrorIs(t, err, context.Canceled)
+}
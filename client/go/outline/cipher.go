@@ -0,0 +1,75 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ss2022"
+)
+
+// supportedCiphers are the Shadowsocks ciphers supported by the transport, by their SIP002
+// names.
+var supportedCiphers = []string{
+	"chacha20-ietf-poly1305",
+	"aes-256-gcm",
+	"aes-192-gcm",
+	"aes-128-gcm",
+	ss2022.AES128GCM,
+	ss2022.AES256GCM,
+	ss2022.ChaCha20Poly1305,
+}
+
+// cipherAliases maps the alternative names accepted for the supported ciphers, lowercased, to
+// their SIP002 names.
+var cipherAliases = map[string]string{
+	"aead_chacha20_poly1305": "chacha20-ietf-poly1305",
+	"aead_aes_256_gcm":       "aes-256-gcm",
+	"aead_aes_192_gcm":       "aes-192-gcm",
+	"aead_aes_128_gcm":       "aes-128-gcm",
+}
+
+// canonicalCipherName returns the SIP002 name of cipher, ignoring the case, or false if the
+// cipher is not supported.
+func canonicalCipherName(cipher string) (string, bool) {
+	name := strings.ToLower(cipher)
+	if alias, ok := cipherAliases[name]; ok {
+		return alias, true
+	}
+	for _, c := range supportedCiphers {
+		if c == name {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// validateCipher returns an IllegalConfig [platerrors.PlatformError] listing the supported
+// ciphers if cipher is not one of them.
+func validateCipher(cipher string) error {
+	if _, ok := canonicalCipherName(cipher); !ok {
+		return newIllegalConfigErrorWithDetails("cipher method is not supported", "cipher", cipher,
+			strings.Join(supportedCiphers, "|"), nil)
+	}
+	return nil
+}
+
+// getSupportedCiphers returns the JSON array of the supported ciphers, so that the app can
+// check access keys before connecting.
+func getSupportedCiphers() string {
+	out, _ := json.Marshal(supportedCiphers)
+	return string(out)
+}
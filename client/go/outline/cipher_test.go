@@ -0,0 +1,54 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_canonicalCipherName(t *testing.T) {
+	tests := []struct {
+		cipher string
+		want   string
+		wantOK bool
+	}{
+		{cipher: "chacha20-ietf-poly1305", want: "chacha20-ietf-poly1305", wantOK: true},
+		{cipher: "AES-256-GCM", want: "aes-256-gcm", wantOK: true},
+		{cipher: "AEAD_AES_128_GCM", want: "aes-128-gcm", wantOK: true},
+		{cipher: "2022-BLAKE3-AES-128-GCM", want: "2022-blake3-aes-128-gcm", wantOK: true},
+		{cipher: "aes-256-cfb"},
+		{cipher: "rc4-md5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cipher, func(t *testing.T) {
+			got, ok := canonicalCipherName(tt.cipher)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_validateCipher(t *testing.T) {
+	require.NoError(t, validateCipher("aes-192-gcm"))
+
+	err := validateCipher("aes-256-cfb")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cipher method is not supported")
+
+	require.JSONEq(t, `["chacha20-ietf-poly1305","aes-256-gcm","aes-192-gcm","aes-128-gcm",`+
+		`"2022-blake3-aes-128-gcm","2022-blake3-aes-256-gcm","2022-blake3-chacha20-poly1305"]`, getSupportedCiphers())
+}
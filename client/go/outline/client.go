@@ -15,13 +15,22 @@
 package outline
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/icmpecho"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/quic"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ss2022"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/uot"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
-	"github.com/eycorsican/go-tun2socks/common/log"
 )
 
 // Client provides a transparent container for [transport.StreamDialer] and [transport.PacketListener]
@@ -30,6 +39,45 @@ import (
 type Client struct {
 	transport.StreamDialer
 	transport.PacketListener
+
+	// UDPFallback relays the UDP traffic over TCP if the config enables it, for when the
+	// PacketListener cannot reach the server. It is nil otherwise.
+	UDPFallback transport.PacketListener
+
+	// BlockQUIC is whether the tunnel rejects QUIC (UDP port 443) traffic.
+	BlockQUIC bool
+
+	// ICMPEcho is how the tunnel answers the pings.
+	ICMPEcho icmpecho.Mode
+
+	// UDPIdleTimeout is how long the tunnel keeps a UDP session without outgoing traffic.
+	UDPIdleTimeout time.Duration
+
+	// UDPMaxSessions is the size of the UDP NAT table of the tunnel, or 0 for the default size.
+	UDPMaxSessions int
+
+	// reconnect is how the VPN health monitor retries once the server is unreachable.
+	reconnect reconnectPolicy
+
+	// DNSForwarder answers the DNS queries of the tunnel, if the config has a "dns" section.
+	// The tunnel relays the DNS queries like any other traffic if it is nil.
+	DNSForwarder *dnsintercept.Forwarder
+
+	// description is the transport graph, for [MethodDescribeTransport].
+	description *transportDescriptionJSON
+
+	// vpnRoutingErr is why the routing rules cannot apply to a VPN, see [Client.CheckVPNRouting].
+	vpnRoutingErr error
+
+	healthMu sync.Mutex
+	health   *healthMonitor
+}
+
+// CheckVPNRouting returns an IllegalConfig error if the routing rules of the config cannot apply to
+// the traffic of a VPN, i.e. if they have domains. The VPNs must call it before relaying the
+// traffic through c.
+func (c *Client) CheckVPNRouting() error {
+	return c.vpnRoutingErr
 }
 
 // NewClientResult represents the result of [NewClientAndReturnError].
@@ -49,29 +97,157 @@ func NewClient(transportConfig string) *NewClientResult {
 	}
 }
 
+// NewClientWithoutSubprocesses is like [NewClient], but rejects the transports that run
+// processes, like the managed pluggable transports. It is used for the configs sent by less
+// privileged processes, e.g. through the control channel of a service.
+func NewClientWithoutSubprocesses(transportConfig string) *NewClientResult {
+	client, err := newClient(transportConfig, TransportDialers{TCP: net.Dialer{KeepAlive: -1}, NoSubprocesses: true})
+	return &NewClientResult{
+		Client: client,
+		Error:  platerrors.ToPlatformError(err),
+	}
+}
+
 func newClientWithBaseDialers(transportConfig string, tcpDialer, udpDialer net.Dialer) (*Client, error) {
+	return newClient(transportConfig, TransportDialers{TCP: tcpDialer, UDP: udpDialer})
+}
+
+// newClient creates the client of transportConfig from the base dialers, and the options, of
+// dialers.
+func newClient(transportConfig string, dialers TransportDialers) (*Client, error) {
+	tcpDialer, udpDialer := dialers.TCP, dialers.UDP
 	conf, err := parseConfigFromJSON(transportConfig)
 	if err != nil {
 		return nil, err
 	}
-	prefixBytes, err := ParseConfigPrefixFromString(conf.Prefix)
+	if warnings, err := conf.warnings(transportConfig); err == nil {
+		for _, w := range warnings {
+			logger.Warn("transport config warning", "code", w.Code, "path", w.Path, "line", w.Line, "column", w.Column)
+		}
+	}
+	parse, err := lookupTransport(conf.Type)
+	if err != nil {
+		return nil, err
+	}
+	router, err := conf.router()
+	if err != nil {
+		return nil, err
+	}
+	quicPolicy, err := quic.ParsePolicy(conf.QUIC)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("QUIC policy is not valid",
+			"quic", conf.QUIC, `"allow" or "block"`, err)
+	}
+	icmpEcho, err := icmpecho.ParseMode(conf.ICMPEcho)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("ICMP echo mode is not valid",
+			"icmpEcho", conf.ICMPEcho, `"off", "reply" or "probe"`, err)
+	}
+	timeouts, err := conf.timeouts()
+	if err != nil {
+		return nil, err
+	}
+	reconnect, err := conf.reconnectPolicy()
+	if err != nil {
+		return nil, err
+	}
+	timeouts.applyToDialers(&tcpDialer, &udpDialer)
+	withSocketProtector(&tcpDialer)
+	withSocketProtector(&udpDialer)
+	// Only the sockets to the proxy server are tuned, not the ones routed directly.
+	proxyTCPDialer := tcpDialer
+	if conf.ProxyTCPTuning != nil {
+		if err := conf.ProxyTCPTuning.validate(); err != nil {
+			return nil, err
+		}
+		conf.ProxyTCPTuning.applyToDialer(&proxyTCPDialer)
+	}
+	if conf.UDPMaxSessions < 0 {
+		return nil, newIllegalConfigErrorWithDetails("UDP max sessions is not valid",
+			"udpMaxSessions", conf.UDPMaxSessions, "a positive number", nil)
+	}
+	limiter, err := conf.streamLimiter()
+	if err != nil {
+		return nil, err
+	}
+	quota, err := conf.quota()
 	if err != nil {
 		return nil, err
 	}
 
-	return newShadowsocksClient(conf.Host, int(conf.Port), conf.Method, conf.Password, prefixBytes, tcpDialer, udpDialer)
+	sd, pl, err := parse(json.RawMessage(transportConfig),
+		TransportDialers{TCP: proxyTCPDialer, UDP: udpDialer, NoSubprocesses: dialers.NoSubprocesses})
+	if err != nil {
+		return nil, err
+	}
+	if quota != nil {
+		// Only the traffic through the proxy counts, not the one routed directly.
+		sd, pl = quota.StreamDialer(sd), quota.PacketListener(pl)
+	}
+	client := &Client{StreamDialer: timeouts.withHandshakeTimeout(sd), PacketListener: pl, UDPIdleTimeout: timeouts.udpIdle,
+		UDPMaxSessions: conf.UDPMaxSessions, reconnect: reconnect}
+	directPL := &transport.UDPListener{ListenConfig: net.ListenConfig{Control: udpDialer.Control}}
+	if conf.UDPOverTCP {
+		client.UDPFallback = routing.NewPacketListener(router, uot.NewPacketListener(client.StreamDialer), directPL)
+	}
+	client.StreamDialer = limiter.streamDialer(
+		routing.NewStreamDialer(router, client.StreamDialer, &transport.TCPDialer{Dialer: tcpDialer}))
+	client.PacketListener = routing.NewPacketListener(router, client.PacketListener, directPL)
+	client.BlockQUIC = quicPolicy == quic.PolicyBlock
+	client.ICMPEcho = icmpEcho
+	if client.DNSForwarder, err = conf.dnsForwarder(client.StreamDialer, client.PacketListener, tcpDialer, udpDialer); err != nil {
+		return nil, err
+	}
+	client.description = conf.describe()
+	client.vpnRoutingErr = conf.vpnRoutingError()
+	return client, nil
 }
 
+// newShadowsocksClient creates a Shadowsocks [Client]. The host of the proxy server is resolved
+// according to res. If firstHop is not nil, the TCP connections to the proxy server are dialed
+// through it instead, e.g. an outbound HTTP or SOCKS5 proxy. udpObfs, if not nil, randomizes the
+// UDP packets. preDial and earlyData, if not nil, speed up the TCP connections to the proxy server.
 func newShadowsocksClient(
-	host string, port int, cipherName, password string, prefix []byte, tcpDialer, udpDialer net.Dialer,
+	host string, port int, cipherName, password string, obfs *obfsConfigJSON, udpObfs *udpObfsConfigJSON,
+	preDial *preDialConfigJSON, earlyData *earlyDataConfigJSON, res hostResolution, firstHop transport.StreamDialer, tcpDialer, udpDialer net.Dialer,
 ) (*Client, error) {
 	if err := validateConfig(host, port, cipherName, password); err != nil {
 		return nil, err
 	}
+	if udpObfs != nil {
+		if err := udpObfs.validate(); err != nil {
+			return nil, err
+		}
+		if udpObfs.MaxPadding > 0 && !ss2022.IsCipher(cipherName) {
+			return nil, newIllegalConfigErrorWithDetails("UDP padding requires a Shadowsocks 2022 cipher",
+				"udpObfs.maxPadding", udpObfs.MaxPadding, "0 with this cipher", nil)
+		}
+	}
+	if preDial != nil {
+		if err := preDial.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if earlyData != nil {
+		if err := earlyData.validate(); err != nil {
+			return nil, err
+		}
+		if earlyData.TCPFastOpen {
+			withTCPFastOpen(&tcpDialer)
+		}
+	}
 
-	// TODO: consider using net.LookupIP to get a list of IPs, and add logic for optimal selection.
 	proxyAddress := net.JoinHostPort(host, fmt.Sprint(port))
 
+	tcpEndpoint, udpEndpoint := newProxyEndpoints(res, proxyAddress, tcpDialer, udpDialer)
+	if firstHop != nil {
+		tcpEndpoint = &transport.StreamDialerEndpoint{Dialer: firstHop, Address: proxyAddress}
+	}
+	tcpEndpoint = preDial.streamEndpoint(tcpEndpoint)
+	if ss2022.IsCipher(cipherName) {
+		return newShadowsocks2022Client(cipherName, password, obfs, udpObfs, earlyData, tcpEndpoint, udpEndpoint)
+	}
+
 	cryptoKey, err := shadowsocks.NewEncryptionKey(cipherName, password)
 	if err != nil {
 		return nil, newIllegalConfigErrorWithDetails("cipher&password pair is not valid",
@@ -80,29 +256,72 @@ func newShadowsocksClient(
 
 	// We disable Keep-Alive as per https://datatracker.ietf.org/doc/html/rfc1122#page-101, which states that it should only be
 	// enabled in server applications. This prevents the device from unnecessarily waking up to send keep alives.
-	streamDialer, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: proxyAddress, Dialer: tcpDialer}, cryptoKey)
+	streamDialer, err := shadowsocks.NewStreamDialer(tcpEndpoint, cryptoKey)
 	if err != nil {
-		return nil, platerrors.PlatformError{
-			Code:    platerrors.SetupTrafficHandlerFailed,
-			Message: "failed to create TCP traffic handler",
-			Details: platerrors.ErrorDetails{"proxy-protocol": "shadowsocks", "handler": "tcp"},
-			Cause:   platerrors.ToPlatformError(err),
-		}
+		return nil, newTrafficHandlerError("tcp", err)
+	}
+	if wait := earlyData.clientDataWait(); wait > 0 {
+		streamDialer.ClientDataWait = wait
 	}
-	if len(prefix) > 0 {
-		log.Debugf("Using salt prefix: %s", string(prefix))
-		streamDialer.SaltGenerator = shadowsocks.NewPrefixSaltGenerator(prefix)
+	saltGenerator, err := newObfsSaltGenerator(obfs, cryptoKey.SaltSize())
+	if err != nil {
+		return nil, err
+	}
+	if saltGenerator != nil {
+		logger.Debug("using salt obfuscation", "type", obfs.Type)
+		streamDialer.SaltGenerator = saltGenerator
 	}
 
-	packetListener, err := shadowsocks.NewPacketListener(&transport.UDPEndpoint{Address: proxyAddress, Dialer: udpDialer}, cryptoKey)
+	packetListener, err := shadowsocks.NewPacketListener(udpEndpoint, cryptoKey)
 	if err != nil {
-		return nil, platerrors.PlatformError{
-			Code:    platerrors.SetupTrafficHandlerFailed,
-			Message: "failed to create UDP traffic handler",
-			Details: platerrors.ErrorDetails{"proxy-protocol": "shadowsocks", "handler": "udp"},
-			Cause:   platerrors.ToPlatformError(err),
-		}
+		return nil, newTrafficHandlerError("udp", err)
 	}
 
-	return &Client{StreamDialer: streamDialer, PacketListener: packetListener}, nil
+	return &Client{StreamDialer: streamDialer, PacketListener: udpObfs.packetListener(packetListener)}, nil
+}
+
+// newShadowsocks2022Client creates a Shadowsocks 2022 [Client] connecting to the endpoints.
+func newShadowsocks2022Client(
+	cipherName, password string, obfs *obfsConfigJSON, udpObfs *udpObfsConfigJSON, earlyData *earlyDataConfigJSON,
+	tcpEndpoint transport.StreamEndpoint, udpEndpoint transport.PacketEndpoint,
+) (*Client, error) {
+	key, err := ss2022.NewKey(cipherName, password)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("cipher&password pair is not valid",
+			"cipher|password", cipherName+"|"+password, "base64-encoded key of the cipher size", err)
+	}
+	streamDialer, err := ss2022.NewStreamDialer(tcpEndpoint, key)
+	if err != nil {
+		return nil, newTrafficHandlerError("tcp", err)
+	}
+	if wait := earlyData.clientDataWait(); wait > 0 {
+		streamDialer.ClientDataWait = wait
+	}
+	saltGenerator, err := newObfsSaltGenerator(obfs, key.SaltSize())
+	if err != nil {
+		return nil, err
+	}
+	if saltGenerator != nil {
+		logger.Debug("using salt obfuscation", "type", obfs.Type)
+		streamDialer.SaltGenerator = saltGenerator
+	}
+	packetListener, err := ss2022.NewPacketListener(udpEndpoint, key)
+	if err != nil {
+		return nil, newTrafficHandlerError("udp", err)
+	}
+	if udpObfs != nil && udpObfs.MaxPadding > 0 {
+		packetListener.Padding = udpObfs.padding
+	}
+	return &Client{StreamDialer: streamDialer, PacketListener: udpObfs.packetListener(packetListener)}, nil
+}
+
+// newTrafficHandlerError creates the error of a Shadowsocks traffic handler that could not be
+// created, handler being "tcp" or "udp".
+func newTrafficHandlerError(handler string, err error) platerrors.PlatformError {
+	return platerrors.PlatformError{
+		Code:    platerrors.SetupTrafficHandlerFailed,
+		Message: fmt.Sprintf("failed to create %s traffic handler", strings.ToUpper(handler)),
+		Details: platerrors.ErrorDetails{"proxy-protocol": "shadowsocks", "handler": handler},
+		Cause:   platerrors.ToPlatformError(err),
+	}
 }
@@ -14,7 +14,12 @@
 
 package outline
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
 
 func Test_NewClientFromJSON_Errors(t *testing.T) {
 	tests := []struct {
@@ -23,11 +28,11 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 	}{
 		{
 			name:  "missing host",
-			input: `{"port":12345,"method":"some-cipher","password":"abcd1234"}`,
+			input: `{"port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "missing port",
-			input: `{"host":"192.0.2.1","method":"some-cipher","password":"abcd1234"}`,
+			input: `{"host":"192.0.2.1","method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "missing method",
@@ -35,15 +40,15 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 		},
 		{
 			name:  "missing password",
-			input: `{"host":"192.0.2.1","port":12345,"method":"some-cipher"}`,
+			input: `{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305"}`,
 		},
 		{
 			name:  "empty host",
-			input: `{"host":"","port":12345,"method":"some-cipher","password":"abcd1234"}`,
+			input: `{"host":"","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "zero port",
-			input: `{"host":"192.0.2.1","port":0,"method":"some-cipher","password":"abcd1234"}`,
+			input: `{"host":"192.0.2.1","port":0,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "empty method",
@@ -51,19 +56,67 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 		},
 		{
 			name:  "empty password",
-			input: `{"host":"192.0.2.1","port":12345,"method":"some-cipher","password":""}`,
+			input: `{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":""}`,
 		},
 		{
 			name:  "port -1",
-			input: `{"host":"192.0.2.1","port":-1,"method":"some-cipher","password":"abcd1234"}`,
+			input: `{"host":"192.0.2.1","port":-1,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "port 65536",
-			input: `{"host":"192.0.2.1","port":65536,"method":"some-cipher","password":"abcd1234"}`,
+			input: `{"host":"192.0.2.1","port":65536,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
 		},
 		{
 			name:  "prefix out-of-range",
-			input: `{"host":"192.0.2.1","port":8080,"method":"some-cipher","password":"abcd1234","prefix":"\x1234"}`,
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","prefix":"\x1234"}`,
+		},
+		{
+			name:  "unsupported cipher",
+			input: `{"host":"192.0.2.1","port":8080,"method":"rc4-md5","password":"abcd1234"}`,
+		},
+		{
+			name:  "invalid Shadowsocks 2022 key",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-256-gcm","password":"abcd1234"}`,
+		},
+		{
+			name:  "invalid routing action",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","routing":{"rules":[{"action":"drop"}]}}`,
+		},
+		{
+			name:  "invalid routing CIDR",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","routing":{"rules":[{"action":"direct","cidrs":["10.0.0.0"]}]}}`,
+		},
+		{
+			name:  "invalid management host",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","routing":{"managementHosts":["https://manager.example.com/api"]}}`,
+		},
+		{
+			name:  "invalid QUIC policy",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","quic":"drop"}`,
+		},
+		{
+			name:  "invalid ICMP echo mode",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","icmpEcho":"forward"}`,
+		},
+		{
+			name:  "invalid DoH URL",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","dns":{"doh":"http://1.1.1.1/dns-query"}}`,
+		},
+		{
+			name:  "unsupported DNS resolver type",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","dns":{"resolvers":[{"$type":"dnscrypt","address":"1.1.1.1"}]}}`,
+		},
+		{
+			name:  "DNS rules without default resolvers",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","dns":{"rules":[{"domains":["corp.example"],"resolvers":[{"$type":"plain","address":"10.0.0.53:53"}],"direct":true}]}}`,
+		},
+		{
+			name:  "DNS rule without resolvers",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","dns":{"doh":"https://1.1.1.1/dns-query","rules":[{"domains":["corp.example"]}]}}`,
+		},
+		{
+			name:  "DoT resolver without address",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","dns":{"resolvers":[{"$type":"dot"}]}}`,
 		},
 	}
 	for _, tt := range tests {
@@ -76,3 +129,70 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 		})
 	}
 }
+
+func Test_NewClientFromJSON_Shadowsocks2022(t *testing.T) {
+	got := NewClient(`{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-128-gcm","password":"YctPZ6U7xPPcU+gp3u+0tx==","prefix":"POST "}`)
+	require.Nil(t, got.Error)
+	require.NotNil(t, got.Client.StreamDialer)
+	require.NotNil(t, got.Client.PacketListener)
+}
+
+func Test_NewClientFromJSON_DNS(t *testing.T) {
+	got := NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+		`"dns":{"resolvers":[{"$type":"dot","address":"1.1.1.1:853"},{"$type":"plain","address":"8.8.8.8:53"}],` +
+		`"rules":[{"domains":["corp.example"],"resolvers":[{"$type":"plain","address":"10.0.0.53:53"}],"direct":true}]}}`)
+	require.Nil(t, got.Error)
+	require.NotNil(t, got.Client.DNSForwarder)
+
+	got = NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`)
+	require.Nil(t, got.Error)
+	require.Nil(t, got.Client.DNSForwarder)
+}
+
+func Test_NewClientFromJSON_UDPOverTCP(t *testing.T) {
+	got := NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234","udpOverTcp":true}`)
+	require.Nil(t, got.Error)
+	require.NotNil(t, got.Client.UDPFallback)
+
+	got = NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`)
+	require.Nil(t, got.Error)
+	require.Nil(t, got.Client.UDPFallback)
+}
+
+func Test_NewClientFromJSON_VPNRouting(t *testing.T) {
+	got := NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+		`"routing":{"rules":[{"action":"direct","cidrs":["10.0.0.0/8"]},{"action":"direct","domains":["example.com"]}]}}`)
+	require.Nil(t, got.Error)
+	err := got.Client.CheckVPNRouting()
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+	require.Equal(t, "routing.rules[1].domains", perr.Details["field"])
+
+	got = NewClient(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+		`"routing":{"rules":[{"action":"direct","cidrs":["10.0.0.0/8"]}]}}`)
+	require.Nil(t, got.Error)
+	require.NoError(t, got.Client.CheckVPNRouting())
+}
+
+// FuzzNewClient checks that no transport config crashes the client, including the configs of the
+// other transport types, and that the errors are platform errors.
+func FuzzNewClient(f *testing.F) {
+	for _, seed := range []string{
+		`{"host":"example.com","port":443,"method":"chacha20-ietf-poly1305","password":"secret"}`,
+		`{"host":"example.com","port":443,"method":"aes-128-gcm","password":"secret","prefix":"POST ","udpOverTcp":true}`,
+		`{"$type":"multi","transports":[{"host":"a.example","port":1,"method":"aes-256-gcm","password":"x"}]}`,
+		`{"host":"192.0.2.1","port":8388,"method":"2022-blake3-aes-128-gcm","password":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		`{"$type":"shadowsocks","host":"example.com","port":65536}`,
+		`{"$type":"unknown"}`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, config string) {
+		result := NewClient(config)
+		if result.Error != nil {
+			require.Nil(t, result.Client)
+			require.NotEmpty(t, result.Error.Code)
+		}
+	})
+}
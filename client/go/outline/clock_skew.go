@@ -0,0 +1,101 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+const (
+	// maxClockSkew is the replay window of Shadowsocks 2022. Servers reject the handshakes of
+	// the clients whose clock is further off.
+	maxClockSkew = 30 * time.Second
+
+	clockSourceTimeout = 5 * time.Second
+)
+
+// clockSources are plain HTTP URLs whose responses carry the actual time in their Date header.
+// They don't use HTTPS, since a wrong clock fails the certificate validation.
+var clockSources = []string{
+	"http://connectivitycheck.gstatic.com/generate_204",
+	"http://captive.apple.com/hotspot-detect.html",
+}
+
+// measureClockSkew returns how far ahead of the actual time the device clock is, according to
+// the first of the clockSources to answer outside of the VPN.
+func measureClockSkew(ctx context.Context) (time.Duration, error) {
+	dialer := newDirectDialer()
+	transport := &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Timeout:       clockSourceTimeout,
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	var errs error
+	for _, url := range clockSources {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		resp.Body.Close()
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid Date header from %s: %w", url, err))
+			continue
+		}
+		// The server read its clock about halfway through the round trip.
+		rtt := time.Since(start)
+		return start.Add(rtt / 2).Sub(date), nil
+	}
+	return 0, errs
+}
+
+// withClockSkewCheck returns a DeviceClockSkewed error instead of err if err is a handshake
+// failure that a wrong device clock explains, and the clock is wrong. It returns err otherwise.
+func withClockSkewCheck(ctx context.Context, err error) error {
+	perr := platerrors.ToPlatformError(err)
+	if perr == nil || (perr.Code != platerrors.Unauthenticated && perr.Code != platerrors.ProxyServerReadFailed) {
+		return err
+	}
+	skew, skewErr := measureClockSkew(ctx)
+	if skewErr != nil {
+		logger.Debug("failed to measure the clock skew", "err", skewErr)
+		return err
+	}
+	if skew <= maxClockSkew && skew >= -maxClockSkew {
+		return err
+	}
+	logger.Warn("the device clock is wrong", "skew", skew)
+	return platerrors.PlatformError{
+		Code:    platerrors.DeviceClockSkewed,
+		Message: fmt.Sprintf("device clock is wrong by %d minutes", int64(skew.Abs().Round(time.Minute)/time.Minute)),
+		Details: platerrors.ErrorDetails{"skewSeconds": int64(skew.Round(time.Second) / time.Second)},
+		Cause:   perr,
+	}.WithHint(platerrors.HintFixDeviceClock)
+}
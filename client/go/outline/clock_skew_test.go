@@ -0,0 +1,84 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+// setTestClockSource makes the clock sources a server whose clock is offset from the local one,
+// and returns the number of requests it got.
+func setTestClockSource(t *testing.T, offset time.Duration) *atomic.Int32 {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	saved := clockSources
+	clockSources = []string{"http://127.0.0.1:1", server.URL}
+	t.Cleanup(func() { clockSources = saved })
+	return &requests
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	setTestClockSource(t, -10*time.Minute)
+	skew, err := measureClockSkew(context.Background())
+	require.NoError(t, err)
+	require.InDelta(t, (10 * time.Minute).Seconds(), skew.Seconds(), 2)
+
+	clockSources = []string{"http://127.0.0.1:1"}
+	_, err = measureClockSkew(context.Background())
+	require.Error(t, err)
+}
+
+func TestWithClockSkewCheck(t *testing.T) {
+	unauthenticated := platerrors.PlatformError{Code: platerrors.Unauthenticated, Message: "closed"}
+
+	requests := setTestClockSource(t, 10*time.Minute)
+	err := withClockSkewCheck(context.Background(), unauthenticated)
+	perr, ok := err.(platerrors.PlatformError)
+	require.True(t, ok)
+	require.Equal(t, platerrors.DeviceClockSkewed, perr.Code)
+	require.Equal(t, "device clock is wrong by 10 minutes", perr.Message)
+	require.InDelta(t, -600, perr.Details["skewSeconds"], 2)
+	require.Equal(t, platerrors.HintFixDeviceClock, perr.Details[platerrors.HintDetailsKey])
+	require.Equal(t, platerrors.Unauthenticated, perr.Cause.Code)
+
+	// Other failures don't check the clock.
+	unreachable := platerrors.PlatformError{Code: platerrors.ProxyServerUnreachable, Message: "unreachable"}
+	requests.Store(0)
+	require.Equal(t, unreachable, withClockSkewCheck(context.Background(), unreachable))
+	require.Nil(t, withClockSkewCheck(context.Background(), nil))
+	other := errors.New("other")
+	require.Equal(t, other, withClockSkewCheck(context.Background(), other))
+	require.Zero(t, requests.Load())
+}
+
+func TestWithClockSkewCheck_ClockIsRight(t *testing.T) {
+	setTestClockSource(t, 5*time.Second)
+	unauthenticated := platerrors.PlatformError{Code: platerrors.Unauthenticated, Message: "closed"}
+	require.Equal(t, unauthenticated, withClockSkewCheck(context.Background(), unauthenticated))
+}
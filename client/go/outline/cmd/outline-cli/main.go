@@ -0,0 +1,270 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command outline-cli connects to an Outline server from a terminal, e.g. on a headless Linux box
+// or to test a transport in CI. It runs the same Go client as the apps, through
+// [outline.InvokeMethod].
+//
+// The server is given as an argument, either a ss:// access key, a ssconf:// dynamic access key
+// or a transport config, or with -config as a file containing one of those.
+//
+// In the default "socks" mode, it starts a local SOCKS5 proxy, and optionally an HTTP proxy, that
+// relay the traffic through the server. In the "tun" mode, only supported on Linux, it routes all
+// the traffic of the system through the server, which requires root. With -check, it only tests
+// the TCP and UDP connectivity of the server, and exits with a non-zero code if TCP fails.
+//
+// While connected, it prints the traffic statistics to the standard output every -statusInterval,
+// and disconnects on SIGINT or SIGTERM. The logs go to the standard error.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// Exit codes.
+const (
+	exitCodeSuccess = 0
+	exitCodeFailure = 1
+	exitCodeUsage   = 2
+)
+
+// Modes of the -mode flag.
+const (
+	modeSOCKS = "socks"
+	modeTUN   = "tun"
+)
+
+var args struct {
+	config         *string
+	mode           *string
+	socksPort      *uint
+	httpPort       *uint
+	tunName        *string
+	tunIP          *string
+	tunDNS         *string
+	check          *bool
+	statusInterval *time.Duration
+	logLevel       *string
+}
+
+func main() {
+	args.config = flag.String("config", "", "Path of a file containing the access key or transport config, instead of the argument.")
+	args.mode = flag.String("mode", modeSOCKS, "How to relay the traffic: socks (local proxy) or tun (system-wide, Linux only, requires root).")
+	args.socksPort = flag.Uint("socksPort", 1080, "Port of the local SOCKS5 proxy in socks mode. 0 picks any free port.")
+	args.httpPort = flag.Uint("httpPort", 0, "Port of the local HTTP proxy in socks mode. Disabled if 0.")
+	args.tunName = flag.String("tunName", "outline-tun0", "Name of the TUN interface in tun mode.")
+	args.tunIP = flag.String("tunIP", "10.0.85.1", "IP address of the TUN interface in tun mode.")
+	args.tunDNS = flag.String("tunDNS", "9.9.9.9", "Comma-separated DNS servers of the TUN interface in tun mode.")
+	args.check = flag.Bool("check", false, "Test the TCP and UDP connectivity of the server, print the result and exit.")
+	args.statusInterval = flag.Duration("statusInterval", 10*time.Second, "How often to print the traffic statistics. Disabled if 0.")
+	args.logLevel = flag.String("logLevel", "info", "Logging level: debug|info|warn|error")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [ss://... | ssconf://... | transport config]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if _, err := invoke(outline.MethodConfigureLogging, fmt.Sprintf(`{"level": %q}`, *args.logLevel)); err != nil {
+		exitWithError(err, exitCodeUsage)
+	}
+	if flag.NArg() > 1 || (flag.NArg() == 1) == (*args.config != "") {
+		flag.Usage()
+		os.Exit(exitCodeUsage)
+	}
+	text := flag.Arg(0)
+	if *args.config != "" {
+		data, err := os.ReadFile(*args.config)
+		if err != nil {
+			exitWithError(err, exitCodeUsage)
+		}
+		text = string(data)
+	}
+	transport, err := loadTransport(text)
+	if err != nil {
+		exitWithError(err, exitCodeFailure)
+	}
+
+	if *args.check {
+		os.Exit(checkConnectivity(transport))
+	}
+
+	stop, err := connect(transport)
+	if err != nil {
+		exitWithError(err, exitCodeFailure)
+	}
+	defer stop()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var ticks <-chan time.Time
+	if *args.statusInterval > 0 {
+		ticker := time.NewTicker(*args.statusInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-signals:
+			fmt.Println("disconnecting...")
+			return
+		case <-ticks:
+			printStatus()
+		}
+	}
+}
+
+// invoke calls an [outline.InvokeMethod] method.
+func invoke(method, input string) (string, error) {
+	result := outline.InvokeMethod(method, input)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	return result.Value, nil
+}
+
+// loadTransport returns the transport config of text, which is a ss:// access key, a ssconf://
+// dynamic access key or a transport config.
+func loadTransport(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "ssconf://"); ok {
+		content, err := invoke(outline.MethodFetchResource, "https://"+rest)
+		if err != nil {
+			return "", err
+		}
+		text = strings.TrimSpace(content)
+	}
+	input, err := json.Marshal([]string{text})
+	if err != nil {
+		return "", err
+	}
+	output, err := invoke(outline.MethodParseTunnelConfigs, string(input))
+	if err != nil {
+		return "", err
+	}
+	var parsed []struct {
+		Transport json.RawMessage `json:"transport"`
+		Error     json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return "", err
+	}
+	if len(parsed) != 1 {
+		return "", fmt.Errorf("unexpected number of parsed configs: %d", len(parsed))
+	}
+	if parsed[0].Error != nil {
+		return "", fmt.Errorf("invalid access key or transport config: %s", parsed[0].Error)
+	}
+	return string(parsed[0].Transport), nil
+}
+
+// checkConnectivity prints the connectivity test result of transport, and returns the exit code.
+func checkConnectivity(transport string) int {
+	output, err := invoke(outline.MethodTestConnectivity, transport)
+	if err != nil {
+		exitWithError(err, exitCodeFailure)
+	}
+	fmt.Println(output)
+	var result struct {
+		TCP struct {
+			Success bool `json:"success"`
+		} `json:"tcp"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil || !result.TCP.Success {
+		return exitCodeFailure
+	}
+	return exitCodeSuccess
+}
+
+// connect relays the traffic through transport in the mode of the flags, and returns the
+// function disconnecting it.
+func connect(transport string) (func(), error) {
+	switch *args.mode {
+	case modeSOCKS:
+		input, err := json.Marshal(map[string]any{
+			"transport": json.RawMessage(transport),
+			"socksPort": *args.socksPort,
+			"httpPort":  *args.httpPort,
+		})
+		if err != nil {
+			return nil, err
+		}
+		output, err := invoke(outline.MethodStartLocalProxy, string(input))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("local proxy started:", output)
+		return func() { invoke(outline.MethodStopLocalProxy, "") }, nil
+
+	case modeTUN:
+		input, err := json.Marshal(map[string]any{
+			"vpn": map[string]any{
+				"id":              "outline-cli",
+				"interfaceName":   *args.tunName,
+				"ipAddress":       *args.tunIP,
+				"dnsServers":      strings.Split(*args.tunDNS, ","),
+				"connectionName":  "Outline CLI TUN Connection",
+				"routingTableId":  7113,
+				"routingPriority": 0x711e,
+				"protectionMark":  0x711e,
+			},
+			"transport": transport,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := invoke(outline.MethodEstablishVPN, string(input)); err != nil {
+			return nil, err
+		}
+		fmt.Println("VPN connected through", *args.tunName)
+		return func() { invoke(outline.MethodCloseVPN, "") }, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported mode %q, expected %s or %s", *args.mode, modeSOCKS, modeTUN)
+	}
+}
+
+// printStatus prints the traffic statistics of the session on one line.
+func printStatus() {
+	output, err := invoke(outline.MethodGetStats, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get the statistics:", err)
+		return
+	}
+	var s stats.Snapshot
+	if err := json.Unmarshal([]byte(output), &s); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to parse the statistics:", err)
+		return
+	}
+	fmt.Printf("status: tcp=%d udp=%d sent=%dB received=%dB\n",
+		s.ActiveTCPConns, s.ActiveUDPSessions, s.TxBytes, s.RxBytes)
+}
+
+func exitWithError(err error, exitCode int) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitCodeUsage)
+	}
+	fmt.Fprintln(os.Stderr, "outline-cli:", err)
+	os.Exit(exitCode)
+}
@@ -15,10 +15,14 @@
 package outline
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/utf8"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
 )
 
 // Config represents a (legacy) shadowsocks server configuration. You can use
@@ -47,28 +51,238 @@ func ParseConfigPrefixFromString(raw string) (p []byte, err error) {
 // An internal data structure to be used by JSON deserialization.
 // Must match the ShadowsocksSessionConfig interface defined in Outline Client.
 type configJSON struct {
+	// Type is the transport type, which selects the [TransportParser] of the config. It defaults
+	// to "shadowsocks", whose fields follow.
+	Type string `json:"$type,omitempty"`
+
+	// Version is the version of the config format, see [configVersion]. Defaults to 1.
+	Version int `json:"version,omitempty"`
+
 	Host     string `json:"host"`
 	Port     uint16 `json:"port"`
 	Password string `json:"password"`
 	Method   string `json:"method"`
 	Prefix   string `json:"prefix"`
+
+	// Obfs selects an obfuscation strategy for the Shadowsocks salts. It supersedes Prefix,
+	// which is the same as an obfs layer of type "prefix".
+	Obfs *obfsConfigJSON `json:"obfs,omitempty"`
+
+	// UDPObfs randomizes the sizes and the timing of the UDP packets to the proxy server.
+	UDPObfs *udpObfsConfigJSON `json:"udpObfs,omitempty"`
+
+	// UDPOverTCP relays the UDP traffic over TCP when the UDP connectivity check fails, instead
+	// of only relaying the DNS queries. The server must support UDP-over-TCP (version 2).
+	UDPOverTCP bool `json:"udpOverTcp,omitempty"`
+
+	// QUIC is the QUIC (HTTP/3) policy: "allow" (default) relays the QUIC traffic, and "block"
+	// rejects it so that browsers use TCP, e.g. when the server doesn't relay UDP.
+	QUIC string `json:"quic,omitempty"`
+
+	// ICMPEcho is how the tunnel answers the pings, which the proxy doesn't relay: "off" (default)
+	// drops them, "reply" replies to all of them, and "probe" replies to the ones whose
+	// destination answers a probe through the proxy.
+	ICMPEcho string `json:"icmpEcho,omitempty"`
+
+	// Routing selects the destinations that bypass the proxy (split tunneling).
+	Routing *routing.Config `json:"routing,omitempty"`
+
+	// DNS selects the resolvers answering the DNS queries of the tunnel, instead of relaying them,
+	// and resolving the host name of the proxy server.
+	DNS *dnsConfigJSON `json:"dns,omitempty"`
+
+	// HostResolution controls the resolution of the host name of the proxy server.
+	HostResolution *hostResolutionConfigJSON `json:"hostResolution,omitempty"`
+
+	// Timeouts tunes the connection timeouts, e.g. for high-latency links.
+	Timeouts *timeoutsConfigJSON `json:"timeouts,omitempty"`
+
+	// Reconnect tunes how the tunnel retries when the server becomes unreachable.
+	Reconnect *reconnectConfigJSON `json:"reconnect,omitempty"`
+
+	// UDPMaxSessions is the size of the UDP NAT table of the tunnel. When it's full, the least
+	// recently used UDP session is closed to make room for a new one. Defaults to 1024.
+	UDPMaxSessions int `json:"udpMaxSessions,omitempty"`
+
+	// Quota is the data allowance of the provider. The tunnel emits an [EventQuotaWarning] when
+	// its usage reaches 80% and 100% of it.
+	Quota *quotaConfigJSON `json:"quota,omitempty"`
+
+	// Limits caps the TCP connections of the tunnel.
+	Limits *limitsConfigJSON `json:"limits,omitempty"`
+
+	// PreDial keeps connections to the proxy server ready, so that the new TCP connections don't
+	// wait for the TCP handshake.
+	PreDial *preDialConfigJSON `json:"preDial,omitempty"`
+
+	// EarlyData tunes how the first bytes of the TCP connections get to the proxy server, e.g. with
+	// TCP Fast Open.
+	EarlyData *earlyDataConfigJSON `json:"earlyData,omitempty"`
+
+	// ProxyTCPTuning tunes the TCP sockets to the proxy server, e.g. their congestion control,
+	// for high-latency links.
+	ProxyTCPTuning *proxyTCPTuningConfigJSON `json:"proxyTcpTuning,omitempty"`
+
+	// OutboundProxy tunnels the TCP connections to the proxy server through another proxy, for
+	// networks that only allow proxied connections: "system" for the proxy of the system
+	// settings, or an http://, socks5:// or socks5h:// URL. HTTP proxies may have credentials.
+	OutboundProxy string `json:"outboundProxy,omitempty"`
+
+	// PluggableTransport tunnels the TCP connections to the proxy server through a pluggable
+	// transport, like obfs4, for networks where Shadowsocks is detected. It cannot be used
+	// together with OutboundProxy.
+	PluggableTransport *pluggableTransportConfigJSON `json:"pluggableTransport,omitempty"`
+
+	// Name, Comment and Tags describe the server to the user, e.g. to title the servers imported
+	// from a subscription. The client ignores them.
+	Name    string   `json:"name,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
 }
 
 // ParseConfigFromJSON parses a JSON string `in` as a configJSON object.
 // The JSON string `in` must match the ShadowsocksSessionConfig interface
 // defined in Outline Client.
 func parseConfigFromJSON(in string) (*configJSON, error) {
+	if err := checkConfigLimits(in); err != nil {
+		return nil, err
+	}
 	var conf configJSON
 	if err := json.Unmarshal([]byte(in), &conf); err != nil {
-		return nil, platerrors.PlatformError{
-			Code:    platerrors.IllegalConfig,
-			Message: "transport config is not a valid JSON",
-			Cause:   platerrors.ToPlatformError(err),
+		return nil, newInvalidJSONError("transport config is not a valid JSON", in, err)
+	}
+	if err := conf.checkVersion(); err != nil {
+		return nil, err
+	}
+	if strictConfigParsing.Load() {
+		if err := conf.checkStrict(in); err != nil {
+			return nil, err
 		}
 	}
 	return &conf, nil
 }
 
+// canonicalJSON returns the transport of the config as canonical JSON: compact, with the keys of
+// the objects in sorted order, without the empty fields and the display metadata, and with the
+// canonical cipher name. Configs that are the same have the same canonical JSON.
+func (conf *configJSON) canonicalJSON() (string, error) {
+	c := conf.withoutMetadata()
+	if method, ok := canonicalCipherName(c.Method); ok {
+		c.Method = method
+	}
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to decode the config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	// Maps are marshaled with sorted keys.
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(withoutEmptyFields(value)); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the canonical config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+// withoutMetadata returns a copy of the config without the display metadata, which doesn't affect
+// the transport.
+func (conf *configJSON) withoutMetadata() configJSON {
+	c := *conf
+	c.Name, c.Comment, c.Tags = "", "", nil
+	return c
+}
+
+// withoutEmptyFields removes the null and empty string fields of the objects in value, which
+// mean the same as missing fields.
+func withoutEmptyFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if field == nil || field == "" {
+				delete(v, key)
+				continue
+			}
+			v[key] = withoutEmptyFields(field)
+		}
+	case []any:
+		for i := range v {
+			v[i] = withoutEmptyFields(v[i])
+		}
+	}
+	return value
+}
+
+// obfsConfig returns the obfuscation layer of the config, converting the legacy Prefix field
+// into a "prefix" obfs layer. It returns nil if no obfuscation is configured.
+func (conf *configJSON) obfsConfig() (*obfsConfigJSON, error) {
+	if len(conf.Prefix) == 0 {
+		return conf.Obfs, nil
+	}
+	if conf.Obfs != nil {
+		return nil, newIllegalConfigErrorWithDetails("prefix and obfs cannot be used together",
+			"prefix", conf.Prefix, "empty when obfs is set", nil)
+	}
+	if _, err := ParseConfigPrefixFromString(conf.Prefix); err != nil {
+		return nil, err
+	}
+	return &obfsConfigJSON{Type: obfsTypePrefix, Prefix: conf.Prefix}, nil
+}
+
+// router creates the [routing.Router] of the config. Without a routing section, all the traffic
+// goes through the proxy, except the LAN traffic if [MethodSetLANBypass] enabled it.
+//
+// The management hosts are resolved on the first routing decision rather than when the client is
+// created, which doesn't always lead to any traffic, e.g. when only validating the config.
+func (conf *configJSON) router() (*routing.Router, error) {
+	var routingConf routing.Config
+	if conf.Routing != nil {
+		routingConf = *conf.Routing
+	}
+	router, err := routing.NewRouter(routingConf)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("routing rules are not valid",
+			"routing", err.Error(), "valid routing rules", err)
+	}
+	if hosts := routingConf.ManagementHosts; len(hosts) > 0 {
+		router.OnFirstRoute(func() { resolveManagementHosts(hosts) })
+	}
+	return router, nil
+}
+
+// vpnRoutingError returns an IllegalConfig error if the routing section has domain rules, which
+// a VPN cannot apply: it only sees the IP addresses of the destinations, never their names.
+func (conf *configJSON) vpnRoutingError() error {
+	if conf.Routing == nil {
+		return nil
+	}
+	for i, rule := range conf.Routing.Rules {
+		if len(rule.Domains) > 0 {
+			return newIllegalConfigErrorWithDetails(
+				"domain routing rules are not supported by the VPN, which only sees IP addresses",
+				fmt.Sprintf("routing.rules[%d].domains", i), rule.Domains, "cidrs instead of domains", nil)
+		}
+	}
+	return nil
+}
+
 // validateConfig validates whether a Shadowsocks server configuration is valid
 // (it won't do any connectivity tests)
 //
@@ -83,6 +297,9 @@ func validateConfig(host string, port int, cipher, password string) error {
 	if len(cipher) == 0 {
 		return newIllegalConfigErrorWithDetails("cipher method is not valid", "cipher", cipher, "not nil", nil)
 	}
+	if err := validateCipher(cipher); err != nil {
+		return err
+	}
 	if len(password) == 0 {
 		return newIllegalConfigErrorWithDetails("password is not valid", "password", password, "not nil", nil)
 	}
@@ -0,0 +1,85 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+const (
+	// maxConfigSize is the maximum size of a config, far above the size of legitimate ones, so
+	// that a malicious dynamic key can't make the VPN process run out of memory.
+	maxConfigSize = 1 << 20
+
+	// maxConfigDepth is the maximum nesting depth of the JSON objects and arrays of a config.
+	maxConfigDepth = 32
+)
+
+// checkConfigLimits returns a [platerrors.ConfigTooLarge] error if the JSON config exceeds
+// [maxConfigSize] or [maxConfigDepth]. It is cheaper than parsing, so it runs before.
+func checkConfigLimits(config string) error {
+	if len(config) > maxConfigSize {
+		return newConfigTooLargeError("config is too large", "size", len(config), maxConfigSize)
+	}
+	depth, inString, escaped := 0, false, false
+	for i := 0; i < len(config); i++ {
+		c := config[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > maxConfigDepth {
+				return newConfigTooLargeError("config is nested too deep", "depth", depth, maxConfigDepth)
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// readConfig reads a config from r, failing if it exceeds [maxConfigSize].
+func readConfig(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxConfigSize+1))
+	if err != nil {
+		return body, err
+	}
+	if len(body) > maxConfigSize {
+		return body[:maxConfigSize], newConfigTooLargeError("config is too large", "size",
+			fmt.Sprintf("more than %d", maxConfigSize), maxConfigSize)
+	}
+	return body, nil
+}
+
+func newConfigTooLargeError(msg, limit string, got any, max int) platerrors.PlatformError {
+	return platerrors.PlatformError{
+		Code:    platerrors.ConfigTooLarge,
+		Message: msg,
+		Details: platerrors.ErrorDetails{"limit": limit, "got": got, "max": max},
+	}
+}
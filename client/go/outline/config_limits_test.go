@@ -0,0 +1,59 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfigLimits(t *testing.T) {
+	deep := strings.Repeat("[", maxConfigDepth) + strings.Repeat("]", maxConfigDepth)
+	for _, tt := range []struct {
+		name   string
+		config string
+		limit  string
+	}{
+		{"valid", `{"host":"example.com","routing":{"rules":[{"domains":["a"]}]}}`, ""},
+		{"max depth", deep, ""},
+		{"too deep", "[" + deep + "]", "depth"},
+		{"brackets in strings", `{"password":"` + strings.Repeat("[{", maxConfigDepth) + `\"["}`, ""},
+		{"too large", `{"password":"` + strings.Repeat("x", maxConfigSize) + `"}`, "size"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkConfigLimits(tt.config)
+			if tt.limit == "" {
+				require.NoError(t, err)
+				return
+			}
+			var perr platerrors.PlatformError
+			require.ErrorAs(t, err, &perr)
+			require.Equal(t, platerrors.ConfigTooLarge, perr.Code)
+			require.Equal(t, tt.limit, perr.Details["limit"])
+		})
+	}
+}
+
+func TestParseConfig_TooLarge(t *testing.T) {
+	bomb := strings.Repeat(`{"a":`, 100_000)
+	_, err := parseConfigFromJSON(bomb)
+	require.Equal(t, platerrors.ConfigTooLarge, platerrors.ToPlatformError(err).Code)
+
+	_, _, err = parseTunnelConfig(strings.Repeat("[", 100_000))
+	require.Equal(t, platerrors.ConfigTooLarge, platerrors.ToPlatformError(err).Code)
+}
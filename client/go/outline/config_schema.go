@@ -0,0 +1,374 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// configVersion is the latest version of the transport config format this app understands.
+// Configs without a version are version 1.
+const configVersion = 1
+
+// configWarningUnknownField is the code of the warnings about fields the config format doesn't
+// have, which are ignored. They are usually typos, or fields of a newer version.
+const configWarningUnknownField = "unknown-field"
+
+// strictConfigParsing rejects the configs with unknown fields, instead of ignoring the fields
+// with a warning.
+var strictConfigParsing atomic.Bool
+
+// SetStrictConfigParsing enables or disables the strict parsing of transport configs. While
+// enabled, a config with an unknown field, usually a typo like "chiper", fails with an
+// IllegalConfig error locating the field, instead of creating a client that doesn't connect.
+func SetStrictConfigParsing(enabled bool) {
+	strictConfigParsing.Store(enabled)
+}
+
+func setStrictConfigParsing(input string) error {
+	var enabled bool
+	if err := json.Unmarshal([]byte(input), &enabled); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "strict config parsing toggle must be true or false",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	SetStrictConfigParsing(enabled)
+	logger.Info("strict config parsing updated", "enabled", enabled)
+	return nil
+}
+
+// configWarningJSON is a problem of a config that doesn't prevent using it.
+type configWarningJSON struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Path is the dot-separated path of the field, e.g. "obfs.lenght".
+	Path string `json:"path"`
+
+	// Line and Column locate the field in the config, starting at 1. The column counts bytes.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// configValidationJSON is the output of [MethodValidateConfig].
+type configValidationJSON struct {
+	Warnings []configWarningJSON `json:"warnings"`
+}
+
+// validateConfigJSON parses the transport config in input, and returns a JSON string of
+// configValidationJSON with its warnings. Invalid configs return an error instead.
+func validateConfigJSON(input string) (string, error) {
+	conf, err := parseConfigFromJSON(input)
+	if err != nil {
+		return "", err
+	}
+	warnings, err := conf.warnings(input)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(configValidationJSON{Warnings: warnings})
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the config warnings",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// checkVersion returns an error if the config requires a newer version of the app.
+func (conf *configJSON) checkVersion() error {
+	if conf.Version < 0 {
+		return newIllegalConfigErrorWithDetails("config version is not valid", "version", conf.Version,
+			fmt.Sprintf("within range [1..%d]", configVersion), nil)
+	}
+	if conf.Version > configVersion {
+		return platerrors.PlatformError{
+			Code:    platerrors.ConfigRequiresNewerApp,
+			Message: "config requires a newer version of the app",
+			Details: platerrors.ErrorDetails{
+				"version":          conf.Version,
+				"supportedVersion": configVersion,
+			},
+		}
+	}
+	return nil
+}
+
+// warnings returns the warnings of the config, parsed from the JSON string in. The fields of
+// transports other than Shadowsocks are not checked, as they are parsed by their transport.
+func (conf *configJSON) warnings(in string) ([]configWarningJSON, error) {
+	warnings := []configWarningJSON{}
+	if conf.Type != "" && conf.Type != transportTypeShadowsocks {
+		return warnings, nil
+	}
+	fields, err := findUnknownFields([]byte(in), reflect.TypeOf(configJSON{}))
+	if err != nil {
+		return nil, newInvalidJSONError("transport config is not a valid JSON", in, err)
+	}
+	for _, f := range fields {
+		warnings = append(warnings, configWarningJSON{
+			Code:    configWarningUnknownField,
+			Message: fmt.Sprintf("unknown field %q is ignored", f.path),
+			Path:    f.path,
+			Line:    f.line,
+			Column:  f.column,
+		})
+	}
+	return warnings, nil
+}
+
+// checkStrict returns an IllegalConfig error for the first unknown field of the config, parsed
+// from the JSON string in.
+func (conf *configJSON) checkStrict(in string) error {
+	warnings, err := conf.warnings(in)
+	if err != nil {
+		return err
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	w := warnings[0]
+	perr := newIllegalConfigErrorWithDetails(
+		fmt.Sprintf("unknown field %q at line %d, column %d", w.Path, w.Line, w.Column),
+		w.Path, "unknown field", "a known field", nil)
+	perr.Details["line"] = w.Line
+	perr.Details["column"] = w.Column
+	return perr
+}
+
+// unknownField is a JSON object key that doesn't match any field of the Go type it's decoded to.
+type unknownField struct {
+	path         string
+	line, column int
+}
+
+// findUnknownFields returns the object keys of the JSON data that [json.Unmarshal] would ignore
+// when decoding it into a value of type t.
+func findUnknownFields(data []byte, t reflect.Type) ([]unknownField, error) {
+	w := &unknownFieldWalker{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	if err := w.walk(t, ""); err != nil {
+		return nil, err
+	}
+	return w.fields, nil
+}
+
+type unknownFieldWalker struct {
+	data   []byte
+	dec    *json.Decoder
+	fields []unknownField
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// walk reads the next JSON value, expected to be decoded into type t at path.
+func (w *unknownFieldWalker) walk(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	if t == nil || t == rawMessageType {
+		return w.skip(delim)
+	}
+	switch {
+	case delim == '{' && t.Kind() == reflect.Struct:
+		return w.walkStruct(t, path)
+	case delim == '{' && t.Kind() == reflect.Map:
+		for w.dec.More() {
+			key, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			if err := w.walk(t.Elem(), joinFieldPath(path, fmt.Sprint(key))); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	case delim == '[' && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for i := 0; w.dec.More(); i++ {
+			if err := w.walk(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	default:
+		// Either an interface, or a type mismatch that json.Unmarshal reports.
+		return w.skip(delim)
+	}
+}
+
+func (w *unknownFieldWalker) walkStruct(t reflect.Type, path string) error {
+	for w.dec.More() {
+		offset := w.keyOffset()
+		key, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprint(key)
+		field, ok := jsonField(t, name)
+		if !ok {
+			line, column := lineColumn(w.data, offset)
+			w.fields = append(w.fields, unknownField{path: joinFieldPath(path, name), line: line, column: column})
+			if err := w.walk(nil, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(field.Type, joinFieldPath(path, name)); err != nil {
+			return err
+		}
+	}
+	_, err := w.dec.Token()
+	return err
+}
+
+// keyOffset returns the offset of the next object key, skipping the separators before it.
+func (w *unknownFieldWalker) keyOffset() int {
+	offset := int(w.dec.InputOffset())
+	for offset < len(w.data) && strings.IndexByte(" \t\r\n,", w.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// skip reads the rest of the object or array opened by delim.
+func (w *unknownFieldWalker) skip(delim json.Delim) error {
+	if delim != '{' && delim != '[' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := w.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// jsonField returns the field of struct type t that the object key name is decoded to. Like
+// [json.Unmarshal], it prefers an exact match, but accepts a case-insensitive one.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	var fold *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return f, true
+		}
+		if fold == nil && strings.EqualFold(tag, name) {
+			fold = &f
+		}
+	}
+	if fold != nil {
+		return *fold, true
+	}
+	return reflect.StructField{}, false
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// lineColumn returns the 1-based line and column of the byte offset in data.
+func lineColumn(data []byte, offset int) (line, column int) {
+	before := data[:min(offset, len(data))]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// snippetRadius is the number of bytes around an error that the snippet of the error includes.
+const snippetRadius = 16
+
+// newInvalidJSONError creates an IllegalConfig error for the JSON decoding error err of data.
+// Syntax and type errors are located with the "line", "column" and "snippet" details, so that
+// the app can highlight where a pasted config is broken.
+func newInvalidJSONError(msg, data string, err error) platerrors.PlatformError {
+	perr := platerrors.PlatformError{
+		Code:    platerrors.IllegalConfig,
+		Message: msg,
+		Cause:   platerrors.ToPlatformError(err),
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset is after the invalid byte.
+		perr.Details = positionDetails(data, int(syntaxErr.Offset)-1, snippetAround(data, int(syntaxErr.Offset)-1))
+	case errors.As(err, &typeErr):
+		perr.Details = positionDetails(data, int(typeErr.Offset)-1, snippetAround(data, int(typeErr.Offset)-1))
+	}
+	return perr
+}
+
+// positionDetails returns the details locating the error at offset in data.
+func positionDetails(data string, offset int, snippet string) platerrors.ErrorDetails {
+	line, column := lineColumn([]byte(data), max(offset, 0))
+	return platerrors.ErrorDetails{"line": line, "column": column, "snippet": snippet}
+}
+
+// snippetAround returns the text of data around offset, without splitting UTF-8 characters.
+func snippetAround(data string, offset int) string {
+	start, end := max(offset-snippetRadius, 0), min(offset+snippetRadius, len(data))
+	for start > 0 && !utf8.RuneStart(data[start]) {
+		start--
+	}
+	for end < len(data) && !utf8.RuneStart(data[end]) {
+		end++
+	}
+	return data[start:end]
+}
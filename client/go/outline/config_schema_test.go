@@ -0,0 +1,135 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig_Warnings(t *testing.T) {
+	const config = `{
+  "host": "example.com", "port": 443, "method": "aes-128-gcm", "password": "pw",
+  "chiper": "aes-256-gcm",
+  "Version": 1,
+  "obfs": {"$type": "padding", "lenght": 8},
+  "routing": {"rules": [{"action": "direct", "domains": ["lan"]}, {"acton": "proxy", "extra": {"a": [1]}}]},
+  "dns": {"rules": [{"domains": ["example.com"], "resolvers": [{"$type": "udp", "adress": "1.1.1.1"}]}]}
+}`
+	out, err := validateConfigJSON(config)
+	require.NoError(t, err)
+	var got configValidationJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &got))
+
+	var paths []string
+	for _, w := range got.Warnings {
+		require.Equal(t, configWarningUnknownField, w.Code)
+		paths = append(paths, w.Path)
+	}
+	require.Equal(t, []string{
+		"chiper",
+		"obfs.lenght",
+		"routing.rules[1].acton",
+		"routing.rules[1].extra",
+		"dns.rules[0].resolvers[0].adress",
+	}, paths)
+	require.Equal(t, 3, got.Warnings[0].Line)
+	require.Equal(t, 3, got.Warnings[0].Column)
+	require.Equal(t, 5, got.Warnings[1].Line)
+	require.Equal(t, 32, got.Warnings[1].Column)
+}
+
+func TestValidateConfig_NoWarnings(t *testing.T) {
+	for _, config := range []string{
+		`{"host": "example.com", "port": 443, "method": "aes-128-gcm", "password": "pw", "version": 1}`,
+		// Other transports parse their own fields.
+		`{"$type": "custom", "endpoint": "example.com"}`,
+	} {
+		out, err := validateConfigJSON(config)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"warnings":[]}`, out)
+	}
+}
+
+func TestParseConfigFromJSON_Version(t *testing.T) {
+	_, err := parseConfigFromJSON(`{"host": "example.com", "version": 2}`)
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ConfigRequiresNewerApp, perr.Code)
+	require.Equal(t, configVersion, perr.Details["supportedVersion"])
+
+	_, err = parseConfigFromJSON(`{"host": "example.com", "version": -1}`)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+}
+
+func TestParseConfigFromJSON_Strict(t *testing.T) {
+	SetStrictConfigParsing(true)
+	defer SetStrictConfigParsing(false)
+
+	_, err := parseConfigFromJSON("{\n  \"host\": \"example.com\", \"port\": 443,\n  \"chiper\": \"aes-128-gcm\"\n}")
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+	require.Equal(t, "chiper", perr.Details["field"])
+	require.Equal(t, 3, perr.Details["line"])
+	require.Equal(t, 3, perr.Details["column"])
+
+	_, err = parseConfigFromJSON(`{"host": "example.com", "port": 443, "method": "aes-128-gcm", "password": "pw"}`)
+	require.NoError(t, err)
+
+	require.NoError(t, setStrictConfigParsing("false"))
+	_, err = parseConfigFromJSON(`{"host": "example.com", "chiper": "aes-128-gcm"}`)
+	require.NoError(t, err)
+	require.Error(t, setStrictConfigParsing("yes"))
+}
+
+func TestParseConfigFromJSON_ErrorPosition(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		line, column int
+		snippet      string
+	}{
+		{
+			name:    "syntax",
+			input:   "{\n  \"host\": \"example.com\",\n  \"port\": 443,,\n}",
+			line:    3,
+			column:  15,
+			snippet: ",\n  \"port\": 443,,\n}",
+		},
+		{
+			name:    "type",
+			input:   `{"host": "example.com", "port": "443"}`,
+			line:    1,
+			column:  37,
+			snippet: `m", "port": "443"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfigFromJSON(tt.input)
+			var perr platerrors.PlatformError
+			require.ErrorAs(t, err, &perr)
+			require.Equal(t, platerrors.IllegalConfig, perr.Code)
+			require.Equal(t, tt.line, perr.Details["line"])
+			require.Equal(t, tt.column, perr.Details["column"])
+			require.Equal(t, tt.snippet, perr.Details["snippet"])
+		})
+	}
+}
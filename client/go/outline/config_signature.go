@@ -0,0 +1,104 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// signedConfigJSON is the envelope of a signed dynamic config.
+type signedConfigJSON struct {
+	// Payload is the config, e.g. an ss:// access key or a JSON transport config.
+	Payload string `json:"payload"`
+
+	// Signature is the base64 Ed25519 signature of the UTF-8 bytes of Payload, exactly as they
+	// are, without any canonicalization.
+	Signature string `json:"signature"`
+}
+
+// The public keys of the providers, which the signed dynamic configs must be signed with.
+var configSigningKeysMu sync.RWMutex
+var configSigningKeys []ed25519.PublicKey
+
+// setConfigSigningKeys replaces the provider public keys with the JSON array of base64 Ed25519
+// public keys in input. The signed configs are rejected while there are none.
+func setConfigSigningKeys(input string) error {
+	var encodedKeys []string
+	if err := json.Unmarshal([]byte(input), &encodedKeys); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "config signing keys must be a JSON array of strings",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	keys := make([]ed25519.PublicKey, 0, len(encodedKeys))
+	for _, encoded := range encodedKeys {
+		key, err := decodeBase64(encoded)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return newIllegalConfigErrorWithDetails("config signing key is not valid", "key", encoded,
+				"base64 Ed25519 public key", err)
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	configSigningKeysMu.Lock()
+	defer configSigningKeysMu.Unlock()
+	configSigningKeys = keys
+	logger.Info("config signing keys updated", "count", len(keys))
+	return nil
+}
+
+// verifySignedConfig verifies the signature of the signed dynamic config content, a JSON
+// signedConfigJSON, with the provider public keys, and returns its payload. The signature covers
+// the raw bytes of the payload, so that the providers sign the config exactly as they serve it.
+//
+// Only the sources that opt in, e.g. with the "signed" option of [MethodFetchResource], are
+// verified, so that the other dynamic keys, like plain ss:// keys, keep working.
+func verifySignedConfig(content string) (string, error) {
+	configSigningKeysMu.RLock()
+	keys := configSigningKeys
+	configSigningKeysMu.RUnlock()
+	if len(keys) == 0 {
+		return "", newConfigSignatureError("no config signing keys are provisioned to verify the config")
+	}
+
+	var envelope signedConfigJSON
+	if err := json.Unmarshal([]byte(content), &envelope); err != nil || envelope.Payload == "" {
+		return "", newConfigSignatureError("signed config must be a JSON object with a payload and a signature")
+	}
+	if envelope.Signature == "" {
+		return "", newConfigSignatureError("config is not signed")
+	}
+	signature, err := decodeBase64(envelope.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return "", newConfigSignatureError("config signature is not valid base64 Ed25519 signature")
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, []byte(envelope.Payload), signature) {
+			return envelope.Payload, nil
+		}
+	}
+	return "", newConfigSignatureError("config signature doesn't match the provider keys")
+}
+
+func newConfigSignatureError(msg string) platerrors.PlatformError {
+	return platerrors.PlatformError{
+		Code:    platerrors.ConfigSignatureInvalid,
+		Message: msg,
+	}.WithHint(platerrors.HintUntrustedNetwork)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignedConfig(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	// The payload is signed as is, whitespace included.
+	const payload = "{\n  \"port\": 443, \"host\": \"example.com\",\n  \"password\": \"<pw>&\", \"method\": \"aes-128-gcm\"\n}"
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(payload)))
+	envelope := func(payload, signature string) string {
+		out, err := json.Marshal(signedConfigJSON{Payload: payload, Signature: signature})
+		require.NoError(t, err)
+		return string(out)
+	}
+	signed := envelope(payload, signature)
+
+	// Without keys, the signed configs are rejected.
+	_, err = verifySignedConfig(signed)
+	require.Error(t, err)
+
+	keys, err := json.Marshal([]string{base64.StdEncoding.EncodeToString(otherPub), base64.RawURLEncoding.EncodeToString(pub)})
+	require.NoError(t, err)
+	require.NoError(t, setConfigSigningKeys(string(keys)))
+	defer setConfigSigningKeys("[]")
+
+	got, err := verifySignedConfig(signed)
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	for _, content := range []string{
+		envelope(strings.Replace(payload, "example.com", "attacker.example", 1), signature),
+		envelope(strings.Replace(payload, "\n", "", -1), signature),
+		envelope(payload, ""),
+		envelope(payload, "bm90IGEgc2lnbmF0dXJl"),
+		payload,
+		"ss://YWVzLTEyOC1nY206cHc@example.com:443/",
+	} {
+		_, err := verifySignedConfig(content)
+		var perr platerrors.PlatformError
+		require.ErrorAs(t, err, &perr, content)
+		require.Equal(t, platerrors.ConfigSignatureInvalid, perr.Code)
+		require.Equal(t, platerrors.HintUntrustedNetwork, perr.Details[platerrors.HintDetailsKey])
+	}
+}
+
+func TestFetchResource_Signed(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	keys, err := json.Marshal([]string{base64.StdEncoding.EncodeToString(pub)})
+	require.NoError(t, err)
+	require.NoError(t, setConfigSigningKeys(string(keys)))
+	defer setConfigSigningKeys("[]")
+
+	const accessKey = "ss://YWVzLTEyOC1nY206cHc@example.com:443/"
+	signed, err := json.Marshal(signedConfigJSON{
+		Payload: accessKey, Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(accessKey))),
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/signed" {
+			w.Write(signed)
+		} else {
+			w.Write([]byte(accessKey))
+		}
+	}))
+	defer server.Close()
+
+	// The sources that don't opt in are not verified.
+	got, err := fetchVerifiedResource(context.Background(), server.URL+"/plain")
+	require.NoError(t, err)
+	require.Equal(t, accessKey, got)
+
+	got, err = fetchVerifiedResource(context.Background(), `{"url":"`+server.URL+`/signed","signed":true}`)
+	require.NoError(t, err)
+	require.Equal(t, accessKey, got)
+
+	_, err = fetchVerifiedResource(context.Background(), `{"url":"`+server.URL+`/plain","signed":true}`)
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ConfigSignatureInvalid, perr.Code)
+}
+
+func TestSetConfigSigningKeys_Errors(t *testing.T) {
+	for _, input := range []string{
+		`not json`,
+		`["not base64!"]`,
+		`["c2hvcnQ="]`,
+	} {
+		require.Error(t, setConfigSigningKeys(input), input)
+	}
+}
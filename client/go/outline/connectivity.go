@@ -15,6 +15,13 @@
 package outline
 
 import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
 )
@@ -30,11 +37,101 @@ type TCPAndUDPConnectivityResult struct {
 //
 // It parallelizes the execution of TCP and UDP checks, and returns a [TCPAndUDPConnectivityResult]
 // containing a TCP error and a UDP error.
-// If the connectivity check was successful, the corresponding error field will be nil.
+// If the connectivity check was successful, the corresponding error field will be nil. A TCP error
+// caused by a wrong device clock is reported as a DeviceClockSkewed error.
 func CheckTCPAndUDPConnectivity(client *Client) *TCPAndUDPConnectivityResult {
 	tcpErr, udpErr := connectivity.CheckTCPAndUDPConnectivity(client, client)
+	tcpErr = withClockSkewCheck(context.Background(), tcpErr)
 	return &TCPAndUDPConnectivityResult{
 		TCPError: platerrors.ToPlatformError(tcpErr),
 		UDPError: platerrors.ToPlatformError(udpErr),
 	}
 }
+
+// connectivityTestResultJSON is the output of [MethodTestConnectivity].
+type connectivityTestResultJSON struct {
+	TCP protocolTestResultJSON `json:"tcp"`
+	UDP protocolTestResultJSON `json:"udp"`
+
+	// RemoteAddress is the IP address and port the TCP connection of the check was dialed to,
+	// which is the proxy server, or the outbound proxy. It's omitted if the check failed, or if
+	// the transport has no TCP connections, like the QUIC transports.
+	RemoteAddress string `json:"remoteAddress,omitempty"`
+}
+
+// protocolTestResultJSON is the connectivity test result of a single protocol.
+type protocolTestResultJSON struct {
+	Success bool `json:"success"`
+	// DurationMs is how long the check took to succeed. It includes the connection to the server
+	// and a request through it, so it's a few round trips, not the round-trip time of the network.
+	DurationMs int64                     `json:"durationMs"`
+	Error      *platerrors.PlatformError `json:"error,omitempty"`
+}
+
+// testConnectivity creates a [Client] from transportConfig, checks whether it can relay TCP and
+// UDP traffic, and returns a JSON string of connectivityTestResultJSON.
+//
+// An error is returned only if the transport config is invalid, connectivity failures are
+// reported in the result.
+func testConnectivity(ctx context.Context, transportConfig string) (string, error) {
+	res, err := runConnectivityTest(ctx, transportConfig)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(res)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal connectivity test result",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// runConnectivityTest creates a [Client] from transportConfig, and checks whether it can relay
+// TCP and UDP traffic. It returns ctx.Err() if ctx is done before the checks complete.
+func runConnectivityTest(ctx context.Context, transportConfig string) (*connectivityTestResultJSON, error) {
+	// The address the connection is dialed to is recorded before connect, for every attempt. The
+	// attempts are sequential without the fallback of dual-stack hosts, so the last one is the
+	// connection of the check when it succeeds.
+	var remoteAddress atomic.Pointer[string]
+	tcpDialer := net.Dialer{KeepAlive: -1, FallbackDelay: -1, Control: func(_, address string, _ syscall.RawConn) error {
+		remoteAddress.Store(&address)
+		return nil
+	}}
+	client, err := newClientWithBaseDialers(transportConfig, tcpDialer, net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &connectivityTestResultJSON{}
+	udpDone := make(chan struct{})
+	go func() {
+		defer close(udpDone)
+		res.UDP = timeProtocolTest(func() error {
+			return connectivity.CheckUDPConnectivity(ctx, client)
+		})
+	}()
+	res.TCP = timeProtocolTest(func() error {
+		return withClockSkewCheck(ctx, connectivity.CheckTCPConnectivity(ctx, client))
+	})
+	<-udpDone
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if address := remoteAddress.Load(); address != nil && res.TCP.Success {
+		res.RemoteAddress = *address
+	}
+	return res, nil
+}
+
+// timeProtocolTest runs test and measures how long it takes to succeed.
+func timeProtocolTest(test func() error) protocolTestResultJSON {
+	start := time.Now()
+	if err := test(); err != nil {
+		return protocolTestResultJSON{Error: platerrors.ToPlatformError(err)}
+	}
+	return protocolTestResultJSON{Success: true, DurationMs: time.Since(start).Milliseconds()}
+}
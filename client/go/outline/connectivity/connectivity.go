@@ -16,8 +16,11 @@ package connectivity
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"net/http"
+	"syscall"
 	"time"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
@@ -49,30 +52,48 @@ func CheckTCPAndUDPConnectivity(
 	udpErrChan := make(chan error)
 	go func() {
 		resolverAddr := &net.UDPAddr{IP: net.ParseIP(testDNSServerIP), Port: testDNSServerPort}
-		udpErrChan <- CheckUDPConnectivityWithDNS(udp, resolverAddr)
+		udpErrChan <- CheckUDPConnectivityWithDNS(context.Background(), udp, resolverAddr)
 	}()
 
-	tcpErr = CheckTCPConnectivityWithHTTP(tcp, testTCPWebsite)
+	tcpErr = CheckTCPConnectivityWithHTTP(context.Background(), tcp, testTCPWebsite)
 	udpErr = <-udpErrChan
 	return
 }
 
+// CheckTCPConnectivity checks whether the given `tcp` client can relay TCP traffic, by issuing an
+// HTTP HEAD request to a well-known website. It returns ctx.Err() if ctx is done first.
+func CheckTCPConnectivity(ctx context.Context, tcp transport.StreamDialer) error {
+	return CheckTCPConnectivityWithHTTP(ctx, tcp, testTCPWebsite)
+}
+
+// CheckUDPConnectivity checks whether the given `udp` client can relay UDP traffic, by issuing a
+// DNS query to a well-known resolver. It returns ctx.Err() if ctx is done first.
+func CheckUDPConnectivity(ctx context.Context, udp transport.PacketListener) error {
+	resolverAddr := &net.UDPAddr{IP: net.ParseIP(testDNSServerIP), Port: testDNSServerPort}
+	return CheckUDPConnectivityWithDNS(ctx, udp, resolverAddr)
+}
+
 // CheckUDPConnectivityWithDNS determines whether the Outline proxy represented by `client` and
 // the network support UDP traffic by issuing a DNS query though a resolver at `resolverAddr`.
-// Returns nil on success or an error on failure.
-func CheckUDPConnectivityWithDNS(client transport.PacketListener, resolverAddr net.Addr) error {
-	conn, err := client.ListenPacket(context.Background())
+// Returns nil on success, ctx.Err() if ctx is done first, or an error on failure.
+func CheckUDPConnectivityWithDNS(ctx context.Context, client transport.PacketListener, resolverAddr net.Addr) error {
+	conn, err := client.ListenPacket(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
 		return platerrors.PlatformError{
 			Code:    platerrors.ProxyServerUDPUnsupported,
 			Message: "failed to listen for UDP packets",
 			Cause:   platerrors.ToPlatformError(err),
-		}
+		}.WithHint(platerrors.HintEnableUDPOverTCP)
 	}
 	defer conn.Close()
+	// Closing the connection interrupts the pending read.
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
 
 	buf := make([]byte, bufferLength)
-	for attempt := 0; attempt < udpMaxRetryAttempts; attempt++ {
+	for attempt := 0; attempt < udpMaxRetryAttempts && ctx.Err() == nil; attempt++ {
 		conn.SetDeadline(time.Now().Add(udpTimeout))
 		_, err := conn.WriteTo(getDNSRequest(), resolverAddr)
 		if err != nil {
@@ -87,21 +108,24 @@ func CheckUDPConnectivityWithDNS(client transport.PacketListener, resolverAddr n
 		}
 		return nil
 	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	return platerrors.PlatformError{
 		Code:    platerrors.ProxyServerUDPUnsupported,
 		Message: "UDP connectivity check timed out",
-	}
+	}.WithHint(platerrors.HintEnableUDPOverTCP)
 }
 
 // CheckTCPConnectivityWithHTTP determines whether the proxy is reachable over TCP and validates the
 // client's authentication credentials by performing an HTTP HEAD request to `targetURL`, which must
 // be of the form: http://[host](:[port])(/[path]).
 //
-// Returns nil on success, error on connectivity failure.
-func CheckTCPConnectivityWithHTTP(dialer transport.StreamDialer, targetURL string) error {
+// Returns nil on success, ctx.Err() if ctx is done first, or an error on connectivity failure.
+func CheckTCPConnectivityWithHTTP(parent context.Context, dialer transport.StreamDialer, targetURL string) error {
 	deadline := time.Now().Add(tcpTimeout)
-	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	ctx, cancel := context.WithDeadline(parent, deadline)
 	defer cancel()
 	req, err := http.NewRequest("HEAD", targetURL, nil)
 	if err != nil {
@@ -112,16 +136,35 @@ func CheckTCPConnectivityWithHTTP(dialer transport.StreamDialer, targetURL strin
 		targetAddr = net.JoinHostPort(targetAddr, "80")
 	}
 	conn, err := dialer.DialStream(ctx, targetAddr)
+	if parent.Err() != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return parent.Err()
+	}
 	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return platerrors.PlatformError{
+				Code:    platerrors.ResolveIPFailed,
+				Message: "failed to resolve the server",
+				Cause:   platerrors.ToPlatformError(err),
+			}.WithHint(platerrors.HintCheckInternet)
+		}
 		return platerrors.PlatformError{
 			Code:    platerrors.ProxyServerUnreachable,
 			Message: "failed to dial to the server",
 			Cause:   platerrors.ToPlatformError(err),
-		}
+		}.WithHint(platerrors.HintCheckInternet)
 	}
 	defer conn.Close()
+	// Closing the connection interrupts the pending write or read.
+	defer context.AfterFunc(parent, func() { conn.Close() })()
 	conn.SetDeadline(deadline)
 	err = req.Write(conn)
+	if parent.Err() != nil {
+		return parent.Err()
+	}
 	if err != nil {
 		return platerrors.PlatformError{
 			Code:    platerrors.ProxyServerWriteFailed,
@@ -130,7 +173,18 @@ func CheckTCPConnectivityWithHTTP(dialer transport.StreamDialer, targetURL strin
 		}
 	}
 	n, err := conn.Read(make([]byte, bufferLength))
+	if parent.Err() != nil {
+		return parent.Err()
+	}
 	if n == 0 && err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+			// The server closes the connections it cannot authenticate.
+			return platerrors.PlatformError{
+				Code:    platerrors.Unauthenticated,
+				Message: "the server closed the connection, the access key may be wrong",
+				Cause:   platerrors.ToPlatformError(err),
+			}.WithHint(platerrors.HintCheckAccessKey)
+		}
 		return platerrors.PlatformError{
 			Code:    platerrors.ProxyServerReadFailed,
 			Message: "failed to read HTTP HEAD response from the server",
@@ -17,6 +17,7 @@ package connectivity
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -29,7 +30,7 @@ import (
 
 func TestCheckUDPConnectivityWithDNS_Success(t *testing.T) {
 	client := &fakeSSClient{}
-	err := CheckUDPConnectivityWithDNS(client, &net.UDPAddr{})
+	err := CheckUDPConnectivityWithDNS(context.Background(), client, &net.UDPAddr{})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -37,7 +38,7 @@ func TestCheckUDPConnectivityWithDNS_Success(t *testing.T) {
 
 func TestCheckUDPConnectivityWithDNS_Fail(t *testing.T) {
 	client := &fakeSSClient{failUDP: true}
-	err := CheckUDPConnectivityWithDNS(client, &net.UDPAddr{})
+	err := CheckUDPConnectivityWithDNS(context.Background(), client, &net.UDPAddr{})
 	if err == nil {
 		t.Fail()
 	}
@@ -45,7 +46,7 @@ func TestCheckUDPConnectivityWithDNS_Fail(t *testing.T) {
 
 func TestCheckTCPConnectivityWithHTTP_Success(t *testing.T) {
 	client := &fakeSSClient{}
-	err := CheckTCPConnectivityWithHTTP(client, "")
+	err := CheckTCPConnectivityWithHTTP(context.Background(), client, "")
 	if err != nil {
 		t.Fail()
 	}
@@ -53,24 +54,59 @@ func TestCheckTCPConnectivityWithHTTP_Success(t *testing.T) {
 
 func TestCheckTCPConnectivityWithHTTP_FailReachability(t *testing.T) {
 	client := &fakeSSClient{failReachability: true}
-	err := CheckTCPConnectivityWithHTTP(client, "")
+	err := CheckTCPConnectivityWithHTTP(context.Background(), client, "")
 	require.Error(t, err)
 	perr := platerrors.ToPlatformError(err)
 	require.Equal(t, platerrors.ProxyServerUnreachable, perr.Code)
+	require.Equal(t, platerrors.HintCheckInternet, perr.Details[platerrors.HintDetailsKey])
+}
+
+func TestCheckTCPConnectivityWithHTTP_FailResolve(t *testing.T) {
+	client := &fakeSSClient{failResolve: true}
+	err := CheckTCPConnectivityWithHTTP(context.Background(), client, "")
+	require.Error(t, err)
+	perr := platerrors.ToPlatformError(err)
+	require.Equal(t, platerrors.ResolveIPFailed, perr.Code)
+	require.Equal(t, platerrors.HintCheckInternet, perr.Details[platerrors.HintDetailsKey])
 }
 
 func TestCheckTCPConnectivityWithHTTP_FailAuthentication(t *testing.T) {
 	client := &fakeSSClient{failAuthentication: true}
-	err := CheckTCPConnectivityWithHTTP(client, "")
+	err := CheckTCPConnectivityWithHTTP(context.Background(), client, "")
 	require.Error(t, err)
 	perr := platerrors.ToPlatformError(err)
 	require.Equal(t, platerrors.ProxyServerReadFailed, perr.Code)
 }
 
+func TestCheckTCPConnectivityWithHTTP_ConnectionClosed(t *testing.T) {
+	client := &fakeSSClient{closeOnRead: true}
+	err := CheckTCPConnectivityWithHTTP(context.Background(), client, "")
+	require.Error(t, err)
+	perr := platerrors.ToPlatformError(err)
+	require.Equal(t, platerrors.Unauthenticated, perr.Code)
+	require.Equal(t, platerrors.HintCheckAccessKey, perr.Details[platerrors.HintDetailsKey])
+}
+
+func TestCheckTCPConnectivityWithHTTP_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := CheckTCPConnectivityWithHTTP(ctx, &fakeSSClient{}, "")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCheckUDPConnectivityWithDNS_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := CheckUDPConnectivityWithDNS(ctx, &fakeSSClient{}, &net.UDPAddr{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 // Fake shadowsocks.Client that can be configured to return failing UDP and TCP connections.
 type fakeSSClient struct {
 	failReachability   bool
 	failAuthentication bool
+	failResolve        bool
+	closeOnRead        bool
 	failUDP            bool
 }
 
@@ -79,7 +115,10 @@ func (c *fakeSSClient) DialStream(_ context.Context, raddr string) (transport.St
 		// OpError.Error() panics if Err is nil.
 		return nil, &net.OpError{Err: errors.New("unreachable fakeSSClient")}
 	}
-	return &fakeDuplexConn{failRead: c.failAuthentication}, nil
+	if c.failResolve {
+		return nil, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: raddr, IsNotFound: true}}
+	}
+	return &fakeDuplexConn{failRead: c.failAuthentication, closeOnRead: c.closeOnRead}, nil
 }
 func (c *fakeSSClient) ListenPacket(_ context.Context) (net.PacketConn, error) {
 	conn, err := net.ListenPacket("udp", "")
@@ -115,10 +154,14 @@ func (c *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 // Fake DuplexConn that fails `Read` calls when `failRead` is true.
 type fakeDuplexConn struct {
 	transport.StreamConn
-	failRead bool
+	failRead    bool
+	closeOnRead bool
 }
 
 func (c *fakeDuplexConn) Read(b []byte) (int, error) {
+	if c.closeOnRead {
+		return 0, io.EOF
+	}
 	if c.failRead {
 		return 0, errors.New("Fake read error")
 	}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeShadowsocksServer starts a Shadowsocks server answering the HTTP requests of the TCP
+// connectivity checks itself, instead of relaying them.
+func startFakeShadowsocksServer(t *testing.T, method, password string) string {
+	key, err := shadowsocks.NewEncryptionKey(method, password)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(shadowsocks.NewReader(conn, key))
+				if _, err := wire.SOCKSAddr.Read(reader); err != nil {
+					return
+				}
+				if _, err := http.ReadRequest(reader); err != nil {
+					return
+				}
+				fmt.Fprint(shadowsocks.NewWriter(conn, key), "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRunConnectivityTest_RemoteAddress(t *testing.T) {
+	address := startFakeShadowsocksServer(t, "chacha20-ietf-poly1305", "secret")
+	host, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	res, err := runConnectivityTest(context.Background(),
+		fmt.Sprintf(`{"host": %q, "port": %s, "method": "chacha20-ietf-poly1305", "password": "secret"}`, host, port))
+	require.NoError(t, err)
+	require.True(t, res.TCP.Success, res.TCP.Error)
+	require.Equal(t, address, res.RemoteAddress)
+
+	// The address isn't reported when the connection failed, e.g. to a server with another password.
+	res, err = runConnectivityTest(context.Background(),
+		fmt.Sprintf(`{"host": %q, "port": %s, "method": "chacha20-ietf-poly1305", "password": "wrong"}`, host, port))
+	require.NoError(t, err)
+	require.False(t, res.TCP.Success)
+	require.Empty(t, res.RemoteAddress)
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client calls the methods of a control channel [Server].
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID uint64
+}
+
+// Dial connects to the control channel served on the Unix domain socket, or the Windows named
+// pipe, at path.
+func Dial(ctx context.Context, path string) (*Client, error) {
+	conn, err := dial(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Call calls method with params, and decodes its result into result if it is not nil. The error
+// of a failed method is a *platerrors.PlatformError. Calls of the same client are serialized.
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	req := Request{Method: method}
+	if params != nil {
+		var err error
+		if req.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	req.ID = c.nextID
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(aLongTimeAgo) })
+	defer stop()
+
+	if err := writeMessage(c.conn, req); err != nil {
+		return contextError(ctx, err)
+	}
+	var resp Response
+	if err := readMessage(c.conn, &resp); err != nil {
+		return contextError(ctx, err)
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("response %d doesn't match request %d", resp.ID, req.ID)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil && resp.Result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// aLongTimeAgo is a deadline in the past, to interrupt the pending reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// contextError returns the error of ctx if it is done, since it caused err, or else err.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control implements a local control channel for the desktop backend, so that the
+// Electron app and third-party tools can connect, disconnect and monitor a running tunnel
+// without parsing its standard output.
+//
+// The channel is a Unix domain socket, or a named pipe on Windows, carrying length-delimited
+// messages: each message is a 4-byte big-endian length followed by a JSON object of that length.
+// A client sends [Request] messages and receives one [Response] per request, in order.
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// Methods of the control channel.
+const (
+	// MethodConnect connects the tunnel, or relays its traffic through a new transport if it is
+	// already connected.
+	//
+	//  - Params: a [ConnectParams]
+	//  - Result: null
+	MethodConnect = "Connect"
+
+	// MethodDisconnect disconnects the tunnel.
+	//
+	//  - Params: null
+	//  - Result: null
+	MethodDisconnect = "Disconnect"
+
+	// MethodStatus returns the status of the tunnel.
+	//
+	//  - Params: null
+	//  - Result: a [Status]
+	MethodStatus = "Status"
+
+	// MethodStats returns the traffic statistics of the tunnel.
+	//
+	//  - Params: null
+	//  - Result: a stats.Snapshot
+	MethodStats = "Stats"
+)
+
+// maxMessageSize is the maximum size of the messages, to bound the memory of a connection.
+const maxMessageSize = 1 << 20
+
+// Request is a call of a method of the control channel.
+type Request struct {
+	// ID is chosen by the client, and copied to the response.
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the result of a [Request].
+type Response struct {
+	ID     uint64                    `json:"id"`
+	Result json.RawMessage           `json:"result,omitempty"`
+	Error  *platerrors.PlatformError `json:"error,omitempty"`
+}
+
+// ConnectParams are the params of [MethodConnect].
+type ConnectParams struct {
+	// Transport is the transport config of the tunnel.
+	Transport string `json:"transport"`
+
+	// DNSFallback relays the DNS queries over TCP instead of the UDP traffic, for the servers or
+	// networks that don't support UDP.
+	DNSFallback bool `json:"dnsFallback,omitempty"`
+}
+
+// Status is the result of [MethodStatus].
+type Status struct {
+	Connected bool `json:"connected"`
+
+	// Since is when the tunnel started using its current transport, or zero if it's not
+	// connected.
+	Since time.Time `json:"since"`
+}
+
+// writeMessage writes v as a length-delimited JSON message.
+func writeMessage(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds the maximum of %d", len(data), maxMessageSize)
+	}
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = w.Write(append(msg, data...))
+	return err
+}
+
+// readMessage reads a length-delimited JSON message into v. It returns io.EOF if the connection
+// is closed between messages.
+func readMessage(r io.Reader, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds the maximum of %d", size, maxMessageSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
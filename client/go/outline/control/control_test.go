@@ -0,0 +1,171 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/leakcheck"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHandler struct {
+	mu           sync.Mutex
+	transport    string
+	disconnected bool
+	since        time.Time
+}
+
+func (h *fakeHandler) Connect(_ context.Context, params ConnectParams) error {
+	if params.Transport == "" {
+		return platerrors.PlatformError{Code: platerrors.IllegalConfig, Message: "transport config missing"}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transport = params.Transport
+	return nil
+}
+
+func (h *fakeHandler) Disconnect(context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnected = true
+	return nil
+}
+
+func (h *fakeHandler) Status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Status{Connected: !h.disconnected, Since: h.since}
+}
+
+func (h *fakeHandler) Stats() stats.Snapshot {
+	return stats.Snapshot{ActiveTCPConns: 2}
+}
+
+func newTestServer(t *testing.T, h Handler) (*Server, *Client) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	server, err := Listen(path, h)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	client, err := Dial(context.Background(), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestServer_Methods(t *testing.T) {
+	leakcheck.Check(t)
+	h := &fakeHandler{since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	_, client := newTestServer(t, h)
+	ctx := context.Background()
+
+	var status Status
+	require.NoError(t, client.Call(ctx, MethodStatus, nil, &status))
+	require.Equal(t, Status{Connected: true, Since: h.since}, status)
+
+	require.NoError(t, client.Call(ctx, MethodConnect, ConnectParams{Transport: `{"host": "example.com"}`}, nil))
+	require.Equal(t, `{"host": "example.com"}`, h.transport)
+
+	var snapshot stats.Snapshot
+	require.NoError(t, client.Call(ctx, MethodStats, nil, &snapshot))
+	require.Equal(t, int64(2), snapshot.ActiveTCPConns)
+
+	require.NoError(t, client.Call(ctx, MethodDisconnect, nil, nil))
+	require.NoError(t, client.Call(ctx, MethodStatus, nil, &status))
+	require.False(t, status.Connected)
+}
+
+func TestServer_Errors(t *testing.T) {
+	_, client := newTestServer(t, &fakeHandler{})
+	ctx := context.Background()
+
+	tests := []struct {
+		method string
+		params any
+		code   platerrors.ErrorCode
+	}{
+		{"Reboot", nil, platerrors.UnknownMethod},
+		{MethodConnect, "not an object", platerrors.InvalidMethodArguments},
+		{MethodConnect, ConnectParams{}, platerrors.IllegalConfig},
+	}
+	for _, tc := range tests {
+		err := client.Call(ctx, tc.method, tc.params, nil)
+		var perr *platerrors.PlatformError
+		require.ErrorAs(t, err, &perr, tc.method)
+		require.Equal(t, tc.code, perr.Code, tc.method)
+	}
+
+	// The connection is still usable after the errors.
+	var status Status
+	require.NoError(t, client.Call(ctx, MethodStatus, nil, &status))
+}
+
+func TestListen_ReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	first, err := Listen(path, &fakeHandler{})
+	require.NoError(t, err)
+	// Simulate a crash, which leaves the socket file behind.
+	first.listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	require.NoError(t, first.Close())
+
+	second, err := Listen(path, &fakeHandler{})
+	require.NoError(t, err)
+	defer second.Close()
+	client, err := Dial(context.Background(), path)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Call(context.Background(), MethodStatus, nil, nil))
+}
+
+func TestClient_CallCanceled(t *testing.T) {
+	_, client := newTestServer(t, &blockingHandler{fakeHandler: &fakeHandler{}, release: make(chan struct{})})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.Call(ctx, MethodDisconnect, nil, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// blockingHandler blocks Disconnect until released or the server is closed.
+type blockingHandler struct {
+	*fakeHandler
+	release chan struct{}
+}
+
+func (h *blockingHandler) Disconnect(ctx context.Context) error {
+	select {
+	case <-h.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestReadMessage_TooLarge(t *testing.T) {
+	var req Request
+	err := readMessage(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), &req)
+	require.Error(t, err)
+
+	err = readMessage(bytes.NewReader([]byte{0, 0, 0, 10, '{'}), &req)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
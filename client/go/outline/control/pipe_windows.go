@@ -0,0 +1,461 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeSDDL only grants access to the pipe to the system, the administrators, and the allowed user
+// with the ACE of pipeUserACE, so that the app of the user who installed a service can control
+// the tunnel it runs. The other users of the machine must not: the service runs as LocalSystem.
+const pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+
+// pipeUserACE is the ACE of the allowed user, formatted with its SID.
+const pipeUserACE = "(A;;GRGW;;;%s)"
+
+const pipeBufferSize = 64 << 10
+
+// pipeRetryInterval is how often [dial] retries while all the instances of the pipe are busy.
+const pipeRetryInterval = 10 * time.Millisecond
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeListener accepts the connections to a named pipe. It keeps an instance of the pipe waiting
+// for the next client between the calls to Accept, so that clients don't find the pipe missing.
+type pipeListener struct {
+	path string
+	sa   *windows.SecurityAttributes
+	user *windows.SID // The allowed user.
+
+	mu      sync.Mutex
+	closed  bool
+	next    windows.Handle // The instance for the next client, if not 0.
+	pending *pipeOp        // The pending ConnectNamedPipe, if any.
+}
+
+func listen(path, user string) (net.Listener, error) {
+	sid, err := pipeUser(user)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL + fmt.Sprintf(pipeUserACE, sid))
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeListener{
+		path: path,
+		sa:   &windows.SecurityAttributes{SecurityDescriptor: sd},
+		user: sid,
+	}
+	l.sa.Length = uint32(unsafe.Sizeof(*l.sa))
+	// The first instance fails if another process already serves the pipe.
+	if l.next, err = l.createInstance(windows.FILE_FLAG_FIRST_PIPE_INSTANCE); err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(path), Err: err}
+	}
+	return l, nil
+}
+
+// pipeUser returns the SID of the user allowed to use the pipe: user, or the user of the current
+// process if empty.
+func pipeUser(user string) (*windows.SID, error) {
+	if user != "" {
+		sid, err := windows.StringToSid(user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SID of the user of the pipe: %w", err)
+		}
+		return sid, nil
+	}
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	return tokenUser.User.Sid.Copy()
+}
+
+func (l *pipeListener) createInstance(flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.path)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateNamedPipe(name,
+		windows.PIPE_ACCESS_DUPLEX|windows.FILE_FLAG_OVERLAPPED|flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, l.sa)
+}
+
+// errClientNotAllowed is returned by [pipeListener.accept] for the clients whose processes don't
+// run as the system, an administrator or the allowed user.
+var errClientNotAllowed = errors.New("client of the pipe is not allowed")
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.accept()
+		if errors.Is(err, errClientNotAllowed) {
+			logger.Warn("rejected a client of the control pipe", "err", err)
+			continue
+		}
+		return conn, err
+	}
+}
+
+func (l *pipeListener) accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	handle := l.next
+	l.next = 0
+	var err error
+	if handle == 0 {
+		if handle, err = l.createInstance(0); err != nil {
+			l.mu.Unlock()
+			return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+		}
+	}
+	op, err := newPipeOp()
+	if err != nil {
+		l.mu.Unlock()
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	defer op.close()
+	op.handle = handle
+	err = windows.ConnectNamedPipe(handle, &op.ov)
+	l.pending = op
+	l.mu.Unlock()
+
+	switch err {
+	case windows.ERROR_PIPE_CONNECTED:
+		err = nil
+	case windows.ERROR_IO_PENDING:
+		err = op.wait(handle)
+	}
+
+	l.mu.Lock()
+	l.pending = nil
+	if err == nil && !l.closed {
+		// Errors are returned by the next call to Accept, which tries again.
+		l.next, _ = l.createInstance(0)
+	}
+	closed := l.closed
+	l.mu.Unlock()
+	if err != nil || closed {
+		windows.CloseHandle(handle)
+		if closed || errors.Is(err, windows.ERROR_OPERATION_ABORTED) {
+			return nil, net.ErrClosed
+		}
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+	if err := l.checkClient(handle); err != nil {
+		windows.DisconnectNamedPipe(handle)
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	return newPipeConn(handle, l.path, true), nil
+}
+
+var procGetNamedPipeClientProcessId = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetNamedPipeClientProcessId")
+
+// checkClient checks that the process of the client connected to handle runs as the system, an
+// elevated administrator or the allowed user. The DACL of the pipe is only checked when the
+// client opens it, not when a handle is inherited or duplicated by another process.
+func (l *pipeListener) checkClient(handle windows.Handle) error {
+	var pid uint32
+	if r, _, err := procGetNamedPipeClientProcessId.Call(uintptr(handle), uintptr(unsafe.Pointer(&pid))); r == 0 {
+		return fmt.Errorf("%w: failed to get its process: %w", errClientNotAllowed, err)
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open process %d: %w", errClientNotAllowed, pid, err)
+	}
+	defer windows.CloseHandle(process)
+	var token windows.Token
+	if err := windows.OpenProcessToken(process, windows.TOKEN_QUERY, &token); err != nil {
+		return fmt.Errorf("%w: failed to open the token of process %d: %w", errClientNotAllowed, pid, err)
+	}
+	defer token.Close()
+	tokenUser, err := token.GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("%w: failed to get the user of process %d: %w", errClientNotAllowed, pid, err)
+	}
+	sid := tokenUser.User.Sid
+	if sid.Equals(l.user) || sid.IsWellKnown(windows.WinLocalSystemSid) || token.IsElevated() {
+		return nil
+	}
+	return fmt.Errorf("%w: process %d runs as %v", errClientNotAllowed, pid, sid)
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	if l.pending != nil {
+		// The handle is closed by Accept, once the operation is canceled.
+		windows.CancelIoEx(l.pending.handle, &l.pending.ov)
+	}
+	if l.next != 0 {
+		windows.CloseHandle(l.next)
+		l.next = 0
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// SECURITY_IDENTIFICATION prevents the server from impersonating the client.
+		handle, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+			windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED|windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		if err == nil {
+			return newPipeConn(handle, path, false), nil
+		}
+		// The pipe is missing while the server creates the instance for the next client, and busy
+		// while all its instances are connected.
+		if !errors.Is(err, windows.ERROR_PIPE_BUSY) && !errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(path), Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pipeRetryInterval):
+		}
+	}
+}
+
+// pipeOp is an overlapped operation on a pipe handle.
+type pipeOp struct {
+	ov     windows.Overlapped
+	handle windows.Handle // Only set for ConnectNamedPipe.
+}
+
+func newPipeOp() (*pipeOp, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeOp{ov: windows.Overlapped{HEvent: event}}, nil
+}
+
+// wait waits for the operation started on handle to complete.
+func (op *pipeOp) wait(handle windows.Handle) error {
+	var n uint32
+	return windows.GetOverlappedResult(handle, &op.ov, &n, true)
+}
+
+func (op *pipeOp) close() {
+	windows.CloseHandle(op.ov.HEvent)
+}
+
+// pipeDirection is the state of the reads or writes of a [pipeConn].
+type pipeDirection struct {
+	deadline time.Time
+	op       *pipeOp // The pending operation, if any.
+	timer    *time.Timer
+	timedOut bool
+}
+
+// pipeConn is a connection to a named pipe, opened for overlapped I/O so that its operations can
+// be canceled by Close and the deadlines.
+type pipeConn struct {
+	handle   windows.Handle
+	path     string
+	isServer bool
+
+	mu      sync.Mutex
+	closed  bool
+	read    pipeDirection
+	write   pipeDirection
+	pending sync.WaitGroup
+}
+
+var _ net.Conn = (*pipeConn)(nil)
+
+func newPipeConn(handle windows.Handle, path string, isServer bool) *pipeConn {
+	return &pipeConn{handle: handle, path: path, isServer: isServer}
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := c.do(&c.read, func(op *pipeOp, done *uint32) error {
+		return windows.ReadFile(c.handle, b, done, &op.ov)
+	})
+	if errors.Is(err, windows.ERROR_BROKEN_PIPE) || errors.Is(err, windows.ERROR_PIPE_NOT_CONNECTED) {
+		return n, io.EOF
+	}
+	return n, c.opError("read", err)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	n, err := c.do(&c.write, func(op *pipeOp, done *uint32) error {
+		return windows.WriteFile(c.handle, b, done, &op.ov)
+	})
+	return n, c.opError("write", err)
+}
+
+// do runs the overlapped operation start in direction d, until it completes, fails, or is
+// canceled by Close or the deadline of d.
+func (c *pipeConn) do(d *pipeDirection, start func(op *pipeOp, done *uint32) error) (int, error) {
+	op, err := newPipeOp()
+	if err != nil {
+		return 0, err
+	}
+	defer op.close()
+
+	// The operation starts with the lock held, so that Close and the deadlines can't miss it.
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if !d.deadline.IsZero() && !time.Now().Before(d.deadline) {
+		c.mu.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	var done uint32
+	err = start(op, &done)
+	if err == windows.ERROR_IO_PENDING {
+		d.op, d.timedOut = op, false
+		if !d.deadline.IsZero() {
+			d.timer = time.AfterFunc(time.Until(d.deadline), func() { c.expire(d, op) })
+		}
+		c.pending.Add(1)
+		c.mu.Unlock()
+
+		err = windows.GetOverlappedResult(c.handle, &op.ov, &done, true)
+
+		c.mu.Lock()
+		d.op = nil
+		if d.timer != nil {
+			d.timer.Stop()
+			d.timer = nil
+		}
+		if errors.Is(err, windows.ERROR_OPERATION_ABORTED) {
+			if d.timedOut {
+				err = os.ErrDeadlineExceeded
+			} else {
+				err = net.ErrClosed
+			}
+		}
+		c.pending.Done()
+	}
+	c.mu.Unlock()
+	return int(done), err
+}
+
+// expire cancels op if it is still the pending operation of d.
+func (c *pipeConn) expire(d *pipeDirection, op *pipeOp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.op == op {
+		d.timedOut = true
+		windows.CancelIoEx(c.handle, &op.ov)
+	}
+}
+
+// setDeadline sets the deadline of d, and applies it to the pending operation, if any.
+func (c *pipeConn) setDeadline(d *pipeDirection, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d.deadline = t
+	if d.op == nil {
+		return
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	if !time.Now().Before(t) {
+		d.timedOut = true
+		windows.CancelIoEx(c.handle, &d.op.ov)
+		return
+	}
+	op := d.op
+	d.timer = time.AfterFunc(time.Until(t), func() { c.expire(d, op) })
+}
+
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	windows.CancelIoEx(c.handle, nil)
+	c.mu.Unlock()
+
+	c.pending.Wait()
+	if c.isServer {
+		windows.DisconnectNamedPipe(c.handle)
+	}
+	return windows.CloseHandle(c.handle)
+}
+
+func (c *pipeConn) opError(op string, err error) error {
+	if err == nil || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	return &net.OpError{Op: op, Net: "pipe", Addr: pipeAddr(c.path), Err: err}
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(c.path) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(c.path) }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.setDeadline(&c.read, t)
+	c.setDeadline(&c.write, t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(&c.read, t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(&c.write, t)
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+var logger = logging.Module("control")
+
+// Handler implements the methods of the control channel.
+type Handler interface {
+	Connect(ctx context.Context, params ConnectParams) error
+	Disconnect(ctx context.Context) error
+	Status() Status
+	Stats() stats.Snapshot
+}
+
+// Server serves the control channel on a Unix domain socket, or a named pipe on Windows.
+type Server struct {
+	listener net.Listener
+	handler  Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// ListenConfig configures the access to the control channel.
+type ListenConfig struct {
+	// User is the SID of the Windows user allowed to use the named pipe, besides the system and
+	// the administrators, like the user who installed a service. Defaults to the user of the
+	// current process. It's ignored by the Unix domain sockets, only accessible to the current
+	// user.
+	User string
+}
+
+// Listen serves the control channel with h on path, with the default [ListenConfig].
+func Listen(path string, h Handler) (*Server, error) {
+	return ListenConfig{}.Listen(path, h)
+}
+
+// Listen serves the control channel with h on path: a Unix domain socket only accessible to the
+// current user, which replaces a stale socket left by a crashed process, or on Windows a named
+// pipe like \\.\pipe\Name, only accessible to the system, the administrators and lc.User. On
+// Windows, the processes of the clients are checked again when they connect.
+func (lc ListenConfig) Listen(path string, h Handler) (*Server, error) {
+	listener, err := listen(path, lc.User)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, handler: h, conns: make(map[net.Conn]struct{})}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	resources.Go(resources.SubsystemControl, s.serve)
+	return s, nil
+}
+
+// Addr returns the address of the socket.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, closes the active ones, and removes the socket.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.cancel()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Warn("control channel stopped accepting connections", "err", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		resources.Go(resources.SubsystemControl, func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.serveConn(conn)
+		})
+	}
+}
+
+// serveConn handles the requests of conn one by one, until it is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	for {
+		var req Request
+		if err := readMessage(conn, &req); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Warn("failed to read control request", "err", err)
+			}
+			return
+		}
+		result, err := s.handle(req)
+		resp := Response{ID: req.ID, Error: platerrors.ToPlatformError(err)}
+		if err == nil && result != nil {
+			if resp.Result, err = json.Marshal(result); err != nil {
+				resp.Error = &platerrors.PlatformError{
+					Code:    platerrors.InternalError,
+					Message: "failed to marshal control result",
+					Cause:   platerrors.ToPlatformError(err),
+				}
+			}
+		}
+		if err := writeMessage(conn, resp); err != nil {
+			logger.Warn("failed to write control response", "err", err)
+			return
+		}
+	}
+}
+
+// handle runs the method of req, and returns its result.
+func (s *Server) handle(req Request) (any, error) {
+	switch req.Method {
+	case MethodConnect:
+		var params ConnectParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, platerrors.PlatformError{
+				Code:    platerrors.InvalidMethodArguments,
+				Message: "invalid params of Connect",
+				Details: platerrors.ErrorDetails{"method": req.Method},
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+		return nil, s.handler.Connect(s.ctx, params)
+	case MethodDisconnect:
+		return nil, s.handler.Disconnect(s.ctx)
+	case MethodStatus:
+		return s.handler.Status(), nil
+	case MethodStats:
+		return s.handler.Stats(), nil
+	default:
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.UnknownMethod,
+			Message: fmt.Sprintf("unsupported control method: %s", req.Method),
+			Details: platerrors.ErrorDetails{"method": req.Method},
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// deepLinkJSON is the output of [MethodParseDeepLink].
+type deepLinkJSON struct {
+	// AccessKey is the ss:// or ssconf:// access key of the link.
+	AccessKey string `json:"accessKey"`
+
+	// Name is the name of the server given by the link, if any.
+	Name string `json:"name,omitempty"`
+}
+
+// qrPayloadJSON is the JSON payload of the Outline QR codes. Plain QR codes only contain the
+// access key.
+type qrPayloadJSON struct {
+	AccessKey string `json:"accessKey"`
+	Name      string `json:"name"`
+}
+
+// parseDeepLink extracts the access key of a link opened with the app or of a scanned QR code,
+// and returns it as a JSON string of deepLinkJSON. It supports:
+//
+//   - access keys: ss://... and ssconf://...
+//   - outline:// links: outline://add?key=ENCODEDKEY&name=NAME, or outline://#ENCODEDKEY
+//   - invite URLs: https://.../invite.html#ENCODEDKEY, or .../invite.html#/en/invite/ENCODEDKEY
+//   - QR payloads: {"accessKey": "ss://...", "name": "..."}
+func parseDeepLink(input string) (string, error) {
+	link, err := extractDeepLink(strings.TrimSpace(input))
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(link)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the deep link",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+func extractDeepLink(input string) (*deepLinkJSON, error) {
+	if strings.HasPrefix(input, "{") {
+		var payload qrPayloadJSON
+		if err := json.Unmarshal([]byte(input), &payload); err != nil {
+			return nil, newInvalidJSONError("QR code payload is not a valid JSON", input, err)
+		}
+		if !isAccessKey(payload.AccessKey) {
+			return nil, newInvalidDeepLinkError("QR code payload has no valid access key")
+		}
+		return &deepLinkJSON{AccessKey: payload.AccessKey, Name: payload.Name}, nil
+	}
+	if isAccessKey(input) {
+		return &deepLinkJSON{AccessKey: input}, nil
+	}
+
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, newInvalidDeepLinkError("link is not a valid URL")
+	}
+	if strings.EqualFold(u.Scheme, "outline") {
+		query := u.Query()
+		if key := query.Get("key"); key != "" {
+			if !isAccessKey(key) {
+				return nil, newInvalidDeepLinkError("outline:// link has no valid access key")
+			}
+			return &deepLinkJSON{AccessKey: key, Name: query.Get("name")}, nil
+		}
+	}
+	// Invite URLs, and outline:// links, may carry the key in the fragment. The invite website
+	// redirects invite.html#KEY to invite.html#/en/invite/KEY, so the key is searched anywhere in
+	// the fragment.
+	if fragment := u.Fragment; fragment != "" {
+		if i := strings.Index(fragment, "ss://"); i >= 0 {
+			if key := fragment[i:]; isAccessKey(key) {
+				return &deepLinkJSON{AccessKey: key}, nil
+			}
+		}
+	}
+	return nil, newInvalidDeepLinkError("link is neither an access key, an outline:// link nor an invite URL")
+}
+
+// isAccessKey returns whether key looks like a static or dynamic access key, or the URL of another
+// transport. It doesn't validate the key.
+func isAccessKey(key string) bool {
+	scheme, rest, ok := strings.Cut(key, "://")
+	_, isTransportURL := transportURLParsers[scheme]
+	return ok && rest != "" && (scheme == "ss" || scheme == "ssconf" || isTransportURL)
+}
+
+func newInvalidDeepLinkError(msg string) platerrors.PlatformError {
+	return platerrors.PlatformError{
+		Code:    platerrors.IllegalConfig,
+		Message: msg,
+	}
+}
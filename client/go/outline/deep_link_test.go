@@ -0,0 +1,82 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeepLink(t *testing.T) {
+	const key = "ss://YWVzLTEyOC1nY206cHc@example.com:443/?outline=1#My%20server"
+	encodedKey := url.QueryEscape(key)
+	tests := []struct {
+		name  string
+		input string
+		want  deepLinkJSON
+	}{
+		{name: "static key", input: " " + key + "\n", want: deepLinkJSON{AccessKey: key}},
+		{name: "dynamic key", input: "ssconf://example.com/key", want: deepLinkJSON{AccessKey: "ssconf://example.com/key"}},
+		{name: "hysteria2 URL", input: "hy2://secret@example.com", want: deepLinkJSON{AccessKey: "hy2://secret@example.com"}},
+		{
+			name:  "outline link",
+			input: "outline://add?key=" + encodedKey + "&name=Work",
+			want:  deepLinkJSON{AccessKey: key, Name: "Work"},
+		},
+		{name: "outline link fragment", input: "outline://#" + encodedKey, want: deepLinkJSON{AccessKey: key}},
+		{
+			name:  "invite",
+			input: "https://s3.amazonaws.com/outline-vpn/invite.html#" + encodedKey,
+			want:  deepLinkJSON{AccessKey: key},
+		},
+		{
+			name:  "redirected invite",
+			input: "https://s3.amazonaws.com/outline-vpn/invite.html#/en/invite/" + encodedKey,
+			want:  deepLinkJSON{AccessKey: key},
+		},
+		{
+			name:  "QR payload",
+			input: `{"accessKey": "ssconf://example.com/key", "name": "Home"}`,
+			want:  deepLinkJSON{AccessKey: "ssconf://example.com/key", Name: "Home"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := parseDeepLink(tt.input)
+			require.NoError(t, err)
+			var got deepLinkJSON
+			require.NoError(t, json.Unmarshal([]byte(out), &got))
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseDeepLink_Errors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"ss://",
+		"https://example.com/",
+		"https://s3.amazonaws.com/outline-vpn/invite.html#/en/invite/",
+		"outline://add?key=https%3A%2F%2Fexample.com",
+		`{"accessKey": "https://example.com"}`,
+		`{"accessKey":`,
+	} {
+		_, err := parseDeepLink(input)
+		require.Error(t, err, input)
+	}
+}
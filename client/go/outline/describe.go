@@ -0,0 +1,212 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/geoip"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+)
+
+// Types of the nodes of the transport graph.
+const (
+	transportNodeRouter             = "router"
+	transportNodeDirect             = "direct"
+	transportNodeShadowsocks        = "shadowsocks"
+	transportNodeUDPOverTCP         = "udp-over-tcp"
+	transportNodeEndpoint           = "endpoint"
+	transportNodePluggableTransport = "pluggable-transport"
+)
+
+// transportDescriptionJSON is the output of [MethodDescribeTransport]. It never contains secrets.
+type transportDescriptionJSON struct {
+	// Summary is a human-readable description, like "Shadowsocks (aes-256-gcm) via example.com:443".
+	Summary string `json:"summary"`
+
+	// Stream and Packet are the graphs of the TCP and UDP traffic.
+	Stream *transportNodeJSON `json:"stream"`
+	Packet *transportNodeJSON `json:"packet"`
+
+	// UDPFallback is the graph of the UDP traffic when UDP is blocked, if UDP-over-TCP is enabled.
+	UDPFallback *transportNodeJSON `json:"udpFallback,omitempty"`
+
+	// Resolvers are the resolvers of the DNS queries of the tunnel, empty if they are relayed.
+	Resolvers []string `json:"resolvers,omitempty"`
+
+	// QUIC is the QUIC policy.
+	QUIC string `json:"quic"`
+
+	// Location is the country and autonomous system of the proxy server, if its host is an IP
+	// address known to the databases of [MethodSetGeoIPDatabases].
+	Location *geoip.Info `json:"location,omitempty"`
+}
+
+// transportNodeJSON is a node of a transport graph, like a protocol or a router, with the nodes
+// it relays the traffic through.
+type transportNodeJSON struct {
+	Type string `json:"$type"`
+
+	// Address is the address of the "endpoint" nodes.
+	Address string `json:"address,omitempty"`
+	// Resolver resolves the host name of the "endpoint" nodes.
+	Resolver string `json:"resolver,omitempty"`
+
+	// Name is the transport name of the "pluggable-transport" nodes, like obfs4.
+	Name string `json:"name,omitempty"`
+
+	// Cipher and Obfs are the cipher and obfuscation type of the "shadowsocks" nodes.
+	Cipher string `json:"cipher,omitempty"`
+	Obfs   string `json:"obfs,omitempty"`
+
+	// Default and Rules are the default action and number of rules of the "router" nodes.
+	Default routing.Action `json:"default,omitempty"`
+	Rules   int            `json:"rules,omitempty"`
+
+	// Next are the nodes the traffic goes through next: the proxy and the direct nodes of the
+	// "router" nodes, or the underlying transport of the other nodes.
+	Next []*transportNodeJSON `json:"next,omitempty"`
+}
+
+// describe returns the transport graph of the config, which must be valid.
+func (conf *configJSON) describe() *transportDescriptionJSON {
+	cipher, ok := canonicalCipherName(conf.Method)
+	if !ok {
+		cipher = conf.Method
+	}
+	address := net.JoinHostPort(conf.Host, strconv.Itoa(int(conf.Port)))
+	resolver := "system"
+	if conf.DNS != nil && len(conf.DNS.resolverConfigs()) > 0 {
+		resolver = "dns"
+	}
+	if conf.HostResolution != nil && conf.HostResolution.Resolver == hostResolverDoH {
+		resolver = hostResolverDoH
+	}
+	shadowsocks := func(endpointType string) *transportNodeJSON {
+		if conf.Type != "" && conf.Type != transportTypeShadowsocks {
+			// The graphs of the registered transports are opaque.
+			return &transportNodeJSON{Type: conf.Type}
+		}
+		endpoint := &transportNodeJSON{Type: transportNodeEndpoint, Address: endpointType + "://" + address, Resolver: resolver}
+		if conf.PluggableTransport != nil && endpointType == "tcp" {
+			endpoint = &transportNodeJSON{Type: transportNodePluggableTransport, Name: conf.PluggableTransport.Name,
+				Next: []*transportNodeJSON{endpoint}}
+		}
+		node := &transportNodeJSON{Type: transportNodeShadowsocks, Cipher: cipher, Next: []*transportNodeJSON{endpoint}}
+		if obfs, err := conf.obfsConfig(); err == nil && obfs != nil {
+			node.Obfs = obfs.Type
+		}
+		return node
+	}
+	router := func(proxy *transportNodeJSON) *transportNodeJSON {
+		node := &transportNodeJSON{Type: transportNodeRouter, Default: routing.ActionProxy,
+			Next: []*transportNodeJSON{proxy, {Type: transportNodeDirect}}}
+		if conf.Routing != nil {
+			node.Rules = len(conf.Routing.Rules)
+			if conf.Routing.Default != "" {
+				node.Default = conf.Routing.Default
+			}
+		}
+		return node
+	}
+
+	desc := &transportDescriptionJSON{
+		Summary: fmt.Sprintf("Shadowsocks (%s) via %s", cipher, address),
+		Stream:  router(shadowsocks("tcp")),
+		Packet:  router(shadowsocks("udp")),
+		QUIC:    "allow",
+	}
+	if conf.Type != "" && conf.Type != transportTypeShadowsocks {
+		desc.Summary = fmt.Sprintf("%s transport", conf.Type)
+	}
+	if conf.UDPOverTCP {
+		desc.UDPFallback = router(&transportNodeJSON{Type: transportNodeUDPOverTCP, Next: []*transportNodeJSON{shadowsocks("tcp")}})
+	}
+	if strings.EqualFold(conf.QUIC, "block") {
+		desc.QUIC = "block"
+	}
+	if ip := net.ParseIP(conf.Host); ip != nil {
+		desc.Location = locateIP(ip)
+	}
+	if conf.DNS != nil {
+		for _, r := range conf.DNS.resolverConfigs() {
+			desc.Resolvers = append(desc.Resolvers, r.describe())
+		}
+		if len(conf.DNS.Rules) > 0 {
+			desc.Resolvers = append(desc.Resolvers, fmt.Sprintf("%d split-horizon rules", len(conf.DNS.Rules)))
+		}
+	}
+	return desc
+}
+
+// describe returns a short description of the resolver, like "doh:https://1.1.1.1/dns-query".
+func (r *dnsResolverJSON) describe() string {
+	if r.Type == dnsResolverTypeDoH {
+		return r.Type + ":" + r.URL
+	}
+	return r.Type + ":" + r.Address
+}
+
+// The description of the transport of the active VPN or local proxy, if any.
+var activeTransportMu sync.Mutex
+var activeTransport *transportDescriptionJSON
+
+// setActiveTransport sets the transport of the active VPN or local proxy.
+func setActiveTransport(desc *transportDescriptionJSON) {
+	activeTransportMu.Lock()
+	defer activeTransportMu.Unlock()
+	activeTransport = desc
+}
+
+// clearActiveTransport clears the active transport if it is still desc.
+func clearActiveTransport(desc *transportDescriptionJSON) {
+	activeTransportMu.Lock()
+	defer activeTransportMu.Unlock()
+	if activeTransport == desc {
+		activeTransport = nil
+	}
+}
+
+// describeTransport returns the JSON transportDescriptionJSON of the transport config input, or
+// of the active transport if input is empty. It returns "null" if there is no active transport.
+func describeTransport(input string) (string, error) {
+	var desc *transportDescriptionJSON
+	if input = strings.TrimSpace(input); input == "" || input == "null" {
+		activeTransportMu.Lock()
+		desc = activeTransport
+		activeTransportMu.Unlock()
+	} else {
+		client, err := newClientWithBaseDialers(input, net.Dialer{}, net.Dialer{})
+		if err != nil {
+			return "", err
+		}
+		desc = client.description
+	}
+	out, err := json.Marshal(desc)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal transport description",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
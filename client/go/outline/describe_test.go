@@ -0,0 +1,70 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_describeTransport(t *testing.T) {
+	got, err := describeTransport(`{"host":"example.com","port":443,"method":"AES-256-GCM","password":"secret",` +
+		`"obfs":{"$type":"padding","length":4},"udpOverTcp":true,"quic":"block",` +
+		`"routing":{"rules":[{"action":"direct","cidrs":["10.0.0.0/8"]}]},"dns":{"doh":"https://1.1.1.1/dns-query"}}`)
+	require.NoError(t, err)
+	require.NotContains(t, got, "secret")
+
+	ss := func(network string) string {
+		return `{"$type":"shadowsocks","cipher":"aes-256-gcm","obfs":"padding","next":[` +
+			`{"$type":"endpoint","address":"` + network + `://example.com:443","resolver":"dns"}]}`
+	}
+	router := func(proxy string) string {
+		return `{"$type":"router","default":"proxy","rules":1,"next":[` + proxy + `,{"$type":"direct"}]}`
+	}
+	require.JSONEq(t, `{
+		"summary": "Shadowsocks (aes-256-gcm) via example.com:443",
+		"stream": `+router(ss("tcp"))+`,
+		"packet": `+router(ss("udp"))+`,
+		"udpFallback": `+router(`{"$type":"udp-over-tcp","next":[`+ss("tcp")+`]}`)+`,
+		"resolvers": ["doh:https://1.1.1.1/dns-query"],
+		"quic": "block"
+	}`, got)
+}
+
+func Test_describeTransport_Active(t *testing.T) {
+	got, err := describeTransport("null")
+	require.NoError(t, err)
+	require.Equal(t, "null", got)
+
+	desc := &transportDescriptionJSON{Summary: "active", QUIC: "allow"}
+	setActiveTransport(desc)
+	got, err = describeTransport("")
+	require.NoError(t, err)
+	require.Contains(t, got, `"summary":"active"`)
+
+	clearActiveTransport(&transportDescriptionJSON{})
+	got, _ = describeTransport("")
+	require.Contains(t, got, `"summary":"active"`)
+
+	clearActiveTransport(desc)
+	got, _ = describeTransport("")
+	require.Equal(t, "null", got)
+}
+
+func Test_describeTransport_Invalid(t *testing.T) {
+	_, err := describeTransport(`{"host":"example.com"}`)
+	require.Error(t, err)
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// diagnosticsLogLimit is the default number of log records in a diagnostics bundle.
+const diagnosticsLogLimit = 500
+
+// diagnosticsRequestJSON is the input of [MethodCollectDiagnostics].
+type diagnosticsRequestJSON struct {
+	// Transport is the transport config of the server the user has trouble with. It's included
+	// redacted, and tested, if it's not empty.
+	Transport string `json:"transport,omitempty"`
+
+	// AppVersion is the version of the app, which the Go code doesn't know.
+	AppVersion string `json:"appVersion,omitempty"`
+
+	// LogLimit is the maximum number of log records. Defaults to 500.
+	LogLimit int `json:"logLimit,omitempty"`
+}
+
+// diagnosticsJSON is the output of [MethodCollectDiagnostics]. It doesn't contain secrets, or
+// the IP addresses of the device.
+type diagnosticsJSON struct {
+	Time    time.Time          `json:"time"`
+	Version diagnosticsVersion `json:"version"`
+
+	// Config is the redacted transport config of the request.
+	Config string `json:"config,omitempty"`
+
+	// Connectivity is the result of the connectivity test of the transport config, or
+	// ConnectivityError the reason why it could not be tested.
+	Connectivity      *connectivityTestResultJSON `json:"connectivity,omitempty"`
+	ConnectivityError *platerrors.PlatformError   `json:"connectivityError,omitempty"`
+
+	// ActiveTransport describes the transport of the active VPN or local proxy, if any.
+	ActiveTransport *transportDescriptionJSON `json:"activeTransport,omitempty"`
+	Stats           stats.Snapshot            `json:"stats"`
+
+	// TCPFailures are the failures of the TCP connections of the active tunnel, by endpoint: the
+	// proxy server for the relayed connections, and the destination for the direct ones. See
+	// [stats.TCPFailures].
+	TCPFailures []stats.TCPFailures `json:"tcpFailures"`
+
+	Interfaces []diagnosticsInterface `json:"interfaces"`
+	Logs       []logging.Entry        `json:"logs"`
+}
+
+type diagnosticsVersion struct {
+	App  string `json:"app,omitempty"`
+	Go   string `json:"go"`
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Modules are the versions of the main Go module and its dependencies that matter, e.g.
+	// the Outline SDK.
+	Modules map[string]string `json:"modules,omitempty"`
+}
+
+// diagnosticsInterface describes a network interface, without its addresses.
+type diagnosticsInterface struct {
+	Name  string `json:"name"`
+	MTU   int    `json:"mtu"`
+	Flags string `json:"flags"`
+	IPv4  bool   `json:"ipv4"`
+	IPv6  bool   `json:"ipv6"`
+}
+
+// diagnosticsModules are the dependencies whose versions are reported.
+var diagnosticsModules = []string{
+	"github.com/Jigsaw-Code/outline-sdk",
+	"github.com/eycorsican/go-tun2socks",
+}
+
+// collectDiagnostics parses the input as an optional diagnosticsRequestJSON, and returns a JSON
+// string of diagnosticsJSON, to attach to support tickets.
+func collectDiagnostics(ctx context.Context, input string) (string, error) {
+	var req diagnosticsRequestJSON
+	if input != "" {
+		if err := json.Unmarshal([]byte(input), &req); err != nil {
+			return "", platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: "invalid diagnostics request",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	if req.LogLimit <= 0 {
+		req.LogLimit = diagnosticsLogLimit
+	}
+
+	diag := diagnosticsJSON{
+		Time:        time.Now().UTC(),
+		Version:     collectVersion(req.AppVersion),
+		Stats:       stats.Current().Snapshot(),
+		TCPFailures: stats.Current().TCPFailures(),
+		Interfaces:  collectInterfaces(),
+		Logs:        redactLogs(logging.Entries(logging.Level(), req.LogLimit)),
+	}
+	if req.Transport != "" {
+		diag.Config = RedactConfig(req.Transport)
+		res, err := runConnectivityTest(ctx, req.Transport)
+		diag.Connectivity, diag.ConnectivityError = res, platerrors.ToPlatformError(err)
+	}
+	activeTransportMu.Lock()
+	diag.ActiveTransport = activeTransport
+	activeTransportMu.Unlock()
+
+	out, err := json.Marshal(diag)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal diagnostics",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+func collectVersion(app string) diagnosticsVersion {
+	v := diagnosticsVersion{App: app, Go: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Modules = map[string]string{info.Main.Path: info.Main.Version}
+	for _, dep := range info.Deps {
+		for _, path := range diagnosticsModules {
+			if dep.Path == path {
+				v.Modules[dep.Path] = dep.Version
+			}
+		}
+	}
+	return v
+}
+
+func collectInterfaces() []diagnosticsInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Warn("failed to list the network interfaces", "err", err)
+		return nil
+	}
+	result := make([]diagnosticsInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		d := diagnosticsInterface{Name: iface.Name, MTU: iface.MTU, Flags: iface.Flags.String()}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ipNet.IP.To4() != nil {
+					d.IPv4 = true
+				} else {
+					d.IPv6 = true
+				}
+			}
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// redactLogs redacts the secrets the log records may contain, e.g. in config errors.
+func redactLogs(entries []logging.Entry) []logging.Entry {
+	for i := range entries {
+		entries[i].Message = redactText(entries[i].Message)
+		// The attributes are shared with the memory buffer.
+		attrs := make(map[string]string, len(entries[i].Attrs))
+		for k, v := range entries[i].Attrs {
+			if isSensitiveKey(k) {
+				attrs[k] = redacted
+			} else {
+				attrs[k] = redactText(v)
+			}
+		}
+		entries[i].Attrs = attrs
+	}
+	return entries
+}
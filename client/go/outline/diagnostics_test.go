@@ -0,0 +1,61 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectDiagnostics(t *testing.T) {
+	logger.Warn("invalid config", "password", "secret123", "config", `{"password":"hunter2"}`)
+
+	out, err := collectDiagnostics(context.Background(), `{"transport": "{\"host\":\"\",\"password\":\"hunter2\"}", "appVersion": "1.2.3", "logLimit": 1}`)
+	require.NoError(t, err)
+	require.NotContains(t, out, "hunter2")
+	require.NotContains(t, out, "secret123")
+
+	var diag diagnosticsJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &diag))
+	require.Equal(t, "1.2.3", diag.Version.App)
+	require.Equal(t, runtime.GOOS, diag.Version.OS)
+	require.Equal(t, runtime.Version(), diag.Version.Go)
+	require.JSONEq(t, `{"host":"","password":"REDACTED"}`, diag.Config)
+	require.Nil(t, diag.Connectivity)
+	require.NotNil(t, diag.ConnectivityError)
+	require.Equal(t, platerrors.IllegalConfig, diag.ConnectivityError.Code)
+	require.NotEmpty(t, diag.Interfaces)
+	require.Len(t, diag.Logs, 1)
+	require.Equal(t, "invalid config", diag.Logs[0].Message)
+	require.Equal(t, "REDACTED", diag.Logs[0].Attrs["password"])
+}
+
+func TestCollectDiagnostics_NoInput(t *testing.T) {
+	out, err := collectDiagnostics(context.Background(), "")
+	require.NoError(t, err)
+	var diag diagnosticsJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &diag))
+	require.Empty(t, diag.Config)
+	require.Nil(t, diag.ConnectivityError)
+	require.NotNil(t, diag.TCPFailures, "the failures must be an array, even without a tunnel")
+
+	_, err = collectDiagnostics(context.Background(), "{")
+	require.Error(t, err)
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// newProtectedTCPDialer creates a base TCP dialer for [Client] bound to the default network
+// interface, so that its traffic bypasses the VPN. macOS has no firewall marks, so fwmark is
+// ignored.
+func newProtectedTCPDialer(fwmark uint32) net.Dialer {
+	return net.Dialer{
+		KeepAlive: -1,
+		Control:   boundToInterface(defaultInterfaceIndex()),
+	}
+}
+
+// newProtectedUDPDialer creates a new UDP dialer for [Client] bound to the default network
+// interface, like [newProtectedTCPDialer].
+func newProtectedUDPDialer(fwmark uint32) net.Dialer {
+	return net.Dialer{Control: boundToInterface(defaultInterfaceIndex())}
+}
+
+// boundToInterface returns a dialer control function binding the sockets to the interface of
+// ifindex, or nil if ifindex is 0.
+func boundToInterface(ifindex int) func(network, address string, c syscall.RawConn) error {
+	if ifindex == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		ctrlErr := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifindex)
+			} else {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, ifindex)
+			}
+		})
+		if ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}
+}
+
+// defaultInterfaceIndex returns the index of the interface of the default route, or 0 if it is
+// unknown. The VPN routes the traffic with more specific routes, so the default route keeps
+// pointing to the physical interface while it is connected.
+func defaultInterfaceIndex() int {
+	out, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		logger.Warn("failed to get the default route", "err", err)
+		return 0
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "interface:"); ok {
+			iface, err := net.InterfaceByName(strings.TrimSpace(name))
+			if err != nil {
+				logger.Warn("failed to find the default interface", "err", err)
+				return 0
+			}
+			return iface.Index
+		}
+	}
+	return 0
+}
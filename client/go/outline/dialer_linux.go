@@ -19,9 +19,9 @@ import (
 	"syscall"
 )
 
-// newFWMarkProtectedTCPDialer creates a base TCP dialer for [Client]
+// newProtectedTCPDialer creates a base TCP dialer for [Client]
 // protected by the specified firewall mark.
-func newFWMarkProtectedTCPDialer(fwmark uint32) net.Dialer {
+func newProtectedTCPDialer(fwmark uint32) net.Dialer {
 	return net.Dialer{
 		KeepAlive: -1,
 		Control: func(network, address string, c syscall.RawConn) error {
@@ -32,9 +32,9 @@ func newFWMarkProtectedTCPDialer(fwmark uint32) net.Dialer {
 	}
 }
 
-// newFWMarkProtectedUDPDialer creates a new UDP dialer for [Client]
+// newProtectedUDPDialer creates a new UDP dialer for [Client]
 // protected by the specified firewall mark.
-func newFWMarkProtectedUDPDialer(fwmark uint32) net.Dialer {
+func newProtectedUDPDialer(fwmark uint32) net.Dialer {
 	return net.Dialer{
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
@@ -0,0 +1,236 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS resolver types of the "dns" config section.
+const (
+	dnsResolverTypePlain = "plain"
+	dnsResolverTypeDoT   = "dot"
+	dnsResolverTypeDoH   = "doh"
+)
+
+// dnsConfigJSON is the "dns" section of the transport config. It selects the resolvers answering
+// the DNS queries of the tunnel, and resolving the host name of the proxy server.
+type dnsConfigJSON struct {
+	// DoH is the URL of a DNS-over-HTTPS resolver, e.g. "https://1.1.1.1/dns-query".
+	// It is a shorthand for a first resolver of type "doh".
+	DoH string `json:"doh,omitempty"`
+
+	// Resolvers are tried in order, until one of them answers.
+	Resolvers []dnsResolverJSON `json:"resolvers,omitempty"`
+
+	// Rules send the queries of the tunnel for some domains to specific resolvers (split-horizon
+	// DNS). The first matching rule wins, and the queries matching no rule go to Resolvers.
+	// Rules don't apply to the resolution of the proxy server host name.
+	Rules []dnsRuleJSON `json:"rules,omitempty"`
+}
+
+// dnsRuleJSON is a split-horizon rule of the "dns" config section.
+type dnsRuleJSON struct {
+	// Domains match the domain and all its subdomains.
+	Domains []string `json:"domains"`
+
+	// Resolvers are tried in order, until one of them answers.
+	Resolvers []dnsResolverJSON `json:"resolvers"`
+
+	// Direct connects to the resolvers outside of the tunnel, e.g. for a resolver only reachable
+	// from the local network.
+	Direct bool `json:"direct,omitempty"`
+}
+
+// dnsResolverJSON is a resolver of the "dns" config section.
+type dnsResolverJSON struct {
+	// Type is either "plain" (DNS over UDP), "dot" (DNS-over-TLS) or "doh" (DNS-over-HTTPS).
+	Type string `json:"$type"`
+
+	// Address is the host[:port] of "plain" and "dot" resolvers.
+	Address string `json:"address,omitempty"`
+
+	// Name is the TLS server name of "dot" resolvers. Defaults to the host of Address.
+	Name string `json:"name,omitempty"`
+
+	// URL is the URL of "doh" resolvers.
+	URL string `json:"url,omitempty"`
+}
+
+// resolverConfigs returns the configured resolvers, in order.
+func (c *dnsConfigJSON) resolverConfigs() []dnsResolverJSON {
+	if c.DoH == "" {
+		return c.Resolvers
+	}
+	return append([]dnsResolverJSON{{Type: dnsResolverTypeDoH, URL: c.DoH}}, c.Resolvers...)
+}
+
+// newResolver creates a [dns.Resolver] trying the configured resolvers in order, connecting to
+// them with sd and pd. It returns nil if no resolver is configured.
+func (c *dnsConfigJSON) newResolver(sd transport.StreamDialer, pd transport.PacketDialer) (dns.Resolver, error) {
+	return newFallbackResolver("dns.resolvers", c.resolverConfigs(), sd, pd)
+}
+
+// newSplitResolver creates the [dns.Resolver] of the tunnel, applying the rules before the
+// default resolvers. Rules connect to their resolvers with sd and pd, or with directSD and
+// directPD if they are direct. It returns nil if no resolver is configured.
+func (c *dnsConfigJSON) newSplitResolver(
+	sd transport.StreamDialer, pd transport.PacketDialer, directSD transport.StreamDialer, directPD transport.PacketDialer,
+) (dns.Resolver, error) {
+	resolver, err := c.newResolver(sd, pd)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Rules) == 0 {
+		return resolver, nil
+	}
+	if resolver == nil {
+		return nil, errors.New("dns.resolvers is required by dns.rules")
+	}
+	rules := make([]dnsintercept.SplitRule, len(c.Rules))
+	for i, rule := range c.Rules {
+		if len(rule.Domains) == 0 {
+			return nil, fmt.Errorf("dns.rules[%d]: domains are required", i)
+		}
+		ruleSD, rulePD := sd, pd
+		if rule.Direct {
+			ruleSD, rulePD = directSD, directPD
+		}
+		r, err := newFallbackResolver(fmt.Sprintf("dns.rules[%d].resolvers", i), rule.Resolvers, ruleSD, rulePD)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			return nil, fmt.Errorf("dns.rules[%d]: resolvers are required", i)
+		}
+		rules[i] = dnsintercept.SplitRule{Domains: rule.Domains, Resolver: r}
+	}
+	return dnsintercept.NewSplitResolver(rules, resolver), nil
+}
+
+// newFallbackResolver creates a [dns.Resolver] trying the resolvers of configs in order. It
+// returns nil if configs is empty. path locates configs in error messages.
+func newFallbackResolver(
+	path string, configs []dnsResolverJSON, sd transport.StreamDialer, pd transport.PacketDialer,
+) (dns.Resolver, error) {
+	var resolvers fallbackResolver
+	for i, rc := range configs {
+		r, err := rc.newResolver(sd, pd)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", path, i, err)
+		}
+		resolvers = append(resolvers, r)
+	}
+	if len(resolvers) == 0 {
+		return nil, nil
+	}
+	return resolvers, nil
+}
+
+func (rc *dnsResolverJSON) newResolver(sd transport.StreamDialer, pd transport.PacketDialer) (dns.Resolver, error) {
+	switch rc.Type {
+	case dnsResolverTypePlain:
+		if rc.Address == "" {
+			return nil, errors.New("address is required")
+		}
+		return dns.NewUDPResolver(pd, rc.Address), nil
+
+	case dnsResolverTypeDoT:
+		if rc.Address == "" {
+			return nil, errors.New("address is required")
+		}
+		name := rc.Name
+		if name == "" {
+			name = rc.Address
+			if host, _, err := net.SplitHostPort(rc.Address); err == nil {
+				name = host
+			}
+		}
+		return dns.NewTLSResolver(sd, rc.Address, name), nil
+
+	case dnsResolverTypeDoH:
+		u, err := url.Parse(rc.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("URL %q must be a valid https URL", rc.URL)
+		}
+		return dns.NewHTTPSResolver(sd, u.Host, u.String()), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported resolver type %q", rc.Type)
+	}
+}
+
+// dnsForwarder creates the forwarder answering the DNS queries of the tunnel, connecting to the
+// resolvers through the proxy with sd and pl, or directly with tcpDialer and udpDialer for the
+// direct rules. It returns nil if the config has no resolvers.
+func (conf *configJSON) dnsForwarder(
+	sd transport.StreamDialer, pl transport.PacketListener, tcpDialer, udpDialer net.Dialer,
+) (*dnsintercept.Forwarder, error) {
+	if conf.DNS == nil {
+		return nil, nil
+	}
+	resolver, err := conf.DNS.newSplitResolver(sd, transport.PacketListenerDialer{Listener: pl},
+		&transport.TCPDialer{Dialer: tcpDialer}, &transport.UDPDialer{Dialer: udpDialer})
+	if err != nil {
+		return nil, newIllegalDNSConfigError(err)
+	}
+	if resolver == nil {
+		return nil, nil
+	}
+	return dnsintercept.NewForwarder(resolver), nil
+}
+
+// endpointResolver creates the resolver of the proxy server host name, connecting to the resolvers
+// directly with tcpDialer and udpDialer. It returns nil, meaning the system resolver, if the config
+// has no resolvers.
+func (conf *configJSON) endpointResolver(tcpDialer, udpDialer net.Dialer) (dns.Resolver, error) {
+	if conf.DNS == nil {
+		return nil, nil
+	}
+	resolver, err := conf.DNS.newResolver(&transport.TCPDialer{Dialer: tcpDialer}, &transport.UDPDialer{Dialer: udpDialer})
+	if err != nil {
+		return nil, newIllegalDNSConfigError(err)
+	}
+	return resolver, nil
+}
+
+func newIllegalDNSConfigError(err error) error {
+	return newIllegalConfigErrorWithDetails("DNS resolvers are not valid", "dns", err.Error(), "valid DNS resolvers", err)
+}
+
+// fallbackResolver queries its resolvers in order, until one of them answers without failing.
+type fallbackResolver []dns.Resolver
+
+func (r fallbackResolver) Query(ctx context.Context, q dnsmessage.Question) (msg *dnsmessage.Message, err error) {
+	for _, resolver := range r {
+		msg, err = resolver.Query(ctx, q)
+		if err == nil && msg.RCode != dnsmessage.RCodeServerFailure {
+			return msg, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return msg, err
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newTestResolver(rcode dnsmessage.RCode, answers []dnsmessage.Resource, err error) dns.Resolver {
+	return dns.FuncResolver(func(_ context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+		if err != nil {
+			return nil, err
+		}
+		msg := &dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RCode: rcode},
+			Questions: []dnsmessage.Question{q},
+		}
+		for _, answer := range answers {
+			if answer.Header.Type == q.Type {
+				msg.Answers = append(msg.Answers, answer)
+			}
+		}
+		return msg, nil
+	})
+}
+
+func TestDNSConfig_ResolverConfigs(t *testing.T) {
+	conf := dnsConfigJSON{
+		DoH:       "https://1.1.1.1/dns-query",
+		Resolvers: []dnsResolverJSON{{Type: dnsResolverTypeDoT, Address: "8.8.8.8:853"}},
+	}
+	require.Equal(t, []dnsResolverJSON{
+		{Type: dnsResolverTypeDoH, URL: "https://1.1.1.1/dns-query"},
+		{Type: dnsResolverTypeDoT, Address: "8.8.8.8:853"},
+	}, conf.resolverConfigs())
+
+	resolver, err := (&dnsConfigJSON{}).newResolver(nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, resolver)
+}
+
+func TestFallbackResolver(t *testing.T) {
+	q, err := dns.NewQuestion("example.com", dnsmessage.TypeA)
+	require.NoError(t, err)
+
+	r := fallbackResolver{
+		newTestResolver(0, nil, errors.New("unreachable")),
+		newTestResolver(dnsmessage.RCodeServerFailure, nil, nil),
+		newTestResolver(dnsmessage.RCodeNameError, nil, nil),
+	}
+	msg, err := r.Query(context.Background(), *q)
+	require.NoError(t, err)
+	require.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+
+	_, err = r[:1].Query(context.Background(), *q)
+	require.ErrorContains(t, err, "unreachable")
+}
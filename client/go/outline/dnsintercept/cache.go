@@ -0,0 +1,176 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultCacheSize = 1024
+
+	// maxCacheTTL caps how long an answer is cached, regardless of its TTL.
+	maxCacheTTL = time.Hour
+
+	// maxNegativeCacheTTL caps how long a negative response (NXDOMAIN or no data) is cached, so
+	// that new records show up quickly.
+	maxNegativeCacheTTL = 5 * time.Minute
+)
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// cache is an LRU cache of DNS responses. Successful responses are kept until their smallest TTL
+// expires, and negative responses as long as their SOA record allows (RFC 2308).
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	// lru holds the *cacheEntry, from the most to the least recently used.
+	lru          *list.List
+	hits, misses int64
+}
+
+func newCache(size int) *cache {
+	return &cache{size: size, entries: make(map[cacheKey]*list.Element, size), lru: list.New()}
+}
+
+func newCacheKey(q dnsmessage.Question) cacheKey {
+	return cacheKey{strings.ToLower(q.Name.String()), q.Type, q.Class}
+}
+
+// get returns a copy of the cached response to q, with its TTLs reduced by the time spent in the
+// cache, or nil if there is none.
+func (c *cache) get(q dnsmessage.Question) *dnsmessage.Message {
+	key := newCacheKey(q)
+	now := time.Now()
+	c.mu.Lock()
+	var entry *cacheEntry
+	if elem, ok := c.entries[key]; ok {
+		entry = elem.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+		} else {
+			c.remove(elem)
+			entry = nil
+		}
+	}
+	if entry == nil {
+		c.misses++
+	} else {
+		c.hits++
+	}
+	c.mu.Unlock()
+	if entry == nil {
+		return nil
+	}
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	msg := *entry.msg
+	msg.Answers = agedResources(entry.msg.Answers, elapsed)
+	msg.Authorities = agedResources(entry.msg.Authorities, elapsed)
+	msg.Additionals = agedResources(entry.msg.Additionals, elapsed)
+	return &msg
+}
+
+func agedResources(resources []dnsmessage.Resource, elapsed uint32) []dnsmessage.Resource {
+	aged := make([]dnsmessage.Resource, len(resources))
+	for i, r := range resources {
+		aged[i] = r
+		if r.Header.Type != dnsmessage.TypeOPT {
+			aged[i].Header.TTL -= min(elapsed, r.Header.TTL)
+		}
+	}
+	return aged
+}
+
+// put caches msg as the response to q, if it is a cacheable response.
+func (c *cache) put(q dnsmessage.Question, msg *dnsmessage.Message) {
+	ttl := cacheTTL(msg)
+	if ttl <= 0 {
+		return
+	}
+
+	key := newCacheKey(q)
+	now := time.Now()
+	entry := &cacheEntry{key: key, msg: msg, stored: now, expires: now.Add(ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+// remove deletes elem from the cache. c.mu must be held.
+func (c *cache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
+
+// cacheTTL returns how long msg can be cached, or 0 if it must not be cached.
+func cacheTTL(msg *dnsmessage.Message) time.Duration {
+	if msg.Truncated {
+		return 0
+	}
+	if msg.RCode == dnsmessage.RCodeSuccess && len(msg.Answers) > 0 {
+		ttl := maxCacheTTL
+		for _, r := range msg.Answers {
+			ttl = min(ttl, time.Duration(r.Header.TTL)*time.Second)
+		}
+		return ttl
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError {
+		return 0
+	}
+	// Negative response: the TTL is the minimum of the SOA record TTL and its MINIMUM field.
+	for _, r := range msg.Authorities {
+		if soa, ok := r.Body.(*dnsmessage.SOAResource); ok {
+			ttl := min(time.Duration(r.Header.TTL), time.Duration(soa.MinTTL)) * time.Second
+			return min(ttl, maxNegativeCacheTTL)
+		}
+	}
+	return 0
+}
+
+// stats returns the statistics of the cache.
+func (c *cache) stats() stats.DNSCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := stats.DNSCache{Hits: c.hits, Misses: c.misses, Size: c.lru.Len(), Capacity: c.size}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRate = float64(c.hits) / float64(total)
+	}
+	return s
+}
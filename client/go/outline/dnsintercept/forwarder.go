@@ -0,0 +1,137 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dnsintercept answers the DNS queries the TUN device sends to port 53, using a
+// [dns.Resolver] instead of relaying them as regular UDP traffic. It lets the tunnel use an
+// encrypted resolver like DNS-over-HTTPS, so that DNS queries never leak to the local network.
+package dnsintercept
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var logger = logging.Module("dns")
+
+// DNSPort is the port of the queries to intercept.
+const DNSPort = 53
+
+const (
+	// queryTimeout limits how long a query can take to be resolved.
+	queryTimeout = 10 * time.Second
+
+	// maxPlainUDPSize is the maximum size of a response to a query without an EDNS(0) record.
+	maxPlainUDPSize = 512
+)
+
+// Forwarder answers intercepted DNS queries using a [dns.Resolver], and caches the answers.
+type Forwarder struct {
+	resolver dns.Resolver
+	cache    *cache
+}
+
+// NewForwarder creates a [Forwarder] resolving the queries with resolver.
+func NewForwarder(resolver dns.Resolver) *Forwarder {
+	return &Forwarder{resolver: resolver, cache: newCache(defaultCacheSize)}
+}
+
+// CacheStats returns the statistics of the cache of f.
+func (f *Forwarder) CacheStats() stats.DNSCache {
+	return f.cache.stats()
+}
+
+// Answer returns the wire-format response to the wire-format query. Resolution failures are
+// reported to the client as SERVFAIL. It returns nil if query is not a valid DNS query.
+func (f *Forwarder) Answer(ctx context.Context, query []byte) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil
+	}
+	maxSize := maxPlainUDPSize
+	if err = p.SkipAllAnswers(); err == nil {
+		if err = p.SkipAllAuthorities(); err == nil {
+			maxSize = ednsUDPSize(&p, maxSize)
+		}
+	}
+
+	resp := &dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeFormatError}}
+	if len(questions) == 1 {
+		resp = f.resolve(ctx, questions[0])
+	}
+	resp.Header.ID = hdr.ID
+	resp.Header.Response = true
+	resp.Header.RecursionDesired = hdr.RecursionDesired
+	resp.Questions = questions
+
+	buf, err := resp.Pack()
+	if err != nil {
+		logger.Warn("failed to pack DNS response", "err", err)
+		return nil
+	}
+	if len(buf) > maxSize {
+		// Let the client retry over TCP.
+		resp.Header.Truncated = true
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		if buf, err = resp.Pack(); err != nil {
+			return nil
+		}
+	}
+	return buf
+}
+
+// resolve returns the cached response to q, or queries the resolver.
+func (f *Forwarder) resolve(ctx context.Context, q dnsmessage.Question) *dnsmessage.Message {
+	if msg := f.cache.get(q); msg != nil {
+		return msg
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	msg, err := f.resolver.Query(ctx, q)
+	if err != nil {
+		logger.Debug("failed to resolve intercepted DNS query", "name", q.Name, "type", q.Type, "err", err)
+		return &dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+	}
+	f.cache.put(q, msg)
+	// The caller modifies the header, so it must not share it with the cached message.
+	resp := *msg
+	return &resp
+}
+
+// ednsUDPSize returns the UDP payload size advertised by the OPT record in the additional section
+// of the query parsed by p, or defaultSize if there is none.
+func ednsUDPSize(p *dnsmessage.Parser, defaultSize int) int {
+	for {
+		hdr, err := p.AdditionalHeader()
+		if err != nil {
+			return defaultSize
+		}
+		if hdr.Type == dnsmessage.TypeOPT {
+			// The class of an OPT record is the UDP payload size.
+			return max(int(hdr.Class), defaultSize)
+		}
+		if err = p.SkipAdditional(); err != nil {
+			return defaultSize
+		}
+	}
+}
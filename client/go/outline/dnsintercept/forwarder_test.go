@@ -0,0 +1,188 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newQuery(t *testing.T, id uint16, name string) []byte {
+	q, err := dns.NewQuestion(name, dnsmessage.TypeA)
+	require.NoError(t, err)
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{*q},
+	}
+	buf, err := msg.Pack()
+	require.NoError(t, err)
+	return buf
+}
+
+func newAnswer(q dnsmessage.Question, ttl uint32, count int) *dnsmessage.Message {
+	msg := &dnsmessage.Message{Header: dnsmessage.Header{Response: true}, Questions: []dnsmessage.Question{q}}
+	for i := 0; i < count; i++ {
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, byte(i)}},
+		})
+	}
+	return msg
+}
+
+func unpack(t *testing.T, buf []byte) dnsmessage.Message {
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(buf))
+	return msg
+}
+
+func TestForwarder_Answer(t *testing.T) {
+	queries := 0
+	f := NewForwarder(dns.FuncResolver(func(_ context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+		queries++
+		return newAnswer(q, 300, 1), nil
+	}))
+
+	resp := unpack(t, f.Answer(context.Background(), newQuery(t, 1234, "example.com.")))
+	require.Equal(t, uint16(1234), resp.ID)
+	require.True(t, resp.Response)
+	require.True(t, resp.RecursionDesired)
+	require.Len(t, resp.Answers, 1)
+	require.Equal(t, "example.com.", resp.Questions[0].Name.String())
+
+	resp = unpack(t, f.Answer(context.Background(), newQuery(t, 5678, "EXAMPLE.com.")))
+	require.Equal(t, uint16(5678), resp.ID)
+	require.Len(t, resp.Answers, 1)
+	require.LessOrEqual(t, resp.Answers[0].Header.TTL, uint32(300))
+	require.Equal(t, 1, queries, "the second query must be answered from the cache")
+
+	require.Nil(t, f.Answer(context.Background(), []byte{1, 2, 3}))
+}
+
+func TestForwarder_ServerFailure(t *testing.T) {
+	f := NewForwarder(dns.FuncResolver(func(context.Context, dnsmessage.Question) (*dnsmessage.Message, error) {
+		return nil, errors.New("unreachable")
+	}))
+	resp := unpack(t, f.Answer(context.Background(), newQuery(t, 1, "example.com.")))
+	require.Equal(t, dnsmessage.RCodeServerFailure, resp.RCode)
+	require.Equal(t, uint16(1), resp.ID)
+}
+
+func TestForwarder_Truncated(t *testing.T) {
+	f := NewForwarder(dns.FuncResolver(func(_ context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+		return newAnswer(q, 300, 50), nil
+	}))
+	resp := unpack(t, f.Answer(context.Background(), newQuery(t, 1, "example.com.")))
+	require.True(t, resp.Truncated)
+	require.Empty(t, resp.Answers)
+
+	// The full answer is still cached.
+	resp = unpack(t, f.Answer(context.Background(), newQuery(t, 2, "example.com.")))
+	require.True(t, resp.Truncated)
+	q, err := dns.NewQuestion("example.com.", dnsmessage.TypeA)
+	require.NoError(t, err)
+	require.Len(t, f.cache.get(*q).Answers, 50)
+}
+
+func TestCache_Evict(t *testing.T) {
+	c := newCache(2)
+	var questions []dnsmessage.Question
+	for i, name := range []string{"a.example.", "b.example.", "c.example."} {
+		q, err := dns.NewQuestion(name, dnsmessage.TypeA)
+		require.NoError(t, err)
+		questions = append(questions, *q)
+		c.put(*q, newAnswer(*q, uint32(100+i), 1))
+	}
+	require.Nil(t, c.get(questions[0]))
+	require.NotNil(t, c.get(questions[1]))
+	require.NotNil(t, c.get(questions[2]))
+}
+
+func newNegativeAnswer(q dnsmessage.Question, rcode dnsmessage.RCode, ttl, minTTL uint32) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true, RCode: rcode},
+		Questions: []dnsmessage.Question{q},
+		Authorities: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body: &dnsmessage.SOAResource{
+				NS:     dnsmessage.MustNewName("ns.example."),
+				MBox:   dnsmessage.MustNewName("admin.example."),
+				MinTTL: minTTL,
+			},
+		}},
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	c := newCache(2)
+	var questions []dnsmessage.Question
+	for _, name := range []string{"a.example.", "b.example.", "c.example."} {
+		q, err := dns.NewQuestion(name, dnsmessage.TypeA)
+		require.NoError(t, err)
+		questions = append(questions, *q)
+	}
+	c.put(questions[0], newAnswer(questions[0], 300, 1))
+	c.put(questions[1], newAnswer(questions[1], 300, 1))
+	// Using the first entry makes the second one the least recently used.
+	require.NotNil(t, c.get(questions[0]))
+	c.put(questions[2], newAnswer(questions[2], 300, 1))
+
+	require.NotNil(t, c.get(questions[0]))
+	require.Nil(t, c.get(questions[1]))
+	require.NotNil(t, c.get(questions[2]))
+
+	s := c.stats()
+	require.Equal(t, int64(3), s.Hits)
+	require.Equal(t, int64(1), s.Misses)
+	require.Equal(t, 0.75, s.HitRate)
+	require.Equal(t, 2, s.Size)
+	require.Equal(t, 2, s.Capacity)
+}
+
+func TestCacheTTL(t *testing.T) {
+	q, err := dns.NewQuestion("example.com.", dnsmessage.TypeA)
+	require.NoError(t, err)
+
+	servFail := newAnswer(*q, 300, 0)
+	servFail.RCode = dnsmessage.RCodeServerFailure
+	truncated := newAnswer(*q, 300, 1)
+	truncated.Truncated = true
+
+	tests := []struct {
+		name string
+		msg  *dnsmessage.Message
+		want time.Duration
+	}{
+		{"answers", newAnswer(*q, 300, 2), 300 * time.Second},
+		{"long TTL", newAnswer(*q, 100000, 1), maxCacheTTL},
+		{"NXDOMAIN", newNegativeAnswer(*q, dnsmessage.RCodeNameError, 600, 60), 60 * time.Second},
+		{"no data", newNegativeAnswer(*q, dnsmessage.RCodeSuccess, 30, 60), 30 * time.Second},
+		{"long negative TTL", newNegativeAnswer(*q, dnsmessage.RCodeNameError, 86400, 86400), maxNegativeCacheTTL},
+		{"negative without SOA", newAnswer(*q, 300, 0), 0},
+		{"SERVFAIL", servFail, 0},
+		{"truncated", truncated, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, cacheTTL(tt.msg))
+		})
+	}
+}
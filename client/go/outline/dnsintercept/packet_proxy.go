@@ -0,0 +1,112 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/network"
+)
+
+// PacketProxy returns a [network.PacketProxy] answering the DNS queries itself, and relaying all
+// other packets through base.
+func (f *Forwarder) PacketProxy(base network.PacketProxy) network.PacketProxy {
+	return &packetProxy{f, base}
+}
+
+type packetProxy struct {
+	forwarder *Forwarder
+	base      network.PacketProxy
+}
+
+func (p *packetProxy) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	s := &session{forwarder: p.forwarder, resp: resp}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	sender, err := p.base.NewSession(responseReceiver{s})
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.base = sender
+	return s, nil
+}
+
+// session is the request sender handed to the network stack. The DNS answers and the responses
+// relayed by the base proxy share its response receiver.
+type session struct {
+	forwarder *Forwarder
+	base      network.PacketRequestSender
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu     sync.Mutex
+	resp   network.PacketResponseReceiver
+	closed bool
+}
+
+func (s *session) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	if destination.Port() != DNSPort {
+		return s.base.WriteTo(p, destination)
+	}
+	query := append([]byte(nil), p...)
+	resources.Go(resources.SubsystemDNS, func() {
+		if answer := s.forwarder.Answer(s.ctx, query); answer != nil {
+			s.writeResponse(answer, net.UDPAddrFromAddrPort(destination))
+		}
+	})
+	return len(p), nil
+}
+
+func (s *session) writeResponse(p []byte, source net.Addr) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, network.ErrClosed
+	}
+	return s.resp.WriteFrom(p, source)
+}
+
+// Close closes the request sender. It is called by the network stack.
+func (s *session) Close() error {
+	s.cancel()
+	return s.base.Close()
+}
+
+func (s *session) closeReceiver() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.resp.Close()
+}
+
+// responseReceiver is the response receiver handed to the base proxy.
+type responseReceiver struct {
+	s *session
+}
+
+func (r responseReceiver) WriteFrom(p []byte, source net.Addr) (int, error) {
+	return r.s.writeResponse(p, source)
+}
+
+func (r responseReceiver) Close() error {
+	return r.s.closeReceiver()
+}
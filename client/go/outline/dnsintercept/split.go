@@ -0,0 +1,72 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SplitRule sends the queries for some domains to a specific resolver (split-horizon DNS).
+type SplitRule struct {
+	// Domains match the domain and all its subdomains, e.g. "corp.example" matches
+	// "corp.example" and "intranet.corp.example".
+	Domains []string
+
+	// Resolver resolves the queries matching Domains.
+	Resolver dns.Resolver
+}
+
+type splitResolver struct {
+	rules    []SplitRule
+	fallback dns.Resolver
+}
+
+// NewSplitResolver creates a [dns.Resolver] sending each query to the resolver of the first rule
+// matching its name, or to fallback if no rule matches.
+func NewSplitResolver(rules []SplitRule, fallback dns.Resolver) dns.Resolver {
+	r := &splitResolver{rules: make([]SplitRule, len(rules)), fallback: fallback}
+	for i, rule := range rules {
+		r.rules[i] = SplitRule{Resolver: rule.Resolver}
+		for _, d := range rule.Domains {
+			r.rules[i].Domains = append(r.rules[i].Domains, normalizeName(d))
+		}
+	}
+	return r
+}
+
+func (r *splitResolver) Query(ctx context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+	return r.resolverFor(q.Name.String()).Query(ctx, q)
+}
+
+// resolverFor returns the resolver of the first rule matching name.
+func (r *splitResolver) resolverFor(name string) dns.Resolver {
+	name = normalizeName(name)
+	for _, rule := range r.rules {
+		for _, d := range rule.Domains {
+			if name == d || strings.HasSuffix(name, "."+d) {
+				return rule.Resolver
+			}
+		}
+	}
+	return r.fallback
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSplitResolver(t *testing.T) {
+	var got string
+	newResolver := func(name string) dns.Resolver {
+		return dns.FuncResolver(func(_ context.Context, q dnsmessage.Question) (*dnsmessage.Message, error) {
+			got = name
+			return newAnswer(q, 300, 1), nil
+		})
+	}
+	r := NewSplitResolver([]SplitRule{
+		{Domains: []string{"Corp.Example."}, Resolver: newResolver("corp")},
+		{Domains: []string{"example", "test"}, Resolver: newResolver("example")},
+	}, newResolver("default"))
+
+	tests := []struct {
+		name     string
+		resolver string
+	}{
+		{"corp.example.", "corp"},
+		{"intranet.CORP.example.", "corp"},
+		{"notcorp.example.", "example"},
+		{"example.", "example"},
+		{"a.b.test.", "example"},
+		{"example.com.", "default"},
+		{"mycorp.example.com.", "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := dns.NewQuestion(tt.name, dnsmessage.TypeA)
+			require.NoError(t, err)
+			_, err = r.Query(context.Background(), *q)
+			require.NoError(t, err)
+			require.Equal(t, tt.resolver, got)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+const (
+	defaultKeyRefreshInterval = 1 * time.Hour
+	minKeyRefreshInterval     = 1 * time.Minute
+)
+
+// dynamicKeyRefreshJSON is the input of [MethodStartDynamicKeyRefresh].
+type dynamicKeyRefreshJSON struct {
+	// URL of the dynamic access key.
+	URL string `json:"url"`
+
+	// IntervalSeconds is how often to re-fetch the URL. Defaults to one hour.
+	IntervalSeconds int `json:"intervalSeconds"`
+
+	// Transport is the currently active transport config, the one new configs are compared to.
+	Transport string `json:"transport"`
+
+	// PinnedSPKISHA256 pins the certificate of the server of the URL, see [fetchRequestJSON].
+	PinnedSPKISHA256 []string `json:"pinnedSpkiSha256,omitempty"`
+
+	// OutboundProxy is the proxy to fetch the URL through, see [fetchRequestJSON].
+	OutboundProxy string `json:"outboundProxy,omitempty"`
+
+	// Signed requires the dynamic key to be signed by the provider, see [fetchRequestJSON].
+	Signed bool `json:"signed,omitempty"`
+}
+
+// configChangedEventJSON is the data of [EventConfigChanged].
+type configChangedEventJSON struct {
+	URL       string `json:"url"`
+	Transport string `json:"transport"`
+}
+
+// dynamicKeyRefresher periodically re-fetches a dynamic access key and emits an
+// [EventConfigChanged] whenever the fetched transport differs from the active one.
+type dynamicKeyRefresher struct {
+	fetch    fetchRequestJSON
+	interval time.Duration
+	current  *configJSON
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// The running refreshers, indexed by URL.
+var refreshersMu sync.Mutex
+var refreshers = make(map[string]*dynamicKeyRefresher)
+
+// startDynamicKeyRefresh starts refreshing the dynamic key described by the JSON string input,
+// replacing any refresher of the same URL.
+func startDynamicKeyRefresh(input string) error {
+	var req dynamicKeyRefreshJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid dynamic key refresh request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if !strings.HasPrefix(req.URL, "https://") && !strings.HasPrefix(req.URL, "http://") {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "dynamic key URL must be an HTTP(S) URL",
+			Details: platerrors.ErrorDetails{"url": req.URL},
+		}
+	}
+	interval := defaultKeyRefreshInterval
+	if req.IntervalSeconds > 0 {
+		interval = max(time.Duration(req.IntervalSeconds)*time.Second, minKeyRefreshInterval)
+	}
+	current, err := parseConfigFromJSON(req.Transport)
+	if err != nil {
+		return err
+	}
+	pins, err := decodeSPKIPins(req.PinnedSPKISHA256)
+	if err != nil {
+		return err
+	}
+	if len(pins) > 0 {
+		if err := checkPinnedURL(req.URL); err != nil {
+			return err
+		}
+	}
+
+	fetch := fetchRequestJSON{
+		URL: req.URL, PinnedSPKISHA256: req.PinnedSPKISHA256, OutboundProxy: req.OutboundProxy, Signed: req.Signed,
+		management: true,
+	}
+	r := newDynamicKeyRefresher(fetch, interval, current)
+	refreshersMu.Lock()
+	defer refreshersMu.Unlock()
+	if old, ok := refreshers[req.URL]; ok {
+		old.stop()
+	}
+	refreshers[req.URL] = r
+	updateDynamicKeyManagementHosts()
+	logger.Info("dynamic key refresh started", "interval", interval)
+	return nil
+}
+
+// stopDynamicKeyRefresh stops refreshing the dynamic key located at url.
+// Nothing happens if the url is not being refreshed.
+func stopDynamicKeyRefresh(url string) error {
+	refreshersMu.Lock()
+	defer refreshersMu.Unlock()
+	if r, ok := refreshers[url]; ok {
+		r.stop()
+		delete(refreshers, url)
+		updateDynamicKeyManagementHosts()
+		logger.Info("dynamic key refresh stopped")
+	}
+	return nil
+}
+
+// newDynamicKeyRefresher creates a refresher and starts its background goroutine.
+func newDynamicKeyRefresher(fetch fetchRequestJSON, interval time.Duration, current *configJSON) *dynamicKeyRefresher {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &dynamicKeyRefresher{
+		fetch:    fetch,
+		interval: interval,
+		current:  current,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	resources.Go(resources.SubsystemDynamicKey, func() { r.run(ctx) })
+	return r
+}
+
+func (r *dynamicKeyRefresher) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.refresh(ctx)
+		}
+	}
+}
+
+// refresh fetches and parses the dynamic key once, and emits an [EventConfigChanged]
+// if the transport is different from the current one.
+//
+// The dynamic key is parsed like the tunnel configs of the app: an ss:// access key or a SIP008
+// JSON object. Its server replaces the one of the current transport, which keeps the other
+// sections set by the app.
+func (r *dynamicKeyRefresher) refresh(ctx context.Context) {
+	content, err := fetchResourceWithOptions(ctx, r.fetch)
+	if err != nil {
+		logger.Warn("failed to fetch dynamic key", "err", err)
+		return
+	}
+	if r.fetch.Signed {
+		if content, err = verifySignedConfig(content); err != nil {
+			logger.Warn("failed to verify dynamic key", "err", err)
+			return
+		}
+	}
+	meta, server, err := parseTunnelConfig(content)
+	if err != nil {
+		logger.Warn("failed to parse dynamic key", "err", err)
+		return
+	}
+	conf := r.current.withServer(server, meta)
+	if sameTransport(conf, r.current) {
+		logger.Debug("dynamic key unchanged")
+		return
+	}
+	transport, err := json.Marshal(conf)
+	if err != nil {
+		logger.Error("failed to marshal dynamic key", "err", err)
+		return
+	}
+	r.current = conf
+	logger.Info("dynamic key changed")
+	event.Emit(EventConfigChanged, configChangedEventJSON{URL: r.fetch.URL, Transport: string(transport)})
+}
+
+// withServer returns a copy of conf with the server and the display metadata of a tunnel config.
+// The prefix of the server replaces the obfs section of conf, which can't be used together with a
+// prefix, and conf keeps its obfs section if the server has no prefix.
+func (conf *configJSON) withServer(server *configJSON, meta serverMetadataJSON) *configJSON {
+	c := *conf
+	c.Host, c.Port, c.Method, c.Password, c.Prefix = server.Host, server.Port, server.Method, server.Password, server.Prefix
+	if c.Prefix != "" {
+		c.Obfs = nil
+	}
+	c.Name, c.Comment, c.Tags = meta.Name, meta.Comment, meta.Tags
+	return &c
+}
+
+// sameTransport reports whether a and b configure the same transport. The quota is ignored, since
+// the usage it reports changes at every fetch, and so is the display metadata.
+func sameTransport(a, b *configJSON) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	a2, b2 := a.withoutMetadata(), b.withoutMetadata()
+	a2.Quota, b2.Quota = nil, nil
+	return reflect.DeepEqual(a2, b2)
+}
+
+// stop stops the refresher and waits for its goroutine to exit.
+func (r *dynamicKeyRefresher) stop() {
+	r.cancel()
+	<-r.done
+}
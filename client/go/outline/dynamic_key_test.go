@@ -0,0 +1,160 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/leakcheck"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEventListener struct {
+	events chan [2]string
+}
+
+func (l *fakeEventListener) OnEvent(eventType string, data string) {
+	l.events <- [2]string{eventType, data}
+}
+
+func TestDynamicKeyRefresher_EmitsOnChange(t *testing.T) {
+	key := `{"server":"192.0.2.1","server_port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, key)
+	}))
+	defer server.Close()
+
+	l := &fakeEventListener{events: make(chan [2]string, 1)}
+	SetEventListener(l)
+	defer SetEventListener(nil)
+
+	current, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","udpOverTcp":true}`)
+	require.NoError(t, err)
+	r := &dynamicKeyRefresher{fetch: fetchRequestJSON{URL: server.URL}, current: current}
+
+	r.refresh(context.Background())
+	require.Empty(t, l.events, "no event expected when the key is unchanged")
+
+	key = `{"server":"192.0.2.2","server_port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","remarks":"Second"}`
+	r.refresh(context.Background())
+	require.Len(t, l.events, 1)
+	ev := <-l.events
+	require.Equal(t, EventConfigChanged, ev[0])
+
+	var data configChangedEventJSON
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &data))
+	require.Equal(t, server.URL, data.URL)
+	got, err := parseConfigFromJSON(data.Transport)
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.2", got.Host)
+	require.Equal(t, "Second", got.Name)
+	require.True(t, got.UDPOverTCP, "the sections of the app must be kept")
+
+	// The transport configs of the app aren't dynamic keys.
+	key = `{"host":"192.0.2.3","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`
+	r.refresh(context.Background())
+	require.Empty(t, l.events)
+}
+
+func TestDynamicKeyRefresher_AccessKey(t *testing.T) {
+	key := "ss://" + base64.URLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:abcd1234")) + "@192.0.2.1:12345/#First"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, key)
+	}))
+	defer server.Close()
+
+	l := &fakeEventListener{events: make(chan [2]string, 1)}
+	SetEventListener(l)
+	defer SetEventListener(nil)
+
+	current, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`)
+	require.NoError(t, err)
+	r := &dynamicKeyRefresher{fetch: fetchRequestJSON{URL: server.URL}, current: current}
+
+	r.refresh(context.Background())
+	require.Empty(t, l.events, "no event expected when the key is unchanged")
+
+	key = "ss://" + base64.URLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:efgh5678")) + "@192.0.2.1:12345/#First"
+	r.refresh(context.Background())
+	require.Len(t, l.events, 1)
+	ev := <-l.events
+	var data configChangedEventJSON
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &data))
+	got, err := parseConfigFromJSON(data.Transport)
+	require.NoError(t, err)
+	require.Equal(t, "efgh5678", got.Password)
+	require.Equal(t, "First", got.Name)
+}
+
+func TestConfigJSON_WithServer(t *testing.T) {
+	current, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234",
+		"obfs":{"$type":"prefix","prefixes":["POST ","GET "]}}`)
+	require.NoError(t, err)
+
+	_, server, err := parseTunnelConfig(`{"server":"192.0.2.2","server_port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`)
+	require.NoError(t, err)
+	conf := current.withServer(server, serverMetadataJSON{})
+	require.Equal(t, current.Obfs, conf.Obfs, "the obfs section must be kept without a prefix")
+	_, err = conf.obfsConfig()
+	require.NoError(t, err)
+
+	_, server, err = parseTunnelConfig(`{"server":"192.0.2.2","server_port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","prefix":"HTTP/1.1 "}`)
+	require.NoError(t, err)
+	conf = current.withServer(server, serverMetadataJSON{})
+	require.Nil(t, conf.Obfs)
+	obfs, err := conf.obfsConfig()
+	require.NoError(t, err)
+	require.Equal(t, &obfsConfigJSON{Type: obfsTypePrefix, Prefix: "HTTP/1.1 "}, obfs)
+	require.NotNil(t, current.Obfs, "the current config must not change")
+}
+
+func TestDynamicKeyRefresher_Stop(t *testing.T) {
+	leakcheck.Check(t)
+	current, err := parseConfigFromJSON(`{}`)
+	require.NoError(t, err)
+	r := newDynamicKeyRefresher(fetchRequestJSON{URL: "http://192.0.2.1"}, time.Hour, current)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not stop")
+	}
+}
+
+func TestStartDynamicKeyRefresh_InvalidURL(t *testing.T) {
+	err := startDynamicKeyRefresh(`{"url":"ss://invalid","transport":"{}"}`)
+	require.Error(t, err)
+}
+
+func TestSameTransport_IgnoresMetadata(t *testing.T) {
+	a, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":8080,"name":"A","tags":["x"]}`)
+	require.NoError(t, err)
+	b, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":8080,"name":"B","comment":"renamed"}`)
+	require.NoError(t, err)
+	require.True(t, sameTransport(a, b))
+	require.Empty(t, a.checkStrict(`{"host":"192.0.2.1","port":8080,"name":"A","tags":["x"]}`))
+}
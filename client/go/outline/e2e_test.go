@@ -0,0 +1,87 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/sstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndToEnd_Shadowsocks(t *testing.T) {
+	echo := sstest.StartEcho(t)
+	echoAddr, err := net.ResolveUDPAddr("udp", echo)
+	require.NoError(t, err)
+
+	for _, cipher := range []string{"chacha20-ietf-poly1305", "aes-128-gcm", "aes-192-gcm", "aes-256-gcm"} {
+		for _, prefix := range []string{"", "POST ", "\u0016\u0003\u0001"} {
+			cipher, prefix := cipher, prefix
+			t.Run(cipher+"/"+prefix, func(t *testing.T) {
+				server := sstest.Start(t, cipher, "e2e secret")
+				result := NewClient(server.Config(prefix))
+				require.Nil(t, result.Error)
+				client := result.Client
+
+				conn, err := client.DialStream(context.Background(), echo)
+				require.NoError(t, err)
+				defer conn.Close()
+				msg := make([]byte, 100_000)
+				for i := range msg {
+					msg[i] = byte(i)
+				}
+				go conn.Write(msg)
+				got := make([]byte, len(msg))
+				_, err = io.ReadFull(conn, got)
+				require.NoError(t, err)
+				require.Equal(t, msg, got)
+				require.Len(t, server.Salts(), 1)
+				require.Equal(t, prefix, string(server.Salts()[0][:len(prefix)]))
+
+				pc, err := client.ListenPacket(context.Background())
+				require.NoError(t, err)
+				defer pc.Close()
+				pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = pc.WriteTo([]byte("datagram"), echoAddr)
+				require.NoError(t, err)
+				buf := make([]byte, 64)
+				n, from, err := pc.ReadFrom(buf)
+				require.NoError(t, err)
+				require.Equal(t, "datagram", string(buf[:n]))
+				require.Equal(t, echo, from.String())
+			})
+		}
+	}
+}
+
+func TestEndToEnd_WrongSecret(t *testing.T) {
+	echo := sstest.StartEcho(t)
+	server := sstest.Start(t, "chacha20-ietf-poly1305", "secret")
+	server.Secret = "wrong"
+	result := NewClient(server.Config(""))
+	require.Nil(t, result.Error)
+
+	conn, err := result.Client.DialStream(context.Background(), echo)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 5))
+	require.Error(t, err)
+}
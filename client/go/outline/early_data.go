@@ -0,0 +1,55 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import "time"
+
+// maxClientDataWait is the longest wait for the first bytes of the client that can be configured.
+// Longer waits would delay the protocols where the server speaks first.
+const maxClientDataWait = 100 * time.Millisecond
+
+// earlyDataConfigJSON is the "earlyData" section of a Shadowsocks config. The salt, the target
+// address and the first bytes of the client always go to the server in a single write, and this
+// section tunes how the connections get them there sooner, e.g. on high-latency links.
+type earlyDataConfigJSON struct {
+	// ClientDataWaitMs is how long a new connection waits for the first bytes of the client, to
+	// send them with the target address, in milliseconds, up to 100. Defaults to 10.
+	ClientDataWaitMs int `json:"clientDataWaitMs,omitempty"`
+
+	// TCPFastOpen sends the first write of the connections to the proxy server in the SYN, with
+	// TCP Fast Open, once the server gave a cookie, which saves the round trip of the handshake.
+	// It's only available on Linux and Android, and not through an outbound proxy or a pluggable
+	// transport. The dials don't wait for the handshake, so the addresses of the server are not
+	// raced: the first one is used.
+	TCPFastOpen bool `json:"tcpFastOpen,omitempty"`
+}
+
+// validate checks the bounds of the config.
+func (c *earlyDataConfigJSON) validate() error {
+	if c.ClientDataWaitMs < 0 || time.Duration(c.ClientDataWaitMs)*time.Millisecond > maxClientDataWait {
+		return newIllegalConfigErrorWithDetails("client data wait is not valid",
+			"earlyData.clientDataWaitMs", c.ClientDataWaitMs, "a number of milliseconds within range [0..100]", nil)
+	}
+	return nil
+}
+
+// clientDataWait returns the configured wait for the first bytes of the client, or 0 for the
+// default of the stream dialers. c may be nil.
+func (c *earlyDataConfigJSON) clientDataWait() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(c.ClientDataWaitMs) * time.Millisecond
+}
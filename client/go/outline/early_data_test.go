@@ -0,0 +1,55 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ss2022"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEarlyDataConfig(t *testing.T) {
+	for _, earlyData := range []string{`{"clientDataWaitMs":-1}`, `{"clientDataWaitMs":101}`} {
+		config := `{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","earlyData":` + earlyData + `}`
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+
+	sd, _, err := parseShadowsocksTransport(json.RawMessage(
+		`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234",`+
+			`"earlyData":{"clientDataWaitMs":50,"tcpFastOpen":true}}`), TransportDialers{TCP: net.Dialer{}, UDP: net.Dialer{}})
+	require.NoError(t, err)
+	require.Equal(t, 50*time.Millisecond, sd.(*shadowsocks.StreamDialer).ClientDataWait)
+
+	sd, _, err = parseShadowsocksTransport(json.RawMessage(
+		`{"host":"192.0.2.1","port":12345,"method":"2022-blake3-aes-128-gcm","password":"AAAAAAAAAAAAAAAAAAAAAA==",`+
+			`"earlyData":{"clientDataWaitMs":50}}`), TransportDialers{TCP: net.Dialer{}, UDP: net.Dialer{}})
+	require.NoError(t, err)
+	require.Equal(t, 50*time.Millisecond, sd.(*ss2022.StreamDialer).ClientDataWait)
+
+	// The defaults of the stream dialers are kept.
+	sd, _, err = parseShadowsocksTransport(json.RawMessage(
+		`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","earlyData":{}}`),
+		TransportDialers{TCP: net.Dialer{}, UDP: net.Dialer{}})
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Millisecond, sd.(*shadowsocks.StreamDialer).ClientDataWait)
+}
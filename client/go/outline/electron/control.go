@@ -0,0 +1,120 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/control"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// tunnelController implements the control channel, connecting and disconnecting the tunnel of
+// this process.
+type tunnelController struct {
+	// onDisconnect, if not nil, is called with the error that disconnected the tunnel, or nil if
+	// it was disconnected through [tunnelController.Disconnect].
+	onDisconnect func(err error)
+
+	mu     sync.Mutex
+	tunnel *tun2socksTunnel
+	since  time.Time
+}
+
+var _ control.Handler = (*tunnelController)(nil)
+
+// newTunnelController creates the controller of the tunnel of this process, not connected yet.
+func newTunnelController(onDisconnect func(err error)) *tunnelController {
+	return &tunnelController{onDisconnect: onDisconnect}
+}
+
+// Connect connects the tunnel through the transport of params. If the tunnel is already
+// connected, the new connections use the new transport, and the existing ones keep theirs.
+//
+// The transports can't run processes, like managed pluggable transports: the clients of the
+// control channel may be less privileged than this process, e.g. a service running as
+// LocalSystem.
+func (c *tunnelController) Connect(_ context.Context, params control.ConnectParams) error {
+	result := outline.NewClientWithoutSubprocesses(params.Transport)
+	if result.Error != nil {
+		return result.Error
+	}
+	return c.connect(result.Client, params.DNSFallback)
+}
+
+func (c *tunnelController) connect(client *outline.Client, dnsFallback bool) error {
+	if err := client.CheckVPNRouting(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tunnel != nil {
+		c.tunnel.setClient(client, dnsFallback)
+		c.since = time.Now()
+		logger.Info("transport replaced through the control channel")
+		return nil
+	}
+	t, err := startTunnel(client, dnsFallback)
+	if err != nil {
+		return err
+	}
+	c.tunnel = t
+	c.since = time.Now()
+	go func() {
+		err := t.Wait()
+		c.mu.Lock()
+		if c.tunnel == t {
+			c.tunnel = nil
+		}
+		c.mu.Unlock()
+		if c.onDisconnect != nil {
+			c.onDisconnect(err)
+		}
+	}()
+	return nil
+}
+
+// Disconnect disconnects the tunnel, if it is connected.
+func (c *tunnelController) Disconnect(context.Context) error {
+	c.mu.Lock()
+	t := c.tunnel
+	c.tunnel = nil
+	c.mu.Unlock()
+	if t != nil {
+		t.Close()
+	}
+	return nil
+}
+
+func (c *tunnelController) Status() control.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tunnel == nil {
+		return control.Status{}
+	}
+	return control.Status{Connected: true, Since: c.since}
+}
+
+func (c *tunnelController) Stats() stats.Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tunnel == nil {
+		return stats.Snapshot{}
+	}
+	return c.tunnel.session.Snapshot()
+}
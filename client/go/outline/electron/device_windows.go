@@ -0,0 +1,33 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"io"
+
+	"github.com/eycorsican/go-tun2socks/tun"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/wintun"
+)
+
+// openTunDevice opens the TUN device of the flags, with the driver of `-tunDriver`.
+func openTunDevice(dnsResolvers []string) (io.ReadWriteCloser, error) {
+	if *args.tunDriver == tunDriverWintun {
+		return wintun.Create(*args.tunName, *args.tunAddr, *args.tunMask, dnsResolvers)
+	}
+	return tun.OpenTunDevice(*args.tunName, *args.tunAddr, *args.tunGw, *args.tunMask, dnsResolvers, persistTun)
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+/*
+#include <stdlib.h>  // for C.free
+
+// EventCallback is a function registered by TypeScript to receive events.
+typedef void (*EventCallback)(const char *eventType, const char *data);
+
+static void callEventCallback(EventCallback cb, const char *eventType, const char *data) {
+	cb(eventType, data);
+}
+*/
+import "C"
+import "unsafe"
+
+// cgoEventListener is an [outline.EventListener] forwarding events to a C callback.
+type cgoEventListener struct {
+	cb C.EventCallback
+}
+
+func (l cgoEventListener) OnEvent(eventType string, data string) {
+	cEventType, cData := C.CString(eventType), C.CString(data)
+	defer C.free(unsafe.Pointer(cEventType))
+	defer C.free(unsafe.Pointer(cData))
+	C.callEventCallback(l.cb, cEventType, cData)
+}
+
+// newCGoEventListener creates a cgoEventListener from a pointer to an EventCallback.
+func newCGoEventListener(cb unsafe.Pointer) cgoEventListener {
+	return cgoEventListener{C.EventCallback(cb)}
+}
@@ -35,9 +35,11 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"unsafe"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
 )
 
@@ -57,6 +59,89 @@ func InvokeMethod(method *C.char, input *C.char) C.InvokeMethodResult {
 	}
 }
 
+var cancelersMu sync.Mutex
+var cancelers = make(map[C.int]*outline.Canceler)
+var nextCancelerID C.int = 1
+
+// NewCanceler creates a canceler and returns its ID, to be passed to exactly one
+// [InvokeMethodWithCanceler] call, which releases it.
+//
+//export NewCanceler
+func NewCanceler() C.int {
+	cancelersMu.Lock()
+	defer cancelersMu.Unlock()
+	id := nextCancelerID
+	nextCancelerID++
+	cancelers[id] = outline.NewCanceler()
+	return id
+}
+
+// Cancel aborts the [InvokeMethodWithCanceler] call using the canceler created by [NewCanceler].
+// Unknown IDs, including those of the calls that already returned, are ignored.
+//
+//export Cancel
+func Cancel(id C.int) {
+	cancelersMu.Lock()
+	canceler, ok := cancelers[id]
+	cancelersMu.Unlock()
+	if ok {
+		canceler.Cancel()
+	}
+}
+
+// InvokeMethodWithCanceler is like [InvokeMethod], but the call is aborted when the canceler with
+// the given ID is canceled by [Cancel].
+//
+//export InvokeMethodWithCanceler
+func InvokeMethodWithCanceler(method *C.char, input *C.char, cancelerID C.int) C.InvokeMethodResult {
+	cancelersMu.Lock()
+	canceler := cancelers[cancelerID]
+	cancelersMu.Unlock()
+	defer func() {
+		cancelersMu.Lock()
+		delete(cancelers, cancelerID)
+		cancelersMu.Unlock()
+	}()
+	result := outline.InvokeMethodWithCanceler(C.GoString(method), C.GoString(input), canceler)
+	return C.InvokeMethodResult{
+		Output:    newCGoString(result.Value),
+		ErrorJson: marshalCGoErrorJson(result.Error),
+	}
+}
+
+var subscriptionsMu sync.Mutex
+var subscriptions = make(map[C.int]*outline.Subscription)
+var nextSubscriptionID C.int = 1
+
+// Subscribe registers a C callback `void (*)(const char *eventType, const char *data)` to receive
+// the events of the given type. The strings passed to the callback are only valid during the call.
+//
+// It returns a subscription ID that must be passed to [Unsubscribe] to stop receiving events.
+//
+//export Subscribe
+func Subscribe(eventType *C.char, callback unsafe.Pointer) C.int {
+	sub := outline.Subscribe(C.GoString(eventType), newCGoEventListener(callback))
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	id := nextSubscriptionID
+	nextSubscriptionID++
+	subscriptions[id] = sub
+	return id
+}
+
+// Unsubscribe cancels the subscription returned by [Subscribe]. Unknown IDs are ignored.
+//
+//export Unsubscribe
+func Unsubscribe(id C.int) {
+	subscriptionsMu.Lock()
+	sub, ok := subscriptions[id]
+	delete(subscriptions, id)
+	subscriptionsMu.Unlock()
+	if ok {
+		sub.Unsubscribe()
+	}
+}
+
 // newCGoString allocates memory for a C string based on the given Go string.
 // It should be paired with [FreeCGoString] to avoid memory leaks.
 func newCGoString(s string) *C.char {
@@ -93,15 +178,10 @@ func marshalCGoErrorJson(e *platerrors.PlatformError) *C.char {
 }
 
 // init initializes the backend module.
-// It sets up a default logger based on the OUTLINE_DEBUG environment variable.
+// It sets the log level based on the OUTLINE_DEBUG environment variable.
 func init() {
-	opts := slog.HandlerOptions{Level: slog.LevelInfo}
-
 	dbg := os.Getenv("OUTLINE_DEBUG")
 	if dbg != "" && dbg != "false" && dbg != "0" {
-		opts.Level = slog.LevelDebug
+		logging.SetLevel(slog.LevelDebug)
 	}
-
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &opts))
-	slog.SetDefault(logger)
 }
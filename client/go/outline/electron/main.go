@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -24,15 +25,15 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/control"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/tun2socks"
 	_ "github.com/eycorsican/go-tun2socks/common/log/simple" // Register a simple logger.
 	"github.com/eycorsican/go-tun2socks/core"
 	"github.com/eycorsican/go-tun2socks/proxy/dnsfallback"
-	"github.com/eycorsican/go-tun2socks/tun"
 )
 
 // tun2socks exit codes. Must be kept in sync with definitions in "go_vpn_tunnel.ts"
@@ -42,9 +43,14 @@ const (
 	exitCodeFailure = 1
 )
 
+// Drivers of the TUN device on Windows, see `-tunDriver`.
+const (
+	tunDriverTAP    = "tap"
+	tunDriverWintun = "wintun"
+)
+
 const (
 	mtu        = 1500
-	udpTimeout = 30 * time.Second
 	persistTun = true // Linux: persist the TUN interface after the last open file descriptor is closed.
 )
 
@@ -57,14 +63,19 @@ type CheckConnectivityResult struct {
 }
 
 var args struct {
-	tunAddr *string
-	tunGw   *string
-	tunMask *string
-	tunName *string
-	tunDNS  *string
+	tunAddr   *string
+	tunGw     *string
+	tunMask   *string
+	tunName   *string
+	tunDNS    *string
+	tunDriver *string
 
 	transportConfig *string
 
+	controlSocket *string
+	controlUser   *string
+	service       *bool
+
 	logLevel          *string
 	checkConnectivity *bool
 	dnsFallback       *bool
@@ -84,6 +95,16 @@ var version string // Populated at build time through `-X main.version=...`
 //
 //   - Connectivity Check: If you run the app with `-checkConnectivity`, it will test the proxy's connectivity
 //     and exit with the result printed out to standard output.
+//
+// With `-controlSocket`, the app also serves a control channel on a Unix domain socket, or a named
+// pipe on Windows, see the control package. It replaces the transport, stops the tunnel, and
+// reports its status and statistics, without relying on the standard input and output.
+//
+// With `-service`, the app runs as a Windows service instead. It serves the control channel on
+// `-controlSocket`, and connects and disconnects the tunnel on request, so that the Electron app
+// doesn't need to run a process with administrator rights.
+// The pipe is only accessible to the system, the administrators, and the user of `-controlUser`,
+// who installed the service.
 func main() {
 	// VPN routing configs
 	args.tunAddr = flag.String("tunAddr", "10.0.85.2", "TUN interface IP address")
@@ -91,11 +112,17 @@ func main() {
 	args.tunMask = flag.String("tunMask", "255.255.255.0", "TUN interface network mask; prefixlen for IPv6")
 	args.tunDNS = flag.String("tunDNS", "1.1.1.1,9.9.9.9,208.67.222.222", "Comma-separated list of DNS resolvers for the TUN interface (Windows only)")
 	args.tunName = flag.String("tunName", "tun0", "TUN interface name")
+	args.tunDriver = flag.String("tunDriver", tunDriverTAP, "Driver of the TUN interface (Windows only): tap uses the installed TAP-Windows6 adapter, and wintun creates an adapter with wintun.dll, next to the executable")
 	args.dnsFallback = flag.Bool("dnsFallback", false, "Enable DNS fallback over TCP (overrides the UDP handler).")
 
 	// Proxy transport config
 	args.transportConfig = flag.String("transport", "", "A JSON object containing the transport config, UTF8-encoded")
 
+	// Control channel
+	args.controlSocket = flag.String("controlSocket", "", "Path of the Unix domain socket, or the Windows named pipe, of the control channel. Disabled if empty.")
+	args.controlUser = flag.String("controlUser", "", "SID of the user allowed to use the control pipe, besides the system and the administrators (Windows only). Defaults to the user of the process.")
+	args.service = flag.Bool("service", false, "Run as a Windows service, which connects the tunnel through the control channel.")
+
 	// Check connectivity of transportConfig and exit
 	args.checkConnectivity = flag.Bool("checkConnectivity", false, "Check the proxy TCP and UDP connectivity and exit.")
 
@@ -112,6 +139,11 @@ func main() {
 
 	setLogLevel(*args.logLevel)
 
+	if *args.service {
+		runService()
+		return
+	}
+
 	if len(*args.transportConfig) == 0 {
 		printErrorAndExit(platerrors.PlatformError{Code: platerrors.IllegalConfig, Message: "transport config missing"}, exitCodeFailure)
 	}
@@ -135,49 +167,63 @@ func main() {
 		os.Exit(exitCodeSuccess)
 	}
 
-	// Open TUN device
-	dnsResolvers := strings.Split(*args.tunDNS, ",")
-	tunDevice, err := tun.OpenTunDevice(*args.tunName, *args.tunAddr, *args.tunGw, *args.tunMask, dnsResolvers, persistTun)
-	if err != nil {
-		printErrorAndExit(platerrors.PlatformError{
-			Code:    platerrors.SetupSystemVPNFailed,
-			Message: "failed to open TUN device",
-			Cause:   platerrors.ToPlatformError(err),
-		}, exitCodeFailure)
-	}
-	// Output packets to TUN device
-	core.RegisterOutputFn(tunDevice.Write)
-
-	// Register TCP and UDP connection handlers
-	core.RegisterTCPConnHandler(tun2socks.NewTCPHandler(client))
-	if *args.dnsFallback {
-		// UDP connectivity not supported, fall back to DNS over TCP.
-		logger.Debug("Registering DNS fallback UDP handler")
-		core.RegisterUDPConnHandler(dnsfallback.NewUDPHandler())
-	} else {
-		core.RegisterUDPConnHandler(tun2socks.NewUDPHandler(client, udpTimeout))
+	disconnected := make(chan error, 1)
+	controller := newTunnelController(func(err error) { disconnected <- err })
+	if err := controller.connect(client, *args.dnsFallback); err != nil {
+		printErrorAndExit(err, exitCodeFailure)
 	}
+	defer controller.Disconnect(context.Background())
 
-	// Configure LWIP stack to receive input data from the TUN device
-	lwipWriter := core.NewLWIPStack()
-	go func() {
-		_, err := io.CopyBuffer(lwipWriter, tunDevice, make([]byte, mtu))
+	if *args.controlSocket != "" {
+		server, err := control.ListenConfig{User: *args.controlUser}.Listen(*args.controlSocket, controller)
 		if err != nil {
 			printErrorAndExit(platerrors.PlatformError{
-				Code:    platerrors.DataTransmissionFailed,
-				Message: "failed to write data to network stack",
+				Code:    platerrors.InternalError,
+				Message: "failed to listen on the control socket",
 				Cause:   platerrors.ToPlatformError(err),
 			}, exitCodeFailure)
 		}
-	}()
+		defer server.Close()
+	}
 
 	// This message is used in TypeScript to determine whether tun2socks has been started successfully
 	logger.Info("tun2socks running...")
 
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
-	sig := <-osSignals
-	logger.Debug("Received signal", "signal", sig)
+	select {
+	case sig := <-osSignals:
+		logger.Debug("Received signal", "signal", sig)
+	case err := <-disconnected:
+		if err != nil {
+			printErrorAndExit(err, exitCodeFailure)
+		}
+		logger.Debug("Disconnected through the control channel")
+	}
+}
+
+// registerConnHandlers makes the network stack relay the TCP and UDP traffic through client,
+// counted in session. It replaces the handlers of the previous client, if any. With dnsFallback,
+// it relays the DNS queries over TCP instead of the UDP traffic.
+func registerConnHandlers(client *outline.Client, session *stats.Session, dnsFallback bool) {
+	core.RegisterTCPConnHandler(tun2socks.NewTCPHandler(session.StreamDialer(client)))
+	var udpHandler core.UDPConnHandler
+	if dnsFallback && client.UDPFallback != nil {
+		// UDP connectivity not supported, fall back to UDP over TCP.
+		logger.Debug("Registering UDP-over-TCP fallback UDP handler")
+		udpHandler = tun2socks.NewUDPHandler(session.PacketListener(client.UDPFallback), client.UDPIdleTimeout, session.NewNATTable(client.UDPMaxSessions))
+	} else if dnsFallback {
+		// UDP connectivity not supported, fall back to DNS over TCP.
+		logger.Debug("Registering DNS fallback UDP handler")
+		udpHandler = dnsfallback.NewUDPHandler()
+	} else {
+		udpHandler = tun2socks.NewUDPHandler(session.PacketListener(client), client.UDPIdleTimeout, session.NewNATTable(client.UDPMaxSessions))
+	}
+	if client.DNSForwarder != nil {
+		logger.Debug("Intercepting DNS queries with the configured resolver")
+		udpHandler = tun2socks.NewDNSInterceptUDPHandler(client.DNSForwarder, udpHandler)
+	}
+	core.RegisterUDPConnHandler(udpHandler)
 }
 
 func setLogLevel(level string) {
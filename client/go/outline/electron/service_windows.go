@@ -0,0 +1,82 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/control"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// serviceName is the name of the service, as installed by "install_windows_service.bat".
+const serviceName = "OutlineTun2socks"
+
+// defaultServicePipe is the named pipe of the control channel of the service when
+// `-controlSocket` is empty. Must be kept in sync with "go_vpn_tunnel.ts".
+const defaultServicePipe = `\\.\pipe\OutlineTun2socksPipe`
+
+// tun2socksService is the Windows service connecting the tunnel through the control channel.
+type tun2socksService struct{}
+
+// runService runs the app as a Windows service, until the service manager stops it.
+func runService() {
+	if err := svc.Run(serviceName, tun2socksService{}); err != nil {
+		printErrorAndExit(platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to run the Windows service",
+			Cause:   platerrors.ToPlatformError(err),
+		}, exitCodeFailure)
+	}
+}
+
+func (tun2socksService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	pipe := *args.controlSocket
+	if pipe == "" {
+		pipe = defaultServicePipe
+	}
+	controller := newTunnelController(func(err error) {
+		if err != nil {
+			// E.g. the TAP device is closed when the system suspends. The app connects again.
+			logger.Warn("tunnel disconnected", "err", err)
+		}
+	})
+	server, err := control.ListenConfig{User: *args.controlUser}.Listen(pipe, controller)
+	if err != nil {
+		logger.Error("failed to listen on the control pipe", "pipe", pipe, "err", err)
+		return true, exitCodeFailure
+	}
+	defer server.Close()
+	defer controller.Disconnect(context.Background())
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	logger.Info("tun2socks service running...", "pipe", pipe)
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			return false, exitCodeSuccess
+		}
+	}
+	return false, exitCodeSuccess
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/icmpecho"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/quic"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+	"github.com/eycorsican/go-tun2socks/core"
+)
+
+// tun2socksTunnel relays the traffic of the TUN device through an Outline client.
+type tun2socksTunnel struct {
+	device  io.ReadWriteCloser
+	stack   core.LWIPStack
+	session *stats.Session
+
+	closeOnce sync.Once
+	stopOnce  sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+	err       error
+}
+
+// startTunnel opens the TUN device of the flags and relays its traffic through client. With
+// dnsFallback, it relays the DNS queries over TCP instead of the UDP traffic.
+func startTunnel(client *outline.Client, dnsFallback bool) (*tun2socksTunnel, error) {
+	dnsResolvers := strings.Split(*args.tunDNS, ",")
+	device, err := openTunDevice(dnsResolvers)
+	if err != nil {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.SetupSystemVPNFailed,
+			Message: "failed to open TUN device",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	// Output packets to TUN device
+	core.RegisterOutputFn(device.Write)
+
+	t := &tun2socksTunnel{
+		device:  device,
+		session: stats.StartSession(),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	// Register TCP and UDP connection handlers
+	registerConnHandlers(client, t.session, dnsFallback)
+
+	// Configure LWIP stack to receive input data from the TUN device
+	t.stack = core.NewLWIPStack()
+	var input io.Writer = t.stack
+	if client.BlockQUIC {
+		input = quic.NewBlockingWriter(t.stack, device)
+	}
+	input = icmpecho.NewWriter(input, device, client.ICMPEcho, icmpecho.NewTLSProber(client.StreamDialer))
+	go t.relay(input)
+	return t, nil
+}
+
+// relay copies the packets of the device to input until the device fails or is closed.
+func (t *tun2socksTunnel) relay(input io.Writer) {
+	defer close(t.done)
+	_, err := io.CopyBuffer(input, t.device, make([]byte, mtu))
+	select {
+	case <-t.closed:
+		return
+	default:
+	}
+	if err == nil {
+		err = errors.New("TUN device closed")
+	}
+	t.err = platerrors.PlatformError{
+		Code:    platerrors.DataTransmissionFailed,
+		Message: "failed to write data to network stack",
+		Cause:   platerrors.ToPlatformError(err),
+	}
+	t.stop()
+}
+
+// setClient relays the new connections through client. The existing connections keep their
+// client, and the QUIC policy of the tunnel doesn't change.
+func (t *tun2socksTunnel) setClient(client *outline.Client, dnsFallback bool) {
+	registerConnHandlers(client, t.session, dnsFallback)
+}
+
+// Close disconnects the tunnel, and waits for it to stop.
+func (t *tun2socksTunnel) Close() {
+	t.closeOnce.Do(func() { close(t.closed) })
+	t.stop()
+	<-t.done
+}
+
+// stop releases the device and the network stack, which ends the relay.
+func (t *tun2socksTunnel) stop() {
+	t.stopOnce.Do(func() {
+		t.device.Close()
+		t.stack.Close()
+		stats.EndSession(t.session)
+	})
+}
+
+// Wait waits for the tunnel to stop, and returns the error that stopped it, or nil if it was
+// closed by [tun2socksTunnel.Close].
+func (t *tun2socksTunnel) Wait() error {
+	<-t.done
+	return t.err
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// lookupFunc returns the addresses of a host name, of a single IP family.
+type lookupFunc = func(ctx context.Context, host string) ([]netip.Addr, error)
+
+// Resolution strategies of the "hostResolution" config section.
+const (
+	hostResolveDial = "dial"
+	hostResolveOnce = "once"
+)
+
+// hostResolverDoH is the "hostResolution" resolver using DoH servers.
+const hostResolverDoH = "doh"
+
+// defaultHostDoHServers are the DoH servers resolving the proxy server host name when the
+// config lists none. They are addressed by IP, so that connecting to them doesn't need the DNS.
+var defaultHostDoHServers = []string{
+	"https://1.1.1.1/dns-query",
+	"https://8.8.8.8/dns-query",
+	"https://9.9.9.9/dns-query",
+}
+
+// hostResolutionConfigJSON is the "hostResolution" section of the transport config. It controls
+// how the host name of the proxy server is resolved.
+type hostResolutionConfigJSON struct {
+	// Resolve is "dial" (default) to resolve the host name at every dial, or "once" to resolve it
+	// at the first successful dial and reuse the addresses for the lifetime of the client.
+	Resolve string `json:"resolve,omitempty"`
+
+	// FallbackIPs are addresses of the proxy server tried after the resolved ones, and instead of
+	// them if the host name fails to resolve, e.g. when the DNS answers are poisoned.
+	FallbackIPs []string `json:"fallbackIps,omitempty"`
+
+	// Resolver is "doh" to resolve the host name with DoH servers instead of the "dns" section or
+	// the system resolver, which may answer poisoned addresses. The system resolver is still used
+	// when none of the DoH servers can be reached.
+	Resolver string `json:"resolver,omitempty"`
+
+	// DoHServers are the https URLs of the DoH servers of the "doh" resolver, tried in order.
+	// Defaults to a bundled list of public servers.
+	DoHServers []string `json:"dohServers,omitempty"`
+}
+
+// hostResolution is how the host name of the proxy server is resolved.
+type hostResolution struct {
+	// resolver resolves the host name, or the system resolver if nil.
+	resolver dns.Resolver
+	// once pins the addresses of the first successful resolution.
+	once bool
+	// fallbackIPs are appended to the resolved addresses of the same family.
+	fallbackIPs []netip.Addr
+	// systemFallback resolves the host name with the system resolver when resolver can't be
+	// reached.
+	systemFallback bool
+}
+
+// hostResolution returns how the host name of the proxy server is resolved, according to the
+// "dns" and "hostResolution" sections of the config.
+func (conf *configJSON) hostResolution(tcpDialer, udpDialer net.Dialer) (hostResolution, error) {
+	resolver, err := conf.endpointResolver(tcpDialer, udpDialer)
+	if err != nil {
+		return hostResolution{}, err
+	}
+	res := hostResolution{resolver: resolver}
+	if conf.HostResolution == nil {
+		return res, nil
+	}
+	switch conf.HostResolution.Resolve {
+	case "", hostResolveDial:
+	case hostResolveOnce:
+		res.once = true
+	default:
+		return hostResolution{}, newIllegalConfigErrorWithDetails("host resolution strategy is not valid",
+			"hostResolution.resolve", conf.HostResolution.Resolve, fmt.Sprintf("%q or %q", hostResolveDial, hostResolveOnce), nil)
+	}
+	for i, s := range conf.HostResolution.FallbackIPs {
+		ip, err := netip.ParseAddr(s)
+		if err != nil || ip.Zone() != "" {
+			return hostResolution{}, newIllegalConfigErrorWithDetails("fallback IP is not valid",
+				fmt.Sprintf("hostResolution.fallbackIps[%d]", i), s, "an IPv4 or IPv6 address", err)
+		}
+		res.fallbackIPs = append(res.fallbackIPs, ip.Unmap())
+	}
+	switch conf.HostResolution.Resolver {
+	case "":
+	case hostResolverDoH:
+		res.resolver, err = conf.HostResolution.newDoHResolver(tcpDialer, udpDialer)
+		if err != nil {
+			return hostResolution{}, err
+		}
+		res.systemFallback = true
+	default:
+		return hostResolution{}, newIllegalConfigErrorWithDetails("host resolver is not valid",
+			"hostResolution.resolver", conf.HostResolution.Resolver, fmt.Sprintf("%q", hostResolverDoH), nil)
+	}
+	return res, nil
+}
+
+// newDoHResolver creates the resolver trying the DoH servers in order, connecting to them directly
+// with tcpDialer and udpDialer.
+func (c *hostResolutionConfigJSON) newDoHResolver(tcpDialer, udpDialer net.Dialer) (dns.Resolver, error) {
+	path, urls := "hostResolution.dohServers", c.DoHServers
+	if len(urls) == 0 {
+		urls = defaultHostDoHServers
+	}
+	configs := make([]dnsResolverJSON, len(urls))
+	for i, u := range urls {
+		configs[i] = dnsResolverJSON{Type: dnsResolverTypeDoH, URL: u}
+	}
+	resolver, err := newFallbackResolver(path, configs, &transport.TCPDialer{Dialer: tcpDialer}, &transport.UDPDialer{Dialer: udpDialer})
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("DoH servers are not valid", path, err.Error(), "https URLs", err)
+	}
+	return resolver, nil
+}
+
+// lookupFuncs returns the IPv6 and IPv4 lookup functions of the resolution.
+func (res hostResolution) lookupFuncs() (lookupIPv6, lookupIPv4 lookupFunc) {
+	lookupIPv6, lookupIPv4 = newLookupFuncs(res.resolver)
+	if res.systemFallback {
+		systemIPv6, systemIPv4 := newLookupFuncs(nil)
+		lookupIPv6, lookupIPv4 = fallbackLookup(lookupIPv6, systemIPv6), fallbackLookup(lookupIPv4, systemIPv4)
+	}
+	return res.wrap(lookupIPv6, netip.Addr.Is6), res.wrap(lookupIPv4, netip.Addr.Is4)
+}
+
+// wrap applies the pinning and the fallback IPs of the family to lookup.
+func (res hostResolution) wrap(lookup lookupFunc, inFamily func(netip.Addr) bool) lookupFunc {
+	var fallbacks []netip.Addr
+	for _, ip := range res.fallbackIPs {
+		if inFamily(ip) {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if res.once {
+		lookup = pinLookup(lookup)
+	}
+	if len(fallbacks) == 0 {
+		return lookup
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		ips, err := lookup(ctx, host)
+		if err != nil {
+			logger.Debug("failed to resolve the proxy server, using the fallback IPs", "err", err)
+			return fallbacks, nil
+		}
+		for _, ip := range fallbacks {
+			if !containsAddr(ips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, nil
+	}
+}
+
+// fallbackLookup returns a lookup function calling fallback when the resolver of lookup can't be
+// reached. Answers of the resolver, even without addresses, are not retried, since the fallback
+// resolver is usually the one answering poisoned addresses.
+func fallbackLookup(lookup, fallback lookupFunc) lookupFunc {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		ips, err := lookup(ctx, host)
+		if err == nil || ctx.Err() != nil || !isResolverUnreachable(err) {
+			return ips, err
+		}
+		logger.Debug("failed to reach the resolver of the proxy server, using the system resolver", "err", err)
+		return fallback(ctx, host)
+	}
+}
+
+// isResolverUnreachable returns whether err is a failure to exchange messages with a resolver.
+func isResolverUnreachable(err error) bool {
+	for _, target := range []error{dns.ErrDial, dns.ErrSend, dns.ErrReceive, dns.ErrBadResponse} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinLookup returns a lookup function returning the addresses of the first successful lookup of
+// each host. Failures are not cached, so the next dial resolves again.
+func pinLookup(lookup lookupFunc) lookupFunc {
+	var mu sync.Mutex
+	pinned := make(map[string][]netip.Addr)
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		mu.Lock()
+		ips, ok := pinned[host]
+		mu.Unlock()
+		if ok {
+			return append([]netip.Addr(nil), ips...), nil
+		}
+		ips, err := lookup(ctx, host)
+		if err != nil || len(ips) == 0 {
+			return ips, err
+		}
+		mu.Lock()
+		pinned[host] = ips
+		mu.Unlock()
+		return append([]netip.Addr(nil), ips...), nil
+	}
+}
+
+func containsAddr(ips []netip.Addr, ip netip.Addr) bool {
+	for _, other := range ips {
+		if other.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// newLookupFuncs returns the IPv6 and IPv4 lookup functions of resolver, or of the system resolver
+// if it is nil.
+func newLookupFuncs(resolver dns.Resolver) (lookupIPv6, lookupIPv4 lookupFunc) {
+	if resolver == nil {
+		lookup := func(network string) lookupFunc {
+			return func(ctx context.Context, host string) ([]netip.Addr, error) {
+				return net.DefaultResolver.LookupNetIP(ctx, network, host)
+			}
+		}
+		return lookup("ip6"), lookup("ip4")
+	}
+	lookup := func(qtype dnsmessage.Type) lookupFunc {
+		return func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return lookupIPs(ctx, resolver, host, qtype)
+		}
+	}
+	return lookup(dnsmessage.TypeAAAA), lookup(dnsmessage.TypeA)
+}
+
+// newProxyEndpoints creates the endpoints of the proxy server at address, resolving its host name
+// according to res.
+//
+// TCP connections use Happy Eyeballs (RFC 8305): IPv6 and IPv4 addresses are resolved and dialed
+// concurrently with staggered starts, and the first established connection wins, so that broken
+// IPv6 connectivity doesn't stall the connection setup. UDP has no handshake to race, so it uses
+// the first IPv4 address, or else IPv6 address.
+func newProxyEndpoints(
+	res hostResolution, address string, tcpDialer, udpDialer net.Dialer,
+) (transport.StreamEndpoint, transport.PacketEndpoint) {
+	lookupIPv6, lookupIPv4 := res.lookupFuncs()
+	tcpEndpoint := &transport.StreamDialerEndpoint{
+		Dialer: &transport.HappyEyeballsStreamDialer{
+			Dialer:  &transport.TCPDialer{Dialer: tcpDialer},
+			Resolve: transport.NewParallelHappyEyeballsResolveFunc(lookupIPv6, lookupIPv4),
+		},
+		Address: address,
+	}
+	if res.resolver == nil && !res.once && len(res.fallbackIPs) == 0 {
+		return tcpEndpoint, &transport.UDPEndpoint{Address: address, Dialer: udpDialer}
+	}
+	udpEndpoint := transport.FuncPacketEndpoint(func(ctx context.Context) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ip, err := firstHostIP(ctx, host, lookupIPv4, lookupIPv6)
+		if err != nil {
+			return nil, err
+		}
+		return udpDialer.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), port))
+	})
+	return tcpEndpoint, udpEndpoint
+}
+
+// resolveHostIP returns the first IPv4, or else IPv6, address of host.
+func resolveHostIP(ctx context.Context, resolver dns.Resolver, host string) (netip.Addr, error) {
+	lookupIPv6, lookupIPv4 := newLookupFuncs(resolver)
+	return firstHostIP(ctx, host, lookupIPv4, lookupIPv6)
+}
+
+// firstHostIP returns the first address of host returned by the lookups, in order.
+func firstHostIP(ctx context.Context, host string, lookups ...lookupFunc) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip, nil
+	}
+	var lastErr error
+	for _, lookup := range lookups {
+		ips, err := lookup(ctx, host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(ips) > 0 {
+			return ips[0], nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no IP address found for %s", host)
+	}
+	return netip.Addr{}, lastErr
+}
+
+// lookupIPs returns the addresses in the A or AAAA (qtype) records of host.
+func lookupIPs(ctx context.Context, resolver dns.Resolver, host string, qtype dnsmessage.Type) ([]netip.Addr, error) {
+	q, err := dns.NewQuestion(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := resolver.Query(ctx, *q)
+	if err != nil {
+		return nil, err
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("failed to resolve %s: %v", host, msg.RCode)
+	}
+	var ips []netip.Addr
+	for _, answer := range msg.Answers {
+		switch rr := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, netip.AddrFrom4(rr.A))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, netip.AddrFrom16(rr.AAAA))
+		}
+	}
+	return ips, nil
+}
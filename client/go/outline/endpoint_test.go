@@ -0,0 +1,213 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNewProxyEndpoints_HappyEyeballs(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// The IPv6 address is not reachable, so the connection must fall back to IPv4.
+	resolver := newTestResolver(dnsmessage.RCodeSuccess, []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("100::1").As16()},
+	}, {
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+	}}, nil)
+	tcpEndpoint, _ := newProxyEndpoints(hostResolution{resolver: resolver}, net.JoinHostPort("proxy.example", fmt.Sprint(port)), net.Dialer{}, net.Dialer{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tcpEndpoint.ConnectStream(ctx)
+	require.NoError(t, err)
+	require.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+}
+
+func TestResolveHostIP(t *testing.T) {
+	resolver := newTestResolver(dnsmessage.RCodeSuccess, []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2001:db8::1").As16()},
+	}}, nil)
+
+	ip, err := resolveHostIP(context.Background(), resolver, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddr("2001:db8::1"), ip)
+
+	ip, err = resolveHostIP(context.Background(), resolver, "192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddr("192.0.2.1"), ip)
+
+	_, err = resolveHostIP(context.Background(), newTestResolver(dnsmessage.RCodeSuccess, nil, nil), "example.com")
+	require.Error(t, err)
+}
+
+func TestConfigJSON_HostResolution(t *testing.T) {
+	conf, err := parseConfigFromJSON(`{"hostResolution": {"resolve": "once", "fallbackIps": ["192.0.2.1", "::ffff:192.0.2.2", "2001:db8::1"]}}`)
+	require.NoError(t, err)
+	res, err := conf.hostResolution(net.Dialer{}, net.Dialer{})
+	require.NoError(t, err)
+	require.True(t, res.once)
+	require.Equal(t, []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::1"),
+	}, res.fallbackIPs)
+
+	for _, input := range []string{
+		`{"hostResolution": {"resolve": "never"}}`,
+		`{"hostResolution": {"fallbackIps": ["example.com"]}}`,
+		`{"hostResolution": {"fallbackIps": ["fe80::1%eth0"]}}`,
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err)
+		_, err = conf.hostResolution(net.Dialer{}, net.Dialer{})
+		require.Equal(t, platerrors.IllegalConfig, platerrors.ToPlatformError(err).Code, input)
+	}
+}
+
+func TestConfigJSON_HostResolution_DoH(t *testing.T) {
+	conf, err := parseConfigFromJSON(`{"hostResolution": {"resolver": "doh"}}`)
+	require.NoError(t, err)
+	res, err := conf.hostResolution(net.Dialer{}, net.Dialer{})
+	require.NoError(t, err)
+	require.True(t, res.systemFallback)
+	require.Len(t, res.resolver, len(defaultHostDoHServers))
+
+	conf, err = parseConfigFromJSON(`{"hostResolution": {"resolver": "doh", "dohServers": ["https://doh.example/dns-query"]}}`)
+	require.NoError(t, err)
+	res, err = conf.hostResolution(net.Dialer{}, net.Dialer{})
+	require.NoError(t, err)
+	require.Len(t, res.resolver, 1)
+
+	for _, input := range []string{
+		`{"hostResolution": {"resolver": "dot"}}`,
+		`{"hostResolution": {"resolver": "doh", "dohServers": ["http://doh.example/dns-query"]}}`,
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err)
+		_, err = conf.hostResolution(net.Dialer{}, net.Dialer{})
+		require.Equal(t, platerrors.IllegalConfig, platerrors.ToPlatformError(err).Code, input)
+	}
+}
+
+func TestFallbackLookup(t *testing.T) {
+	systemIP := netip.MustParseAddr("192.0.2.1")
+	system := func(context.Context, string) ([]netip.Addr, error) {
+		return []netip.Addr{systemIP}, nil
+	}
+
+	unreachable := newTestResolver(dnsmessage.RCodeSuccess, nil, fmt.Errorf("%w: timeout", dns.ErrDial))
+	_, lookupIPv4 := newLookupFuncs(unreachable)
+	ips, err := fallbackLookup(lookupIPv4, system)(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{systemIP}, ips)
+
+	// Answers of the resolver are not retried with the system resolver.
+	nxdomain := newTestResolver(dnsmessage.RCodeNameError, nil, nil)
+	_, lookupIPv4 = newLookupFuncs(nxdomain)
+	_, err = fallbackLookup(lookupIPv4, system)(context.Background(), "example.com")
+	require.Error(t, err)
+}
+
+func TestPinLookup(t *testing.T) {
+	calls := 0
+	fail := true
+	lookup := pinLookup(func(context.Context, string) ([]netip.Addr, error) {
+		calls++
+		if fail {
+			return nil, errors.New("timeout")
+		}
+		return []netip.Addr{netip.AddrFrom4([4]byte{192, 0, 2, byte(calls)})}, nil
+	})
+	_, err := lookup(context.Background(), "example.com")
+	require.Error(t, err)
+
+	fail = false
+	for i := 0; i < 3; i++ {
+		ips, err := lookup(context.Background(), "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.2")}, ips)
+	}
+	require.Equal(t, 2, calls)
+}
+
+func TestNewProxyEndpoints_FallbackIPs(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	address := net.JoinHostPort("proxy.example", fmt.Sprint(port))
+	fallback := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+
+	// The poisoned answer refuses the connections, so the fallback IP must be dialed.
+	poisoned := newTestResolver(dnsmessage.RCodeSuccess, []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}},
+	}}, nil)
+	nxdomain := newTestResolver(dnsmessage.RCodeNameError, nil, nil)
+	for _, tt := range []struct {
+		resolver dns.Resolver
+		checkUDP bool
+	}{{poisoned, false}, {nxdomain, true}} {
+		tcpEndpoint, udpEndpoint := newProxyEndpoints(hostResolution{resolver: tt.resolver, fallbackIPs: fallback}, address, net.Dialer{}, net.Dialer{})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := tcpEndpoint.ConnectStream(ctx)
+		cancel()
+		require.NoError(t, err)
+		require.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+
+		if tt.checkUDP {
+			udpConn, err := udpEndpoint.ConnectPacket(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, net.JoinHostPort("127.0.0.1", fmt.Sprint(port)), udpConn.RemoteAddr().String())
+			udpConn.Close()
+		}
+	}
+}
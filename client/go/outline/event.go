@@ -0,0 +1,107 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+)
+
+// Event type constants
+const (
+	// EventConfigChanged is emitted when a refreshed dynamic key resolves to a different transport.
+	//  - Data: a JSON string of configChangedEventJSON.
+	EventConfigChanged = event.ConfigChanged
+
+	// EventConnectionStatusChanged is emitted when a health monitor detects that the connection
+	// status changed, e.g. from CONNECTED to RECONNECTING.
+	//  - Data: a JSON string of connectionStatusEventJSON.
+	EventConnectionStatusChanged = event.ConnectionStatusChanged
+
+	// EventReconnectAttempt is emitted when the health monitor of the VPN schedules an attempt to
+	// reconnect to the server, with the delay until the attempt.
+	//  - Data: a JSON string of reconnectAttemptEventJSON.
+	EventReconnectAttempt = event.ReconnectAttempt
+
+	// EventUDPSupportChanged is emitted when the tunnel starts or stops proxying UDP traffic.
+	//  - Data: a JSON string of event.UDPSupportChangedData.
+	EventUDPSupportChanged = event.UDPSupportChanged
+
+	// EventStatsTick is emitted every second with the traffic statistics of the active tunnel
+	// session, as long as there is a subscription to it.
+	//  - Data: a JSON string of stats.Snapshot.
+	EventStatsTick = event.StatsTick
+
+	// EventOnDemandAction is emitted when the connect-on-demand rules decide that the VPN should
+	// connect or disconnect. The host app is responsible for carrying out the action.
+	//  - Data: a JSON string of onDemandActionEventJSON.
+	EventOnDemandAction = event.OnDemandAction
+
+	// EventQuotaWarning is emitted when the traffic of a transport reaches 80% and 100% of the
+	// data allowance in the "quota" of its config, so that the app can warn the user before the
+	// provider cuts access.
+	//  - Data: a JSON string of stats.QuotaUsage.
+	EventQuotaWarning = event.QuotaWarning
+
+	// EventTransportFallback is emitted when a "fallback" transport detects that the network blocks
+	// its current transport mid-session, and switches to the next transport of its list.
+	//  - Data: a JSON string of transportFallbackEventJSON.
+	EventTransportFallback = event.TransportFallback
+)
+
+// EventListener receives events emitted by the Go code.
+// It is implemented by the host app (Swift, Java or TypeScript).
+type EventListener interface {
+	// OnEvent is called with the type of the event and a JSON string containing the event data.
+	OnEvent(eventType string, data string)
+}
+
+// Subscription represents an [EventListener] subscribed to an event type.
+type Subscription struct {
+	sub *event.Subscription
+}
+
+// Subscribe registers l to receive the events of eventType, until the returned [Subscription] is
+// unsubscribed.
+func Subscribe(eventType string, l EventListener) *Subscription {
+	s := &Subscription{event.Subscribe(eventType, l)}
+	updateStatsTicker()
+	return s
+}
+
+// Unsubscribe stops the delivery of events to the listener. It is safe to call it more than once.
+func (s *Subscription) Unsubscribe() {
+	s.sub.Unsubscribe()
+	updateStatsTicker()
+}
+
+var listenerMu sync.Mutex
+var listenerSub *event.Subscription
+
+// SetEventListener sets the [EventListener] that receives all events. Pass nil to stop receiving.
+//
+// Deprecated: use [Subscribe] to receive specific events.
+func SetEventListener(l EventListener) {
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+	if listenerSub != nil {
+		listenerSub.Unsubscribe()
+		listenerSub = nil
+	}
+	if l != nil {
+		listenerSub = event.SubscribeAll(l)
+	}
+}
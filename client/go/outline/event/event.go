@@ -0,0 +1,146 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package event delivers the events emitted by the Go code to the subscribers in the host app.
+//
+// Events are identified by a type, and carry a JSON string with the event data. Listeners can
+// subscribe to a single event type with [Subscribe], or to all event types with [SubscribeAll].
+package event
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+)
+
+// Event types.
+const (
+	// ConfigChanged is emitted when a refreshed dynamic key resolves to a different transport.
+	ConfigChanged = "ConfigChanged"
+
+	// ConnectionStatusChanged is emitted when a health monitor detects that the connection
+	// status changed, e.g. from CONNECTED to RECONNECTING.
+	ConnectionStatusChanged = "ConnectionStatusChanged"
+
+	// ReconnectAttempt is emitted when the health monitor of the VPN schedules an attempt to
+	// reconnect to the server.
+	ReconnectAttempt = "ReconnectAttempt"
+
+	// UDPSupportChanged is emitted when the tunnel switches between proxying UDP traffic and
+	// falling back to DNS over TCP, or to UDP over TCP.
+	//  - Data: a JSON string of [UDPSupportChangedData].
+	UDPSupportChanged = "UDPSupportChanged"
+
+	// StatsTick is emitted periodically with the traffic statistics of the active tunnel, as long
+	// as it has subscribers.
+	StatsTick = "StatsTick"
+
+	// OnDemandAction is emitted when the connect-on-demand rules decide that the VPN should
+	// connect or disconnect, e.g. after a network change.
+	OnDemandAction = "OnDemandAction"
+
+	// QuotaWarning is emitted when the traffic of a transport reaches a threshold of the data
+	// allowance of its provider.
+	QuotaWarning = "QuotaWarning"
+
+	// TransportFallback is emitted when a fallback transport detects that its transport is blocked
+	// and switches to the next one.
+	TransportFallback = "TransportFallback"
+)
+
+// UDPSupportChangedData is the data of the [UDPSupportChanged] event.
+type UDPSupportChangedData struct {
+	SupportsUDP bool `json:"supportsUdp"`
+
+	// OverTCP is whether the UDP traffic is relayed over TCP because the server or the network
+	// doesn't support UDP. SupportsUDP is false in that case.
+	OverTCP bool `json:"overTcp,omitempty"`
+}
+
+// Listener receives the events a [Subscription] has subscribed to.
+type Listener interface {
+	// OnEvent is called with the type of the event and a JSON string containing the event data.
+	OnEvent(eventType string, data string)
+}
+
+// Subscription is a registered [Listener], returned by [Subscribe] and [SubscribeAll].
+type Subscription struct {
+	eventType string // Empty for all events.
+	listener  Listener
+}
+
+var mu sync.RWMutex
+var subscriptions = make(map[*Subscription]struct{})
+
+// Subscribe registers l to receive the events of eventType.
+func Subscribe(eventType string, l Listener) *Subscription {
+	return subscribe(&Subscription{eventType: eventType, listener: l})
+}
+
+// SubscribeAll registers l to receive the events of all types.
+func SubscribeAll(l Listener) *Subscription {
+	return subscribe(&Subscription{listener: l})
+}
+
+func subscribe(s *Subscription) *Subscription {
+	mu.Lock()
+	defer mu.Unlock()
+	subscriptions[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops the delivery of events to s. It is safe to call it more than once.
+func (s *Subscription) Unsubscribe() {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(subscriptions, s)
+}
+
+// HasSubscribers returns whether any [Subscription] was made specifically for eventType.
+// Subscriptions to all events are not taken into account, so events that are expensive to
+// produce can be skipped when nobody asked for them.
+func HasSubscribers(eventType string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for s := range subscriptions {
+		if s.eventType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit marshals data to JSON and delivers it to the subscribers of eventType.
+func Emit(eventType string, data any) {
+	var listeners []Listener
+	mu.RLock()
+	for s := range subscriptions {
+		if s.eventType == "" || s.eventType == eventType {
+			listeners = append(listeners, s.listener)
+		}
+	}
+	mu.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("failed to marshal event data", "event", eventType, "err", err)
+		return
+	}
+	for _, l := range listeners {
+		l.OnEvent(eventType, string(dataJSON))
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeListener struct {
+	events [][2]string
+}
+
+func (l *fakeListener) OnEvent(eventType string, data string) {
+	l.events = append(l.events, [2]string{eventType, data})
+}
+
+func TestSubscribe(t *testing.T) {
+	udp, all := &fakeListener{}, &fakeListener{}
+	udpSub := Subscribe(UDPSupportChanged, udp)
+	allSub := SubscribeAll(all)
+	defer allSub.Unsubscribe()
+	require.True(t, HasSubscribers(UDPSupportChanged))
+	require.False(t, HasSubscribers(StatsTick))
+
+	Emit(UDPSupportChanged, UDPSupportChangedData{SupportsUDP: true})
+	Emit(StatsTick, map[string]int{"txBytes": 1})
+	require.Equal(t, [][2]string{{UDPSupportChanged, `{"supportsUdp":true}`}}, udp.events)
+	require.Equal(t, [][2]string{
+		{UDPSupportChanged, `{"supportsUdp":true}`},
+		{StatsTick, `{"txBytes":1}`},
+	}, all.events)
+
+	udpSub.Unsubscribe()
+	udpSub.Unsubscribe()
+	require.False(t, HasSubscribers(UDPSupportChanged))
+	Emit(UDPSupportChanged, UDPSupportChangedData{})
+	require.Len(t, udp.events, 1)
+	require.Len(t, all.events, 3)
+}
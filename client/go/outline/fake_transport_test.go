@@ -0,0 +1,316 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// transportTypeFake is an in-memory transport for the end-to-end tests of the tunnel logic. It
+// is only registered in the test builds.
+const transportTypeFake = "fake"
+
+// fakeTransportConfigJSON is the config of the "fake" transport.
+type fakeTransportConfigJSON struct {
+	// Server is the name of the [fakeServer] the tests control, e.g. to make it unreachable.
+	Server string `json:"server,omitempty"`
+
+	// Responses are the canned responses by destination address, sent after the first read of
+	// a stream, or for each packet. The other destinations echo.
+	Responses map[string]string `json:"responses,omitempty"`
+
+	// LatencyMs delays the dials and the packets.
+	LatencyMs int `json:"latencyMs,omitempty"`
+}
+
+func init() {
+	transportRegistry[transportTypeFake] = parseFakeTransport
+}
+
+// fakeServer is the state of the servers of the "fake" transports with the same name.
+type fakeServer struct {
+	// down makes the server unreachable.
+	down atomic.Bool
+	// streams and packets count the relayed streams and packets.
+	streams, packets atomic.Int64
+}
+
+var fakeServers sync.Map
+
+// getFakeServer returns the fake server of the "fake" transports whose "server" is name.
+func getFakeServer(name string) *fakeServer {
+	s, _ := fakeServers.LoadOrStore(name, &fakeServer{})
+	return s.(*fakeServer)
+}
+
+// newFakeServer returns a new fake server of the "fake" transports whose "server" is the name of
+// the test.
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{}
+	fakeServers.Store(t.Name(), s)
+	t.Cleanup(func() { fakeServers.Delete(t.Name()) })
+	return s
+}
+
+var errFakeServerDown = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+func parseFakeTransport(config json.RawMessage, _ TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf fakeTransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("fake transport config is not a valid JSON", string(config), err)
+	}
+	f := &fakeTransport{server: getFakeServer(conf.Server), responses: conf.Responses,
+		latency: time.Duration(conf.LatencyMs) * time.Millisecond}
+	return f, f, nil
+}
+
+// fakeTransport relays the streams and packets to an in-memory [fakeServer].
+type fakeTransport struct {
+	server    *fakeServer
+	responses map[string]string
+	latency   time.Duration
+}
+
+func (f *fakeTransport) wait(ctx context.Context) error {
+	if f.latency == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(f.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeTransport) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	if f.server.down.Load() {
+		return nil, errFakeServerDown
+	}
+	f.server.streams.Add(1)
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		response, ok := f.responses[addr]
+		if !ok {
+			io.Copy(server, server)
+			return
+		}
+		if _, err := server.Read(make([]byte, 4096)); err == nil {
+			server.Write([]byte(response))
+		}
+	}()
+	return &pipeStreamConn{Conn: client}, nil
+}
+
+func (f *fakeTransport) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	if f.server.down.Load() {
+		return nil, errFakeServerDown
+	}
+	return &fakePacketConn{transport: f, queue: make(chan fakePacket, 64), closed: make(chan struct{})}, nil
+}
+
+type fakePacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// fakePacketConn answers the packets with their canned response, or echoes them.
+type fakePacketConn struct {
+	transport *fakeTransport
+	queue     chan fakePacket
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+var _ net.PacketConn = (*fakePacketConn)(nil)
+
+func (c *fakePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if c.transport.server.down.Load() {
+		// The packets to an unreachable server are lost.
+		return len(b), nil
+	}
+	c.transport.server.packets.Add(1)
+	response := append([]byte(nil), b...)
+	if r, ok := c.transport.responses[addr.String()]; ok {
+		response = []byte(r)
+	}
+	time.AfterFunc(c.transport.latency, func() {
+		select {
+		case c.queue <- fakePacket{data: response, addr: addr}:
+		default:
+		}
+	})
+	return len(b), nil
+}
+
+func (c *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.queue:
+		return copy(b, p.data), p.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *fakePacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakePacketConn) LocalAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func (c *fakePacketConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *fakePacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakePacketConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestFakeTransport_Connectivity(t *testing.T) {
+	server := newFakeServer(t)
+	config := `{"$type":"fake","server":"` + t.Name() + `"}`
+	res, err := runConnectivityTest(context.Background(), config)
+	require.NoError(t, err)
+	require.True(t, res.TCP.Success, res.TCP.Error)
+	require.True(t, res.UDP.Success, res.UDP.Error)
+
+	server.down.Store(true)
+	res, err = runConnectivityTest(context.Background(), config)
+	require.NoError(t, err)
+	require.False(t, res.TCP.Success)
+	require.Equal(t, platerrors.ProxyServerUnreachable, res.TCP.Error.Code)
+	require.False(t, res.UDP.Success)
+}
+
+func TestFakeTransport_CannedResponses(t *testing.T) {
+	result := NewClient(`{"$type":"fake","responses":{"example.com:80":"HTTP/1.1 204 No Content\r\n\r\n","192.0.2.1:53":"answer"}}`)
+	require.Nil(t, result.Error)
+	client := result.Client
+
+	conn, err := client.DialStream(context.Background(), "example.com:80")
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", string(got))
+	conn.Close()
+
+	pc, err := client.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(time.Second))
+	_, err = pc.WriteTo([]byte("query"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53})
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "answer", string(buf[:n]))
+}
+
+func TestFakeTransport_Quota(t *testing.T) {
+	l := &fakeEventListener{events: make(chan [2]string, 2)}
+	defer Subscribe(EventQuotaWarning, l).Unsubscribe()
+
+	result := NewClient(`{"$type":"fake","quota":{"bytesAllowed":1000}}`)
+	require.Nil(t, result.Error)
+	conn, err := result.Client.DialStream(context.Background(), "192.0.2.1:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	msg := make([]byte, 500)
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, msg)
+	require.NoError(t, err)
+
+	select {
+	case ev := <-l.events:
+		require.Equal(t, EventQuotaWarning, ev[0])
+	case <-time.After(time.Second):
+		t.Fatal("no quota warning")
+	}
+}
+
+func TestFakeTransport_Reconnect(t *testing.T) {
+	server := newFakeServer(t)
+	result := NewClient(`{"$type":"fake","server":"` + t.Name() + `"}`)
+	require.Nil(t, result.Error)
+	client := result.Client
+	server.down.Store(true)
+
+	statuses := make(chan string, 4)
+	m := newHealthMonitor(func(ctx context.Context) error {
+		err := connectivity.CheckTCPConnectivity(ctx, client)
+		if err != nil && server.streams.Load() == 0 {
+			// The server comes back after the first failure.
+			server.down.Store(false)
+		}
+		return err
+	}, time.Hour, reconnectPolicy{initialDelay: 10 * time.Millisecond, maxDelay: time.Second, multiplier: 2, maxAttempts: 3},
+		func(status string) { statuses <- status })
+	defer m.stop()
+	m.checkNow()
+
+	require.Equal(t, ConnectionStatusReconnecting, <-statuses)
+	require.Equal(t, ConnectionStatusConnected, <-statuses)
+	require.NotZero(t, server.streams.Load())
+}
+
+func TestFakeTransport_Invalid(t *testing.T) {
+	_, _, err := parseFakeTransport([]byte(`{"responses":1}`), TransportDialers{})
+	require.True(t, errors.As(err, new(platerrors.PlatformError)))
+}
@@ -0,0 +1,286 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// transportTypeFallback is the transport switching to the next transport of a list when the
+// network blocks the current one.
+const transportTypeFallback = "fallback"
+
+// Reasons of the fallbacks, in [transportFallbackEventJSON].
+const (
+	// fallbackReasonTCPReset is the reset of connections after they sent data, the signature of
+	// the censors injecting RSTs once they recognize the protocol.
+	fallbackReasonTCPReset = "tcp-reset"
+	// fallbackReasonUDPLoss is the sudden loss of all the packets of flows that were answered.
+	fallbackReasonUDPLoss = "udp-loss"
+)
+
+const (
+	defaultFallbackResetThreshold = 3
+	defaultFallbackUDPLossTimeout = 10 * time.Second
+
+	// fallbackSignalWindow is how long the blocking signals are counted for.
+	fallbackSignalWindow = time.Minute
+	// fallbackUDPLossThreshold is the number of packet flows that must stop receiving to switch, so
+	// that a single peer going away isn't taken for blocking.
+	fallbackUDPLossThreshold = 2
+)
+
+// fallbackTransportConfigJSON is the config of the "fallback" transport.
+type fallbackTransportConfigJSON struct {
+	// Transports are the configs of the transports to fall back to, in order, of any transport
+	// type. The first one is used until it's blocked, and the last one falls back to the first.
+	Transports []json.RawMessage `json:"transports"`
+
+	// ResetThreshold is the number of connections reset after sending data within a minute that
+	// switches to the next transport. Defaults to 3.
+	ResetThreshold int `json:"resetThreshold,omitempty"`
+
+	// UDPLossSeconds is how long a packet flow that was answered may send without being answered
+	// before it's considered blocked. Two blocked flows within a minute switch to the next
+	// transport. Defaults to 10 seconds.
+	UDPLossSeconds int `json:"udpLossSeconds,omitempty"`
+}
+
+// transportFallbackEventJSON is the data of [EventTransportFallback].
+type transportFallbackEventJSON struct {
+	// From and To are the indexes of the transports in the list.
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Reason is "tcp-reset" or "udp-loss".
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+func init() {
+	transportRegistry[transportTypeFallback] = parseFallbackTransport
+	firstHopsRegistry[transportTypeFallback] = func(config json.RawMessage) (firstHops, error) {
+		var conf fallbackTransportConfigJSON
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return firstHops{}, err
+		}
+		return nestedFirstHops(conf.Transports...)
+	}
+}
+
+// parseFallbackTransport is the [TransportParser] of the "fallback" transport.
+func parseFallbackTransport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf fallbackTransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("fallback transport config is not a valid JSON", string(config), err)
+	}
+	if len(conf.Transports) < 2 {
+		return nil, nil, newIllegalConfigErrorWithDetails("fallback transport has nothing to fall back to",
+			"transports", len(conf.Transports), "at least two transport configs", nil)
+	}
+	if conf.ResetThreshold < 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("reset threshold is not valid",
+			"resetThreshold", conf.ResetThreshold, "a positive number of connections", nil)
+	}
+	if conf.UDPLossSeconds < 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("UDP loss timeout is not valid",
+			"udpLossSeconds", conf.UDPLossSeconds, "a positive number of seconds", nil)
+	}
+	s := &fallbackSupervisor{
+		resetThreshold: defaultFallbackResetThreshold,
+		udpLossTimeout: defaultFallbackUDPLossTimeout,
+		signals:        make(map[string][]time.Time),
+	}
+	if conf.ResetThreshold > 0 {
+		s.resetThreshold = conf.ResetThreshold
+	}
+	if conf.UDPLossSeconds > 0 {
+		s.udpLossTimeout = time.Duration(conf.UDPLossSeconds) * time.Second
+	}
+	for _, raw := range conf.Transports {
+		sd, pl, err := parseTransportConfig(raw, dialers)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.servers = append(s.servers, selectableServer{sd: sd, pl: pl})
+	}
+	return s, s, nil
+}
+
+// fallbackSupervisor relays the traffic through its current transport, watching its connections
+// for the signatures of blocking, and switches to the next transport when they add up.
+type fallbackSupervisor struct {
+	servers        []selectableServer
+	resetThreshold int
+	udpLossTimeout time.Duration
+
+	mu      sync.Mutex
+	current int
+	// signals are the times of the blocking signals of the current transport, by reason.
+	signals map[string][]time.Time
+}
+
+var _ transport.StreamDialer = (*fallbackSupervisor)(nil)
+var _ transport.PacketListener = (*fallbackSupervisor)(nil)
+
+func (s *fallbackSupervisor) selected() (int, selectableServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current, s.servers[s.current]
+}
+
+func (s *fallbackSupervisor) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	index, server := s.selected()
+	conn, err := server.sd.DialStream(ctx, addr)
+	if err != nil {
+		// Transports with a handshake send data before the dial returns.
+		if isConnectionReset(err) {
+			s.report(index, fallbackReasonTCPReset)
+		}
+		return nil, err
+	}
+	return &fallbackStreamConn{StreamConn: conn, onReset: func() { s.report(index, fallbackReasonTCPReset) }}, nil
+}
+
+func (s *fallbackSupervisor) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	index, server := s.selected()
+	pc, err := server.pl.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &fallbackPacketConn{PacketConn: pc, lossTimeout: s.udpLossTimeout,
+		onLoss: func() { s.report(index, fallbackReasonUDPLoss) }}, nil
+}
+
+// report records a blocking signal of the transport at index, and switches to the next transport
+// once the signals within [fallbackSignalWindow] reach the threshold of reason. The signals of the
+// transports that were already switched from are ignored.
+func (s *fallbackSupervisor) report(index int, reason string) {
+	s.mu.Lock()
+	if index != s.current {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	signals := append(s.signals[reason], now)
+	for len(signals) > 0 && now.Sub(signals[0]) > fallbackSignalWindow {
+		signals = signals[1:]
+	}
+	s.signals[reason] = signals
+	threshold := s.resetThreshold
+	details := fmt.Sprintf("%d connections reset after sending data within %v", len(signals), fallbackSignalWindow)
+	if reason == fallbackReasonUDPLoss {
+		threshold = fallbackUDPLossThreshold
+		details = fmt.Sprintf("%d packet flows unanswered for %v within %v", len(signals), s.udpLossTimeout, fallbackSignalWindow)
+	}
+	if len(signals) < threshold {
+		s.mu.Unlock()
+		return
+	}
+	data := transportFallbackEventJSON{From: s.current, To: (s.current + 1) % len(s.servers), Reason: reason, Details: details}
+	s.current = data.To
+	s.signals = make(map[string][]time.Time)
+	s.mu.Unlock()
+
+	logger.Warn("transport is blocked, falling back", "from", data.From, "to", data.To, "reason", reason, "details", details)
+	event.Emit(EventTransportFallback, data)
+}
+
+// isConnectionReset returns whether err is the reset of a connection.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// fallbackStreamConn calls onReset when it's reset after sending data.
+type fallbackStreamConn struct {
+	transport.StreamConn
+	onReset  func()
+	written  atomic.Int64
+	reported atomic.Bool
+}
+
+func (c *fallbackStreamConn) Read(b []byte) (int, error) {
+	n, err := c.StreamConn.Read(b)
+	if err != nil {
+		c.checkReset(err)
+	}
+	return n, err
+}
+
+func (c *fallbackStreamConn) Write(b []byte) (int, error) {
+	n, err := c.StreamConn.Write(b)
+	c.written.Add(int64(n))
+	if err != nil {
+		c.checkReset(err)
+	}
+	return n, err
+}
+
+func (c *fallbackStreamConn) checkReset(err error) {
+	if c.written.Load() > 0 && isConnectionReset(err) && c.reported.CompareAndSwap(false, true) {
+		c.onReset()
+	}
+}
+
+// fallbackPacketConn calls onLoss when it stops being answered after it was, for lossTimeout.
+type fallbackPacketConn struct {
+	net.PacketConn
+	lossTimeout time.Duration
+	onLoss      func()
+
+	mu       sync.Mutex
+	answered bool
+	// unansweredSince is the time of the first packet sent since the last one received.
+	unansweredSince time.Time
+	reported        bool
+}
+
+func (c *fallbackPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	now := time.Now()
+	lost := c.answered && !c.reported && !c.unansweredSince.IsZero() && now.Sub(c.unansweredSince) > c.lossTimeout
+	if lost {
+		c.reported = true
+	}
+	if c.unansweredSince.IsZero() {
+		c.unansweredSince = now
+	}
+	c.mu.Unlock()
+	if lost {
+		c.onLoss()
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *fallbackPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.mu.Lock()
+		c.answered = true
+		c.unansweredSince = time.Time{}
+		c.mu.Unlock()
+	}
+	return n, addr, err
+}
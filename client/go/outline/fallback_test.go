@@ -0,0 +1,152 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFallbackTransport(t *testing.T) {
+	got := NewClient(`{"$type":"fallback","transports":[{"$type":"fake"},{"$type":"fake"}],"resetThreshold":2}`)
+	require.Nil(t, got.Error)
+
+	for _, config := range []string{
+		`{"$type":"fallback","transports":[{"$type":"fake"}]}`,
+		`{"$type":"fallback","transports":[{"$type":"fake"},{"$type":"fake"}],"resetThreshold":-1}`,
+		`{"$type":"fallback","transports":[{"$type":"fake"},{"$type":"fake"}],"udpLossSeconds":-1}`,
+		`{"$type":"fallback","transports":[{"$type":"fake"},{"$type":"unknown"}]}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
+
+// resetStreamDialer dials connections that are reset on their first read.
+type resetStreamDialer struct{}
+
+func (resetStreamDialer) DialStream(context.Context, string) (transport.StreamConn, error) {
+	return &resetStreamConn{}, nil
+}
+
+type resetStreamConn struct {
+	transport.StreamConn
+}
+
+func (c *resetStreamConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func (c *resetStreamConn) Read([]byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+}
+
+func TestFallbackSupervisor_TCPReset(t *testing.T) {
+	l := &fakeEventListener{events: make(chan [2]string, 1)}
+	defer Subscribe(EventTransportFallback, l).Unsubscribe()
+
+	s := &fallbackSupervisor{
+		servers:        []selectableServer{{sd: resetStreamDialer{}}, {sd: resetStreamDialer{}}},
+		resetThreshold: 2,
+		signals:        make(map[string][]time.Time),
+	}
+	buf := make([]byte, 10)
+	dial := func(write bool) {
+		conn, err := s.DialStream(context.Background(), "example.com:443")
+		require.NoError(t, err)
+		if write {
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+		}
+		_, err = conn.Read(buf)
+		require.Error(t, err)
+		_, err = conn.Read(buf)
+		require.Error(t, err)
+	}
+
+	// The resets before sending data, and the repeated errors of a conn, don't count.
+	dial(false)
+	dial(true)
+	require.Equal(t, 0, s.current)
+	require.Empty(t, l.events)
+
+	dial(true)
+	require.Equal(t, 1, s.current)
+	require.Len(t, l.events, 1)
+	ev := <-l.events
+	require.Equal(t, EventTransportFallback, ev[0])
+	var data transportFallbackEventJSON
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &data))
+	require.Equal(t, 0, data.From)
+	require.Equal(t, 1, data.To)
+	require.Equal(t, fallbackReasonTCPReset, data.Reason)
+	require.NotEmpty(t, data.Details)
+
+	// The last transport falls back to the first.
+	dial(true)
+	dial(true)
+	require.Equal(t, 0, s.current)
+}
+
+func TestFallbackSupervisor_IgnoresPreviousTransport(t *testing.T) {
+	s := &fallbackSupervisor{servers: make([]selectableServer, 3), resetThreshold: 1, signals: make(map[string][]time.Time)}
+	s.report(0, fallbackReasonTCPReset)
+	require.Equal(t, 1, s.current)
+	s.report(0, fallbackReasonTCPReset)
+	require.Equal(t, 1, s.current)
+}
+
+func TestFallbackPacketConn_UDPLoss(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	losses := 0
+	pc := &fallbackPacketConn{PacketConn: client, lossTimeout: 50 * time.Millisecond, onLoss: func() { losses++ }}
+	defer pc.Close()
+	buf := make([]byte, 10)
+
+	// Unanswered flows aren't lost until they were answered.
+	_, err = pc.WriteTo([]byte("ping"), server.LocalAddr())
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = pc.WriteTo([]byte("ping"), server.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, 0, losses)
+
+	_, err = server.WriteTo([]byte("pong"), client.LocalAddr())
+	require.NoError(t, err)
+	_, _, err = pc.ReadFrom(buf)
+	require.NoError(t, err)
+
+	_, err = pc.WriteTo([]byte("ping"), server.LocalAddr())
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = pc.WriteTo([]byte("ping"), server.LocalAddr())
+	require.NoError(t, err)
+	_, err = pc.WriteTo([]byte("ping"), server.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, 1, losses)
+}
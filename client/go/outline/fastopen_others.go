@@ -0,0 +1,25 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package outline
+
+import "net"
+
+// withTCPFastOpen does nothing: TCP Fast Open needs connectx on Darwin and ConnectEx on Windows,
+// which the Go dialers don't use.
+func withTCPFastOpen(d *net.Dialer) {
+	logger.Debug("TCP Fast Open is not available on this platform")
+}
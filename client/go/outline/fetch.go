@@ -15,8 +15,17 @@
 package outline
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
@@ -24,27 +33,123 @@ import (
 
 const fetchTimeout = 10 * time.Second
 
+// fetchRequestJSON is the JSON input of [MethodFetchResource], to pin the certificate of the
+// server or select the proxy of the request.
+type fetchRequestJSON struct {
+	URL string `json:"url"`
+
+	// PinnedSPKISHA256 are the base64 SHA-256 hashes of the SubjectPublicKeyInfo of the
+	// certificates the server may use, like in HPKP. A certificate of the verified chain must
+	// match one. The URL, and the ones it redirects to, must then use HTTPS.
+	PinnedSPKISHA256 []string `json:"pinnedSpkiSha256,omitempty"`
+
+	// OutboundProxy is the proxy to fetch the URL through, like the outboundProxy of a transport
+	// config. Defaults to "system", and "direct" disables the proxy.
+	OutboundProxy string `json:"outboundProxy,omitempty"`
+
+	// Signed requires the resource to be a dynamic config signed by the provider, and returns its
+	// payload, see [verifySignedConfig].
+	Signed bool `json:"signed,omitempty"`
+
+	// CacheFallback returns the content of the last successful fetch of the URL if the server
+	// can't be reached, e.g. to keep a subscription usable while its server is down.
+	CacheFallback bool `json:"cacheFallback,omitempty"`
+
+	// management dials the server of the URL outside the tunnel, see [dialManagementHost].
+	management bool
+}
+
+// errCertificatePinMismatch is returned by the TLS handshake of a pinned fetch when no
+// certificate of the server matches the pins.
+var errCertificatePinMismatch = errors.New("no certificate of the server matches the pinned public keys")
+
+// errPinnedRedirectInsecure is returned by a pinned fetch redirected to a URL without TLS, whose
+// server can't be checked against the pins.
+var errPinnedRedirectInsecure = errors.New("pinned fetch was redirected to a URL without HTTPS")
+
+// fetchRootCAs are the trusted root certificates of the fetches. Nil means the system ones.
+var fetchRootCAs *x509.CertPool
+
+// parseFetchRequest parses the input of [MethodFetchResource]: either a URL, or a JSON string of
+// fetchRequestJSON.
+func parseFetchRequest(input string) (fetchRequestJSON, error) {
+	if !strings.HasPrefix(strings.TrimSpace(input), "{") {
+		return fetchRequestJSON{URL: input}, nil
+	}
+	var req fetchRequestJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return req, newInvalidJSONError("invalid fetch request", input, err)
+	}
+	return req, nil
+}
+
 // fetchResource fetches a resource from the given URL.
 //
 // The function makes an HTTP GET request to the specified URL and returns the response body as a
 // string. If the request fails or the server returns a non-2xx status code, an error is returned.
 func fetchResource(url string) (string, error) {
+	return fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: url})
+}
+
+// fetchResourceWithOptions fetches a resource like [fetchResource], through the outbound proxy of
+// req. It fails with a CertificatePinMismatch error if req has pins and no certificate of the
+// server matches. The request is aborted when ctx is done.
+func fetchResourceWithOptions(ctx context.Context, req fetchRequestJSON) (string, error) {
+	pinnedHashes, err := decodeSPKIPins(req.PinnedSPKISHA256)
+	if err != nil {
+		return "", err
+	}
+	if len(pinnedHashes) > 0 {
+		if err := checkPinnedURL(req.URL); err != nil {
+			return "", err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if req.OutboundProxy != "" && req.OutboundProxy != outboundProxySystem {
+		// The default transport already uses the system proxy. Invalid URLs fail in Get below.
+		if target, parseErr := url.Parse(req.URL); parseErr == nil {
+			proxyURL, err := resolveOutboundProxy(req.OutboundProxy, target)
+			if err != nil {
+				return "", err
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	if req.management {
+		transport.DialContext = dialManagementHost
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: fetchRootCAs}
+	if len(pinnedHashes) > 0 {
+		transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifySPKIPins(cs.VerifiedChains, pinnedHashes)
+		}
+	}
 	client := &http.Client{
-		Timeout: fetchTimeout,
+		Timeout:   fetchTimeout,
+		Transport: transport,
+		Jar:       fetchCookieJar,
 	}
-	resp, err := client.Get(url)
-	if err != nil {
-		return "", platerrors.PlatformError{
-			Code:    platerrors.FetchConfigFailed,
-			Message: "failed to fetch the URL",
-			Details: platerrors.ErrorDetails{"url": url},
-			Cause:   platerrors.ToPlatformError(err),
+	if len(pinnedHashes) > 0 {
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			if r.URL.Scheme != "https" {
+				return errPinnedRedirectInsecure
+			}
+			return nil
 		}
 	}
-	body, err := io.ReadAll(resp.Body)
+	defer transport.CloseIdleConnections()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return "", newFetchError(req.URL, err)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", newFetchError(req.URL, err)
+	}
+	body, err := readConfig(resp.Body)
 	resp.Body.Close()
 	if resp.StatusCode > 299 {
-		return "", platerrors.PlatformError{
+		perr := platerrors.PlatformError{
 			Code:    platerrors.FetchConfigFailed,
 			Message: "non-successful HTTP status",
 			Details: platerrors.ErrorDetails{
@@ -52,14 +157,96 @@ func fetchResource(url string) (string, error) {
 				"body":   string(body),
 			},
 		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			perr.Code = platerrors.Unauthenticated
+			return "", perr.WithHint(platerrors.HintCheckAccessKey)
+		case http.StatusPaymentRequired, http.StatusTooManyRequests:
+			perr.Code = platerrors.QuotaExceeded
+			return "", perr.WithHint(platerrors.HintContactProvider)
+		}
+		return "", perr
+	}
+	var limitErr platerrors.PlatformError
+	if errors.As(err, &limitErr) && limitErr.Code == platerrors.ConfigTooLarge {
+		return "", err
 	}
 	if err != nil {
 		return "", platerrors.PlatformError{
 			Code:    platerrors.FetchConfigFailed,
 			Message: "failed to read the body",
-			Details: platerrors.ErrorDetails{"url": url},
+			Details: platerrors.ErrorDetails{"url": req.URL},
 			Cause:   platerrors.ToPlatformError(err),
 		}
 	}
 	return string(body), nil
 }
+
+// newFetchError creates the error of a failed request to url, telling apart DNS failures and
+// intercepted TLS connections.
+func newFetchError(url string, err error) error {
+	perr := platerrors.PlatformError{
+		Code:    platerrors.FetchConfigFailed,
+		Message: "failed to fetch the URL",
+		Details: platerrors.ErrorDetails{"url": url},
+		Cause:   platerrors.ToPlatformError(err),
+	}
+	var dnsErr *net.DNSError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, errPinnedRedirectInsecure):
+		return perr
+	case errors.Is(err, errCertificatePinMismatch):
+		perr.Code = platerrors.CertificatePinMismatch
+		return perr.WithHint(platerrors.HintUntrustedNetwork)
+	case errors.As(err, &dnsErr):
+		perr.Code = platerrors.ResolveIPFailed
+		return perr.WithHint(platerrors.HintCheckInternet)
+	case errors.As(err, &unknownAuthorityErr), errors.As(err, &certInvalidErr), errors.As(err, &hostnameErr):
+		perr.Code = platerrors.TLSIntercepted
+		return perr.WithHint(platerrors.HintUntrustedNetwork)
+	}
+	return perr.WithHint(platerrors.HintCheckInternet)
+}
+
+// decodeSPKIPins decodes the base64 SHA-256 hashes of pinned public keys.
+func decodeSPKIPins(pins []string) ([][]byte, error) {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		hash, err := decodeBase64(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, newIllegalConfigErrorWithDetails("pinned public key hash is not valid",
+				"pinnedSpkiSha256", pin, "base64 SHA-256 hash", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// checkPinnedURL checks that the URL of a pinned fetch uses HTTPS, since the pins can't be checked
+// without TLS.
+func checkPinnedURL(rawURL string) error {
+	if u, err := url.Parse(rawURL); err != nil || u.Scheme != "https" {
+		return newIllegalConfigErrorWithDetails("pinned URL must use HTTPS", "url", rawURL, "an https:// URL", err)
+	}
+	return nil
+}
+
+// verifySPKIPins returns [errCertificatePinMismatch] if the public key of no certificate of the
+// verified chains matches the pinned hashes. The other certificates sent by the server, which
+// don't chain to a trusted root, can't satisfy the pins.
+func verifySPKIPins(chains [][]*x509.Certificate, pinnedHashes [][]byte) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pinned := range pinnedHashes {
+				if bytes.Equal(hash[:], pinned) {
+					return nil
+				}
+			}
+		}
+	}
+	return errCertificatePinMismatch
+}
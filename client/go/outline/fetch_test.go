@@ -15,7 +15,16 @@
 package outline
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -63,19 +72,25 @@ func TestFetchResource_Redirection(t *testing.T) {
 }
 
 func TestFetchResource_HTTPStatusError(t *testing.T) {
-	errStatuses := []int{
-		http.StatusBadRequest,
-		http.StatusUnauthorized,
-		http.StatusForbidden,
-		http.StatusNotFound,
-		http.StatusInternalServerError,
-		http.StatusBadGateway,
-		http.StatusServiceUnavailable,
+	errStatuses := []struct {
+		status int
+		code   platerrors.ErrorCode
+		hint   platerrors.Hint
+	}{
+		{http.StatusBadRequest, platerrors.FetchConfigFailed, ""},
+		{http.StatusUnauthorized, platerrors.Unauthenticated, platerrors.HintCheckAccessKey},
+		{http.StatusPaymentRequired, platerrors.QuotaExceeded, platerrors.HintContactProvider},
+		{http.StatusForbidden, platerrors.Unauthenticated, platerrors.HintCheckAccessKey},
+		{http.StatusNotFound, platerrors.FetchConfigFailed, ""},
+		{http.StatusTooManyRequests, platerrors.QuotaExceeded, platerrors.HintContactProvider},
+		{http.StatusInternalServerError, platerrors.FetchConfigFailed, ""},
+		{http.StatusBadGateway, platerrors.FetchConfigFailed, ""},
+		{http.StatusServiceUnavailable, platerrors.FetchConfigFailed, ""},
 	}
 
-	for _, errStatus := range errStatuses {
+	for _, tc := range errStatuses {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			w.WriteHeader(errStatus)
+			w.WriteHeader(tc.status)
 		}))
 		defer server.Close()
 
@@ -83,11 +98,39 @@ func TestFetchResource_HTTPStatusError(t *testing.T) {
 		content, err := fetchResource(server.URL)
 		require.Empty(t, content)
 		require.ErrorAs(t, err, &perr)
-		require.Equal(t, platerrors.FetchConfigFailed, perr.Code)
+		require.Equal(t, tc.code, perr.Code, tc.status)
 		require.Error(t, perr.Cause)
+		if tc.hint == "" {
+			require.NotContains(t, perr.Details, platerrors.HintDetailsKey)
+		} else {
+			require.Equal(t, tc.hint, perr.Details[platerrors.HintDetailsKey])
+		}
 	}
 }
 
+func TestFetchResource_TLSIntercepted(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var perr platerrors.PlatformError
+	content, err := fetchResource(server.URL)
+	require.Empty(t, content)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.TLSIntercepted, perr.Code)
+	require.Equal(t, platerrors.HintUntrustedNetwork, perr.Details[platerrors.HintDetailsKey])
+}
+
+func TestFetchResource_DNSError(t *testing.T) {
+	var perr platerrors.PlatformError
+	content, err := fetchResource("https://nonexistent.invalid/key")
+	require.Empty(t, content)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ResolveIPFailed, perr.Code)
+	require.Equal(t, platerrors.HintCheckInternet, perr.Details[platerrors.HintDetailsKey])
+}
+
 func TestFetchResource_BodyReadError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Length", "1") // This will cause io.ReadAll to fail
@@ -102,6 +145,19 @@ func TestFetchResource_BodyReadError(t *testing.T) {
 	require.Error(t, perr.Cause)
 }
 
+func TestFetchResource_TooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(bytes.Repeat([]byte(" "), maxConfigSize+1))
+	}))
+	defer server.Close()
+
+	var perr platerrors.PlatformError
+	content, err := fetchResource(server.URL)
+	require.Empty(t, content)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ConfigTooLarge, perr.Code)
+}
+
 func TestFetchResource_Timeout(t *testing.T) {
 	const (
 		MaxFetchWaitTime = 12 * time.Second
@@ -127,3 +183,124 @@ func TestFetchResource_Timeout(t *testing.T) {
 	require.Error(t, err, "fetchResource should return a non-nil timeout error")
 	require.Empty(t, content)
 }
+
+func TestFetchPinnedResource(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ss://pinned-key"))
+	}))
+	defer server.Close()
+	fetchRootCAs = x509.NewCertPool()
+	fetchRootCAs.AddCert(server.Certificate())
+	defer func() { fetchRootCAs = nil }()
+
+	spki := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(spki[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	content, err := fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL, PinnedSPKISHA256: []string{otherPin, pin}})
+	require.NoError(t, err)
+	require.Equal(t, "ss://pinned-key", content)
+
+	var perr platerrors.PlatformError
+	content, err = fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL, PinnedSPKISHA256: []string{otherPin}})
+	require.Empty(t, content)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.CertificatePinMismatch, perr.Code)
+	require.Equal(t, platerrors.HintUntrustedNetwork, perr.Details[platerrors.HintDetailsKey])
+
+	_, err = fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL, PinnedSPKISHA256: []string{"not a hash"}})
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+}
+
+func TestFetchPinnedResource_UnverifiedCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ss://pinned-key"))
+	}))
+	defer server.Close()
+	fetchRootCAs = x509.NewCertPool()
+	fetchRootCAs.AddCert(server.Certificate())
+	defer func() { fetchRootCAs = nil }()
+
+	// The server also sends a certificate that isn't part of the verified chain.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	extra, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: big.NewInt(1)},
+		&x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	require.NoError(t, err)
+	server.TLS.Certificates[0].Certificate = append(server.TLS.Certificates[0].Certificate, extra)
+	extraCert, err := x509.ParseCertificate(extra)
+	require.NoError(t, err)
+	spki := sha256.Sum256(extraCert.RawSubjectPublicKeyInfo)
+
+	_, err = fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL,
+		PinnedSPKISHA256: []string{base64.StdEncoding.EncodeToString(spki[:])}})
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.CertificatePinMismatch, perr.Code)
+}
+
+func TestFetchPinnedResource_Insecure(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ss://unpinned-key"))
+	}))
+	defer plain.Close()
+	server := httptest.NewTLSServer(http.RedirectHandler(plain.URL, http.StatusFound))
+	defer server.Close()
+	fetchRootCAs = x509.NewCertPool()
+	fetchRootCAs.AddCert(server.Certificate())
+	defer func() { fetchRootCAs = nil }()
+	spki := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pins := []string{base64.StdEncoding.EncodeToString(spki[:])}
+
+	var perr platerrors.PlatformError
+	_, err := fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: plain.URL, PinnedSPKISHA256: pins})
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+
+	content, err := fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL, PinnedSPKISHA256: pins})
+	require.Empty(t, content)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.FetchConfigFailed, perr.Code)
+
+	// Without pins, the redirect is followed.
+	content, err = fetchResourceWithOptions(context.Background(), fetchRequestJSON{URL: server.URL})
+	require.NoError(t, err)
+	require.Equal(t, "ss://unpinned-key", content)
+}
+
+func TestInvokeMethodWithCanceler_FetchResource(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	canceler := NewCanceler()
+	time.AfterFunc(50*time.Millisecond, canceler.Cancel)
+	result := InvokeMethodWithCanceler(MethodFetchResource, server.URL, canceler)
+	require.NotNil(t, result.Error)
+	require.Equal(t, platerrors.OperationCanceled, result.Error.Code)
+
+	// The successful calls are not affected by the cancellation.
+	result = InvokeMethodWithCanceler(MethodGetHealth, "", canceler)
+	require.Nil(t, result.Error)
+	require.NotEmpty(t, result.Value)
+}
+
+func TestParseFetchRequest(t *testing.T) {
+	req, err := parseFetchRequest("https://example.com/key")
+	require.NoError(t, err)
+	require.Equal(t, fetchRequestJSON{URL: "https://example.com/key"}, req)
+
+	req, err = parseFetchRequest(`{"url": "https://example.com/key", "pinnedSpkiSha256": ["abc="]}`)
+	require.NoError(t, err)
+	require.Equal(t, fetchRequestJSON{URL: "https://example.com/key", PinnedSPKISHA256: []string{"abc="}}, req)
+
+	_, err = parseFetchRequest(`{"url": `)
+	require.Error(t, err)
+}
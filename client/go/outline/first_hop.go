@@ -0,0 +1,140 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+)
+
+// firstHopJSON is the address of a host the transport connects to directly, so that the app can
+// show it and exclude it from the VPN. It mirrors EndpointAddress of the app.
+type firstHopJSON struct {
+	Host string `json:"host"`
+	// Port is omitted when the transport hops between several ports of the host.
+	Port uint16 `json:"port,omitempty"`
+}
+
+// firstHops are the hosts a transport connects to directly, by protocol. They differ when e.g.
+// the TCP connections go through an outbound proxy but the UDP packets go to the server.
+type firstHops struct {
+	TCP []firstHopJSON
+	UDP []firstHopJSON
+	// ICMP are the hosts the echo messages of the "icmp" transport are sent to.
+	ICMP []firstHopJSON
+}
+
+// add adds the hops of other that h doesn't have yet.
+func (h *firstHops) add(other firstHops) {
+	h.TCP = appendNewFirstHops(h.TCP, other.TCP)
+	h.UDP = appendNewFirstHops(h.UDP, other.UDP)
+	h.ICMP = appendNewFirstHops(h.ICMP, other.ICMP)
+}
+
+func appendNewFirstHops(hops []firstHopJSON, other []firstHopJSON) []firstHopJSON {
+	for _, hop := range other {
+		if !slices.Contains(hops, hop) {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// firstHopsFunc returns the first hops of a transport config.
+type firstHopsFunc func(config json.RawMessage) (firstHops, error)
+
+// firstHopsRegistry are the functions returning the first hops of the built-in transports, by
+// "$type". The first hops of the transports registered with [RegisterTransport] are unknown.
+var firstHopsRegistry = map[string]firstHopsFunc{
+	transportTypeShadowsocks: shadowsocksFirstHops,
+}
+
+// transportFirstHops returns the first hops of a transport config of any type. It returns false if
+// they are unknown.
+func transportFirstHops(config json.RawMessage) (firstHops, bool) {
+	var typed struct {
+		Type string `json:"$type"`
+	}
+	if err := json.Unmarshal(config, &typed); err != nil {
+		return firstHops{}, false
+	}
+	if typed.Type == "" {
+		typed.Type = transportTypeShadowsocks
+	}
+	hopsFunc, ok := firstHopsRegistry[typed.Type]
+	if !ok {
+		return firstHops{}, false
+	}
+	hops, err := hopsFunc(config)
+	if err != nil {
+		return firstHops{}, false
+	}
+	return hops, true
+}
+
+// nestedFirstHops returns the union of the first hops of the transport configs, which are unknown
+// if the hops of any of them are.
+func nestedFirstHops(configs ...json.RawMessage) (firstHops, error) {
+	var hops firstHops
+	for i, config := range configs {
+		nested, ok := transportFirstHops(config)
+		if !ok {
+			return firstHops{}, fmt.Errorf("first hops of transport %d are unknown", i)
+		}
+		hops.add(nested)
+	}
+	return hops, nil
+}
+
+// shadowsocksFirstHops returns the first hops of the built-in Shadowsocks transport: the UDP
+// packets go to the server, and the TCP connections to the outbound proxy, if any, or else to the
+// server. The pluggable transports connect to the server too.
+func shadowsocksFirstHops(config json.RawMessage) (firstHops, error) {
+	var conf configJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return firstHops{}, err
+	}
+	server := firstHopJSON{Host: conf.Host, Port: conf.Port}
+	hops := firstHops{TCP: []firstHopJSON{server}, UDP: []firstHopJSON{server}}
+	address := net.JoinHostPort(conf.Host, fmt.Sprint(conf.Port))
+	proxyURL, err := resolveOutboundProxy(conf.OutboundProxy, &url.URL{Scheme: "https", Host: address})
+	if err != nil {
+		return firstHops{}, err
+	}
+	if proxyURL != nil {
+		defaultPort := uint16(1080)
+		if proxyURL.Scheme == "http" {
+			defaultPort = 80
+		}
+		port, err := urlPort(proxyURL, defaultPort)
+		if err != nil {
+			return firstHops{}, err
+		}
+		hops.TCP = []firstHopJSON{{Host: proxyURL.Hostname(), Port: port}}
+	}
+	return hops, nil
+}
+
+// quicFirstHops returns the first hops of the QUIC transports, which only send UDP packets to the
+// server.
+func quicFirstHops(host string, port uint16, ports string) firstHops {
+	if ports != "" {
+		port = 0
+	}
+	return firstHops{UDP: []firstHopJSON{{Host: host, Port: port}}}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportFirstHops(t *testing.T) {
+	server := firstHopJSON{Host: "example.com", Port: 443}
+	cases := []struct {
+		name   string
+		config string
+		want   firstHops
+	}{
+		{
+			name:   "shadowsocks",
+			config: `{"host": "example.com", "port": 443, "method": "chacha20-ietf-poly1305", "password": "x"}`,
+			want:   firstHops{TCP: []firstHopJSON{server}, UDP: []firstHopJSON{server}},
+		},
+		{
+			name: "outbound proxy",
+			config: `{"host": "example.com", "port": 443, "method": "chacha20-ietf-poly1305", "password": "x",
+				"outboundProxy": "socks5://192.0.2.1"}`,
+			want: firstHops{TCP: []firstHopJSON{{Host: "192.0.2.1", Port: 1080}}, UDP: []firstHopJSON{server}},
+		},
+		{
+			name:   "hysteria2 port hopping",
+			config: `{"$type": "hysteria2", "host": "example.com", "ports": "20000-30000", "auth": "x"}`,
+			want:   firstHops{UDP: []firstHopJSON{{Host: "example.com"}}},
+		},
+		{
+			name:   "icmp",
+			config: `{"$type": "icmp", "transport": {"$type": "tuic", "host": "example.com", "port": 443}}`,
+			want:   firstHops{ICMP: []firstHopJSON{server}},
+		},
+		{
+			name: "multi",
+			config: `{"$type": "multi", "transports": [
+				{"$type": "mux", "transport": {"host": "example.com", "port": 443, "method": "chacha20-ietf-poly1305", "password": "x"}},
+				{"$type": "hysteria2", "host": "example.com", "port": 443, "auth": "x"},
+				{"$type": "tuic", "host": "example.net", "port": 443}
+			]}`,
+			want: firstHops{TCP: []firstHopJSON{server}, UDP: []firstHopJSON{server, {Host: "example.net", Port: 443}}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hops, ok := transportFirstHops(json.RawMessage(tc.config))
+			require.True(t, ok)
+			require.Equal(t, tc.want, hops)
+		})
+	}
+
+	_, ok := transportFirstHops(json.RawMessage(`{"$type": "unknown"}`))
+	require.False(t, ok)
+	_, ok = transportFirstHops(json.RawMessage(`{"$type": "fallback", "transports": [{"$type": "unknown"}]}`))
+	require.False(t, ok)
+}
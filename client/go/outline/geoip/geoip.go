@@ -0,0 +1,182 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package geoip looks up the country and the autonomous system of IP addresses offline, in
+// MaxMind DB (MMDB) files like GeoLite2-Country and GeoLite2-ASN.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata at the end of an MMDB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the size of the zeros between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// Info is what a database knows about an IP address. The fields it doesn't know are empty.
+type Info struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, like "DE".
+	Country string `json:"country,omitempty"`
+
+	// ASN is the number of the autonomous system.
+	ASN uint32 `json:"asn,omitempty"`
+
+	// Organization is the organization of the autonomous system.
+	Organization string `json:"asOrganization,omitempty"`
+}
+
+// Reader looks up IP addresses in an MMDB database. It's safe for concurrent use.
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node of the IPv4 addresses in an IPv6 tree.
+	ipv4Start uint
+}
+
+// Open reads the MMDB database of the file at path.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(buf)
+}
+
+// New reads the MMDB database in buf.
+func New(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB: metadata not found")
+	}
+	d := decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata: not a map")
+	}
+	r := &Reader{buf: buf}
+	for key, field := range map[string]*uint{"node_count": &r.nodeCount, "record_size": &r.recordSize, "ip_version": &r.ipVersion} {
+		v, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata: %s is missing", key)
+		}
+		*field = uint(v)
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, errors.New("invalid metadata: search tree is larger than the file")
+	}
+	r.data = buf[treeSize+dataSectionSeparator : start]
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns what the database knows about ip. It returns an empty [Info] if ip isn't in
+// the database.
+func (r *Reader) Lookup(ip net.IP) (Info, error) {
+	var info Info
+	record, err := r.find(ip)
+	if err != nil || record == nil {
+		return info, err
+	}
+	if country, ok := record["country"].(map[string]any); ok {
+		info.Country, _ = country["iso_code"].(string)
+	}
+	if info.Country == "" {
+		// Anycast addresses have no country, only the one of their registration.
+		if country, ok := record["registered_country"].(map[string]any); ok {
+			info.Country, _ = country["iso_code"].(string)
+		}
+	}
+	if asn, ok := record["autonomous_system_number"].(uint64); ok && asn <= math.MaxUint32 {
+		info.ASN = uint32(asn)
+	}
+	info.Organization, _ = record["autonomous_system_organization"].(string)
+	return info, nil
+}
+
+// find returns the data record of the network of ip, or nil if ip isn't in the database.
+func (r *Reader) find(ip net.IP) (map[string]any, error) {
+	bits := ip.To4()
+	node := uint(0)
+	if bits == nil {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		if bits = ip.To16(); bits == nil {
+			return nil, fmt.Errorf("invalid IP address %v", ip)
+		}
+	} else if r.ipVersion == 6 {
+		node = r.ipv4Start
+	}
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(bits[i/8]>>(7-i%8))&1)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid search tree: no record at the end of the address")
+	}
+	offset := node - r.nodeCount - dataSectionSeparator
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data record: %w", err)
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
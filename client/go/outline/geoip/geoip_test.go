@@ -0,0 +1,195 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encode encodes v in the MMDB data format. It supports strings shorter than 285 bytes, uint32
+// and maps of them.
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		if len(v) >= 29 {
+			return append([]byte{typeString<<5 | 29, byte(len(v) - 29)}, v...)
+		}
+		return append([]byte{typeString<<5 | byte(len(v))}, v...)
+	case uint32:
+		return []byte{typeUint32<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := []byte{typeMap<<5 | byte(len(v))}
+		for _, k := range keys {
+			out = append(append(out, encode(k)...), encode(v[k])...)
+		}
+		return out
+	default:
+		panic("unsupported type")
+	}
+}
+
+// buildDB builds an MMDB database of the given IP version and 24-bit records, mapping the
+// networks in CIDR notation to their records.
+func buildDB(t *testing.T, ipVersion uint32, networks map[string]map[string]any) []byte {
+	// The records of the nodes are a node index, -1 if empty, or -2-k for the data record k.
+	nodes := [][2]int{{-1, -1}}
+	var data []byte
+	var offsets []int
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP
+		if ipVersion == 6 {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, ones = ip4.To16(), ones+96
+				ip[10], ip[11] = 0, 0
+			}
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - len(offsets)
+				break
+			}
+			if nodes[node][bit] == -1 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		offsets = append(offsets, len(data))
+		data = append(data, encode(record)...)
+	}
+	var db []byte
+	for _, node := range nodes {
+		for _, rec := range node {
+			v := len(nodes)
+			if rec >= 0 {
+				v = rec
+			} else if rec < -1 {
+				v = len(nodes) + dataSectionSeparator + offsets[-2-rec]
+			}
+			db = append(db, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	db = append(db, make([]byte, dataSectionSeparator)...)
+	db = append(db, data...)
+	db = append(db, metadataMarker...)
+	return append(db, encode(map[string]any{
+		"node_count": uint32(len(nodes)), "record_size": uint32(24), "ip_version": ipVersion,
+	})...)
+}
+
+func TestReader_Lookup(t *testing.T) {
+	for _, ipVersion := range []uint32{4, 6} {
+		db, err := New(buildDB(t, ipVersion, map[string]map[string]any{
+			"192.0.2.0/24": {
+				"country":                        map[string]any{"iso_code": "DE"},
+				"autonomous_system_number":       uint32(64500),
+				"autonomous_system_organization": "Example GmbH",
+			},
+			"198.51.100.0/25": {"registered_country": map[string]any{"iso_code": "US"}},
+		}))
+		require.NoError(t, err)
+
+		info, err := db.Lookup(net.ParseIP("192.0.2.10"))
+		require.NoError(t, err)
+		require.Equal(t, Info{Country: "DE", ASN: 64500, Organization: "Example GmbH"}, info)
+
+		info, err = db.Lookup(net.ParseIP("198.51.100.1"))
+		require.NoError(t, err)
+		require.Equal(t, Info{Country: "US"}, info)
+
+		for _, ip := range []string{"198.51.100.200", "203.0.113.1", "2001:db8::1"} {
+			info, err = db.Lookup(net.ParseIP(ip))
+			require.NoError(t, err)
+			require.Equal(t, Info{}, info, ip)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	require.NoError(t, os.WriteFile(path, buildDB(t, 6, map[string]map[string]any{
+		"2001:db8::/32": {"autonomous_system_number": uint32(64501)},
+	}), 0o600))
+	db, err := Open(path)
+	require.NoError(t, err)
+	info, err := db.Lookup(net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	require.Equal(t, Info{ASN: 64501}, info)
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	require.Error(t, err)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New([]byte("not a database"))
+	require.ErrorContains(t, err, "metadata not found")
+
+	_, err = New(append(append([]byte{}, metadataMarker...), encode(map[string]any{"node_count": uint32(1)})...))
+	require.ErrorContains(t, err, "record_size is missing")
+
+	huge := append(append([]byte{}, metadataMarker...), encode(map[string]any{
+		"node_count": uint32(1000), "record_size": uint32(24), "ip_version": uint32(4),
+	})...)
+	_, err = New(huge)
+	require.ErrorContains(t, err, "larger than the file")
+}
+
+func TestDecoder(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	// A long string, followed by a 1-byte pointer to it and a boolean.
+	buf := append([]byte{typeString<<5 | 30, 0x00, 300 - 285}, long...)
+	pointer := len(buf)
+	buf = append(buf, typePointer<<5, 0, typeExtended<<5|1, typeBool-7)
+
+	d := decoder{buf: buf}
+	value, next, err := d.decode(0)
+	require.NoError(t, err)
+	require.Equal(t, long, value)
+	require.Equal(t, uint(pointer), next)
+
+	value, next, err = d.decode(uint(pointer))
+	require.NoError(t, err)
+	require.Equal(t, long, value)
+	value, _, err = d.decode(next)
+	require.NoError(t, err)
+	require.Equal(t, true, value)
+
+	// A pointer to itself.
+	d = decoder{buf: []byte{typePointer << 5, 0}}
+	_, _, err = d.decode(0)
+	require.ErrorContains(t, err, "nested too deep")
+
+	d = decoder{buf: bytes.Repeat([]byte{typeString<<5 | 10}, 3)}
+	_, _, err = d.decode(0)
+	require.ErrorIs(t, err, errTruncated)
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+// Connection status constants, reported by [EventConnectionStatusChanged].
+const (
+	ConnectionStatusConnected    = "CONNECTED"
+	ConnectionStatusReconnecting = "RECONNECTING"
+	ConnectionStatusDisconnected = "DISCONNECTED"
+)
+
+const defaultHealthCheckInterval = 1 * time.Minute
+
+// connectionStatusEventJSON is the data of [EventConnectionStatusChanged].
+type connectionStatusEventJSON struct {
+	Status string                    `json:"status"`
+	Error  *platerrors.PlatformError `json:"error,omitempty"`
+}
+
+// healthMonitor periodically calls check to verify that a connection is still healthy.
+// Once check fails, it keeps calling check with the backoff of its reconnectPolicy until it
+// succeeds again, or gives up after the maximum attempts. Once it gives up, the connection is
+// DISCONNECTED, and the monitor keeps calling check every interval, so that the connection
+// recovers once the server is reachable again, e.g. when the kill switch blocks it meanwhile.
+//
+// check is expected to re-establish whatever it can (e.g. the UDP handler) on its own.
+//
+// A monitor created with [newCheckMonitor] doesn't reconnect: its check cannot re-establish
+// anything, so it only reports the connection as DISCONNECTED while the checks fail, and as
+// CONNECTED once they succeed again.
+type healthMonitor struct {
+	check    func(ctx context.Context) error
+	interval time.Duration
+	policy   reconnectPolicy
+
+	// checkOnly is whether the monitor only re-checks the connection, see [newCheckMonitor].
+	checkOnly bool
+
+	// onStatusChange, if not nil, is called with the new status when it changes.
+	onStatusChange func(status string)
+
+	mu     sync.Mutex
+	status string
+
+	// wake interrupts the wait for the next check, see [healthMonitor.checkNow].
+	wake chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newHealthMonitor creates a healthMonitor and starts its background goroutine. The zero policy
+// is the default one. onStatusChange can be nil.
+func newHealthMonitor(check func(ctx context.Context) error, interval time.Duration, policy reconnectPolicy, onStatusChange func(status string)) *healthMonitor {
+	if policy == (reconnectPolicy{}) {
+		policy = defaultReconnectPolicy
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &healthMonitor{
+		check:          check,
+		interval:       interval,
+		policy:         policy,
+		onStatusChange: onStatusChange,
+		status:         ConnectionStatusConnected,
+		wake:           make(chan struct{}, 1),
+		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+	resources.Go(resources.SubsystemHealth, func() { m.run(ctx) })
+	return m
+}
+
+// newCheckMonitor creates a healthMonitor that calls check every interval, without reconnecting
+// nor emitting [EventReconnectAttempt] events when it fails, and starts its background goroutine.
+// onStatusChange can be nil.
+func newCheckMonitor(check func(ctx context.Context) error, interval time.Duration, onStatusChange func(status string)) *healthMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &healthMonitor{
+		check:          check,
+		interval:       interval,
+		checkOnly:      true,
+		onStatusChange: onStatusChange,
+		status:         ConnectionStatusConnected,
+		wake:           make(chan struct{}, 1),
+		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+	resources.Go(resources.SubsystemHealth, func() { m.run(ctx) })
+	return m
+}
+
+// Status returns the current connection status.
+func (m *healthMonitor) Status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// checkNow makes the monitor check the connection right away, instead of at the next interval or
+// reconnect attempt, e.g. after a network change. It also resets the reconnect backoff.
+func (m *healthMonitor) checkNow() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the health monitor and waits for its goroutine to exit.
+func (m *healthMonitor) stop() {
+	m.cancel()
+	<-m.done
+}
+
+func (m *healthMonitor) run(ctx context.Context) {
+	defer close(m.done)
+	for {
+		if _, ok := m.sleep(ctx, m.interval); !ok {
+			return
+		}
+		err := m.check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if m.checkOnly {
+			if err != nil {
+				logger.Warn("health check failed", "err", err)
+				m.setStatus(ConnectionStatusDisconnected, err)
+			} else {
+				m.setStatus(ConnectionStatusConnected, nil)
+			}
+			continue
+		}
+		if err == nil {
+			if m.Status() != ConnectionStatusConnected {
+				logger.Info("reconnected")
+				m.setStatus(ConnectionStatusConnected, nil)
+			}
+			continue
+		}
+		if m.Status() == ConnectionStatusDisconnected {
+			logger.Debug("health check still failing", "err", err)
+			continue
+		}
+		logger.Warn("health check failed, reconnecting...", "err", err)
+		m.setStatus(ConnectionStatusReconnecting, err)
+		if !m.reconnect(ctx, err) {
+			return
+		}
+	}
+}
+
+// reconnect calls check with the backoff of the policy until it succeeds, and emits an
+// [EventReconnectAttempt] before each attempt. It never gives up while the kill switch is enabled,
+// so that the tunnel keeps capturing the traffic.
+// It returns false if the monitor should exit, i.e. once it is stopped.
+func (m *healthMonitor) reconnect(ctx context.Context, err error) bool {
+	delay := m.policy.initialDelay
+	for attempt := 1; attempt <= m.policy.maxAttempts || killSwitchEnabled(); attempt++ {
+		wait := m.policy.withJitter(delay)
+		maxAttempts := m.policy.maxAttempts
+		if killSwitchEnabled() {
+			maxAttempts = 0
+		}
+		event.Emit(EventReconnectAttempt, reconnectAttemptEventJSON{
+			Attempt:     attempt,
+			MaxAttempts: maxAttempts,
+			DelayMs:     wait.Milliseconds(),
+			Error:       platerrors.ToPlatformError(err),
+		})
+		woken, ok := m.sleep(ctx, wait)
+		if !ok {
+			return false
+		}
+		if err = m.check(ctx); err == nil {
+			logger.Info("reconnected", "attempt", attempt)
+			m.setStatus(ConnectionStatusConnected, nil)
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		logger.Debug("reconnect attempt failed", "attempt", attempt, "err", err)
+		if woken {
+			// The network changed, don't wait long before trying it again.
+			delay = m.policy.initialDelay
+		} else {
+			delay = m.policy.nextDelay(delay)
+		}
+	}
+	logger.Error("failed to reconnect, giving up", "attempts", m.policy.maxAttempts, "err", err)
+	m.setStatus(ConnectionStatusDisconnected, err)
+	return true
+}
+
+func (m *healthMonitor) setStatus(status string, err error) {
+	m.mu.Lock()
+	changed := m.status != status
+	m.status = status
+	m.mu.Unlock()
+	if changed && m.onStatusChange != nil {
+		m.onStatusChange(status)
+	}
+	if changed {
+		event.Emit(EventConnectionStatusChanged, connectionStatusEventJSON{
+			Status: status,
+			Error:  platerrors.ToPlatformError(err),
+		})
+	}
+}
+
+// sleep waits for d, or until [healthMonitor.checkNow] is called, in which case woken is true.
+// ok is false if ctx is done before that.
+func (m *healthMonitor) sleep(ctx context.Context, d time.Duration) (woken, ok bool) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false, false
+	case <-m.wake:
+		return true, true
+	case <-t.C:
+		return false, true
+	}
+}
+
+// StartHealthMonitor starts checking the TCP connectivity of the [Client] every intervalSeconds
+// (one minute if not positive), and emits [EventConnectionStatusChanged] events when the
+// connection is lost (DISCONNECTED) and when it works again (CONNECTED).
+// It replaces any health monitor previously started on c.
+//
+// The monitor doesn't reconnect, since it cannot rebuild the dialers of c. Only the health
+// monitor of the VPN reconnects, see [EventReconnectAttempt].
+func (c *Client) StartHealthMonitor(intervalSeconds int) {
+	interval := defaultHealthCheckInterval
+	if intervalSeconds > 0 {
+		interval = time.Duration(intervalSeconds) * time.Second
+	}
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if c.health != nil {
+		c.health.stop()
+	}
+	c.health = newCheckMonitor(func(ctx context.Context) error {
+		return connectivity.CheckTCPConnectivity(ctx, c)
+	}, interval, nil)
+}
+
+// StopHealthMonitor stops the health monitor started by [Client.StartHealthMonitor].
+func (c *Client) StopHealthMonitor() {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if c.health != nil {
+		c.health.stop()
+		c.health = nil
+	}
+}
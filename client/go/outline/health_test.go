@@ -0,0 +1,153 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/leakcheck"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthMonitor_Reconnects(t *testing.T) {
+	l := &fakeEventListener{events: make(chan [2]string, 2)}
+	defer Subscribe(EventConnectionStatusChanged, l).Unsubscribe()
+	attempts := &fakeEventListener{events: make(chan [2]string, 1)}
+	defer Subscribe(EventReconnectAttempt, attempts).Unsubscribe()
+
+	var calls atomic.Int32
+	statuses := make(chan string, 2)
+	m := newHealthMonitor(func(context.Context) error {
+		if calls.Add(1) == 1 {
+			return errors.New("connection lost")
+		}
+		return nil
+	}, 10*time.Millisecond, reconnectPolicy{initialDelay: 10 * time.Millisecond, maxDelay: time.Second, multiplier: 2, maxAttempts: 3},
+		func(status string) { statuses <- status })
+	defer m.stop()
+
+	var status connectionStatusEventJSON
+	ev := <-l.events
+	require.Equal(t, EventConnectionStatusChanged, ev[0])
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &status))
+	require.Equal(t, ConnectionStatusReconnecting, status.Status)
+	require.NotNil(t, status.Error)
+
+	ev = <-l.events
+	status = connectionStatusEventJSON{}
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &status))
+	require.Equal(t, ConnectionStatusConnected, status.Status)
+	require.Nil(t, status.Error)
+	require.Equal(t, ConnectionStatusConnected, m.Status())
+	require.Equal(t, ConnectionStatusReconnecting, <-statuses)
+	require.Equal(t, ConnectionStatusConnected, <-statuses)
+
+	var attempt reconnectAttemptEventJSON
+	ev = <-attempts.events
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &attempt))
+	require.Equal(t, 1, attempt.Attempt)
+	require.Equal(t, 3, attempt.MaxAttempts)
+	require.Equal(t, int64(10), attempt.DelayMs)
+	require.NotNil(t, attempt.Error)
+}
+
+func TestHealthMonitor_GivesUp(t *testing.T) {
+	statuses := make(chan string, 3)
+	var calls atomic.Int32
+	m := newHealthMonitor(func(context.Context) error {
+		// The server comes back after the monitor gave up.
+		if calls.Add(1) <= 4 {
+			return errors.New("connection lost")
+		}
+		return nil
+	}, time.Millisecond, reconnectPolicy{initialDelay: time.Millisecond, maxDelay: time.Millisecond, multiplier: 1, maxAttempts: 2},
+		func(status string) { statuses <- status })
+	defer m.stop()
+
+	require.Equal(t, ConnectionStatusReconnecting, <-statuses)
+	require.Equal(t, ConnectionStatusDisconnected, <-statuses)
+	require.Equal(t, ConnectionStatusConnected, <-statuses)
+	require.GreaterOrEqual(t, calls.Load(), int32(5))
+}
+
+func TestCheckMonitor(t *testing.T) {
+	attempts := &fakeEventListener{events: make(chan [2]string, 1)}
+	defer Subscribe(EventReconnectAttempt, attempts).Unsubscribe()
+
+	var calls atomic.Int32
+	statuses := make(chan string, 2)
+	m := newCheckMonitor(func(context.Context) error {
+		if calls.Add(1) == 1 {
+			return errors.New("connection lost")
+		}
+		return nil
+	}, 10*time.Millisecond, func(status string) { statuses <- status })
+	defer m.stop()
+
+	require.Equal(t, ConnectionStatusDisconnected, <-statuses)
+	require.Equal(t, ConnectionStatusConnected, <-statuses)
+	select {
+	case ev := <-attempts.events:
+		t.Fatalf("unexpected reconnect attempt: %v", ev)
+	default:
+	}
+}
+
+func TestHealthMonitor_StopWhileChecking(t *testing.T) {
+	leakcheck.Check(t)
+	m := newHealthMonitor(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Millisecond, defaultReconnectPolicy, nil)
+
+	time.Sleep(10 * time.Millisecond)
+	m.stop()
+	require.Equal(t, ConnectionStatusConnected, m.Status())
+}
+
+func TestHealthMonitor_CheckNow(t *testing.T) {
+	checks := make(chan struct{}, 1)
+	m := newHealthMonitor(func(context.Context) error {
+		checks <- struct{}{}
+		return nil
+	}, time.Hour, defaultReconnectPolicy, nil)
+	defer m.stop()
+
+	m.checkNow()
+	select {
+	case <-checks:
+	case <-time.After(time.Second):
+		t.Fatal("checkNow didn't trigger a check")
+	}
+}
+
+func TestGetHealth(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	resources.Go(resources.SubsystemHealth, func() { <-release })
+
+	out, err := getHealth()
+	require.NoError(t, err)
+	var usage resources.Usage
+	require.NoError(t, json.Unmarshal([]byte(out), &usage))
+	require.Positive(t, usage.Goroutines)
+	require.Positive(t, usage.GoroutinesBySubsystem[resources.SubsystemHealth])
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/hysteria2"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// transportTypeHysteria2 is the transport relaying the traffic over QUIC to a Hysteria 2 server.
+const transportTypeHysteria2 = "hysteria2"
+
+// obfsTypeSalamander is the only obfuscation of the Hysteria 2 packets supported.
+const obfsTypeSalamander = "salamander"
+
+// minSalamanderPasswordLen is the minimum length of the Salamander passwords, like the servers.
+const minSalamanderPasswordLen = 4
+
+// hysteria2TransportConfigJSON is the config of the "hysteria2" transport.
+type hysteria2TransportConfigJSON struct {
+	Type string `json:"$type"`
+	Host string `json:"host"`
+	Port uint16 `json:"port,omitempty"`
+	portHoppingConfigJSON
+
+	// Auth is the authentication of the user.
+	Auth string `json:"auth"`
+
+	// SNI is the server name of the TLS handshakes. Defaults to Host.
+	SNI string `json:"sni,omitempty"`
+
+	// Insecure skips the verification of the certificate of the server.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// PinSHA256 is the hex SHA-256 of a certificate of the server, which the connections
+	// require.
+	PinSHA256 string `json:"pinSHA256,omitempty"`
+
+	// Obfs obfuscates the QUIC packets, so that they don't look like QUIC.
+	Obfs *hysteria2ObfsConfigJSON `json:"obfs,omitempty"`
+
+	// DownMbps is the download bandwidth of the client, in Mbps, that the server sends at with
+	// its Brutal congestion control. 0 lets the server detect the bandwidth, with BBR.
+	DownMbps int `json:"downMbps,omitempty"`
+}
+
+// hysteria2ObfsConfigJSON is the "obfs" section of the "hysteria2" transport.
+type hysteria2ObfsConfigJSON struct {
+	// Type is the obfuscation. Only "salamander" is supported.
+	Type     string `json:"$type"`
+	Password string `json:"password"`
+}
+
+func init() {
+	transportRegistry[transportTypeHysteria2] = parseHysteria2Transport
+	firstHopsRegistry[transportTypeHysteria2] = func(config json.RawMessage) (firstHops, error) {
+		var conf hysteria2TransportConfigJSON
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return firstHops{}, err
+		}
+		return quicFirstHops(conf.Host, conf.Port, conf.Ports), nil
+	}
+}
+
+// parseHysteria2Transport is the [TransportParser] of the "hysteria2" transport.
+func parseHysteria2Transport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf hysteria2TransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("hysteria2 transport config is not a valid JSON", string(config), err)
+	}
+	if err := conf.validate(); err != nil {
+		return nil, nil, err
+	}
+	ports, err := conf.portHoppingConfigJSON.parse(conf.Port)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverName := conf.SNI
+	if serverName == "" {
+		serverName = conf.Host
+	}
+	tlsConf, err := newQUICTLSConfig(serverName, conf.Insecure, conf.PinSHA256, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	hyConf := hysteria2.Config{
+		Dial:      newQUICServerDialer(conf.Host, conf.Port, ports, time.Duration(conf.HopIntervalSeconds)*time.Second, dialers),
+		TLSConfig: tlsConf,
+		Auth:      conf.Auth,
+		// 1 Mbps is 125000 bytes per second.
+		MaxRx: uint64(conf.DownMbps) * 125_000,
+	}
+	if conf.Obfs != nil {
+		hyConf.ObfsPassword = conf.Obfs.Password
+	}
+	client := hysteria2.NewClient(hyConf)
+	return client, client, nil
+}
+
+func (conf *hysteria2TransportConfigJSON) validate() error {
+	if conf.Host == "" {
+		return newIllegalConfigErrorWithDetails("host name or IP is not valid", "host", conf.Host, "not nil", nil)
+	}
+	if _, err := conf.portHoppingConfigJSON.parse(conf.Port); err != nil {
+		return err
+	}
+	if conf.Auth == "" {
+		return newIllegalConfigErrorWithDetails("hysteria2 auth is not valid", "auth", conf.Auth, "not nil", nil)
+	}
+	if conf.DownMbps < 0 {
+		return newIllegalConfigErrorWithDetails("download bandwidth is not valid",
+			"downMbps", conf.DownMbps, "a positive number of Mbps", nil)
+	}
+	if conf.Obfs != nil {
+		if conf.Obfs.Type != obfsTypeSalamander {
+			return newIllegalConfigErrorWithDetails("hysteria2 obfuscation is not supported",
+				"obfs.$type", conf.Obfs.Type, fmt.Sprintf("%q", obfsTypeSalamander), nil)
+		}
+		if len(conf.Obfs.Password) < minSalamanderPasswordLen {
+			return newIllegalConfigErrorWithDetails("obfuscation password is too short",
+				"obfs.password", len(conf.Obfs.Password), fmt.Sprintf("at least %d characters", minSalamanderPasswordLen), nil)
+		}
+	}
+	return nil
+}
+
+// parseHysteria2URL parses a hysteria2:// or hy2:// URL, in the format of the Hysteria 2 apps:
+//
+//	hysteria2://auth@host:port/?sni=...&insecure=1&pinSHA256=...&obfs=salamander&obfs-password=...#name
+//
+// The port defaults to 443, and a user:password user info is the auth "user:password". The port can
+// be a list of ports and port ranges to hop between, like host:20000-30000, or the mport parameter.
+func parseHysteria2URL(u *url.URL) (any, error) {
+	port, err := urlPort(u, 443)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	conf := &hysteria2TransportConfigJSON{
+		Type:      transportTypeHysteria2,
+		Host:      u.Hostname(),
+		Port:      port,
+		Auth:      u.User.Username(),
+		SNI:       query.Get("sni"),
+		Insecure:  isTrueQueryValue(query.Get("insecure")),
+		PinSHA256: query.Get("pinSHA256"),
+	}
+	if password, ok := u.User.Password(); ok {
+		conf.Auth += ":" + password
+	}
+	if obfs := query.Get("obfs"); obfs != "" {
+		conf.Obfs = &hysteria2ObfsConfigJSON{Type: obfs, Password: query.Get("obfs-password")}
+	}
+	if mport := query.Get("mport"); mport != "" {
+		conf.Ports = mport
+		if u.Port() == "" {
+			conf.Port = 0
+		}
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
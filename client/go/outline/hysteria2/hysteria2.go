@@ -0,0 +1,299 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hysteria2 implements the client of the Hysteria 2 protocol, which relays the streams
+// and the packets over a QUIC connection that looks like HTTP/3. The client tells the server its
+// download bandwidth, so that the server sends at that rate (the "Brutal" congestion control)
+// instead of backing off on the packet loss of throttled networks.
+package hysteria2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/quicconn"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// The authentication request, an HTTP/3 request to https://hysteria/auth.
+const (
+	alpn     = "h3"
+	authURL  = "https://hysteria/auth"
+	statusOK = 233
+
+	headerAuth    = "Hysteria-Auth"
+	headerCCRX    = "Hysteria-CC-RX"
+	headerPadding = "Hysteria-Padding"
+	headerUDP     = "Hysteria-UDP"
+)
+
+// frameTypeTCPRequest starts the streams relaying TCP.
+const frameTypeTCPRequest = 0x401
+
+// Statuses of the TCP responses.
+const tcpStatusOK = 0
+
+// Limits of the TCP responses, as in the server.
+const (
+	maxMessageLen = 2048
+	maxPaddingLen = 4096
+)
+
+// Error codes of the connections, the ones of HTTP/3.
+const (
+	closeErrCodeOK            = 0x100
+	closeErrCodeProtocolError = 0x101
+)
+
+// quicConfig has the larger flow control windows of the Hysteria clients, so that the windows
+// don't limit the throughput of high-latency links.
+var quicConfig = &quic.Config{
+	InitialStreamReceiveWindow:     8 << 20,
+	MaxStreamReceiveWindow:         8 << 20,
+	InitialConnectionReceiveWindow: 20 << 20,
+	MaxConnectionReceiveWindow:     20 << 20,
+	MaxIdleTimeout:                 30 * time.Second,
+	KeepAlivePeriod:                10 * time.Second,
+	EnableDatagrams:                true,
+}
+
+// ErrUDPDisabled is returned by [Client.ListenPacket] when the server doesn't relay UDP.
+var ErrUDPDisabled = errors.New("hysteria2 server does not relay UDP")
+
+// AuthError is returned when the server rejects the authentication of the client.
+type AuthError struct {
+	// StatusCode is the HTTP status of the authentication response.
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("hysteria2 authentication failed with status %d", e.StatusCode)
+}
+
+// Config configures a [Client].
+type Config struct {
+	// Dial returns the packet conn of a new connection to the server, and the address of the server.
+	Dial func(ctx context.Context) (net.PacketConn, net.Addr, error)
+
+	// TLSConfig is the TLS config of the connections. Its NextProtos are replaced with HTTP/3.
+	TLSConfig *tls.Config
+
+	// Auth is the authentication of the user.
+	Auth string
+
+	// MaxRx is the download bandwidth of the client, in bytes per second, that the server sends
+	// at. 0 lets the server detect the bandwidth.
+	MaxRx uint64
+
+	// ObfsPassword obfuscates the QUIC packets with Salamander, if not empty. The server must have
+	// the same password.
+	ObfsPassword string
+}
+
+// Client relays the streams and the packets over a QUIC connection to a Hysteria 2 server, dialed
+// on the first use and redialed once it's closed.
+type Client struct {
+	config Config
+
+	mu   sync.Mutex
+	conn *conn
+}
+
+var (
+	_ transport.StreamDialer   = (*Client)(nil)
+	_ transport.PacketListener = (*Client)(nil)
+)
+
+// NewClient creates a [Client] with config.
+func NewClient(config Config) *Client {
+	return &Client{config: config}
+}
+
+// conn is an authenticated connection to the server.
+type conn struct {
+	quic.Connection
+	// udp tells whether the server relays UDP.
+	udp bool
+
+	mu            sync.Mutex
+	packetConns   map[uint32]*packetConn
+	nextSessionID uint32
+}
+
+// connection returns the connection to the server, dialing a new one if there's none open.
+func (c *Client) connection(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// connect dials and authenticates a new connection to the server.
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	pc, addr, err := c.config.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.ObfsPassword != "" {
+		pc = newSalamanderConn(pc, []byte(c.config.ObfsPassword))
+	}
+	tlsConf := &tls.Config{}
+	if c.config.TLSConfig != nil {
+		tlsConf = c.config.TLSConfig.Clone()
+	}
+	tlsConf.NextProtos = []string{alpn}
+	qc, err := quicconn.Dial(ctx, pc, addr, tlsConf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL, nil)
+	if err != nil {
+		qc.CloseWithError(closeErrCodeProtocolError, "")
+		return nil, err
+	}
+	req.Header.Set(headerAuth, c.config.Auth)
+	req.Header.Set(headerCCRX, strconv.FormatUint(c.config.MaxRx, 10))
+	req.Header.Set(headerPadding, randomPadding(256, 2048))
+	resp, err := (&http3.Transport{}).NewClientConn(qc).RoundTrip(req)
+	if err != nil {
+		qc.CloseWithError(closeErrCodeProtocolError, "")
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != statusOK {
+		qc.CloseWithError(closeErrCodeProtocolError, "")
+		return nil, &AuthError{StatusCode: resp.StatusCode}
+	}
+
+	conn := &conn{Connection: qc, udp: resp.Header.Get(headerUDP) == "true", packetConns: make(map[uint32]*packetConn)}
+	if conn.udp {
+		resources.Go(resources.SubsystemHysteria2, conn.receiveDatagrams)
+	}
+	return conn, nil
+}
+
+// Close closes the connection to the server, and the streams and packet conns over it.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.CloseWithError(closeErrCodeOK, "")
+	c.conn = nil
+	return err
+}
+
+// DialStream relays a stream to addr, a host:port address, once the server connected to it.
+func (c *Client) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	str, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	padding := randomPadding(64, 512)
+	req := quicvarint.Append(nil, frameTypeTCPRequest)
+	req = quicvarint.Append(req, uint64(len(addr)))
+	req = append(req, addr...)
+	req = quicvarint.Append(req, uint64(len(padding)))
+	req = append(req, padding...)
+	if _, err := str.Write(req); err != nil {
+		str.CancelRead(0)
+		str.Close()
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { str.SetReadDeadline(time.Now()) })
+	err = readTCPResponse(quicvarint.NewReader(str))
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		str.CancelRead(0)
+		str.Close()
+		return nil, err
+	}
+	return quicconn.NewStreamConn(conn, str), nil
+}
+
+// readTCPResponse reads the response to a TCP request:
+//
+//	[uint8 status][varint message length][message][varint padding length][padding]
+func readTCPResponse(r quicvarint.Reader) error {
+	status, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	msgLen, err := quicvarint.Read(r)
+	if err != nil {
+		return err
+	}
+	if msgLen > maxMessageLen {
+		return fmt.Errorf("hysteria2 response message of %d bytes is too long", msgLen)
+	}
+	msg := make([]byte, msgLen)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+	paddingLen, err := quicvarint.Read(r)
+	if err != nil {
+		return err
+	}
+	if paddingLen > maxPaddingLen {
+		return fmt.Errorf("hysteria2 response padding of %d bytes is too long", paddingLen)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(paddingLen)); err != nil {
+		return err
+	}
+	if status != tcpStatusOK {
+		return fmt.Errorf("hysteria2 server failed to connect: %s", msg)
+	}
+	return nil
+}
+
+// paddingChars are the characters of the paddings, which are sent in HTTP headers.
+const paddingChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// randomPadding returns a random padding of at least minLen and less than maxLen characters.
+func randomPadding(minLen, maxLen int) string {
+	b := make([]byte, minLen+rand.Intn(maxLen-minLen))
+	for i := range b {
+		b[i] = paddingChars[rand.Intn(len(paddingChars))]
+	}
+	return string(b)
+}
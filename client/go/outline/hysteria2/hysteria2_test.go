@@ -0,0 +1,225 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hysteria2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/transporttest"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a Hysteria 2 server on the loopback interface echoing the streams and the packets
+// back, or failing the streams to the "blocked.example" host.
+type testServer struct {
+	*transporttest.QUICServer
+	t            *testing.T
+	udp          bool
+	obfsPassword string
+
+	mu   sync.Mutex
+	ccRx []string
+}
+
+func startTestServer(t *testing.T, udp bool, obfsPassword string) *testServer {
+	srv := &testServer{t: t, udp: udp, obfsPassword: obfsPassword}
+	var pc net.PacketConn
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if obfsPassword != "" {
+		pc = newSalamanderConn(pc, []byte(obfsPassword))
+	}
+	srv.QUICServer = transporttest.StartQUIC(t, pc, alpn, srv.serve)
+	return srv
+}
+
+func (srv *testServer) serve(conn quic.Connection) {
+	h3 := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/auth" || r.Header.Get(headerAuth) != "secret" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			srv.mu.Lock()
+			srv.ccRx = append(srv.ccRx, r.Header.Get(headerCCRX))
+			srv.mu.Unlock()
+			w.Header().Set(headerUDP, strconv.FormatBool(srv.udp))
+			w.WriteHeader(statusOK)
+		}),
+		StreamHijacker: func(ft http3.FrameType, _ quic.ConnectionTracingID, str quic.Stream, err error) (bool, error) {
+			if err != nil || ft != frameTypeTCPRequest {
+				return false, nil
+			}
+			go srv.serveStream(str)
+			return true, nil
+		},
+	}
+	go func() {
+		// Echo the fragments of the packets back as they are.
+		for {
+			b, err := conn.ReceiveDatagram(context.Background())
+			if err != nil {
+				return
+			}
+			conn.SendDatagram(b)
+		}
+	}()
+	h3.ServeQUICConn(conn)
+}
+
+func (srv *testServer) serveStream(str quic.Stream) {
+	defer str.Close()
+	r := quicvarint.NewReader(str)
+	addrLen, err := quicvarint.Read(r)
+	require.NoError(srv.t, err)
+	addr := make([]byte, addrLen)
+	_, err = io.ReadFull(r, addr)
+	require.NoError(srv.t, err)
+	paddingLen, err := quicvarint.Read(r)
+	require.NoError(srv.t, err)
+	require.GreaterOrEqual(srv.t, paddingLen, uint64(64))
+	_, err = io.CopyN(io.Discard, r, int64(paddingLen))
+	require.NoError(srv.t, err)
+
+	if host, _, _ := net.SplitHostPort(string(addr)); host == "blocked.example" {
+		msg := "connection refused"
+		resp := quicvarint.Append([]byte{1}, uint64(len(msg)))
+		resp = quicvarint.Append(append(resp, msg...), 0)
+		str.Write(resp)
+		return
+	}
+	resp := quicvarint.Append([]byte{tcpStatusOK}, 0)
+	resp = quicvarint.Append(resp, 3)
+	_, err = str.Write(append(resp, "pad"...))
+	require.NoError(srv.t, err)
+	io.Copy(str, str)
+}
+
+func (srv *testServer) client(auth string) *Client {
+	return NewClient(Config{
+		Dial:         srv.Dial,
+		TLSConfig:    &tls.Config{RootCAs: srv.Roots, ServerName: "localhost"},
+		Auth:         auth,
+		MaxRx:        12_500_000,
+		ObfsPassword: srv.obfsPassword,
+	})
+}
+
+func TestClient(t *testing.T) {
+	for _, obfsPassword := range []string{"", "obfuscated"} {
+		srv := startTestServer(t, true, obfsPassword)
+		client := srv.client("secret")
+		defer client.Close()
+
+		data := bytes.Repeat([]byte("0123456789"), 100_000)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := client.DialStream(context.Background(), "example.com:443")
+				require.NoError(t, err)
+				defer conn.Close()
+				require.Equal(t, data, transporttest.Echo(t, conn, data))
+			}()
+		}
+		wg.Wait()
+
+		pc, err := client.ListenPacket(context.Background())
+		require.NoError(t, err)
+		defer pc.Close()
+		buf := make([]byte, 65535)
+		// The second packet is too large for a datagram.
+		for _, packet := range [][]byte{[]byte("ping"), bytes.Repeat([]byte("x"), 3000)} {
+			dest := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+			_, err = pc.WriteTo(packet, dest)
+			require.NoError(t, err)
+			pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, addr, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, packet, buf[:n])
+			require.Equal(t, "192.0.2.1:53", addr.String())
+		}
+
+		require.Equal(t, int32(1), srv.Conns.Load())
+		require.Equal(t, []string{"12500000"}, srv.ccRx)
+	}
+}
+
+func TestClient_AuthError(t *testing.T) {
+	srv := startTestServer(t, true, "")
+	_, err := srv.client("wrong").DialStream(context.Background(), "example.com:443")
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	require.Equal(t, http.StatusNotFound, authErr.StatusCode)
+}
+
+func TestClient_RemoteError(t *testing.T) {
+	srv := startTestServer(t, true, "")
+	client := srv.client("secret")
+	defer client.Close()
+	_, err := client.DialStream(context.Background(), "blocked.example:443")
+	require.ErrorContains(t, err, "connection refused")
+}
+
+func TestClient_UDPDisabled(t *testing.T) {
+	srv := startTestServer(t, false, "")
+	client := srv.client("secret")
+	defer client.Close()
+	_, err := client.ListenPacket(context.Background())
+	require.ErrorIs(t, err, ErrUDPDisabled)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	srv := startTestServer(t, true, "")
+	client := srv.client("secret")
+	defer client.Close()
+	pc, err := client.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer pc.Close()
+
+	client.conn.CloseWithError(closeErrCodeOK, "")
+	_, _, err = pc.ReadFrom(make([]byte, 1))
+	require.True(t, errors.Is(err, net.ErrClosed))
+
+	conn, err := client.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, []byte("ping"), transporttest.Echo(t, conn, []byte("ping")))
+	require.Equal(t, int32(2), srv.Conns.Load())
+}
+
+func TestUDPMessage(t *testing.T) {
+	msg := &udpMessage{sessionID: 7, packetID: 9, fragmentID: 1, fragments: 2, addr: "example.com:53", payload: []byte("payload")}
+	got, err := parseUDPMessage(appendUDPMessage(nil, msg))
+	require.NoError(t, err)
+	require.Equal(t, msg, got)
+
+	_, err = parseUDPMessage([]byte{0, 0, 0, 7, 0, 9, 0, 1, 20, 'a'})
+	require.Error(t, err)
+}
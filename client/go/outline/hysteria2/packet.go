@@ -0,0 +1,312 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hysteria2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// maxFragments is the number of fragments a packet too large for a datagram can be split into.
+const maxFragments = 255
+
+// packetQueueSize is the number of received packets a packet conn buffers before dropping them.
+const packetQueueSize = 128
+
+// ListenPacket creates a UDP session relaying packets to any destination. The packets are
+// relayed as QUIC datagrams:
+//
+//	[uint32 session ID][uint16 packet ID][uint8 fragment ID][uint8 fragment count]
+//	[varint address length][address][payload]
+func (c *Client) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !conn.udp {
+		return nil, ErrUDPDisabled
+	}
+	pc := &packetConn{conn: conn, packets: make(chan packet, packetQueueSize), closed: make(chan struct{})}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.packetConns == nil {
+		// The connection is closed.
+		return nil, net.ErrClosed
+	}
+	conn.nextSessionID++
+	pc.id = conn.nextSessionID
+	conn.packetConns[pc.id] = pc
+	return pc, nil
+}
+
+// udpMessage is a fragment of a packet.
+type udpMessage struct {
+	sessionID  uint32
+	packetID   uint16
+	fragmentID uint8
+	fragments  uint8
+	addr       string
+	payload    []byte
+}
+
+// appendUDPMessage appends the message of a fragment of a packet to b.
+func appendUDPMessage(b []byte, msg *udpMessage) []byte {
+	b = binary.BigEndian.AppendUint32(b, msg.sessionID)
+	b = binary.BigEndian.AppendUint16(b, msg.packetID)
+	b = append(b, msg.fragmentID, msg.fragments)
+	b = quicvarint.Append(b, uint64(len(msg.addr)))
+	b = append(b, msg.addr...)
+	return append(b, msg.payload...)
+}
+
+// parseUDPMessage parses the message of a datagram, whose payload it refers to.
+func parseUDPMessage(b []byte) (*udpMessage, error) {
+	if len(b) < 8 {
+		return nil, errors.New("hysteria2 UDP message is too short")
+	}
+	msg := &udpMessage{
+		sessionID:  binary.BigEndian.Uint32(b),
+		packetID:   binary.BigEndian.Uint16(b[4:]),
+		fragmentID: b[6],
+		fragments:  b[7],
+	}
+	addrLen, n, err := quicvarint.Parse(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	b = b[8+n:]
+	if addrLen > uint64(len(b)) {
+		return nil, errors.New("hysteria2 UDP message address is truncated")
+	}
+	msg.addr, msg.payload = string(b[:addrLen]), b[addrLen:]
+	return msg, nil
+}
+
+// receiveDatagrams dispatches the datagrams of the connection to the packet conns of their
+// sessions, until the connection is closed.
+func (c *conn) receiveDatagrams() {
+	defer c.closePacketConns()
+	for {
+		b, err := c.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		msg, err := parseUDPMessage(b)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		pc := c.packetConns[msg.sessionID]
+		c.mu.Unlock()
+		if pc != nil {
+			pc.receive(msg)
+		}
+	}
+}
+
+// closePacketConns ends the packet conns of the closed connection.
+func (c *conn) closePacketConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pc := range c.packetConns {
+		pc.closeOnce.Do(func() { close(pc.closed) })
+	}
+	c.packetConns = nil
+}
+
+type packet struct {
+	payload []byte
+	addr    net.Addr
+}
+
+// packetConn is a UDP session of a connection.
+type packetConn struct {
+	conn         *conn
+	id           uint32
+	nextPacketID atomic.Uint32
+
+	packets   chan packet
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// fragments are the received fragments of the packet with the ID fragmentsID. Only the
+	// receive loop of the connection accesses them.
+	fragmentsID uint16
+	fragments   []*udpMessage
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// receive queues the packet of msg, once all its fragments are received. The packet is dropped
+// if the queue is full.
+func (c *packetConn) receive(msg *udpMessage) {
+	payload := msg.payload
+	if msg.fragments > 1 {
+		if payload = c.defragment(msg); payload == nil {
+			return
+		}
+	}
+	select {
+	case c.packets <- packet{payload: payload, addr: parseAddr(msg.addr)}:
+	default:
+	}
+}
+
+// defragment returns the payload of the packet of the fragment msg, or nil until all its
+// fragments are received. Only the fragments of the latest packet are kept.
+func (c *packetConn) defragment(msg *udpMessage) []byte {
+	if msg.fragmentID >= msg.fragments {
+		return nil
+	}
+	if c.fragments == nil || msg.packetID != c.fragmentsID || len(c.fragments) != int(msg.fragments) {
+		c.fragmentsID = msg.packetID
+		c.fragments = make([]*udpMessage, msg.fragments)
+	}
+	c.fragments[msg.fragmentID] = msg
+	var payload []byte
+	for _, fragment := range c.fragments {
+		if fragment == nil {
+			return nil
+		}
+		payload = append(payload, fragment.payload...)
+	}
+	c.fragments = nil
+	return payload
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.packets:
+		return copy(b, p.payload), p.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends the packet b to addr, split into fragments if it's too large for a datagram.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	msg := &udpMessage{
+		sessionID: c.id,
+		packetID:  uint16(c.nextPacketID.Add(1)),
+		fragments: 1,
+		addr:      addrString(addr),
+		payload:   b,
+	}
+	err := c.conn.SendDatagram(appendUDPMessage(nil, msg))
+	var tooLarge *quic.DatagramTooLargeError
+	if !errors.As(err, &tooLarge) {
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	headerLen := len(appendUDPMessage(nil, &udpMessage{addr: msg.addr}))
+	maxPayload := int(tooLarge.MaxDatagramPayloadSize) - headerLen
+	if maxPayload <= 0 || (len(b)+maxPayload-1)/maxPayload > maxFragments {
+		return 0, fmt.Errorf("packet of %d bytes is too large", len(b))
+	}
+	msg.fragments = uint8((len(b) + maxPayload - 1) / maxPayload)
+	for i := 0; i < int(msg.fragments); i++ {
+		msg.fragmentID = uint8(i)
+		msg.payload = b[i*maxPayload : min(len(b), (i+1)*maxPayload)]
+		if err := c.conn.SendDatagram(appendUDPMessage(nil, msg)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close ends the session. The server has no message to end sessions: they expire once idle.
+func (c *packetConn) Close() error {
+	c.conn.mu.Lock()
+	delete(c.conn.packetConns, c.id)
+	c.conn.mu.Unlock()
+	closed := false
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	if !closed {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, as the datagrams are sent without blocking.
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// addrString returns the host:port string of addr, with IPv4 addresses in their 4-byte form.
+func addrString(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		addrPort := udpAddr.AddrPort()
+		return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()).String()
+	}
+	return addr.String()
+}
+
+// parseAddr parses the host:port address of a received packet.
+func parseAddr(s string) net.Addr {
+	addr, err := wire.ParseHostPort(s)
+	if err != nil {
+		return &wire.DomainAddr{Host: s}
+	}
+	return addr
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hysteria2
+
+import (
+	"crypto/rand"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"golang.org/x/crypto/blake2b"
+)
+
+// salamanderSaltLen is the size of the random salt prefixing the obfuscated packets.
+const salamanderSaltLen = 8
+
+// salamanderConn obfuscates the QUIC packets with Salamander, so that they don't look like QUIC:
+//
+//	[salt][packet XOR the repeated BLAKE2b-256(password + salt)]
+type salamanderConn struct {
+	net.PacketConn
+	password []byte
+}
+
+func newSalamanderConn(pc net.PacketConn, password []byte) *salamanderConn {
+	return &salamanderConn{PacketConn: pc, password: password}
+}
+
+// xorKey returns the key of the packets with salt.
+func (c *salamanderConn) xorKey(salt []byte) [blake2b.Size256]byte {
+	return blake2b.Sum256(append(append([]byte{}, c.password...), salt...))
+}
+
+func (c *salamanderConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf := bufpool.Packets.Get()
+	defer bufpool.Packets.Put(buf)
+	out := (*buf)[:salamanderSaltLen+len(b)]
+	if _, err := rand.Read(out[:salamanderSaltLen]); err != nil {
+		return 0, err
+	}
+	key := c.xorKey(out[:salamanderSaltLen])
+	for i, x := range b {
+		out[salamanderSaltLen+i] = x ^ key[i%len(key)]
+	}
+	if _, err := c.PacketConn.WriteTo(out, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads the next packet, skipping the ones too short to be obfuscated.
+func (c *salamanderConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := bufpool.Packets.Get()
+	defer bufpool.Packets.Put(buf)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(*buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n <= salamanderSaltLen {
+			continue
+		}
+		key := c.xorKey((*buf)[:salamanderSaltLen])
+		data := (*buf)[salamanderSaltLen:n]
+		n = min(len(b), len(data))
+		for i := 0; i < n; i++ {
+			b[i] = data[i] ^ key[i%len(key)]
+		}
+		return n, addr, nil
+	}
+}
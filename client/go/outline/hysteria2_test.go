@@ -0,0 +1,106 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHysteria2Transport(t *testing.T) {
+	for _, config := range []string{
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret"}`,
+		`{"$type":"hysteria2","host":"192.0.2.1","port":8443,"auth":"secret","sni":"a.example","insecure":true,"downMbps":100}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret","obfs":{"$type":"salamander","password":"obfuscated"}}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret",` +
+			`"pinSHA256":"BA:88:45:17:A1:BC:05:9A:86:C0:3B:DE:1A:1E:BD:4E:A6:9D:7C:B4:9B:35:0E:20:A2:C1:63:CE:2E:4E:C9:90"}`,
+		`{"$type":"mux","transport":{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret"}}`,
+		`{"$type":"hysteria2","host":"example.com","ports":"443,20000-30000","hopIntervalSeconds":10,"auth":"secret"}`,
+	} {
+		got := NewClient(config)
+		require.Nil(t, got.Error, config)
+	}
+}
+
+func TestParseHysteria2Transport_Invalid(t *testing.T) {
+	for _, config := range []string{
+		`{"$type":"hysteria2","port":443,"auth":"secret"}`,
+		`{"$type":"hysteria2","host":"example.com","auth":"secret"}`,
+		`{"$type":"hysteria2","host":"example.com","port":443}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret","downMbps":-1}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret","obfs":{"$type":"xor","password":"obfuscated"}}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret","obfs":{"$type":"salamander","password":"abc"}}`,
+		`{"$type":"hysteria2","host":"example.com","port":443,"auth":"secret","pinSHA256":"ba88"}`,
+		`{"$type":"hysteria2","host":"example.com","ports":"30000-20000","auth":"secret"}`,
+		`{"$type":"hysteria2","host":"example.com","ports":"20000-30000","hopIntervalSeconds":1,"auth":"secret"}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
+
+func TestParseHysteria2URL(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantName string
+		want     string
+	}{
+		{
+			input: "hysteria2://secret@example.com",
+			want:  `{"$type":"hysteria2","auth":"secret","host":"example.com","port":443}`,
+		},
+		{
+			input:    "hy2://user:pass@[2001:db8::1]:8443/?sni=a.example&insecure=1&obfs=salamander&obfs-password=obfuscated#My%20server",
+			wantName: "My server",
+			want: `{"$type":"hysteria2","auth":"user:pass","host":"2001:db8::1","insecure":true,` +
+				`"obfs":{"$type":"salamander","password":"obfuscated"},"port":8443,"sni":"a.example"}`,
+		},
+		{
+			input: "hysteria2://secret@example.com:443,20000-30000/?insecure=1",
+			want:  `{"$type":"hysteria2","auth":"secret","host":"example.com","insecure":true,"ports":"443,20000-30000"}`,
+		},
+		{
+			input: "hy2://secret@[2001:db8::1]:20000-30000",
+			want:  `{"$type":"hysteria2","auth":"secret","host":"2001:db8::1","ports":"20000-30000"}`,
+		},
+		{
+			input: "hysteria2://secret@example.com:8443/?mport=20000-30000",
+			want:  `{"$type":"hysteria2","auth":"secret","host":"example.com","port":8443,"ports":"20000-30000"}`,
+		},
+	}
+	for _, tt := range tests {
+		meta, _, canonical, err := parseTunnelConfigTransport(tt.input, true)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.wantName, meta.Name)
+		require.Equal(t, tt.want, canonical)
+
+		got := NewClient(canonical)
+		require.Nil(t, got.Error, canonical)
+	}
+
+	for _, input := range []string{
+		"hysteria2://example.com",
+		"hysteria2://secret@example.com:99999",
+		"hysteria2://secret@example.com/?obfs=salamander",
+		"hysteria2://secret@",
+		"hysteria2://secret@example.com:20000-",
+	} {
+		_, _, _, err := parseTunnelConfigTransport(input, false)
+		require.Error(t, err, input)
+	}
+}
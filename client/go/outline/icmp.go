@@ -0,0 +1,76 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/icmptun"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// transportTypeICMP is the experimental transport tunneling the packets of a QUIC transport in
+// ICMP echo messages, for the networks that block TCP and UDP to the server but let ping through.
+// It's slow, so it's usually the last transport of a "multi" transport.
+const transportTypeICMP = "icmp"
+
+// icmpTransportConfigJSON is the config of the "icmp" transport.
+type icmpTransportConfigJSON struct {
+	// Transport is the config of the QUIC transport, "hysteria2" or "tuic", whose packets are
+	// tunneled. Its server must run an ICMP tunnel server forwarding them to it, see
+	// [icmptun].
+	Transport json.RawMessage `json:"transport"`
+}
+
+func init() {
+	transportRegistry[transportTypeICMP] = parseICMPTransport
+	// The packets of the QUIC transport are sent in ICMP echo messages instead of UDP.
+	firstHopsRegistry[transportTypeICMP] = func(config json.RawMessage) (firstHops, error) {
+		var conf icmpTransportConfigJSON
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return firstHops{}, err
+		}
+		hops, err := nestedFirstHops(conf.Transport)
+		return firstHops{ICMP: hops.UDP}, err
+	}
+}
+
+// parseICMPTransport is the [TransportParser] of the "icmp" transport. The ICMP sockets are raw
+// sockets where the app may open them, like the desktop services, or else unprivileged ICMP
+// sockets, on Linux, Android and macOS.
+func parseICMPTransport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf icmpTransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("icmp transport config is not a valid JSON", string(config), err)
+	}
+	var nested struct {
+		Type string `json:"$type"`
+	}
+	if err := json.Unmarshal(conf.Transport, &nested); err != nil || len(conf.Transport) == 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("icmp transport has no transport",
+			"transport", nil, "a transport config", err)
+	}
+	if nested.Type != transportTypeHysteria2 && nested.Type != transportTypeTUIC {
+		return nil, nil, newIllegalConfigErrorWithDetails("icmp transport only tunnels QUIC transports",
+			"transport.$type", nested.Type, `"hysteria2" or "tuic"`, nil)
+	}
+	control := dialers.UDP.Control
+	dialers.ListenPacket = func(ctx context.Context) (net.PacketConn, error) {
+		return icmptun.Listen(ctx, control)
+	}
+	return parseTransportConfig(conf.Transport, dialers)
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseICMPTransport(t *testing.T) {
+	hysteria2 := `{"$type":"hysteria2","host":"192.0.2.1","port":443,"auth":"secret"}`
+	tuic := `{"$type":"tuic","host":"192.0.2.1","port":443,"uuid":"6c2f0b1e-935a-4d2c-8e0f-513a7719c402","password":"secret"}`
+	for _, config := range []string{
+		`{"$type":"icmp","transport":` + hysteria2 + `}`,
+		`{"$type":"icmp","transport":` + tuic + `}`,
+		// The ICMP tunnel as the last resort of a list of servers.
+		`{"$type":"multi","transports":[` + hysteria2 + `,{"$type":"icmp","transport":` + hysteria2 + `}]}`,
+	} {
+		got := NewClient(config)
+		require.Nil(t, got.Error, config)
+	}
+}
+
+func TestParseICMPTransport_Invalid(t *testing.T) {
+	for _, config := range []string{
+		`{"$type":"icmp"}`,
+		`{"$type":"icmp","transport":{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"}}`,
+		`{"$type":"icmp","transport":{"$type":"mux","transport":{"$type":"hysteria2"}}}`,
+		`{"$type":"icmp","transport":{"$type":"hysteria2","host":"192.0.2.1","port":443}}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
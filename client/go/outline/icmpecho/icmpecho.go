@@ -0,0 +1,262 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package icmpecho answers the ICMP echo requests (pings) of the tunnel.
+//
+// The proxies only relay TCP and UDP, so the pings sent through the tunnel are dropped, and ping,
+// like the apps checking the reachability of a host with ICMP, hangs until it times out. The
+// tunnel can instead reply to all of them, or reply to the ones whose destination answers a
+// probe through the proxy.
+package icmpecho
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// Mode selects how the tunnel handles the pings.
+type Mode string
+
+const (
+	// ModeOff drops the pings. It is the default.
+	ModeOff Mode = "off"
+
+	// ModeReply replies to all the pings right away, so that the apps see that the tunnel is up,
+	// but not whether the destination is reachable.
+	ModeReply Mode = "reply"
+
+	// ModeProbe replies to the pings whose destination answers a probe through the proxy, once
+	// the probe succeeds. The reachability and the round trip time of the probes are reused for
+	// a while, so that the destinations aren't probed for every ping.
+	ModeProbe Mode = "probe"
+)
+
+// ParseMode parses the mode in s. The empty string is [ModeOff].
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeOff:
+		return ModeOff, nil
+	case ModeReply, ModeProbe:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unsupported ICMP echo mode %q", s)
+}
+
+// Prober probes whether dst is reachable through the proxy.
+type Prober func(ctx context.Context, dst netip.Addr) error
+
+// NewTLSProber returns a [Prober] starting a TLS handshake with port 443 of the destinations
+// through sd. The destinations are reachable if they answer, even with a TLS alert, as the proxy
+// connections may succeed before the proxy server connects to them.
+func NewTLSProber(sd transport.StreamDialer) Prober {
+	return func(ctx context.Context, dst netip.Addr) error {
+		conn, err := sd.DialStream(ctx, netip.AddrPortFrom(dst, 443).String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: dst.String(), InsecureSkipVerify: true})
+		err = tlsConn.HandshakeContext(ctx)
+		var alert tls.AlertError
+		var header tls.RecordHeaderError
+		if errors.As(err, &alert) || errors.As(err, &header) {
+			return nil
+		}
+		return err
+	}
+}
+
+const (
+	ipv4HeaderLen  = 20
+	ipv6HeaderLen  = 40
+	icmpHeaderLen  = 8
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+	replyHopLimit  = 64
+
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	// probeTTL is how long the results of the probes are reused.
+	probeTTL = 10 * time.Second
+	// probeTimeout is the timeout of the probes, after which the pings go unanswered.
+	probeTimeout = 5 * time.Second
+	// maxPendingPings is the number of pings to a destination that wait for its probe.
+	maxPendingPings = 8
+)
+
+// probeResult is the result of the probe of a destination. The probe is still running while
+// done is zero.
+type probeResult struct {
+	done      time.Time
+	reachable bool
+	rtt       time.Duration
+	// pending are the replies to send if the running probe succeeds.
+	pending [][]byte
+}
+
+type echoWriter struct {
+	w, reply io.Writer
+	mode     Mode
+	probe    Prober
+
+	mu     sync.Mutex
+	probes map[netip.Addr]*probeResult
+}
+
+// NewWriter creates a writer of IP packets to w, which handles the ICMP echo requests according to
+// mode, and writes the echo replies to reply. probe probes the destinations in [ModeProbe].
+func NewWriter(w, reply io.Writer, mode Mode, probe Prober) io.Writer {
+	if mode == "" || mode == ModeOff {
+		return w
+	}
+	return &echoWriter{w: w, reply: reply, mode: mode, probe: probe, probes: make(map[netip.Addr]*probeResult)}
+}
+
+func (e *echoWriter) Write(pkt []byte) (int, error) {
+	resp, dst := echoReply(pkt)
+	if resp == nil {
+		return e.w.Write(pkt)
+	}
+	if e.mode == ModeReply {
+		e.reply.Write(resp)
+	} else {
+		e.replyAfterProbe(dst, resp)
+	}
+	return len(pkt), nil
+}
+
+// replyAfterProbe writes resp once dst is known to be reachable, after the round trip time of its
+// probe.
+func (e *echoWriter) replyAfterProbe(dst netip.Addr, resp []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	result, ok := e.probes[dst]
+	if ok && result.done.IsZero() {
+		if len(result.pending) < maxPendingPings {
+			result.pending = append(result.pending, resp)
+		}
+		return
+	}
+	if ok && now.Sub(result.done) < probeTTL {
+		if result.reachable {
+			time.AfterFunc(result.rtt, func() { e.reply.Write(resp) })
+		}
+		return
+	}
+	for addr, r := range e.probes {
+		if !r.done.IsZero() && now.Sub(r.done) >= probeTTL {
+			delete(e.probes, addr)
+		}
+	}
+	result = &probeResult{pending: [][]byte{resp}}
+	e.probes[dst] = result
+	resources.Go(resources.SubsystemICMPEcho, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		start := time.Now()
+		err := e.probe(ctx, dst)
+
+		e.mu.Lock()
+		result.done = time.Now()
+		result.reachable = err == nil
+		result.rtt = result.done.Sub(start)
+		pending := result.pending
+		result.pending = nil
+		e.mu.Unlock()
+		if err != nil {
+			return
+		}
+		for _, resp := range pending {
+			e.reply.Write(resp)
+		}
+	})
+}
+
+// echoReply returns the echo reply answering pkt, and its destination, if pkt is an unfragmented
+// ICMP or ICMPv6 echo request. The packets whose length fields don't fit the packet are ignored.
+func echoReply(pkt []byte) ([]byte, netip.Addr) {
+	if len(pkt) == 0 {
+		return nil, netip.Addr{}
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < ipv4HeaderLen {
+			return nil, netip.Addr{}
+		}
+		headerLen := int(pkt[0]&0x0F) * 4
+		totalLen := int(binary.BigEndian.Uint16(pkt[2:]))
+		if pkt[9] != protocolICMP || binary.BigEndian.Uint16(pkt[6:])&0x3FFF != 0 || headerLen < ipv4HeaderLen ||
+			totalLen < headerLen+icmpHeaderLen || totalLen > len(pkt) || pkt[headerLen] != icmpv4EchoRequest {
+			return nil, netip.Addr{}
+		}
+		icmp := pkt[headerLen:totalLen]
+		resp := make([]byte, ipv4HeaderLen+len(icmp))
+		resp[0] = 4<<4 | ipv4HeaderLen/4
+		binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)))
+		resp[8] = replyHopLimit
+		resp[9] = protocolICMP
+		copy(resp[12:16], pkt[16:20])
+		copy(resp[16:20], pkt[12:16])
+		binary.BigEndian.PutUint16(resp[10:], ^wire.Checksum(0, resp[:ipv4HeaderLen]))
+
+		reply := resp[ipv4HeaderLen:]
+		copy(reply, icmp)
+		reply[0], reply[2], reply[3] = icmpv4EchoReply, 0, 0
+		binary.BigEndian.PutUint16(reply[2:], ^wire.Checksum(0, reply))
+		return resp, netip.AddrFrom4([4]byte(pkt[16:20]))
+	case 6:
+		if len(pkt) < ipv6HeaderLen {
+			return nil, netip.Addr{}
+		}
+		payloadLen := int(binary.BigEndian.Uint16(pkt[4:]))
+		if pkt[6] != protocolICMPv6 || payloadLen < icmpHeaderLen || ipv6HeaderLen+payloadLen > len(pkt) ||
+			pkt[ipv6HeaderLen] != icmpv6EchoRequest {
+			return nil, netip.Addr{}
+		}
+		icmp := pkt[ipv6HeaderLen : ipv6HeaderLen+payloadLen]
+		resp := make([]byte, ipv6HeaderLen+len(icmp))
+		resp[0] = 6 << 4
+		binary.BigEndian.PutUint16(resp[4:], uint16(len(icmp)))
+		resp[6] = protocolICMPv6
+		resp[7] = replyHopLimit
+		copy(resp[8:24], pkt[24:40])
+		copy(resp[24:40], pkt[8:24])
+
+		reply := resp[ipv6HeaderLen:]
+		copy(reply, icmp)
+		reply[0], reply[2], reply[3] = icmpv6EchoReply, 0, 0
+		sum := wire.IPv6PseudoHeaderSum(resp, len(reply), protocolICMPv6)
+		binary.BigEndian.PutUint16(reply[2:], ^wire.Checksum(sum, reply))
+		return resp, netip.AddrFrom16([16]byte(pkt[24:40]))
+	}
+	return nil, netip.Addr{}
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icmpecho
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func newEchoRequest(ipv6 bool, payload []byte) []byte {
+	icmp := make([]byte, icmpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(icmp[4:], 0x1234)
+	binary.BigEndian.PutUint16(icmp[6:], 7)
+	copy(icmp[icmpHeaderLen:], payload)
+	if ipv6 {
+		icmp[0] = icmpv6EchoRequest
+		ip := make([]byte, ipv6HeaderLen)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(icmp)))
+		ip[6], ip[7] = protocolICMPv6, 64
+		ip[8], ip[23] = 0xfd, 1
+		ip[24], ip[25], ip[39] = 0x20, 0x01, 2
+		return append(ip, icmp...)
+	}
+	icmp[0] = icmpv4EchoRequest
+	binary.BigEndian.PutUint16(icmp[2:], ^wire.Checksum(0, icmp))
+	ip := make([]byte, ipv4HeaderLen)
+	ip[0] = 4<<4 | 5
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(icmp)))
+	ip[8], ip[9] = 64, protocolICMP
+	copy(ip[12:], []byte{10, 0, 0, 1, 192, 0, 2, 1})
+	return append(ip, icmp...)
+}
+
+// replyChan writes the packets to a channel, for the replies written in other goroutines.
+type replyChan chan []byte
+
+func (c replyChan) Write(b []byte) (int, error) {
+	c <- bytes.Clone(b)
+	return len(b), nil
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"": ModeOff, "off": ModeOff, "reply": ModeReply, "probe": ModeProbe} {
+		got, err := ParseMode(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := ParseMode("forward")
+	require.Error(t, err)
+}
+
+func TestNewWriter_Off(t *testing.T) {
+	var w bytes.Buffer
+	require.Same(t, &w, NewWriter(&w, nil, ModeOff, nil))
+}
+
+func TestWriter_ReplyIPv4(t *testing.T) {
+	var w, reply bytes.Buffer
+	ew := NewWriter(&w, &reply, ModeReply, nil)
+
+	udp := newEchoRequest(false, nil)
+	udp[9] = 17
+	_, err := ew.Write(udp)
+	require.NoError(t, err)
+	require.Equal(t, udp, w.Bytes())
+	require.Zero(t, reply.Len())
+
+	w.Reset()
+	ping := newEchoRequest(false, []byte("abcdefg"))
+	n, err := ew.Write(ping)
+	require.NoError(t, err)
+	require.Equal(t, len(ping), n)
+	require.Zero(t, w.Len())
+
+	resp := reply.Bytes()
+	require.Len(t, resp, len(ping))
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(0, resp[:ipv4HeaderLen]))
+	require.Equal(t, ping[16:20], resp[12:16], "the source must be the pinged host")
+	require.Equal(t, ping[12:16], resp[16:20], "the destination must be the pinger")
+	icmp := resp[ipv4HeaderLen:]
+	require.Equal(t, byte(icmpv4EchoReply), icmp[0])
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(0, icmp))
+	require.Equal(t, ping[ipv4HeaderLen+4:], icmp[4:], "the identifier, sequence number and data must be echoed")
+}
+
+func TestWriter_ReplyIPv6(t *testing.T) {
+	var w, reply bytes.Buffer
+	ew := NewWriter(&w, &reply, ModeReply, nil)
+
+	ping := newEchoRequest(true, []byte("abcdefg"))
+	_, err := ew.Write(ping)
+	require.NoError(t, err)
+	require.Zero(t, w.Len())
+
+	resp := reply.Bytes()
+	require.Len(t, resp, len(ping))
+	require.Equal(t, byte(protocolICMPv6), resp[6])
+	require.Equal(t, ping[24:40], resp[8:24])
+	require.Equal(t, ping[8:24], resp[24:40])
+	icmp := resp[ipv6HeaderLen:]
+	require.Equal(t, byte(icmpv6EchoReply), icmp[0])
+	sum := wire.IPv6PseudoHeaderSum(resp, len(icmp), protocolICMPv6)
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(sum, icmp))
+	require.Equal(t, ping[ipv6HeaderLen+4:], icmp[4:])
+}
+
+func TestWriter_NotEchoRequest(t *testing.T) {
+	var w, reply bytes.Buffer
+	ew := NewWriter(&w, &reply, ModeReply, nil)
+	unreachable := newEchoRequest(false, nil)
+	unreachable[ipv4HeaderLen] = 3
+	_, err := ew.Write(unreachable)
+	require.NoError(t, err)
+	require.Equal(t, unreachable, w.Bytes())
+	require.Zero(t, reply.Len())
+}
+
+func TestEchoReply_InvalidLength(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  func() []byte
+	}{
+		{"IPv4 truncated header", func() []byte { return newEchoRequest(false, nil)[:ipv4HeaderLen-1] }},
+		{"IPv4 truncated ICMP header", func() []byte { return newEchoRequest(false, nil)[:ipv4HeaderLen+icmpHeaderLen-1] }},
+		{"IPv4 total length below the header", func() []byte {
+			pkt := newEchoRequest(false, []byte("abcdefg"))
+			binary.BigEndian.PutUint16(pkt[2:], ipv4HeaderLen-1)
+			return pkt
+		}},
+		{"IPv4 total length below the ICMP header", func() []byte {
+			pkt := newEchoRequest(false, []byte("abcdefg"))
+			binary.BigEndian.PutUint16(pkt[2:], ipv4HeaderLen+icmpHeaderLen-1)
+			return pkt
+		}},
+		{"IPv4 total length beyond the packet", func() []byte {
+			pkt := newEchoRequest(false, []byte("abcdefg"))
+			binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)+1))
+			return pkt
+		}},
+		{"IPv4 header length beyond the packet", func() []byte {
+			pkt := newEchoRequest(false, nil)
+			pkt[0] = 4<<4 | 0x0F
+			return pkt
+		}},
+		{"IPv6 truncated header", func() []byte { return newEchoRequest(true, nil)[:ipv6HeaderLen-1] }},
+		{"IPv6 payload length below the ICMP header", func() []byte {
+			pkt := newEchoRequest(true, []byte("abcdefg"))
+			binary.BigEndian.PutUint16(pkt[4:], icmpHeaderLen-1)
+			return pkt
+		}},
+		{"IPv6 payload length beyond the packet", func() []byte {
+			pkt := newEchoRequest(true, []byte("abcdefg"))
+			binary.BigEndian.PutUint16(pkt[4:], 0xFFFF)
+			return pkt
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, dst := echoReply(tc.pkt())
+			require.Nil(t, resp)
+			require.False(t, dst.IsValid())
+		})
+	}
+}
+
+func TestWriter_Probe(t *testing.T) {
+	var probes atomic.Int32
+	release := make(chan struct{})
+	probe := func(ctx context.Context, dst netip.Addr) error {
+		probes.Add(1)
+		<-release
+		if dst == netip.MustParseAddr("192.0.2.1") {
+			return nil
+		}
+		return errors.New("unreachable")
+	}
+	var w bytes.Buffer
+	reply := make(replyChan, 10)
+	ew := NewWriter(&w, reply, ModeProbe, probe)
+
+	ping := newEchoRequest(false, []byte("1"))
+	_, err := ew.Write(ping)
+	require.NoError(t, err)
+	_, err = ew.Write(ping)
+	require.NoError(t, err)
+	require.Empty(t, reply, "the replies must wait for the probe")
+
+	close(release)
+	require.Len(t, <-reply, len(ping))
+	require.Len(t, <-reply, len(ping))
+
+	_, err = ew.Write(ping)
+	require.NoError(t, err)
+	select {
+	case <-reply:
+	case <-time.After(time.Second):
+		t.Fatal("the cached probe must be reused")
+	}
+	require.Equal(t, int32(1), probes.Load(), "the destination must be probed once")
+
+	other := newEchoRequest(false, []byte("2"))
+	other[19] = 2
+	_, err = ew.Write(other)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return probes.Load() == 2 }, time.Second, 10*time.Millisecond)
+	select {
+	case <-reply:
+		t.Fatal("the unreachable destination must not be answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Zero(t, w.Len())
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package icmptun tunnels packets in ICMP echo messages, for the networks that block the TCP and
+// UDP traffic to a server but let ping through. It's experimental.
+//
+// The client sends its packets in echo requests to the server, and the server answers with its
+// packets in the echo replies. The payload of the messages is:
+//
+//	[4-byte magic]["OLI>" from the client, or "OLI<" from the server][packet]
+//
+// The requests with an empty packet are polls, which give the server replies to send its packets
+// in, since the networks let a single reply through per request. The server must not answer the
+// echo requests itself, e.g. with net.ipv4.icmp_echo_ignore_all=1 on Linux.
+//
+// Only IPv4 is supported.
+package icmptun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+// Types of the ICMP messages.
+const (
+	icmpTypeEchoReply   = 0
+	icmpTypeEchoRequest = 8
+)
+
+// icmpHeaderLen is the size of the header of the echo messages: type, code, checksum,
+// identifier and sequence number.
+const icmpHeaderLen = 8
+
+// Magic prefixes of the payloads, which tell the packets of the tunnel from the ping messages,
+// and the replies of the server from the replies of its kernel echoing the requests.
+var (
+	magicClient = []byte("OLI>")
+	magicServer = []byte("OLI<")
+)
+
+const magicLen = 4
+
+const (
+	// pollInterval is the interval of the polls while the tunnel is busy.
+	pollInterval = 20 * time.Millisecond
+
+	// idlePollInterval is the interval of the polls once nothing has been received for
+	// idleAfter.
+	idlePollInterval = 500 * time.Millisecond
+	idleAfter        = 5 * time.Second
+)
+
+// Control is the control function of the ICMP sockets, like the one of [net.Dialer], e.g. to
+// exclude them from the VPN.
+type Control func(network, address string, c syscall.RawConn) error
+
+// Listen creates a packet conn relaying its packets in ICMP echo messages. It uses a raw ICMP
+// socket if the process may, or else an unprivileged ICMP socket where the platform has them,
+// like Linux, Android and macOS.
+//
+// The packet conn sends to and receives from a single server: the first address it writes to.
+func Listen(ctx context.Context, control Control) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: control}
+	pc, err := lc.ListenPacket(ctx, "ip4:icmp", "0.0.0.0")
+	raw := err == nil
+	if err != nil {
+		if !errors.Is(err, os.ErrPermission) {
+			return nil, err
+		}
+		if pc, err = listenUnprivileged(control); err != nil {
+			return nil, err
+		}
+	}
+	c := &conn{
+		pc:     pc,
+		raw:    raw,
+		id:     uint16(rand.Uint32()),
+		closed: make(chan struct{}),
+		peerCh: make(chan struct{}),
+	}
+	c.lastReceived.Store(time.Now().UnixNano())
+	resources.Go(resources.SubsystemICMP, c.poll)
+	return c, nil
+}
+
+// conn is a packet conn relaying its packets in ICMP echo messages.
+type conn struct {
+	pc net.PacketConn
+	// raw is set for raw sockets, which receive all the ICMP messages of the host, and need the
+	// addresses as [net.IPAddr]. The unprivileged sockets only receive the replies to their own
+	// requests, and need the addresses as [net.UDPAddr].
+	raw bool
+	id  uint16
+	seq atomic.Uint32
+
+	// lastReceived is the time of the last packet received, in Unix nanoseconds.
+	lastReceived atomic.Int64
+
+	peerOnce sync.Once
+	peerCh   chan struct{}
+	// peer is the address of the server, as given to the first WriteTo.
+	peer net.Addr
+	// peerIP is the address of the server in the addresses of the ICMP socket.
+	peerIP net.Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.PacketConn = (*conn)(nil)
+
+// setPeer sets the server to addr, if it's not set.
+func (c *conn) setPeer(addr net.Addr) error {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return errors.New("ICMP tunnel only sends to IP addresses")
+	}
+	if ip = ip.To4(); ip == nil {
+		return errors.New("ICMP tunnel only supports IPv4")
+	}
+	c.peerOnce.Do(func() {
+		c.peer = addr
+		if c.raw {
+			c.peerIP = &net.IPAddr{IP: ip}
+		} else {
+			c.peerIP = &net.UDPAddr{IP: ip}
+		}
+		close(c.peerCh)
+	})
+	return nil
+}
+
+// send sends p in an echo request to the server.
+func (c *conn) send(p []byte) error {
+	buf := bufpool.Packets.Get()
+	defer bufpool.Packets.Put(buf)
+	msg := appendEchoRequest((*buf)[:0], c.id, uint16(c.seq.Add(1)), p)
+	_, err := c.pc.WriteTo(msg, c.peerIP)
+	return err
+}
+
+func (c *conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if err := c.setPeer(addr); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		// Empty packets would be polls.
+		return 0, nil
+	}
+	if err := c.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads the next packet of the server, skipping the other ICMP messages.
+func (c *conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := bufpool.Packets.Get()
+	defer bufpool.Packets.Put(buf)
+	for {
+		n, from, err := c.pc.ReadFrom(*buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		select {
+		case <-c.peerCh:
+		default:
+			// Nothing was sent yet.
+			continue
+		}
+		if !sameIP(from, c.peerIP) {
+			continue
+		}
+		payload, ok := parseEchoReply((*buf)[:n], c.id, c.raw)
+		if !ok || len(payload) == 0 {
+			continue
+		}
+		c.lastReceived.Store(time.Now().UnixNano())
+		return copy(p, payload), c.peer, nil
+	}
+}
+
+// poll sends the polls until the conn is closed.
+func (c *conn) poll() {
+	select {
+	case <-c.peerCh:
+	case <-c.closed:
+		return
+	}
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-timer.C:
+		}
+		c.send(nil)
+		interval := pollInterval
+		if time.Since(time.Unix(0, c.lastReceived.Load())) > idleAfter {
+			interval = idlePollInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.pc.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *conn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *conn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *conn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
+
+// sameIP returns whether a and b have the same IP address.
+func sameIP(a, b net.Addr) bool {
+	ip := func(addr net.Addr) net.IP {
+		switch a := addr.(type) {
+		case *net.UDPAddr:
+			return a.IP
+		case *net.IPAddr:
+			return a.IP
+		}
+		return nil
+	}
+	return ip(a).Equal(ip(b))
+}
+
+// appendEchoRequest appends the echo request of the client with packet p to b.
+func appendEchoRequest(b []byte, id, seq uint16, p []byte) []byte {
+	start := len(b)
+	b = append(b, icmpTypeEchoRequest, 0, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, seq)
+	b = append(append(b, magicClient...), p...)
+	binary.BigEndian.PutUint16(b[start+2:], ^wire.Checksum(0, b[start:]))
+	return b
+}
+
+// parseEchoReply returns the packet of the echo reply msg of the server. The identifier of the
+// replies to the unprivileged sockets is the one the kernel set, which only checks it. Some
+// platforms, like macOS, return the replies with their IPv4 header, which is skipped.
+func parseEchoReply(msg []byte, id uint16, checkID bool) ([]byte, bool) {
+	if len(msg) > 0 && msg[0]>>4 == 4 {
+		// The echo replies have type 0, so this is the version of an IPv4 header.
+		headerLen := int(msg[0]&0x0f) * 4
+		if len(msg) < headerLen {
+			return nil, false
+		}
+		msg = msg[headerLen:]
+	}
+	if len(msg) < icmpHeaderLen+magicLen || msg[0] != icmpTypeEchoReply || msg[1] != 0 {
+		return nil, false
+	}
+	if checkID && binary.BigEndian.Uint16(msg[4:]) != id {
+		return nil, false
+	}
+	payload := msg[icmpHeaderLen:]
+	if string(payload[:magicLen]) != string(magicServer) {
+		return nil, false
+	}
+	return payload[magicLen:], true
+}
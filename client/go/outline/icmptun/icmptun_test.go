@@ -0,0 +1,115 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icmptun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestServer starts an ICMP tunnel server on the loopback interface echoing the packets
+// back, and returns the number of polls it received.
+func startTestServer(t *testing.T) *atomic.Int32 {
+	pc, err := net.ListenPacket("ip4:icmp", "127.0.0.1")
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("raw sockets are not permitted")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	var polls atomic.Int32
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg := buf[:n]
+			if len(msg) < icmpHeaderLen+magicLen || msg[0] != icmpTypeEchoRequest ||
+				string(msg[icmpHeaderLen:icmpHeaderLen+magicLen]) != string(magicClient) {
+				continue
+			}
+			payload := msg[icmpHeaderLen+magicLen:]
+			if len(payload) == 0 {
+				polls.Add(1)
+				continue
+			}
+			reply := append([]byte{icmpTypeEchoReply, 0, 0, 0}, msg[4:icmpHeaderLen]...)
+			reply = append(append(reply, magicServer...), payload...)
+			binary.BigEndian.PutUint16(reply[2:], ^wire.Checksum(0, reply))
+			pc.WriteTo(reply, from)
+		}
+	}()
+	return &polls
+}
+
+func TestConn(t *testing.T) {
+	polls := startTestServer(t)
+	pc, err := Listen(context.Background(), nil)
+	require.NoError(t, err)
+	defer pc.Close()
+
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}
+	buf := make([]byte, 2000)
+	for _, packet := range []string{"ping", string(make([]byte, 1200))} {
+		_, err = pc.WriteTo([]byte(packet), server)
+		require.NoError(t, err)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// The kernel echoes the requests too, but they are skipped.
+		n, addr, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, packet, string(buf[:n]))
+		require.Same(t, server, addr)
+	}
+	require.Eventually(t, func() bool { return polls.Load() > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = pc.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443})
+	require.Error(t, err)
+}
+
+func TestEchoMessages(t *testing.T) {
+	req := appendEchoRequest(nil, 0x1234, 7, []byte("packet"))
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(0, req))
+	require.Equal(t, byte(icmpTypeEchoRequest), req[0])
+	require.Equal(t, "OLI>packet", string(req[icmpHeaderLen:]))
+
+	reply := append([]byte{icmpTypeEchoReply, 0, 0, 0, 0x12, 0x34, 0, 7}, "OLI<packet"...)
+	payload, ok := parseEchoReply(reply, 0x1234, true)
+	require.True(t, ok)
+	require.Equal(t, "packet", string(payload))
+
+	// With an IPv4 header.
+	withHeader := append(append([]byte{0x45}, make([]byte, 19)...), reply...)
+	payload, ok = parseEchoReply(withHeader, 0x1234, true)
+	require.True(t, ok)
+	require.Equal(t, "packet", string(payload))
+
+	_, ok = parseEchoReply(reply, 0x4321, true)
+	require.False(t, ok)
+	_, ok = parseEchoReply(reply, 0x4321, false)
+	require.True(t, ok)
+	// The replies of the kernel echo the requests.
+	_, ok = parseEchoReply(append([]byte{icmpTypeEchoReply}, req[1:]...), 0x1234, true)
+	require.False(t, ok)
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package icmptun
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnprivileged creates an unprivileged ICMP socket, which the kernel only lets send echo
+// requests and receive their replies. On Linux, the group of the process must be in
+// net.ipv4.ping_group_range, which it is on Android.
+func listenUnprivileged(control Control) (net.PacketConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	if control != nil {
+		rawConn, err := pc.(syscall.Conn).SyscallConn()
+		if err == nil {
+			err = control("udp4", "0.0.0.0:0", rawConn)
+		}
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+	return pc, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bufpool provides pools of reusable byte buffers for the relay paths, which would
+// otherwise allocate a buffer per packet or per connection, and keep the garbage collector busy
+// under sustained traffic.
+package bufpool
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// PacketSize is the size of the [Packets] buffers, large enough for any UDP datagram.
+	PacketSize = 65535
+
+	// streamSize is the size of the buffers of [Copy], the same as [io.Copy].
+	streamSize = 32 * 1024
+)
+
+// Packets is the pool of the buffers receiving UDP datagrams.
+var Packets = New(PacketSize)
+
+var streams = New(streamSize)
+
+// Pool is a pool of buffers of a fixed size. It's safe for concurrent use.
+type Pool struct {
+	size int
+	pool sync.Pool
+}
+
+// New creates a pool of buffers of size bytes.
+func New(size int) *Pool {
+	p := &Pool{size: size}
+	p.pool.New = func() any {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+// Get returns a buffer of the size of the pool. It should be returned with [Pool.Put] once it's
+// no longer used. The buffer is a pointer to avoid an allocation when it's put back.
+func (p *Pool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns b to the pool, restoring its length. b must not be used afterwards. Buffers of
+// another capacity are dropped.
+func (p *Pool) Put(b *[]byte) {
+	if cap(*b) != p.size {
+		return
+	}
+	*b = (*b)[:p.size]
+	p.pool.Put(b)
+}
+
+// Copy copies from src to dst like [io.Copy], but with a pooled buffer when neither src nor dst
+// implement the copy themselves.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := streams.Get()
+	defer streams.Put(b)
+	return io.CopyBuffer(dst, src, *b)
+}
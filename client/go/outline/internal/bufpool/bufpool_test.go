@@ -0,0 +1,71 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufpool
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	p := New(16)
+	b := p.Get()
+	require.Len(t, *b, 16)
+
+	// The length is restored when the buffer is put back.
+	*b = (*b)[:3]
+	p.Put(b)
+	require.Len(t, *p.Get(), 16)
+
+	// Buffers of another capacity are dropped.
+	other := make([]byte, 8)
+	p.Put(&other)
+	require.Len(t, *p.Get(), 16)
+}
+
+func TestCopy(t *testing.T) {
+	var dst bytes.Buffer
+	// Hide the WriterTo of strings.Reader, so that the pooled buffer is used.
+	src := struct{ io.Reader }{strings.NewReader(strings.Repeat("x", 3*streamSize+1))}
+	n, err := Copy(&dst, src)
+	require.NoError(t, err)
+	require.Equal(t, int64(3*streamSize+1), n)
+	require.Equal(t, dst.Len(), int(n))
+}
+
+// discard is an io.Writer without ReaderFrom, unlike io.Discard.
+type discard struct{}
+
+func (discard) Write(b []byte) (int, error) { return len(b), nil }
+
+func BenchmarkCopy(b *testing.B) {
+	data := bytes.Repeat([]byte("x"), 4*1024)
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.Copy(discard{}, struct{ io.Reader }{bytes.NewReader(data)})
+		}
+	})
+	b.Run("bufpool.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Copy(discard{}, struct{ io.Reader }{bytes.NewReader(data)})
+		}
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leakcheck detects the goroutines and sockets leaked by tests.
+package leakcheck
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+// gracePeriod is how long the goroutines and sockets of a test have to go away after
+// it ends, e.g. for the relays noticing that their connections are closed.
+const gracePeriod = 2 * time.Second
+
+// Check fails t if the test ends with more goroutines or open sockets than it started with. Other
+// file descriptors aren't checked, since the runtime opens some lazily, like those of the network
+// poller. It must be called at the start of the test, before the cleanups releasing the resources
+// are registered, and not in parallel tests, whose resources can't be told apart.
+func Check(t testing.TB) {
+	t.Helper()
+	goroutines := runtime.NumGoroutine()
+	_, sockets := resources.OpenFiles()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(gracePeriod)
+		for {
+			leakedGoroutines := runtime.NumGoroutine() - goroutines
+			leakedSockets := 0
+			if sockets >= 0 {
+				_, current := resources.OpenFiles()
+				leakedSockets = current - sockets
+			}
+			if leakedGoroutines <= 0 && leakedSockets <= 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<20)
+				buf = buf[:runtime.Stack(buf, true)]
+				t.Errorf("test leaked %d goroutines and %d sockets. Goroutines:\n%s",
+					max(leakedGoroutines, 0), max(leakedSockets, 0), buf)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leakcheck
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingT records whether the test failed, to check the failures of Check.
+type recordingT struct {
+	testing.TB
+	failed   bool
+	cleanups []func()
+}
+
+func (t *recordingT) Errorf(string, ...any) { t.failed = true }
+func (t *recordingT) Cleanup(f func())      { t.cleanups = append(t.cleanups, f) }
+
+func (t *recordingT) end() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestCheck_NoLeak(t *testing.T) {
+	rt := &recordingT{TB: t}
+	Check(rt)
+	done := make(chan struct{})
+	go func() { <-done }()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	rt.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+	rt.end()
+	require.False(t, rt.failed)
+}
+
+func TestCheck_LeakedGoroutine(t *testing.T) {
+	rt := &recordingT{TB: t}
+	Check(rt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() { <-done }()
+	rt.end()
+	require.True(t, rt.failed)
+}
+
+func TestCheck_LeakedSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open sockets are only counted on Linux")
+	}
+	rt := &recordingT{TB: t}
+	Check(rt)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	rt.end()
+	require.True(t, rt.failed)
+}
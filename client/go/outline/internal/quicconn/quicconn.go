@@ -0,0 +1,72 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quicconn dials the QUIC connections of the transports relaying over QUIC, like
+// Hysteria2 and TUIC, and adapts their streams to [transport.StreamConn].
+package quicconn
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/quic-go/quic-go"
+)
+
+// Dial dials a QUIC connection to addr over pc, which is closed with the connection. pc is
+// closed right away if the dial fails.
+func Dial(ctx context.Context, pc net.PacketConn, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	conn, err := quic.Dial(ctx, pc, addr, tlsConf, conf)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	// quic-go doesn't close the packet conns it didn't create.
+	context.AfterFunc(conn.Context(), func() { pc.Close() })
+	return conn, nil
+}
+
+// streamConn is a QUIC stream as a [transport.StreamConn].
+type streamConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+var _ transport.StreamConn = (*streamConn)(nil)
+
+// NewStreamConn returns str, a stream of conn, as a [transport.StreamConn].
+func NewStreamConn(conn quic.Connection, str quic.Stream) transport.StreamConn {
+	return &streamConn{Stream: str, conn: conn}
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *streamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// CloseRead stops reading the stream, so that the writes of the peer fail.
+func (c *streamConn) CloseRead() error {
+	c.Stream.CancelRead(0)
+	return nil
+}
+
+// CloseWrite closes the sending direction of the stream, like a TCP FIN.
+func (c *streamConn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+// Close closes both directions of the stream. [quic.Stream.Close] only closes the sending one.
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
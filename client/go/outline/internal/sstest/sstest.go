@@ -0,0 +1,335 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sstest provides a Shadowsocks server on the loopback interface for the end-to-end
+// tests of the client, relaying TCP and UDP to the real destinations like a production server.
+//
+// It only supports the AEAD ciphers of outline-sdk, not Shadowsocks 2022.
+package sstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+// udpIdleTimeout is how long the server keeps the UDP associations without traffic.
+const udpIdleTimeout = 10 * time.Second
+
+// Server is a Shadowsocks server listening on TCP and UDP on the same loopback port.
+type Server struct {
+	// Addr is the "127.0.0.1:port" address of the server.
+	Addr string
+	// Cipher and Secret are the credentials of the server.
+	Cipher, Secret string
+
+	key      *shadowsocks.EncryptionKey
+	listener net.Listener
+	pc       net.PacketConn
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	salts  [][]byte
+	conns  map[net.Conn]struct{}
+	assocs map[string]net.PacketConn
+	closed bool
+}
+
+// Start starts a server with the given cipher and secret, which is closed at the end of the test.
+func Start(t testing.TB, cipher, secret string) *Server {
+	t.Helper()
+	s, err := NewServer(cipher, secret)
+	if err != nil {
+		t.Fatalf("failed to start the Shadowsocks server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewServer starts a server with the given cipher and secret. The caller must close it.
+func NewServer(cipher, secret string) (*Server, error) {
+	key, err := shadowsocks.NewEncryptionKey(cipher, secret)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Cipher: cipher, Secret: secret, key: key,
+		conns: make(map[net.Conn]struct{}), assocs: make(map[string]net.PacketConn)}
+	// The TCP port may be taken on UDP, so try a few.
+	for attempt := 0; attempt < 10 && s.pc == nil; attempt++ {
+		if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return nil, err
+		}
+		if s.pc, err = net.ListenPacket("udp", s.listener.Addr().String()); err != nil {
+			s.listener.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Addr = s.listener.Addr().String()
+	s.wg.Add(2)
+	go s.serveStreams()
+	go s.servePackets()
+	return s, nil
+}
+
+// Config returns the JSON transport config of the client of the server, with the given salt
+// prefix if it is not empty.
+func (s *Server) Config(prefix string) string {
+	host, port, _ := net.SplitHostPort(s.Addr)
+	portNum, _ := strconv.Atoi(port)
+	config, _ := json.Marshal(map[string]any{
+		"host": host, "port": portNum, "method": s.Cipher, "password": s.Secret, "prefix": prefix,
+	})
+	return string(config)
+}
+
+// Salts returns the salts of the TCP connections received so far, in order, so that the tests
+// can check their prefixes.
+func (s *Server) Salts() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.salts...)
+}
+
+// Close stops the server, closes its connections and waits for its goroutines to exit.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.listener.Close()
+	s.pc.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	for _, assoc := range s.assocs {
+		assoc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// track registers conn to be closed by [Server.Close]. It returns false if the server is closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *Server) serveStreams() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			defer conn.Close()
+			s.relayStream(conn)
+		}()
+	}
+}
+
+// relayStream relays the Shadowsocks stream of conn to its destination.
+func (s *Server) relayStream(conn net.Conn) {
+	salt := make([]byte, s.key.SaltSize())
+	if _, err := io.ReadFull(conn, salt); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.salts = append(s.salts, salt)
+	s.mu.Unlock()
+
+	r := shadowsocks.NewReader(io.MultiReader(bytes.NewReader(salt), conn), s.key)
+	dest, err := wire.SOCKSAddr.Read(r)
+	if err != nil {
+		return
+	}
+	target, err := net.DialTimeout("tcp", dest.String(), 5*time.Second)
+	if err != nil {
+		return
+	}
+	if !s.track(target) {
+		target.Close()
+		return
+	}
+	defer s.untrack(target)
+	defer target.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(target, r)
+		target.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(shadowsocks.NewWriter(conn, s.key), target)
+	conn.(*net.TCPConn).CloseWrite()
+	<-done
+}
+
+func (s *Server) servePackets() {
+	defer s.wg.Done()
+	buf := make([]byte, 64*1024)
+	for {
+		n, clientAddr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		payload, err := shadowsocks.Unpack(nil, buf[:n], s.key)
+		if err != nil {
+			continue
+		}
+		dest, n, err := wire.SOCKSAddr.Parse(payload)
+		if err != nil {
+			continue
+		}
+		destAddr, err := net.ResolveUDPAddr("udp", dest.String())
+		if err != nil {
+			continue
+		}
+		assoc, err := s.association(clientAddr)
+		if err != nil {
+			continue
+		}
+		assoc.WriteTo(payload[n:], destAddr)
+	}
+}
+
+// association returns the UDP socket relaying the packets of clientAddr, creating it if needed.
+func (s *Server) association(clientAddr net.Addr) (net.PacketConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+	if assoc, ok := s.assocs[clientAddr.String()]; ok {
+		return assoc, nil
+	}
+	assoc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.assocs[clientAddr.String()] = assoc
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.assocs, clientAddr.String())
+			s.mu.Unlock()
+			assoc.Close()
+		}()
+		buf := make([]byte, 64*1024)
+		for {
+			assoc.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+			n, srcAddr, err := assoc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			plaintext, err := wire.SOCKSAddr.AppendAddr(nil, srcAddr)
+			if err != nil {
+				continue
+			}
+			plaintext = append(plaintext, buf[:n]...)
+			pkt, err := shadowsocks.Pack(make([]byte, s.key.SaltSize()+len(plaintext)+s.key.TagSize()), plaintext, s.key)
+			if err != nil {
+				continue
+			}
+			s.pc.WriteTo(pkt, clientAddr)
+		}
+	}()
+	return assoc, nil
+}
+
+// StartEcho starts TCP and UDP echo servers on the same loopback port, to be the destinations
+// of the tests, and returns their address. They are closed at the end of the test.
+func StartEcho(t testing.TB) string {
+	t.Helper()
+	var l net.Listener
+	var pc net.PacketConn
+	var err error
+	for attempt := 0; attempt < 10 && pc == nil; attempt++ {
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatalf("failed to start the echo server: %v", err)
+		}
+		if pc, err = net.ListenPacket("udp", l.Addr().String()); err != nil {
+			l.Close()
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to start the echo server: %v", err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		pc.Close()
+		wg.Wait()
+	})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return l.Addr().String()
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstest
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	echo := StartEcho(t)
+	s := Start(t, "chacha20-ietf-poly1305", "secret")
+	key, err := shadowsocks.NewEncryptionKey(s.Cipher, s.Secret)
+	require.NoError(t, err)
+
+	sd, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: s.Addr}, key)
+	require.NoError(t, err)
+	sd.SaltGenerator = shadowsocks.NewPrefixSaltGenerator([]byte("PRE"))
+	conn, err := sd.DialStream(context.Background(), echo)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+	require.NoError(t, conn.CloseWrite())
+	_, err = conn.Read(got)
+	require.ErrorIs(t, err, io.EOF)
+	conn.Close()
+	require.Len(t, s.Salts(), 1)
+	require.Equal(t, "PRE", string(s.Salts()[0][:3]))
+
+	pl, err := shadowsocks.NewPacketListener(&transport.UDPEndpoint{Address: s.Addr}, key)
+	require.NoError(t, err)
+	pc, err := pl.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer pc.Close()
+	echoAddr, err := net.ResolveUDPAddr("udp", echo)
+	require.NoError(t, err)
+	_, err = pc.WriteTo([]byte("datagram"), echoAddr)
+	require.NoError(t, err)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "datagram", string(buf[:n]))
+	require.Equal(t, echo, from.String())
+}
+
+func TestServer_WrongKey(t *testing.T) {
+	echo := StartEcho(t)
+	s := Start(t, "aes-256-gcm", "secret")
+	key, err := shadowsocks.NewEncryptionKey(s.Cipher, "wrong")
+	require.NoError(t, err)
+	sd, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: s.Addr}, key)
+	require.NoError(t, err)
+	conn, err := sd.DialStream(context.Background(), echo)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 5))
+	require.Error(t, err)
+}
+
+func TestNewServer_UnsupportedCipher(t *testing.T) {
+	_, err := NewServer("rc4-md5", "secret")
+	require.Error(t, err)
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transporttest provides the fixtures of the tests of the transports: a QUIC server on
+// the loopback interface with a self-signed certificate, and the echo of the streams.
+package transporttest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+// QUICServer is a QUIC server on the loopback interface, with a self-signed certificate for
+// "localhost".
+type QUICServer struct {
+	// Addr is the address of the server.
+	Addr net.Addr
+	// Roots has the certificate of the server, for the clients to trust.
+	Roots *x509.CertPool
+	// Conns is the number of connections the server accepted.
+	Conns atomic.Int32
+}
+
+// StartQUIC starts a QUIC server with datagrams on pc, a loopback packet conn, negotiating alpn.
+// serve handles each connection. The server and pc are closed at the end of the test.
+func StartQUIC(t testing.TB, pc net.PacketConn, alpn string, serve func(quic.Connection)) *QUICServer {
+	t.Helper()
+	srv := &QUICServer{Addr: pc.LocalAddr()}
+	tlsConf, roots := NewTLSConfig(t)
+	srv.Roots = roots
+	tlsConf.NextProtos = []string{alpn}
+	ln, err := quic.Listen(pc, tlsConf, &quic.Config{EnableDatagrams: true})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+		pc.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			srv.Conns.Add(1)
+			go serve(conn)
+		}
+	}()
+	return srv
+}
+
+// Dial dials the server from a new loopback packet conn, like the Dial of the QUIC clients.
+func (srv *QUICServer) Dial(ctx context.Context) (net.PacketConn, net.Addr, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	return pc, srv.Addr, err
+}
+
+// NewTLSConfig returns the TLS config of a server with a self-signed certificate for
+// "localhost", and the pool with the certificate.
+func NewTLSConfig(t testing.TB) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, roots
+}
+
+// Echo writes data to conn, half-closes it, and returns what it reads back.
+func Echo(t testing.TB, conn transport.StreamConn, data []byte) []byte {
+	go func() {
+		_, err := conn.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, conn.CloseWrite())
+	}()
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return got
+}
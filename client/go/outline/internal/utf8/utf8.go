@@ -33,3 +33,13 @@ func DecodeUTF8CodepointsToRawBytes(utf8Str string) ([]byte, error) {
 	}
 	return rawBytes, nil
 }
+
+// EncodeRawBytesToUTF8Codepoints is the inverse of [DecodeUTF8CodepointsToRawBytes]: it converts
+// each byte of rawBytes to a single codepoint of the returned string.
+func EncodeRawBytesToUTF8Codepoints(rawBytes []byte) string {
+	runes := make([]rune, len(rawBytes))
+	for i, b := range rawBytes {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -83,3 +83,14 @@ func Test_DecodeUTF8CodepointsToRawBytes(t *testing.T) {
 		})
 	}
 }
+
+func Test_EncodeRawBytesToUTF8Codepoints(t *testing.T) {
+	rawBytes := []byte{0, 1, 2, 'a', 126, 127, 128, 129, 254, 255}
+	got := EncodeRawBytesToUTF8Codepoints(rawBytes)
+	if want := string([]rune{0, 1, 2, 'a', 126, 127, 128, 129, 254, 255}); got != want {
+		t.Errorf("EncodeRawBytesToUTF8Codepoints() = %q, want %q", got, want)
+	}
+	if decoded, err := DecodeUTF8CodepointsToRawBytes(got); err != nil || !bytes.Equal(decoded, rawBytes) {
+		t.Errorf("DecodeUTF8CodepointsToRawBytes() = %v, %v, want %v", decoded, err, rawBytes)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wire encodes the addresses and checksums shared by the wire formats of the transports
+// and of the packet handling of the VPN.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// AddrFormat is the encoding of the addresses of a protocol:
+//
+//	[uint8 type][IPv4 or IPv6 address, or uint8 length and domain name][uint16 big-endian port]
+//
+// The protocols only differ in the type bytes.
+type AddrFormat struct {
+	IPv4, IPv6, Domain byte
+}
+
+// SOCKSAddr is the format of the SOCKS addresses, see
+// https://datatracker.ietf.org/doc/html/rfc1928#section-5.
+var SOCKSAddr = AddrFormat{IPv4: 0x01, Domain: 0x03, IPv6: 0x04}
+
+// DomainAddr is a [net.Addr] with a domain name, of the packets of the proxies that don't resolve
+// the names of the sources.
+type DomainAddr struct {
+	Host string
+	Port uint16
+}
+
+func (a *DomainAddr) Network() string { return "udp" }
+func (a *DomainAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.FormatUint(uint64(a.Port), 10))
+}
+
+// Append appends the address of host and port to b.
+func (f AddrFormat) Append(b []byte, host string, port uint16) ([]byte, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			b = append(b, f.IPv4)
+		} else {
+			b = append(b, f.IPv6)
+		}
+		b = append(b, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain name %q is too long", host)
+		}
+		b = append(b, f.Domain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, port), nil
+}
+
+// AppendHostPort appends the address of addr, a host:port address, to b.
+func (f AddrFormat) AppendHostPort(b []byte, addr string) ([]byte, error) {
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return f.Append(b, host, port)
+}
+
+// AppendAddr appends the address of addr to b.
+func (f AddrFormat) AppendAddr(b []byte, addr net.Addr) ([]byte, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		addrPort := udpAddr.AddrPort()
+		return f.Append(b, addrPort.Addr().String(), addrPort.Port())
+	}
+	return f.AppendHostPort(b, addr.String())
+}
+
+// Parse parses the address at the start of b, and returns its size. IP addresses are returned as
+// [*net.UDPAddr], and domain names as [*DomainAddr].
+func (f AddrFormat) Parse(b []byte) (net.Addr, int, error) {
+	if len(b) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	n := 1
+	switch b[0] {
+	case f.IPv4:
+		n += net.IPv4len
+	case f.IPv6:
+		n += net.IPv6len
+	case f.Domain:
+		if len(b) < 2 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		n += 1 + int(b[1])
+	default:
+		return nil, 0, fmt.Errorf("unknown address type %d", b[0])
+	}
+	if len(b) < n+2 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	port := binary.BigEndian.Uint16(b[n:])
+	if b[0] == f.Domain {
+		return &DomainAddr{Host: string(b[2:n]), Port: port}, n + 2, nil
+	}
+	ip, _ := netip.AddrFromSlice(b[1:n])
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), n + 2, nil
+}
+
+// Read reads an address from r, like [AddrFormat.Parse].
+func (f AddrFormat) Read(r io.Reader) (net.Addr, error) {
+	// The largest address is a domain name of 255 bytes.
+	var buf [1 + 1 + 255 + 2]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return nil, err
+	}
+	var size int
+	switch buf[0] {
+	case f.IPv4:
+		size = 1 + net.IPv4len + 2
+	case f.IPv6:
+		size = 1 + net.IPv6len + 2
+	case f.Domain:
+		if _, err := io.ReadFull(r, buf[1:2]); err != nil {
+			return nil, err
+		}
+		size = 2 + int(buf[1]) + 2
+	default:
+		return nil, fmt.Errorf("unknown address type %d", buf[0])
+	}
+	start := 1
+	if buf[0] == f.Domain {
+		start = 2
+	}
+	if _, err := io.ReadFull(r, buf[start:size]); err != nil {
+		return nil, err
+	}
+	addr, _, err := f.Parse(buf[:size])
+	return addr, err
+}
+
+// ParseHostPort returns the [net.Addr] of addr, a host:port address, like [AddrFormat.Parse].
+func ParseHostPort(addr string) (net.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(addrPort), nil
+	}
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &DomainAddr{Host: host, Port: port}, nil
+}
+
+// SplitHostPort splits addr, a host:port address, into its host and its numeric port.
+func SplitHostPort(addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, errors.New("invalid port in " + strconv.Quote(addr))
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrFormat(t *testing.T) {
+	for _, tt := range []struct {
+		addr    string
+		encoded []byte
+	}{
+		{"192.0.2.1:443", []byte{0x01, 192, 0, 2, 1, 0x01, 0xbb}},
+		{"example.com:80", append(append([]byte{0x03, 11}, "example.com"...), 0, 80)},
+		{"[2001:db8::1]:53", append(append([]byte{0x04}, net.ParseIP("2001:db8::1")...), 0, 53)},
+	} {
+		encoded, err := SOCKSAddr.AppendHostPort(nil, tt.addr)
+		require.NoError(t, err)
+		require.Equal(t, tt.encoded, encoded)
+
+		addr, n, err := SOCKSAddr.Parse(append(tt.encoded, "payload"...))
+		require.NoError(t, err)
+		require.Equal(t, tt.addr, addr.String())
+		require.Equal(t, len(tt.encoded), n)
+
+		addr, err = SOCKSAddr.Read(bytes.NewReader(tt.encoded))
+		require.NoError(t, err)
+		require.Equal(t, tt.addr, addr.String())
+
+		parsed, err := ParseHostPort(tt.addr)
+		require.NoError(t, err)
+		encoded, err = SOCKSAddr.AppendAddr(nil, parsed)
+		require.NoError(t, err)
+		require.Equal(t, tt.encoded, encoded)
+	}
+}
+
+func TestAddrFormat_IPv4Mapped(t *testing.T) {
+	encoded, err := SOCKSAddr.AppendAddr(nil, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 192, 0, 2, 1, 0x01, 0xbb}, encoded)
+}
+
+func TestAddrFormat_Invalid(t *testing.T) {
+	_, _, err := SOCKSAddr.Parse([]byte{0x01, 1, 2})
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = SOCKSAddr.Parse([]byte{9, 0, 0})
+	require.Error(t, err)
+	_, err = SOCKSAddr.Read(bytes.NewReader([]byte{0x03, 11, 'e'}))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = SOCKSAddr.AppendHostPort(nil, "example.com:http")
+	require.Error(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import "encoding/binary"
+
+// Checksum adds the 16-bit words of b to the one's complement sum, and returns it folded to 16
+// bits. The Internet checksum of the IP, ICMP, TCP and UDP headers is its complement, see
+// RFC 1071, and a valid packet sums to 0xFFFF.
+func Checksum(sum uint32, b []byte) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return uint16(sum)
+}
+
+// IPv6PseudoHeaderSum returns the sum of the IPv6 pseudo-header that the checksums of the
+// upper-layer protocols of IPv6 cover: the source and destination addresses, at pkt[8:40] in the
+// IPv6 header, the upper-layer length and the next header.
+func IPv6PseudoHeaderSum(pkt []byte, length int, nextHeader uint8) uint32 {
+	return uint32(Checksum(0, pkt[8:40])) + uint32(length) + uint32(nextHeader)
+}
+
+// UpdateChecksum updates the checksum at the start of b after a 16-bit word changed from old to
+// new, as per RFC 1624.
+func UpdateChecksum(b []byte, old, new uint16) {
+	sum := uint32(^binary.BigEndian.Uint16(b)) + uint32(^old) + uint32(new)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	binary.BigEndian.PutUint16(b, ^uint16(sum))
+}
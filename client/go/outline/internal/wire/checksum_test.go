@@ -0,0 +1,40 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	// The example of RFC 1071, section 3.
+	b := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	require.Equal(t, uint16(0xddf2), Checksum(0, b))
+	require.Equal(t, Checksum(0, append(b, 0x12, 0x00)), Checksum(0, append(b, 0x12)))
+
+	b = binary.BigEndian.AppendUint16(b, ^Checksum(0, b))
+	require.Equal(t, uint16(0xFFFF), Checksum(0, b))
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	b := []byte{0, 0, 0x12, 0x34, 0x56, 0x78}
+	binary.BigEndian.PutUint16(b, ^Checksum(0, b[2:]))
+	binary.BigEndian.PutUint16(b[2:], 0xabcd)
+	UpdateChecksum(b, 0x1234, 0xabcd)
+	require.Equal(t, uint16(0xFFFF), Checksum(0, b))
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// killSwitch is whether the tunnel fails closed: while the server is unreachable, it keeps
+// capturing the traffic and drops it, instead of giving up and letting it leave the VPN.
+var killSwitch atomic.Bool
+
+func killSwitchEnabled() bool {
+	return killSwitch.Load()
+}
+
+// setKillSwitch parses the JSON boolean input and enables or disables the kill switch, including
+// for the active VPN connection. Enabling it fails with [platerrors.FeatureNotSupported] on the
+// platforms whose VPN can't block the traffic, see killSwitchSupported.
+func setKillSwitch(input string) error {
+	var enabled bool
+	if err := json.Unmarshal([]byte(input), &enabled); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "kill switch toggle must be true or false",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if enabled && !killSwitchSupported {
+		return platerrors.PlatformError{
+			Code:    platerrors.FeatureNotSupported,
+			Message: "the kill switch is not supported on this platform",
+		}
+	}
+	killSwitch.Store(enabled)
+	applyKillSwitch()
+	updateSessionState(func(state *sessionStateJSON) { state.KillSwitch = enabled })
+	logger.Info("kill switch updated", "enabled", enabled)
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kvstore persists small values of the Go features, like cookies and cached
+// subscriptions, in a single file, optionally encrypted by the platform.
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cipher encrypts the content of the store at rest, e.g. with a key of the Android Keystore or
+// the macOS Keychain.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Store is a key-value store kept in memory and written to its file on every change. It is safe
+// for concurrent use.
+type Store struct {
+	path   string
+	cipher Cipher
+
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemory creates a [Store] that is not persisted.
+func NewMemory() *Store {
+	return &Store{values: make(map[string][]byte)}
+}
+
+// New creates an empty [Store] persisted to the file at path, which is overwritten on the first
+// change. The file is encrypted with cipher, unless it is nil.
+func New(path string, cipher Cipher) *Store {
+	return &Store{path: path, cipher: cipher, values: make(map[string][]byte)}
+}
+
+// Open loads the [Store] in the file at path, or creates an empty one if the file doesn't exist,
+// like [New].
+func Open(path string, cipher Cipher) (*Store, error) {
+	s := New(path, cipher)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cipher != nil {
+		if data, err = cipher.Decrypt(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the store: %w", err)
+		}
+	}
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("failed to parse the store: %w", err)
+	}
+	return s, nil
+}
+
+// Get returns the value of key, and whether it exists.
+func (s *Store) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set sets the value of key, and persists the store.
+func (s *Store) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return s.save()
+}
+
+// Delete deletes key, if it exists, and persists the store.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; !ok {
+		return nil
+	}
+	delete(s.values, key)
+	return s.save()
+}
+
+// Keys returns the sorted keys starting with prefix.
+func (s *Store) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// save writes the store to its file through a temporary file, so that a crash never leaves a
+// partial store behind. It must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	if s.cipher != nil {
+		if data, err = s.cipher.Encrypt(data); err != nil {
+			return fmt.Errorf("failed to encrypt the store: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// xorCipher is a reversible Cipher for the tests.
+type xorCipher struct{ fail bool }
+
+func (c xorCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	if c.fail {
+		return nil, errors.New("no key")
+	}
+	return bytes.Map(func(r rune) rune { return r ^ 0x55 }, plaintext), nil
+}
+
+func (c xorCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	return c.Encrypt(ciphertext)
+}
+
+func TestStore_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	for _, cipher := range []Cipher{nil, xorCipher{}} {
+		os.Remove(path)
+		s, err := Open(path, cipher)
+		require.NoError(t, err)
+		require.NoError(t, s.Set("fetch:a", []byte("1")))
+		require.NoError(t, s.Set("fetch:b", []byte("2")))
+		require.NoError(t, s.Set("cookies:c", []byte("3")))
+		require.NoError(t, s.Delete("fetch:b"))
+		require.NoError(t, s.Delete("missing"))
+
+		s, err = Open(path, cipher)
+		require.NoError(t, err)
+		value, ok := s.Get("fetch:a")
+		require.True(t, ok)
+		require.Equal(t, []byte("1"), value)
+		_, ok = s.Get("fetch:b")
+		require.False(t, ok)
+		require.Equal(t, []string{"fetch:a"}, s.Keys("fetch:"))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, cipher == nil, bytes.Contains(data, []byte("fetch:a")), "encrypted: %v", cipher != nil)
+	}
+}
+
+func TestStore_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := Open(path, xorCipher{fail: true})
+	require.NoError(t, err)
+	require.Error(t, s.Set("key", []byte("value")))
+	require.NoFileExists(t, path)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+	_, err = Open(path, nil)
+	require.Error(t, err)
+}
+
+func TestNewMemory(t *testing.T) {
+	s := NewMemory()
+	require.NoError(t, s.Set("key", []byte("value")))
+	value, ok := s.Get("key")
+	require.True(t, ok)
+	require.Equal(t, []byte("value"), value)
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+const (
+	defaultMaxTCPStreams    = 1024
+	defaultStreamQueueDelay = 10 * time.Second
+)
+
+// limitsConfigJSON is the "limits" section of a transport config. It caps the resources the
+// tunnel uses, so that a single misbehaving app, e.g. a torrent client, cannot exhaust the file
+// descriptors of the VPN service. The UDP sessions are capped by udpMaxSessions.
+type limitsConfigJSON struct {
+	// MaxTCPStreams is how many TCP connections can be open at the same time. The connections
+	// beyond it wait, in order, for another one to close. Defaults to 1024.
+	MaxTCPStreams int `json:"maxTcpStreams,omitempty"`
+
+	// QueueSeconds is how long a connection waits for another one to close before failing.
+	// Defaults to 10 seconds.
+	QueueSeconds int `json:"queueSeconds,omitempty"`
+}
+
+// streamLimiter validates the "limits" section of the config, and returns the limiter of the TCP
+// connections.
+func (conf *configJSON) streamLimiter() (*streamLimiter, error) {
+	l := &streamLimiter{max: defaultMaxTCPStreams, queueDelay: defaultStreamQueueDelay}
+	if conf.Limits == nil {
+		return l, nil
+	}
+	if conf.Limits.MaxTCPStreams < 0 {
+		return nil, newIllegalConfigErrorWithDetails("TCP stream limit is not valid",
+			"limits.maxTcpStreams", conf.Limits.MaxTCPStreams, "a positive number", nil)
+	}
+	if conf.Limits.QueueSeconds < 0 {
+		return nil, newIllegalConfigErrorWithDetails("timeout is not valid",
+			"limits.queueSeconds", conf.Limits.QueueSeconds, "a positive number of seconds", nil)
+	}
+	if conf.Limits.MaxTCPStreams > 0 {
+		l.max = conf.Limits.MaxTCPStreams
+	}
+	if conf.Limits.QueueSeconds > 0 {
+		l.queueDelay = time.Duration(conf.Limits.QueueSeconds) * time.Second
+	}
+	return l, nil
+}
+
+// streamLimiter limits the number of open TCP connections. The connections beyond the limit
+// are queued in FIFO order, so that they are served fairly as the open ones close.
+type streamLimiter struct {
+	max        int
+	queueDelay time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiters list.List // Of chan struct{}, closed when the waiter is given a slot.
+}
+
+// acquire takes a connection slot, waiting for one to be released if all are taken. It fails
+// if ctx is done or no slot is released within the queue delay.
+func (l *streamLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.active < l.max && l.waiters.Len() == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueDelay)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("too many TCP connections: %d are open", l.max)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// The slot was given to us in the meantime.
+		return nil
+	default:
+		l.waiters.Remove(elem)
+		return err
+	}
+}
+
+// release releases a connection slot, handing it over to the first waiter if any.
+func (l *streamLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if front := l.waiters.Front(); front != nil {
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.active--
+}
+
+// streamDialer returns a [transport.StreamDialer] dialing with sd within the limits of l.
+func (l *streamLimiter) streamDialer(sd transport.StreamDialer) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if err := l.acquire(ctx); err != nil {
+			logger.Debug("TCP connection rejected", "err", err)
+			return nil, err
+		}
+		conn, err := sd.DialStream(ctx, addr)
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+		return &limitedStreamConn{StreamConn: conn, release: sync.OnceFunc(l.release)}, nil
+	})
+}
+
+// limitedStreamConn is a [transport.StreamConn] releasing its slot of a [streamLimiter] once
+// closed.
+type limitedStreamConn struct {
+	transport.StreamConn
+	release func()
+}
+
+func (c *limitedStreamConn) Close() error {
+	c.release()
+	return c.StreamConn.Close()
+}
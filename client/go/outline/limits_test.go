@@ -0,0 +1,140 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigStreamLimiter(t *testing.T) {
+	tests := []struct {
+		input      string
+		max        int
+		queueDelay time.Duration
+	}{
+		{`{}`, defaultMaxTCPStreams, defaultStreamQueueDelay},
+		{`{"limits": {"maxTcpStreams": 64}}`, 64, defaultStreamQueueDelay},
+		{`{"limits": {"maxTcpStreams": 64, "queueSeconds": 2}}`, 64, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		conf, err := parseConfigFromJSON(tt.input)
+		require.NoError(t, err)
+		l, err := conf.streamLimiter()
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.max, l.max, tt.input)
+		require.Equal(t, tt.queueDelay, l.queueDelay, tt.input)
+	}
+}
+
+func TestConfigStreamLimiter_Negative(t *testing.T) {
+	for input, field := range map[string]string{
+		`{"limits": {"maxTcpStreams": -1}}`: "limits.maxTcpStreams",
+		`{"limits": {"queueSeconds": -1}}`:  "limits.queueSeconds",
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err)
+		_, err = conf.streamLimiter()
+		var perr platerrors.PlatformError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, platerrors.IllegalConfig, perr.Code)
+		require.Equal(t, field, perr.Details["field"])
+	}
+}
+
+// pipeDialer dials connections to nowhere, which only need to be closed.
+var pipeDialer = transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, _ := net.Pipe()
+	return &pipeStreamConn{conn}, nil
+})
+
+type pipeStreamConn struct {
+	net.Conn
+}
+
+func (c *pipeStreamConn) CloseRead() error  { return nil }
+func (c *pipeStreamConn) CloseWrite() error { return nil }
+
+func TestStreamLimiter_QueuesInOrder(t *testing.T) {
+	l := &streamLimiter{max: 1, queueDelay: time.Minute}
+	sd := l.streamDialer(pipeDialer)
+	first, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+
+	dialed := make(chan string, 2)
+	for _, name := range []string{"second", "third"} {
+		name := name
+		go func() {
+			conn, err := sd.DialStream(context.Background(), "example.com:443")
+			require.NoError(t, err)
+			dialed <- name
+			time.Sleep(10 * time.Millisecond)
+			conn.Close()
+		}()
+		// Let the dial queue up before the next one.
+		require.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return l.waiters.Len() > 0 && name == "second" || l.waiters.Len() > 1
+		}, time.Second, time.Millisecond)
+	}
+	require.Len(t, dialed, 0)
+
+	require.NoError(t, first.Close())
+	// Closing twice doesn't release twice.
+	first.Close()
+	require.Equal(t, "second", <-dialed)
+	require.Equal(t, "third", <-dialed)
+}
+
+func TestStreamLimiter_QueueTimeout(t *testing.T) {
+	l := &streamLimiter{max: 1, queueDelay: 10 * time.Millisecond}
+	sd := l.streamDialer(pipeDialer)
+	conn, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+
+	_, err = sd.DialStream(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "too many TCP connections")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sd.DialStream(ctx, "example.com:443")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The failed dials don't hold a slot.
+	require.NoError(t, conn.Close())
+	conn, err = sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 0, l.active)
+}
+
+func TestStreamLimiter_ReleasesFailedDials(t *testing.T) {
+	l := &streamLimiter{max: 1, queueDelay: 10 * time.Millisecond}
+	failing := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		return nil, net.ErrClosed
+	})
+	for i := 0; i < 3; i++ {
+		_, err := l.streamDialer(failing).DialStream(context.Background(), "example.com:443")
+		require.ErrorIs(t, err, net.ErrClosed)
+	}
+	require.Equal(t, 0, l.active)
+}
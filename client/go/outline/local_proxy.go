@@ -0,0 +1,147 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/localproxy"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// localProxyHost is the address the local proxies are bound to. They are not authenticated, so
+// they must not be reachable from other devices.
+const localProxyHost = "127.0.0.1"
+
+// localProxyJSON is the input of [MethodStartLocalProxy].
+type localProxyJSON struct {
+	// Transport is the transport config to relay the traffic through.
+	Transport json.RawMessage `json:"transport"`
+
+	// SOCKSPort is the port of the SOCKS5 proxy. Zero picks any free port.
+	SOCKSPort uint16 `json:"socksPort"`
+
+	// HTTPPort is the port of the HTTP proxy. The HTTP proxy is only started if it is set.
+	HTTPPort uint16 `json:"httpPort,omitempty"`
+}
+
+// localProxyAddressesJSON is the output of [MethodStartLocalProxy].
+type localProxyAddressesJSON struct {
+	SOCKSAddress string `json:"socksAddress"`
+	HTTPAddress  string `json:"httpAddress,omitempty"`
+}
+
+// localProxy is the running local proxy mode, with its SOCKS5 and optional HTTP servers.
+type localProxy struct {
+	socks     *localproxy.Server
+	http      *localproxy.Server
+	stats     *stats.Session
+	transport *transportDescriptionJSON
+}
+
+var localProxyMu sync.Mutex
+var activeLocalProxy *localProxy
+
+// startLocalProxy starts the local proxies described by the JSON string input, replacing the
+// running ones, and returns a JSON string of localProxyAddressesJSON.
+func startLocalProxy(input string) (string, error) {
+	var req localProxyJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid local proxy request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	result := NewClient(string(req.Transport))
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	localProxyMu.Lock()
+	defer localProxyMu.Unlock()
+	if activeLocalProxy != nil {
+		activeLocalProxy.close()
+		activeLocalProxy = nil
+	}
+
+	p := &localProxy{stats: stats.StartSession(), transport: result.Client.description}
+	dialer := p.stats.StreamDialer(result.Client)
+	var err error
+	if p.socks, err = localproxy.ListenSOCKS5(localProxyAddress(req.SOCKSPort), dialer); err != nil {
+		p.close()
+		return "", newLocalProxyListenError("socks", req.SOCKSPort, err)
+	}
+	out := localProxyAddressesJSON{SOCKSAddress: p.socks.Addr().String()}
+	if req.HTTPPort != 0 {
+		if p.http, err = localproxy.ListenHTTP(localProxyAddress(req.HTTPPort), dialer); err != nil {
+			p.close()
+			return "", newLocalProxyListenError("http", req.HTTPPort, err)
+		}
+		out.HTTPAddress = p.http.Addr().String()
+	}
+	activeLocalProxy = p
+	setActiveTransport(p.transport)
+	logger.Info("local proxy started", "socks", out.SOCKSAddress, "http", out.HTTPAddress)
+
+	outJSON, err := json.Marshal(out)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal local proxy addresses",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(outJSON), nil
+}
+
+// stopLocalProxy stops the running local proxies, if any.
+func stopLocalProxy() {
+	localProxyMu.Lock()
+	defer localProxyMu.Unlock()
+	if activeLocalProxy != nil {
+		activeLocalProxy.close()
+		activeLocalProxy = nil
+		logger.Info("local proxy stopped")
+	}
+}
+
+func (p *localProxy) close() {
+	if p.socks != nil {
+		p.socks.Close()
+	}
+	if p.http != nil {
+		p.http.Close()
+	}
+	stats.EndSession(p.stats)
+	clearActiveTransport(p.transport)
+}
+
+func localProxyAddress(port uint16) string {
+	return net.JoinHostPort(localProxyHost, fmt.Sprint(port))
+}
+
+func newLocalProxyListenError(proxy string, port uint16, cause error) error {
+	return platerrors.PlatformError{
+		Code:    platerrors.SetupTrafficHandlerFailed,
+		Message: "failed to start the local proxy",
+		Details: platerrors.ErrorDetails{"proxy": proxy, "port": port},
+		Cause:   platerrors.ToPlatformError(cause),
+	}
+}
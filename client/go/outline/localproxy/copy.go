@@ -0,0 +1,80 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localproxy
+
+import (
+	"io"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+)
+
+// spliceChunkSize is how much data is spliced between two reports of the traffic to the
+// socketWrappers.
+const spliceChunkSize = 256 * 1024
+
+// socketWrapper is implemented by the connections wrapping a socket to count its traffic, like
+// the ones of the stats package. When splicing, the relay reads and writes the wrapped socket
+// directly, and reports the traffic with CountRead and CountWritten instead.
+type socketWrapper interface {
+	NetConn() net.Conn
+	CountRead(n int64)
+	CountWritten(n int64)
+}
+
+// tcpSocket returns the TCP socket of conn, unwrapping its socketWrappers, and the wrappers. ok
+// is false if conn isn't a TCP socket.
+func tcpSocket(conn io.ReadWriter) (socket *net.TCPConn, wrappers []socketWrapper, ok bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, wrappers, true
+		case socketWrapper:
+			wrappers = append(wrappers, c)
+			conn = c.NetConn()
+		default:
+			return nil, nil, false
+		}
+	}
+}
+
+// copyStream copies from src to dst until EOF. When both are TCP sockets and splicing is
+// supported (see spliceSupported), the data is moved in the kernel without going through user
+// space. Otherwise it's copied with a pooled buffer.
+func copyStream(dst, src io.ReadWriter) (int64, error) {
+	if !spliceSupported {
+		return bufpool.Copy(dst, src)
+	}
+	dstSocket, dstWrappers, dstOK := tcpSocket(dst)
+	srcSocket, srcWrappers, srcOK := tcpSocket(src)
+	if !dstOK || !srcOK {
+		return bufpool.Copy(dst, src)
+	}
+	var written int64
+	for {
+		// The net package splices a limited reader of a TCP socket too.
+		n, err := dstSocket.ReadFrom(&io.LimitedReader{R: srcSocket, N: spliceChunkSize})
+		written += n
+		for _, w := range srcWrappers {
+			w.CountRead(n)
+		}
+		for _, w := range dstWrappers {
+			w.CountWritten(n)
+		}
+		if err != nil || n == 0 {
+			return written, err
+		}
+	}
+}
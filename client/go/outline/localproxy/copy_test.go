@@ -0,0 +1,119 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns the two ends of a TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	client, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	server, err := listener.AcceptTCP()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// countingConn is a socketWrapper counting the traffic like the stats connections.
+type countingConn struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.CountRead(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.CountWritten(int64(n))
+	return n, err
+}
+
+func (c *countingConn) NetConn() net.Conn    { return c.Conn }
+func (c *countingConn) CountRead(n int64)    { c.read.Add(n) }
+func (c *countingConn) CountWritten(n int64) { c.written.Add(n) }
+
+func TestCopyStream_Sockets(t *testing.T) {
+	writer, srcSocket := tcpPair(t)
+	dstSocket, reader := tcpPair(t)
+	src, dst := &countingConn{Conn: srcSocket}, &countingConn{Conn: dstSocket}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*spliceChunkSize/16+1)
+
+	go func() {
+		writer.Write(data)
+		writer.CloseWrite()
+	}()
+	done := make(chan int64)
+	go func() {
+		n, err := copyStream(dst, src)
+		require.NoError(t, err)
+		dstSocket.CloseWrite()
+		done <- n
+	}()
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	require.Equal(t, int64(len(data)), <-done)
+	// The traffic is counted by the wrappers, whether it went through them or was spliced.
+	require.Equal(t, int64(len(data)), src.read.Load())
+	require.Equal(t, int64(len(data)), dst.written.Load())
+}
+
+func TestCopyStream_NotSockets(t *testing.T) {
+	srcWriter, src := net.Pipe()
+	dst, dstReader := net.Pipe()
+	go func() {
+		srcWriter.Write([]byte("hello"))
+		srcWriter.Close()
+	}()
+	go func() {
+		copyStream(dst, src)
+		dst.Close()
+	}()
+	got, err := io.ReadAll(dstReader)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+}
+
+func TestTCPSocket(t *testing.T) {
+	socket, _ := tcpPair(t)
+	inner := &countingConn{Conn: socket}
+	outer := &countingConn{Conn: inner}
+	got, wrappers, ok := tcpSocket(outer)
+	require.True(t, ok)
+	require.Same(t, socket, got)
+	require.Equal(t, []socketWrapper{outer, inner}, wrappers)
+
+	pipe, _ := net.Pipe()
+	_, _, ok = tcpSocket(&countingConn{Conn: pipe})
+	require.False(t, ok)
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localproxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// ListenHTTP starts an HTTP proxy server on address, relaying the requests through dialer.
+// It supports both CONNECT tunnels and plain HTTP requests with an absolute URL.
+func ListenHTTP(address string, dialer transport.StreamDialer) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(listener, dialer, (*Server).handleHTTP), nil
+}
+
+func (s *Server) handleHTTP(conn net.Conn) {
+	forwarder := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return s.dialer.DialStream(ctx, addr)
+		},
+	}
+	defer forwarder.CloseIdleConnections()
+
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		if req.Method == http.MethodConnect {
+			s.handleHTTPConnect(conn, reader, req)
+			return
+		}
+		if !req.URL.IsAbs() {
+			writeHTTPError(conn, http.StatusBadRequest)
+			return
+		}
+		req.RequestURI = ""
+		req.Header.Del("Proxy-Connection")
+		req.Header.Del("Proxy-Authorization")
+		resp, err := forwarder.RoundTrip(req)
+		if err != nil {
+			logger.Debug("HTTP proxy failed to forward request", "host", req.URL.Host, "err", err)
+			writeHTTPError(conn, http.StatusBadGateway)
+			return
+		}
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+func (s *Server) handleHTTPConnect(conn net.Conn, reader *bufio.Reader, req *http.Request) {
+	remote, err := s.dialer.DialStream(req.Context(), req.Host)
+	if err != nil {
+		logger.Debug("HTTP proxy failed to connect", "host", req.Host, "err", err)
+		writeHTTPError(conn, http.StatusBadGateway)
+		return
+	}
+	defer remote.Close()
+	if _, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+	// Forward what the client sent right after the CONNECT request.
+	if n := reader.Buffered(); n > 0 {
+		buffered, _ := reader.Peek(n)
+		if _, err = remote.Write(buffered); err != nil {
+			return
+		}
+	}
+	relay(conn, remote)
+}
+
+func writeHTTPError(conn net.Conn, status int) {
+	resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+	resp.Write(conn)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/leakcheck"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHTTPServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSOCKS5(t *testing.T) {
+	leakcheck.Check(t)
+	target := newTestHTTPServer(t)
+	server, err := ListenSOCKS5("127.0.0.1:0", &transport.TCPDialer{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	dialer, err := socks5.NewStreamDialer(&transport.TCPEndpoint{Address: server.Addr().String()})
+	require.NoError(t, err)
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialStream(ctx, addr)
+		},
+	}}
+	defer httpClient.CloseIdleConnections()
+	resp, err := httpClient.Get(target.URL + "/socks")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello /socks", string(body))
+}
+
+func TestSOCKS5_UnsupportedCommand(t *testing.T) {
+	server, err := ListenSOCKS5("127.0.0.1:0", &transport.TCPDialer{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{5, 1, 0, 5, 3, 0, 1, 127, 0, 0, 1, 0, 53})
+	require.NoError(t, err)
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 0}, reply[:2])
+	require.Equal(t, byte(socksReplyCmdNotSupported), reply[3])
+}
+
+func TestHTTP(t *testing.T) {
+	target := newTestHTTPServer(t)
+	server, err := ListenHTTP("127.0.0.1:0", &transport.TCPDialer{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	proxyURL := &url.URL{Scheme: "http", Host: server.Addr().String()}
+	httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := httpClient.Get(target.URL + "/plain")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "hello /plain", string(body))
+}
+
+func TestHTTP_Connect(t *testing.T) {
+	target := newTestHTTPServer(t)
+	server, err := ListenHTTP("127.0.0.1:0", &transport.TCPDialer{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	targetAddr := target.Listener.Addr().String()
+	_, err = io.WriteString(conn, "CONNECT "+targetAddr+" HTTP/1.1\r\nHost: "+targetAddr+"\r\n\r\n"+
+		"GET /connect HTTP/1.1\r\nHost: "+targetAddr+"\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello /connect", string(body))
+}
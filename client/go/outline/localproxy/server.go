@@ -0,0 +1,110 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package localproxy implements local SOCKS5 and HTTP proxy servers relaying traffic through a
+// [transport.StreamDialer]. It is an alternative to the system VPN for apps that can be
+// configured to use a proxy.
+package localproxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+var logger = logging.Module("localproxy")
+
+// Server accepts proxy connections on a listener and relays them through a dialer.
+type Server struct {
+	listener net.Listener
+	dialer   transport.StreamDialer
+	handle   func(*Server, net.Conn)
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newServer(listener net.Listener, dialer transport.StreamDialer, handle func(*Server, net.Conn)) *Server {
+	s := &Server{listener: listener, dialer: dialer, handle: handle, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	resources.Go(resources.SubsystemLocalProxy, s.serve)
+	return s
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, closes the active ones and waits for them to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Warn("local proxy stopped accepting connections", "addr", s.Addr(), "err", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		resources.Go(resources.SubsystemLocalProxy, func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handle(s, conn)
+		})
+	}
+}
+
+// relay copies data in both directions between the client and the remote connection, until both
+// directions are done.
+func relay(client net.Conn, remote transport.StreamConn) {
+	done := make(chan struct{})
+	resources.Go(resources.SubsystemLocalProxy, func() {
+		defer close(done)
+		copyStream(remote, client)
+		remote.CloseWrite()
+	})
+	copyStream(client, remote)
+	if cw, ok := client.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		client.Close()
+	}
+	<-done
+}
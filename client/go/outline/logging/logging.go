@@ -0,0 +1,155 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging is the logging subsystem of the Go code. It is built on [log/slog]: the
+// records go to the console, an optional log file, and a bounded in-memory buffer, so that
+// support bundles contain the recent logs.
+//
+// The package installs its handler as the default [slog] handler. Packages tag their records
+// with a module name using a logger from [Module].
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// moduleKey is the attribute key of the module name.
+const moduleKey = "module"
+
+// level is the minimum level of the records that are logged.
+var level = new(slog.LevelVar)
+
+var out = &output{console: os.Stderr}
+
+var root = newHandler(out, level)
+
+func init() {
+	slog.SetDefault(slog.New(root))
+}
+
+// SetLevel sets the minimum level of the records that are logged, to all the outputs.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the minimum level of the records that are logged.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// Module returns a logger that tags its records with the module name, e.g. "vpn".
+func Module(name string) *slog.Logger {
+	return slog.New(root).With(moduleKey, name)
+}
+
+// SetConsole sets where the records are written as text, besides the log file. Pass nil to stop
+// writing them.
+func SetConsole(w io.Writer) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.console = w
+}
+
+// SetFile appends the records, as text, to the file at path. The previous log file, if any, is
+// closed. An empty path stops writing to a file.
+func SetFile(path string) error {
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600); err != nil {
+			return err
+		}
+	}
+	out.mu.Lock()
+	prev := out.file
+	out.file = f
+	out.mu.Unlock()
+	if prev != nil {
+		return prev.Close()
+	}
+	return nil
+}
+
+// output writes the text records to the console and the log file.
+type output struct {
+	mu      sync.Mutex
+	console io.Writer
+	file    *os.File
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.console != nil {
+		o.console.Write(p)
+	}
+	if o.file != nil {
+		if _, err := o.file.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// handler is a [slog.Handler] writing the records as text to an [output], and keeping them in
+// the memory buffer.
+type handler struct {
+	text   slog.Handler
+	level  slog.Leveler
+	module string
+	group  string      // The prefix of the attribute keys, e.g. "dns.".
+	attrs  []slog.Attr // The attributes added with WithAttrs, with the group prefix.
+}
+
+func newHandler(w io.Writer, level slog.Leveler) *handler {
+	return &handler{text: slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}), level: level}
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	memory.add(h.newEntry(r))
+	return h.text.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.text = h.text.WithAttrs(attrs)
+	h2.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if a.Key == moduleKey && h.group == "" {
+			h2.module = a.Value.String()
+			continue
+		}
+		a.Key = h.group + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.text = h.text.WithGroup(name)
+	h2.group = h.group + name + "."
+	return &h2
+}
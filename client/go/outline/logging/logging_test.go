@@ -0,0 +1,102 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// resetMemory empties the memory buffer.
+func resetMemory() {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	memory.entries, memory.next = nil, 0
+}
+
+func TestModule(t *testing.T) {
+	resetMemory()
+	var buf bytes.Buffer
+	SetConsole(&buf)
+	defer SetConsole(os.Stderr)
+
+	Module("vpn").With("id", "abc").WithGroup("dns").Info("resolved", "name", "example.com")
+	slog.Debug("not logged")
+	slog.Warn("untagged")
+
+	entries := Entries(slog.LevelDebug, 0)
+	require.Len(t, entries, 2)
+	require.Equal(t, "vpn", entries[0].Module)
+	require.Equal(t, "INFO", entries[0].Level)
+	require.Equal(t, "resolved", entries[0].Message)
+	require.Equal(t, map[string]string{"id": "abc", "dns.name": "example.com"}, entries[0].Attrs)
+	require.Equal(t, "", entries[1].Module)
+	require.Equal(t, "untagged", entries[1].Message)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "module=vpn")
+	require.Contains(t, lines[0], "dns.name=example.com")
+}
+
+func TestSetLevel(t *testing.T) {
+	resetMemory()
+	SetConsole(nil)
+	defer SetConsole(os.Stderr)
+	defer SetLevel(Level())
+
+	SetLevel(slog.LevelDebug)
+	Module("test").Debug("debug")
+	Module("test").Error("error")
+	require.Len(t, Entries(slog.LevelDebug, 0), 2)
+	require.Len(t, Entries(slog.LevelWarn, 0), 1)
+	require.Equal(t, "error", Entries(slog.LevelDebug, 1)[0].Message)
+}
+
+func TestEntries_Overflow(t *testing.T) {
+	resetMemory()
+	SetConsole(nil)
+	defer SetConsole(os.Stderr)
+
+	for i := 0; i < memoryCapacity+10; i++ {
+		slog.Info("message", "i", i)
+	}
+	entries := Entries(slog.LevelDebug, 0)
+	require.Len(t, entries, memoryCapacity)
+	require.Equal(t, "10", entries[0].Attrs["i"])
+	require.Equal(t, "2009", entries[len(entries)-1].Attrs["i"])
+}
+
+func TestSetFile(t *testing.T) {
+	SetConsole(nil)
+	defer SetConsole(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "go.log")
+	require.NoError(t, SetFile(path))
+	Module("test").Info("to file")
+	require.NoError(t, SetFile(""))
+	Module("test").Info("not to file")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `msg="to file" module=test`)
+	require.NotContains(t, string(content), "not to file")
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// memoryCapacity is the number of records the memory buffer keeps.
+const memoryCapacity = 2000
+
+// Entry is a log record kept in memory, see [Entries].
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Module  string            `json:"module,omitempty"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+
+	level slog.Level
+}
+
+func (h *handler) newEntry(r slog.Record) Entry {
+	e := Entry{Time: r.Time, Level: r.Level.String(), Module: h.module, Message: r.Message, level: r.Level}
+	setAttr := func(key string, v slog.Value) {
+		if e.Attrs == nil {
+			e.Attrs = make(map[string]string)
+		}
+		e.Attrs[key] = fmt.Sprint(v.Resolve().Any())
+	}
+	for _, a := range h.attrs {
+		setAttr(a.Key, a.Value)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == moduleKey && h.group == "" {
+			e.Module = a.Value.String()
+		} else {
+			setAttr(h.group+a.Key, a.Value)
+		}
+		return true
+	})
+	return e
+}
+
+// ring is a bounded buffer of entries, dropping the oldest ones when full.
+type ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int // Where the next entry goes, once entries is full.
+}
+
+var memory = &ring{}
+
+func (b *ring) add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) < memoryCapacity {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % memoryCapacity
+}
+
+// Entries returns the most recent entries in the memory buffer, oldest first, whose level is at
+// least minLevel. It returns at most limit entries, or all of them if limit is not positive.
+func Entries(minLevel slog.Level, limit int) []Entry {
+	memory.mu.Lock()
+	all := make([]Entry, 0, len(memory.entries))
+	all = append(all, memory.entries[memory.next:]...)
+	all = append(all, memory.entries[:memory.next]...)
+	memory.mu.Unlock()
+
+	entries := make([]Entry, 0, len(all))
+	for _, e := range all {
+		if e.level < minLevel {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+var logger = logging.Module("outline")
+
+// loggingConfigJSON is the input of [MethodConfigureLogging].
+type loggingConfigJSON struct {
+	// Level is the minimum level of the logged records: "debug", "info", "warn" or "error".
+	// The level is unchanged if it is empty.
+	Level string `json:"level,omitempty"`
+
+	// File is the path of the file the logs are appended to, or "" to stop writing to a file.
+	// The log file is unchanged if it is absent.
+	File *string `json:"file,omitempty"`
+}
+
+// getLogsRequestJSON is the input of [MethodGetLogs].
+type getLogsRequestJSON struct {
+	// Level is the minimum level of the returned records. Defaults to "debug".
+	Level string `json:"level,omitempty"`
+
+	// Limit is the maximum number of returned records, the most recent ones. There is no limit if
+	// it is not positive.
+	Limit int `json:"limit,omitempty"`
+}
+
+// configureLogging parses the input as a loggingConfigJSON, and applies it.
+func configureLogging(input string) error {
+	var conf loggingConfigJSON
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "invalid logging config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if conf.Level != "" {
+		l, err := parseLogLevel(conf.Level)
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(l)
+	}
+	if conf.File != nil {
+		if err := logging.SetFile(*conf.File); err != nil {
+			return platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: "failed to open the log file",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	logger.Info("logging configured", "level", logging.Level())
+	return nil
+}
+
+// getLogs returns a JSON array of the recent [logging.Entry], oldest first. The input is an
+// optional getLogsRequestJSON.
+func getLogs(input string) (string, error) {
+	var req getLogsRequestJSON
+	if input != "" {
+		if err := json.Unmarshal([]byte(input), &req); err != nil {
+			return "", platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: "invalid logs request",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	minLevel := slog.LevelDebug
+	if req.Level != "" {
+		var err error
+		if minLevel, err = parseLogLevel(req.Level); err != nil {
+			return "", err
+		}
+	}
+	out, err := json.Marshal(logging.Entries(minLevel, req.Limit))
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal logs",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "invalid log level, must be debug, info, warn or error",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return l, nil
+}
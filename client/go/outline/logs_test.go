@@ -0,0 +1,51 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	path := filepath.Join(t.TempDir(), "go.log")
+	defer logging.SetFile("")
+
+	require.NoError(t, configureLogging(`{"level": "debug", "file": "`+path+`"}`))
+	require.Equal(t, slog.LevelDebug, logging.Level())
+	logger.Debug("debug message")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "debug message")
+
+	out, err := getLogs(`{"level": "debug", "limit": 1}`)
+	require.NoError(t, err)
+	var entries []logging.Entry
+	require.NoError(t, json.Unmarshal([]byte(out), &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, "debug message", entries[0].Message)
+	require.Equal(t, "outline", entries[0].Module)
+
+	require.Error(t, configureLogging(`{"level": "verbose"}`))
+	_, err = getLogs(`{"level": "verbose"}`)
+	require.Error(t, err)
+}
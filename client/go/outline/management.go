@@ -0,0 +1,96 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+)
+
+// The management endpoints of the providers, like their API or the servers of their dynamic keys,
+// bypass the tunnel, so that a broken tunnel doesn't prevent fetching the config that fixes it.
+// The routers bypass their host names and the addresses they resolve to, as the VPN only sees
+// addresses, and the fetches of the dynamic keys dial them with sockets protected from the VPN.
+
+// resolveManagementHosts resolves the host names of hosts in the background, so that the routers
+// bypass their addresses.
+func resolveManagementHosts(hosts []string) {
+	for _, host := range hosts {
+		if _, err := netip.ParseAddr(host); err == nil || host == "" {
+			continue
+		}
+		resources.Go(resources.SubsystemRouting, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+			defer cancel()
+			ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+			if err != nil {
+				logger.Debug("failed to resolve management host", "err", err)
+				return
+			}
+			routing.AddManagementHostIPs(host, ips)
+		})
+	}
+}
+
+// updateDynamicKeyManagementHosts makes the routers bypass the servers of the running refreshers.
+// refreshersMu must be held.
+func updateDynamicKeyManagementHosts() {
+	var hosts []string
+	for rawURL := range refreshers {
+		if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	if err := routing.SetManagementHosts(hosts); err != nil {
+		logger.Warn("failed to bypass the dynamic key servers", "err", err)
+		return
+	}
+	resolveManagementHosts(hosts)
+}
+
+// dialManagementHost dials addr with a socket that bypasses the VPN, see [newDirectDialer]. The
+// addresses of its host are recorded for the routers, and the last recorded ones are dialed if
+// the host can't be resolved, e.g. when the DNS queries go through a broken tunnel.
+func dialManagementHost(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := newDirectDialer()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err == nil {
+		routing.AddManagementHostIPs(host, ips)
+	} else if ips = routing.ManagementHostIPs(host); len(ips) == 0 {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
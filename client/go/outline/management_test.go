@@ -0,0 +1,83 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartDynamicKeyRefresh_BypassesServer(t *testing.T) {
+	router, err := routing.NewRouter(routing.Config{})
+	require.NoError(t, err)
+
+	const keyURL = "https://192.0.2.10/key"
+	require.NoError(t, startDynamicKeyRefresh(`{"url":"`+keyURL+`","transport":"{}"}`))
+	require.Equal(t, routing.ActionDirect, router.Route("192.0.2.10:443"), "the server of the key must bypass the tunnel")
+
+	require.NoError(t, stopDynamicKeyRefresh(keyURL))
+	require.Equal(t, routing.ActionProxy, router.Route("192.0.2.10:443"))
+}
+
+func TestFetchResource_Management(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "key")
+	}))
+	defer server.Close()
+	p := &fakeProtector{ok: true}
+	SetSocketProtector(p)
+	t.Cleanup(func() { SetSocketProtector(nil) })
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	content, err := fetchResourceWithOptions(context.Background(),
+		fetchRequestJSON{URL: "http://localhost:" + u.Port(), management: true})
+	require.NoError(t, err)
+	require.Equal(t, "key", content)
+	p.mu.Lock()
+	require.NotEmpty(t, p.fds, "the socket must be protected from the VPN")
+	p.mu.Unlock()
+	require.Contains(t, routing.ManagementHostIPs("localhost"), netip.MustParseAddr("127.0.0.1"))
+}
+
+func TestDialManagementHost_RecordedIPs(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	// The .invalid host names never resolve, like when the DNS goes through a broken tunnel.
+	addr := net.JoinHostPort("keys.invalid", fmt.Sprint(listener.Addr().(*net.TCPAddr).Port))
+	_, err = dialManagementHost(context.Background(), "tcp", addr)
+	require.Error(t, err)
+
+	routing.AddManagementHostIPs("keys.invalid", []netip.Addr{netip.MustParseAddr("127.0.0.1")})
+	conn, err := dialManagementHost(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	conn.Close()
+}
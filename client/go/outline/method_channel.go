@@ -15,6 +15,7 @@
 package outline
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
@@ -23,11 +24,16 @@ import (
 // API name constants
 const (
 	// FetchResource fetches a resource located at a given URL.
-	//  - Input: the URL string of the resource to fetch
+	// With the "signed" option, the resource must be a dynamic config signed by one of the keys
+	// provisioned with [MethodSetConfigSigningKeys], and its payload is returned.
+	//  - Input: the URL string of the resource to fetch, or a JSON string of fetchRequestJSON to
+	//    pin the certificate of the server or require a signature
 	//  - Output: the content in raw string of the fetched resource
 	MethodFetchResource = "FetchResource"
 
 	// EstablishVPN initiates a VPN connection and directs all network traffic through Outline.
+	// It fails with FeatureNotSupported on the platforms whose apps set up the VPN themselves, and
+	// for the app split tunneling on the platforms other than Linux.
 	//
 	//  - Input: a JSON string of vpn.configJSON.
 	//  - Output: a JSON string of vpn.connectionJSON.
@@ -39,6 +45,324 @@ const (
 	//  - Input: null
 	//  - Output: null
 	MethodCloseVPN = "CloseVPN"
+
+	// StartDynamicKeyRefresh periodically re-fetches a dynamic access key, and emits an
+	// [EventConfigChanged] when the fetched transport is different from the active one.
+	//
+	//  - Input: a JSON string of dynamicKeyRefreshJSON.
+	//  - Output: null
+	MethodStartDynamicKeyRefresh = "StartDynamicKeyRefresh"
+
+	// StopDynamicKeyRefresh stops refreshing a dynamic access key.
+	//
+	//  - Input: the URL string of the dynamic access key
+	//  - Output: null
+	MethodStopDynamicKeyRefresh = "StopDynamicKeyRefresh"
+
+	// TestConnectivity checks whether a transport can relay TCP and UDP traffic, and measures
+	// how long each check takes.
+	//
+	//  - Input: the transport config JSON string
+	//  - Output: a JSON string of connectivityTestResultJSON.
+	MethodTestConnectivity = "TestConnectivity"
+
+	// RankServers tests a list of transports concurrently, and orders them by reachability,
+	// UDP support and latency.
+	//
+	//  - Input: a JSON string of rankServersJSON.
+	//  - Output: a JSON array of rankedServerJSON, from the best server to the worst.
+	MethodRankServers = "RankServers"
+
+	// TestBandwidth downloads a URL through a transport to estimate its throughput.
+	//
+	//  - Input: a JSON string of bandwidthTestJSON.
+	//  - Output: a JSON string of bandwidthTestResultJSON.
+	MethodTestBandwidth = "TestBandwidth"
+
+	// GetStats returns the traffic statistics of the active tunnel session.
+	//
+	//  - Input: null
+	//  - Output: a JSON string of stats.Snapshot.
+	MethodGetStats = "GetStats"
+
+	// GetFlowLog returns the most recent connections relayed by the active tunnel session.
+	//
+	//  - Input: null
+	//  - Output: a JSON array of stats.Flow, from the oldest to the newest.
+	MethodGetFlowLog = "GetFlowLog"
+
+	// GetDNSCacheStats returns the statistics of the DNS cache of the active tunnel session.
+	//
+	//  - Input: null
+	//  - Output: a JSON string of stats.DNSCache.
+	MethodGetDNSCacheStats = "GetDNSCacheStats"
+
+	// StartLocalProxy starts a SOCKS5 proxy, and optionally an HTTP proxy, on localhost that relay
+	// the traffic through a transport. It is an alternative to the system VPN. Any running local
+	// proxy is stopped first.
+	//
+	//  - Input: a JSON string of localProxyJSON.
+	//  - Output: a JSON string of localProxyAddressesJSON.
+	MethodStartLocalProxy = "StartLocalProxy"
+
+	// StopLocalProxy stops the running local proxy, if any.
+	//
+	//  - Input: null
+	//  - Output: null
+	MethodStopLocalProxy = "StopLocalProxy"
+
+	// SetLANBypass enables or disables sending the traffic to private, link-local and multicast
+	// addresses directly instead of through the proxy, for all clients including the connected
+	// ones. When disabled, the "bypassLan" option of each transport config applies.
+	//
+	//  - Input: "true" or "false"
+	//  - Output: null
+	MethodSetLANBypass = "SetLANBypass"
+
+	// RedactConfig masks the secrets, passwords and user identifiers of a config, so that it can
+	// be logged or attached to bug reports. See [RedactConfig].
+	//
+	//  - Input: a JSON config, a ss:// access key or a ssconf:// dynamic access key
+	//  - Output: the redacted config
+	MethodRedactConfig = "RedactConfig"
+
+	// ExportAccessKey converts a Shadowsocks transport config into a SIP002 ss:// access key, so
+	// that it can be shared with other users.
+	//
+	//  - Input: a JSON string of exportAccessKeyJSON.
+	//  - Output: the ss:// access key.
+	MethodExportAccessKey = "ExportAccessKey"
+
+	// GetSupportedCiphers returns the Shadowsocks ciphers supported by the transport.
+	//
+	//  - Input: null
+	//  - Output: a JSON array of the cipher names, like "chacha20-ietf-poly1305".
+	MethodGetSupportedCiphers = "GetSupportedCiphers"
+
+	// DescribeTransport returns the graph of a transport: its protocols, endpoints and routers,
+	// without secrets. It describes the transport of the active VPN or local proxy if the input
+	// is null.
+	//
+	//  - Input: null, or a transport config
+	//  - Output: a JSON string of transportDescriptionJSON, or null if there is no active transport.
+	MethodDescribeTransport = "DescribeTransport"
+
+	// ReplaceTransport switches the active VPN connection to another transport without tearing
+	// down the VPN. The existing connections keep using the previous transport until they are
+	// closed. The VPN keeps the previous transport if the new server is not reachable.
+	//
+	//  - Input: the new transport config
+	//  - Output: null
+	MethodReplaceTransport = "ReplaceTransport"
+
+	// SetKillSwitch enables or disables the kill switch. While enabled, the VPN keeps capturing
+	// the traffic and drops it while the server is unreachable, and keeps trying to reconnect
+	// instead of giving up, so that the traffic never leaks outside of the VPN. Enabling it fails
+	// with ERR_FEATURE_NOT_SUPPORTED on the platforms whose VPN runs tun2socks in the app:
+	// Android, iOS and Windows.
+	//
+	//  - Input: "true" or "false"
+	//  - Output: null
+	MethodSetKillSwitch = "SetKillSwitch"
+
+	// SetOnDemandRules replaces the connect-on-demand rules, and evaluates them against the
+	// current network.
+	//
+	//  - Input: a JSON string of ondemand.Config, or "null" to disable connect-on-demand
+	//  - Output: null
+	MethodSetOnDemandRules = "SetOnDemandRules"
+
+	// NotifyNetworkChanged reports that the device switched networks, e.g. from Wi-Fi to cellular.
+	// The active VPN connection closes its TCP connections, re-dials its UDP sessions and
+	// re-checks the server right away, instead of waiting for the connections to time out.
+	// The connect-on-demand rules are evaluated, and an EventOnDemandAction is emitted unless the
+	// action is "ignore".
+	//
+	//  - Input: a JSON string of ondemand.Network, e.g. {"type": "wifi", "ssid": "Home"}
+	//  - Output: the action: "connect", "disconnect" or "ignore"
+	MethodNotifyNetworkChanged = "NotifyNetworkChanged"
+
+	// EvaluateOnDemandDomain returns the connect-on-demand action for a lookup of a domain on the
+	// current network.
+	//
+	//  - Input: the domain name
+	//  - Output: the action: "connect", "disconnect" or "ignore"
+	MethodEvaluateOnDemandDomain = "EvaluateOnDemandDomain"
+
+	// ConfigureLogging sets the minimum level of the Go logs, and the file they are appended to.
+	//
+	//  - Input: a JSON string of loggingConfigJSON, e.g. {"level": "debug", "file": "/path/go.log"}
+	//  - Output: null
+	MethodConfigureLogging = "ConfigureLogging"
+
+	// GetLogs returns the recent Go logs kept in memory, e.g. for support bundles.
+	//
+	//  - Input: an optional JSON string of getLogsRequestJSON, e.g. {"level": "warn", "limit": 100}
+	//  - Output: a JSON array of logging.Entry, oldest first
+	MethodGetLogs = "GetLogs"
+
+	// CollectDiagnostics gathers the redacted transport config and its connectivity test results,
+	// the classified failures of the relayed TCP connections, the recent logs, the network
+	// interfaces and the versions into a bundle for support tickets.
+	//
+	//  - Input: an optional JSON string of diagnosticsRequestJSON, e.g. {"transport": "...", "appVersion": "1.2.3"}
+	//  - Output: a JSON string of diagnosticsJSON
+	MethodCollectDiagnostics = "CollectDiagnostics"
+
+	// PingServer pings the server with ICMP, or times TCP connections to its port where ICMP
+	// sockets aren't allowed, to tell whether it is up.
+	//
+	//  - Input: a JSON string of serverProbeRequestJSON, e.g. {"transport": "...", "count": 4}
+	//  - Output: a JSON string of probe.PingResult
+	MethodPingServer = "PingServer"
+
+	// TracerouteServer traces the route to the server, with ICMP or TTL-limited TCP connections,
+	// to tell where the path is blocked.
+	//
+	//  - Input: a JSON string of serverProbeRequestJSON, e.g. {"host": "example.com", "port": 443}
+	//  - Output: a JSON string of probe.TracerouteResult
+	MethodTracerouteServer = "TracerouteServer"
+
+	// ParseTunnelConfigs parses many tunnel configs at once, e.g. to import a subscription list.
+	// The configs are parsed concurrently, and the ones that fail don't affect the others.
+	//
+	//  - Input: a JSON parseTunnelConfigsRequestJSON, or a JSON array of tunnel config texts, each
+	//    an ss:// access key or a JSON object.
+	//  - Output: a JSON array of parsedTunnelConfigJSON, in the same order as the input, with
+	//    the transports as canonical JSON too if requested. The TCP and UDP first hops of a
+	//    transport are listed separately, as they differ with e.g. an outbound proxy.
+	MethodParseTunnelConfigs = "ParseTunnelConfigs"
+
+	// ParseSubscription extracts the access keys of a subscription, decoding its base64,
+	// base64url or percent-encoded body if needed.
+	//
+	//  - Input: the body of the subscription, e.g. fetched with [MethodFetchResource].
+	//  - Output: a JSON array of the access keys, like "ss://...".
+	MethodParseSubscription = "ParseSubscription"
+
+	// ParseDeepLink extracts the access key of a link opened with the app, like an outline:// or
+	// invite link, or of the payload of a scanned QR code.
+	//
+	//  - Input: the link or QR code payload
+	//  - Output: a JSON string of deepLinkJSON
+	MethodParseDeepLink = "ParseDeepLink"
+
+	// ValidateConfig parses a transport config without creating a client, and reports the
+	// problems that don't prevent using it, like unknown fields.
+	//
+	//  - Input: the transport config
+	//  - Output: a JSON string of configValidationJSON
+	MethodValidateConfig = "ValidateConfig"
+
+	// SetStrictConfigParsing enables or disables the strict parsing of transport configs, which
+	// rejects the configs with unknown fields, like typos, instead of ignoring the fields.
+	//
+	//  - Input: "true" or "false"
+	//  - Output: null
+	MethodSetStrictConfigParsing = "SetStrictConfigParsing"
+
+	// SetConfigSigningKeys provisions the public keys of the providers. The dynamic configs
+	// fetched with the "signed" option must be a {"payload", "signature"} envelope, whose Ed25519
+	// signature of the raw payload bytes is made with one of them, or fail with
+	// ERR_CONFIG_SIGNATURE_INVALID.
+	//
+	//  - Input: a JSON array of base64 Ed25519 public keys, or [] to remove them
+	//  - Output: null
+	MethodSetConfigSigningKeys = "SetConfigSigningKeys"
+
+	// SetSessionStateFile sets the file the active VPN session is persisted to, e.g. in the data
+	// directory of the app, so that [MethodResumeLastSession] can restore it after the process is
+	// killed. The file contains the transport config, including its secrets.
+	//
+	//  - Input: the absolute path of the file, or "" to stop persisting and delete the file
+	//  - Output: null
+	MethodSetSessionStateFile = "SetSessionStateFile"
+
+	// ResumeLastSession re-establishes the VPN session persisted in the file set with
+	// [MethodSetSessionStateFile], with its transport, VPN options and kill switch, e.g. after the
+	// process was killed while connected. A session closed with [MethodCloseVPN] is not resumed.
+	//
+	//  - Input: null
+	//  - Output: true if a session was resumed, false if there was none
+	MethodResumeLastSession = "ResumeLastSession"
+
+	// SetStorageFile sets the file the Go layer persists its state to, like the cookies and cached
+	// resources of the fetches, without encryption. The mobile apps call [SetStorage] instead, to
+	// encrypt it with a platform key.
+	//
+	//  - Input: the absolute path of the file
+	//  - Output: null
+	MethodSetStorageFile = "SetStorageFile"
+
+	// SetGeoIPDatabases sets the MaxMind DB (MMDB) files locating the servers offline, like the
+	// GeoLite2-Country and GeoLite2-ASN databases bundled with the app, so that
+	// [MethodDescribeTransport] and [MethodRankServers] return their country and autonomous system.
+	//
+	//  - Input: a JSON array of the absolute paths of the files, or [] to disable the lookups
+	//  - Output: null
+	MethodSetGeoIPDatabases = "SetGeoIPDatabases"
+
+	// DetectCaptivePortal requests known URLs outside of the VPN, to tell whether the network
+	// intercepts them with a captive portal, like the login page of a hotel WiFi. The app can then
+	// ask the user to authenticate, instead of reporting the server as unreachable.
+	//
+	//  - Input: null, or a JSON string of captivePortalRequestJSON to replace the default probes
+	//  - Output: a JSON string of captivePortalResultJSON
+	MethodDetectCaptivePortal = "DetectCaptivePortal"
+
+	// GetNetworkEnvironment describes the network the device is on before connecting: the
+	// interface of the default route, IPv6 availability, the DNS servers, and whether DNS over
+	// UDP port 53 and TCP connections to port 443 get out. The app can use it to pick a transport
+	// and explain connection failures.
+	//
+	//  - Input: null, or a JSON string of networkEnvironmentRequestJSON
+	//  - Output: a JSON string of networkEnvironmentJSON
+	MethodGetNetworkEnvironment = "GetNetworkEnvironment"
+
+	// StartPacketCapture captures the packets of the tunnel to a file, to debug protocol issues
+	// with providers. It captures the metadata of the packets, as JSON lines, unless the full
+	// packets are explicitly requested, in the pcap format with the plaintext HTTP credentials
+	// scrubbed. The capture stops growing at its size cap.
+	//
+	//  - Input: a JSON string of packetCaptureJSON
+	//  - Output: null
+	MethodStartPacketCapture = "StartPacketCapture"
+
+	// StopPacketCapture stops the capture of [MethodStartPacketCapture] and closes its file.
+	//
+	//  - Input: null
+	//  - Output: a JSON string of pcap.Stats, or null if no capture was in progress
+	MethodStopPacketCapture = "StopPacketCapture"
+
+	// StartProfiling serves the pprof endpoints of net/http/pprof at /debug/pprof/ on a local
+	// address, to profile the transport stack on the device, e.g. with
+	// `adb forward tcp:6060 tcp:6060 && go tool pprof http://localhost:6060/debug/pprof/profile`.
+	// It is only available in the builds with the "debug" tag, and fails in the release builds.
+	//
+	//  - Input: null, or a JSON string of profilingRequestJSON
+	//  - Output: the address the endpoints listen on, like "127.0.0.1:6060" or "unix:/path"
+	MethodStartProfiling = "StartProfiling"
+
+	// StopProfiling stops the pprof endpoints of [MethodStartProfiling].
+	//
+	//  - Input: null
+	//  - Output: null
+	MethodStopProfiling = "StopProfiling"
+
+	// GetHealth returns the resources used by the process: the goroutines of each subsystem, the
+	// open files and sockets, and the heap size.
+	//
+	//  - Input: null
+	//  - Output: a JSON string of resources.Usage.
+	MethodGetHealth = "GetHealth"
+
+	// DescribeMethods returns the JSON Schemas of the input and output of every method, to
+	// generate or check the bindings of the Swift, Java and TypeScript callers. Inputs and outputs
+	// passed as they are, instead of as JSON, are strings with the "text/plain" content media type.
+	//
+	//  - Input: null
+	//  - Output: a JSON object mapping the method names to methodSchemaJSON.
+	MethodDescribeMethods = "DescribeMethods"
 )
 
 // InvokeMethodResult represents the result of an InvokeMethod call.
@@ -49,33 +373,66 @@ type InvokeMethodResult struct {
 	Error *platerrors.PlatformError
 }
 
-// InvokeMethod calls a method by name.
-func InvokeMethod(method string, input string) *InvokeMethodResult {
-	switch method {
-	case MethodFetchResource:
-		url := input
-		content, err := fetchResource(url)
-		return &InvokeMethodResult{
-			Value: content,
-			Error: platerrors.ToPlatformError(err),
-		}
+// Canceler aborts the [InvokeMethodWithCanceler] calls it is passed to, e.g. when the user
+// navigates away from a long-running connectivity check or config fetch.
+type Canceler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
 
-	case MethodEstablishVPN:
-		err := establishVPN(input)
-		return &InvokeMethodResult{
-			Error: platerrors.ToPlatformError(err),
-		}
+// NewCanceler creates a [Canceler] that is not canceled yet.
+func NewCanceler() *Canceler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Canceler{ctx: ctx, cancel: cancel}
+}
 
-	case MethodCloseVPN:
-		err := closeVPN()
-		return &InvokeMethodResult{
-			Error: platerrors.ToPlatformError(err),
+// Cancel aborts the calls using c. It can be called multiple times, and from any goroutine.
+func (c *Canceler) Cancel() {
+	c.cancel()
+}
+
+// InvokeMethod calls a method by name. Unknown methods fail with an UnknownMethod error, and inputs
+// that don't match the input type of the method fail with an InvalidMethodArguments error.
+func InvokeMethod(method string, input string) *InvokeMethodResult {
+	return invokeMethod(context.Background(), method, input)
+}
+
+// InvokeMethodWithCanceler calls a method by name like [InvokeMethod], and aborts it once
+// canceler is canceled. The aborted calls fail with an OperationCanceled error.
+//
+// The methods making network requests support it: FetchResource, TestConnectivity, RankServers,
+// TestBandwidth, PingServer, TracerouteServer and CollectDiagnostics. The others always run to
+// completion.
+func InvokeMethodWithCanceler(method string, input string, canceler *Canceler) *InvokeMethodResult {
+	ctx := context.Background()
+	if canceler != nil {
+		ctx = canceler.ctx
+	}
+	result := invokeMethod(ctx, method, input)
+	if result.Error != nil && ctx.Err() != nil {
+		result.Error = &platerrors.PlatformError{
+			Code:    platerrors.OperationCanceled,
+			Message: fmt.Sprintf("%s was canceled", method),
 		}
+	}
+	return result
+}
 
-	default:
+func invokeMethod(ctx context.Context, method string, input string) *InvokeMethodResult {
+	spec, ok := methods[method]
+	if !ok {
 		return &InvokeMethodResult{Error: &platerrors.PlatformError{
-			Code:    platerrors.InternalError,
+			Code:    platerrors.UnknownMethod,
 			Message: fmt.Sprintf("unsupported Go method: %s", method),
+			Details: platerrors.ErrorDetails{"method": method},
 		}}
 	}
+	if err := spec.checkInput(method, input); err != nil {
+		return &InvokeMethodResult{Error: platerrors.ToPlatformError(err)}
+	}
+	output, err := spec.run(ctx, input)
+	return &InvokeMethodResult{
+		Value: output,
+		Error: platerrors.ToPlatformError(err),
+	}
 }
@@ -0,0 +1,309 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ondemand"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pcap"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/probe"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// methodFunc runs a method of [InvokeMethod] with its input, and returns its output.
+type methodFunc func(ctx context.Context, input string) (string, error)
+
+// rawText is the type of the inputs and outputs passed as they are, instead of as JSON, like
+// URLs, access keys and transport configs.
+type rawText string
+
+// methodSpec describes a method of [InvokeMethod].
+type methodSpec struct {
+	run methodFunc
+
+	// input is the type the JSON input is decoded into, rawText, or nil if the method takes no
+	// input. The JSON inputs are checked against it before running the method.
+	input reflect.Type
+
+	// optionalInput allows an empty input, in which case the method uses its defaults.
+	optionalInput bool
+
+	// output is the type of the JSON output, rawText, or nil if the method has no output.
+	output reflect.Type
+}
+
+// checkInput returns an InvalidMethodArguments error if input can't be decoded into the input
+// type of the method.
+func (spec methodSpec) checkInput(method, input string) error {
+	if spec.input == nil || spec.input == rawTextType || (spec.optionalInput && input == "") {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(input), reflect.New(spec.input).Interface()); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.InvalidMethodArguments,
+			Message: fmt.Sprintf("invalid input of Go method %s", method),
+			Details: platerrors.ErrorDetails{"method": method, "inputType": spec.input.String()},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return nil
+}
+
+var rawTextType = reflect.TypeOf(rawText(""))
+
+// typeOf returns the reflect.Type of T, including interfaces and pointers.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// withoutContext adapts a method that can't be canceled.
+func withoutContext(f func(input string) (string, error)) methodFunc {
+	return func(_ context.Context, input string) (string, error) { return f(input) }
+}
+
+// withoutInput adapts a method that takes no input and can't be canceled.
+func withoutInput(f func() (string, error)) methodFunc {
+	return func(context.Context, string) (string, error) { return f() }
+}
+
+// withoutOutput adapts a method that has no output and can't be canceled.
+func withoutOutput(f func(input string) error) methodFunc {
+	return func(_ context.Context, input string) (string, error) { return "", f(input) }
+}
+
+// methods is the registry of the methods of [InvokeMethod], indexed by name. It is populated in
+// init, since [MethodDescribeMethods] describes the registry itself.
+var methods map[string]methodSpec
+
+func init() {
+	methods = map[string]methodSpec{
+		MethodFetchResource: {
+			run:   fetchVerifiedResource,
+			input: rawTextType, output: rawTextType,
+		},
+		MethodEstablishVPN: {
+			run:   withoutOutput(establishAndPersistVPN),
+			input: typeOf[vpnConfigJSON](),
+		},
+		MethodCloseVPN: {
+			run: withoutInput(func() (string, error) { return "", closeAndForgetVPN() }),
+		},
+		MethodStartDynamicKeyRefresh: {
+			run:   withoutOutput(startDynamicKeyRefresh),
+			input: typeOf[dynamicKeyRefreshJSON](),
+		},
+		MethodStopDynamicKeyRefresh: {
+			run:   withoutOutput(stopDynamicKeyRefresh),
+			input: rawTextType,
+		},
+		MethodTestConnectivity: {
+			run:   testConnectivity,
+			input: rawTextType, output: typeOf[connectivityTestResultJSON](),
+		},
+		MethodRankServers: {
+			run:   rankServers,
+			input: typeOf[rankServersJSON](), output: typeOf[[]rankedServerJSON](),
+		},
+		MethodTestBandwidth: {
+			run:   testBandwidth,
+			input: typeOf[bandwidthTestJSON](), output: typeOf[bandwidthTestResultJSON](),
+		},
+		MethodGetStats: {
+			run:    withoutInput(getStats),
+			output: typeOf[stats.Snapshot](),
+		},
+		MethodGetFlowLog: {
+			run:    withoutInput(getFlowLog),
+			output: typeOf[[]stats.Flow](),
+		},
+		MethodGetDNSCacheStats: {
+			run:    withoutInput(getDNSCacheStats),
+			output: typeOf[stats.DNSCache](),
+		},
+		MethodStartLocalProxy: {
+			run:   withoutContext(startLocalProxy),
+			input: typeOf[localProxyJSON](), output: typeOf[localProxyAddressesJSON](),
+		},
+		MethodStopLocalProxy: {
+			run: withoutInput(func() (string, error) { stopLocalProxy(); return "", nil }),
+		},
+		MethodSetLANBypass: {
+			run:   withoutOutput(setLANBypass),
+			input: typeOf[bool](),
+		},
+		MethodRedactConfig: {
+			run:   withoutContext(func(input string) (string, error) { return RedactConfig(input), nil }),
+			input: rawTextType, output: rawTextType,
+		},
+		MethodExportAccessKey: {
+			run:   withoutContext(exportAccessKey),
+			input: typeOf[exportAccessKeyJSON](), output: rawTextType,
+		},
+		MethodGetSupportedCiphers: {
+			run:    withoutInput(func() (string, error) { return getSupportedCiphers(), nil }),
+			output: typeOf[[]string](),
+		},
+		MethodDescribeTransport: {
+			run:   withoutContext(describeTransport),
+			input: rawTextType, output: typeOf[*transportDescriptionJSON](),
+		},
+		MethodReplaceTransport: {
+			run:   withoutOutput(replaceAndPersistVPNTransport),
+			input: rawTextType,
+		},
+		MethodSetKillSwitch: {
+			run:   withoutOutput(setKillSwitch),
+			input: typeOf[bool](),
+		},
+		MethodSetOnDemandRules: {
+			run:   withoutOutput(setOnDemandRules),
+			input: typeOf[*ondemand.Config](),
+		},
+		MethodNotifyNetworkChanged: {
+			run: withoutContext(func(input string) (string, error) {
+				action, err := notifyNetworkChanged(input)
+				return string(action), err
+			}),
+			input: typeOf[ondemand.Network](), output: rawTextType,
+		},
+		MethodEvaluateOnDemandDomain: {
+			run: withoutContext(func(input string) (string, error) {
+				return string(evaluateOnDemandDomain(input)), nil
+			}),
+			input: rawTextType, output: rawTextType,
+		},
+		MethodConfigureLogging: {
+			run:   withoutOutput(configureLogging),
+			input: typeOf[loggingConfigJSON](),
+		},
+		MethodGetLogs: {
+			run:   withoutContext(getLogs),
+			input: typeOf[getLogsRequestJSON](), optionalInput: true, output: typeOf[[]logging.Entry](),
+		},
+		MethodCollectDiagnostics: {
+			run:   collectDiagnostics,
+			input: typeOf[diagnosticsRequestJSON](), optionalInput: true, output: typeOf[diagnosticsJSON](),
+		},
+		MethodPingServer: {
+			run:   pingServer,
+			input: typeOf[serverProbeRequestJSON](), output: typeOf[probe.PingResult](),
+		},
+		MethodTracerouteServer: {
+			run:   tracerouteServer,
+			input: typeOf[serverProbeRequestJSON](), output: typeOf[probe.TracerouteResult](),
+		},
+		MethodParseTunnelConfigs: {
+			run:   withoutContext(parseTunnelConfigs),
+			input: typeOf[parseTunnelConfigsRequestJSON](), output: typeOf[[]parsedTunnelConfigJSON](),
+		},
+		MethodParseSubscription: {
+			run:   withoutContext(parseSubscription),
+			input: rawTextType, output: typeOf[[]string](),
+		},
+		MethodParseDeepLink: {
+			run:   withoutContext(parseDeepLink),
+			input: rawTextType, output: typeOf[deepLinkJSON](),
+		},
+		MethodValidateConfig: {
+			run:   withoutContext(validateConfigJSON),
+			input: rawTextType, output: typeOf[configValidationJSON](),
+		},
+		MethodSetStrictConfigParsing: {
+			run:   withoutOutput(setStrictConfigParsing),
+			input: typeOf[bool](),
+		},
+		MethodSetConfigSigningKeys: {
+			run:   withoutOutput(setConfigSigningKeys),
+			input: typeOf[[]string](),
+		},
+		MethodSetSessionStateFile: {
+			run:   withoutOutput(setSessionStateFile),
+			input: rawTextType,
+		},
+		MethodResumeLastSession: {
+			run: withoutInput(func() (string, error) {
+				resumed, err := resumeLastSession()
+				return strconv.FormatBool(resumed), err
+			}),
+			output: typeOf[bool](),
+		},
+		MethodSetStorageFile: {
+			run:   withoutOutput(setStorageFile),
+			input: rawTextType,
+		},
+		MethodSetGeoIPDatabases: {
+			run:   withoutOutput(setGeoIPDatabases),
+			input: typeOf[[]string](),
+		},
+		MethodDetectCaptivePortal: {
+			run:   detectCaptivePortal,
+			input: typeOf[captivePortalRequestJSON](), optionalInput: true, output: typeOf[captivePortalResultJSON](),
+		},
+		MethodGetNetworkEnvironment: {
+			run:   getNetworkEnvironment,
+			input: typeOf[networkEnvironmentRequestJSON](), optionalInput: true, output: typeOf[networkEnvironmentJSON](),
+		},
+		MethodStartPacketCapture: {
+			run:   withoutOutput(startPacketCapture),
+			input: typeOf[packetCaptureJSON](),
+		},
+		MethodStopPacketCapture: {
+			run:    withoutInput(stopPacketCapture),
+			output: typeOf[*pcap.Stats](),
+		},
+		MethodStartProfiling: {
+			run:   withoutContext(startProfiling),
+			input: typeOf[profilingRequestJSON](), optionalInput: true, output: rawTextType,
+		},
+		MethodStopProfiling: {
+			run: withoutInput(func() (string, error) { return "", stopProfiling() }),
+		},
+		MethodGetHealth: {
+			run:    withoutInput(getHealth),
+			output: typeOf[resources.Usage](),
+		},
+		MethodDescribeMethods: {
+			run:    withoutInput(describeMethods),
+			output: typeOf[map[string]methodSchemaJSON](),
+		},
+	}
+}
+
+// fetchVerifiedResource fetches the resource of the request in input, see [MethodFetchResource].
+func fetchVerifiedResource(ctx context.Context, input string) (string, error) {
+	req, err := parseFetchRequest(input)
+	if err != nil {
+		return "", err
+	}
+	content, err := fetchResourceWithOptions(ctx, req)
+	if req.CacheFallback {
+		content, err = withFetchCache(req.URL, content, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !req.Signed {
+		return content, nil
+	}
+	return verifySignedConfig(content)
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+// methodConstants returns the values of the Method* constants of method_channel.go.
+func methodConstants(t *testing.T) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "method_channel.go", nil, 0)
+	require.NoError(t, err)
+	var names []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || !strings.HasPrefix(spec.Names[0].Name, "Method") {
+			return true
+		}
+		name, err := strconv.Unquote(spec.Values[0].(*ast.BasicLit).Value)
+		require.NoError(t, err)
+		names = append(names, name)
+		return true
+	})
+	return names
+}
+
+func TestMethods_AllRegistered(t *testing.T) {
+	names := methodConstants(t)
+	require.Len(t, methods, len(names))
+	for _, name := range names {
+		require.Contains(t, methods, name)
+		require.NotNil(t, methods[name].run, name)
+	}
+}
+
+func TestInvokeMethod_UnknownMethod(t *testing.T) {
+	result := InvokeMethod("NoSuchMethod", "")
+	require.NotNil(t, result.Error)
+	require.Equal(t, platerrors.UnknownMethod, result.Error.Code)
+	require.Equal(t, "NoSuchMethod", result.Error.Details["method"])
+}
+
+func TestInvokeMethod_InvalidArguments(t *testing.T) {
+	tests := []struct {
+		method string
+		input  string
+	}{
+		{MethodSetKillSwitch, `"yes"`},
+		{MethodRankServers, `[]`},
+		{MethodParseTunnelConfigs, `{"configs": "ss://"}`},
+		{MethodGetLogs, `{"limit": "all"}`},
+	}
+	for _, tc := range tests {
+		result := InvokeMethod(tc.method, tc.input)
+		require.NotNil(t, result.Error, tc.method)
+		require.Equal(t, platerrors.InvalidMethodArguments, result.Error.Code, tc.method)
+		require.Equal(t, tc.method, result.Error.Details["method"])
+	}
+}
+
+func TestInvokeMethod_OptionalInput(t *testing.T) {
+	result := InvokeMethod(MethodGetLogs, "")
+	require.Nil(t, result.Error)
+	var logs []json.RawMessage
+	require.NoError(t, json.Unmarshal([]byte(result.Value), &logs))
+}
+
+func TestDescribeMethods(t *testing.T) {
+	result := InvokeMethod(MethodDescribeMethods, "")
+	require.Nil(t, result.Error)
+	var schemas map[string]struct {
+		Input  map[string]any `json:"input"`
+		Output map[string]any `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(result.Value), &schemas))
+	require.Len(t, schemas, len(methods))
+
+	require.Equal(t, map[string]any{"type": "null"}, schemas[MethodCloseVPN].Input)
+	require.Equal(t, map[string]any{"type": "string", "contentMediaType": "text/plain"}, schemas[MethodFetchResource].Output)
+	require.Equal(t, map[string]any{"type": "boolean"}, schemas[MethodSetKillSwitch].Input)
+	require.Equal(t, "array", schemas[MethodRankServers].Output["type"])
+}
+
+func TestSchemaOf(t *testing.T) {
+	type embedded struct {
+		Shared string `json:"shared"`
+	}
+	type node struct {
+		embedded
+		Name     string            `json:"name"`
+		Count    int               `json:"count,omitempty"`
+		Big      int64             `json:"big,string"`
+		Data     []byte            `json:"data,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Children []*node           `json:"children,omitempty"`
+		Any      any               `json:"any,omitempty"`
+		Ignored  string            `json:"-"`
+		private  string
+	}
+	require.Equal(t, jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"shared": jsonSchema{"type": "string"},
+			"name":   jsonSchema{"type": "string"},
+			"count":  jsonSchema{"type": "integer"},
+			"big":    jsonSchema{"type": "string"},
+			"data":   jsonSchema{"type": "string", "contentEncoding": "base64"},
+			"labels": jsonSchema{"type": "object", "additionalProperties": jsonSchema{"type": "string"}},
+			"children": jsonSchema{"type": "array", "items": jsonSchema{
+				"anyOf": []jsonSchema{{"type": "object"}, {"type": "null"}},
+			}},
+			"any": jsonSchema{},
+		},
+		"required": []string{"shared", "name", "big"},
+	}, schemaOf(typeOf[node]()))
+}
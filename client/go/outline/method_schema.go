@@ -0,0 +1,164 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// methodSchemaJSON describes the input and output of a method, see [MethodDescribeMethods].
+type methodSchemaJSON struct {
+	Input  jsonSchema `json:"input"`
+	Output jsonSchema `json:"output"`
+}
+
+// jsonSchema is a JSON Schema (draft 2020-12) document.
+type jsonSchema map[string]any
+
+// describeMethods returns a JSON object mapping the method names to their methodSchemaJSON.
+func describeMethods() (string, error) {
+	schemas := make(map[string]methodSchemaJSON, len(methods))
+	for name, spec := range methods {
+		input := schemaOf(spec.input)
+		if spec.optionalInput {
+			input = jsonSchema{"anyOf": []jsonSchema{input, schemaOf(rawTextType)}, "description": "empty for the defaults"}
+		}
+		schemas[name] = methodSchemaJSON{Input: input, Output: schemaOf(spec.output)}
+	}
+	out, err := json.Marshal(schemas)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal method schemas",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = typeOf[json.Marshaler]()
+	textMarshalerType = typeOf[encoding.TextMarshaler]()
+)
+
+// schemaOf returns the JSON Schema of the JSON encoding of the values of type t. A nil type is
+// null, and rawText is a string passed as it is, instead of as JSON.
+func schemaOf(t reflect.Type) jsonSchema {
+	return (&schemaBuilder{visiting: make(map[reflect.Type]bool)}).build(t)
+}
+
+type schemaBuilder struct {
+	// visiting holds the types being built, to stop at the recursive types.
+	visiting map[reflect.Type]bool
+}
+
+func (b *schemaBuilder) build(t reflect.Type) jsonSchema {
+	switch {
+	case t == nil:
+		return jsonSchema{"type": "null"}
+	case t == rawTextType:
+		return jsonSchema{"type": "string", "contentMediaType": "text/plain"}
+	case t == timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return jsonSchema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// The encoding is up to the type.
+		return jsonSchema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return jsonSchema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema{"anyOf": []jsonSchema{b.build(t.Elem()), {"type": "null"}}}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}
+		}
+		return jsonSchema{"type": "array", "items": b.build(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": b.build(t.Elem())}
+	case reflect.Struct:
+		if b.visiting[t] {
+			return jsonSchema{"type": "object"}
+		}
+		b.visiting[t] = true
+		defer delete(b.visiting, t)
+		properties, required := jsonSchema{}, []string{}
+		b.addFields(t, properties, &required)
+		schema := jsonSchema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// Interfaces can hold any value.
+		return jsonSchema{}
+	}
+}
+
+// addFields adds the JSON fields of the struct type t to properties, and the names of the ones
+// that are always encoded, without omitempty, to required.
+func (b *schemaBuilder) addFields(t reflect.Type, properties jsonSchema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(embedded, properties, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		schema := b.build(f.Type)
+		if strings.Contains(","+opts+",", ",string,") {
+			schema = jsonSchema{"type": "string"}
+		}
+		properties[name] = schema
+		if !strings.Contains(","+opts+",", ",omitempty,") && !strings.Contains(","+opts+",", ",omitzero,") {
+			*required = append(*required, name)
+		}
+	}
+}
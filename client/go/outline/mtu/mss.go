@@ -0,0 +1,124 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtu
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+)
+
+const (
+	ipv4HeaderMinLen = 20
+	ipv6HeaderLen    = 40
+	tcpHeaderMinLen  = 20
+	protocolTCP      = 6
+
+	tcpFlagSYN   = 0x02
+	tcpOptionEnd = 0
+	tcpOptionNOP = 1
+	tcpOptionMSS = 2
+)
+
+// MSS returns the largest TCP segment fitting in an IP packet of mtu bytes.
+func MSS(mtu int, ipv6 bool) int {
+	if ipv6 {
+		return mtu - ipv6HeaderLen - tcpHeaderMinLen
+	}
+	return mtu - ipv4HeaderMinLen - tcpHeaderMinLen
+}
+
+type mssClampingWriter struct {
+	w   io.Writer
+	mtu int
+}
+
+// NewMSSClampingWriter creates a writer of IP packets to w, which lowers the MSS option of TCP SYN
+// packets so that the segments of the connection fit in packets of mtu bytes.
+func NewMSSClampingWriter(w io.Writer, mtu int) io.Writer {
+	return &mssClampingWriter{w: w, mtu: mtu}
+}
+
+func (w *mssClampingWriter) Write(pkt []byte) (int, error) {
+	return w.w.Write(ClampMSS(pkt, w.mtu))
+}
+
+// ClampMSS returns pkt, or a copy of it with a lower MSS if it is a TCP SYN packet with an MSS
+// option exceeding the MSS of mtu.
+func ClampMSS(pkt []byte, mtu int) []byte {
+	tcp, ipv6 := tcpSegment(pkt)
+	if len(tcp) < tcpHeaderMinLen || tcp[13]&tcpFlagSYN == 0 {
+		return pkt
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < tcpHeaderMinLen || dataOffset > len(tcp) {
+		return pkt
+	}
+	maxMSS := uint16(MSS(mtu, ipv6))
+	options := tcp[tcpHeaderMinLen:dataOffset]
+	for i := 0; i < len(options); {
+		switch kind := options[i]; {
+		case kind == tcpOptionEnd:
+			return pkt
+		case kind == tcpOptionNOP:
+			i++
+			continue
+		case i+1 >= len(options) || options[i+1] < 2:
+			return pkt
+		case kind == tcpOptionMSS && options[i+1] == 4 && i+4 <= len(options):
+			mss := binary.BigEndian.Uint16(options[i+2:])
+			if mss <= maxMSS {
+				return pkt
+			}
+			clamped := append([]byte(nil), pkt...)
+			tcpStart := len(pkt) - len(tcp)
+			binary.BigEndian.PutUint16(clamped[tcpStart+tcpHeaderMinLen+i+2:], maxMSS)
+			wire.UpdateChecksum(clamped[tcpStart+16:], mss, maxMSS)
+			return clamped
+		}
+		i += int(options[i+1])
+	}
+	return pkt
+}
+
+// tcpSegment returns the TCP segment of pkt, or nil if it isn't an unfragmented TCP packet.
+func tcpSegment(pkt []byte) (tcp []byte, ipv6 bool) {
+	if len(pkt) == 0 {
+		return nil, false
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < ipv4HeaderMinLen || pkt[9] != protocolTCP {
+			return nil, false
+		}
+		// Only the first fragment has the TCP header, and it can't be changed without reassembly.
+		if binary.BigEndian.Uint16(pkt[6:])&0x3FFF != 0 {
+			return nil, false
+		}
+		headerLen := int(pkt[0]&0x0F) * 4
+		if headerLen < ipv4HeaderMinLen || headerLen > len(pkt) {
+			return nil, false
+		}
+		return pkt[headerLen:], false
+	case 6:
+		// TCP segments behind extension headers are left alone.
+		if len(pkt) < ipv6HeaderLen || pkt[6] != protocolTCP {
+			return nil, false
+		}
+		return pkt[ipv6HeaderLen:], true
+	}
+	return nil, false
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// newSYN creates an IPv4 or IPv6 TCP SYN packet with the options and a valid checksum.
+func newSYN(ipv6 bool, options []byte) []byte {
+	tcp := make([]byte, tcpHeaderMinLen+len(options))
+	binary.BigEndian.PutUint16(tcp[0:], 50000)
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	tcp[12] = byte(len(tcp)/4) << 4
+	tcp[13] = tcpFlagSYN
+	copy(tcp[tcpHeaderMinLen:], options)
+
+	var ip []byte
+	if ipv6 {
+		ip = make([]byte, ipv6HeaderLen)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = protocolTCP
+		ip[23], ip[39] = 1, 2
+	} else {
+		ip = make([]byte, ipv4HeaderMinLen)
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(tcp)))
+		ip[9] = protocolTCP
+		copy(ip[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	}
+	pkt := append(ip, tcp...)
+	binary.BigEndian.PutUint16(pkt[len(ip)+16:], ^tcpChecksum(pkt))
+	return pkt
+}
+
+// tcpChecksum returns the one's complement sum of the TCP segment of pkt and its pseudo-header.
+// It is 0xFFFF if the checksum of the segment is valid.
+func tcpChecksum(pkt []byte) uint16 {
+	tcp, ipv6 := tcpSegment(pkt)
+	var sum uint32
+	if ipv6 {
+		sum = wire.IPv6PseudoHeaderSum(pkt, len(tcp), protocolTCP)
+	} else {
+		sum = uint32(wire.Checksum(0, pkt[12:20])) + protocolTCP + uint32(len(tcp))
+	}
+	return wire.Checksum(sum, tcp)
+}
+
+func TestClampMSS(t *testing.T) {
+	mssOption := func(mss uint16) []byte {
+		return binary.BigEndian.AppendUint16([]byte{tcpOptionMSS, 4}, mss)
+	}
+	tests := []struct {
+		name    string
+		ipv6    bool
+		options []byte
+		wantMSS uint16
+	}{
+		{name: "IPv4", options: mssOption(1460), wantMSS: 1360},
+		{name: "IPv6", ipv6: true, options: mssOption(1440), wantMSS: 1340},
+		{name: "after other options", options: append([]byte{tcpOptionNOP, tcpOptionNOP, 4, 2}, mssOption(1460)...), wantMSS: 1360},
+		{name: "small MSS", options: mssOption(1200), wantMSS: 1200},
+		{name: "no MSS", options: []byte{tcpOptionNOP, tcpOptionNOP, 4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := newSYN(tt.ipv6, tt.options)
+			orig := bytes.Clone(pkt)
+			clamped := ClampMSS(pkt, 1400)
+			require.Equal(t, orig, pkt, "the input packet must not be modified")
+			require.Equal(t, uint16(0xFFFF), tcpChecksum(clamped))
+			if tt.wantMSS == 0 {
+				require.Equal(t, pkt, clamped)
+				return
+			}
+			i := bytes.Index(clamped[len(clamped)-len(tt.options):], []byte{tcpOptionMSS, 4})
+			require.GreaterOrEqual(t, i, 0)
+			require.Equal(t, tt.wantMSS, binary.BigEndian.Uint16(clamped[len(clamped)-len(tt.options)+i+2:]))
+		})
+	}
+}
+
+func TestClampMSS_NotSYN(t *testing.T) {
+	pkt := newSYN(false, []byte{tcpOptionMSS, 4, 0x05, 0xB4})
+	pkt[ipv4HeaderMinLen+13] = 0x10 // ACK
+	require.Equal(t, pkt, ClampMSS(pkt, 1400))
+	require.Equal(t, []byte{}, ClampMSS([]byte{}, 1400))
+	require.Equal(t, []byte{0x45, 0}, ClampMSS([]byte{0x45, 0}, 1400))
+}
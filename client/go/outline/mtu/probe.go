@@ -0,0 +1,158 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mtu discovers the largest packets the tunnel can carry without loss, and keeps the TCP
+// segments of the tunneled connections within that size.
+//
+// Shadowsocks adds its own overhead to every UDP datagram, so packets that fit the TUN device may
+// not fit the path to the proxy server once encapsulated. Some carriers silently drop the
+// fragments of such datagrams.
+package mtu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// MinMTU is the smallest MTU probed. It is the minimum MTU of IPv6 links.
+	MinMTU = 1280
+
+	// MaxMTU is the largest MTU probed, the MTU of Ethernet links.
+	MaxMTU = 1500
+
+	// DefaultProbeResolver is the DNS resolver answering the probes.
+	DefaultProbeResolver = "1.1.1.1:53"
+
+	// udpOverhead is the size of the IPv4 and UDP headers of a datagram.
+	udpOverhead = 20 + 8
+
+	probeAttempts = 2
+)
+
+// probeTimeout is how long a probe waits for its response. Tests shorten it.
+var probeTimeout = 500 * time.Millisecond
+
+// Probe returns the largest MTU, between [MinMTU] and [MaxMTU], of IPv4 UDP packets sent through
+// pl that reach the DNS resolver at resolverAddr and get a response.
+//
+// Each probe is a DNS query padded (RFC 7830) to the size under test. The search is a binary
+// search, so it sends about 8 probes.
+func Probe(ctx context.Context, pl transport.PacketListener, resolverAddr string) (int, error) {
+	raddr, err := net.ResolveUDPAddr("udp", resolverAddr)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := pl.ListenPacket(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	p := &prober{conn: conn, raddr: raddr}
+	if err := p.probe(ctx, MinMTU); err != nil {
+		return 0, fmt.Errorf("the minimum MTU %d doesn't go through: %w", MinMTU, err)
+	}
+	// Invariant: lo goes through, hi+1 doesn't (or is out of range).
+	lo, hi := MinMTU, MaxMTU
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if err := p.probe(ctx, mid); err == nil {
+			lo = mid
+		} else if ctx.Err() != nil {
+			return 0, ctx.Err()
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+type prober struct {
+	conn  net.PacketConn
+	raddr net.Addr
+	id    uint16
+}
+
+// probe sends a query making a packet of mtu bytes, and waits for its response.
+func (p *prober) probe(ctx context.Context, mtu int) error {
+	buf := make([]byte, MaxMTU)
+	var err error
+	for attempt := 0; attempt < probeAttempts && ctx.Err() == nil; attempt++ {
+		p.id++
+		var query []byte
+		if query, err = newPaddedQuery(p.id, mtu-udpOverhead); err != nil {
+			return err
+		}
+		if _, err = p.conn.WriteTo(query, p.raddr); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(probeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		p.conn.SetReadDeadline(deadline)
+		for {
+			var n int
+			if n, _, err = p.conn.ReadFrom(buf); err != nil {
+				break
+			}
+			// Ignore the late responses to previous probes.
+			if n >= 2 && binary.BigEndian.Uint16(buf) == p.id {
+				return nil
+			}
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+	}
+	return err
+}
+
+// newPaddedQuery creates a DNS query of exactly size bytes.
+func newPaddedQuery(id uint16, size int) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName("."),
+			Type:  dnsmessage.TypeNS,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	opt := dnsmessage.Resource{Body: &dnsmessage.OPTResource{}}
+	if err := opt.Header.SetEDNS0(MaxMTU, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	msg.Additionals = []dnsmessage.Resource{opt}
+	unpadded, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	// The padding option has a 4-byte header.
+	padding := size - len(unpadded) - 4
+	if padding < 0 {
+		return nil, fmt.Errorf("query size %d is too small", size)
+	}
+	opt.Body = &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: 12, Data: make([]byte, padding)}}}
+	msg.Additionals[0] = opt
+	return msg.Pack()
+}
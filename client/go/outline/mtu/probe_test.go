@@ -0,0 +1,70 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a UDP server echoing the datagrams of at most maxSize bytes.
+func startServer(t *testing.T, maxSize int) net.Addr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2*MaxMTU)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n <= maxSize {
+				conn.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	return conn.LocalAddr()
+}
+
+func TestProbe(t *testing.T) {
+	defer func(timeout time.Duration) { probeTimeout = timeout }(probeTimeout)
+	probeTimeout = 50 * time.Millisecond
+
+	pl := &transport.UDPListener{Address: "127.0.0.1:0"}
+	for _, want := range []int{MinMTU, 1400, 1452, MaxMTU} {
+		addr := startServer(t, want-udpOverhead)
+		got, err := Probe(context.Background(), pl, addr.String())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	addr := startServer(t, MinMTU-udpOverhead-1)
+	_, err := Probe(context.Background(), pl, addr.String())
+	require.Error(t, err)
+}
+
+func TestNewPaddedQuery(t *testing.T) {
+	for _, size := range []int{MinMTU - udpOverhead, MaxMTU - udpOverhead} {
+		query, err := newPaddedQuery(1, size)
+		require.NoError(t, err)
+		require.Len(t, query, size)
+	}
+}
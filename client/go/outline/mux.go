@@ -0,0 +1,95 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/mux"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// transportTypeMux is the transport multiplexing the streams of another transport.
+const transportTypeMux = "mux"
+
+// muxProtocolYAMux is the only multiplexing protocol supported.
+const muxProtocolYAMux = "yamux"
+
+// muxTransportConfigJSON is the config of the "mux" transport.
+type muxTransportConfigJSON struct {
+	// Transport is the config of the transport carrying the multiplexed connections, of any
+	// transport type. Its server must support the multiplexing of sing-box.
+	Transport json.RawMessage `json:"transport"`
+
+	// Protocol is the multiplexing protocol. Only "yamux", the default, is supported.
+	Protocol string `json:"protocol,omitempty"`
+
+	// MaxConnections is the number of connections the streams are spread over. Defaults to 4.
+	MaxConnections int `json:"maxConnections,omitempty"`
+
+	// MaxStreams is the number of streams of a connection before another connection is opened.
+	// Defaults to 8.
+	MaxStreams int `json:"maxStreams,omitempty"`
+
+	// Padding pads the first frames of the connections with random bytes.
+	Padding bool `json:"padding,omitempty"`
+}
+
+func init() {
+	transportRegistry[transportTypeMux] = parseMuxTransport
+	firstHopsRegistry[transportTypeMux] = func(config json.RawMessage) (firstHops, error) {
+		var conf muxTransportConfigJSON
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return firstHops{}, err
+		}
+		return nestedFirstHops(conf.Transport)
+	}
+}
+
+// parseMuxTransport is the [TransportParser] of the "mux" transport. Only the streams are
+// multiplexed: the packets go through the packet listener of the nested transport.
+func parseMuxTransport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf muxTransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("mux transport config is not a valid JSON", string(config), err)
+	}
+	if len(conf.Transport) == 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("mux transport has no transport",
+			"transport", nil, "a transport config", nil)
+	}
+	if conf.Protocol != "" && conf.Protocol != muxProtocolYAMux {
+		return nil, nil, newIllegalConfigErrorWithDetails("multiplexing protocol is not supported",
+			"protocol", conf.Protocol, fmt.Sprintf("%q", muxProtocolYAMux), nil)
+	}
+	if conf.MaxConnections < 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("max connections is not valid",
+			"maxConnections", conf.MaxConnections, "a positive number", nil)
+	}
+	if conf.MaxStreams < 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("max streams is not valid",
+			"maxStreams", conf.MaxStreams, "a positive number", nil)
+	}
+	sd, pl, err := parseTransportConfig(conf.Transport, dialers)
+	if err != nil {
+		return nil, nil, err
+	}
+	sd = mux.NewStreamDialer(sd, mux.Options{
+		MaxConnections: conf.MaxConnections,
+		MaxStreams:     conf.MaxStreams,
+		Padding:        conf.Padding,
+	})
+	return sd, pl, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux multiplexes the streams to the proxy over fewer connections, saving a handshake per
+// stream and hiding the number of connections. It implements the client of the multiplexing
+// protocol of sing-box (sing-mux) with yamux, which servers like sing-box support.
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// MagicAddress is the destination requesting a multiplexed connection from the server.
+const MagicAddress = "sp.mux.sing-box.arpa:444"
+
+// Versions and protocols of the connection request.
+const (
+	version0 = 0
+	// version1 adds the padding.
+	version1 = 1
+
+	protocolYAMux = 2
+)
+
+// Statuses of the stream responses.
+const (
+	statusSuccess = 0
+	statusError   = 1
+)
+
+// Address types of the stream requests, as in SOCKS5.
+const (
+	addrTypeIPv4 = 0x01
+	addrTypeFQDN = 0x03
+	addrTypeIPv6 = 0x04
+)
+
+var addrFormat = wire.AddrFormat{IPv4: addrTypeIPv4, IPv6: addrTypeIPv6, Domain: addrTypeFQDN}
+
+const (
+	// DefaultMaxConnections is the default of [Options.MaxConnections].
+	DefaultMaxConnections = 4
+	// DefaultMaxStreams is the default of [Options.MaxStreams].
+	DefaultMaxStreams = 8
+)
+
+// Options configure the multiplexing of a [transport.StreamDialer].
+type Options struct {
+	// MaxConnections is the number of connections to the server the streams are spread over.
+	// Defaults to [DefaultMaxConnections].
+	MaxConnections int
+
+	// MaxStreams is the number of streams of a connection before another connection is opened.
+	// The connections take more streams once there are MaxConnections. Defaults to
+	// [DefaultMaxStreams].
+	MaxStreams int
+
+	// Padding pads the first frames of the connections with random bytes, so that the sizes of the
+	// handshakes of their first streams don't identify the traffic.
+	Padding bool
+}
+
+type streamDialer struct {
+	sd   transport.StreamDialer
+	opts Options
+
+	mu       sync.Mutex
+	sessions []*session
+	// dialing is the number of sessions being dialed, which count toward MaxConnections.
+	dialing int
+	// dialed, if not nil, is closed when a session is dialed, to wake up the streams waiting
+	// for a connection.
+	dialed chan struct{}
+}
+
+var _ transport.StreamDialer = (*streamDialer)(nil)
+
+// NewStreamDialer creates a [transport.StreamDialer] opening its streams over the multiplexed
+// connections to the server, dialed with sd.
+func NewStreamDialer(sd transport.StreamDialer, opts Options) transport.StreamDialer {
+	if opts.MaxConnections <= 0 {
+		opts.MaxConnections = DefaultMaxConnections
+	}
+	if opts.MaxStreams <= 0 {
+		opts.MaxStreams = DefaultMaxStreams
+	}
+	return &streamDialer{sd: sd, opts: opts}
+}
+
+func (d *streamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	// The request: a TCP stream, and its destination.
+	req, err := addrFormat.AppendHostPort([]byte{0, 0}, addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := d.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := st.Write(req); err != nil {
+		st.Close()
+		return nil, err
+	}
+	return &streamConn{stream: st}, nil
+}
+
+// session returns the connection to open a stream on: the one with the fewest streams, unless
+// they all have MaxStreams and another one can be opened. The connections are dialed without
+// holding the lock, so that the other streams don't wait for them, unless there's no connection
+// to open them on yet.
+func (d *streamDialer) session(ctx context.Context) (*session, error) {
+	for {
+		s, dialed, err := d.pickSession(ctx)
+		if dialed == nil {
+			return s, err
+		}
+		select {
+		case <-dialed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pickSession returns the connection to open a stream on, or a channel closed when the
+// connection being dialed is ready, if no connection is usable yet.
+func (d *streamDialer) pickSession(ctx context.Context) (*session, <-chan struct{}, error) {
+	d.mu.Lock()
+	var best *session
+	bestStreams := 0
+	usable := d.sessions[:0]
+	for _, s := range d.sessions {
+		if !s.usable() {
+			// The streams still open on the connection keep it until they're closed.
+			s.closeWhenDrained()
+			continue
+		}
+		usable = append(usable, s)
+		if n := s.numStreams(); best == nil || n < bestStreams {
+			best, bestStreams = s, n
+		}
+	}
+	clear(d.sessions[len(usable):])
+	d.sessions = usable
+
+	if best != nil && (bestStreams < d.opts.MaxStreams || len(d.sessions)+d.dialing >= d.opts.MaxConnections) {
+		d.mu.Unlock()
+		return best, nil, nil
+	}
+	if best == nil && d.dialing > 0 {
+		if d.dialed == nil {
+			d.dialed = make(chan struct{})
+		}
+		dialed := d.dialed
+		d.mu.Unlock()
+		return nil, dialed, nil
+	}
+	d.dialing++
+	d.mu.Unlock()
+
+	s, err := d.dialSession(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialing--
+	if d.dialed != nil {
+		close(d.dialed)
+		d.dialed = nil
+	}
+	if err != nil {
+		if best != nil {
+			return best, nil, nil
+		}
+		return nil, nil, err
+	}
+	d.sessions = append(d.sessions, s)
+	return s, nil, nil
+}
+
+// dialSession dials a new multiplexed connection.
+func (d *streamDialer) dialSession(ctx context.Context) (*session, error) {
+	conn, err := d.sd.DialStream(ctx, MagicAddress)
+	if err != nil {
+		return nil, err
+	}
+	req := []byte{version0, protocolYAMux}
+	if d.opts.Padding {
+		paddingLen := newPaddingLen()
+		req = []byte{version1, protocolYAMux, 1}
+		req = binary.BigEndian.AppendUint16(req, uint16(paddingLen))
+		req = append(req, make([]byte, paddingLen)...)
+	}
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if d.opts.Padding {
+		return newSession(newPaddingConn(conn), true), nil
+	}
+	return newSession(conn, true), nil
+}
+
+// streamConn is a multiplexed stream, which reads the response of the server to its request
+// before its data.
+type streamConn struct {
+	*stream
+
+	responseOnce sync.Once
+	responseErr  error
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	c.responseOnce.Do(func() {
+		c.responseErr = c.readResponse()
+	})
+	if c.responseErr != nil {
+		return 0, c.responseErr
+	}
+	return c.stream.Read(b)
+}
+
+func (c *streamConn) readResponse() error {
+	r := byteReader{c.stream}
+	status, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case statusSuccess:
+		return nil
+	case statusError:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(c.stream, msg); err != nil {
+			return err
+		}
+		return fmt.Errorf("mux server failed to connect: %s", msg)
+	default:
+		return fmt.Errorf("unknown mux stream status %d", status)
+	}
+}
+
+// byteReader reads a reader byte by byte, so that reading the response doesn't read past it.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
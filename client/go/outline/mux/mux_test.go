@@ -0,0 +1,266 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/transporttest"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a sing-mux server echoing the data of the streams back, or failing the streams
+// to the "blocked.example" host.
+type testServer struct {
+	t     *testing.T
+	conns atomic.Int32
+
+	mu           sync.Mutex
+	destinations []string
+}
+
+// dialer returns a [transport.StreamDialer] connecting to the server over in-memory pipes.
+func (srv *testServer) dialer() transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		require.Equal(srv.t, MagicAddress, addr)
+		client, server := net.Pipe()
+		srv.conns.Add(1)
+		go srv.serve(server)
+		return &pipeConn{client}, nil
+	})
+}
+
+func (srv *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var req [2]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return
+	}
+	require.Equal(srv.t, byte(protocolYAMux), req[1])
+	if req[0] == version1 {
+		var padding [3]byte
+		_, err := io.ReadFull(conn, padding[:])
+		require.NoError(srv.t, err)
+		require.Equal(srv.t, byte(1), padding[0])
+		_, err = io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint16(padding[1:])))
+		require.NoError(srv.t, err)
+		conn = newPaddingConn(conn)
+	}
+	s := newSession(conn, false)
+	defer s.Close()
+	for {
+		st, err := s.acceptStream()
+		if err != nil {
+			return
+		}
+		go srv.serveStream(st)
+	}
+}
+
+func (srv *testServer) serveStream(st *stream) {
+	defer st.Close()
+	var flags [2]byte
+	_, err := io.ReadFull(st, flags[:])
+	require.NoError(srv.t, err)
+	require.Equal(srv.t, [2]byte{}, flags)
+	destAddr, err := addrFormat.Read(st)
+	require.NoError(srv.t, err)
+	dest := destAddr.String()
+	srv.mu.Lock()
+	srv.destinations = append(srv.destinations, dest)
+	srv.mu.Unlock()
+
+	if host, _, _ := net.SplitHostPort(dest); host == "blocked.example" {
+		msg := "connection refused"
+		resp := binary.AppendUvarint([]byte{statusError}, uint64(len(msg)))
+		st.Write(append(resp, msg...))
+		return
+	}
+	// The client may close the connection first, e.g. in TestStreamDialer_SessionClosed.
+	if _, err := st.Write([]byte{statusSuccess}); err != nil {
+		return
+	}
+	io.Copy(st, st)
+}
+
+// pipeConn is a [transport.StreamConn] over a [net.Pipe] end, which can't be half-closed.
+type pipeConn struct {
+	net.Conn
+}
+
+func (c *pipeConn) CloseRead() error  { return nil }
+func (c *pipeConn) CloseWrite() error { return nil }
+
+func TestStreamDialer(t *testing.T) {
+	for _, padding := range []bool{false, true} {
+		srv := &testServer{t: t}
+		sd := NewStreamDialer(srv.dialer(), Options{Padding: padding})
+
+		// More than the receive window, to exercise the window updates.
+		data := bytes.Repeat([]byte("0123456789"), initialWindow/5)
+		var wg sync.WaitGroup
+		for _, addr := range []string{"example.com:443", "192.0.2.1:80", "[2001:db8::1]:53"} {
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				conn, err := sd.DialStream(context.Background(), addr)
+				require.NoError(t, err)
+				defer conn.Close()
+				require.Equal(t, data, transporttest.Echo(t, conn, data))
+			}(addr)
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), srv.conns.Load())
+		require.ElementsMatch(t, []string{"example.com:443", "192.0.2.1:80", "[2001:db8::1]:53"}, srv.destinations)
+	}
+}
+
+func TestStreamDialer_MaxStreams(t *testing.T) {
+	srv := &testServer{t: t}
+	sd := NewStreamDialer(srv.dialer(), Options{MaxConnections: 2, MaxStreams: 1})
+	for i := 0; i < 3; i++ {
+		conn, err := sd.DialStream(context.Background(), "example.com:443")
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	require.Equal(t, int32(2), srv.conns.Load())
+	require.Len(t, sd.(*streamDialer).sessions, 2)
+}
+
+func TestStreamDialer_RemoteError(t *testing.T) {
+	srv := &testServer{t: t}
+	sd := NewStreamDialer(srv.dialer(), Options{})
+	conn, err := sd.DialStream(context.Background(), "blocked.example:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorContains(t, err, "connection refused")
+}
+
+func TestStreamDialer_SessionClosed(t *testing.T) {
+	srv := &testServer{t: t}
+	sd := NewStreamDialer(srv.dialer(), Options{})
+	conn, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	sd.(*streamDialer).sessions[0].Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ErrSessionClosed)
+
+	// A new connection replaces the closed one.
+	conn, err = sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, []byte("ping"), transporttest.Echo(t, conn, []byte("ping")))
+	require.Equal(t, int32(2), srv.conns.Load())
+}
+
+func TestPaddingConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	w, r := newPaddingConn(client), newPaddingConn(server)
+
+	// Larger than a padded frame, and more writes than the padded ones.
+	var data []byte
+	go func() {
+		for i := 0; i < firstPaddedFrames+2; i++ {
+			_, err := w.Write(bytes.Repeat([]byte{byte(i)}, maxPaddedData/4))
+			require.NoError(t, err)
+		}
+		_, err := w.Write(bytes.Repeat([]byte{0xff}, maxPaddedData+1))
+		require.NoError(t, err)
+		client.Close()
+	}()
+	for i := 0; i < firstPaddedFrames+2; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, maxPaddedData/4)...)
+	}
+	data = append(data, bytes.Repeat([]byte{0xff}, maxPaddedData+1)...)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestStreamDialer_DrainsUnusableSession(t *testing.T) {
+	srv := &testServer{t: t}
+	sd := NewStreamDialer(srv.dialer(), Options{})
+	conn, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	old := sd.(*streamDialer).sessions[0]
+	old.mu.Lock()
+	old.goAway = true
+	old.mu.Unlock()
+
+	// The new streams go to a new connection, and the old one is closed once its streams are.
+	conn2, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn2.Close()
+	require.Equal(t, int32(2), srv.conns.Load())
+	require.False(t, old.isClosed(), "the open streams must keep the connection")
+	require.Equal(t, []byte("ping"), transporttest.Echo(t, conn, []byte("ping")))
+	conn.Close()
+	require.Eventually(t, old.isClosed, time.Second, 10*time.Millisecond)
+}
+
+func TestStreamDialer_DialsWithoutLock(t *testing.T) {
+	srv := &testServer{t: t}
+	var blocked atomic.Bool
+	unblock := make(chan struct{})
+	dialer := srv.dialer()
+	sd := NewStreamDialer(transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if blocked.Load() {
+			<-unblock
+		}
+		return dialer.DialStream(ctx, addr)
+	}), Options{MaxConnections: 2, MaxStreams: 1})
+	d := sd.(*streamDialer)
+
+	conn, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	blocked.Store(true)
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := sd.DialStream(context.Background(), "example.com:443")
+		if err == nil {
+			defer conn.Close()
+		}
+		dialed <- err
+	}()
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.dialing == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The connection being dialed takes the last slot, so the stream goes to the existing one
+	// without waiting for it.
+	conn2, err := sd.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	close(unblock)
+	require.NoError(t, <-dialed)
+	require.Len(t, d.sessions, 2)
+}
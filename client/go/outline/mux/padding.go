@@ -0,0 +1,104 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+)
+
+// firstPaddedFrames is the number of writes, and of reads, padded at the start of a connection.
+const firstPaddedFrames = 16
+
+// maxPaddedData is the size of the largest data of a padded frame.
+const maxPaddedData = 65535
+
+// newPaddingLen returns the random size of a padding.
+func newPaddingLen() int {
+	return 256 + rand.Intn(512)
+}
+
+// paddingConn pads the first frames of each direction of a connection, hiding the sizes of the
+// handshakes of the first streams. The padded frames are:
+//
+//	[uint16 big-endian data length][uint16 big-endian padding length][data][padding]
+type paddingConn struct {
+	net.Conn
+
+	readFrames  int
+	writeFrames int
+	// readRemaining and paddingRemaining are what's left to read of the current padded frame.
+	readRemaining    int
+	paddingRemaining int
+}
+
+func newPaddingConn(conn net.Conn) *paddingConn {
+	return &paddingConn{Conn: conn}
+}
+
+func (c *paddingConn) Read(b []byte) (int, error) {
+	if c.readRemaining > 0 {
+		n, err := c.Conn.Read(b[:min(len(b), c.readRemaining)])
+		c.readRemaining -= n
+		return n, err
+	}
+	if c.paddingRemaining > 0 {
+		if _, err := io.CopyN(io.Discard, c.Conn, int64(c.paddingRemaining)); err != nil {
+			return 0, err
+		}
+		c.paddingRemaining = 0
+	}
+	if c.readFrames >= firstPaddedFrames {
+		return c.Conn.Read(b)
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return 0, err
+	}
+	c.readFrames++
+	c.readRemaining = int(binary.BigEndian.Uint16(header[:2]))
+	c.paddingRemaining = int(binary.BigEndian.Uint16(header[2:]))
+	if c.readRemaining == 0 {
+		return c.Read(b)
+	}
+	n, err := c.Conn.Read(b[:min(len(b), c.readRemaining)])
+	c.readRemaining -= n
+	return n, err
+}
+
+func (c *paddingConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) && c.writeFrames < firstPaddedFrames {
+		data := b[n:min(len(b), n+maxPaddedData)]
+		paddingLen := newPaddingLen()
+		frame := make([]byte, 4, 4+len(data)+paddingLen)
+		binary.BigEndian.PutUint16(frame[:2], uint16(len(data)))
+		binary.BigEndian.PutUint16(frame[2:], uint16(paddingLen))
+		frame = append(frame, data...)
+		frame = append(frame, make([]byte, paddingLen)...)
+		c.writeFrames++
+		if _, err := c.Conn.Write(frame); err != nil {
+			return n, err
+		}
+		n += len(data)
+	}
+	if n == len(b) {
+		return n, nil
+	}
+	m, err := c.Conn.Write(b[n:])
+	return n + m, err
+}
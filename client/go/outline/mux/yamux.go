@@ -0,0 +1,582 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+// Frame types and flags of the yamux protocol, as specified in
+// https://github.com/hashicorp/yamux/blob/master/spec.md.
+const (
+	yamuxVersion    = 0
+	yamuxHeaderSize = 12
+
+	typeData         = 0
+	typeWindowUpdate = 1
+	typePing         = 2
+	typeGoAway       = 3
+
+	flagSYN = 1
+	flagACK = 2
+	flagFIN = 4
+	flagRST = 8
+)
+
+const (
+	// initialWindow is the receive window of the streams when they are opened.
+	initialWindow = 256 * 1024
+	// maxFrameData is the size of the largest data frame written.
+	maxFrameData = 32 * 1024
+	// acceptBacklog is the number of streams opened by the peer waiting to be accepted.
+	acceptBacklog = 256
+	// streamCloseTimeout is how long a closed stream waits for the peer to close it too, before
+	// resetting it.
+	streamCloseTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrSessionClosed is returned by the streams of a closed session.
+	ErrSessionClosed = errors.New("mux session closed")
+	// ErrStreamReset is returned by the streams reset by the peer.
+	ErrStreamReset = errors.New("mux stream reset by peer")
+
+	errGoAway = errors.New("mux session is going away")
+)
+
+var framePool = bufpool.New(yamuxHeaderSize + maxFrameData)
+
+// session is a yamux session over a connection. The client opens the streams with odd IDs, and
+// the server the streams with even IDs.
+type session struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*stream
+	nextID  uint32
+	// goAway is set when the peer doesn't accept new streams anymore.
+	goAway bool
+	// drained is set to close the session once it has no streams, see closeWhenDrained.
+	drained bool
+	// accept holds the streams opened by the peer. It is nil in the client, which rejects them.
+	accept chan *stream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	// closeErr is why the session was closed. It is set before closed is closed.
+	closeErr error
+}
+
+// newSession starts a yamux session over conn, as the client or the server.
+func newSession(conn net.Conn, client bool) *session {
+	s := &session{
+		conn:    conn,
+		streams: make(map[uint32]*stream),
+		nextID:  2,
+		closed:  make(chan struct{}),
+	}
+	if client {
+		s.nextID = 1
+	} else {
+		s.accept = make(chan *stream, acceptBacklog)
+	}
+	resources.Go(resources.SubsystemMux, func() {
+		s.closeWithError(s.recv())
+	})
+	return s
+}
+
+// open opens a new stream. It doesn't wait for the peer to acknowledge it.
+func (s *session) open() (*stream, error) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, s.err()
+	}
+	if s.goAway {
+		s.mu.Unlock()
+		return nil, errGoAway
+	}
+	st := newStream(s, s.nextID)
+	s.nextID += 2
+	s.streams[st.id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, st.id, 0, nil); err != nil {
+		s.removeStream(st.id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// acceptStream waits for the peer to open a stream, and acknowledges it.
+func (s *session) acceptStream() (*stream, error) {
+	select {
+	case st := <-s.accept:
+		if err := s.writeFrame(typeWindowUpdate, flagACK, st.id, 0, nil); err != nil {
+			return nil, err
+		}
+		return st, nil
+	case <-s.closed:
+		return nil, s.err()
+	}
+}
+
+// usable returns whether new streams can be opened.
+func (s *session) usable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.goAway && !s.isClosed()
+}
+
+// numStreams returns the number of streams that are not fully closed.
+func (s *session) numStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	closing := s.drained && len(s.streams) == 0
+	s.mu.Unlock()
+	if closing {
+		s.Close()
+	}
+}
+
+// closeWhenDrained closes the session now if it has no streams, or else once they're all closed,
+// e.g. when it's not usable anymore.
+func (s *session) closeWhenDrained() {
+	s.mu.Lock()
+	s.drained = true
+	closing := len(s.streams) == 0
+	s.mu.Unlock()
+	if closing {
+		s.Close()
+	}
+}
+
+// Close closes the connection of the session and all its streams.
+func (s *session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.closed)
+		s.conn.Close()
+	})
+}
+
+func (s *session) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// err returns the error of the operations on the closed session.
+func (s *session) err() error {
+	if s.closeErr == nil || errors.Is(s.closeErr, ErrSessionClosed) || errors.Is(s.closeErr, io.EOF) {
+		return ErrSessionClosed
+	}
+	return fmt.Errorf("%w: %w", ErrSessionClosed, s.closeErr)
+}
+
+// writeFrame writes a frame with a header of type typ, and data, if any. length is the size of
+// data for the data frames, and the value of the header for the other frames.
+func (s *session) writeFrame(typ byte, flags uint16, id, length uint32, data []byte) error {
+	frameBuf := framePool.Get()
+	defer framePool.Put(frameBuf)
+	frame := (*frameBuf)[:yamuxHeaderSize]
+	frame[0], frame[1] = yamuxVersion, typ
+	binary.BigEndian.PutUint16(frame[2:], flags)
+	binary.BigEndian.PutUint32(frame[4:], id)
+	binary.BigEndian.PutUint32(frame[8:], length)
+	frame = append(frame, data...)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.isClosed() {
+		return s.err()
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		s.closeWithError(err)
+		return s.err()
+	}
+	return nil
+}
+
+// recv reads the frames of the peer until the connection fails.
+func (s *session) recv() error {
+	r := bufio.NewReader(s.conn)
+	var header [yamuxHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		if header[0] != yamuxVersion {
+			return fmt.Errorf("unsupported yamux version %d", header[0])
+		}
+		typ, flags := header[1], binary.BigEndian.Uint16(header[2:])
+		id, length := binary.BigEndian.Uint32(header[4:]), binary.BigEndian.Uint32(header[8:])
+		switch typ {
+		case typeData, typeWindowUpdate:
+			if err := s.handleStreamFrame(r, typ, flags, id, length); err != nil {
+				return err
+			}
+		case typePing:
+			if flags&flagSYN != 0 {
+				if err := s.writeFrame(typePing, flagACK, 0, length, nil); err != nil {
+					return err
+				}
+			}
+		case typeGoAway:
+			s.mu.Lock()
+			s.goAway = true
+			s.mu.Unlock()
+		default:
+			return fmt.Errorf("unknown yamux frame type %d", typ)
+		}
+	}
+}
+
+func (s *session) handleStreamFrame(r io.Reader, typ byte, flags uint16, id, length uint32) error {
+	s.mu.Lock()
+	st := s.streams[id]
+	rejected := false
+	if st == nil && flags&flagSYN != 0 {
+		st = newStream(s, id)
+		select {
+		case s.accept <- st:
+			s.streams[id] = st
+		default:
+			st, rejected = nil, true
+		}
+	}
+	s.mu.Unlock()
+
+	if st != nil {
+		return st.handleFrame(r, typ, flags, length)
+	}
+	// Frames of unknown or closed streams are dropped.
+	if typ == typeData {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return err
+		}
+	}
+	if rejected {
+		return s.writeFrame(typeWindowUpdate, flagRST, id, 0, nil)
+	}
+	return nil
+}
+
+// stream is a yamux stream. It implements [transport.StreamConn].
+type stream struct {
+	id      uint32
+	session *session
+
+	// writeMu serializes the writes, so that their data frames don't interleave.
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	recvBuf    bytes.Buffer
+	recvWindow uint32
+	// consumed is the number of bytes read since the last window update.
+	consumed   uint32
+	sendWindow uint32
+	// localClosed and remoteClosed are set when each side sent its FIN.
+	localClosed  bool
+	remoteClosed bool
+	readClosed   bool
+	reset        bool
+	closeTimer   *time.Timer
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// recvReady and sendReady wake up the blocked reads and writes.
+	recvReady chan struct{}
+	sendReady chan struct{}
+}
+
+func newStream(s *session, id uint32) *stream {
+	return &stream{
+		id:         id,
+		session:    s,
+		recvWindow: initialWindow,
+		sendWindow: initialWindow,
+		recvReady:  make(chan struct{}, 1),
+		sendReady:  make(chan struct{}, 1),
+	}
+}
+
+// notify wakes up the goroutine waiting on ch, if any.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// handleFrame handles a data or window update frame of the stream, reading its data from r.
+func (st *stream) handleFrame(r io.Reader, typ byte, flags uint16, length uint32) error {
+	if typ == typeWindowUpdate {
+		st.mu.Lock()
+		st.sendWindow += length
+		st.mu.Unlock()
+		notify(st.sendReady)
+	} else if length > 0 {
+		st.mu.Lock()
+		if length > st.recvWindow {
+			st.mu.Unlock()
+			return fmt.Errorf("yamux stream %d exceeded its receive window", st.id)
+		}
+		st.recvWindow -= length
+		discard := st.readClosed
+		st.mu.Unlock()
+
+		if discard {
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+				return err
+			}
+			// Let the peer send more, since the data is dropped anyway.
+			st.mu.Lock()
+			st.recvWindow += length
+			st.mu.Unlock()
+			if err := st.session.writeFrame(typeWindowUpdate, 0, st.id, length, nil); err != nil {
+				return err
+			}
+		} else {
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			st.mu.Lock()
+			st.recvBuf.Write(data)
+			st.mu.Unlock()
+			notify(st.recvReady)
+		}
+	}
+
+	if flags&(flagFIN|flagRST) != 0 {
+		st.mu.Lock()
+		if flags&flagRST != 0 {
+			st.reset = true
+		}
+		st.remoteClosed = true
+		done := st.localClosed || st.reset
+		if done && st.closeTimer != nil {
+			st.closeTimer.Stop()
+		}
+		st.mu.Unlock()
+		notify(st.recvReady)
+		notify(st.sendReady)
+		if done {
+			st.session.removeStream(st.id)
+		}
+	}
+	return nil
+}
+
+// wait waits for ready, until deadline if it's not zero.
+func (st *stream) wait(ready <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-st.session.closed:
+		return st.session.err()
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (st *stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.reset {
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		}
+		if st.recvBuf.Len() > 0 {
+			n, _ := st.recvBuf.Read(b)
+			st.consumed += uint32(n)
+			var delta uint32
+			if st.consumed >= initialWindow/2 {
+				delta, st.consumed = st.consumed, 0
+				st.recvWindow += delta
+			}
+			st.mu.Unlock()
+			if delta > 0 {
+				if err := st.session.writeFrame(typeWindowUpdate, 0, st.id, delta, nil); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}
+		if st.remoteClosed || st.readClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if err := st.wait(st.recvReady, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *stream) Write(b []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+	n := 0
+	for n < len(b) {
+		st.mu.Lock()
+		if st.reset {
+			st.mu.Unlock()
+			return n, ErrStreamReset
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.sendReady, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		size := min(uint32(len(b)-n), st.sendWindow, maxFrameData)
+		st.sendWindow -= size
+		st.mu.Unlock()
+		if err := st.session.writeFrame(typeData, 0, st.id, size, b[n:n+int(size)]); err != nil {
+			return n, err
+		}
+		n += int(size)
+	}
+	return n, nil
+}
+
+// CloseRead drops the data received from now on.
+func (st *stream) CloseRead() error {
+	st.mu.Lock()
+	st.readClosed = true
+	st.recvBuf.Reset()
+	st.mu.Unlock()
+	notify(st.recvReady)
+	return nil
+}
+
+// CloseWrite sends a FIN to the peer, after the data being written.
+func (st *stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.localClosed || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	done := st.remoteClosed
+	st.mu.Unlock()
+	// Wake up the blocked writes, and wait for the one in progress.
+	notify(st.sendReady)
+	st.writeMu.Lock()
+	err := st.session.writeFrame(typeWindowUpdate, flagFIN, st.id, 0, nil)
+	st.writeMu.Unlock()
+	if done {
+		st.session.removeStream(st.id)
+	}
+	return err
+}
+
+// Close closes both directions of the stream. The stream is reset if the peer doesn't close it
+// within streamCloseTimeout.
+func (st *stream) Close() error {
+	st.CloseRead()
+	err := st.CloseWrite()
+	st.mu.Lock()
+	if !st.remoteClosed && st.closeTimer == nil {
+		st.closeTimer = time.AfterFunc(streamCloseTimeout, st.forceReset)
+	}
+	st.mu.Unlock()
+	return err
+}
+
+func (st *stream) forceReset() {
+	st.mu.Lock()
+	if st.remoteClosed {
+		st.mu.Unlock()
+		return
+	}
+	st.reset = true
+	st.mu.Unlock()
+	st.session.writeFrame(typeWindowUpdate, flagRST, st.id, 0, nil)
+	st.session.removeStream(st.id)
+}
+
+func (st *stream) LocalAddr() net.Addr  { return st.session.conn.LocalAddr() }
+func (st *stream) RemoteAddr() net.Addr { return st.session.conn.RemoteAddr() }
+
+func (st *stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.recvReady)
+	return nil
+}
+
+func (st *stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.sendReady)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMuxTransport(t *testing.T) {
+	server := `{"host":"example.com","port":1234,"method":"chacha20-ietf-poly1305","password":"abcd"}`
+	for _, config := range []string{
+		`{"$type":"mux","transport":` + server + `}`,
+		`{"$type":"mux","protocol":"yamux","maxConnections":2,"maxStreams":16,"padding":true,"transport":` + server + `}`,
+		`{"$type":"mux","transport":{"$type":"multi","transports":[` + server + `]}}`,
+	} {
+		got := NewClient(config)
+		require.Nil(t, got.Error, config)
+	}
+}
+
+func TestParseMuxTransport_Invalid(t *testing.T) {
+	server := `{"host":"example.com","port":1234,"method":"chacha20-ietf-poly1305","password":"abcd"}`
+	for _, config := range []string{
+		`{"$type":"mux"}`,
+		`{"$type":"mux","protocol":"smux","transport":` + server + `}`,
+		`{"$type":"mux","maxConnections":-1,"transport":` + server + `}`,
+		`{"$type":"mux","maxStreams":-1,"transport":` + server + `}`,
+		`{"$type":"mux","transport":{"$type":"unknown"}}`,
+		`{"$type":"mux","transport":{"host":"example.com"}}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nat keeps track of the UDP sessions of a tunnel, like the NAT table of a router. The
+// table has a maximum size, and evicts the least recently used session to make room for a new
+// one, so that applications opening many sessions, like P2P or QUIC-heavy ones, cannot make the
+// memory and sockets of the tunnel grow without bound.
+package nat
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultMaxSessions is the maximum number of sessions of a [Table], unless configured.
+const DefaultMaxSessions = 1024
+
+// Table is a table of UDP sessions with a maximum size. It's safe for concurrent use.
+type Table struct {
+	maxSessions int
+	onEvict     func()
+
+	mu  sync.Mutex
+	lru list.List // Of *Entry, the most recently used first.
+}
+
+// NewTable creates a [Table] of at most maxSessions sessions, or [DefaultMaxSessions] if it is not
+// positive. onEvict, if not nil, is called whenever a session is evicted, e.g. to count it.
+func NewTable(maxSessions int, onEvict func()) *Table {
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	return &Table{maxSessions: maxSessions, onEvict: onEvict}
+}
+
+// MaxSessions returns the maximum number of sessions of t.
+func (t *Table) MaxSessions() int {
+	return t.maxSessions
+}
+
+// Len returns the number of sessions in t.
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+// Entry is a session of a [Table].
+type Entry struct {
+	table *Table
+	elem  *list.Element // Nil once removed.
+	close func()
+}
+
+// Add adds a session to t, which close terminates if it's evicted. If t is full, the least
+// recently used session is evicted first.
+func (t *Table) Add(close func()) *Entry {
+	e := &Entry{table: t, close: close}
+	var evicted []*Entry
+	t.mu.Lock()
+	for t.lru.Len() >= t.maxSessions {
+		oldest := t.lru.Back().Value.(*Entry)
+		t.removeLocked(oldest)
+		evicted = append(evicted, oldest)
+	}
+	e.elem = t.lru.PushFront(e)
+	t.mu.Unlock()
+
+	// The sessions are closed without holding the lock, since closing may remove them again.
+	for _, oldest := range evicted {
+		oldest.close()
+		if t.onEvict != nil {
+			t.onEvict()
+		}
+	}
+	return e
+}
+
+// Touch marks e as the most recently used session. It's called for each outgoing packet.
+func (e *Entry) Touch() {
+	e.table.mu.Lock()
+	defer e.table.mu.Unlock()
+	if e.elem != nil {
+		e.table.lru.MoveToFront(e.elem)
+	}
+}
+
+// Remove removes e from its table, when the session is closed. It does nothing if e is already
+// removed.
+func (e *Entry) Remove() {
+	e.table.mu.Lock()
+	defer e.table.mu.Unlock()
+	e.table.removeLocked(e)
+}
+
+func (t *Table) removeLocked(e *Entry) {
+	if e.elem != nil {
+		t.lru.Remove(e.elem)
+		e.elem = nil
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable_EvictsLeastRecentlyUsed(t *testing.T) {
+	var evictions int
+	table := NewTable(2, func() { evictions++ })
+	var closed []string
+	add := func(name string) *Entry {
+		var e *Entry
+		e = table.Add(func() {
+			closed = append(closed, name)
+			// Closing a session removes it, like the real handlers do.
+			e.Remove()
+		})
+		return e
+	}
+
+	a := add("a")
+	add("b")
+	a.Touch()
+	add("c")
+	require.Equal(t, []string{"b"}, closed)
+	require.Equal(t, 1, evictions)
+	require.Equal(t, 2, table.Len())
+
+	add("d")
+	require.Equal(t, []string{"b", "a"}, closed)
+	require.Equal(t, 2, evictions)
+	require.Equal(t, 2, table.Len())
+}
+
+func TestTable_Remove(t *testing.T) {
+	table := NewTable(2, nil)
+	a := table.Add(func() { t.Error("a must not be evicted") })
+	a.Remove()
+	a.Remove()
+	a.Touch()
+	require.Equal(t, 0, table.Len())
+
+	table.Add(func() {})
+	table.Add(func() {})
+	require.Equal(t, 2, table.Len())
+}
+
+func TestNewTable_Default(t *testing.T) {
+	require.Equal(t, DefaultMaxSessions, NewTable(0, nil).MaxSessions())
+	require.Equal(t, 10, NewTable(10, nil).MaxSessions())
+}
+
+func BenchmarkEntry_Touch(b *testing.B) {
+	table := NewTable(DefaultMaxSessions, nil)
+	entries := make([]*Entry, DefaultMaxSessions)
+	for i := range entries {
+		entries[i] = table.Add(func() {})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries[i%len(entries)].Touch()
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ondemand"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/dns"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultEgressTimeout = 3 * time.Second
+	defaultEgressDNS     = "8.8.8.8:53"
+	defaultEgressTCP     = "8.8.8.8:443"
+	// The addresses of the routing table lookups, to which nothing is sent.
+	routeProbeIPv4 = "8.8.8.8:53"
+	routeProbeIPv6 = "[2001:4860:4860::8888]:53"
+)
+
+// resolvConfPath is the resolver configuration of the system, where it has one.
+var resolvConfPath = "/etc/resolv.conf"
+
+// networkEnvironmentRequestJSON is the input of [MethodGetNetworkEnvironment].
+type networkEnvironmentRequestJSON struct {
+	// DNSServer is the address of the resolver the UDP/53 egress is tested with. Defaults to
+	// 8.8.8.8:53.
+	DNSServer string `json:"dnsServer,omitempty"`
+
+	// TCPAddress is the address the TCP/443 egress is tested with. Defaults to 8.8.8.8:443.
+	TCPAddress string `json:"tcpAddress,omitempty"`
+
+	// TimeoutMs is the timeout of each egress test. Defaults to 3 seconds.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+}
+
+// networkEnvironmentJSON is the output of [MethodGetNetworkEnvironment]. It doesn't contain the
+// IP addresses of the device.
+type networkEnvironmentJSON struct {
+	// Interface is the name of the interface of the default route, if any.
+	Interface string `json:"interface,omitempty"`
+
+	// InterfaceType is the type of the network the platform last reported with
+	// [MethodNotifyNetworkChanged], or else guessed from the name of the interface.
+	InterfaceType ondemand.NetworkType `json:"interfaceType"`
+
+	// IPv4 and IPv6 are whether there is a route to the IPv4 and IPv6 internet.
+	IPv4 bool `json:"ipv4"`
+	IPv6 bool `json:"ipv6"`
+
+	// DNSServers are the resolvers of the system, on the platforms where they can be read.
+	DNSServers []string `json:"dnsServers,omitempty"`
+
+	// UDPDNS and TCP443 are whether the network lets DNS queries over UDP port 53 and TCP
+	// connections to port 443 out, outside of the VPN.
+	UDPDNS egressTestJSON `json:"udpDns"`
+	TCP443 egressTestJSON `json:"tcp443"`
+}
+
+// egressTestJSON is the result of an egress test of [MethodGetNetworkEnvironment].
+type egressTestJSON struct {
+	Success   bool                      `json:"success"`
+	LatencyMs int64                     `json:"latencyMs,omitempty"`
+	Error     *platerrors.PlatformError `json:"error,omitempty"`
+}
+
+// getNetworkEnvironment parses the input as an optional networkEnvironmentRequestJSON, and
+// returns a JSON string of networkEnvironmentJSON describing the network the device is on.
+func getNetworkEnvironment(ctx context.Context, input string) (string, error) {
+	var req networkEnvironmentRequestJSON
+	if input != "" {
+		if err := json.Unmarshal([]byte(input), &req); err != nil {
+			return "", platerrors.PlatformError{
+				Code:    platerrors.IllegalConfig,
+				Message: "invalid network environment request",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	if req.DNSServer == "" {
+		req.DNSServer = defaultEgressDNS
+	}
+	if req.TCPAddress == "" {
+		req.TCPAddress = defaultEgressTCP
+	}
+	timeout := defaultEgressTimeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+
+	dialer := newDirectDialer()
+	env := networkEnvironmentJSON{DNSServers: readSystemDNSServers(resolvConfPath)}
+	var localIP net.IP
+	env.IPv4, localIP = hasRoute(dialer, routeProbeIPv4)
+	env.IPv6, _ = hasRoute(dialer, routeProbeIPv6)
+	if localIP != nil {
+		env.Interface = interfaceOf(localIP)
+	}
+	onDemand.mu.Lock()
+	env.InterfaceType = onDemand.network.Type
+	onDemand.mu.Unlock()
+	if env.InterfaceType == "" {
+		env.InterfaceType = guessNetworkType(env.Interface)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		env.UDPDNS = testEgress(ctx, timeout, func(ctx context.Context) error {
+			return testUDPDNSEgress(ctx, dialer, req.DNSServer)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		env.TCP443 = testEgress(ctx, timeout, func(ctx context.Context) error {
+			conn, err := dialer.DialContext(ctx, "tcp", req.TCPAddress)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		})
+	}()
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	out, err := json.Marshal(env)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal network environment",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// hasRoute returns whether there is a route to addr, and the local IP address of the route. It
+// doesn't send anything.
+func hasRoute(dialer net.Dialer, addr string) (bool, net.IP) {
+	conn, err := dialer.Dial("udp", addr)
+	if err != nil {
+		return false, nil
+	}
+	defer conn.Close()
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return true, nil
+	}
+	return true, local.IP
+}
+
+// interfaceOf returns the name of the interface with the IP address ip, or "" if none has it.
+func interfaceOf(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// guessNetworkType guesses the type of the network of the interface from the naming conventions
+// of the operating systems.
+func guessNetworkType(iface string) ondemand.NetworkType {
+	switch {
+	case iface == "":
+		return ondemand.NetworkNone
+	case strings.HasPrefix(iface, "wl"):
+		return ondemand.NetworkWiFi
+	case strings.HasPrefix(iface, "eth"), strings.HasPrefix(iface, "enp"), strings.HasPrefix(iface, "eno"):
+		return ondemand.NetworkEthernet
+	case strings.HasPrefix(iface, "rmnet"), strings.HasPrefix(iface, "pdp_ip"), strings.HasPrefix(iface, "wwan"),
+		strings.HasPrefix(iface, "ccmni"):
+		return ondemand.NetworkCellular
+	default:
+		return ondemand.NetworkOther
+	}
+}
+
+// readSystemDNSServers returns the name servers of the resolv.conf file at path, or nil if it
+// can't be read.
+func readSystemDNSServers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// testEgress runs test with the timeout, and returns its result.
+func testEgress(ctx context.Context, timeout time.Duration, test func(ctx context.Context) error) egressTestJSON {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	if err := test(ctx); err != nil {
+		return egressTestJSON{Error: platerrors.ToPlatformError(err)}
+	}
+	return egressTestJSON{Success: true, LatencyMs: time.Since(start).Milliseconds()}
+}
+
+// testUDPDNSEgress sends a DNS query over UDP to the resolver at addr.
+func testUDPDNSEgress(ctx context.Context, dialer net.Dialer, addr string) error {
+	q, err := dns.NewQuestion("dns.google.", dnsmessage.TypeA)
+	if err != nil {
+		return err
+	}
+	_, err = dns.NewUDPResolver(&transport.UDPDialer{Dialer: dialer}, addr).Query(ctx, *q)
+	return err
+}
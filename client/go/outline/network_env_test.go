@@ -0,0 +1,148 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ondemand"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startTestDNSServer starts a UDP resolver answering all the A queries with 192.0.2.1.
+func startTestDNSServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if msg.Unpack(buf[:n]) != nil || len(msg.Questions) == 0 {
+				continue
+			}
+			msg.Header.Response = true
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+			}}
+			if out, err := msg.Pack(); err == nil {
+				conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestGetNetworkEnvironment(t *testing.T) {
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConf, []byte("# comment\nnameserver 192.0.2.53\nsearch example.com\nnameserver 2001:db8::53\n"), 0o600))
+	defer func(path string) { resolvConfPath = path }(resolvConfPath)
+	resolvConfPath = resolvConf
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	input, err := json.Marshal(networkEnvironmentRequestJSON{
+		DNSServer: startTestDNSServer(t), TCPAddress: listener.Addr().String(), TimeoutMs: 2000,
+	})
+	require.NoError(t, err)
+	out, err := getNetworkEnvironment(context.Background(), string(input))
+	require.NoError(t, err)
+	var env networkEnvironmentJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &env))
+	require.Equal(t, []string{"192.0.2.53", "2001:db8::53"}, env.DNSServers)
+	require.True(t, env.UDPDNS.Success, env.UDPDNS.Error)
+	require.True(t, env.TCP443.Success, env.TCP443.Error)
+	require.NotEmpty(t, env.InterfaceType)
+
+	// Nothing listens on the closed addresses.
+	listener.Close()
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed.Close()
+	input, err = json.Marshal(networkEnvironmentRequestJSON{
+		DNSServer: closed.LocalAddr().String(), TCPAddress: listener.Addr().String(), TimeoutMs: 500,
+	})
+	require.NoError(t, err)
+	out, err = getNetworkEnvironment(context.Background(), string(input))
+	require.NoError(t, err)
+	env = networkEnvironmentJSON{}
+	require.NoError(t, json.Unmarshal([]byte(out), &env))
+	require.False(t, env.UDPDNS.Success)
+	require.NotNil(t, env.UDPDNS.Error)
+	require.False(t, env.TCP443.Success)
+	require.NotNil(t, env.TCP443.Error)
+}
+
+func TestGetNetworkEnvironment_ReportedNetworkType(t *testing.T) {
+	onDemand.mu.Lock()
+	saved := onDemand.network
+	onDemand.network = ondemand.Network{Type: ondemand.NetworkCellular}
+	onDemand.mu.Unlock()
+	defer func() {
+		onDemand.mu.Lock()
+		onDemand.network = saved
+		onDemand.mu.Unlock()
+	}()
+
+	out, err := getNetworkEnvironment(context.Background(), `{"dnsServer":"127.0.0.1:1","tcpAddress":"127.0.0.1:1","timeoutMs":100}`)
+	require.NoError(t, err)
+	var env networkEnvironmentJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &env))
+	require.Equal(t, ondemand.NetworkCellular, env.InterfaceType)
+
+	_, err = getNetworkEnvironment(context.Background(), `not json`)
+	require.Error(t, err)
+}
+
+func TestGuessNetworkType(t *testing.T) {
+	for iface, want := range map[string]ondemand.NetworkType{
+		"":        ondemand.NetworkNone,
+		"wlan0":   ondemand.NetworkWiFi,
+		"wlp2s0":  ondemand.NetworkWiFi,
+		"eth0":    ondemand.NetworkEthernet,
+		"enp3s0":  ondemand.NetworkEthernet,
+		"rmnet0":  ondemand.NetworkCellular,
+		"pdp_ip0": ondemand.NetworkCellular,
+		"en0":     ondemand.NetworkOther,
+	} {
+		require.Equal(t, want, guessNetworkType(iface), iface)
+	}
+}
+
+func TestReadSystemDNSServers(t *testing.T) {
+	require.Nil(t, readSystemDNSServers(filepath.Join(t.TempDir(), "missing")))
+}
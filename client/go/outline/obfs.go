@@ -0,0 +1,168 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+// Obfuscation strategies that can be selected by the "$type" field of an obfs config.
+const (
+	// obfsTypePrefix prepends a fixed prefix to every salt. This is what the legacy "prefix"
+	// field does. With "prefixes", each connection uses one of the candidates, picked at random.
+	obfsTypePrefix = "prefix"
+
+	// obfsTypePadding fills the first "length" bytes of every salt with random printable
+	// ASCII characters, so the first packet looks like plain text.
+	obfsTypePadding = "padding"
+
+	// obfsTypeHTTP makes every salt start with an HTTP request line, like "POST /a8c3",
+	// followed by "length" random path characters.
+	obfsTypeHTTP = "http"
+)
+
+const (
+	obfsPrintableChars = "!#$%&()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[]^_abcdefghijklmnopqrstuvwxyz{|}~"
+	obfsPathChars      = "abcdefghijklmnopqrstuvwxyz0123456789"
+	obfsDefaultMethod  = "POST"
+)
+
+// obfsConfigJSON is the JSON representation of an obfuscation layer in the transport config.
+//
+// Examples:
+//
+//	{"$type": "prefix", "prefix": "\u0016\u0003\u0001"}
+//	{"$type": "prefix", "prefixes": ["\u0016\u0003\u0001", "POST "]}
+//	{"$type": "padding", "length": 8}
+//	{"$type": "http", "method": "GET", "length": 4}
+type obfsConfigJSON struct {
+	Type   string `json:"$type"`
+	Prefix string `json:"prefix,omitempty"`
+	// Prefixes are the candidate prefixes to rotate, a single static prefix being easier to
+	// fingerprint. It cannot be used together with Prefix.
+	Prefixes []string `json:"prefixes,omitempty"`
+	Length   int      `json:"length,omitempty"`
+	Method   string   `json:"method,omitempty"`
+}
+
+// newObfsSaltGenerator creates a [shadowsocks.SaltGenerator] implementing the obfuscation
+// strategy described by conf. saltSize is the salt size of the cipher in use, the obfuscated
+// part of the salt must fit into it.
+//
+// Note: like prefixes, all strategies steal entropy from the salt, see
+// [shadowsocks.NewPrefixSaltGenerator] for the security implications.
+//
+// It returns nil if conf is nil, meaning the default random salt should be used.
+func newObfsSaltGenerator(conf *obfsConfigJSON, saltSize int) (shadowsocks.SaltGenerator, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	switch conf.Type {
+	case obfsTypePrefix:
+		if len(conf.Prefixes) == 0 {
+			return newPrefixSaltGenerator(conf.Prefix, "obfs.prefix", saltSize)
+		}
+		if len(conf.Prefix) > 0 {
+			return nil, newIllegalConfigErrorWithDetails("prefix and prefixes cannot be used together",
+				"obfs.prefix", conf.Prefix, "empty when prefixes is set", nil)
+		}
+		gens := make(rotatingSaltGenerator, len(conf.Prefixes))
+		for i, raw := range conf.Prefixes {
+			gen, err := newPrefixSaltGenerator(raw, fmt.Sprintf("obfs.prefixes[%d]", i), saltSize)
+			if err != nil {
+				return nil, err
+			}
+			gens[i] = gen
+		}
+		return gens, nil
+
+	case obfsTypePadding:
+		if conf.Length <= 0 || conf.Length > saltSize {
+			return nil, newIllegalConfigErrorWithDetails("padding length is not valid", "obfs.length", conf.Length, "within range [1..salt size]", nil)
+		}
+		return &charsetSaltGenerator{charset: obfsPrintableChars, n: conf.Length}, nil
+
+	case obfsTypeHTTP:
+		method := strings.ToUpper(conf.Method)
+		if method == "" {
+			method = obfsDefaultMethod
+		}
+		for _, c := range method {
+			if c < 'A' || c > 'Z' {
+				return nil, newIllegalConfigErrorWithDetails("HTTP method is not valid", "obfs.method", conf.Method, "an HTTP method like GET or POST", nil)
+			}
+		}
+		prefix := []byte(method + " /")
+		if conf.Length < 0 || len(prefix)+conf.Length > saltSize {
+			return nil, newIllegalConfigErrorWithDetails("HTTP header is too long", "obfs.length", conf.Length, "header fits into salt size", nil)
+		}
+		return &charsetSaltGenerator{prefix: prefix, charset: obfsPathChars, n: conf.Length}, nil
+
+	default:
+		return nil, newIllegalConfigErrorWithDetails("obfuscation type is not supported", "obfs.$type", conf.Type,
+			strings.Join([]string{obfsTypePrefix, obfsTypePadding, obfsTypeHTTP}, "|"), nil)
+	}
+}
+
+// newPrefixSaltGenerator creates a [shadowsocks.SaltGenerator] prepending the prefix raw, which
+// is the config field named field, to every salt.
+func newPrefixSaltGenerator(raw, field string, saltSize int) (shadowsocks.SaltGenerator, error) {
+	prefix, err := ParseConfigPrefixFromString(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) == 0 {
+		return nil, newIllegalConfigErrorWithDetails("prefix must not be empty", field, raw, "non-empty string", nil)
+	}
+	if len(prefix) > saltSize {
+		return nil, newIllegalConfigErrorWithDetails("prefix is too long", field, raw, "at most salt size", nil)
+	}
+	return shadowsocks.NewPrefixSaltGenerator(prefix), nil
+}
+
+// rotatingSaltGenerator generates each salt with one of its generators, picked at random.
+type rotatingSaltGenerator []shadowsocks.SaltGenerator
+
+var _ shadowsocks.SaltGenerator = (rotatingSaltGenerator)(nil)
+
+func (g rotatingSaltGenerator) GetSalt(salt []byte) error {
+	return g[mrand.Intn(len(g))].GetSalt(salt)
+}
+
+// charsetSaltGenerator generates salts that start with a fixed prefix, followed by n random
+// characters from charset, followed by random bytes.
+type charsetSaltGenerator struct {
+	prefix  []byte
+	charset string
+	n       int
+}
+
+var _ shadowsocks.SaltGenerator = (*charsetSaltGenerator)(nil)
+
+func (g *charsetSaltGenerator) GetSalt(salt []byte) error {
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	p := copy(salt, g.prefix)
+	for i := p; i < p+g.n && i < len(salt); i++ {
+		salt[i] = g.charset[int(salt[i])%len(g.charset)]
+	}
+	return nil
+}
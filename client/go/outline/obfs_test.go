@@ -0,0 +1,125 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newObfsSaltGenerator(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       *obfsConfigJSON
+		wantPrefix string
+		wantChars  string
+		wantN      int
+		wantErr    bool
+	}{
+		{name: "nil config", conf: nil},
+		{
+			name:       "prefix",
+			conf:       &obfsConfigJSON{Type: "prefix", Prefix: "abc"},
+			wantPrefix: "abc",
+		},
+		{
+			name:      "padding",
+			conf:      &obfsConfigJSON{Type: "padding", Length: 8},
+			wantChars: obfsPrintableChars,
+			wantN:     8,
+		},
+		{
+			name:       "http default method",
+			conf:       &obfsConfigJSON{Type: "http", Length: 4},
+			wantPrefix: "POST /",
+			wantChars:  obfsPathChars,
+			wantN:      4,
+		},
+		{
+			name:       "http lowercase method",
+			conf:       &obfsConfigJSON{Type: "http", Method: "get"},
+			wantPrefix: "GET /",
+		},
+		{name: "empty prefix", conf: &obfsConfigJSON{Type: "prefix"}, wantErr: true},
+		{name: "prefix too long", conf: &obfsConfigJSON{Type: "prefix", Prefix: strings.Repeat("a", 17)}, wantErr: true},
+		{name: "prefixes with prefix", conf: &obfsConfigJSON{Type: "prefix", Prefix: "a", Prefixes: []string{"b"}}, wantErr: true},
+		{name: "empty prefix candidate", conf: &obfsConfigJSON{Type: "prefix", Prefixes: []string{"a", ""}}, wantErr: true},
+		{name: "prefix candidate too long", conf: &obfsConfigJSON{Type: "prefix", Prefixes: []string{strings.Repeat("a", 17)}}, wantErr: true},
+		{name: "zero padding", conf: &obfsConfigJSON{Type: "padding"}, wantErr: true},
+		{name: "padding too long", conf: &obfsConfigJSON{Type: "padding", Length: 17}, wantErr: true},
+		{name: "invalid http method", conf: &obfsConfigJSON{Type: "http", Method: "P0ST"}, wantErr: true},
+		{name: "http header too long", conf: &obfsConfigJSON{Type: "http", Length: 11}, wantErr: true},
+		{name: "unknown type", conf: &obfsConfigJSON{Type: "unknown"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen, err := newObfsSaltGenerator(tt.conf, 16)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.conf == nil {
+				require.Nil(t, gen)
+				return
+			}
+			salt := make([]byte, 16)
+			require.NoError(t, gen.GetSalt(salt))
+			require.Equal(t, tt.wantPrefix, string(salt[:len(tt.wantPrefix)]))
+			for _, c := range salt[len(tt.wantPrefix) : len(tt.wantPrefix)+tt.wantN] {
+				require.Contains(t, tt.wantChars, string(c))
+			}
+		})
+	}
+}
+
+func Test_newObfsSaltGenerator_Prefixes(t *testing.T) {
+	prefixes := []string{"abc", "POST /", "\u0016\u0003"}
+	gen, err := newObfsSaltGenerator(&obfsConfigJSON{Type: "prefix", Prefixes: prefixes}, 16)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		salt := make([]byte, 16)
+		require.NoError(t, gen.GetSalt(salt))
+		var found bool
+		for _, p := range []string{"abc", "POST /", "\x16\x03"} {
+			if strings.HasPrefix(string(salt), p) {
+				seen[p] = true
+				found = true
+			}
+		}
+		require.True(t, found, "salt %q has none of the prefixes", salt)
+	}
+	require.Len(t, seen, len(prefixes))
+}
+
+func Test_configJSON_obfsConfig(t *testing.T) {
+	conf := &configJSON{Prefix: "abc"}
+	obfs, err := conf.obfsConfig()
+	require.NoError(t, err)
+	require.Equal(t, &obfsConfigJSON{Type: obfsTypePrefix, Prefix: "abc"}, obfs)
+
+	conf = &configJSON{Obfs: &obfsConfigJSON{Type: obfsTypePadding, Length: 4}}
+	obfs, err = conf.obfsConfig()
+	require.NoError(t, err)
+	require.Equal(t, conf.Obfs, obfs)
+
+	conf = &configJSON{Prefix: "abc", Obfs: &obfsConfigJSON{Type: obfsTypePadding, Length: 4}}
+	_, err = conf.obfsConfig()
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ondemand"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// onDemandActionEventJSON is the data of [EventOnDemandAction].
+type onDemandActionEventJSON struct {
+	Action  ondemand.Action  `json:"action"`
+	Network ondemand.Network `json:"network"`
+}
+
+// onDemand holds the connect-on-demand rules and the network the platform last reported.
+var onDemand struct {
+	mu      sync.Mutex
+	engine  *ondemand.Engine // nil when connect-on-demand is disabled.
+	network ondemand.Network
+}
+
+// setOnDemandRules parses the input as an ondemand.Config and replaces the connect-on-demand
+// rules. A "null" input disables connect-on-demand.
+//
+// The new rules are evaluated right away against the current network.
+func setOnDemandRules(input string) error {
+	var conf *ondemand.Config
+	if err := json.Unmarshal([]byte(input), &conf); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid connect-on-demand config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	var engine *ondemand.Engine
+	if conf != nil {
+		var err error
+		if engine, err = ondemand.NewEngine(*conf); err != nil {
+			return platerrors.PlatformError{
+				Code:    platerrors.IllegalConfig,
+				Message: "invalid connect-on-demand config",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+
+	onDemand.mu.Lock()
+	onDemand.engine = engine
+	network := onDemand.network
+	onDemand.mu.Unlock()
+	logger.Info("connect-on-demand rules updated", "enabled", engine != nil)
+
+	if engine != nil && network.Type != "" {
+		emitOnDemandAction(engine.Evaluate(network), network)
+	}
+	return nil
+}
+
+// notifyNetworkChanged is called by the platform with the JSON ondemand.Network the device
+// switched to. It moves the active VPN connection to the new network, unless the device is
+// offline. It returns the action the connect-on-demand rules decide, and emits it in an
+// [EventOnDemandAction] unless it's "ignore".
+func notifyNetworkChanged(input string) (ondemand.Action, error) {
+	var network ondemand.Network
+	if err := json.Unmarshal([]byte(input), &network); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "invalid network description",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	onDemand.mu.Lock()
+	onDemand.network = network
+	engine := onDemand.engine
+	onDemand.mu.Unlock()
+
+	if network.Type != ondemand.NetworkNone {
+		networkGeneration.Add(1)
+		resumeVPN()
+	}
+	if engine == nil {
+		return ondemand.ActionIgnore, nil
+	}
+	action := engine.Evaluate(network)
+	emitOnDemandAction(action, network)
+	return action, nil
+}
+
+// evaluateOnDemandDomain returns the connect-on-demand action for a lookup of domain on the
+// current network. Platforms that can intercept lookups while disconnected, like Apple's
+// NEOnDemandRuleEvaluateConnection, use it to connect only for some destinations.
+func evaluateOnDemandDomain(domain string) ondemand.Action {
+	onDemand.mu.Lock()
+	engine, network := onDemand.engine, onDemand.network
+	onDemand.mu.Unlock()
+	if engine == nil {
+		return ondemand.ActionIgnore
+	}
+	return engine.EvaluateDomain(network, domain)
+}
+
+func emitOnDemandAction(action ondemand.Action, network ondemand.Network) {
+	logger.Debug("connect-on-demand evaluated", "action", action, "networkType", network.Type)
+	if action == ondemand.ActionIgnore {
+		return
+	}
+	event.Emit(EventOnDemandAction, onDemandActionEventJSON{Action: action, Network: network})
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ondemand decides whether the VPN should connect or disconnect, based on the network the
+// device is on and the domains it looks up.
+//
+// The rules are evaluated in Go so that connect-on-demand behaves the same on all platforms. The
+// platforms only report network changes, and act on the decisions.
+package ondemand
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+)
+
+// Action tells what to do with the VPN.
+type Action string
+
+const (
+	// ActionConnect connects the VPN, if it's not connected.
+	ActionConnect Action = "connect"
+
+	// ActionDisconnect disconnects the VPN, if it's connected.
+	ActionDisconnect Action = "disconnect"
+
+	// ActionIgnore leaves the VPN as it is.
+	ActionIgnore Action = "ignore"
+)
+
+var actions = []Action{ActionConnect, ActionDisconnect, ActionIgnore}
+
+// NetworkType is the kind of the network the device is on.
+type NetworkType string
+
+const (
+	NetworkWiFi     NetworkType = "wifi"
+	NetworkCellular NetworkType = "cellular"
+	NetworkEthernet NetworkType = "ethernet"
+	NetworkOther    NetworkType = "other"
+
+	// NetworkNone is reported when the device is offline.
+	NetworkNone NetworkType = "none"
+)
+
+// Network describes the network the device is on, as reported by the platform.
+type Network struct {
+	Type NetworkType `json:"type"`
+
+	// SSID is the name of the Wi-Fi network. It's empty for other network types, or when the
+	// platform doesn't have the permission to read it.
+	SSID string `json:"ssid,omitempty"`
+}
+
+// Config is the connect-on-demand configuration.
+type Config struct {
+	// Default is the action when no rule matches. Defaults to "ignore".
+	Default Action `json:"default,omitempty"`
+
+	// Rules are evaluated in order, and the first one that matches decides the action.
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig is a connect-on-demand rule. It matches if the network matches any of the
+// NetworkTypes (or there are none) and any of the SSIDs (or there are none), and the domain
+// matches any of the Domains (or there are none).
+//
+// Rules with Domains only apply to [Engine.EvaluateDomain], since there is no domain to match on
+// a network change.
+type RuleConfig struct {
+	Action Action `json:"action"`
+
+	NetworkTypes []NetworkType `json:"networkTypes,omitempty"`
+
+	// SSIDs match the name of the Wi-Fi network exactly.
+	SSIDs []string `json:"ssids,omitempty"`
+
+	// Domains match the domain and all its subdomains, e.g. "example.com" matches
+	// "www.example.com".
+	Domains []string `json:"domains,omitempty"`
+}
+
+// Engine evaluates connect-on-demand rules.
+type Engine struct {
+	defaultAction Action
+	rules         []rule
+}
+
+type rule struct {
+	action       Action
+	networkTypes []NetworkType
+	ssids        []string
+	domains      []string
+}
+
+// NewEngine validates conf and creates an [Engine] from it.
+func NewEngine(conf Config) (*Engine, error) {
+	e := &Engine{defaultAction: ActionIgnore}
+	if conf.Default != "" {
+		if err := routing.ValidateAction(conf.Default, actions...); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+		e.defaultAction = conf.Default
+	}
+	for i, rc := range conf.Rules {
+		rule, err := newRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		e.rules = append(e.rules, rule)
+	}
+	return e, nil
+}
+
+func newRule(rc RuleConfig) (rule, error) {
+	if err := routing.ValidateAction(rc.Action, actions...); err != nil {
+		return rule{}, err
+	}
+	domains, err := routing.ParseDomains(rc.Domains)
+	if err != nil {
+		return rule{}, err
+	}
+	r := rule{action: rc.Action, ssids: rc.SSIDs, domains: domains}
+	for _, t := range rc.NetworkTypes {
+		switch t {
+		case NetworkWiFi, NetworkCellular, NetworkEthernet, NetworkOther, NetworkNone:
+			r.networkTypes = append(r.networkTypes, t)
+		default:
+			return rule{}, fmt.Errorf("unsupported network type %q", t)
+		}
+	}
+	return r, nil
+}
+
+// Evaluate returns the action for the device joining network.
+func (e *Engine) Evaluate(network Network) Action {
+	for _, rule := range e.rules {
+		if len(rule.domains) == 0 && rule.matchesNetwork(network) {
+			return rule.action
+		}
+	}
+	return e.defaultAction
+}
+
+// EvaluateDomain returns the action for a lookup of domain while the device is on network. Rules
+// without Domains also apply, so that e.g. a "disconnect on the home Wi-Fi" rule listed first
+// takes precedence.
+func (e *Engine) EvaluateDomain(network Network, domain string) Action {
+	domain = routing.NormalizeDomain(domain)
+	for _, rule := range e.rules {
+		if rule.matchesNetwork(network) && rule.matchesDomain(domain) {
+			return rule.action
+		}
+	}
+	return e.defaultAction
+}
+
+func (r *rule) matchesNetwork(network Network) bool {
+	if len(r.networkTypes) > 0 && !slices.Contains(r.networkTypes, network.Type) {
+		return false
+	}
+	return len(r.ssids) == 0 || (network.SSID != "" && slices.Contains(r.ssids, network.SSID))
+}
+
+func (r *rule) matchesDomain(domain string) bool {
+	return len(r.domains) == 0 || routing.MatchesDomain(domain, r.domains)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ondemand
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine, err := NewEngine(Config{
+		Rules: []RuleConfig{
+			{Action: ActionDisconnect, NetworkTypes: []NetworkType{NetworkWiFi}, SSIDs: []string{"Home", "Office"}},
+			{Action: ActionConnect, Domains: []string{"example.com"}},
+			{Action: ActionIgnore, NetworkTypes: []NetworkType{NetworkNone}},
+			{Action: ActionConnect, NetworkTypes: []NetworkType{NetworkWiFi, NetworkCellular}},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		network Network
+		want    Action
+	}{
+		{Network{Type: NetworkWiFi, SSID: "Home"}, ActionDisconnect},
+		{Network{Type: NetworkWiFi, SSID: "home"}, ActionConnect},
+		{Network{Type: NetworkWiFi}, ActionConnect},
+		{Network{Type: NetworkCellular}, ActionConnect},
+		{Network{Type: NetworkEthernet}, ActionIgnore},
+		{Network{Type: NetworkNone}, ActionIgnore},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, engine.Evaluate(tt.network), tt.network)
+	}
+}
+
+func TestEngine_EvaluateDomain(t *testing.T) {
+	engine, err := NewEngine(Config{
+		Default: ActionDisconnect,
+		Rules: []RuleConfig{
+			{Action: ActionIgnore, SSIDs: []string{"Home"}},
+			{Action: ActionConnect, Domains: []string{"Example.com."}},
+		},
+	})
+	require.NoError(t, err)
+
+	cellular := Network{Type: NetworkCellular}
+	require.Equal(t, ActionConnect, engine.EvaluateDomain(cellular, "example.com"))
+	require.Equal(t, ActionConnect, engine.EvaluateDomain(cellular, "www.EXAMPLE.com."))
+	require.Equal(t, ActionDisconnect, engine.EvaluateDomain(cellular, "notexample.com"))
+	require.Equal(t, ActionIgnore, engine.EvaluateDomain(Network{Type: NetworkWiFi, SSID: "Home"}, "example.com"))
+	// Domain rules don't apply to network changes.
+	require.Equal(t, ActionDisconnect, engine.Evaluate(cellular))
+}
+
+func TestNewEngine_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+	}{
+		{"default", Config{Default: "maybe"}},
+		{"action", Config{Rules: []RuleConfig{{Action: "proxy"}}}},
+		{"network type", Config{Rules: []RuleConfig{{Action: ActionConnect, NetworkTypes: []NetworkType{"5g"}}}}},
+		{"empty domain", Config{Rules: []RuleConfig{{Action: ActionConnect, Domains: []string{" "}}}}},
+	}
+	for _, tt := range tests {
+		_, err := NewEngine(tt.conf)
+		require.Error(t, err, tt.name)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/ondemand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnDemand(t *testing.T) {
+	l := &fakeEventListener{events: make(chan [2]string, 2)}
+	SetEventListener(l)
+	defer SetEventListener(nil)
+	defer setOnDemandRules("null")
+
+	// Disabled.
+	action, err := notifyNetworkChanged(`{"type": "wifi", "ssid": "Home"}`)
+	require.NoError(t, err)
+	require.Equal(t, ondemand.ActionIgnore, action)
+
+	// Enabling evaluates the rules against the current network.
+	require.NoError(t, setOnDemandRules(`{"rules": [
+		{"action": "disconnect", "ssids": ["Home"]},
+		{"action": "connect", "domains": ["example.com"]},
+		{"action": "connect", "networkTypes": ["cellular"]}
+	]}`))
+	var data onDemandActionEventJSON
+	ev := <-l.events
+	require.Equal(t, EventOnDemandAction, ev[0])
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &data))
+	require.Equal(t, onDemandActionEventJSON{
+		Action:  ondemand.ActionDisconnect,
+		Network: ondemand.Network{Type: ondemand.NetworkWiFi, SSID: "Home"},
+	}, data)
+
+	action, err = notifyNetworkChanged(`{"type": "cellular"}`)
+	require.NoError(t, err)
+	require.Equal(t, ondemand.ActionConnect, action)
+	ev = <-l.events
+	require.NoError(t, json.Unmarshal([]byte(ev[1]), &data))
+	require.Equal(t, ondemand.ActionConnect, data.Action)
+
+	// Ignored actions aren't emitted.
+	action, err = notifyNetworkChanged(`{"type": "ethernet"}`)
+	require.NoError(t, err)
+	require.Equal(t, ondemand.ActionIgnore, action)
+	require.Empty(t, l.events)
+	require.Equal(t, ondemand.ActionConnect, evaluateOnDemandDomain("www.example.com"))
+
+	_, err = notifyNetworkChanged("wifi")
+	require.Error(t, err)
+	require.Error(t, setOnDemandRules(`{"rules": [{"action": "proxy"}]}`))
+}
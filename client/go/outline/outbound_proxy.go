@@ -0,0 +1,197 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+const (
+	// outboundProxySystem selects the proxy of the system settings, as found in the HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY environment variables.
+	outboundProxySystem = "system"
+
+	// outboundProxyDirect disables the outbound proxy.
+	outboundProxyDirect = "direct"
+)
+
+// resolveOutboundProxy returns the URL of the proxy to connect to target through, given the
+// outboundProxy option: "system", "direct", or an http://, socks5:// or socks5h:// URL. Only the
+// HTTP proxies may have credentials. It returns nil for a direct connection.
+func resolveOutboundProxy(option string, target *url.URL) (*url.URL, error) {
+	switch option {
+	case "", outboundProxyDirect:
+		return nil, nil
+	case outboundProxySystem:
+		proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: target})
+		if err != nil || proxyURL == nil {
+			return nil, err
+		}
+		return proxyURL, validateOutboundProxyURL(proxyURL)
+	}
+	proxyURL, err := url.Parse(option)
+	if err != nil {
+		return nil, newIllegalOutboundProxyError("", err)
+	}
+	return proxyURL, validateOutboundProxyURL(proxyURL)
+}
+
+// validateOutboundProxyURL checks that proxyURL is a supported proxy URL.
+func validateOutboundProxyURL(proxyURL *url.URL) error {
+	switch proxyURL.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return newIllegalOutboundProxyError(proxyURL.Redacted(), fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme))
+	}
+	if proxyURL.Hostname() == "" {
+		return newIllegalOutboundProxyError(proxyURL.Redacted(), fmt.Errorf("missing proxy host"))
+	}
+	if proxyURL.Scheme != "http" && proxyURL.User != nil {
+		return newIllegalOutboundProxyError(proxyURL.Redacted(), fmt.Errorf("SOCKS5 authentication is not supported"))
+	}
+	return nil
+}
+
+func newIllegalOutboundProxyError(got string, cause error) error {
+	return newIllegalConfigErrorWithDetails("outbound proxy is not valid", "outboundProxy", got,
+		`"system", "direct", or an http://, socks5:// or socks5h:// URL`, cause)
+}
+
+// outboundProxyDialer returns the dialer tunneling the TCP connections to the proxy server at
+// address through the outbound proxy of the config, connecting to the outbound proxy with
+// tcpDialer. It returns nil if the connections are direct.
+//
+// Only TCP goes through the outbound proxy. UDP is still sent directly, so udpOverTcp should be
+// enabled on networks that only allow proxied connections.
+func (conf *configJSON) outboundProxyDialer(address string, tcpDialer net.Dialer) (transport.StreamDialer, error) {
+	proxyURL, err := resolveOutboundProxy(conf.OutboundProxy, &url.URL{Scheme: "https", Host: address})
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	logger.Debug("using outbound proxy", "scheme", proxyURL.Scheme)
+	return newOutboundProxyDialer(proxyURL, tcpDialer)
+}
+
+// newOutboundProxyDialer creates a [transport.StreamDialer] connecting through the HTTP or SOCKS5
+// proxy at proxyURL, which must have been validated with [validateOutboundProxyURL].
+func newOutboundProxyDialer(proxyURL *url.URL, tcpDialer net.Dialer) (transport.StreamDialer, error) {
+	if proxyURL.Scheme == "http" {
+		return &httpConnectDialer{
+			address: proxyAddressWithDefaultPort(proxyURL, "80"),
+			user:    proxyURL.User,
+			dialer:  tcpDialer,
+		}, nil
+	}
+	socksDialer, err := socks5.NewStreamDialer(&transport.StreamDialerEndpoint{
+		Dialer:  &transport.TCPDialer{Dialer: tcpDialer},
+		Address: proxyAddressWithDefaultPort(proxyURL, "1080"),
+	})
+	if err != nil {
+		return nil, newIllegalOutboundProxyError(proxyURL.Redacted(), err)
+	}
+	return socksDialer, nil
+}
+
+// proxyAddressWithDefaultPort returns the host:port address of proxyURL, with port defaultPort
+// if the URL has none.
+func proxyAddressWithDefaultPort(proxyURL *url.URL, defaultPort string) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// httpConnectDialer is a [transport.StreamDialer] tunneling the connections through an HTTP
+// proxy with the CONNECT method.
+type httpConnectDialer struct {
+	address string
+	user    *url.Userinfo
+	dialer  net.Dialer
+}
+
+var _ transport.StreamDialer = (*httpConnectDialer)(nil)
+
+func (d *httpConnectDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, err
+	}
+	// Abort the CONNECT handshake when ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	reader, err := d.connect(conn, addr)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tcpConn := conn.(*net.TCPConn)
+	if reader.Buffered() == 0 {
+		return tcpConn, nil
+	}
+	return &bufferedStreamConn{StreamConn: tcpConn, reader: reader}, nil
+}
+
+// connect sends the CONNECT request for addr over conn, and reads the response of the proxy. It
+// returns the reader of conn, which may have buffered data sent after the response.
+func (d *httpConnectDialer) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.user != nil {
+		password, _ := d.user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send the CONNECT request: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CONNECT response: %w", err)
+	}
+	// The body of a CONNECT response is the tunnel, so it's not read nor closed.
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("outbound proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+	return reader, nil
+}
+
+// bufferedStreamConn is a [transport.StreamConn] reading the data buffered by reader first.
+type bufferedStreamConn struct {
+	transport.StreamConn
+	reader *bufio.Reader
+}
+
+func (c *bufferedStreamConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
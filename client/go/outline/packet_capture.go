@@ -0,0 +1,89 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pcap"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// packetCaptureJSON is the input of [MethodStartPacketCapture].
+type packetCaptureJSON struct {
+	// Path is the absolute path of the capture file, e.g. in the cache directory of the app.
+	Path string `json:"path"`
+
+	// Full captures the full packets in the pcap format, instead of their metadata. It must be
+	// explicitly enabled, since the packets contain the traffic of the user.
+	Full bool `json:"full,omitempty"`
+
+	// MaxBytes caps the size of the capture file. Defaults to 16 MiB.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// startPacketCapture parses the input as a packetCaptureJSON, and starts capturing the packets
+// of the tunnel, replacing the capture in progress, if any.
+func startPacketCapture(input string) error {
+	var req packetCaptureJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid packet capture request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	opts := pcap.Options{Path: req.Path, Mode: pcap.ModeMetadata, MaxBytes: req.MaxBytes}
+	if req.Full {
+		opts.Mode = pcap.ModeFull
+	}
+	if err := pcap.Start(opts); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to start the packet capture",
+			Details: platerrors.ErrorDetails{"path": req.Path},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	logger.Warn("packet capture started", "mode", opts.Mode)
+	return nil
+}
+
+// stopPacketCapture stops the capture in progress, and returns a JSON string of its pcap.Stats,
+// or null if there was none.
+func stopPacketCapture() (string, error) {
+	stats, ok, err := pcap.Stop()
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to write the packet capture",
+			Details: platerrors.ErrorDetails{"path": stats.Path},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if !ok {
+		return "null", nil
+	}
+	logger.Info("packet capture stopped", "packets", stats.Packets, "truncated", stats.Truncated)
+	out, err := json.Marshal(stats)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal packet capture stats",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
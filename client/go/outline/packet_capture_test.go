@@ -0,0 +1,46 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pcap"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketCapture(t *testing.T) {
+	out, err := stopPacketCapture()
+	require.NoError(t, err)
+	require.Equal(t, "null", out)
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	require.NoError(t, startPacketCapture(`{"path":"`+path+`","full":true}`))
+	require.True(t, pcap.Active())
+	out, err = stopPacketCapture()
+	require.NoError(t, err)
+	var stats pcap.Stats
+	require.NoError(t, json.Unmarshal([]byte(out), &stats))
+	require.Equal(t, pcap.Stats{Path: path, Mode: pcap.ModeFull, Bytes: 24}, stats)
+
+	err = startPacketCapture(`{"path":""}`)
+	perr, ok := err.(platerrors.PlatformError)
+	require.True(t, ok)
+	require.Equal(t, platerrors.InternalError, perr.Code)
+	require.Error(t, startPacketCapture(`not json`))
+}
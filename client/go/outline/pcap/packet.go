@@ -0,0 +1,168 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcap
+
+import (
+	"bytes"
+	"net/netip"
+	"time"
+)
+
+// IP protocol numbers.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// Metadata describes a packet of a [ModeMetadata] capture.
+type Metadata struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"dir"`
+	Protocol  string    `json:"proto"`
+	Src       string    `json:"src,omitempty"`
+	Dst       string    `json:"dst,omitempty"`
+	Length    int       `json:"len"`
+
+	// PayloadLength is the size of the TCP or UDP payload.
+	PayloadLength int `json:"payloadLen,omitempty"`
+
+	// Flags are the TCP flags, like "SA" for SYN-ACK.
+	Flags string `json:"flags,omitempty"`
+}
+
+// parsePacket returns the metadata of the IP packet pkt, without time and direction.
+func parsePacket(pkt []byte) Metadata {
+	meta := Metadata{Length: len(pkt), Protocol: "unknown"}
+	proto, src, dst, l4, ok := parseIP(pkt)
+	if !ok {
+		return meta
+	}
+	var srcPort, dstPort uint16
+	switch proto {
+	case protoTCP:
+		meta.Protocol = "tcp"
+		if len(l4) >= 20 {
+			srcPort, dstPort = uint16(l4[0])<<8|uint16(l4[1]), uint16(l4[2])<<8|uint16(l4[3])
+			if offset := int(l4[12]>>4) * 4; offset >= 20 && offset <= len(l4) {
+				meta.PayloadLength = len(l4) - offset
+			}
+			meta.Flags = tcpFlags(l4[13])
+		}
+	case protoUDP:
+		meta.Protocol = "udp"
+		if len(l4) >= 8 {
+			srcPort, dstPort = uint16(l4[0])<<8|uint16(l4[1]), uint16(l4[2])<<8|uint16(l4[3])
+			meta.PayloadLength = len(l4) - 8
+		}
+	case protoICMP:
+		meta.Protocol = "icmp"
+	case protoICMPv6:
+		meta.Protocol = "icmpv6"
+	}
+	if srcPort != 0 || dstPort != 0 {
+		meta.Src = netip.AddrPortFrom(src, srcPort).String()
+		meta.Dst = netip.AddrPortFrom(dst, dstPort).String()
+	} else {
+		meta.Src, meta.Dst = src.String(), dst.String()
+	}
+	return meta
+}
+
+// parseIP returns the protocol, the addresses and the payload of the IPv4 or IPv6 packet pkt.
+// The IPv6 extension headers are not parsed.
+func parseIP(pkt []byte) (proto byte, src, dst netip.Addr, payload []byte, ok bool) {
+	if len(pkt) == 0 {
+		return
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if len(pkt) < 20 || ihl < 20 || ihl > len(pkt) {
+			return
+		}
+		src, _ = netip.AddrFromSlice(pkt[12:16])
+		dst, _ = netip.AddrFromSlice(pkt[16:20])
+		return pkt[9], src, dst, pkt[ihl:], true
+	case 6:
+		if len(pkt) < 40 {
+			return
+		}
+		src, _ = netip.AddrFromSlice(pkt[8:24])
+		dst, _ = netip.AddrFromSlice(pkt[24:40])
+		return pkt[6], src, dst, pkt[40:], true
+	}
+	return
+}
+
+func tcpFlags(b byte) string {
+	var flags []byte
+	for i, f := range []byte("FSRPAU") {
+		if b&(1<<i) != 0 {
+			flags = append(flags, f)
+		}
+	}
+	return string(flags)
+}
+
+// sensitiveHeaders are the HTTP headers whose values are scrubbed from the plaintext payloads.
+var sensitiveHeaders = [][]byte{
+	[]byte("\nauthorization:"),
+	[]byte("\nproxy-authorization:"),
+	[]byte("\ncookie:"),
+	[]byte("\nset-cookie:"),
+}
+
+// scrubCredentials overwrites the values of the sensitive HTTP headers in the TCP payload of the
+// IP packet pkt with asterisks.
+func scrubCredentials(pkt []byte) {
+	proto, _, _, l4, ok := parseIP(pkt)
+	if !ok || proto != protoTCP || len(l4) < 20 {
+		return
+	}
+	offset := int(l4[12]>>4) * 4
+	if offset < 20 || offset >= len(l4) {
+		return
+	}
+	payload := l4[offset:]
+	// Unlike bytes.ToLower, this keeps the offsets of the binary payloads.
+	lower := make([]byte, len(payload))
+	for i, c := range payload {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	for _, header := range sensitiveHeaders {
+		for start := 0; ; {
+			i := bytes.Index(lower[start:], header)
+			if i < 0 {
+				break
+			}
+			i += start + len(header)
+			end := bytes.IndexByte(payload[i:], '\r')
+			if end < 0 {
+				end = len(payload) - i
+			}
+			for j := i; j < i+end; j++ {
+				if payload[j] != ' ' {
+					payload[j] = '*'
+				}
+			}
+			start = i + end
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pcap captures the packets of the tunnel for debugging, either as metadata (addresses,
+// ports, sizes and TCP flags) or as a pcap file with the full packets.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mode is what is captured of the packets.
+type Mode string
+
+const (
+	// ModeMetadata writes a JSON object per packet, without payload.
+	ModeMetadata Mode = "metadata"
+
+	// ModeFull writes the packets in the pcap format, with their payload. The plaintext HTTP
+	// credentials of the payloads are scrubbed, which invalidates the checksums of their packets.
+	ModeFull Mode = "full"
+)
+
+// DefaultMaxBytes is the default size cap of the capture files.
+const DefaultMaxBytes = 16 * 1024 * 1024
+
+// linkTypeRaw is the pcap link type of the packets without link-layer header.
+const linkTypeRaw = 101
+
+// Options configure a capture.
+type Options struct {
+	// Path is the file the packets are written to. It's truncated.
+	Path string
+
+	Mode Mode
+
+	// MaxBytes caps the size of the file. The packets that don't fit are dropped. Defaults to
+	// [DefaultMaxBytes].
+	MaxBytes int64
+}
+
+// Stats describe a capture.
+type Stats struct {
+	Path    string `json:"path"`
+	Mode    Mode   `json:"mode"`
+	Packets int64  `json:"packets"`
+	Bytes   int64  `json:"bytes"`
+
+	// Truncated is whether packets were dropped because the file reached its size cap.
+	Truncated bool `json:"truncated"`
+}
+
+// capture is a capture in progress.
+type capture struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	max   int64
+	stats Stats
+}
+
+// active is the capture in progress, or nil.
+var active atomic.Pointer[capture]
+
+// startMu serializes [Start] and [Stop].
+var startMu sync.Mutex
+
+// Start starts capturing the packets of the tunnel to the file of opts, stopping the capture
+// in progress, if any.
+func Start(opts Options) error {
+	switch opts.Mode {
+	case ModeMetadata, ModeFull:
+	default:
+		return fmt.Errorf("unknown capture mode %q", opts.Mode)
+	}
+	if opts.Path == "" {
+		return errors.New("capture file path is empty")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+
+	startMu.Lock()
+	defer startMu.Unlock()
+	if prev := active.Swap(nil); prev != nil {
+		prev.close()
+	}
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	c := &capture{file: f, w: bufio.NewWriter(f), max: opts.MaxBytes, stats: Stats{Path: opts.Path, Mode: opts.Mode}}
+	if opts.Mode == ModeFull {
+		var header [24]byte
+		binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+		binary.LittleEndian.PutUint16(header[4:], 2)
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], 65535)
+		binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+		if !c.write(header[:]) {
+			f.Close()
+			return errors.New("capture size cap is too small")
+		}
+	}
+	active.Store(c)
+	return nil
+}
+
+// Stop stops the capture in progress, and returns its stats. It returns false if there was no
+// capture in progress.
+func Stop() (Stats, bool, error) {
+	startMu.Lock()
+	defer startMu.Unlock()
+	c := active.Swap(nil)
+	if c == nil {
+		return Stats{}, false, nil
+	}
+	err := c.close()
+	return c.stats, true, err
+}
+
+// Active returns whether a capture is in progress.
+func Active() bool {
+	return active.Load() != nil
+}
+
+// Record captures the IP packet pkt, going out of the device if outgoing, or into it otherwise.
+// It does nothing if no capture is in progress.
+func Record(outgoing bool, pkt []byte) {
+	c := active.Load()
+	if c == nil {
+		return
+	}
+	c.record(time.Now(), outgoing, pkt)
+}
+
+// NewTapWriter returns a writer that records the packets written to w, one per write, before
+// writing them to w.
+func NewTapWriter(w io.Writer, outgoing bool) io.Writer {
+	return &tapWriter{w: w, outgoing: outgoing}
+}
+
+type tapWriter struct {
+	w        io.Writer
+	outgoing bool
+}
+
+func (t *tapWriter) Write(pkt []byte) (int, error) {
+	Record(t.outgoing, pkt)
+	return t.w.Write(pkt)
+}
+
+func (c *capture) record(now time.Time, outgoing bool, pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return
+	}
+	var rec []byte
+	if c.stats.Mode == ModeFull {
+		rec = make([]byte, 16, 16+len(pkt))
+		binary.LittleEndian.PutUint32(rec[0:], uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+		rec = append(rec, pkt...)
+		scrubCredentials(rec[16:])
+	} else {
+		meta := parsePacket(pkt)
+		meta.Time = now.UTC()
+		meta.Direction = "in"
+		if outgoing {
+			meta.Direction = "out"
+		}
+		var err error
+		if rec, err = json.Marshal(meta); err != nil {
+			return
+		}
+		rec = append(rec, '\n')
+	}
+	if c.write(rec) {
+		c.stats.Packets++
+	}
+}
+
+// write writes b unless it would exceed the size cap, and returns whether it did.
+func (c *capture) write(b []byte) bool {
+	if c.stats.Bytes+int64(len(b)) > c.max {
+		c.stats.Truncated = true
+		return false
+	}
+	n, _ := c.w.Write(b)
+	c.stats.Bytes += int64(n)
+	return true
+}
+
+func (c *capture) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return nil
+	}
+	err := errors.Join(c.w.Flush(), c.file.Close())
+	c.w, c.file = nil, nil
+	return err
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTCPv4Packet returns an IPv4 TCP packet from 10.0.0.1:1234 to 192.0.2.1:80.
+func newTCPv4Packet(flags byte, payload string) []byte {
+	pkt := make([]byte, 40, 40+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:], uint16(40+len(payload)))
+	pkt[9] = protoTCP
+	copy(pkt[12:], []byte{10, 0, 0, 1})
+	copy(pkt[16:], []byte{192, 0, 2, 1})
+	binary.BigEndian.PutUint16(pkt[20:], 1234)
+	binary.BigEndian.PutUint16(pkt[22:], 80)
+	pkt[32] = 5 << 4
+	pkt[33] = flags
+	return append(pkt, payload...)
+}
+
+// newUDPv6Packet returns an IPv6 UDP packet from [fd00::1]:5353 to [2001:db8::1]:53.
+func newUDPv6Packet(payload string) []byte {
+	pkt := make([]byte, 48, 48+len(payload))
+	pkt[0] = 0x60
+	pkt[6] = protoUDP
+	copy(pkt[8:], []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	copy(pkt[24:], []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	binary.BigEndian.PutUint16(pkt[40:], 5353)
+	binary.BigEndian.PutUint16(pkt[42:], 53)
+	return append(pkt, payload...)
+}
+
+func TestParsePacket(t *testing.T) {
+	require.Equal(t, Metadata{Protocol: "tcp", Src: "10.0.0.1:1234", Dst: "192.0.2.1:80", Length: 45,
+		PayloadLength: 5, Flags: "SA"}, parsePacket(newTCPv4Packet(0x12, "hello")))
+	require.Equal(t, Metadata{Protocol: "udp", Src: "[fd00::1]:5353", Dst: "[2001:db8::1]:53", Length: 50,
+		PayloadLength: 2}, parsePacket(newUDPv6Packet("hi")))
+	require.Equal(t, Metadata{Protocol: "unknown", Length: 3}, parsePacket([]byte{0x45, 0, 0}))
+}
+
+func TestScrubCredentials(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\nAuthorization: Bearer secret\r\ncookie: a=b\r\n\r\n"
+	pkt := newTCPv4Packet(0x18, request)
+	scrubCredentials(pkt)
+	require.Equal(t, "GET / HTTP/1.1\r\nHost: example.com\r\nAuthorization: ****** ******\r\ncookie: ***\r\n\r\n", string(pkt[40:]))
+
+	// UDP payloads are left alone.
+	pkt = newUDPv6Packet("\nCookie: a=b")
+	scrubCredentials(pkt)
+	require.Equal(t, "\nCookie: a=b", string(pkt[48:]))
+}
+
+func TestCapture_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	require.NoError(t, Start(Options{Path: path, Mode: ModeMetadata}))
+	require.True(t, Active())
+	Record(true, newTCPv4Packet(0x02, ""))
+	Record(false, newUDPv6Packet("hi"))
+	stats, ok, err := Stop()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, Active())
+	require.Equal(t, int64(2), stats.Packets)
+	require.False(t, stats.Truncated)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, stats.Bytes, int64(len(data)))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &meta))
+	require.Equal(t, "out", meta.Direction)
+	require.Equal(t, "S", meta.Flags)
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &meta))
+	require.Equal(t, "in", meta.Direction)
+	require.Equal(t, "udp", meta.Protocol)
+
+	// Nothing is recorded without a capture in progress.
+	Record(true, newTCPv4Packet(0x02, ""))
+	_, ok, err = Stop()
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestCapture_Full(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	require.NoError(t, Start(Options{Path: path, Mode: ModeFull, MaxBytes: 24 + 2*(16+60)}))
+	var buf bytes.Buffer
+	w := NewTapWriter(&buf, true)
+	request := newTCPv4Packet(0x18, "\r\nCookie: secret\r\n")
+	_, err := w.Write(request)
+	require.NoError(t, err)
+	// The packets written through are not scrubbed.
+	require.Equal(t, request, buf.Bytes())
+	Record(false, newTCPv4Packet(0x10, ""))
+	// The third packet exceeds the size cap.
+	Record(false, newTCPv4Packet(0x10, ""))
+	stats, _, err := Stop()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Packets)
+	require.True(t, stats.Truncated)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data))
+	require.Equal(t, uint32(linkTypeRaw), binary.LittleEndian.Uint32(data[20:]))
+	first := data[24:]
+	require.Equal(t, uint32(len(request)), binary.LittleEndian.Uint32(first[8:]))
+	require.Equal(t, "\r\nCookie: ******\r\n", string(first[16+40:16+len(request)]))
+	require.Len(t, data, 24+16+len(request)+16+40)
+}
+
+func TestStart_Invalid(t *testing.T) {
+	require.Error(t, Start(Options{Path: filepath.Join(t.TempDir(), "capture"), Mode: "everything"}))
+	require.Error(t, Start(Options{Mode: ModeMetadata}))
+	require.Error(t, Start(Options{Path: filepath.Join(t.TempDir(), "missing", "capture"), Mode: ModeMetadata}))
+	require.Error(t, Start(Options{Path: filepath.Join(t.TempDir(), "capture"), Mode: ModeFull, MaxBytes: 10}))
+	require.False(t, Active())
+}
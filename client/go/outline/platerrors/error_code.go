@@ -34,6 +34,17 @@ const (
 
 	// OperationCanceled means that user canceled the long running operation.
 	OperationCanceled ErrorCode = "ERR_OPERATION_CANCELED_BY_USER"
+
+	// UnknownMethod means that the platform invoked a Go method that doesn't exist, usually
+	// because the platform code and the Go library are from different versions.
+	UnknownMethod ErrorCode = "ERR_UNKNOWN_METHOD"
+
+	// InvalidMethodArguments means that the input of a Go method doesn't match its input type.
+	InvalidMethodArguments ErrorCode = "ERR_INVALID_METHOD_ARGUMENTS"
+
+	// FeatureNotSupported means that the requested feature is not available on this platform,
+	// e.g. the kill switch where the VPN can't block the traffic.
+	FeatureNotSupported ErrorCode = "ERR_FEATURE_NOT_SUPPORTED"
 )
 
 //////////
@@ -43,6 +54,14 @@ const (
 const (
 	// ResolveIPFailed means that we failed to resolve the IP address of a hostname.
 	ResolveIPFailed ErrorCode = "ERR_RESOLVE_IP_FAILURE"
+
+	// TLSIntercepted means that the TLS certificate of a server could not be verified, which
+	// typically indicates that the network intercepts TLS connections.
+	TLSIntercepted ErrorCode = "ERR_TLS_INTERCEPTED"
+
+	// CertificatePinMismatch means the certificate of a server doesn't match the public keys it
+	// is pinned to, which indicates that the network intercepts TLS connections.
+	CertificatePinMismatch ErrorCode = "ERR_CERTIFICATE_PIN_MISMATCH"
 )
 
 //////////
@@ -85,8 +104,17 @@ const (
 	// due to the lack of valid authentication credentials.
 	Unauthenticated ErrorCode = "ERR_CLIENT_UNAUTHENTICATED"
 
-	// ProxyServerUDPUnsupported means the remote proxy doesn't support relaying UDP traffic.
+	// ProxyServerUDPUnsupported means the remote proxy doesn't support relaying UDP traffic, or
+	// the network blocks it.
 	ProxyServerUDPUnsupported ErrorCode = "ERR_PROXY_SERVER_UDP_NOT_SUPPORTED"
+
+	// QuotaExceeded means the provider refused the request because the user ran out of quota,
+	// e.g. their subscription expired or they used up their data.
+	QuotaExceeded ErrorCode = "ERR_QUOTA_EXCEEDED"
+
+	// DeviceClockSkewed means the clock of the device is too far from the actual time, so the
+	// server rejects the handshakes as replays, e.g. with Shadowsocks 2022.
+	DeviceClockSkewed ErrorCode = "ERR_DEVICE_CLOCK_SKEWED"
 )
 
 //////////
@@ -99,4 +127,48 @@ const (
 
 	// IllegalConfig indicates an invalid config to connect to a remote server.
 	IllegalConfig ErrorCode = "ERR_ILLEGAL_CONFIG"
+
+	// ConfigRequiresNewerApp means the config has a version of the format that is newer than the
+	// ones this app supports, so the user must update the app.
+	ConfigRequiresNewerApp ErrorCode = "ERR_CONFIG_REQUIRES_NEWER_APP"
+
+	// ConfigSignatureInvalid means a dynamic config is not signed by the provider, which
+	// indicates that it was tampered with.
+	ConfigSignatureInvalid ErrorCode = "ERR_CONFIG_SIGNATURE_INVALID"
+
+	// ConfigTooLarge means a config exceeds the limits on the size or the nesting depth, which
+	// only a malicious or broken provider would serve.
+	ConfigTooLarge ErrorCode = "ERR_CONFIG_TOO_LARGE"
+)
+
+//////////
+// Remediation hints
+//////////
+
+// Hint is a machine-readable remediation of a [PlatformError], which clients can turn into
+// localized guidance. It is set in the [HintDetailsKey] entry of the [ErrorDetails].
+type Hint = string
+
+// HintDetailsKey is the key of the [Hint] in the [ErrorDetails].
+const HintDetailsKey = "hint"
+
+const (
+	// HintCheckInternet asks the user to check that they are connected to the internet.
+	HintCheckInternet Hint = "check-internet"
+
+	// HintCheckAccessKey asks the user to check that their access key is correct and up to date.
+	HintCheckAccessKey Hint = "check-access-key"
+
+	// HintUntrustedNetwork asks the user to switch to a network that doesn't intercept their
+	// connections, e.g. from a public Wi-Fi to mobile data.
+	HintUntrustedNetwork Hint = "untrusted-network"
+
+	// HintEnableUDPOverTCP suggests using a server or key that relays UDP over TCP.
+	HintEnableUDPOverTCP Hint = "enable-udp-over-tcp"
+
+	// HintContactProvider asks the user to contact their access key provider.
+	HintContactProvider Hint = "contact-provider"
+
+	// HintFixDeviceClock asks the user to set the date and time of their device automatically.
+	HintFixDeviceClock Hint = "fix-device-clock"
 )
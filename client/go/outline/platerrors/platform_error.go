@@ -68,6 +68,17 @@ func ToPlatformError(err error) *PlatformError {
 	return &PlatformError{Code: InternalError, Message: err.Error()}
 }
 
+// WithHint returns a copy of e with the remediation hint set in its details.
+func (e PlatformError) WithHint(hint Hint) PlatformError {
+	details := make(ErrorDetails, len(e.Details)+1)
+	for k, v := range e.Details {
+		details[k] = v
+	}
+	details[HintDetailsKey] = hint
+	e.Details = details
+	return e
+}
+
 // Error returns a JSON string containing the error details and all its underlying causes,
 // until it finds a cause that is not a [PlatformError].
 // The resulting JSON can be used to reconstruct the error in TypeScript.
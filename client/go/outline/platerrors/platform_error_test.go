@@ -129,6 +129,20 @@ func TestEmptyErrorCode(t *testing.T) {
 	require.Equal(t, InternalError, pe.Code)
 }
 
+func TestWithHint(t *testing.T) {
+	details := ErrorDetails{"url": "https://example.com"}
+	e := PlatformError{Code: FetchConfigFailed, Message: "fetch failed", Details: details}
+
+	got := e.WithHint(HintCheckInternet)
+	require.Equal(t, ErrorDetails{"url": "https://example.com", HintDetailsKey: HintCheckInternet}, got.Details)
+	require.NotContains(t, details, HintDetailsKey)
+
+	quota := PlatformError{Code: QuotaExceeded, Message: "quota"}.WithHint(HintContactProvider)
+	js, err := MarshalJSONString(&quota)
+	require.NoError(t, err)
+	require.Equal(t, `{"code":"ERR_QUOTA_EXCEEDED","message":"quota","details":{"hint":"contact-provider"}}`, js)
+}
+
 // Test the output when json.Marshal returns an error, which should not happen.
 // But we want to make sure if it happens the returned JSON is well-formatted.
 func TestJSONMarshalError(t *testing.T) {
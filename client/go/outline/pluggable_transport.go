@@ -0,0 +1,78 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"net"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pt"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// pluggableTransportConfigJSON is the "pluggableTransport" section of a Shadowsocks config. The
+// TCP connections to the proxy server go through the pluggable transport, whose server forwards
+// them to the Shadowsocks server: the host and the port of the config are the ones of the
+// pluggable transport server.
+//
+// The transport must be built into the app, or its executable registered by the platform with
+// [RegisterManagedPluggableTransport]: the configs come from the providers, so they only select
+// the transport by name, and never the executables that run.
+//
+// Example:
+//
+//	{"name": "obfs4", "args": {"cert": "...", "iat-mode": "0"}}
+type pluggableTransportConfigJSON struct {
+	// Name is the name of the transport, e.g. "obfs4".
+	Name string `json:"name"`
+
+	// Args are the arguments of the server, like the "cert" and "iat-mode" of obfs4.
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// RegisterManagedPluggableTransport registers the executable shipped with the app, or installed by
+// the platform, of the managed pluggable transport name, following the Tor Pluggable Transport
+// specification, like lyrebird for obfs4. command and stateDir, which may be empty, are absolute
+// paths.
+func RegisterManagedPluggableTransport(name, command, stateDir string) error {
+	if err := pt.RegisterManaged(name, pt.Managed{Command: command, StateDir: stateDir}); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to register the pluggable transport",
+			Details: platerrors.ErrorDetails{"name": name},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return nil
+}
+
+// streamDialer returns the dialer tunneling the TCP connections to the proxy server through the
+// pluggable transport. In-process transports connect with tcpDialer. If noSubprocesses is true,
+// the managed transports are rejected.
+//
+// Only TCP goes through the pluggable transport. UDP is still sent directly, so udpOverTcp
+// should be enabled.
+func (c *pluggableTransportConfigJSON) streamDialer(tcpDialer net.Dialer, noSubprocesses bool) (transport.StreamDialer, error) {
+	if c.Name == "" {
+		return nil, newIllegalConfigErrorWithDetails("pluggable transport has no name",
+			"pluggableTransport.name", c.Name, "a transport name, like obfs4", nil)
+	}
+	sd, err := pt.NewStreamDialer(pt.Config{Name: c.Name, Args: c.Args, NoSubprocesses: noSubprocesses}, tcpDialer)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("pluggable transport is not supported",
+			"pluggableTransport.name", c.Name, "a built-in or registered transport", err)
+	}
+	return sd, nil
+}
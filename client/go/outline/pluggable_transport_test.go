@@ -0,0 +1,80 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+// registerTestPluggableTransport registers lyrebird as the "obfs4" transport, once for all the
+// tests, since the transports can't be unregistered.
+var registerTestPluggableTransport = sync.OnceValue(func() error {
+	return RegisterManagedPluggableTransport("obfs4", "/usr/bin/lyrebird", "")
+})
+
+func TestPluggableTransport(t *testing.T) {
+	require.NoError(t, registerTestPluggableTransport())
+	got := NewClient(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd",` +
+		`"pluggableTransport":{"name":"obfs4","args":{"cert":"abc","iat-mode":"0"}}}`)
+	require.Nil(t, got.Error)
+
+	desc, err := describeTransport(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd",` +
+		`"pluggableTransport":{"name":"obfs4","args":{"cert":"abc"}}}`)
+	require.NoError(t, err)
+	require.NotContains(t, desc, "abc")
+	require.Contains(t, desc, `{"$type":"pluggable-transport","name":"obfs4","next":[{"$type":"endpoint","address":"tcp://192.0.2.1:443"`)
+	require.Contains(t, desc, `"next":[{"$type":"endpoint","address":"udp://192.0.2.1:443"`)
+}
+
+func TestPluggableTransport_Invalid(t *testing.T) {
+	require.NoError(t, registerTestPluggableTransport())
+	for _, pt := range []string{
+		`{"args":{"cert":"abc"}}`,
+		`{"name":"meek"}`,
+		// The configs can't choose the executables that run.
+		`{"name":"test-shell","command":"/bin/sh","commandArgs":["-c","id"]}`,
+	} {
+		config := `{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd","pluggableTransport":` + pt + `}`
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+
+	got := NewClient(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd",` +
+		`"outboundProxy":"socks5://127.0.0.1:1080","pluggableTransport":{"name":"obfs4"}}`)
+	require.NotNil(t, got.Error)
+	require.Equal(t, platerrors.IllegalConfig, got.Error.Code)
+}
+
+func TestPluggableTransport_NoSubprocesses(t *testing.T) {
+	require.NoError(t, registerTestPluggableTransport())
+	got := NewClientWithoutSubprocesses(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd",` +
+		`"pluggableTransport":{"name":"obfs4","args":{"cert":"abc"}}}`)
+	require.NotNil(t, got.Error)
+	require.Equal(t, platerrors.IllegalConfig, got.Error.Code)
+
+	got = NewClientWithoutSubprocesses(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"}`)
+	require.Nil(t, got.Error)
+}
+
+func TestRegisterManagedPluggableTransport(t *testing.T) {
+	require.NoError(t, registerTestPluggableTransport())
+	require.Error(t, RegisterManagedPluggableTransport("obfs4", "/usr/bin/lyrebird", ""), "a transport must be registered once")
+	require.Error(t, RegisterManagedPluggableTransport("test-relative", "lyrebird", ""))
+}
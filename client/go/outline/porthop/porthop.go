@@ -0,0 +1,163 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package porthop rotates the destination port of the UDP packets to a server listening on a
+// range of ports, against the networks throttling the UDP flows per port.
+package porthop
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+)
+
+const (
+	// DefaultInterval is the default interval of the hops.
+	DefaultInterval = 30 * time.Second
+
+	// MinInterval is the minimum interval of the hops.
+	MinInterval = 5 * time.Second
+
+	// lossTimeout is how long the server may not answer the packets before the conn hops to
+	// another port, as the port is probably blocked or throttled.
+	lossTimeout = 3 * time.Second
+)
+
+// Ports is a set of ports, parsed with [ParsePorts].
+type Ports []routing.PortRange
+
+// ParsePorts parses a list of ports and port ranges, like "443,20000-30000".
+func ParsePorts(s string) (Ports, error) {
+	var ports Ports
+	for _, part := range strings.Split(s, ",") {
+		r, err := routing.ParsePortRange(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if r.First == 0 {
+			return nil, fmt.Errorf("invalid ports %q: not a range of ports within [1..65535]", part)
+		}
+		ports = append(ports, r)
+	}
+	return ports, nil
+}
+
+// Contains returns whether port is one of ports.
+func (ports Ports) Contains(port int) bool {
+	for _, r := range ports {
+		if port >= int(r.First) && port <= int(r.Last) {
+			return true
+		}
+	}
+	return false
+}
+
+// Random returns one of ports, picked at random.
+func (ports Ports) Random() uint16 {
+	total := 0
+	for _, r := range ports {
+		total += int(r.Last-r.First) + 1
+	}
+	n := rand.Intn(total)
+	for _, r := range ports {
+		if size := int(r.Last-r.First) + 1; n >= size {
+			n -= size
+		} else {
+			return r.First + uint16(n)
+		}
+	}
+	panic("unreachable")
+}
+
+// conn is a packet conn sending the packets to a server at the current port of the server, and
+// receiving the packets from all its ports.
+type conn struct {
+	net.PacketConn
+	ports    Ports
+	interval time.Duration
+
+	mu sync.Mutex
+	// server is the address of the server the conn was given, which the packets of the server
+	// are received from, so that the hops are invisible to the protocol over the conn.
+	server *net.UDPAddr
+	// current is the address the packets are sent to.
+	current *net.UDPAddr
+	nextHop time.Time
+	// unansweredSince is the time of the first packet sent since the last one received.
+	unansweredSince time.Time
+}
+
+// NewPacketConn wraps pc to send the packets to the server at one of ports, hopping to another
+// every interval, or when the server stops answering. The packets can be sent to the server at
+// any of its ports, and are received from it with the address they are sent to.
+func NewPacketConn(pc net.PacketConn, ports Ports, interval time.Duration) net.PacketConn {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &conn{PacketConn: pc, ports: ports, interval: max(interval, MinInterval)}
+}
+
+// destination returns the address of the current port of server, hopping if it's time to.
+func (c *conn) destination(server *net.UDPAddr) *net.UDPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.server == nil || !c.server.IP.Equal(server.IP) {
+		c.server = server
+		c.current = server
+		c.nextHop = now.Add(c.interval)
+	}
+	lost := !c.unansweredSince.IsZero() && now.Sub(c.unansweredSince) > lossTimeout
+	if now.After(c.nextHop) || lost {
+		c.current = &net.UDPAddr{IP: server.IP, Port: int(c.ports.Random()), Zone: server.Zone}
+		c.nextHop = now.Add(c.interval)
+		c.unansweredSince = time.Time{}
+	}
+	if c.unansweredSince.IsZero() {
+		c.unansweredSince = now
+	}
+	return c.current
+}
+
+func (c *conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	server, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("port hopping needs a UDP address, not %v", addr)
+	}
+	return c.PacketConn.WriteTo(b, c.destination(server))
+}
+
+// ReadFrom reads the next packet, skipping the ones that aren't from a port of the server.
+func (c *conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+		from, ok := addr.(*net.UDPAddr)
+		c.mu.Lock()
+		server := c.server
+		if server != nil && ok && from.IP.Equal(server.IP) && (c.ports.Contains(from.Port) || from.Port == server.Port) {
+			c.unansweredSince = time.Time{}
+			c.mu.Unlock()
+			return n, server, nil
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package porthop
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("443, 20000-20002")
+	require.NoError(t, err)
+	require.Equal(t, Ports{{First: 443, Last: 443}, {First: 20000, Last: 20002}}, ports)
+	require.True(t, ports.Contains(443))
+	require.True(t, ports.Contains(20001))
+	require.False(t, ports.Contains(444))
+	for i := 0; i < 100; i++ {
+		require.True(t, ports.Contains(int(ports.Random())))
+	}
+
+	for _, s := range []string{"", "0", "20000-", "30000-20000", "20000-70000", "443,,444", "a-b"} {
+		_, err := ParsePorts(s)
+		require.Error(t, err, s)
+	}
+}
+
+// listenServer listens on n consecutive ports of the loopback interface.
+func listenServer(t *testing.T, n int) []net.PacketConn {
+	for attempt := 0; attempt < 10; attempt++ {
+		first, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		conns := []net.PacketConn{first}
+		for port := first.LocalAddr().(*net.UDPAddr).Port + 1; len(conns) < n; port++ {
+			pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			if err != nil {
+				break
+			}
+			conns = append(conns, pc)
+		}
+		if len(conns) == n {
+			t.Cleanup(func() {
+				for _, pc := range conns {
+					pc.Close()
+				}
+			})
+			return conns
+		}
+		for _, pc := range conns {
+			pc.Close()
+		}
+	}
+	t.Fatal("no consecutive free ports")
+	return nil
+}
+
+func TestPacketConn(t *testing.T) {
+	servers := listenServer(t, 3)
+	firstPort := servers[0].LocalAddr().(*net.UDPAddr).Port
+	ports := Ports{{First: uint16(firstPort), Last: uint16(firstPort + 2)}}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := NewPacketConn(pc, ports, 0).(*conn)
+	defer c.Close()
+	require.Equal(t, DefaultInterval, c.interval)
+
+	server := servers[0].LocalAddr().(*net.UDPAddr)
+	buf := make([]byte, 100)
+	// received reads the packet of the server it was sent to, and answers from its port.
+	received := func() int {
+		for i, s := range servers {
+			s.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, from, err := s.ReadFrom(buf)
+			if err == nil {
+				require.Equal(t, "ping", string(buf[:n]))
+				_, err = s.WriteTo([]byte("pong"), from)
+				require.NoError(t, err)
+				return i
+			}
+		}
+		t.Fatal("no server received the packet")
+		return -1
+	}
+	reply := func() {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := c.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "pong", string(buf[:n]))
+		require.Same(t, server, addr)
+	}
+
+	_, err = c.WriteTo([]byte("ping"), server)
+	require.NoError(t, err)
+	require.Equal(t, 0, received())
+	reply()
+
+	// Hops on schedule, and receives from the new port with the address of the server.
+	hopped := false
+	for i := 0; i < 20 && !hopped; i++ {
+		c.mu.Lock()
+		c.nextHop = time.Now().Add(-time.Second)
+		c.mu.Unlock()
+		_, err = c.WriteTo([]byte("ping"), server)
+		require.NoError(t, err)
+		hopped = received() != 0
+		reply()
+	}
+	require.True(t, hopped)
+
+	// Hops when the server stops answering.
+	c.mu.Lock()
+	c.current = server
+	c.nextHop = time.Now().Add(time.Hour)
+	c.unansweredSince = time.Now().Add(-2 * lossTimeout)
+	c.mu.Unlock()
+	require.True(t, ports.Contains(c.destination(server).Port))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.WithinDuration(t, time.Now().Add(c.interval), c.nextHop, time.Second)
+}
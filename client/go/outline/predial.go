@@ -0,0 +1,178 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+const (
+	defaultPreDialPoolSize    = 2
+	maxPreDialPoolSize        = 16
+	defaultPreDialIdleTimeout = 20 * time.Second
+)
+
+// preDialConfigJSON is the "preDial" section of a Shadowsocks config. It keeps a pool of
+// connections to the proxy server ready, so that the new TCP flows, e.g. the page loads, don't
+// wait for the TCP handshake, nor for the handshake of the outbound proxy or the pluggable
+// transport. The Shadowsocks handshake still happens on the first write, as it carries the
+// destination of the flow.
+type preDialConfigJSON struct {
+	// PoolSize is the number of connections kept ready, up to 16. Defaults to 2.
+	PoolSize int `json:"poolSize,omitempty"`
+
+	// IdleSeconds is how long a connection stays ready before it's closed, which must be shorter
+	// than the time the server waits for the first bytes of a connection. Defaults to 20 seconds.
+	IdleSeconds int `json:"idleSeconds,omitempty"`
+}
+
+// validate checks the bounds of the config.
+func (c *preDialConfigJSON) validate() error {
+	if c.PoolSize < 0 || c.PoolSize > maxPreDialPoolSize {
+		return newIllegalConfigErrorWithDetails("pre-dial pool size is not valid",
+			"preDial.poolSize", c.PoolSize, "a number of connections within range [0..16]", nil)
+	}
+	if c.IdleSeconds < 0 {
+		return newIllegalConfigErrorWithDetails("pre-dial idle time is not valid",
+			"preDial.idleSeconds", c.IdleSeconds, "a positive number of seconds", nil)
+	}
+	return nil
+}
+
+// streamEndpoint returns endpoint connecting from a pool of ready connections. c may be nil.
+func (c *preDialConfigJSON) streamEndpoint(endpoint transport.StreamEndpoint) transport.StreamEndpoint {
+	if c == nil {
+		return endpoint
+	}
+	e := &preDialEndpoint{endpoint: endpoint, size: defaultPreDialPoolSize, idleTimeout: defaultPreDialIdleTimeout}
+	if c.PoolSize > 0 {
+		e.size = c.PoolSize
+	}
+	if c.IdleSeconds > 0 {
+		e.idleTimeout = time.Duration(c.IdleSeconds) * time.Second
+	}
+	return e
+}
+
+// preDialEndpoint connects with the ready connections of its pool, and refills it after each
+// connection. The pool is only filled once the endpoint is used, and it's not refilled as its
+// connections expire, so that an idle tunnel doesn't keep connecting to the server.
+type preDialEndpoint struct {
+	endpoint    transport.StreamEndpoint
+	size        int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	pool    []*preDialedConn
+	dialing int
+	// generation is the network generation of the connections of the pool, which are discarded
+	// after a network change.
+	generation uint64
+}
+
+// preDialedConn is a connection of the pool, closed by expiry once it idled for too long.
+type preDialedConn struct {
+	conn   transport.StreamConn
+	expiry *time.Timer
+}
+
+var _ transport.StreamEndpoint = (*preDialEndpoint)(nil)
+
+func (e *preDialEndpoint) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	conn := e.take()
+	e.refill()
+	if conn != nil {
+		return conn, nil
+	}
+	return e.endpoint.ConnectStream(ctx)
+}
+
+// take returns the most recent connection of the pool, or nil if it is empty.
+func (e *preDialEndpoint) take() transport.StreamConn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.discardOutdated()
+	for len(e.pool) > 0 {
+		c := e.pool[len(e.pool)-1]
+		e.pool = e.pool[:len(e.pool)-1]
+		// The connection is closed if it just expired.
+		if c.expiry.Stop() {
+			return c.conn
+		}
+	}
+	return nil
+}
+
+// discardOutdated closes the connections of the pool dialed on a previous network.
+func (e *preDialEndpoint) discardOutdated() {
+	generation := networkGeneration.Load()
+	if generation == e.generation {
+		return
+	}
+	for _, c := range e.pool {
+		if c.expiry.Stop() {
+			c.conn.Close()
+		}
+	}
+	e.pool = nil
+	e.generation = generation
+}
+
+// refill dials the connections missing from the pool in the background.
+func (e *preDialEndpoint) refill() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ; len(e.pool)+e.dialing < e.size; e.dialing++ {
+		resources.Go(resources.SubsystemPreDial, e.dial)
+	}
+}
+
+func (e *preDialEndpoint) dial() {
+	generation := networkGeneration.Load()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	conn, err := e.endpoint.ConnectStream(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dialing--
+	if err != nil {
+		logger.Debug("failed to pre-dial a connection to the server", "err", err)
+		return
+	}
+	e.discardOutdated()
+	if generation != e.generation {
+		conn.Close()
+		return
+	}
+	c := &preDialedConn{conn: conn}
+	c.expiry = time.AfterFunc(e.idleTimeout, func() {
+		conn.Close()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for i, pooled := range e.pool {
+			if pooled == c {
+				e.pool = append(e.pool[:i], e.pool[i+1:]...)
+				break
+			}
+		}
+	})
+	e.pool = append(e.pool, c)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// countingEndpoint connects pipes, and keeps their server ends to tell whether they were closed.
+type countingEndpoint struct {
+	connects atomic.Int32
+
+	mu      sync.Mutex
+	servers []net.Conn
+}
+
+func (e *countingEndpoint) ConnectStream(context.Context) (transport.StreamConn, error) {
+	e.connects.Add(1)
+	client, server := net.Pipe()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.servers = append(e.servers, server)
+	return &pipeStreamConn{Conn: client}, nil
+}
+
+// closed returns the number of connections closed by the client.
+func (e *countingEndpoint) closed() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, s := range e.servers {
+		s.SetReadDeadline(time.Now().Add(time.Millisecond))
+		if _, err := s.Read(make([]byte, 1)); err == io.EOF {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *preDialEndpoint) poolLen() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.pool)
+}
+
+func TestPreDialEndpoint(t *testing.T) {
+	inner := &countingEndpoint{}
+	e := (&preDialConfigJSON{PoolSize: 2}).streamEndpoint(inner).(*preDialEndpoint)
+	require.Equal(t, defaultPreDialIdleTimeout, e.idleTimeout)
+	require.Equal(t, 0, e.poolLen(), "the pool is only filled once used")
+
+	conn, err := e.ConnectStream(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return e.poolLen() == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, int32(3), inner.connects.Load())
+
+	// The next connections come from the pool, which is refilled.
+	for i := 0; i < 3; i++ {
+		conn, err := e.ConnectStream(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Eventually(t, func() bool { return e.poolLen() == 2 }, time.Second, time.Millisecond)
+	}
+	require.Equal(t, int32(6), inner.connects.Load())
+
+	// The pool is discarded after a network change.
+	networkGeneration.Add(1)
+	conn, err = e.ConnectStream(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, 2, inner.closed())
+	require.Eventually(t, func() bool { return e.poolLen() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestPreDialEndpoint_Expiry(t *testing.T) {
+	inner := &countingEndpoint{}
+	e := (&preDialConfigJSON{PoolSize: 3}).streamEndpoint(inner).(*preDialEndpoint)
+	e.idleTimeout = 50 * time.Millisecond
+
+	conn, err := e.ConnectStream(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return e.poolLen() == 3 }, time.Second, time.Millisecond)
+
+	// The expired connections are closed, and not replaced until the next connection.
+	require.Eventually(t, func() bool { return e.poolLen() == 0 }, time.Second, time.Millisecond)
+	require.Equal(t, 3, inner.closed())
+	require.Equal(t, int32(4), inner.connects.Load())
+}
+
+func TestPreDialConfig_Invalid(t *testing.T) {
+	for _, preDial := range []string{`{"poolSize":17}`, `{"poolSize":-1}`, `{"idleSeconds":-1}`} {
+		config := `{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","preDial":` + preDial + `}`
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+	got := NewClient(`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","preDial":{}}`)
+	require.Nil(t, got.Error)
+}
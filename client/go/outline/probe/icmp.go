@@ -0,0 +1,165 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// icmpConn sends ICMP echo requests to a single destination.
+type icmpConn struct {
+	*icmp.PacketConn
+	dst net.Addr
+	ip  netip.Addr
+	id  int
+}
+
+// listenICMP opens an unprivileged ICMP socket to ip, or else a raw one.
+func listenICMP(ip netip.Addr) (*icmpConn, error) {
+	networks := []string{"udp4", "ip4:icmp"}
+	if ip.Is6() {
+		networks = []string{"udp6", "ip6:ipv6-icmp"}
+	}
+	var errs []error
+	for _, network := range networks {
+		conn, err := icmp.ListenPacket(network, "")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c := &icmpConn{PacketConn: conn, ip: ip, id: os.Getpid() & 0xffff}
+		if network[:3] == "udp" {
+			c.dst = &net.UDPAddr{IP: ip.AsSlice()}
+		} else {
+			c.dst = &net.IPAddr{IP: ip.AsSlice()}
+		}
+		return c, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// probe sends an echo request with the sequence number seq, and the TTL ttl if it is not 0, and
+// waits for the echo reply or a time exceeded message about it. ok is whether the echo reply
+// came, and from is the address of the replying host, if any.
+func (c *icmpConn) probe(ctx context.Context, seq, ttl int, timeout time.Duration) (rtt time.Duration, from netip.Addr, ok bool, err error) {
+	if ttl > 0 {
+		if err := c.setTTL(ttl); err != nil {
+			return 0, netip.Addr{}, false, err
+		}
+	}
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: c.id, Seq: seq, Data: []byte("outline-probe")},
+	}
+	proto := protocolICMP
+	if c.ip.Is6() {
+		msg.Type, proto = ipv6.ICMPTypeEchoRequest, protocolIPv6ICMP
+	}
+	req, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, netip.Addr{}, false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.SetReadDeadline(deadline); err != nil {
+		return 0, netip.Addr{}, false, err
+	}
+	start := time.Now()
+	if _, err := c.WriteTo(req, c.dst); err != nil {
+		return 0, netip.Addr{}, false, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, netip.Addr{}, false, ctx.Err()
+			}
+			return 0, netip.Addr{}, false, err
+		}
+		rtt = time.Since(start)
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		peerIP := addrIP(peer)
+		switch body := reply.Body.(type) {
+		case *icmp.Echo:
+			// Unprivileged sockets replace the ID, so only the sequence number is checked.
+			if (reply.Type == ipv4.ICMPTypeEchoReply || reply.Type == ipv6.ICMPTypeEchoReply) &&
+				body.Seq == seq && peerIP == c.ip {
+				return rtt, peerIP, true, nil
+			}
+		case *icmp.TimeExceeded:
+			if quotedEchoSeq(body.Data, c.ip.Is6()) == seq {
+				return rtt, peerIP, false, nil
+			}
+		}
+	}
+}
+
+func (c *icmpConn) setTTL(ttl int) error {
+	if c.ip.Is6() {
+		return c.IPv6PacketConn().SetHopLimit(ttl)
+	}
+	return c.IPv4PacketConn().SetTTL(ttl)
+}
+
+// quotedEchoSeq returns the sequence number of the echo request quoted in an ICMP error, or -1.
+func quotedEchoSeq(quoted []byte, isIPv6 bool) int {
+	headerLen := ipv6.HeaderLen
+	if !isIPv6 {
+		if len(quoted) < 1 {
+			return -1
+		}
+		headerLen = int(quoted[0]&0x0f) * 4
+	}
+	// The echo request has the type, code, checksum, ID and then sequence number.
+	if len(quoted) < headerLen+8 {
+		return -1
+	}
+	return int(binary.BigEndian.Uint16(quoted[headerLen+6:]))
+}
+
+func addrIP(addr net.Addr) netip.Addr {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	}
+	parsed, _ := netip.AddrFromSlice(ip)
+	return parsed.Unmap()
+}
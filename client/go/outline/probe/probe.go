@@ -0,0 +1,233 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package probe measures the network path to a server with ping and traceroute, to tell a server
+// that is down from a path that is blocked.
+//
+// The probes use ICMP if the platform allows it, with unprivileged ICMP sockets or else raw ones.
+// Otherwise they fall back to timing TCP connections to the server port. The probes don't go
+// through the VPN only if the server address is routed outside of it, so they are most reliable
+// while disconnected.
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Method is how the server is probed.
+type Method string
+
+const (
+	// MethodICMP sends ICMP echo requests.
+	MethodICMP Method = "icmp"
+
+	// MethodTCP opens TCP connections to the server port, for when ICMP sockets are not allowed.
+	MethodTCP Method = "tcp"
+)
+
+// Defaults of the [Options].
+const (
+	DefaultCount   = 4
+	DefaultTimeout = 2 * time.Second
+	DefaultMaxHops = 30
+)
+
+// Options configure the probes.
+type Options struct {
+	// Port is the TCP port of the server, for the TCP fallback. The TCP fallback is not used if
+	// it is 0.
+	Port uint16
+
+	// Count is the number of pings. Defaults to [DefaultCount].
+	Count int
+
+	// Timeout is how long a probe waits for its reply. Defaults to [DefaultTimeout].
+	Timeout time.Duration
+
+	// MaxHops is the maximum TTL of the traceroute. Defaults to [DefaultMaxHops].
+	MaxHops int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Count <= 0 {
+		o.Count = DefaultCount
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.MaxHops <= 0 {
+		o.MaxHops = DefaultMaxHops
+	}
+	return o
+}
+
+// PingResult is the result of [Ping].
+type PingResult struct {
+	Address  string    `json:"address"`
+	Method   Method    `json:"method"`
+	Sent     int       `json:"sent"`
+	Received int       `json:"received"`
+	RTTsMs   []float64 `json:"rttsMs"` // The round-trip times of the replies.
+}
+
+// Hop is a step of a [TracerouteResult].
+type Hop struct {
+	TTL int `json:"ttl"`
+
+	// Address is the router that replied, or the server. It is empty if there was no reply, or
+	// the reply doesn't tell the address, e.g. with the TCP method.
+	Address string  `json:"address,omitempty"`
+	RTTMs   float64 `json:"rttMs,omitempty"`
+
+	// Reached is whether the probe reached the server.
+	Reached bool `json:"reached,omitempty"`
+}
+
+// TracerouteResult is the result of [Traceroute].
+type TracerouteResult struct {
+	Address string `json:"address"`
+	Method  Method `json:"method"`
+	Hops    []Hop  `json:"hops"`
+	Reached bool   `json:"reached"`
+}
+
+// ErrUnavailable is returned when neither ICMP nor the TCP fallback can be used.
+var ErrUnavailable = errors.New("ICMP is not available, and no TCP port to fall back to")
+
+// Ping sends opts.Count probes to ip, one at a time, and returns the replies.
+func Ping(ctx context.Context, ip netip.Addr, opts Options) (*PingResult, error) {
+	opts = opts.withDefaults()
+	res := &PingResult{Address: ip.String(), RTTsMs: []float64{}}
+	conn, err := listenICMP(ip)
+	if err == nil {
+		defer conn.Close()
+		res.Method = MethodICMP
+	} else if opts.Port != 0 {
+		res.Method = MethodTCP
+	} else {
+		return nil, errors.Join(ErrUnavailable, err)
+	}
+	for seq := 1; seq <= opts.Count; seq++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var rtt time.Duration
+		var ok bool
+		if conn != nil {
+			rtt, _, ok, err = conn.probe(ctx, seq, 0, opts.Timeout)
+		} else {
+			rtt, ok, err = probeTCP(ctx, ip, opts.Port, 0, opts.Timeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Sent++
+		if ok {
+			res.Received++
+			res.RTTsMs = append(res.RTTsMs, toMs(rtt))
+		}
+	}
+	return res, nil
+}
+
+// Traceroute sends probes to ip with increasing TTLs, until one reaches it or opts.MaxHops.
+func Traceroute(ctx context.Context, ip netip.Addr, opts Options) (*TracerouteResult, error) {
+	opts = opts.withDefaults()
+	res := &TracerouteResult{Address: ip.String(), Hops: []Hop{}}
+	conn, err := listenICMP(ip)
+	if err == nil {
+		defer conn.Close()
+		res.Method = MethodICMP
+	} else if opts.Port != 0 {
+		res.Method = MethodTCP
+	} else {
+		return nil, errors.Join(ErrUnavailable, err)
+	}
+	for ttl := 1; ttl <= opts.MaxHops && !res.Reached; ttl++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		hop := Hop{TTL: ttl}
+		var rtt time.Duration
+		var from netip.Addr
+		var ok bool
+		if conn != nil {
+			rtt, from, ok, err = conn.probe(ctx, ttl, ttl, opts.Timeout)
+			if from.IsValid() {
+				hop.Address = from.String()
+				hop.Reached = ok
+			}
+		} else {
+			rtt, ok, err = probeTCP(ctx, ip, opts.Port, ttl, opts.Timeout)
+			if ok {
+				hop.Address, hop.Reached = ip.String(), true
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hop.Address != "" {
+			hop.RTTMs = toMs(rtt)
+		}
+		res.Reached = hop.Reached
+		res.Hops = append(res.Hops, hop)
+	}
+	return res, nil
+}
+
+// probeTCP connects to ip:port, with the given TTL if it is not 0. ok is whether the server
+// replied, with a SYN-ACK or a RST. err is only set for local failures.
+func probeTCP(ctx context.Context, ip netip.Addr, port uint16, ttl int, timeout time.Duration) (rtt time.Duration, ok bool, err error) {
+	dialer := net.Dialer{Timeout: timeout}
+	if ttl > 0 {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			return setTTL(c, ip.Is6(), ttl)
+		}
+	}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(ip, port).String())
+	rtt = time.Since(start)
+	if err == nil {
+		conn.Close()
+		return rtt, true, nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return rtt, true, nil
+	}
+	if ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	var ctrlErr *controlError
+	if errors.As(err, &ctrlErr) {
+		return 0, false, ctrlErr.err
+	}
+	// Timeouts, and unreachable hosts or networks.
+	return 0, false, nil
+}
+
+// controlError is a failure to configure the socket, as opposed to a failure to connect.
+type controlError struct {
+	err error
+}
+
+func (e *controlError) Error() string { return e.err.Error() }
+
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
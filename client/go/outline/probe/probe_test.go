@@ -0,0 +1,86 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+)
+
+var loopback = netip.MustParseAddr("127.0.0.1")
+
+func listenTCP(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestPing(t *testing.T) {
+	res, err := Ping(context.Background(), loopback, Options{Port: listenTCP(t), Count: 3, Timeout: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", res.Address)
+	require.Contains(t, []Method{MethodICMP, MethodTCP}, res.Method)
+	require.Equal(t, 3, res.Sent)
+	require.Equal(t, 3, res.Received)
+	require.Len(t, res.RTTsMs, 3)
+}
+
+func TestTraceroute(t *testing.T) {
+	res, err := Traceroute(context.Background(), loopback, Options{Port: listenTCP(t), Timeout: time.Second})
+	require.NoError(t, err)
+	require.True(t, res.Reached)
+	require.Equal(t, []Hop{{TTL: 1, Address: "127.0.0.1", RTTMs: res.Hops[0].RTTMs, Reached: true}}, res.Hops)
+}
+
+func TestProbeTCP(t *testing.T) {
+	port := listenTCP(t)
+	_, ok, err := probeTCP(context.Background(), loopback, port, 1, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// A refused connection still tells that the server is up.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	_, ok, err = probeTCP(context.Background(), loopback, closedPort, 0, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = probeTCP(ctx, loopback, port, 0, time.Second)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQuotedEchoSeq(t *testing.T) {
+	// An IPv4 header without options, and the start of the echo request.
+	quoted := make([]byte, ipv4.HeaderLen)
+	quoted[0] = 0x45
+	quoted = append(quoted, 8, 0, 0, 0, 0x12, 0x34, 0x00, 0x2a)
+	require.Equal(t, 42, quotedEchoSeq(quoted, false))
+	require.Equal(t, -1, quotedEchoSeq(quoted[:ipv4.HeaderLen+4], false))
+	require.Equal(t, -1, quotedEchoSeq(nil, false))
+
+	quoted6 := append(make([]byte, 40), 128, 0, 0, 0, 0x12, 0x34, 0x00, 0x07)
+	require.Equal(t, 7, quotedEchoSeq(quoted6, true))
+}
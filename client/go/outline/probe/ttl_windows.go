@@ -0,0 +1,37 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package probe
+
+import "syscall"
+
+// setTTL sets the TTL, or hop limit, of the socket c.
+func setTTL(c syscall.RawConn, isIPv6 bool, ttl int) error {
+	var err error
+	if ctrlErr := c.Control(func(fd uintptr) {
+		if isIPv6 {
+			err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+		} else {
+			err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+		}
+	}); ctrlErr != nil {
+		return &controlError{ctrlErr}
+	}
+	if err != nil {
+		return &controlError{err}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// profilingRequestJSON is the input of [MethodStartProfiling].
+type profilingRequestJSON struct {
+	// Address is where the pprof endpoint listens: a loopback address like "127.0.0.1:6060", or
+	// "unix:" followed by the path of a unix socket. Defaults to a free port of 127.0.0.1.
+	Address string `json:"address,omitempty"`
+}
+
+// parseProfilingAddress parses the input as an optional profilingRequestJSON, and returns the
+// network and address to listen on. Only local addresses are allowed, since the profiles reveal
+// the memory of the process.
+func parseProfilingAddress(input string) (network, address string, err error) {
+	var req profilingRequestJSON
+	if input != "" {
+		if err := json.Unmarshal([]byte(input), &req); err != nil {
+			return "", "", platerrors.PlatformError{
+				Code:    platerrors.IllegalConfig,
+				Message: "invalid profiling request",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+	}
+	if req.Address == "" {
+		return "tcp", "127.0.0.1:0", nil
+	}
+	if path, ok := strings.CutPrefix(req.Address, "unix:"); ok && path != "" {
+		return "unix", path, nil
+	}
+	host, _, err := net.SplitHostPort(req.Address)
+	if ip := net.ParseIP(host); err != nil || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+		return "", "", newIllegalConfigErrorWithDetails("profiling address is not valid",
+			"address", req.Address, "a loopback address or a unix socket", err)
+	}
+	return "tcp", req.Address, nil
+}
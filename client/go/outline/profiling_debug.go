@@ -0,0 +1,84 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build debug
+
+package outline
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+// The pprof server of [MethodStartProfiling], if it's running.
+var profilingMu sync.Mutex
+var profilingServer *http.Server
+
+// startProfiling serves the pprof endpoints at the address of the request in input, and returns
+// the address it listens on. The server of a previous call is stopped.
+func startProfiling(input string) (string, error) {
+	network, address, err := parseProfilingAddress(input)
+	if err != nil {
+		return "", err
+	}
+	profilingMu.Lock()
+	defer profilingMu.Unlock()
+	if profilingServer != nil {
+		profilingServer.Close()
+		profilingServer = nil
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to listen for profiling requests",
+			Details: platerrors.ErrorDetails{"address": address},
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux}
+	profilingServer = server
+	resources.Go(resources.SubsystemProfiling, func() { server.Serve(l) })
+
+	addr := l.Addr().String()
+	if network == "unix" {
+		addr = "unix:" + addr
+	}
+	logger.Warn("pprof endpoint started", "address", addr)
+	return addr, nil
+}
+
+// stopProfiling stops the pprof server of [MethodStartProfiling], if it's running.
+func stopProfiling() error {
+	profilingMu.Lock()
+	defer profilingMu.Unlock()
+	if profilingServer == nil {
+		return nil
+	}
+	err := profilingServer.Close()
+	profilingServer = nil
+	return err
+}
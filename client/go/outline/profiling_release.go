@@ -0,0 +1,35 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !debug
+
+package outline
+
+import "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+
+// errProfilingUnavailable is the error of [MethodStartProfiling] in the release builds, which
+// don't include the pprof endpoints.
+var errProfilingUnavailable = platerrors.PlatformError{
+	Code:    platerrors.InternalError,
+	Message: "profiling is only available in the builds with the debug tag",
+}
+
+func startProfiling(input string) (string, error) {
+	if _, _, err := parseProfilingAddress(input); err != nil {
+		return "", err
+	}
+	return "", errProfilingUnavailable
+}
+
+func stopProfiling() error { return nil }
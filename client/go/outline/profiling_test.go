@@ -0,0 +1,47 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfilingAddress(t *testing.T) {
+	for input, want := range map[string][2]string{
+		``:                                   {"tcp", "127.0.0.1:0"},
+		`{}`:                                 {"tcp", "127.0.0.1:0"},
+		`{"address":"127.0.0.1:6060"}`:       {"tcp", "127.0.0.1:6060"},
+		`{"address":"[::1]:6060"}`:           {"tcp", "[::1]:6060"},
+		`{"address":"localhost:6060"}`:       {"tcp", "localhost:6060"},
+		`{"address":"unix:/tmp/pprof.sock"}`: {"unix", "/tmp/pprof.sock"},
+	} {
+		network, address, err := parseProfilingAddress(input)
+		require.NoError(t, err, input)
+		require.Equal(t, want, [2]string{network, address}, input)
+	}
+	for _, input := range []string{
+		`{"address":"0.0.0.0:6060"}`,
+		`{"address":"192.0.2.1:6060"}`,
+		`{"address":"example.com:6060"}`,
+		`{"address":"127.0.0.1"}`,
+		`{"address":"unix:"}`,
+		`not json`,
+	} {
+		_, _, err := parseProfilingAddress(input)
+		require.Error(t, err, input)
+	}
+}
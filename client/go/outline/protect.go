@@ -0,0 +1,71 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// SocketProtector protects sockets from the VPN, so that their traffic goes to the network
+// directly instead of looping back into the tunnel. On Android, it calls VpnService.protect().
+type SocketProtector interface {
+	// Protect protects the socket fd before it connects. It returns false if it fails.
+	Protect(fd int) bool
+}
+
+var socketProtectorMu sync.RWMutex
+var socketProtector SocketProtector
+
+// errSocketNotProtected is returned by the dials when the [SocketProtector] fails.
+var errSocketNotProtected = errors.New("failed to protect the socket from the VPN")
+
+// SetSocketProtector sets the protector of the sockets the clients dial, or removes it if p is
+// nil. It applies to the connections dialed after the call, including the ones of existing
+// clients, so it should be set before the first client is created.
+func SetSocketProtector(p SocketProtector) {
+	socketProtectorMu.Lock()
+	defer socketProtectorMu.Unlock()
+	socketProtector = p
+}
+
+// withSocketProtector makes d call the [SocketProtector] on its sockets, after its own Control
+// function.
+func withSocketProtector(d *net.Dialer) {
+	control := d.Control
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		socketProtectorMu.RLock()
+		p := socketProtector
+		socketProtectorMu.RUnlock()
+		if p == nil {
+			return nil
+		}
+		var ok bool
+		if err := c.Control(func(fd uintptr) { ok = p.Protect(int(fd)) }); err != nil {
+			return err
+		}
+		if !ok {
+			return errSocketNotProtected
+		}
+		return nil
+	}
+}
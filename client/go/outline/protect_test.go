@@ -0,0 +1,91 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProtector struct {
+	mu  sync.Mutex
+	fds []int
+	ok  bool
+}
+
+func (p *fakeProtector) Protect(fd int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fds = append(p.fds, fd)
+	return p.ok
+}
+
+func TestWithSocketProtector(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	controlled := false
+	dialer := net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		controlled = true
+		return nil
+	}}
+	withSocketProtector(&dialer)
+
+	// No protector is set.
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	require.True(t, controlled)
+
+	p := &fakeProtector{ok: true}
+	SetSocketProtector(p)
+	t.Cleanup(func() { SetSocketProtector(nil) })
+	conn, err = dialer.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	require.Len(t, p.fds, 1)
+	require.Greater(t, p.fds[0], 0)
+
+	p.ok = false
+	_, err = dialer.Dial("tcp", listener.Addr().String())
+	require.ErrorIs(t, err, errSocketNotProtected)
+	require.Len(t, p.fds, 2)
+}
+
+func TestNewClient_ProtectsSockets(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	p := &fakeProtector{ok: false}
+	SetSocketProtector(p)
+	t.Cleanup(func() { SetSocketProtector(nil) })
+
+	addr := listener.Addr().(*net.TCPAddr)
+	client, err := newClientWithBaseDialers(fmt.Sprintf(
+		`{"host":"127.0.0.1","port":%d,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`, addr.Port),
+		net.Dialer{}, net.Dialer{})
+	require.NoError(t, err)
+	_, err = client.DialStream(context.Background(), "example.com:443")
+	require.ErrorIs(t, err, errSocketNotProtected)
+	require.NotEmpty(t, p.fds)
+}
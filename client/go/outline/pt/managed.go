@@ -0,0 +1,262 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+)
+
+var logger = logging.Module("pt")
+
+const (
+	// launchTimeout is how long a managed transport has to report its SOCKS5 address.
+	launchTimeout = 30 * time.Second
+
+	// idleTimeout is how long a managed transport keeps running without open streams.
+	idleTimeout = 5 * time.Minute
+
+	// stopTimeout is how long a managed transport has to exit once its stdin is closed, before
+	// it's killed.
+	stopTimeout = 5 * time.Second
+)
+
+var (
+	managedMu         sync.Mutex
+	managedTransports = map[string]*managedTransport{}
+)
+
+// sharedManagedTransport returns the managed transport name, shared by all its dialers, so that
+// there's a single subprocess for them.
+func sharedManagedTransport(name string, m Managed) *managedTransport {
+	managedMu.Lock()
+	defer managedMu.Unlock()
+	mt, ok := managedTransports[name]
+	if !ok {
+		mt = &managedTransport{name: name, managed: m}
+		managedTransports[name] = mt
+	}
+	return mt
+}
+
+// managedTransport runs the subprocess of a managed transport while it has open streams, and for
+// idleTimeout after the last one is closed.
+type managedTransport struct {
+	name    string
+	managed Managed
+
+	mu        sync.Mutex
+	proc      *process
+	streams   int
+	idleTimer *time.Timer
+}
+
+// acquire returns the SOCKS5 address of the subprocess, launching it if it's not running, and
+// the function releasing the stream that uses it.
+func (mt *managedTransport) acquire(ctx context.Context) (string, func(), error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.proc == nil || mt.proc.exited() {
+		proc, err := launch(ctx, mt.name, mt.managed)
+		if err != nil {
+			return "", nil, err
+		}
+		mt.proc = proc
+	}
+	if mt.idleTimer != nil {
+		mt.idleTimer.Stop()
+		mt.idleTimer = nil
+	}
+	mt.streams++
+	proc := mt.proc
+	return proc.socksAddr, func() { mt.release(proc) }, nil
+}
+
+func (mt *managedTransport) release(proc *process) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.streams--
+	if mt.streams > 0 || mt.proc != proc {
+		return
+	}
+	mt.idleTimer = time.AfterFunc(idleTimeout, func() {
+		mt.mu.Lock()
+		defer mt.mu.Unlock()
+		if mt.streams > 0 || mt.proc != proc {
+			return
+		}
+		mt.proc = nil
+		mt.idleTimer = nil
+		resources.Go(resources.SubsystemPT, proc.stop)
+	})
+}
+
+// process is a running subprocess of a managed transport.
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.Closer
+	socksAddr string
+	done      chan struct{}
+}
+
+// launch runs the executable of the transport name, and waits for it to report the SOCKS5 address of the
+// transport, as in the pluggable transport specification: the subprocess is configured with the
+// TOR_PT_* environment variables, and reports its status on stdout, e.g.
+//
+//	VERSION 1
+//	CMETHOD obfs4 socks5 127.0.0.1:40475
+//	CMETHODS DONE
+func launch(ctx context.Context, name string, m Managed) (*process, error) {
+	stateDir := m.StateDir
+	if stateDir == "" {
+		stateDir = filepath.Join(os.TempDir(), "outline-pt-"+name)
+	}
+	cmd := exec.Command(m.Command, m.Args...)
+	cmd.Env = append(os.Environ(),
+		"TOR_PT_MANAGED_TRANSPORT_VER=1",
+		"TOR_PT_CLIENT_TRANSPORTS="+name,
+		"TOR_PT_STATE_LOCATION="+stateDir,
+		// The subprocess exits when the app does, which closes its stdin.
+		"TOR_PT_EXIT_ON_STDIN_CLOSE=1",
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start pluggable transport %q: %w", name, err)
+	}
+	proc := &process{cmd: cmd, stdin: stdin, done: make(chan struct{})}
+	resources.Go(resources.SubsystemPT, func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Debug("pluggable transport stderr", "transport", name, "line", scanner.Text())
+		}
+	})
+
+	type result struct {
+		socksAddr string
+		err       error
+	}
+	launched := make(chan result, 1)
+	resources.Go(resources.SubsystemPT, func() {
+		scanner := bufio.NewScanner(stdout)
+		socksAddr, err := readMethods(scanner, name)
+		launched <- result{socksAddr, err}
+		// Keep reading the log and status messages, so that the subprocess never blocks on them.
+		for scanner.Scan() {
+			logger.Debug("pluggable transport message", "transport", name, "line", scanner.Text())
+		}
+		cmd.Wait()
+		close(proc.done)
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, launchTimeout)
+	defer cancel()
+	select {
+	case res := <-launched:
+		if res.err != nil {
+			proc.stop()
+			return nil, res.err
+		}
+		proc.socksAddr = res.socksAddr
+		logger.Info("launched pluggable transport", "transport", name, "pid", cmd.Process.Pid)
+		return proc, nil
+	case <-ctx.Done():
+		proc.stop()
+		return nil, fmt.Errorf("pluggable transport %q didn't start: %w", name, ctx.Err())
+	}
+}
+
+// readMethods reads the messages of a managed transport until it reports the SOCKS5 address of
+// the transport name.
+func readMethods(scanner *bufio.Scanner, name string) (string, error) {
+	var socksAddr string
+	for scanner.Scan() {
+		keyword, rest, _ := strings.Cut(scanner.Text(), " ")
+		switch keyword {
+		case "VERSION":
+			if rest != "1" {
+				return "", fmt.Errorf("pluggable transport %q has unsupported version %q", name, rest)
+			}
+		case "VERSION-ERROR", "ENV-ERROR":
+			return "", fmt.Errorf("pluggable transport %q failed to start: %s", name, rest)
+		case "CMETHOD-ERROR":
+			return "", fmt.Errorf("pluggable transport %q failed: %s", name, rest)
+		case "CMETHOD":
+			// CMETHOD <transport> <protocol> <address>
+			fields := strings.Fields(rest)
+			if len(fields) != 3 || fields[0] != name {
+				continue
+			}
+			if fields[1] != "socks5" {
+				return "", fmt.Errorf("pluggable transport %q has unsupported protocol %q", name, fields[1])
+			}
+			socksAddr = fields[2]
+		case "CMETHODS":
+			if socksAddr == "" {
+				return "", fmt.Errorf("pluggable transport doesn't support %q", name)
+			}
+			return socksAddr, nil
+		default:
+			logger.Debug("pluggable transport message", "transport", name, "line", scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("pluggable transport exited before it started")
+}
+
+// exited returns whether the subprocess exited.
+func (p *process) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop closes the stdin of the subprocess so that it exits, and kills it if it doesn't.
+func (p *process) stop() {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
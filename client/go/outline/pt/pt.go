@@ -0,0 +1,182 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pt tunnels streams through the pluggable transports of the Tor Pluggable Transport
+// specification, version 1, like obfs4, so that the traffic to a server doesn't look like the
+// protocol it carries.
+//
+// A pluggable transport is either in-process, registered with [Register], or managed: an
+// executable, like lyrebird, registered with [RegisterManaged], that the package runs as a
+// subprocess and connects to over its SOCKS5 interface. The configs only select the transports
+// by name, so that they never choose the executables that run.
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// Factory creates the dialer of an in-process pluggable transport, which connects to the servers
+// through base. args are the arguments of the server, like the "cert" of obfs4.
+type Factory func(args map[string]string, base transport.StreamDialer) (transport.StreamDialer, error)
+
+// Managed is the executable of a managed transport, shipped with the app or installed by the
+// platform.
+type Managed struct {
+	// Command is the absolute path of the executable, and Args are its arguments.
+	Command string
+	Args    []string
+
+	// StateDir is the directory where the transport keeps its state. Defaults to a directory of
+	// the transport in the temporary directory.
+	StateDir string
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+	managed     = map[string]Managed{}
+)
+
+// Register registers the in-process implementation of the transport name, e.g. from the init
+// function of a package linking an obfs4 library.
+func Register(name string, factory Factory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("pluggable transport must have a name and a factory")
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[name]; ok {
+		return fmt.Errorf("pluggable transport %q is already registered", name)
+	}
+	factories[name] = factory
+	return nil
+}
+
+// RegisterManaged registers the executable of the managed transport name, which the transport
+// configs can then select.
+func RegisterManaged(name string, m Managed) error {
+	if name == "" || !filepath.IsAbs(m.Command) {
+		return fmt.Errorf("managed pluggable transport must have a name and an absolute command")
+	}
+	if m.StateDir != "" && !filepath.IsAbs(m.StateDir) {
+		return fmt.Errorf("state directory of pluggable transport %q must be an absolute path", name)
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := managed[name]; ok {
+		return fmt.Errorf("pluggable transport %q is already registered", name)
+	}
+	managed[name] = m
+	return nil
+}
+
+// Config configures a pluggable transport.
+type Config struct {
+	// Name is the name of the transport, e.g. "obfs4". The in-process implementation of the
+	// transport is preferred to its managed one.
+	Name string
+
+	// Args are the arguments of the server, like the "cert" and "iat-mode" of obfs4.
+	Args map[string]string
+
+	// NoSubprocesses rejects the managed transports, e.g. for the configs sent by a less
+	// privileged process, which must not run executables with the privileges of this one.
+	NoSubprocesses bool
+}
+
+// NewStreamDialer creates a dialer tunneling the streams through the pluggable transport of
+// config. In-process transports connect to the servers with tcpDialer.
+//
+// The managed transports make their own connections to the servers, which don't go through
+// tcpDialer: the platforms must exclude them from the VPN.
+func NewStreamDialer(config Config, tcpDialer net.Dialer) (transport.StreamDialer, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("pluggable transport has no name")
+	}
+	factoriesMu.RLock()
+	factory, isInProcess := factories[config.Name]
+	m, isManaged := managed[config.Name]
+	factoriesMu.RUnlock()
+	switch {
+	case isInProcess:
+		return factory(config.Args, &transport.TCPDialer{Dialer: tcpDialer})
+	case isManaged && config.NoSubprocesses:
+		return nil, fmt.Errorf("managed pluggable transport %q is not allowed", config.Name)
+	case isManaged:
+		return &managedDialer{
+			transport: sharedManagedTransport(config.Name, m),
+			args:      encodeArgs(config.Args),
+		}, nil
+	}
+	return nil, fmt.Errorf("pluggable transport %q is not built in or registered", config.Name)
+}
+
+// managedDialer tunnels the streams through the SOCKS5 interface of a managed transport.
+type managedDialer struct {
+	transport *managedTransport
+	args      string
+}
+
+func (d *managedDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	socksAddr, release, err := d.transport.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialSOCKS5(ctx, socksAddr, addr, d.args)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &managedConn{StreamConn: conn, release: release}, nil
+}
+
+// managedConn keeps its managed transport running until it's closed.
+type managedConn struct {
+	transport.StreamConn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *managedConn) Close() error {
+	c.releaseOnce.Do(c.release)
+	return c.StreamConn.Close()
+}
+
+// encodeArgs encodes the arguments of a server for the SOCKS5 authentication of a managed
+// transport, sorted by key:
+//
+//	key=value;key=value
+//
+// with the "\", "=" and ";" in the keys and the values escaped with "\".
+func encodeArgs(args map[string]string) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	escaper := strings.NewReplacer(`\`, `\\`, `=`, `\=`, `;`, `\;`)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = escaper.Replace(key) + "=" + escaper.Replace(args[key])
+	}
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// helperEnv makes the test binary run as a managed obfs4 transport, see [runTestTransport].
+const helperEnv = "OUTLINE_PT_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "1" {
+		runTestTransport()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestTransport is a managed transport supporting "obfs4", whose SOCKS5 interface answers the
+// streams with the arguments of the server and the address they connect to, then echoes them.
+func runTestTransport() {
+	if os.Getenv("TOR_PT_MANAGED_TRANSPORT_VER") != "1" {
+		fmt.Println("VERSION-ERROR no-version")
+		return
+	}
+	fmt.Println("VERSION 1")
+	if os.Getenv("TOR_PT_CLIENT_TRANSPORTS") != "obfs4" {
+		fmt.Printf("CMETHOD-ERROR %s unsupported transport\n", os.Getenv("TOR_PT_CLIENT_TRANSPORTS"))
+		fmt.Println("CMETHODS DONE")
+		return
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("CMETHOD-ERROR obfs4", err)
+		return
+	}
+	fmt.Println("CMETHOD obfs4 socks5", ln.Addr())
+	fmt.Println("CMETHODS DONE")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSOCKS5(conn)
+		}
+	}()
+	// Exit when the stdin is closed.
+	io.Copy(io.Discard, os.Stdin)
+}
+
+func serveTestSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return
+	}
+	conn.Write([]byte{socksVersion, greeting[2]})
+	var args string
+	if greeting[2] == socksAuthPassword {
+		readField := func() string {
+			n, _ := r.ReadByte()
+			b := make([]byte, n)
+			io.ReadFull(r, b)
+			return string(b)
+		}
+		r.ReadByte()
+		user, password := readField(), readField()
+		if password == "\x00" {
+			password = ""
+		}
+		args = user + password
+		conn.Write([]byte{socksPasswordVersion, 0})
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	var host string
+	switch header[3] {
+	case socksAddrIPv4:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(r, port)
+	conn.Write([]byte{socksVersion, socksReplySucceeded, 0, socksAddrIPv4, 127, 0, 0, 1, 0, 0})
+	fmt.Fprintf(conn, "%s|%s\n", args, net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port))))
+	io.Copy(conn, r)
+}
+
+// registerManaged registers command as the managed transport name for the test.
+func registerManaged(t *testing.T, name, command string) {
+	require.NoError(t, RegisterManaged(name, Managed{Command: command, StateDir: t.TempDir()}))
+	t.Cleanup(func() {
+		factoriesMu.Lock()
+		delete(managed, name)
+		factoriesMu.Unlock()
+		managedMu.Lock()
+		delete(managedTransports, name)
+		managedMu.Unlock()
+	})
+}
+
+// registerTestTransport registers the test binary as the managed transport name, see
+// [runTestTransport].
+func registerTestTransport(t *testing.T, name string) {
+	t.Setenv(helperEnv, "1")
+	registerManaged(t, name, os.Args[0])
+}
+
+// dialAndReadHeader dials addr and returns the conn and the header the test transport answers.
+func dialAndReadHeader(t *testing.T, d transport.StreamDialer, addr string) (transport.StreamConn, string) {
+	conn, err := d.DialStream(context.Background(), addr)
+	require.NoError(t, err)
+	header, err := bufio.NewReader(io.LimitReader(conn, 2048)).ReadString('\n')
+	require.NoError(t, err)
+	return conn, strings.TrimSuffix(header, "\n")
+}
+
+func TestManagedTransport(t *testing.T) {
+	registerTestTransport(t, "obfs4")
+	longCert := strings.Repeat("c", 300)
+	d, err := NewStreamDialer(Config{Name: "obfs4", Args: map[string]string{"cert": longCert, "iat-mode": "0"}}, net.Dialer{})
+	require.NoError(t, err)
+
+	conn, header := dialAndReadHeader(t, d, "192.0.2.1:443")
+	require.Equal(t, "cert="+longCert+";iat-mode=0|192.0.2.1:443", header)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	// The dialers of the same transport share its subprocess.
+	other, err := NewStreamDialer(Config{Name: "obfs4"}, net.Dialer{})
+	require.NoError(t, err)
+	mt := d.(*managedDialer).transport
+	proc := mt.proc
+	conn2, header := dialAndReadHeader(t, other, "example.com:80")
+	require.Equal(t, "|example.com:80", header)
+	require.Same(t, mt, other.(*managedDialer).transport)
+	require.Same(t, proc, mt.proc)
+	require.Equal(t, 2, mt.streams)
+	require.NoError(t, conn2.Close())
+	require.Equal(t, 1, mt.streams)
+	require.NoError(t, conn.Close())
+	require.Equal(t, 0, mt.streams)
+
+	// The next stream launches the subprocess again once it exited.
+	proc.stop()
+	require.True(t, proc.exited())
+	conn3, header := dialAndReadHeader(t, d, "192.0.2.1:443")
+	defer conn3.Close()
+	require.Equal(t, "cert="+longCert+";iat-mode=0|192.0.2.1:443", header)
+	require.NotSame(t, proc, mt.proc)
+}
+
+func TestManagedTransport_Unsupported(t *testing.T) {
+	registerTestTransport(t, "meek")
+	d, err := NewStreamDialer(Config{Name: "meek"}, net.Dialer{})
+	require.NoError(t, err)
+	_, err = d.DialStream(context.Background(), "192.0.2.1:443")
+	require.ErrorContains(t, err, "unsupported transport")
+}
+
+func TestManagedTransport_MissingCommand(t *testing.T) {
+	registerManaged(t, "obfs4", "/nonexistent/lyrebird")
+	d, err := NewStreamDialer(Config{Name: "obfs4"}, net.Dialer{})
+	require.NoError(t, err)
+	_, err = d.DialStream(context.Background(), "192.0.2.1:443")
+	require.Error(t, err)
+}
+
+func TestRegisterManaged(t *testing.T) {
+	registerManaged(t, "test-managed", "/usr/bin/lyrebird")
+	require.Error(t, RegisterManaged("test-managed", Managed{Command: "/usr/bin/lyrebird"}), "a transport must be registered once")
+	require.Error(t, RegisterManaged("test-relative", Managed{Command: "lyrebird"}))
+	require.Error(t, RegisterManaged("test-state", Managed{Command: "/usr/bin/lyrebird", StateDir: "state"}))
+	require.Error(t, RegisterManaged("", Managed{Command: "/usr/bin/lyrebird"}))
+
+	_, err := NewStreamDialer(Config{Name: "unregistered"}, net.Dialer{})
+	require.Error(t, err, "the unregistered transports must not run")
+
+	_, err = NewStreamDialer(Config{Name: "test-managed", NoSubprocesses: true}, net.Dialer{})
+	require.Error(t, err, "the managed transports must not run without subprocesses")
+}
+
+func TestInProcessTransport(t *testing.T) {
+	var gotArgs map[string]string
+	t.Cleanup(func() {
+		factoriesMu.Lock()
+		defer factoriesMu.Unlock()
+		delete(factories, "test-in-process")
+	})
+	require.NoError(t, Register("test-in-process", func(args map[string]string, base transport.StreamDialer) (transport.StreamDialer, error) {
+		gotArgs = args
+		return base, nil
+	}))
+	require.Error(t, Register("test-in-process", func(map[string]string, transport.StreamDialer) (transport.StreamDialer, error) {
+		return nil, nil
+	}))
+
+	d, err := NewStreamDialer(Config{Name: "test-in-process", Args: map[string]string{"key": "value"}}, net.Dialer{})
+	require.NoError(t, err)
+	require.IsType(t, &transport.TCPDialer{}, d)
+	require.Equal(t, map[string]string{"key": "value"}, gotArgs)
+
+	_, err = NewStreamDialer(Config{Name: "test-in-process", NoSubprocesses: true}, net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = NewStreamDialer(Config{Name: "unknown"}, net.Dialer{})
+	require.Error(t, err)
+}
+
+func TestEncodeArgs(t *testing.T) {
+	require.Equal(t, "", encodeArgs(nil))
+	require.Equal(t, `a=1;b\=c=x\;y\\z`, encodeArgs(map[string]string{"b=c": `x;y\z`, "a": "1"}))
+}
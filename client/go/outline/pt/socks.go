@@ -0,0 +1,158 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929.
+const (
+	socksVersion         = 5
+	socksAuthNone        = 0
+	socksAuthPassword    = 2
+	socksPasswordVersion = 1
+	socksCmdConnect      = 1
+	socksAddrIPv4        = 1
+	socksAddrDomain      = 3
+	socksAddrIPv6        = 4
+	socksReplySucceeded  = 0
+)
+
+// maxSOCKSField is the maximum size of the user name and the password of the SOCKS5
+// authentication.
+const maxSOCKSField = 255
+
+// dialSOCKS5 connects to addr through the SOCKS5 proxy at proxyAddr. The arguments of the server,
+// if any, go in the user name and the password of the authentication, as the pluggable transport
+// specification requires: the first 255 bytes are the user name, and the rest, or a NUL, the
+// password.
+func dialSOCKS5(ctx context.Context, proxyAddr, addr string, args string) (transport.StreamConn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	if len(args) > 2*maxSOCKSField {
+		return nil, errors.New("pluggable transport arguments are too long")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	tcpConn := conn.(*net.TCPConn)
+	if deadline, ok := ctx.Deadline(); ok {
+		tcpConn.SetDeadline(deadline)
+	}
+	if err := socksHandshake(tcpConn, host, uint16(port), args); err != nil {
+		tcpConn.Close()
+		return nil, err
+	}
+	tcpConn.SetDeadline(time.Time{})
+	return tcpConn, nil
+}
+
+// socksHandshake authenticates to the proxy, and sends the connect request of host and port.
+func socksHandshake(conn io.ReadWriter, host string, port uint16, args string) error {
+	method := byte(socksAuthNone)
+	if args != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion || reply[1] != method {
+		return fmt.Errorf("pluggable transport rejected the SOCKS5 authentication method %d", method)
+	}
+	if method == socksAuthPassword {
+		user, password := args, "\x00"
+		if len(args) > maxSOCKSField {
+			user, password = args[:maxSOCKSField], args[maxSOCKSField:]
+		}
+		req := append([]byte{socksPasswordVersion, byte(len(user))}, user...)
+		req = append(append(req, byte(len(password))), password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("pluggable transport rejected the arguments of the server")
+		}
+	}
+
+	req := []byte{socksVersion, socksCmdConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip = ip.Unmap(); ip.Is4() {
+			req = append(req, socksAddrIPv4)
+		} else {
+			req = append(req, socksAddrIPv6)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > maxSOCKSField {
+			return errors.New("domain name is too long")
+		}
+		req = append(append(req, socksAddrDomain, byte(len(host))), host...)
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(req, port)); err != nil {
+		return err
+	}
+
+	// The reply: [version][reply][reserved][address type][bound address][bound port].
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[1] != socksReplySucceeded {
+		return fmt.Errorf("pluggable transport failed to connect: SOCKS5 reply %d", header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case socksAddrIPv4:
+		addrLen = net.IPv4len
+	case socksAddrIPv6:
+		addrLen = net.IPv6len
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("invalid SOCKS5 address type %d", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
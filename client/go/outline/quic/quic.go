@@ -0,0 +1,152 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quic applies the QUIC (HTTP/3) policy of the tunnel.
+//
+// Browsers race HTTP/3 over UDP port 443 against TCP. When the server doesn't relay UDP, the
+// QUIC attempts time out silently, and page loads stall until the browser gives up on them.
+// Blocking QUIC answers the attempts with ICMP port unreachable instead, so browsers fall back
+// to TCP right away.
+package quic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+)
+
+// Policy selects how the tunnel handles QUIC traffic.
+type Policy string
+
+const (
+	// PolicyAllow relays QUIC traffic like any other UDP traffic. It is the default.
+	PolicyAllow Policy = "allow"
+
+	// PolicyBlock rejects QUIC traffic, so that browsers use TCP.
+	PolicyBlock Policy = "block"
+)
+
+// Port is the UDP port of QUIC traffic.
+const Port = 443
+
+// ParsePolicy parses the policy in s. The empty string is [PolicyAllow].
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case "", PolicyAllow:
+		return PolicyAllow, nil
+	case PolicyBlock:
+		return PolicyBlock, nil
+	}
+	return "", fmt.Errorf("unsupported QUIC policy %q", s)
+}
+
+const (
+	ipv4HeaderLen   = 20
+	ipv6HeaderLen   = 40
+	udpHeaderLen    = 8
+	icmpHeaderLen   = 8
+	protocolICMP    = 1
+	protocolUDP     = 17
+	protocolICMPv6  = 58
+	replyHopLimit   = 64
+	maxICMPv4Packet = 576
+	maxICMPv6Packet = 1280
+)
+
+type blockingWriter struct {
+	w, reply io.Writer
+}
+
+// NewBlockingWriter creates a writer of IP packets to w, which drops the QUIC packets and writes
+// an ICMP port unreachable packet to reply for each of them.
+func NewBlockingWriter(w, reply io.Writer) io.Writer {
+	return &blockingWriter{w: w, reply: reply}
+}
+
+func (b *blockingWriter) Write(pkt []byte) (int, error) {
+	if !IsQUICPacket(pkt) {
+		return b.w.Write(pkt)
+	}
+	if resp := portUnreachable(pkt); resp != nil {
+		b.reply.Write(resp)
+	}
+	return len(pkt), nil
+}
+
+// IsQUICPacket returns whether pkt is an IPv4 or IPv6 UDP packet to port 443.
+func IsQUICPacket(pkt []byte) bool {
+	udp := udpDatagram(pkt)
+	return len(udp) >= udpHeaderLen && binary.BigEndian.Uint16(udp[2:]) == Port
+}
+
+// udpDatagram returns the UDP datagram of pkt, or nil if it isn't an unfragmented UDP packet or
+// the first fragment of one.
+func udpDatagram(pkt []byte) []byte {
+	if len(pkt) == 0 {
+		return nil
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < ipv4HeaderLen || pkt[9] != protocolUDP || binary.BigEndian.Uint16(pkt[6:])&0x1FFF != 0 {
+			return nil
+		}
+		if headerLen := int(pkt[0]&0x0F) * 4; headerLen >= ipv4HeaderLen && headerLen <= len(pkt) {
+			return pkt[headerLen:]
+		}
+	case 6:
+		if len(pkt) >= ipv6HeaderLen && pkt[6] == protocolUDP {
+			return pkt[ipv6HeaderLen:]
+		}
+	}
+	return nil
+}
+
+// portUnreachable returns the ICMP port unreachable packet answering pkt.
+func portUnreachable(pkt []byte) []byte {
+	if pkt[0]>>4 == 4 {
+		original := pkt[:min(len(pkt), maxICMPv4Packet-ipv4HeaderLen-icmpHeaderLen)]
+		resp := make([]byte, ipv4HeaderLen+icmpHeaderLen+len(original))
+		resp[0] = 4<<4 | ipv4HeaderLen/4
+		binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)))
+		resp[8] = replyHopLimit
+		resp[9] = protocolICMP
+		copy(resp[12:16], pkt[16:20])
+		copy(resp[16:20], pkt[12:16])
+		binary.BigEndian.PutUint16(resp[10:], ^wire.Checksum(0, resp[:ipv4HeaderLen]))
+
+		icmp := resp[ipv4HeaderLen:]
+		icmp[0], icmp[1] = 3, 3 // Destination unreachable, port unreachable.
+		copy(icmp[icmpHeaderLen:], original)
+		binary.BigEndian.PutUint16(icmp[2:], ^wire.Checksum(0, icmp))
+		return resp
+	}
+
+	original := pkt[:min(len(pkt), maxICMPv6Packet-ipv6HeaderLen-icmpHeaderLen)]
+	resp := make([]byte, ipv6HeaderLen+icmpHeaderLen+len(original))
+	resp[0] = 6 << 4
+	binary.BigEndian.PutUint16(resp[4:], uint16(icmpHeaderLen+len(original)))
+	resp[6] = protocolICMPv6
+	resp[7] = replyHopLimit
+	copy(resp[8:24], pkt[24:40])
+	copy(resp[24:40], pkt[8:24])
+
+	icmp := resp[ipv6HeaderLen:]
+	icmp[0], icmp[1] = 1, 4 // Destination unreachable, port unreachable.
+	copy(icmp[icmpHeaderLen:], original)
+	sum := wire.IPv6PseudoHeaderSum(resp, len(icmp), protocolICMPv6)
+	binary.BigEndian.PutUint16(icmp[2:], ^wire.Checksum(sum, icmp))
+	return resp
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func newUDPPacket(ipv6 bool, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], 50000)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+	if ipv6 {
+		ip := make([]byte, ipv6HeaderLen)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6], ip[7] = protocolUDP, 64
+		ip[23], ip[39] = 1, 2
+		return append(ip, udp...)
+	}
+	ip := make([]byte, ipv4HeaderLen)
+	ip[0] = 4<<4 | 5
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(udp)))
+	ip[8], ip[9] = 64, protocolUDP
+	copy(ip[12:], []byte{10, 0, 0, 1, 192, 0, 2, 1})
+	return append(ip, udp...)
+}
+
+func TestParsePolicy(t *testing.T) {
+	for s, want := range map[string]Policy{"": PolicyAllow, "allow": PolicyAllow, "block": PolicyBlock} {
+		got, err := ParsePolicy(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := ParsePolicy("drop")
+	require.Error(t, err)
+}
+
+func TestBlockingWriter_IPv4(t *testing.T) {
+	var w, reply bytes.Buffer
+	bw := NewBlockingWriter(&w, &reply)
+
+	dns := newUDPPacket(false, 53, []byte("query"))
+	n, err := bw.Write(dns)
+	require.NoError(t, err)
+	require.Equal(t, len(dns), n)
+	require.Equal(t, dns, w.Bytes())
+	require.Zero(t, reply.Len())
+
+	w.Reset()
+	quic := newUDPPacket(false, Port, []byte("initial"))
+	n, err = bw.Write(quic)
+	require.NoError(t, err)
+	require.Equal(t, len(quic), n)
+	require.Zero(t, w.Len())
+
+	resp := reply.Bytes()
+	require.Len(t, resp, ipv4HeaderLen+icmpHeaderLen+len(quic))
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(0, resp[:ipv4HeaderLen]))
+	require.Equal(t, byte(protocolICMP), resp[9])
+	require.Equal(t, quic[16:20], resp[12:16], "the source must be the original destination")
+	require.Equal(t, quic[12:16], resp[16:20], "the destination must be the original source")
+	icmp := resp[ipv4HeaderLen:]
+	require.Equal(t, []byte{3, 3}, icmp[:2])
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(0, icmp))
+	require.Equal(t, quic, icmp[icmpHeaderLen:])
+}
+
+func TestBlockingWriter_IPv6(t *testing.T) {
+	var w, reply bytes.Buffer
+	quic := newUDPPacket(true, Port, make([]byte, 1400))
+	_, err := NewBlockingWriter(&w, &reply).Write(quic)
+	require.NoError(t, err)
+	require.Zero(t, w.Len())
+
+	resp := reply.Bytes()
+	require.Len(t, resp, maxICMPv6Packet)
+	require.Equal(t, byte(protocolICMPv6), resp[6])
+	require.Equal(t, quic[24:40], resp[8:24])
+	require.Equal(t, quic[8:24], resp[24:40])
+	icmp := resp[ipv6HeaderLen:]
+	require.Equal(t, []byte{1, 4}, icmp[:2])
+	sum := wire.IPv6PseudoHeaderSum(resp, len(icmp), protocolICMPv6)
+	require.Equal(t, uint16(0xFFFF), wire.Checksum(sum, icmp))
+}
+
+func TestIsQUICPacket(t *testing.T) {
+	require.True(t, IsQUICPacket(newUDPPacket(false, Port, nil)))
+	require.True(t, IsQUICPacket(newUDPPacket(true, Port, nil)))
+	require.False(t, IsQUICPacket(newUDPPacket(false, 53, nil)))
+
+	tcp := newUDPPacket(false, Port, nil)
+	tcp[9] = 6
+	require.False(t, IsQUICPacket(tcp))
+
+	fragment := newUDPPacket(false, Port, nil)
+	binary.BigEndian.PutUint16(fragment[6:], 100)
+	require.False(t, IsQUICPacket(fragment))
+
+	require.False(t, IsQUICPacket(nil))
+	require.False(t, IsQUICPacket([]byte{0x45}))
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/porthop"
+)
+
+// portHoppingConfigJSON is the port hopping section of the QUIC transports, for the servers
+// listening on a range of ports, against the networks throttling the UDP flows per port.
+type portHoppingConfigJSON struct {
+	// Ports are the ports of the server to hop between, like "20000-30000" or "443,20000-30000".
+	// The client starts at Port, or at a random one of Ports if Port is not set.
+	Ports string `json:"ports,omitempty"`
+
+	// HopIntervalSeconds is the interval of the hops, which also happen as soon as the server stops
+	// answering. Defaults to 30 seconds.
+	HopIntervalSeconds int `json:"hopIntervalSeconds,omitempty"`
+}
+
+// parse validates the port hopping config and the port of the server, and returns the parsed
+// ports, which are nil without port hopping.
+func (conf *portHoppingConfigJSON) parse(port uint16) (porthop.Ports, error) {
+	if conf.Ports == "" {
+		if port == 0 {
+			return nil, newIllegalConfigErrorWithDetails("port is not valid", "port", port, "within range [1..65535]", nil)
+		}
+		return nil, nil
+	}
+	ports, err := porthop.ParsePorts(conf.Ports)
+	if err != nil {
+		return nil, newIllegalConfigErrorWithDetails("ports are not valid",
+			"ports", conf.Ports, "ports and port ranges, like 20000-30000", err)
+	}
+	if conf.HopIntervalSeconds != 0 && conf.HopIntervalSeconds < int(porthop.MinInterval/time.Second) {
+		return nil, newIllegalConfigErrorWithDetails("hop interval is not valid",
+			"hopIntervalSeconds", conf.HopIntervalSeconds, fmt.Sprintf("at least %d seconds", porthop.MinInterval/time.Second), nil)
+	}
+	return ports, nil
+}
+
+// newQUICServerDialer returns the Dial function of the QUIC transports, which resolves the host of
+// the server on every connection, so that the connections follow its DNS changes, and listens on
+// a UDP socket of the UDP dialer, bypassing the VPN, or on the socket of dialers.ListenPacket.
+// With ports, the socket hops between the ports of the server, every hopInterval.
+func newQUICServerDialer(host string, port uint16, ports porthop.Ports, hopInterval time.Duration, dialers TransportDialers) func(ctx context.Context) (net.PacketConn, net.Addr, error) {
+	return func(ctx context.Context) (net.PacketConn, net.Addr, error) {
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			resolver := dialers.UDP.Resolver
+			if resolver == nil {
+				resolver = net.DefaultResolver
+			}
+			network := "ip"
+			if dialers.ListenPacket != nil {
+				network = "ip4"
+			}
+			ips, err := resolver.LookupNetIP(ctx, network, host)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(ips) == 0 {
+				return nil, nil, fmt.Errorf("no IP address for %q", host)
+			}
+			ip = ips[0]
+		}
+		var pc net.PacketConn
+		if dialers.ListenPacket != nil {
+			pc, err = dialers.ListenPacket(ctx)
+		} else {
+			lc := net.ListenConfig{Control: dialers.UDP.Control}
+			pc, err = lc.ListenPacket(ctx, "udp", "")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		serverPort := port
+		if ports != nil {
+			pc = porthop.NewPacketConn(pc, ports, hopInterval)
+			if serverPort == 0 {
+				serverPort = ports.Random()
+			}
+		}
+		return pc, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), serverPort)), nil
+	}
+}
+
+// newQUICTLSConfig returns the TLS config of the connections to a QUIC server. insecure skips the
+// verification of the certificate of the server, and pinSHA256, if not empty, is the hex SHA-256
+// of a certificate the chain of the server must contain.
+func newQUICTLSConfig(serverName string, insecure bool, pinSHA256 string, alpn []string) (*tls.Config, error) {
+	conf := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure, NextProtos: alpn}
+	if pinSHA256 == "" {
+		return conf, nil
+	}
+	normalized := strings.NewReplacer(":", "", "-", "").Replace(strings.ToLower(pinSHA256))
+	pin, err := hex.DecodeString(normalized)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, newIllegalConfigErrorWithDetails("certificate pin is not valid",
+			"pinSHA256", pinSHA256, "the hex SHA-256 of a certificate", err)
+	}
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			if hash := sha256.Sum256(raw); bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+		return errors.New("no certificate of the server matches the pin")
+	}
+	return conf, nil
+}
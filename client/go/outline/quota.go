@@ -0,0 +1,67 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// quotaConfigJSON is the "quota" section of a transport config, set by providers that cap the
+// data of their users, usually in dynamic configs.
+type quotaConfigJSON struct {
+	// BytesAllowed is the data allowance of the current period.
+	BytesAllowed int64 `json:"bytesAllowed"`
+
+	// BytesUsed is how much of the allowance was used when the config was fetched.
+	BytesUsed int64 `json:"bytesUsed,omitempty"`
+
+	// ResetDate is when the allowance is renewed, as an RFC 3339 time or a "2006-01-02" date in
+	// UTC.
+	ResetDate string `json:"resetDate,omitempty"`
+}
+
+// quota validates the "quota" section of the config, and returns the [stats.Quota] emitting an
+// [EventQuotaWarning] at each threshold, or nil if the config has no quota.
+func (conf *configJSON) quota() (*stats.Quota, error) {
+	q := conf.Quota
+	if q == nil {
+		return nil, nil
+	}
+	if q.BytesAllowed <= 0 {
+		return nil, newIllegalConfigErrorWithDetails("quota is not valid",
+			"quota.bytesAllowed", q.BytesAllowed, "a positive number of bytes", nil)
+	}
+	if q.BytesUsed < 0 {
+		return nil, newIllegalConfigErrorWithDetails("quota usage is not valid",
+			"quota.bytesUsed", q.BytesUsed, "a positive number of bytes", nil)
+	}
+	var resetAt time.Time
+	if q.ResetDate != "" {
+		var err error
+		if resetAt, err = time.Parse(time.RFC3339, q.ResetDate); err != nil {
+			if resetAt, err = time.Parse(time.DateOnly, q.ResetDate); err != nil {
+				return nil, newIllegalConfigErrorWithDetails("quota reset date is not valid",
+					"quota.resetDate", q.ResetDate, "an RFC 3339 time or a YYYY-MM-DD date", err)
+			}
+		}
+	}
+	return stats.NewQuota(q.BytesAllowed, q.BytesUsed, resetAt, func(usage stats.QuotaUsage) {
+		logger.Warn("quota threshold reached", "percent", usage.Percent)
+		event.Emit(EventQuotaWarning, usage)
+	}), nil
+}
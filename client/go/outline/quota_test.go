@@ -0,0 +1,66 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigQuota(t *testing.T) {
+	conf, err := parseConfigFromJSON(`{}`)
+	require.NoError(t, err)
+	q, err := conf.quota()
+	require.NoError(t, err)
+	require.Nil(t, q)
+
+	for _, input := range []string{
+		`{"quota": {"bytesAllowed": 1000, "bytesUsed": 10, "resetDate": "2024-07-01"}}`,
+		`{"quota": {"bytesAllowed": 1000, "resetDate": "2024-07-01T00:00:00+02:00"}}`,
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err, input)
+		q, err := conf.quota()
+		require.NoError(t, err, input)
+		require.Equal(t, int64(1000), q.Usage().BytesAllowed, input)
+		require.NotNil(t, q.Usage().ResetTime, input)
+	}
+
+	for _, input := range []string{
+		`{"quota": {}}`,
+		`{"quota": {"bytesAllowed": 1000, "bytesUsed": -1}}`,
+		`{"quota": {"bytesAllowed": 1000, "resetDate": "next month"}}`,
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err, input)
+		_, err = conf.quota()
+		perr := platerrors.ToPlatformError(err)
+		require.NotNil(t, perr, input)
+		require.Equal(t, platerrors.IllegalConfig, perr.Code, input)
+	}
+}
+
+func TestSameTransport_IgnoresQuota(t *testing.T) {
+	a, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":8080,"quota":{"bytesAllowed":1000,"bytesUsed":10}}`)
+	require.NoError(t, err)
+	b, err := parseConfigFromJSON(`{"host":"192.0.2.1","port":8080,"quota":{"bytesAllowed":1000,"bytesUsed":500}}`)
+	require.NoError(t, err)
+	c, err := parseConfigFromJSON(`{"host":"192.0.2.2","port":8080}`)
+	require.NoError(t, err)
+	require.True(t, sameTransport(a, b))
+	require.False(t, sameTransport(a, c))
+}
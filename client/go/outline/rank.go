@@ -0,0 +1,122 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/geoip"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+const defaultRankConcurrency = 8
+
+// rankServersJSON is the input of [MethodRankServers].
+type rankServersJSON struct {
+	// Transports is the list of transport configs to test.
+	Transports []json.RawMessage `json:"transports"`
+
+	// MaxConcurrency is the maximum number of servers tested at the same time.
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+}
+
+// rankedServerJSON is one item of the [MethodRankServers] output.
+type rankedServerJSON struct {
+	// Index of the transport config in the input list.
+	Index  int                         `json:"index"`
+	Result *connectivityTestResultJSON `json:"result,omitempty"`
+	Error  *platerrors.PlatformError   `json:"error,omitempty"`
+
+	// Location is the country and autonomous system of the server, if the databases of
+	// [MethodSetGeoIPDatabases] know it.
+	Location *geoip.Info `json:"location,omitempty"`
+}
+
+// rankServers tests all transport configs in input concurrently, and returns a JSON array of
+// rankedServerJSON, ordered from the best server to the worst. It returns ctx.Err() if ctx is
+// done before all servers are tested.
+func rankServers(ctx context.Context, input string) (string, error) {
+	var req rankServersJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid rank servers request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	concurrency := req.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultRankConcurrency
+	}
+
+	results := make([]rankedServerJSON, len(req.Transports))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, len(req.Transports)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res, err := runConnectivityTest(ctx, string(req.Transports[i]))
+				results[i] = rankedServerJSON{Index: i, Result: res, Error: platerrors.ToPlatformError(err),
+					Location: locateServer(ctx, string(req.Transports[i]))}
+			}
+		}()
+	}
+	for i := range req.Transports {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	sortRankedServers(results)
+	out, err := json.Marshal(results)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal rank servers result",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// sortRankedServers sorts servers so that reachable servers come first, then servers supporting
+// UDP, then servers whose TCP check is faster. Ties keep their input order.
+func sortRankedServers(servers []rankedServerJSON) {
+	sort.SliceStable(servers, func(i, j int) bool {
+		a, b := servers[i].Result, servers[j].Result
+		aTCP, bTCP := a != nil && a.TCP.Success, b != nil && b.TCP.Success
+		if aTCP != bTCP {
+			return aTCP
+		}
+		if !aTCP {
+			return false
+		}
+		if a.UDP.Success != b.UDP.Success {
+			return a.UDP.Success
+		}
+		return a.TCP.DurationMs < b.TCP.DurationMs
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortRankedServers(t *testing.T) {
+	ok := func(tcpMs int64, udp bool) *connectivityTestResultJSON {
+		return &connectivityTestResultJSON{
+			TCP: protocolTestResultJSON{Success: true, DurationMs: tcpMs},
+			UDP: protocolTestResultJSON{Success: udp},
+		}
+	}
+	servers := []rankedServerJSON{
+		{Index: 0, Error: &platerrors.PlatformError{Code: platerrors.IllegalConfig}},
+		{Index: 1, Result: ok(50, false)},
+		{Index: 2, Result: &connectivityTestResultJSON{}},
+		{Index: 3, Result: ok(300, true)},
+		{Index: 4, Result: ok(20, false)},
+		{Index: 5, Result: ok(100, true)},
+	}
+	sortRankedServers(servers)
+
+	got := make([]int, len(servers))
+	for i, s := range servers {
+		got[i] = s.Index
+	}
+	require.Equal(t, []int{5, 3, 4, 1, 0, 2}, got)
+}
+
+func TestRankServers_InvalidConfigs(t *testing.T) {
+	out, err := rankServers(context.Background(), `{"transports":[{"host":""},"not an object"],"maxConcurrency":1}`)
+	require.NoError(t, err)
+
+	var got []rankedServerJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &got))
+	require.Len(t, got, 2)
+	for i, s := range got {
+		require.Equal(t, i, s.Index)
+		require.Nil(t, s.Result)
+		require.NotNil(t, s.Error)
+	}
+}
+
+func TestRankServers_InvalidInput(t *testing.T) {
+	_, err := rankServers(context.Background(), `[]`)
+	require.Error(t, err)
+}
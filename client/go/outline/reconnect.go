@@ -0,0 +1,142 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// reconnectConfigJSON is the "reconnect" section of a transport config. It tunes how the tunnel
+// retries once the server becomes unreachable.
+type reconnectConfigJSON struct {
+	// InitialDelaySeconds is the delay before the first attempt. Defaults to 1 second.
+	InitialDelaySeconds float64 `json:"initialDelaySeconds,omitempty"`
+
+	// Multiplier multiplies the delay after each failed attempt. Defaults to 2.
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// MaxDelaySeconds caps the delay between attempts. Defaults to 60 seconds.
+	MaxDelaySeconds float64 `json:"maxDelaySeconds,omitempty"`
+
+	// Jitter randomizes each delay by up to this fraction of it, from 0 to 1, so that many clients
+	// losing the same server don't retry in lockstep. Defaults to 0.2.
+	Jitter *float64 `json:"jitter,omitempty"`
+
+	// MaxAttempts is how many attempts are made before giving up. Defaults to 10. The attempts
+	// never stop while the kill switch is enabled.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+// reconnectPolicy holds the validated reconnect options of a config, with the defaults applied.
+type reconnectPolicy struct {
+	initialDelay, maxDelay time.Duration
+	multiplier, jitter     float64
+	maxAttempts            int
+}
+
+var defaultReconnectPolicy = reconnectPolicy{
+	initialDelay: 1 * time.Second,
+	maxDelay:     1 * time.Minute,
+	multiplier:   2,
+	jitter:       0.2,
+	maxAttempts:  10,
+}
+
+// reconnectAttemptEventJSON is the data of [EventReconnectAttempt].
+type reconnectAttemptEventJSON struct {
+	// Attempt is the number of the scheduled attempt, starting at 1.
+	Attempt int `json:"attempt"`
+
+	// MaxAttempts is the number of attempts before giving up, or 0 if the attempts don't stop
+	// because the kill switch is enabled.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// DelayMs is how long until the attempt.
+	DelayMs int64 `json:"delayMs"`
+
+	// Error is why the previous attempt, or the health check, failed.
+	Error *platerrors.PlatformError `json:"error,omitempty"`
+}
+
+// reconnectPolicy validates the "reconnect" section of the config, and returns it with the
+// defaults applied.
+func (conf *configJSON) reconnectPolicy() (reconnectPolicy, error) {
+	p := defaultReconnectPolicy
+	r := conf.Reconnect
+	if r == nil {
+		return p, nil
+	}
+	if r.InitialDelaySeconds < 0 || math.IsInf(r.InitialDelaySeconds, 0) {
+		return p, newIllegalConfigErrorWithDetails("reconnect delay is not valid",
+			"reconnect.initialDelaySeconds", r.InitialDelaySeconds, "a positive number of seconds", nil)
+	}
+	if r.MaxDelaySeconds < 0 || math.IsInf(r.MaxDelaySeconds, 0) {
+		return p, newIllegalConfigErrorWithDetails("reconnect delay is not valid",
+			"reconnect.maxDelaySeconds", r.MaxDelaySeconds, "a positive number of seconds", nil)
+	}
+	if r.Multiplier != 0 && (r.Multiplier < 1 || math.IsInf(r.Multiplier, 0)) {
+		return p, newIllegalConfigErrorWithDetails("reconnect multiplier is not valid",
+			"reconnect.multiplier", r.Multiplier, "a number of at least 1", nil)
+	}
+	if r.Jitter != nil && (*r.Jitter < 0 || *r.Jitter > 1) {
+		return p, newIllegalConfigErrorWithDetails("reconnect jitter is not valid",
+			"reconnect.jitter", *r.Jitter, "a fraction from 0 to 1", nil)
+	}
+	if r.MaxAttempts < 0 {
+		return p, newIllegalConfigErrorWithDetails("reconnect attempts are not valid",
+			"reconnect.maxAttempts", r.MaxAttempts, "a positive number", nil)
+	}
+	if r.InitialDelaySeconds > 0 {
+		p.initialDelay = time.Duration(r.InitialDelaySeconds * float64(time.Second))
+	}
+	if r.MaxDelaySeconds > 0 {
+		p.maxDelay = time.Duration(r.MaxDelaySeconds * float64(time.Second))
+	}
+	if p.maxDelay < p.initialDelay {
+		return p, newIllegalConfigErrorWithDetails("reconnect delay is not valid",
+			"reconnect.maxDelaySeconds", r.MaxDelaySeconds, "at least the initial delay", nil)
+	}
+	if r.Multiplier != 0 {
+		p.multiplier = r.Multiplier
+	}
+	if r.Jitter != nil {
+		p.jitter = *r.Jitter
+	}
+	if r.MaxAttempts > 0 {
+		p.maxAttempts = r.MaxAttempts
+	}
+	return p, nil
+}
+
+// nextDelay returns the delay after delay, without jitter.
+func (p reconnectPolicy) nextDelay(delay time.Duration) time.Duration {
+	next := time.Duration(float64(delay) * p.multiplier)
+	if next > p.maxDelay || next < 0 {
+		return p.maxDelay
+	}
+	return next
+}
+
+// withJitter randomizes delay by up to the jitter fraction of it, in both directions.
+func (p reconnectPolicy) withJitter(delay time.Duration) time.Duration {
+	if p.jitter == 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + p.jitter*(2*rand.Float64()-1)))
+}
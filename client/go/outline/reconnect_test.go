@@ -0,0 +1,92 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigReconnectPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  reconnectPolicy
+	}{
+		{
+			name:  "defaults",
+			input: `{}`,
+			want:  defaultReconnectPolicy,
+		},
+		{
+			name:  "all",
+			input: `{"reconnect": {"initialDelaySeconds": 0.5, "multiplier": 1.5, "maxDelaySeconds": 30, "jitter": 0, "maxAttempts": 20}}`,
+			want:  reconnectPolicy{initialDelay: 500 * time.Millisecond, maxDelay: 30 * time.Second, multiplier: 1.5, maxAttempts: 20},
+		},
+		{
+			name:  "partial",
+			input: `{"reconnect": {"maxAttempts": 3}}`,
+			want:  reconnectPolicy{initialDelay: time.Second, maxDelay: time.Minute, multiplier: 2, jitter: 0.2, maxAttempts: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := parseConfigFromJSON(tt.input)
+			require.NoError(t, err)
+			got, err := conf.reconnectPolicy()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfigReconnectPolicy_Invalid(t *testing.T) {
+	for _, input := range []string{
+		`{"reconnect": {"initialDelaySeconds": -1}}`,
+		`{"reconnect": {"maxDelaySeconds": -1}}`,
+		`{"reconnect": {"initialDelaySeconds": 10, "maxDelaySeconds": 5}}`,
+		`{"reconnect": {"multiplier": 0.5}}`,
+		`{"reconnect": {"jitter": 1.5}}`,
+		`{"reconnect": {"maxAttempts": -1}}`,
+	} {
+		conf, err := parseConfigFromJSON(input)
+		require.NoError(t, err, input)
+		_, err = conf.reconnectPolicy()
+		perr := platerrors.ToPlatformError(err)
+		require.NotNil(t, perr, input)
+		require.Equal(t, platerrors.IllegalConfig, perr.Code, input)
+	}
+}
+
+func TestReconnectPolicy_Delays(t *testing.T) {
+	p := reconnectPolicy{initialDelay: time.Second, maxDelay: 5 * time.Second, multiplier: 2}
+	delay := p.initialDelay
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, delay)
+		delay = p.nextDelay(delay)
+	}
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.withJitter(2 * time.Second)
+		require.GreaterOrEqual(t, d, time.Second)
+		require.LessOrEqual(t, d, 3*time.Second)
+	}
+}
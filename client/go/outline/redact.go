@@ -0,0 +1,225 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces the secrets in redacted configs.
+const redacted = "REDACTED"
+
+// sensitiveKeys are the config keys holding secrets or user identifiers, lowercased and without
+// separators.
+var sensitiveKeys = map[string]bool{
+	"password": true, "passwd": true, "secret": true, "key": true, "privatekey": true,
+	"presharedkey": true, "accesskey": true, "psk": true, "token": true, "accesstoken": true, "auth": true,
+	"authorization": true, "uuid": true, "user": true, "userid": true, "username": true,
+	// The obfs4 certificates of the pluggable transports identify and authenticate the servers.
+	"cert": true,
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return sensitiveKeys[key]
+}
+
+// RedactConfig returns config with its secrets, passwords and user identifiers replaced by
+// "REDACTED", so that it can be logged or attached to bug reports. The config can be a JSON
+// config, a ss:// access key, a ssconf:// dynamic access key or a hysteria2:// or tuic:// URL.
+// Configs that fail to parse are redacted on a best-effort basis.
+func RedactConfig(config string) string {
+	trimmed := strings.TrimSpace(config)
+	if out, ok := redactJSON(trimmed); ok {
+		return out
+	}
+	if u, ok := redactURL(trimmed); ok {
+		return u
+	}
+	return redactText(config)
+}
+
+// redactJSON redacts the JSON value in s, keeping its structure. It returns false if s is not
+// valid JSON.
+func redactJSON(s string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var out bytes.Buffer
+	if err := redactJSONValue(dec, &out, false); err != nil {
+		return "", false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return "", false
+	}
+	return out.String(), true
+}
+
+// redactJSONValue copies the next JSON value from dec to out, replacing it if it is sensitive.
+func redactJSONValue(dec *json.Decoder, out *bytes.Buffer, sensitive bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		isObject := t == '{'
+		out.WriteRune(rune(t))
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			valueSensitive := sensitive
+			if isObject {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				writeJSONString(out, key)
+				out.WriteByte(':')
+				valueSensitive = sensitive || isSensitiveKey(key)
+			}
+			if err := redactJSONValue(dec, out, valueSensitive); err != nil {
+				return err
+			}
+		}
+		end, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		out.WriteRune(rune(end.(json.Delim)))
+	case string:
+		if sensitive {
+			writeJSONString(out, redacted)
+		} else {
+			writeJSONString(out, redactString(t))
+		}
+	case nil:
+		out.WriteString("null")
+	default:
+		if sensitive {
+			writeJSONString(out, redacted)
+		} else {
+			b, _ := json.Marshal(t)
+			out.Write(b)
+		}
+	}
+	return nil
+}
+
+func writeJSONString(out *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	out.Write(b)
+}
+
+// redactString redacts the configs nested in a string value, like the transport config in the
+// VPN config.
+func redactString(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") {
+		if out, ok := redactJSON(trimmed); ok {
+			return out
+		}
+	}
+	if out, ok := redactURL(trimmed); ok {
+		return out
+	}
+	return s
+}
+
+// redactURL redacts ss://, ssconf://, hysteria2:// and tuic:// URLs. It returns false if s is none
+// of them.
+func redactURL(s string) (string, bool) {
+	scheme, _, found := strings.Cut(s, "://")
+	if !found {
+		return "", false
+	}
+	switch strings.ToLower(scheme) {
+	case "ss":
+		return redactSSURL(s), true
+	case "ssconf":
+		u, err := url.Parse(s)
+		if err != nil {
+			return redactText(s), true
+		}
+		// The path and query of dynamic keys identify the user.
+		u.User = nil
+		if u.Path != "" && u.Path != "/" {
+			u.Path = "/" + redacted
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = redacted
+		}
+		return u.String(), true
+	case "hysteria2", "hy2", "tuic":
+		u, err := url.Parse(s)
+		if err != nil {
+			return redactText(s), true
+		}
+		// The user info is the auth of Hysteria 2, and the UUID and password of TUIC.
+		if u.User != nil {
+			u.User = url.User(redacted)
+		}
+		if query := u.Query(); query.Has("obfs-password") {
+			query.Set("obfs-password", redacted)
+			u.RawQuery = query.Encode()
+		}
+		return u.String(), true
+	}
+	return "", false
+}
+
+// redactSSURL replaces the credentials of a ss:// URL, keeping the cipher when it is visible.
+func redactSSURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.User == nil {
+		// Legacy keys encode the whole "method:password@host:port" in base64.
+		rest := strings.SplitN(s[len("ss://"):], "#", 2)
+		out := "ss://" + redacted
+		if len(rest) == 2 {
+			out += "#" + rest[1]
+		}
+		return out
+	}
+	if method, _, hasPassword := strings.Cut(u.User.String(), ":"); hasPassword {
+		u.User = url.UserPassword(method, redacted)
+	} else {
+		// SIP002 keys encode "method:password" in base64.
+		u.User = url.User(redacted)
+	}
+	return u.String()
+}
+
+var (
+	// sensitiveKeyPattern matches the key of a sensitive "key": value or key: value pair.
+	sensitiveKeyPattern = `(?i)("?\b(?:password|passwd|secret|(?:(?:private|pre_?shared|access)_?)?key|psk|(?:access_?)?token|auth(?:orization)?|uuid|cert|user(?:_?id|_?name)?)"?\s*[:=]\s*)`
+	sensitiveJSONValue  = regexp.MustCompile(sensitiveKeyPattern + `"(?:[^"\\]|\\.)*"`)
+	sensitiveTextValue  = regexp.MustCompile(sensitiveKeyPattern + `[^\s,}"]+`)
+	ssUserInfo          = regexp.MustCompile(`(?i)(ss://)[^@\s/"]+@`)
+	ssLegacy            = regexp.MustCompile(`(?i)(ss://)[A-Za-z0-9+/=_-]+`)
+)
+
+// redactText redacts the sensitive values of a config that could not be parsed.
+func redactText(s string) string {
+	s = sensitiveJSONValue.ReplaceAllString(s, `${1}"`+redacted+`"`)
+	s = sensitiveTextValue.ReplaceAllString(s, "${1}"+redacted)
+	s = ssUserInfo.ReplaceAllString(s, "${1}"+redacted+"@")
+	return ssLegacy.ReplaceAllString(s, "${1}"+redacted)
+}
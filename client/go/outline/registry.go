@@ -0,0 +1,149 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// TransportAPIVersion is the version of the transport registry API. Transports registered for
+// another version are rejected, as their parsers may not behave as the registry expects.
+const TransportAPIVersion = 1
+
+// transportTypeShadowsocks is the built-in transport, used when a config has no "$type".
+const transportTypeShadowsocks = "shadowsocks"
+
+// TransportDialers are the dialers a transport must use to connect to its servers, so that its
+// traffic bypasses the VPN.
+type TransportDialers struct {
+	TCP net.Dialer
+	UDP net.Dialer
+
+	// ListenPacket, if not nil, creates the sockets of the QUIC transports to their servers
+	// instead of the UDP sockets of UDP, e.g. to tunnel their packets in ICMP. The sockets may
+	// only support IPv4, so the servers are resolved to IPv4 addresses for them.
+	ListenPacket func(ctx context.Context) (net.PacketConn, error)
+
+	// NoSubprocesses forbids the transport to run processes, like managed pluggable transports,
+	// because the config comes from a less privileged process.
+	NoSubprocesses bool
+}
+
+// TransportParser creates the dialers of a transport from its JSON config. The routing, "dns",
+// "quic" and "udpOverTcp" sections of the config are handled by the client, for all transports.
+//
+// Errors should be [platerrors.PlatformError] with the IllegalConfig code for invalid configs.
+type TransportParser func(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error)
+
+var transportRegistryMu sync.RWMutex
+var transportRegistry = map[string]TransportParser{
+	transportTypeShadowsocks: parseShadowsocksTransport,
+}
+
+// RegisterTransport registers the transport selected by the "$type" name in transport configs,
+// so that transports can be added without changing this package. It is usually called from the
+// init function of the package of the transport.
+//
+// apiVersion must be [TransportAPIVersion], and name must not be registered already.
+func RegisterTransport(name string, apiVersion int, parse TransportParser) error {
+	if name == "" {
+		return fmt.Errorf("transport name must not be empty")
+	}
+	if parse == nil {
+		return fmt.Errorf("transport %q has no parser", name)
+	}
+	if apiVersion != TransportAPIVersion {
+		return fmt.Errorf("transport %q requires registry API version %d, but this is version %d", name, apiVersion, TransportAPIVersion)
+	}
+	transportRegistryMu.Lock()
+	defer transportRegistryMu.Unlock()
+	if _, ok := transportRegistry[name]; ok {
+		return fmt.Errorf("transport %q is already registered", name)
+	}
+	transportRegistry[name] = parse
+	return nil
+}
+
+// lookupTransport returns the parser of the transport type, which defaults to Shadowsocks.
+func lookupTransport(transportType string) (TransportParser, error) {
+	if transportType == "" {
+		transportType = transportTypeShadowsocks
+	}
+	transportRegistryMu.RLock()
+	defer transportRegistryMu.RUnlock()
+	parse, ok := transportRegistry[transportType]
+	if !ok {
+		return nil, newIllegalConfigErrorWithDetails("transport type is not supported", "$type", transportType,
+			"a registered transport type", nil)
+	}
+	return parse, nil
+}
+
+// parseTransportConfig creates the dialers of a transport config of any registered type, like the
+// configs nested in the configs of other transports.
+func parseTransportConfig(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var typed struct {
+		Type string `json:"$type"`
+	}
+	if err := json.Unmarshal(config, &typed); err != nil {
+		return nil, nil, newInvalidJSONError("transport config is not a valid JSON", string(config), err)
+	}
+	parse, err := lookupTransport(typed.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parse(config, dialers)
+}
+
+// parseShadowsocksTransport is the [TransportParser] of the built-in Shadowsocks transport.
+func parseShadowsocksTransport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	conf, err := parseConfigFromJSON(string(config))
+	if err != nil {
+		return nil, nil, err
+	}
+	obfs, err := conf.obfsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := conf.hostResolution(dialers.TCP, dialers.UDP)
+	if err != nil {
+		return nil, nil, err
+	}
+	proxyAddress := net.JoinHostPort(conf.Host, fmt.Sprint(conf.Port))
+	firstHop, err := conf.outboundProxyDialer(proxyAddress, dialers.TCP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if conf.PluggableTransport != nil {
+		if firstHop != nil {
+			return nil, nil, newIllegalConfigErrorWithDetails("pluggable transport cannot go through an outbound proxy",
+				"pluggableTransport", conf.PluggableTransport.Name, `no outbound proxy, or "direct"`, nil)
+		}
+		if firstHop, err = conf.PluggableTransport.streamDialer(dialers.TCP, dialers.NoSubprocesses); err != nil {
+			return nil, nil, err
+		}
+	}
+	client, err := newShadowsocksClient(conf.Host, int(conf.Port), conf.Method, conf.Password, obfs, conf.UDPObfs, conf.PreDial, conf.EarlyData, res, firstHop, dialers.TCP, dialers.UDP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.StreamDialer, client.PacketListener, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	var gotConfig string
+	parse := func(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+		gotConfig = string(config)
+		sd := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			return nil, errors.New("not implemented")
+		})
+		return sd, &transport.UDPListener{}, nil
+	}
+	require.NoError(t, RegisterTransport("test-transport", TransportAPIVersion, parse))
+	defer func() {
+		transportRegistryMu.Lock()
+		delete(transportRegistry, "test-transport")
+		transportRegistryMu.Unlock()
+	}()
+
+	require.ErrorContains(t, RegisterTransport("test-transport", TransportAPIVersion, parse), "already registered")
+	require.ErrorContains(t, RegisterTransport("shadowsocks", TransportAPIVersion, parse), "already registered")
+	require.ErrorContains(t, RegisterTransport("other", TransportAPIVersion+1, parse), "API version")
+	require.Error(t, RegisterTransport("", TransportAPIVersion, parse))
+	require.Error(t, RegisterTransport("other", TransportAPIVersion, nil))
+
+	config := `{"$type":"test-transport","server":"example.com","quic":"block"}`
+	got := NewClient(config)
+	require.Nil(t, got.Error)
+	require.Equal(t, config, gotConfig)
+	require.True(t, got.Client.BlockQUIC)
+	require.Equal(t, "test-transport transport", got.Client.description.Summary)
+
+	got = NewClient(`{"$type":"unknown-transport"}`)
+	require.NotNil(t, got.Error)
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"os"
+	"strings"
+)
+
+// OpenFiles returns the number of open file descriptors of the process, and how many of them are
+// sockets, from /proc/self/fd. Both are -1 if they can't be read.
+func OpenFiles() (files, sockets int) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1, -1
+	}
+	for _, entry := range entries {
+		target, err := os.Readlink("/proc/self/fd/" + entry.Name())
+		if err != nil {
+			// The descriptor was closed in the meantime, e.g. the one of ReadDir.
+			continue
+		}
+		files++
+		if strings.HasPrefix(target, "socket:") {
+			sockets++
+		}
+	}
+	return files, sockets
+}
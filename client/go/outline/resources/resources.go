@@ -0,0 +1,94 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resources counts the goroutines of the subsystems and the open sockets of the
+// process, to diagnose the slow resource growth of long-running sessions.
+package resources
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Subsystems of the goroutines counted by [Go].
+const (
+	SubsystemControl    = "control"
+	SubsystemDNS        = "dns"
+	SubsystemDynamicKey = "dynamic-key"
+	SubsystemHealth     = "health"
+	SubsystemHysteria2  = "hysteria2"
+	SubsystemICMP       = "icmp"
+	SubsystemICMPEcho   = "icmp-echo"
+	SubsystemLocalProxy = "local-proxy"
+	SubsystemMux        = "mux"
+	SubsystemPreDial    = "pre-dial"
+	SubsystemProfiling  = "profiling"
+	SubsystemPT         = "pt"
+	SubsystemRouting    = "routing"
+	SubsystemSelection  = "selection"
+	SubsystemStats      = "stats"
+	SubsystemTUIC       = "tuic"
+	SubsystemTun2socks  = "tun2socks"
+	SubsystemVPN        = "vpn"
+)
+
+// The number of running goroutines of each subsystem, as *atomic.Int64.
+var goroutines sync.Map
+
+// Go runs f in a new goroutine, counted in the goroutines of subsystem while it runs.
+func Go(subsystem string, f func()) {
+	counter, _ := goroutines.LoadOrStore(subsystem, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+	go func() {
+		defer counter.(*atomic.Int64).Add(-1)
+		f()
+	}()
+}
+
+// Usage is a snapshot of the resources used by the process.
+type Usage struct {
+	// Goroutines is the number of goroutines of the process.
+	Goroutines int `json:"goroutines"`
+
+	// GoroutinesBySubsystem is the number of running goroutines started by [Go], by subsystem.
+	GoroutinesBySubsystem map[string]int64 `json:"goroutinesBySubsystem"`
+
+	// OpenFiles is the number of open file descriptors, or -1 if the platform can't tell.
+	OpenFiles int `json:"openFiles"`
+
+	// OpenSockets is the number of open sockets, or -1 if the platform can't tell.
+	OpenSockets int `json:"openSockets"`
+
+	// HeapBytes is the number of bytes of allocated heap objects.
+	HeapBytes uint64 `json:"heapBytes"`
+}
+
+// CurrentUsage returns the resources currently used by the process. It stops the world briefly
+// to read the heap statistics, so it's not meant to be called often.
+func CurrentUsage() Usage {
+	u := Usage{
+		Goroutines:            runtime.NumGoroutine(),
+		GoroutinesBySubsystem: make(map[string]int64),
+	}
+	goroutines.Range(func(subsystem, counter any) bool {
+		u.GoroutinesBySubsystem[subsystem.(string)] = counter.(*atomic.Int64).Load()
+		return true
+	})
+	u.OpenFiles, u.OpenSockets = OpenFiles()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	u.HeapBytes = mem.HeapAlloc
+	return u
+}
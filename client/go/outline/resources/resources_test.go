@@ -0,0 +1,69 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGo_CountsGoroutines(t *testing.T) {
+	const subsystem = "test"
+	release := make(chan struct{})
+	started := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		Go(subsystem, func() {
+			started <- struct{}{}
+			<-release
+		})
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	require.Equal(t, int64(3), CurrentUsage().GoroutinesBySubsystem[subsystem])
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return CurrentUsage().GoroutinesBySubsystem[subsystem] == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCurrentUsage(t *testing.T) {
+	u := CurrentUsage()
+	require.Positive(t, u.Goroutines)
+	require.Positive(t, u.HeapBytes)
+	if runtime.GOOS != "linux" {
+		require.Equal(t, -1, u.OpenFiles)
+		require.Equal(t, -1, u.OpenSockets)
+	}
+}
+
+func TestOpenFiles_CountsSockets(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files are only counted on Linux")
+	}
+	files, sockets := OpenFiles()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	newFiles, newSockets := OpenFiles()
+	require.Equal(t, files+1, newFiles)
+	require.Equal(t, sockets+1, newSockets)
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/routing"
+)
+
+// setLANBypass parses the JSON boolean input and enables or disables the LAN bypass of all the
+// clients, including the connected ones.
+func setLANBypass(input string) error {
+	var enabled bool
+	if err := json.Unmarshal([]byte(input), &enabled); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "LAN bypass toggle must be true or false",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	routing.SetLANBypass(enabled)
+	logger.Info("LAN bypass updated", "enabled", enabled)
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// NewStreamDialer creates a [transport.StreamDialer] that dials each destination through either
+// proxy or direct, as decided by r.
+func NewStreamDialer(r *Router, proxy, direct transport.StreamDialer) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if r.Route(addr) == ActionDirect {
+			return direct.DialStream(ctx, addr)
+		}
+		return proxy.DialStream(ctx, addr)
+	})
+}
+
+// NewPacketListener creates a [transport.PacketListener] whose connections send each packet
+// through either proxy or direct, as decided by r.
+//
+// The underlying connections are only created when a packet is first sent through them.
+func NewPacketListener(r *Router, proxy, direct transport.PacketListener) transport.PacketListener {
+	return &packetListener{r, proxy, direct}
+}
+
+type packetListener struct {
+	router        *Router
+	proxy, direct transport.PacketListener
+}
+
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return &packetConn{
+		listener: l,
+		packets:  make(chan packet),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+type packet struct {
+	// buf holds the data, and is returned to [bufpool.Packets] once read.
+	buf  *[]byte
+	n    int
+	addr net.Addr
+}
+
+// packetConn sends packets through the connection of their route, and merges the packets
+// received from all of them.
+type packetConn struct {
+	listener *packetListener
+	packets  chan packet
+	closed   chan struct{}
+
+	mu            sync.Mutex
+	proxy, direct net.PacketConn
+	readDeadline  time.Time
+	writeDeadline time.Time
+	isClosed      bool
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+// conn returns the underlying connection for action, creating it if needed.
+func (c *packetConn) conn(action Action) (net.PacketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		return nil, net.ErrClosed
+	}
+	target, listener := &c.proxy, c.listener.proxy
+	if action == ActionDirect {
+		target, listener = &c.direct, c.listener.direct
+	}
+	if *target != nil {
+		return *target, nil
+	}
+	conn, err := listener.ListenPacket(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(c.writeDeadline)
+	*target = conn
+	resources.Go(resources.SubsystemRouting, func() { c.readLoop(conn) })
+	return conn, nil
+}
+
+func (c *packetConn) readLoop(conn net.PacketConn) {
+	for {
+		buf := bufpool.Packets.Get()
+		n, addr, err := conn.ReadFrom(*buf)
+		if err != nil {
+			bufpool.Packets.Put(buf)
+			return
+		}
+		select {
+		case c.packets <- packet{buf, n, addr}:
+		case <-c.closed:
+			bufpool.Packets.Put(buf)
+			return
+		}
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	conn, err := c.conn(c.listener.router.Route(addr.String()))
+	if err != nil {
+		return 0, err
+	}
+	return conn.WriteTo(b, addr)
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.packets:
+		n := copy(b, (*p.buf)[:p.n])
+		bufpool.Packets.Put(p.buf)
+		return n, p.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *packetConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		return net.ErrClosed
+	}
+	c.isClosed = true
+	close(c.closed)
+	var errs []error
+	for _, conn := range []net.PacketConn{c.proxy, c.direct} {
+		if conn != nil {
+			errs = append(errs, conn.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LocalAddr returns the local address of the proxy connection, or of the direct one if only
+// direct traffic has been sent.
+func (c *packetConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.proxy != nil {
+		return c.proxy.LocalAddr()
+	}
+	if c.direct != nil {
+		return c.direct.LocalAddr()
+	}
+	return &net.UDPAddr{}
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	for _, conn := range []net.PacketConn{c.proxy, c.direct} {
+		if conn != nil {
+			conn.SetWriteDeadline(t)
+		}
+	}
+	return nil
+}
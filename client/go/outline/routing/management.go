@@ -0,0 +1,122 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxManagementIPs is the number of addresses of the management hosts kept. The addresses are
+// forgotten when there are more, until they are added again.
+const maxManagementIPs = 1024
+
+// management are the management hosts of all the routers, and the addresses of the management
+// hosts, so that the VPN, which only sees IP addresses, bypasses them too.
+var management struct {
+	mu sync.RWMutex
+	// hosts are the management hosts set by [SetManagementHosts].
+	hosts managementHosts
+	// ips are the addresses of the management host names, by address.
+	ips map[netip.Addr]string
+}
+
+// managementHosts are host names and IP addresses whose traffic always goes directly.
+type managementHosts struct {
+	domains []string
+	ips     []netip.Addr
+}
+
+// parseManagementHosts parses a list of host names and IP addresses.
+func parseManagementHosts(hosts []string) (managementHosts, error) {
+	var m managementHosts
+	for _, h := range hosts {
+		if ip, err := netip.ParseAddr(strings.Trim(h, "[]")); err == nil {
+			m.ips = append(m.ips, ip.Unmap())
+			continue
+		}
+		d := NormalizeDomain(h)
+		if d == "" || strings.ContainsAny(d, ":/") {
+			return managementHosts{}, fmt.Errorf("invalid management host %q, must be a host name or an IP address", h)
+		}
+		m.domains = append(m.domains, d)
+	}
+	return m, nil
+}
+
+// matches returns whether the destination is one of the hosts. Exactly one of domain and ip is set.
+func (m *managementHosts) matches(domain string, ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return slices.Contains(m.domains, domain)
+	}
+	if slices.Contains(m.ips, ip) {
+		return true
+	}
+	learned, ok := management.ips[ip]
+	return ok && slices.Contains(m.domains, learned)
+}
+
+// SetManagementHosts sets the host names and IP addresses of management endpoints whose traffic
+// always goes directly with all the routers, including the ones in use, in addition to the
+// ManagementHosts of their [Config]. It replaces the hosts of the previous call.
+func SetManagementHosts(hosts []string) error {
+	m, err := parseManagementHosts(hosts)
+	if err != nil {
+		return err
+	}
+	management.mu.Lock()
+	defer management.mu.Unlock()
+	management.hosts = m
+	return nil
+}
+
+// AddManagementHostIPs records the addresses host resolves to, so that the routers with host as a
+// management host bypass them.
+func AddManagementHostIPs(host string, ips []netip.Addr) {
+	host = NormalizeDomain(host)
+	management.mu.Lock()
+	defer management.mu.Unlock()
+	if management.ips == nil || len(management.ips)+len(ips) > maxManagementIPs {
+		management.ips = make(map[netip.Addr]string)
+	}
+	for _, ip := range ips {
+		management.ips[ip.Unmap()] = host
+	}
+}
+
+// ManagementHostIPs returns the addresses of host recorded by [AddManagementHostIPs].
+func ManagementHostIPs(host string) []netip.Addr {
+	host = NormalizeDomain(host)
+	management.mu.RLock()
+	defer management.mu.RUnlock()
+	var ips []netip.Addr
+	for ip, h := range management.ips {
+		if h == host {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// isManagement returns whether the destination is a management host of r or of all the routers.
+// Exactly one of domain and ip is set.
+func (r *Router) isManagement(domain string, ip netip.Addr) bool {
+	management.mu.RLock()
+	defer management.mu.RUnlock()
+	return r.management.matches(domain, ip) || management.hosts.matches(domain, ip)
+}
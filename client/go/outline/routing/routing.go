@@ -0,0 +1,301 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package routing decides, for each destination, whether its traffic goes through the proxy or
+// directly to the underlying network (split tunneling).
+//
+// Rules can match domains, IP ranges and ports. Domain rules only apply to destinations that are
+// given as a domain name, e.g. by the local proxy; the VPN only sees IP addresses, so it rejects
+// the configs with domain rules.
+package routing
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Action tells where the traffic to a destination goes.
+type Action string
+
+const (
+	// ActionProxy relays the traffic through the proxy.
+	ActionProxy Action = "proxy"
+
+	// ActionDirect sends the traffic directly to the underlying network, bypassing the proxy.
+	ActionDirect Action = "direct"
+)
+
+// Config is the "routing" section of a transport config.
+type Config struct {
+	// Default is the action for the destinations no rule matches. Defaults to "proxy".
+	Default Action `json:"default,omitempty"`
+
+	// BypassLAN sends the traffic to private, link-local and multicast addresses directly, so that
+	// local devices like printers stay reachable. It is evaluated before the rules.
+	BypassLAN bool `json:"bypassLan,omitempty"`
+
+	// ManagementHosts are the host names and IP addresses of the management endpoints of the
+	// provider, like its API or the server of its dynamic keys, whose traffic always goes directly,
+	// so that they stay reachable when the proxy isn't. The host names match exactly. They are
+	// evaluated before the rules.
+	ManagementHosts []string `json:"managementHosts,omitempty"`
+
+	// Rules are evaluated in order, and the first one that matches decides the action.
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig is a routing rule. A destination matches the rule if it matches any of the Domains
+// or CIDRs (or there are none), and any of the Ports (or there are none).
+type RuleConfig struct {
+	Action Action `json:"action"`
+
+	// Domains match the domain and all its subdomains, e.g. "example.com" matches
+	// "www.example.com".
+	Domains []string `json:"domains,omitempty"`
+
+	// CIDRs match IP addresses, e.g. "10.0.0.0/8" or "2001:db8::/32".
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// Ports match a single port, e.g. "443", or an inclusive range, e.g. "8000-8999".
+	Ports []string `json:"ports,omitempty"`
+}
+
+// Router evaluates routing rules.
+type Router struct {
+	defaultAction Action
+	bypassLAN     bool
+	management    managementHosts
+	rules         []rule
+
+	// onFirstRoute, if not nil, is called once, on the first routing decision.
+	onFirstRoute func()
+	firstRoute   sync.Once
+}
+
+// lanBypass forces the LAN bypass of all routers, regardless of their config.
+var lanBypass atomic.Bool
+
+// SetLANBypass enables or disables the LAN bypass of all routers, including the ones in use.
+// When disabled, the routers fall back to the BypassLAN option of their [Config].
+func SetLANBypass(enabled bool) {
+	lanBypass.Store(enabled)
+}
+
+type rule struct {
+	action   Action
+	domains  []string
+	prefixes []netip.Prefix
+	ports    []PortRange
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	First, Last uint16
+}
+
+// NewRouter validates conf and creates a [Router] from it.
+func NewRouter(conf Config) (*Router, error) {
+	r := &Router{defaultAction: ActionProxy, bypassLAN: conf.BypassLAN}
+	if conf.Default != "" {
+		if err := ValidateAction(conf.Default, ActionProxy, ActionDirect); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+		r.defaultAction = conf.Default
+	}
+	var err error
+	if r.management, err = parseManagementHosts(conf.ManagementHosts); err != nil {
+		return nil, fmt.Errorf("managementHosts: %w", err)
+	}
+	for i, rc := range conf.Rules {
+		rule, err := newRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func newRule(rc RuleConfig) (rule, error) {
+	if err := ValidateAction(rc.Action, ActionProxy, ActionDirect); err != nil {
+		return rule{}, err
+	}
+	domains, err := ParseDomains(rc.Domains)
+	if err != nil {
+		return rule{}, err
+	}
+	r := rule{action: rc.Action, domains: domains}
+	for _, c := range rc.CIDRs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		r.prefixes = append(r.prefixes, prefix.Masked())
+	}
+	for _, p := range rc.Ports {
+		pr, err := ParsePortRange(p)
+		if err != nil {
+			return rule{}, err
+		}
+		r.ports = append(r.ports, pr)
+	}
+	return r, nil
+}
+
+// ValidateAction returns an error if a isn't one of valid. It validates the actions of the
+// rule engines, like the connect-on-demand ones, whose action types are strings too.
+func ValidateAction[A ~string](a A, valid ...A) error {
+	if slices.Contains(valid, a) {
+		return nil
+	}
+	quoted := make([]string, len(valid))
+	for i, v := range valid {
+		quoted[i] = strconv.Quote(string(v))
+	}
+	last := len(quoted) - 1
+	return fmt.Errorf("unsupported action %q, must be %s or %s",
+		a, strings.Join(quoted[:last], ", "), quoted[last])
+}
+
+// ParsePortRange parses a single port, e.g. "443", or an inclusive range, e.g. "8000-8999".
+func ParsePortRange(s string) (PortRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	from, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.ParseUint(strings.TrimSpace(last), 10, 16); err != nil || to < from {
+			return PortRange{}, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return PortRange{uint16(from), uint16(to)}, nil
+}
+
+// Contains returns whether port is in r.
+func (r PortRange) Contains(port uint16) bool {
+	return r.First <= port && port <= r.Last
+}
+
+// NormalizeDomain returns d in lower case, without spaces and the trailing dot, as the domain
+// rules match it.
+func NormalizeDomain(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+// ParseDomains returns the normalized domains of the Domains of a rule, which must not be empty.
+func ParseDomains(domains []string) ([]string, error) {
+	var parsed []string
+	for _, d := range domains {
+		if d = NormalizeDomain(d); d == "" {
+			return nil, fmt.Errorf("empty domain")
+		}
+		parsed = append(parsed, d)
+	}
+	return parsed, nil
+}
+
+// MatchesDomain returns whether the normalized domain is one of domains, as returned by
+// [ParseDomains], or one of their subdomains.
+func MatchesDomain(domain string, domains []string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnFirstRoute makes r call f on its first routing decision, e.g. to prepare what only matters
+// once r is in use. f must not block. It must be called before r is used.
+func (r *Router) OnFirstRoute(f func()) {
+	r.onFirstRoute = f
+}
+
+// Route returns the action for the destination addr, in host:port form.
+func (r *Router) Route(addr string) Action {
+	if r.onFirstRoute != nil {
+		r.firstRoute.Do(r.onFirstRoute)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return r.defaultAction
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return r.defaultAction
+	}
+	var ip netip.Addr
+	if ip, err = netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		host = ""
+		if (r.bypassLAN || lanBypass.Load()) && isLANAddr(ip) {
+			return ActionDirect
+		}
+	} else {
+		host = NormalizeDomain(host)
+	}
+	if r.isManagement(host, ip) {
+		return ActionDirect
+	}
+	for _, rule := range r.rules {
+		if rule.matches(host, ip, uint16(port)) {
+			return rule.action
+		}
+	}
+	return r.defaultAction
+}
+
+// matches returns whether the destination matches the rule. Exactly one of domain and ip is set.
+func (r *rule) matches(domain string, ip netip.Addr, port uint16) bool {
+	if len(r.domains) > 0 || len(r.prefixes) > 0 {
+		matched := false
+		if ip.IsValid() {
+			for _, p := range r.prefixes {
+				if p.Contains(ip) {
+					matched = true
+					break
+				}
+			}
+		} else {
+			matched = MatchesDomain(domain, r.domains)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, pr := range r.ports {
+		if pr.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLANAddr returns whether ip is a private (RFC 1918 or RFC 4193), link-local, multicast or
+// broadcast address.
+func isLANAddr(ip netip.Addr) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() ||
+		ip == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
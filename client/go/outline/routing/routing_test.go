@@ -0,0 +1,254 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Route(t *testing.T) {
+	router, err := NewRouter(Config{
+		Default: ActionProxy,
+		Rules: []RuleConfig{
+			{Action: ActionProxy, Domains: []string{"secure.example.com"}},
+			{Action: ActionDirect, Domains: []string{"Example.com."}},
+			{Action: ActionDirect, CIDRs: []string{"10.0.0.0/8", "fd00::/8"}},
+			{Action: ActionDirect, Ports: []string{"22", "8000-8999"}},
+			{Action: ActionDirect, CIDRs: []string{"192.0.2.0/24"}, Ports: []string{"53"}},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr string
+		want Action
+	}{
+		{"example.com:443", ActionDirect},
+		{"www.EXAMPLE.com:443", ActionDirect},
+		{"secure.example.com:443", ActionProxy},
+		{"notexample.com:443", ActionProxy},
+		{"10.1.2.3:443", ActionDirect},
+		{"[::ffff:10.1.2.3]:443", ActionDirect},
+		{"[fd12::1]:443", ActionDirect},
+		{"8.8.8.8:22", ActionDirect},
+		{"8.8.8.8:8080", ActionDirect},
+		{"8.8.8.8:9000", ActionProxy},
+		{"192.0.2.1:53", ActionDirect},
+		{"192.0.2.1:443", ActionProxy},
+		{"invalid", ActionProxy},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, router.Route(tt.addr), tt.addr)
+	}
+}
+
+func TestRouter_BypassLAN(t *testing.T) {
+	router, err := NewRouter(Config{BypassLAN: true})
+	require.NoError(t, err)
+	for _, addr := range []string{
+		"10.0.0.1:80", "172.16.5.4:80", "192.168.1.1:631", "169.254.1.1:80", "224.0.0.251:5353",
+		"239.255.255.250:1900", "255.255.255.255:67", "[fe80::1]:80", "[fd00::1]:80", "[ff02::fb]:5353",
+	} {
+		require.Equal(t, ActionDirect, router.Route(addr), addr)
+	}
+	for _, addr := range []string{"8.8.8.8:53", "172.32.0.1:80", "[2001:db8::1]:80", "printer.local:631"} {
+		require.Equal(t, ActionProxy, router.Route(addr), addr)
+	}
+
+	router, err = NewRouter(Config{})
+	require.NoError(t, err)
+	require.Equal(t, ActionProxy, router.Route("192.168.1.1:631"))
+	SetLANBypass(true)
+	defer SetLANBypass(false)
+	require.Equal(t, ActionDirect, router.Route("192.168.1.1:631"))
+}
+
+func TestNewRouter_Errors(t *testing.T) {
+	tests := []Config{
+		{Default: "block"},
+		{Rules: []RuleConfig{{Action: ""}}},
+		{Rules: []RuleConfig{{Action: ActionDirect, CIDRs: []string{"10.0.0.1"}}}},
+		{Rules: []RuleConfig{{Action: ActionDirect, Ports: []string{"65536"}}}},
+		{Rules: []RuleConfig{{Action: ActionDirect, Ports: []string{"90-80"}}}},
+		{Rules: []RuleConfig{{Action: ActionDirect, Domains: []string{" "}}}},
+	}
+	for _, conf := range tests {
+		_, err := NewRouter(conf)
+		require.Error(t, err, conf)
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	require.NoError(t, ValidateAction(ActionDirect, ActionProxy, ActionDirect))
+	require.EqualError(t, ValidateAction(Action("block"), ActionProxy, ActionDirect),
+		`unsupported action "block", must be "proxy" or "direct"`)
+	require.EqualError(t, ValidateAction("none", "connect", "disconnect", "ignore"),
+		`unsupported action "none", must be "connect", "disconnect" or "ignore"`)
+}
+
+func TestParsePortRange(t *testing.T) {
+	pr, err := ParsePortRange("8000 - 8999")
+	require.NoError(t, err)
+	require.Equal(t, PortRange{First: 8000, Last: 8999}, pr)
+	require.True(t, pr.Contains(8000))
+	require.False(t, pr.Contains(9000))
+}
+
+type unusedPacketListener struct {
+	t *testing.T
+}
+
+func (l unusedPacketListener) ListenPacket(context.Context) (net.PacketConn, error) {
+	l.t.Error("the packet listener must not be used")
+	return nil, net.ErrClosed
+}
+
+func TestPacketListener(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	router, err := NewRouter(Config{Default: ActionDirect})
+	require.NoError(t, err)
+	pl := NewPacketListener(router, unusedPacketListener{t}, &transport.UDPListener{Address: "127.0.0.1:0"})
+	conn, err := pl.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.WriteTo([]byte("ping"), echo.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, addr, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, echo.LocalAddr().String(), addr.String())
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+// fakePacketListener listens packet connections receiving packets of a fixed size immediately.
+type fakePacketListener struct {
+	size int
+}
+
+func (l fakePacketListener) ListenPacket(context.Context) (net.PacketConn, error) {
+	return &fakePacketConn{size: l.size}, nil
+}
+
+type fakePacketConn struct {
+	net.PacketConn
+	size int
+}
+
+var fakePacketSource = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+
+func (c *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return min(c.size, len(b)), fakePacketSource, nil
+}
+
+func (c *fakePacketConn) WriteTo(b []byte, _ net.Addr) (int, error) { return len(b), nil }
+
+func (c *fakePacketConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *fakePacketConn) Close() error { return nil }
+
+func BenchmarkPacketConn_ReadFrom(b *testing.B) {
+	router, err := NewRouter(Config{})
+	require.NoError(b, err)
+	const size = 1200
+	pl := NewPacketListener(router, fakePacketListener{size}, fakePacketListener{size})
+	conn, err := pl.ListenPacket(context.Background())
+	require.NoError(b, err)
+	defer conn.Close()
+	// The proxy connection is created by the first packet sent.
+	_, err = conn.WriteTo([]byte("ping"), fakePacketSource)
+	require.NoError(b, err)
+	buf := make([]byte, size)
+
+	b.ReportAllocs()
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRouter_ManagementHosts(t *testing.T) {
+	router, err := NewRouter(Config{
+		ManagementHosts: []string{"Manager.example.com", "203.0.113.5", "[2001:db8::5]"},
+		Rules:           []RuleConfig{{Action: ActionProxy}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, ActionDirect, router.Route("manager.example.com:443"))
+	require.Equal(t, ActionProxy, router.Route("www.manager.example.com:443"), "the host names must match exactly")
+	require.Equal(t, ActionDirect, router.Route("203.0.113.5:8080"))
+	require.Equal(t, ActionDirect, router.Route("[2001:db8::5]:443"))
+
+	// The VPN only sees the addresses of the host names.
+	require.Equal(t, ActionProxy, router.Route("198.51.100.7:443"))
+	AddManagementHostIPs("manager.example.com", []netip.Addr{netip.MustParseAddr("198.51.100.7")})
+	defer func() { management.ips = nil }()
+	require.Equal(t, ActionDirect, router.Route("198.51.100.7:443"))
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("198.51.100.7")}, ManagementHostIPs("manager.example.com"))
+
+	_, err = NewRouter(Config{ManagementHosts: []string{"https://manager.example.com/api"}})
+	require.Error(t, err)
+}
+
+func TestRouter_OnFirstRoute(t *testing.T) {
+	router, err := NewRouter(Config{})
+	require.NoError(t, err)
+	calls := 0
+	router.OnFirstRoute(func() { calls++ })
+	require.Zero(t, calls)
+	router.Route("example.com:443")
+	router.Route("192.0.2.1:443")
+	require.Equal(t, 1, calls)
+}
+
+func TestSetManagementHosts(t *testing.T) {
+	router, err := NewRouter(Config{})
+	require.NoError(t, err)
+	require.Equal(t, ActionProxy, router.Route("keys.example.com:443"))
+
+	require.NoError(t, SetManagementHosts([]string{"keys.example.com"}))
+	defer SetManagementHosts(nil)
+	require.Equal(t, ActionDirect, router.Route("keys.example.com:443"), "the routers in use must bypass the new hosts")
+
+	require.Error(t, SetManagementHosts([]string{""}))
+	require.Equal(t, ActionDirect, router.Route("keys.example.com:443"), "invalid hosts must not replace the hosts")
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/geoip"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// The GeoIP databases locating the servers, set by [MethodSetGeoIPDatabases]. The servers are
+// not located while there are none.
+var geoDatabasesMu sync.RWMutex
+var geoDatabases []*geoip.Reader
+
+// setGeoIPDatabases replaces the GeoIP databases with the MMDB files of the JSON array of paths
+// in input. An empty array disables the lookups.
+func setGeoIPDatabases(input string) error {
+	var paths []string
+	if err := json.Unmarshal([]byte(input), &paths); err != nil {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "GeoIP databases must be a JSON array of paths",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	dbs := make([]*geoip.Reader, 0, len(paths))
+	for _, path := range paths {
+		db, err := geoip.Open(path)
+		if err != nil {
+			return platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: "failed to open GeoIP database",
+				Details: platerrors.ErrorDetails{"path": path},
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+		dbs = append(dbs, db)
+	}
+	geoDatabasesMu.Lock()
+	defer geoDatabasesMu.Unlock()
+	geoDatabases = dbs
+	logger.Info("GeoIP databases updated", "count", len(dbs))
+	return nil
+}
+
+// locateIP returns the country and autonomous system of ip, merged from all the databases, or
+// nil if none of them knows it.
+func locateIP(ip net.IP) *geoip.Info {
+	geoDatabasesMu.RLock()
+	defer geoDatabasesMu.RUnlock()
+	var merged geoip.Info
+	for _, db := range geoDatabases {
+		info, err := db.Lookup(ip)
+		if err != nil {
+			logger.Debug("GeoIP lookup failed", "err", err)
+			continue
+		}
+		if merged.Country == "" {
+			merged.Country = info.Country
+		}
+		if merged.ASN == 0 {
+			merged.ASN, merged.Organization = info.ASN, info.Organization
+		}
+	}
+	if merged == (geoip.Info{}) {
+		return nil
+	}
+	return &merged
+}
+
+// locateServer returns the location of the proxy server of the transport config, resolving its
+// host name if needed. It returns nil if the server can't be located, or no database is set.
+func locateServer(ctx context.Context, transportConfig string) *geoip.Info {
+	geoDatabasesMu.RLock()
+	enabled := len(geoDatabases) > 0
+	geoDatabasesMu.RUnlock()
+	if !enabled {
+		return nil
+	}
+	conf, err := parseConfigFromJSON(transportConfig)
+	if err != nil || conf.Host == "" {
+		return nil
+	}
+	if ip := net.ParseIP(conf.Host); ip != nil {
+		return locateIP(ip)
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", conf.Host)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	return locateIP(ips[0])
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/geoip"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+// testGeoDatabase is an IPv4 MMDB database locating 128.0.0.0/1 in Germany, AS64500.
+const testGeoDatabase = "" +
+	// Search tree: a single node, with no record on the left and the data record on the right.
+	"\x00\x00\x01\x00\x00\x11" + "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	// Data: {"country": {"iso_code": "DE"}, "autonomous_system_number": 64500}
+	"\xe2\x47country\xe1\x48iso_code\x42DE\x58autonomous_system_number\xc2\xfb\xf4" +
+	// Metadata: {"node_count": 1, "record_size": 24, "ip_version": 4}
+	"\xab\xcd\xefMaxMind.com" +
+	"\xe3\x4anode_count\xc1\x01\x4brecord_size\xc1\x18\x4aip_version\xc1\x04"
+
+func setTestGeoDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte(testGeoDatabase), 0o600))
+	require.NoError(t, setGeoIPDatabases(`["`+path+`"]`))
+	t.Cleanup(func() { require.NoError(t, setGeoIPDatabases(`[]`)) })
+}
+
+func TestSetGeoIPDatabases(t *testing.T) {
+	err := setGeoIPDatabases(`["` + filepath.Join(t.TempDir(), "missing.mmdb") + `"]`)
+	perr, ok := err.(platerrors.PlatformError)
+	require.True(t, ok)
+	require.Equal(t, platerrors.InternalError, perr.Code)
+	require.Error(t, setGeoIPDatabases(`"geo.mmdb"`))
+
+	require.Nil(t, locateIP([]byte{203, 0, 113, 1}))
+	setTestGeoDatabase(t)
+	require.Equal(t, &geoip.Info{Country: "DE", ASN: 64500}, locateIP([]byte{203, 0, 113, 1}))
+	require.Nil(t, locateIP([]byte{10, 0, 0, 1}))
+}
+
+func TestDescribe_Location(t *testing.T) {
+	setTestGeoDatabase(t)
+	conf, err := parseConfigFromJSON(`{"host":"203.0.113.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"}`)
+	require.NoError(t, err)
+	require.Equal(t, &geoip.Info{Country: "DE", ASN: 64500}, conf.describe().Location)
+
+	// Host names aren't resolved.
+	conf, err = parseConfigFromJSON(`{"host":"example.com","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"}`)
+	require.NoError(t, err)
+	require.Nil(t, conf.describe().Location)
+}
+
+func TestLocateServer(t *testing.T) {
+	config := `{"host":"203.0.113.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"}`
+	require.Nil(t, locateServer(context.Background(), config))
+	setTestGeoDatabase(t)
+	require.Equal(t, &geoip.Info{Country: "DE", ASN: 64500}, locateServer(context.Background(), config))
+	require.Nil(t, locateServer(context.Background(), `{"host":"localhost","port":443}`))
+	require.Nil(t, locateServer(context.Background(), `not json`))
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/probe"
+)
+
+// serverProbeRequestJSON is the input of [MethodPingServer] and [MethodTracerouteServer].
+type serverProbeRequestJSON struct {
+	// Transport is the transport config of the server. The host and port are taken from it if
+	// Host is empty.
+	Transport string `json:"transport,omitempty"`
+
+	Host string `json:"host,omitempty"`
+	Port uint16 `json:"port,omitempty"`
+
+	Count     int `json:"count,omitempty"`
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	MaxHops   int `json:"maxHops,omitempty"`
+}
+
+// parseServerProbeRequest parses the input as a serverProbeRequestJSON, and resolves the server
+// address.
+func parseServerProbeRequest(ctx context.Context, input string) (netip.Addr, probe.Options, error) {
+	var req serverProbeRequestJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return netip.Addr{}, probe.Options{}, platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "invalid server probe request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if req.Host == "" && req.Transport != "" {
+		conf, err := parseConfigFromJSON(req.Transport)
+		if err != nil {
+			return netip.Addr{}, probe.Options{}, err
+		}
+		req.Host, req.Port = conf.Host, conf.Port
+	}
+	if req.Host == "" {
+		return netip.Addr{}, probe.Options{}, newIllegalConfigErrorWithDetails("host name or IP is not valid",
+			"host", req.Host, "not nil", nil)
+	}
+	ip, err := resolveProbeHost(ctx, req.Host)
+	if err != nil {
+		return netip.Addr{}, probe.Options{}, platerrors.PlatformError{
+			Code:    platerrors.ResolveIPFailed,
+			Message: fmt.Sprintf("failed to resolve host name %s", req.Host),
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return ip, probe.Options{
+		Port:    req.Port,
+		Count:   req.Count,
+		Timeout: time.Duration(req.TimeoutMs) * time.Millisecond,
+		MaxHops: req.MaxHops,
+	}, nil
+}
+
+// resolveProbeHost returns the first IPv4, or else IPv6, address of host.
+func resolveProbeHost(ctx context.Context, host string) (netip.Addr, error) {
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, ip := range ips {
+		if ip.Unmap().Is4() {
+			return ip.Unmap(), nil
+		}
+	}
+	if len(ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("no IP address found for %s", host)
+	}
+	return ips[0], nil
+}
+
+// pingServer pings the server of the request, and returns a JSON string of probe.PingResult.
+func pingServer(ctx context.Context, input string) (string, error) {
+	ip, opts, err := parseServerProbeRequest(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	res, err := probe.Ping(ctx, ip, opts)
+	if err != nil {
+		return "", newServerProbeError("ping", err)
+	}
+	return marshalServerProbeResult(res)
+}
+
+// tracerouteServer traces the route to the server of the request, and returns a JSON string of
+// probe.TracerouteResult.
+func tracerouteServer(ctx context.Context, input string) (string, error) {
+	ip, opts, err := parseServerProbeRequest(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	res, err := probe.Traceroute(ctx, ip, opts)
+	if err != nil {
+		return "", newServerProbeError("traceroute", err)
+	}
+	return marshalServerProbeResult(res)
+}
+
+func newServerProbeError(op string, err error) error {
+	return platerrors.PlatformError{
+		Code:    platerrors.InternalError,
+		Message: fmt.Sprintf("failed to %s the server", op),
+		Cause:   platerrors.ToPlatformError(err),
+	}
+}
+
+func marshalServerProbeResult(res any) (string, error) {
+	out, err := json.Marshal(res)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal server probe result",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/probe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerProbeRequest(t *testing.T) {
+	ip, opts, err := parseServerProbeRequest(context.Background(), `{"transport": "{\"host\":\"127.0.0.1\",\"port\":8388}", "count": 2, "timeoutMs": 500}`)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddr("127.0.0.1"), ip)
+	require.Equal(t, probe.Options{Port: 8388, Count: 2, Timeout: 500 * time.Millisecond}, opts)
+
+	ip, opts, err = parseServerProbeRequest(context.Background(), `{"host": "::1", "port": 443, "maxHops": 5}`)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddr("::1"), ip)
+	require.Equal(t, probe.Options{Port: 443, MaxHops: 5}, opts)
+
+	_, _, err = parseServerProbeRequest(context.Background(), `{}`)
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+
+	_, _, err = parseServerProbeRequest(context.Background(), `{"host": "invalid.invalid"}`)
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ResolveIPFailed, perr.Code)
+}
+
+func TestPingServer(t *testing.T) {
+	out, err := pingServer(context.Background(), `{"host": "127.0.0.1", "count": 1, "timeoutMs": 1000}`)
+	if err != nil {
+		t.Skip("ICMP is not available:", err)
+	}
+	var res probe.PingResult
+	require.NoError(t, json.Unmarshal([]byte(out), &res))
+	require.Equal(t, 1, res.Received)
+}
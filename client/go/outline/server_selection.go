@@ -0,0 +1,241 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// transportTypeMulti is the transport relaying the traffic through one of several servers.
+const transportTypeMulti = "multi"
+
+// Strategies of the "multi" transport.
+const (
+	selectionStrategyFastest = "fastest"
+	selectionStrategyFirst   = "first"
+	selectionStrategyRandom  = "random"
+)
+
+const (
+	defaultReevaluateInterval = 10 * time.Minute
+	selectionCheckTimeout     = 10 * time.Second
+)
+
+// multiTransportConfigJSON is the config of the "multi" transport.
+type multiTransportConfigJSON struct {
+	// Transports are the configs of the servers, of any transport type.
+	Transports []json.RawMessage `json:"transports"`
+
+	// Strategy selects the server: "fastest" (default) races connectivity checks to all the
+	// servers and uses the first to answer, "first" uses the first server, and "random" uses a
+	// random server.
+	Strategy string `json:"strategy,omitempty"`
+
+	// ReevaluateSeconds is how often the "fastest" strategy races the servers again. Defaults to
+	// 10 minutes. They are also raced again after a network change.
+	ReevaluateSeconds int `json:"reevaluateSeconds,omitempty"`
+}
+
+// networkGeneration is incremented on every network change, so that the "fastest" selections
+// made on the previous network are evaluated again.
+var networkGeneration atomic.Uint64
+
+func init() {
+	transportRegistry[transportTypeMulti] = parseMultiTransport
+	firstHopsRegistry[transportTypeMulti] = func(config json.RawMessage) (firstHops, error) {
+		var conf multiTransportConfigJSON
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return firstHops{}, err
+		}
+		return nestedFirstHops(conf.Transports...)
+	}
+}
+
+// parseMultiTransport is the [TransportParser] of the "multi" transport.
+func parseMultiTransport(config json.RawMessage, dialers TransportDialers) (transport.StreamDialer, transport.PacketListener, error) {
+	var conf multiTransportConfigJSON
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, nil, newInvalidJSONError("multi transport config is not a valid JSON", string(config), err)
+	}
+	if len(conf.Transports) == 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("multi transport has no servers",
+			"transports", len(conf.Transports), "at least one transport config", nil)
+	}
+	if conf.ReevaluateSeconds < 0 {
+		return nil, nil, newIllegalConfigErrorWithDetails("reevaluation interval is not valid",
+			"reevaluateSeconds", conf.ReevaluateSeconds, "a positive number of seconds", nil)
+	}
+	s := &serverSelector{
+		strategy: conf.Strategy,
+		interval: defaultReevaluateInterval,
+		check:    connectivity.CheckTCPConnectivity,
+	}
+	if conf.ReevaluateSeconds > 0 {
+		s.interval = time.Duration(conf.ReevaluateSeconds) * time.Second
+	}
+	switch s.strategy {
+	case "":
+		s.strategy = selectionStrategyFastest
+	case selectionStrategyFastest, selectionStrategyFirst, selectionStrategyRandom:
+	default:
+		return nil, nil, newIllegalConfigErrorWithDetails("server selection strategy is not valid",
+			"strategy", conf.Strategy, `"fastest", "first" or "random"`, nil)
+	}
+	for _, raw := range conf.Transports {
+		sd, pl, err := parseTransportConfig(raw, dialers)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.servers = append(s.servers, selectableServer{sd: sd, pl: pl})
+	}
+	if s.strategy == selectionStrategyRandom {
+		s.current = rand.Intn(len(s.servers))
+	}
+	return s, s, nil
+}
+
+// selectableServer is a server of a [serverSelector].
+type selectableServer struct {
+	sd transport.StreamDialer
+	pl transport.PacketListener
+}
+
+// serverSelector relays the traffic through the server its strategy selects.
+type serverSelector struct {
+	servers  []selectableServer
+	strategy string
+	interval time.Duration
+
+	// check tests whether a server is reachable.
+	check func(ctx context.Context, sd transport.StreamDialer) error
+
+	mu      sync.Mutex
+	current int
+	// evaluatedAt and generation are when, and on which network, the servers were raced last.
+	// evaluatedAt is zero if they weren't raced yet.
+	evaluatedAt time.Time
+	generation  uint64
+	// evaluating is closed when the running race, if any, ends.
+	evaluating chan struct{}
+}
+
+var _ transport.StreamDialer = (*serverSelector)(nil)
+var _ transport.PacketListener = (*serverSelector)(nil)
+
+func (s *serverSelector) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	server, err := s.selected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return server.sd.DialStream(ctx, addr)
+}
+
+func (s *serverSelector) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	server, err := s.selected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return server.pl.ListenPacket(ctx)
+}
+
+// selected returns the selected server. With the "fastest" strategy, the first call waits for the
+// servers to be raced, and the calls after the selection is outdated race them again in the
+// background.
+func (s *serverSelector) selected(ctx context.Context) (selectableServer, error) {
+	if s.strategy != selectionStrategyFastest || len(s.servers) == 1 {
+		return s.servers[s.current], nil
+	}
+	s.mu.Lock()
+	outdated := s.evaluatedAt.IsZero() || time.Since(s.evaluatedAt) > s.interval ||
+		s.generation != networkGeneration.Load()
+	if outdated && s.evaluating == nil {
+		s.evaluating = make(chan struct{})
+		resources.Go(resources.SubsystemSelection, s.evaluate)
+	}
+	wait := s.evaluatedAt.IsZero()
+	evaluating := s.evaluating
+	s.mu.Unlock()
+
+	if wait {
+		select {
+		case <-evaluating:
+		case <-ctx.Done():
+			return selectableServer{}, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servers[s.current], nil
+}
+
+// evaluate races the connectivity checks of the servers, and selects the first to succeed. The
+// selection doesn't change if they all fail.
+func (s *serverSelector) evaluate() {
+	generation := networkGeneration.Load()
+	ctx, cancel := context.WithTimeout(context.Background(), selectionCheckTimeout)
+	defer cancel()
+
+	winner := make(chan int, len(s.servers))
+	errs := make(chan error, len(s.servers))
+	for i, server := range s.servers {
+		i, server := i, server
+		go func() {
+			if err := s.check(ctx, server.sd); err != nil {
+				errs <- err
+				return
+			}
+			winner <- i
+		}()
+	}
+	selected := -1
+	var err error
+	for range s.servers {
+		select {
+		case selected = <-winner:
+		case e := <-errs:
+			err = errors.Join(err, e)
+			continue
+		}
+		break
+	}
+	// Stop the checks still running.
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if selected >= 0 {
+		if selected != s.current {
+			logger.Info("selected the fastest server", "index", selected)
+		}
+		s.current = selected
+	} else {
+		logger.Warn("no server is reachable, keeping the selection", "index", s.current, "err", err)
+	}
+	s.evaluatedAt = time.Now()
+	s.generation = generation
+	close(s.evaluating)
+	s.evaluating = nil
+}
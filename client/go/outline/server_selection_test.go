@@ -0,0 +1,143 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSelector creates a "fastest" [serverSelector] whose servers pass their connectivity
+// check after the given delays, or fail it if the delay is negative.
+func newTestSelector(delays ...time.Duration) *serverSelector {
+	s := &serverSelector{strategy: selectionStrategyFastest, interval: time.Hour}
+	checks := make(map[transport.StreamDialer]time.Duration)
+	for _, d := range delays {
+		sd := &transport.TCPDialer{}
+		checks[sd] = d
+		s.servers = append(s.servers, selectableServer{sd: sd, pl: &transport.UDPListener{}})
+	}
+	s.check = func(ctx context.Context, sd transport.StreamDialer) error {
+		d := checks[sd]
+		if d < 0 {
+			return errors.New("unreachable")
+		}
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s
+}
+
+func TestServerSelector_Fastest(t *testing.T) {
+	s := newTestSelector(200*time.Millisecond, -1, 10*time.Millisecond)
+	got, err := s.selected(context.Background())
+	require.NoError(t, err)
+	require.Same(t, s.servers[2].sd, got.sd)
+}
+
+func TestServerSelector_AllUnreachable(t *testing.T) {
+	s := newTestSelector(-1, -1)
+	s.current = 1
+	got, err := s.selected(context.Background())
+	require.NoError(t, err)
+	require.Same(t, s.servers[1].sd, got.sd)
+}
+
+func TestServerSelector_ReevaluatesOnNetworkChange(t *testing.T) {
+	s := newTestSelector(10*time.Millisecond, 200*time.Millisecond)
+	// The first server becomes unreachable on the new network. The checks of the first race may
+	// still be running, so they read the network of the fake with an atomic.
+	var changed atomic.Bool
+	check := s.check
+	s.check = func(ctx context.Context, sd transport.StreamDialer) error {
+		if !changed.Load() {
+			return check(ctx, sd)
+		}
+		if sd == s.servers[0].sd {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	_, err := s.selected(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, s.current)
+
+	changed.Store(true)
+	networkGeneration.Add(1)
+	got, err := s.selected(context.Background())
+	require.NoError(t, err)
+	// The race runs in the background, keeping the previous selection meanwhile.
+	require.Same(t, s.servers[0].sd, got.sd)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.current == 1 && s.evaluating == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerSelector_CanceledWhileRacing(t *testing.T) {
+	s := newTestSelector(time.Second)
+	s.servers = append(s.servers, s.servers[0])
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.selected(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.evaluating == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestParseMultiTransport(t *testing.T) {
+	server := `{"host":"example.com","port":1234,"method":"chacha20-ietf-poly1305","password":"abcd"}`
+	for _, strategy := range []string{"", "first", "random", "fastest"} {
+		got := NewClient(`{"$type":"multi","strategy":"` + strategy + `","transports":[` + server + `,` + server + `]}`)
+		require.Nil(t, got.Error, strategy)
+	}
+
+	s, _, err := parseMultiTransport([]byte(`{"strategy":"first","transports":[`+server+`,`+server+`]}`), TransportDialers{})
+	require.NoError(t, err)
+	got, err := s.(*serverSelector).selected(context.Background())
+	require.NoError(t, err)
+	require.Same(t, s.(*serverSelector).servers[0].sd, got.sd)
+}
+
+func TestParseMultiTransport_Invalid(t *testing.T) {
+	server := `{"host":"example.com","port":1234,"method":"chacha20-ietf-poly1305","password":"abcd"}`
+	for _, config := range []string{
+		`{"$type":"multi"}`,
+		`{"$type":"multi","transports":[]}`,
+		`{"$type":"multi","strategy":"slowest","transports":[` + server + `]}`,
+		`{"$type":"multi","reevaluateSeconds":-1,"transports":[` + server + `]}`,
+		`{"$type":"multi","transports":[{"$type":"unknown"}]}`,
+		`{"$type":"multi","transports":[{"host":"example.com"}]}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// sessionStateJSON is the state of the active VPN session, persisted so that the session can be
+// restored after the process is killed, see [MethodResumeLastSession]. It contains the secrets of
+// the transport, so it is only readable by the user.
+type sessionStateJSON struct {
+	VPN        vpnConfigJSON `json:"vpn"`
+	KillSwitch bool          `json:"killSwitch,omitempty"`
+}
+
+var sessionMu sync.Mutex
+
+// sessionStateFile is the file the session state is persisted to, or "" if it's not persisted.
+var sessionStateFile string
+
+// setSessionStateFile sets the file the session state is persisted to, e.g. in the data
+// directory of the app, or disables the persistence and deletes the previous file if path is "".
+func setSessionStateFile(path string) error {
+	if path != "" && !filepath.IsAbs(path) {
+		return platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "session state file must be an absolute path",
+			Details: platerrors.ErrorDetails{"path": path},
+		}
+	}
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if path == "" && sessionStateFile != "" {
+		if err := os.Remove(sessionStateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("failed to delete the session state", "err", err)
+		}
+	}
+	sessionStateFile = path
+	return nil
+}
+
+// updateSessionState applies update to the persisted session state, and writes it atomically.
+// A missing state starts from its zero value. It does nothing if the persistence is disabled, and
+// only logs the errors, since failing to persist must not fail the session itself.
+func updateSessionState(update func(state *sessionStateJSON)) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if sessionStateFile == "" {
+		return
+	}
+	state, err := readSessionState(sessionStateFile)
+	if err != nil || state == nil {
+		state = &sessionStateJSON{}
+	}
+	update(state)
+	if err := writeSessionState(sessionStateFile, state); err != nil {
+		logger.Warn("failed to persist the session state", "err", err)
+	}
+}
+
+// clearSessionState deletes the persisted session state, so that no session is resumed.
+func clearSessionState() {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if sessionStateFile == "" {
+		return
+	}
+	if err := os.Remove(sessionStateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("failed to delete the session state", "err", err)
+	}
+}
+
+// readSessionState reads the session state in path, or returns nil if there is none.
+func readSessionState(path string) (*sessionStateJSON, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state sessionStateJSON
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.VPN.TransportConfig == "" {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// writeSessionState writes state to path through a temporary file, so that a crash never leaves
+// a partial state behind.
+func writeSessionState(path string, state *sessionStateJSON) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp already makes the file readable by the user only.
+	return os.Rename(tmp.Name(), path)
+}
+
+// establishAndPersistVPN establishes the VPN connection like [establishVPN], and persists its
+// config once it's connected.
+func establishAndPersistVPN(configStr string) error {
+	if err := establishVPN(configStr); err != nil {
+		return err
+	}
+	var conf vpnConfigJSON
+	if err := json.Unmarshal([]byte(configStr), &conf); err != nil {
+		return nil
+	}
+	updateSessionState(func(state *sessionStateJSON) {
+		state.VPN = conf
+		state.KillSwitch = killSwitchEnabled()
+	})
+	return nil
+}
+
+// replaceAndPersistVPNTransport replaces the transport of the VPN connection like
+// [replaceVPNTransport], and persists the new transport.
+func replaceAndPersistVPNTransport(transportConfig string) error {
+	if err := replaceVPNTransport(transportConfig); err != nil {
+		return err
+	}
+	updateSessionState(func(state *sessionStateJSON) {
+		if state.VPN.TransportConfig != "" {
+			state.VPN.TransportConfig = transportConfig
+		}
+	})
+	return nil
+}
+
+// closeAndForgetVPN closes the VPN connection like [closeVPN], and deletes the persisted session
+// so that it isn't resumed.
+func closeAndForgetVPN() error {
+	clearSessionState()
+	return closeVPN()
+}
+
+// resumeLastSession re-establishes the persisted VPN session, if any, with the kill switch it had.
+// It returns whether there was a session to resume. The session stays persisted if it fails to
+// resume, so that it can be retried.
+func resumeLastSession() (bool, error) {
+	sessionMu.Lock()
+	path := sessionStateFile
+	sessionMu.Unlock()
+	if path == "" {
+		return false, nil
+	}
+	state, err := readSessionState(path)
+	if err != nil {
+		return false, platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to read the session state",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if state == nil {
+		return false, nil
+	}
+	killSwitch.Store(state.KillSwitch)
+	configStr, err := json.Marshal(state.VPN)
+	if err != nil {
+		return false, err
+	}
+	logger.Info("resuming the last session", "killSwitch", state.KillSwitch)
+	if err := establishVPN(string(configStr)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
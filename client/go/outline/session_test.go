@@ -0,0 +1,98 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/vpn"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestSessionStateFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "session.json")
+	require.NoError(t, setSessionStateFile(path))
+	t.Cleanup(func() { setSessionStateFile("") })
+	return path
+}
+
+func TestSessionState_Update(t *testing.T) {
+	path := setTestSessionStateFile(t)
+
+	updateSessionState(func(state *sessionStateJSON) {
+		state.VPN = vpnConfigJSON{VPNConfig: vpn.Config{ID: "outline"}, TransportConfig: `{"host":"192.0.2.1"}`}
+	})
+	updateSessionState(func(state *sessionStateJSON) { state.KillSwitch = true })
+	state, err := readSessionState(path)
+	require.NoError(t, err)
+	require.Equal(t, &sessionStateJSON{
+		VPN:        vpnConfigJSON{VPNConfig: vpn.Config{ID: "outline"}, TransportConfig: `{"host":"192.0.2.1"}`},
+		KillSwitch: true,
+	}, state)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the temporary file must be removed")
+
+	clearSessionState()
+	state, err = readSessionState(path)
+	require.NoError(t, err)
+	require.Nil(t, state)
+}
+
+func TestResumeLastSession_None(t *testing.T) {
+	// Not persisted.
+	resumed, err := resumeLastSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+
+	// Nothing persisted yet, or only the kill switch.
+	setTestSessionStateFile(t)
+	resumed, err = resumeLastSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+	updateSessionState(func(state *sessionStateJSON) { state.KillSwitch = true })
+	resumed, err = resumeLastSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+}
+
+func TestResumeLastSession_Corrupt(t *testing.T) {
+	path := setTestSessionStateFile(t)
+	require.NoError(t, os.WriteFile(path, []byte(`{"vpn":`), 0600))
+	_, err := resumeLastSession()
+	perr := platerrors.ToPlatformError(err)
+	require.NotNil(t, perr)
+	require.Equal(t, platerrors.InternalError, perr.Code)
+}
+
+func TestSetSessionStateFile(t *testing.T) {
+	err := setSessionStateFile("session.json")
+	perr := platerrors.ToPlatformError(err)
+	require.NotNil(t, perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+
+	path := setTestSessionStateFile(t)
+	updateSessionState(func(state *sessionStateJSON) { state.VPN.TransportConfig = "{}" })
+	require.FileExists(t, path)
+	require.NoError(t, setSessionStateFile(""))
+	require.NoFileExists(t, path)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ss2022 implements the client of the Shadowsocks 2022 protocol (SIP022), with the
+// 2022-blake3-aes-128-gcm, 2022-blake3-aes-256-gcm and 2022-blake3-chacha20-poly1305 ciphers.
+//
+// See https://github.com/Shadowsocks-NET/shadowsocks-specs/blob/main/2022-1-shadowsocks-2022-edition.md.
+package ss2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// Names of the Shadowsocks 2022 ciphers.
+const (
+	AES128GCM        = "2022-blake3-aes-128-gcm"
+	AES256GCM        = "2022-blake3-aes-256-gcm"
+	ChaCha20Poly1305 = "2022-blake3-chacha20-poly1305"
+)
+
+// Ciphers are the supported Shadowsocks 2022 ciphers.
+var Ciphers = []string{AES128GCM, AES256GCM, ChaCha20Poly1305}
+
+const subkeyContext = "shadowsocks 2022 session subkey"
+
+// Key is the pre-shared key of a Shadowsocks 2022 server, with its cipher.
+type Key struct {
+	cipher string
+	psk    []byte
+
+	// block encrypts the separate headers of the UDP packets of the AES ciphers.
+	block cipher.Block
+}
+
+// IsCipher returns whether name is the name of a Shadowsocks 2022 cipher.
+func IsCipher(name string) bool {
+	name = strings.ToLower(name)
+	for _, c := range Ciphers {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewKey creates the [Key] of the cipher named cipherName, from password, the base64-encoded
+// pre-shared key. Multi-user keys, with several pre-shared keys separated by ":", are not
+// supported.
+func NewKey(cipherName, password string) (*Key, error) {
+	cipherName = strings.ToLower(cipherName)
+	var keySize int
+	switch cipherName {
+	case AES128GCM:
+		keySize = 16
+	case AES256GCM, ChaCha20Poly1305:
+		keySize = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", cipherName)
+	}
+	if strings.Contains(password, ":") {
+		return nil, fmt.Errorf("multi-user keys are not supported")
+	}
+	psk, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return nil, fmt.Errorf("the key is not valid base64: %w", err)
+	}
+	if len(psk) != keySize {
+		return nil, fmt.Errorf("the key must be %d bytes long, got %d", keySize, len(psk))
+	}
+	k := &Key{cipher: cipherName, psk: psk}
+	if cipherName != ChaCha20Poly1305 {
+		if k.block, err = aes.NewCipher(psk); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// SaltSize returns the size of the salts of the stream connections, which is the key size.
+func (k *Key) SaltSize() int {
+	return len(k.psk)
+}
+
+// sessionAEAD returns the AEAD of the session identified by salt, encrypting with the session
+// subkey derived from the pre-shared key and salt.
+func (k *Key) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	material := make([]byte, 0, len(k.psk)+len(salt))
+	material = append(append(material, k.psk...), salt...)
+	subkey := make([]byte, len(k.psk))
+	blake3.DeriveKey(subkey, subkeyContext, material)
+	return k.newAEAD(subkey)
+}
+
+func (k *Key) newAEAD(key []byte) (cipher.AEAD, error) {
+	if k.cipher == ChaCha20Poly1305 {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,289 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Packet types.
+const (
+	headerTypeClientPacket = 0
+	headerTypeServerPacket = 1
+)
+
+const (
+	sessionIDSize = 8
+	packetIDSize  = 8
+
+	// separateHeaderSize is the size of the session ID and packet ID header of the packets.
+	separateHeaderSize = sessionIDSize + packetIDSize
+
+	// udpBufferSize is the maximum supported size of the encrypted packets.
+	udpBufferSize = 16 * 1024
+)
+
+// packetPool holds the buffers of the encrypted and plaintext packets, to avoid allocating them
+// for each packet.
+var packetPool = bufpool.New(udpBufferSize)
+
+// PacketListener is a [transport.PacketListener] relaying the packets through a Shadowsocks 2022
+// server. Each listened connection is a new session.
+type PacketListener struct {
+	endpoint transport.PacketEndpoint
+	key      *Key
+
+	// Padding returns the number of padding bytes of a packet with a payload of payloadLen bytes,
+	// at most 65535. The packets are not padded if it is nil.
+	Padding func(payloadLen int) int
+}
+
+var _ transport.PacketListener = (*PacketListener)(nil)
+
+// NewPacketListener creates a [PacketListener] relaying the packets through the Shadowsocks 2022
+// server at endpoint with key.
+func NewPacketListener(endpoint transport.PacketEndpoint, key *Key) (*PacketListener, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &PacketListener{endpoint: endpoint, key: key}, nil
+}
+
+func (l *PacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	c := &packetConn{key: l.key, padding: l.Padding}
+	if _, err := rand.Read(c.sessionID[:]); err != nil {
+		return nil, err
+	}
+	var err error
+	if l.key.cipher == ChaCha20Poly1305 {
+		c.aead, err = chacha20poly1305.NewX(l.key.psk)
+	} else {
+		c.aead, err = l.key.sessionAEAD(c.sessionID[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Conn, err = l.endpoint.ConnectPacket(ctx); err != nil {
+		return nil, fmt.Errorf("could not connect to endpoint: %w", err)
+	}
+	return c, nil
+}
+
+// serverSession is a session of the server, identified by its session ID.
+type serverSession struct {
+	id     [sessionIDSize]byte
+	aead   cipher.AEAD // Unused with ChaCha20-Poly1305, which uses the pre-shared key.
+	window replayWindow
+}
+
+type packetConn struct {
+	net.Conn
+	key       *Key
+	sessionID [sessionIDSize]byte
+	aead      cipher.AEAD
+	padding   func(payloadLen int) int
+
+	writeMu  sync.Mutex
+	packetID uint64
+
+	readMu sync.Mutex
+	// The current and previous sessions of the server, which changes sessions from time to time.
+	sessions [2]*serverSession
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+// WriteTo encrypts b and writes it to addr through the proxy.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := socks.ParseAddr(addr.String())
+	if target == nil {
+		return 0, errors.New("failed to parse target address")
+	}
+	c.writeMu.Lock()
+	packetID := c.packetID
+	c.packetID++
+	c.writeMu.Unlock()
+
+	var header [separateHeaderSize]byte
+	copy(header[:], c.sessionID[:])
+	binary.BigEndian.PutUint64(header[sessionIDSize:], packetID)
+
+	bodyBuf, packetBuf := packetPool.Get(), packetPool.Get()
+	defer packetPool.Put(bodyBuf)
+	defer packetPool.Put(packetBuf)
+
+	// The body and the packet are built in the pooled buffers, unless they are too large.
+	body := (*bodyBuf)[:0]
+	if c.key.block == nil {
+		body = append(body, header[:]...)
+	}
+	body = append(body, headerTypeClientPacket)
+	body = binary.BigEndian.AppendUint64(body, uint64(now().Unix()))
+	paddingLen := 0
+	if c.padding != nil {
+		paddingLen = c.padding(len(b))
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(paddingLen))
+	body = append(body, make([]byte, paddingLen)...)
+	body = append(body, target...)
+	body = append(body, b...)
+
+	var packet []byte
+	if c.key.block == nil {
+		nonce := (*packetBuf)[:chacha20poly1305.NonceSizeX]
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, err
+		}
+		packet = c.aead.Seal(nonce, nonce, body, nil)
+	} else {
+		packet = (*packetBuf)[:separateHeaderSize]
+		c.key.block.Encrypt(packet, header[:])
+		packet = c.aead.Seal(packet, header[4:], body, nil)
+	}
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// session returns the server session with the given ID. A new session isn't stored until
+// [packetConn.addSession], so that unauthenticated packets can't evict the known sessions.
+func (c *packetConn) session(id []byte) (*serverSession, error) {
+	for _, s := range c.sessions {
+		if s != nil && string(s.id[:]) == string(id) {
+			return s, nil
+		}
+	}
+	s := &serverSession{}
+	copy(s.id[:], id)
+	if c.key.block != nil {
+		var err error
+		if s.aead, err = c.key.sessionAEAD(id); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// addSession stores s as the current server session if it is new, and keeps the previous one.
+func (c *packetConn) addSession(s *serverSession) {
+	if s != c.sessions[0] && s != c.sessions[1] {
+		c.sessions[1], c.sessions[0] = c.sessions[0], s
+	}
+}
+
+// open decrypts packet, returning its server session, the separate header and the body.
+func (c *packetConn) open(packet []byte) (s *serverSession, header, body []byte, err error) {
+	if c.key.block == nil {
+		if len(packet) < chacha20poly1305.NonceSizeX+separateHeaderSize+tagSize {
+			return nil, nil, nil, errors.New("packet is too short")
+		}
+		nonce := packet[:chacha20poly1305.NonceSizeX]
+		plaintext, err := c.aead.Open(packet[len(nonce):len(nonce)], nonce, packet[len(nonce):], nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt: %w", err)
+		}
+		header = plaintext[:separateHeaderSize]
+		if s, err = c.session(header[:sessionIDSize]); err != nil {
+			return nil, nil, nil, err
+		}
+		return s, header, plaintext[separateHeaderSize:], nil
+	}
+	if len(packet) < separateHeaderSize+tagSize {
+		return nil, nil, nil, errors.New("packet is too short")
+	}
+	header = make([]byte, separateHeaderSize)
+	c.key.block.Decrypt(header, packet[:separateHeaderSize])
+	if s, err = c.session(header[:sessionIDSize]); err != nil {
+		return nil, nil, nil, err
+	}
+	body, err = s.aead.Open(packet[separateHeaderSize:separateHeaderSize], header[4:], packet[separateHeaderSize:], nil)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return s, header, body, nil
+}
+
+// ReadFrom reads a packet from the proxy and decrypts it into b.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	bufPtr := packetPool.Get()
+	defer packetPool.Put(bufPtr)
+	buf := *bufPtr
+	n, err := c.Conn.Read(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	s, header, body, err := c.open(buf[:n])
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) < 1+timestampSize+sessionIDSize+2 {
+		return 0, nil, errors.New("packet is too short")
+	}
+	if body[0] != headerTypeServerPacket {
+		return 0, nil, fmt.Errorf("unexpected header type %d", body[0])
+	}
+	if !checkTimestamp(binary.BigEndian.Uint64(body[1:])) {
+		return 0, nil, errors.New("packet timestamp is too far from the local time")
+	}
+	body = body[1+timestampSize:]
+	if string(body[:sessionIDSize]) != string(c.sessionID[:]) {
+		return 0, nil, errors.New("packet is not for this session")
+	}
+	padding := int(binary.BigEndian.Uint16(body[sessionIDSize:]))
+	body = body[sessionIDSize+2:]
+	if len(body) < padding {
+		return 0, nil, errors.New("packet is too short")
+	}
+	body = body[padding:]
+	src := socks.SplitAddr(body)
+	if src == nil {
+		return 0, nil, errors.New("failed to read source address")
+	}
+
+	// Only count the packet, and store its session, once it is authenticated.
+	if !s.window.check(binary.BigEndian.Uint64(header[sessionIDSize:])) {
+		return 0, nil, errors.New("replayed packet")
+	}
+	c.addSession(s)
+
+	srcAddr, err := transport.MakeNetAddr("udp", src.String())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to convert incoming address: %w", err)
+	}
+	n = copy(b, body[len(src):])
+	if n < len(body)-len(src) {
+		return n, srcAddr, io.ErrShortBuffer
+	}
+	return n, srcAddr, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxTimeDifference is the maximum difference between the timestamps of the headers and the
+	// local clock.
+	maxTimeDifference = 30 * time.Second
+
+	// saltTTL is how long the salts are remembered to detect replays. It must be longer than
+	// twice maxTimeDifference, so that a replayed salt is either remembered or too old.
+	saltTTL = 60 * time.Second
+)
+
+// now returns the current time. Tests can replace it.
+var now = time.Now
+
+// checkTimestamp returns whether the Unix timestamp ts of a header is close enough to now.
+func checkTimestamp(ts uint64) bool {
+	diff := now().Sub(time.Unix(int64(ts), 0))
+	return diff <= maxTimeDifference && diff >= -maxTimeDifference
+}
+
+// saltPool remembers the salts of the server responses to detect replayed responses.
+type saltPool struct {
+	mu        sync.Mutex
+	salts     map[string]time.Time
+	lastPrune time.Time
+}
+
+func newSaltPool() *saltPool {
+	return &saltPool{salts: make(map[string]time.Time)}
+}
+
+// add adds salt to the pool. It returns false if the salt was already in it.
+func (p *saltPool) add(salt []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := now()
+	if t.Sub(p.lastPrune) >= saltTTL {
+		for s, expiry := range p.salts {
+			if t.After(expiry) {
+				delete(p.salts, s)
+			}
+		}
+		p.lastPrune = t
+	}
+	if expiry, ok := p.salts[string(salt)]; ok && !t.After(expiry) {
+		return false
+	}
+	p.salts[string(salt)] = t.Add(saltTTL)
+	return true
+}
+
+// replayWindow is a sliding window of the packet IDs received in a UDP session, rejecting the
+// repeated and the too old ones.
+type replayWindow struct {
+	last uint64
+	seen uint64 // Bit i is set if the packet last-i was received.
+	init bool
+}
+
+const replayWindowSize = 64
+
+// check records packet ID id, returning false if it was received already or is too old.
+func (w *replayWindow) check(id uint64) bool {
+	switch {
+	case !w.init:
+		w.init, w.last, w.seen = true, id, 1
+		return true
+	case id > w.last:
+		shift := id - w.last
+		if shift >= replayWindowSize {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<shift | 1
+		}
+		w.last = id
+		return true
+	case w.last-id >= replayWindowSize:
+		return false
+	default:
+		bit := uint64(1) << (w.last - id)
+		if w.seen&bit != 0 {
+			return false
+		}
+		w.seen |= bit
+		return true
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func newTestKey(t testing.TB, cipherName string, size int) *Key {
+	psk := make([]byte, size)
+	_, err := rand.Read(psk)
+	require.NoError(t, err)
+	key, err := NewKey(cipherName, base64.StdEncoding.EncodeToString(psk))
+	require.NoError(t, err)
+	return key
+}
+
+func TestNewKey(t *testing.T) {
+	key16 := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	key32 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	for _, tt := range []struct{ cipher, password string }{
+		{AES128GCM, key16},
+		{"2022-BLAKE3-AES-256-GCM", key32},
+		{ChaCha20Poly1305, key32},
+	} {
+		_, err := NewKey(tt.cipher, tt.password)
+		require.NoError(t, err, tt.cipher)
+	}
+	for _, tt := range []struct{ cipher, password string }{
+		{AES128GCM, key32},
+		{AES256GCM, "not base64"},
+		{AES256GCM, key16 + ":" + key32},
+		{"aes-256-gcm", key32},
+	} {
+		_, err := NewKey(tt.cipher, tt.password)
+		require.Error(t, err, tt.cipher)
+	}
+	require.True(t, IsCipher("2022-blake3-aes-128-gcm"))
+	require.False(t, IsCipher("aes-128-gcm"))
+}
+
+// testStreamServer is a Shadowsocks 2022 server echoing the data of one connection, after
+// checking it connects to target.
+func testStreamServer(t testing.TB, key *Key, target string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reqSalt := make([]byte, key.SaltSize())
+		_, err = io.ReadFull(conn, reqSalt)
+		require.NoError(t, err)
+		reqAEAD, err := key.sessionAEAD(reqSalt)
+		require.NoError(t, err)
+		r := &streamReader{r: conn, aead: reqAEAD, nonce: make([]byte, reqAEAD.NonceSize())}
+		fixed, err := r.readChunk(1 + timestampSize + 2)
+		require.NoError(t, err)
+		require.Equal(t, byte(headerTypeClientStream), fixed[0])
+		require.True(t, checkTimestamp(binary.BigEndian.Uint64(fixed[1:])))
+		varHeader, err := r.readChunk(int(binary.BigEndian.Uint16(fixed[9:])))
+		require.NoError(t, err)
+		addr := socks.SplitAddr(varHeader)
+		require.Equal(t, target, addr.String())
+		varHeader = varHeader[len(addr):]
+		padding := int(binary.BigEndian.Uint16(varHeader))
+		initial := append([]byte(nil), varHeader[2+padding:]...)
+		if len(initial) == 0 {
+			require.NotZero(t, padding)
+		}
+
+		respSalt := make([]byte, key.SaltSize())
+		_, err = rand.Read(respSalt)
+		require.NoError(t, err)
+		respAEAD, err := key.sessionAEAD(respSalt)
+		require.NoError(t, err)
+		w := &streamWriter{w: conn, aead: respAEAD, nonce: make([]byte, respAEAD.NonceSize())}
+		header := []byte{headerTypeServerStream}
+		header = binary.BigEndian.AppendUint64(header, uint64(time.Now().Unix()))
+		header = append(header, reqSalt...)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(initial)))
+		out := w.seal(append([]byte(nil), respSalt...), header)
+		out = w.seal(out, initial)
+		_, err = conn.Write(out)
+		require.NoError(t, err)
+
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			_, err = w.Write(buf[:n])
+			require.NoError(t, err)
+		}
+	}()
+	return l
+}
+
+func TestStreamDialer(t *testing.T) {
+	for _, tt := range []struct {
+		cipher string
+		size   int
+	}{{AES128GCM, 16}, {AES256GCM, 32}, {ChaCha20Poly1305, 32}} {
+		for _, wait := range []time.Duration{time.Second, 0} {
+			t.Run(tt.cipher, func(t *testing.T) {
+				key := newTestKey(t, tt.cipher, tt.size)
+				l := testStreamServer(t, key, "example.com:443")
+				defer l.Close()
+
+				d, err := NewStreamDialer(&transport.StreamDialerEndpoint{Dialer: &transport.TCPDialer{}, Address: l.Addr().String()}, key)
+				require.NoError(t, err)
+				d.ClientDataWait = wait
+				conn, err := d.DialStream(context.Background(), "example.com:443")
+				require.NoError(t, err)
+				defer conn.Close()
+				if wait == 0 {
+					// Let the header be sent without payload.
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				for _, msg := range []string{"hello", string(make([]byte, maxPayload+10)), "bye"} {
+					_, err = conn.Write([]byte(msg))
+					require.NoError(t, err)
+					got := make([]byte, len(msg))
+					_, err = io.ReadFull(conn, got)
+					require.NoError(t, err)
+					require.Equal(t, msg, string(got))
+				}
+			})
+		}
+	}
+}
+
+// serverPacket encrypts a packet of the server, from the server session serverSession, for the
+// client session clientSession.
+func serverPacket(t *testing.T, key *Key, serverSession, clientSession []byte, packetID uint64, src string, payload []byte) []byte {
+	var header [separateHeaderSize]byte
+	copy(header[:], serverSession)
+	binary.BigEndian.PutUint64(header[sessionIDSize:], packetID)
+	var body []byte
+	if key.block == nil {
+		body = append(body, header[:]...)
+	}
+	body = append(body, headerTypeServerPacket)
+	body = binary.BigEndian.AppendUint64(body, uint64(time.Now().Unix()))
+	body = append(body, clientSession...)
+	body = binary.BigEndian.AppendUint16(body, 3)
+	body = append(body, 0, 0, 0)
+	body = append(body, socks.ParseAddr(src)...)
+	body = append(body, payload...)
+
+	if key.block == nil {
+		aead, err := chacha20poly1305.NewX(key.psk)
+		require.NoError(t, err)
+		nonce := make([]byte, chacha20poly1305.NonceSizeX)
+		_, err = rand.Read(nonce)
+		require.NoError(t, err)
+		return aead.Seal(nonce, nonce, body, nil)
+	}
+	aead, err := key.sessionAEAD(serverSession)
+	require.NoError(t, err)
+	packet := make([]byte, separateHeaderSize)
+	key.block.Encrypt(packet, header[:])
+	return aead.Seal(packet, header[4:], body, nil)
+}
+
+// openClientPacket decrypts a packet of the client, returning its session ID, target and payload.
+func openClientPacket(t *testing.T, key *Key, packet []byte) (session []byte, target string, payload []byte) {
+	var header, body []byte
+	if key.block == nil {
+		aead, err := chacha20poly1305.NewX(key.psk)
+		require.NoError(t, err)
+		plaintext, err := aead.Open(nil, packet[:chacha20poly1305.NonceSizeX], packet[chacha20poly1305.NonceSizeX:], nil)
+		require.NoError(t, err)
+		header, body = plaintext[:separateHeaderSize], plaintext[separateHeaderSize:]
+	} else {
+		header = make([]byte, separateHeaderSize)
+		key.block.Decrypt(header, packet[:separateHeaderSize])
+		var aead cipher.AEAD
+		aead, err := key.sessionAEAD(header[:sessionIDSize])
+		require.NoError(t, err)
+		body, err = aead.Open(nil, header[4:], packet[separateHeaderSize:], nil)
+		require.NoError(t, err)
+	}
+	require.Equal(t, byte(headerTypeClientPacket), body[0])
+	require.True(t, checkTimestamp(binary.BigEndian.Uint64(body[1:])))
+	padding := int(binary.BigEndian.Uint16(body[1+timestampSize:]))
+	body = body[1+timestampSize+2+padding:]
+	addr := socks.SplitAddr(body)
+	return header[:sessionIDSize], addr.String(), body[len(addr):]
+}
+
+func TestPacketListener(t *testing.T) {
+	for _, tt := range []struct {
+		cipher string
+		size   int
+	}{{AES128GCM, 16}, {AES256GCM, 32}, {ChaCha20Poly1305, 32}} {
+		t.Run(tt.cipher, func(t *testing.T) {
+			key := newTestKey(t, tt.cipher, tt.size)
+			server, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer server.Close()
+
+			l, err := NewPacketListener(&transport.UDPEndpoint{Address: server.LocalAddr().String()}, key)
+			require.NoError(t, err)
+			conn, err := l.ListenPacket(context.Background())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			target := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+			_, err = conn.WriteTo([]byte("query"), target)
+			require.NoError(t, err)
+
+			buf := make([]byte, udpBufferSize)
+			n, clientAddr, err := server.ReadFrom(buf)
+			require.NoError(t, err)
+			session, gotTarget, payload := openClientPacket(t, key, buf[:n])
+			require.Equal(t, target.String(), gotTarget)
+			require.Equal(t, "query", string(payload))
+
+			serverSession := []byte("server01")
+			reply := serverPacket(t, key, serverSession, session, 1, target.String(), []byte("answer"))
+			for i := 0; i < 2; i++ {
+				_, err = server.WriteTo(reply, clientAddr)
+				require.NoError(t, err)
+			}
+			n, src, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, "answer", string(buf[:n]))
+			require.Equal(t, target.String(), src.String())
+
+			// The repeated packet is rejected.
+			_, _, err = conn.ReadFrom(buf)
+			require.ErrorContains(t, err, "replayed")
+
+			// Forged packets with other server sessions don't evict the session, and the packet
+			// is still rejected when it is replayed after them.
+			for i := 0; i < 2; i++ {
+				forged := make([]byte, len(reply))
+				_, err = rand.Read(forged)
+				require.NoError(t, err)
+				_, err = server.WriteTo(forged, clientAddr)
+				require.NoError(t, err)
+				_, _, err = conn.ReadFrom(buf)
+				require.ErrorContains(t, err, "failed to decrypt")
+			}
+			_, err = server.WriteTo(reply, clientAddr)
+			require.NoError(t, err)
+			_, _, err = conn.ReadFrom(buf)
+			require.ErrorContains(t, err, "replayed")
+
+			// A packet for another client session is rejected.
+			_, err = server.WriteTo(serverPacket(t, key, serverSession, []byte("otherses"), 2, target.String(), nil), clientAddr)
+			require.NoError(t, err)
+			_, _, err = conn.ReadFrom(buf)
+			require.ErrorContains(t, err, "not for this session")
+		})
+	}
+}
+
+func TestPacketListener_Padding(t *testing.T) {
+	key := newTestKey(t, AES128GCM, 16)
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	l, err := NewPacketListener(&transport.UDPEndpoint{Address: server.LocalAddr().String()}, key)
+	require.NoError(t, err)
+	target := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	buf := make([]byte, udpBufferSize)
+	var sizes []int
+	for _, padding := range []int{0, 100} {
+		l.Padding = func(payloadLen int) int {
+			require.Equal(t, len("query"), payloadLen)
+			return padding
+		}
+		conn, err := l.ListenPacket(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.WriteTo([]byte("query"), target)
+		require.NoError(t, err)
+
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		_, gotTarget, payload := openClientPacket(t, key, buf[:n])
+		require.Equal(t, target.String(), gotTarget)
+		require.Equal(t, "query", string(payload))
+		sizes = append(sizes, n)
+	}
+	require.Equal(t, 100, sizes[1]-sizes[0])
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	require.True(t, w.check(10))
+	require.False(t, w.check(10))
+	require.True(t, w.check(8))
+	require.True(t, w.check(100))
+	require.False(t, w.check(8))
+	require.True(t, w.check(99))
+	require.False(t, w.check(99))
+	require.False(t, w.check(100-replayWindowSize))
+}
+
+func TestSaltPool(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	p := newSaltPool()
+	require.True(t, p.add([]byte("salt")))
+	require.False(t, p.add([]byte("salt")))
+	require.True(t, p.add([]byte("other")))
+
+	now = func() time.Time { return start.Add(saltTTL + time.Second) }
+	require.True(t, p.add([]byte("salt")))
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	ts := uint64(time.Now().Unix())
+	require.True(t, checkTimestamp(ts))
+	require.True(t, checkTimestamp(ts-29))
+	require.False(t, checkTimestamp(ts-60))
+	require.False(t, checkTimestamp(ts+60))
+}
+
+// discardConn is a [net.Conn] discarding the written packets.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func BenchmarkPacketConn_WriteTo(b *testing.B) {
+	for _, tt := range []struct {
+		cipher string
+		size   int
+	}{{AES128GCM, 16}, {ChaCha20Poly1305, 32}} {
+		b.Run(tt.cipher, func(b *testing.B) {
+			endpoint := transport.FuncPacketEndpoint(func(context.Context) (net.Conn, error) {
+				return discardConn{}, nil
+			})
+			l, err := NewPacketListener(endpoint, newTestKey(b, tt.cipher, tt.size))
+			require.NoError(b, err)
+			conn, err := l.ListenPacket(context.Background())
+			require.NoError(b, err)
+			target := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+			payload := make([]byte, 1200)
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.WriteTo(payload, target); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStreamWriter_Write(b *testing.B) {
+	for _, tt := range []struct {
+		cipher string
+		size   int
+	}{{AES128GCM, 16}, {AES256GCM, 32}, {ChaCha20Poly1305, 32}} {
+		b.Run(tt.cipher, func(b *testing.B) {
+			key := newTestKey(b, tt.cipher, tt.size)
+			salt := make([]byte, key.SaltSize())
+			aead, err := key.sessionAEAD(salt)
+			require.NoError(b, err)
+			w := &streamWriter{w: io.Discard, aead: aead, nonce: make([]byte, aead.NonceSize()), salt: salt}
+			payload := make([]byte, 16*1024)
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.Write(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStreamConn_RoundTrip measures the latency of a message relayed through a local server,
+// which encrypts and decrypts it on both sides.
+func BenchmarkStreamConn_RoundTrip(b *testing.B) {
+	for _, size := range []int{64, 1400, 16 * 1024} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			key := newTestKey(b, AES128GCM, 16)
+			l := testStreamServer(b, key, "example.com:443")
+			defer l.Close()
+			d, err := NewStreamDialer(&transport.StreamDialerEndpoint{Dialer: &transport.TCPDialer{}, Address: l.Addr().String()}, key)
+			require.NoError(b, err)
+			conn, err := d.DialStream(context.Background(), "example.com:443")
+			require.NoError(b, err)
+			defer conn.Close()
+			msg, got := make([]byte, size), make([]byte, size)
+
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// Header types.
+const (
+	headerTypeClientStream = 0
+	headerTypeServerStream = 1
+)
+
+const (
+	tagSize       = 16
+	maxPayload    = 0xFFFF
+	maxPadding    = 900
+	timestampSize = 8
+)
+
+// StreamDialer dials TCP connections through a Shadowsocks 2022 server.
+type StreamDialer struct {
+	endpoint transport.StreamEndpoint
+	key      *Key
+	salts    *saltPool
+
+	// SaltGenerator generates the salts of the requests. It defaults to
+	// [shadowsocks.RandomSaltGenerator].
+	SaltGenerator shadowsocks.SaltGenerator
+
+	// ClientDataWait is how long to wait for client data to send it along with the request
+	// header, like [shadowsocks.StreamDialer.ClientDataWait]. It is 10 milliseconds by default.
+	ClientDataWait time.Duration
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// NewStreamDialer creates a [StreamDialer] connecting to the server at endpoint with key.
+func NewStreamDialer(endpoint transport.StreamEndpoint, key *Key) (*StreamDialer, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &StreamDialer{endpoint: endpoint, key: key, salts: newSaltPool(), ClientDataWait: 10 * time.Millisecond}, nil
+}
+
+// DialStream implements [transport.StreamDialer]. Like Shadowsocks, the connection is returned
+// before the server connects to remoteAddr.
+func (d *StreamDialer) DialStream(ctx context.Context, remoteAddr string) (transport.StreamConn, error) {
+	target := socks.ParseAddr(remoteAddr)
+	if target == nil {
+		return nil, errors.New("failed to parse target address")
+	}
+	salt := make([]byte, d.key.SaltSize())
+	gen := d.SaltGenerator
+	if gen == nil {
+		gen = shadowsocks.RandomSaltGenerator
+	}
+	if err := gen.GetSalt(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := d.key.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	proxyConn, err := d.endpoint.ConnectStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &streamWriter{w: proxyConn, aead: aead, nonce: make([]byte, aead.NonceSize()), salt: salt, target: target}
+	time.AfterFunc(d.ClientDataWait, func() {
+		w.Flush()
+	})
+	r := &streamReader{r: proxyConn, key: d.key, salts: d.salts, requestSalt: salt}
+	return transport.WrapConn(proxyConn, r, w), nil
+}
+
+// increment increments the little-endian counter nonce.
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// streamWriter encrypts the request stream. The request header is sent with the first write,
+// or by Flush if there is no client data.
+type streamWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	salt   []byte
+	target socks.Addr // Set until the header is sent.
+}
+
+func (w *streamWriter) seal(dst, plaintext []byte) []byte {
+	dst = w.aead.Seal(dst, w.nonce, plaintext, nil)
+	increment(w.nonce)
+	return dst
+}
+
+// header returns the request header carrying the initial payload, which must fit into the
+// variable-length header.
+func (w *streamWriter) header(payload []byte) ([]byte, error) {
+	var padding int
+	if len(payload) == 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(maxPadding))
+		if err != nil {
+			return nil, err
+		}
+		padding = int(n.Int64()) + 1
+	}
+	varHeader := make([]byte, 0, len(w.target)+2+padding+len(payload))
+	varHeader = append(varHeader, w.target...)
+	varHeader = binary.BigEndian.AppendUint16(varHeader, uint16(padding))
+	varHeader = append(varHeader, make([]byte, padding)...)
+	varHeader = append(varHeader, payload...)
+
+	fixedHeader := make([]byte, 0, 1+timestampSize+2)
+	fixedHeader = append(fixedHeader, headerTypeClientStream)
+	fixedHeader = binary.BigEndian.AppendUint64(fixedHeader, uint64(now().Unix()))
+	fixedHeader = binary.BigEndian.AppendUint16(fixedHeader, uint16(len(varHeader)))
+
+	out := make([]byte, 0, len(w.salt)+len(fixedHeader)+len(varHeader)+2*tagSize)
+	out = append(out, w.salt...)
+	out = w.seal(out, fixedHeader)
+	return w.seal(out, varHeader), nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []byte
+	written := 0
+	if w.target != nil {
+		initial := p
+		if max := maxPayload - len(w.target) - 2; len(initial) > max {
+			initial = initial[:max]
+		}
+		header, err := w.header(initial)
+		if err != nil {
+			return 0, err
+		}
+		out = header
+		w.target = nil
+		written = len(initial)
+	}
+	for rest := p[written:]; len(rest) > 0; {
+		chunk := rest
+		if len(chunk) > maxPayload {
+			chunk = chunk[:maxPayload]
+		}
+		out = w.seal(out, binary.BigEndian.AppendUint16(nil, uint16(len(chunk))))
+		out = w.seal(out, chunk)
+		rest = rest[len(chunk):]
+	}
+	if _, err := w.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the request header if it wasn't sent yet.
+func (w *streamWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.target == nil {
+		return nil
+	}
+	header, err := w.header(nil)
+	if err != nil {
+		return err
+	}
+	w.target = nil
+	_, err = w.w.Write(header)
+	return err
+}
+
+// streamReader decrypts the response stream.
+type streamReader struct {
+	r           io.Reader
+	key         *Key
+	salts       *saltPool
+	requestSalt []byte
+
+	aead  cipher.AEAD // Set once the response header is read.
+	nonce []byte
+	buf   []byte
+	left  []byte
+}
+
+func (r *streamReader) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := r.aead.Open(ciphertext[:0], r.nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	increment(r.nonce)
+	return plaintext, nil
+}
+
+// readChunk reads and decrypts the next n bytes of plaintext.
+func (r *streamReader) readChunk(n int) ([]byte, error) {
+	if cap(r.buf) < n+tagSize {
+		r.buf = make([]byte, n+tagSize)
+	}
+	buf := r.buf[:n+tagSize]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	return r.open(buf)
+}
+
+// readHeader reads the response header, returning the length of the first payload chunk.
+func (r *streamReader) readHeader() (int, error) {
+	salt := make([]byte, r.key.SaltSize())
+	if _, err := io.ReadFull(r.r, salt); err != nil {
+		return 0, err
+	}
+	aead, err := r.key.sessionAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+	r.aead, r.nonce = aead, make([]byte, aead.NonceSize())
+	header, err := r.readChunk(1 + timestampSize + len(r.requestSalt) + 2)
+	if err != nil {
+		return 0, err
+	}
+	// Only remember the salt once the header is authenticated, so that forged responses can't
+	// fill the pool.
+	if !r.salts.add(salt) {
+		return 0, errors.New("replayed response salt")
+	}
+	if header[0] != headerTypeServerStream {
+		return 0, fmt.Errorf("unexpected header type %d", header[0])
+	}
+	if !checkTimestamp(binary.BigEndian.Uint64(header[1:])) {
+		return 0, errors.New("response timestamp is too far from the local time")
+	}
+	header = header[1+timestampSize:]
+	if !bytes.Equal(header[:len(r.requestSalt)], r.requestSalt) {
+		return 0, errors.New("response is not for this request")
+	}
+	return int(binary.BigEndian.Uint16(header[len(r.requestSalt):])), nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.left) == 0 {
+		var n int
+		var err error
+		if r.aead == nil {
+			n, err = r.readHeader()
+		} else {
+			var length []byte
+			if length, err = r.readChunk(2); err == nil {
+				n = int(binary.BigEndian.Uint16(length))
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if r.left, err = r.readChunk(n); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.left)
+	r.left = r.left[n:]
+	return n, nil
+}
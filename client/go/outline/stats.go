@@ -0,0 +1,118 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
+)
+
+// getStats returns a JSON string of the [stats.Snapshot] of the active tunnel session.
+// All values are zero if there is no active tunnel.
+func getStats() (string, error) {
+	out, err := json.Marshal(stats.Current().Snapshot())
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal traffic statistics",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// getFlowLog returns a JSON array of the [stats.Flow] recently closed in the active tunnel
+// session. The array is empty if there is no active tunnel.
+func getFlowLog() (string, error) {
+	out, err := json.Marshal(stats.Current().Flows())
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal flow log",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// getDNSCacheStats returns a JSON string of the [stats.DNSCache] of the active tunnel session.
+// All values are zero if there is no active tunnel, or it doesn't intercept DNS queries.
+func getDNSCacheStats() (string, error) {
+	out, err := json.Marshal(stats.Current().DNSCache())
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal DNS cache statistics",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// getHealth returns a JSON string of the [resources.Usage] of the process, to diagnose the
+// resources that pile up in long-running sessions.
+func getHealth() (string, error) {
+	out, err := json.Marshal(resources.CurrentUsage())
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal resource usage",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+const statsTickInterval = time.Second
+
+var statsTickerMu sync.Mutex
+var statsTickerStop chan struct{}
+
+// updateStatsTicker starts emitting [EventStatsTick] when it gets its first subscriber, and stops
+// when the last subscriber is gone.
+func updateStatsTicker() {
+	statsTickerMu.Lock()
+	defer statsTickerMu.Unlock()
+	hasSubscribers := event.HasSubscribers(EventStatsTick)
+	if hasSubscribers && statsTickerStop == nil {
+		statsTickerStop = make(chan struct{})
+		stop := statsTickerStop
+		resources.Go(resources.SubsystemStats, func() { runStatsTicker(stop) })
+	} else if !hasSubscribers && statsTickerStop != nil {
+		close(statsTickerStop)
+		statsTickerStop = nil
+	}
+}
+
+func runStatsTicker(stop <-chan struct{}) {
+	ticker := time.NewTicker(statsTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if s := stats.Current(); s != nil {
+				event.Emit(EventStatsTick, s.Snapshot())
+			}
+		}
+	}
+}
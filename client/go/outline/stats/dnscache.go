@@ -0,0 +1,62 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import "sync/atomic"
+
+// DNSCache is a point-in-time copy of the statistics of the DNS cache of a tunnel.
+type DNSCache struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hitRate"`
+	Size     int     `json:"size"`
+	Capacity int     `json:"capacity"`
+}
+
+// DNSCacheSource reports the statistics of a DNS cache.
+type DNSCacheSource interface {
+	CacheStats() DNSCache
+}
+
+// dnsCacheSource holds the [DNSCacheSource] of a [Session].
+type dnsCacheSource struct {
+	src atomic.Pointer[DNSCacheSource]
+}
+
+// SetDNSCache makes src the DNS cache of s, or removes the DNS cache if src is nil. A nil session
+// ignores it.
+func (s *Session) SetDNSCache(src DNSCacheSource) {
+	if s == nil {
+		return
+	}
+	if src == nil {
+		s.dnsCache.src.Store(nil)
+		return
+	}
+	s.dnsCache.src.Store(&src)
+}
+
+// DNSCache returns the statistics of the DNS cache of s. They are all zero if s is nil or has no
+// DNS cache.
+func (s *Session) DNSCache() DNSCache {
+	if s == nil {
+		return DNSCache{}
+	}
+	src := s.dnsCache.src.Load()
+	if src == nil {
+		return DNSCache{}
+	}
+	return (*src).CacheStats()
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxFailureEndpoints is the number of endpoints whose TCP failures are kept in a [Session]. The
+// endpoint whose last failure is the oldest makes room for a new one.
+const maxFailureEndpoints = 256
+
+// minPatternFailures is the number of failures of a kind an endpoint needs for the kind to be
+// reported as its likely pattern.
+const minPatternFailures = 3
+
+// TCPFailureKind is how a relayed TCP connection failed.
+type TCPFailureKind string
+
+const (
+	// TCPFailureRefused is a connection refused, with a RST answering the SYN.
+	TCPFailureRefused TCPFailureKind = "refused"
+
+	// TCPFailureTimedOut is a connection whose SYN, or its proxy handshake, wasn't answered.
+	TCPFailureTimedOut TCPFailureKind = "timed-out"
+
+	// TCPFailureReset is a connection reset after it was established.
+	TCPFailureReset TCPFailureKind = "reset"
+
+	// TCPFailureResetAfterClientHello is a connection reset after sending a TLS ClientHello,
+	// before receiving anything, the signature of the filtering of the TLS server names.
+	TCPFailureResetAfterClientHello TCPFailureKind = "reset-after-client-hello"
+)
+
+// Patterns of the failures of an endpoint, in [TCPFailures].
+const (
+	PatternTLSFiltering   = "tls-filtering"
+	PatternResetInjection = "reset-injection"
+	PatternBlackholing    = "blackholing"
+	PatternRefused        = "refused"
+)
+
+// TCPFailures counts the failures of the TCP sockets to an endpoint.
+//
+// The failures are those of the sockets of the client, so they're attributed to the peers of the
+// sockets: the proxy server for the relayed connections, or the first hop to it, and the
+// destination for the connections routed directly. The refusals, timeouts and resets between the
+// proxy server and the destinations can't be observed through Shadowsocks, whose servers don't
+// report them: they only close the connections.
+type TCPFailures struct {
+	// Endpoint is the host:port of the peer of the failed sockets.
+	Endpoint string `json:"endpoint"`
+
+	Refused               int64 `json:"refused"`
+	TimedOut              int64 `json:"timedOut"`
+	Reset                 int64 `json:"reset"`
+	ResetAfterClientHello int64 `json:"resetAfterClientHello"`
+
+	// LastFailure is the time of the last failure.
+	LastFailure time.Time `json:"lastFailure"`
+
+	// Pattern is the censorship the failures likely reveal, if any: "tls-filtering" for the
+	// resets after the ClientHello, "reset-injection" for the other resets, "blackholing" for
+	// the timeouts and "refused" for the refused connections.
+	Pattern string `json:"pattern,omitempty"`
+}
+
+func (f *TCPFailures) total() int64 {
+	return f.Refused + f.TimedOut + f.Reset + f.ResetAfterClientHello
+}
+
+// pattern returns the likely pattern of the failures, the kind with the most failures if it has
+// at least minPatternFailures.
+func (f *TCPFailures) pattern() string {
+	pattern, most := "", int64(minPatternFailures-1)
+	for _, kind := range []struct {
+		pattern string
+		count   int64
+	}{
+		{PatternTLSFiltering, f.ResetAfterClientHello},
+		{PatternResetInjection, f.Reset},
+		{PatternBlackholing, f.TimedOut},
+		{PatternRefused, f.Refused},
+	} {
+		if kind.count > most {
+			pattern, most = kind.pattern, kind.count
+		}
+	}
+	return pattern
+}
+
+// failureLog counts the TCP failures by endpoint. The zero value is an empty log ready to use.
+type failureLog struct {
+	mu        sync.Mutex
+	endpoints map[string]*TCPFailures
+}
+
+// add counts a failure of kind of the sockets to endpoint.
+func (l *failureLog) add(endpoint string, kind TCPFailureKind) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.endpoints == nil {
+		l.endpoints = make(map[string]*TCPFailures)
+	}
+	f, ok := l.endpoints[endpoint]
+	if !ok {
+		if len(l.endpoints) >= maxFailureEndpoints {
+			var oldest *TCPFailures
+			for _, e := range l.endpoints {
+				if oldest == nil || e.LastFailure.Before(oldest.LastFailure) {
+					oldest = e
+				}
+			}
+			delete(l.endpoints, oldest.Endpoint)
+		}
+		f = &TCPFailures{Endpoint: endpoint}
+		l.endpoints[endpoint] = f
+	}
+	switch kind {
+	case TCPFailureRefused:
+		f.Refused++
+	case TCPFailureTimedOut:
+		f.TimedOut++
+	case TCPFailureReset:
+		f.Reset++
+	case TCPFailureResetAfterClientHello:
+		f.ResetAfterClientHello++
+	}
+	f.LastFailure = time.Now()
+}
+
+// list returns the failures of the endpoints, the endpoints with the most failures first.
+func (l *failureLog) list() []TCPFailures {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures := make([]TCPFailures, 0, len(l.endpoints))
+	for _, f := range l.endpoints {
+		copied := *f
+		copied.Pattern = f.pattern()
+		failures = append(failures, copied)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if ti, tj := failures[i].total(), failures[j].total(); ti != tj {
+			return ti > tj
+		}
+		return failures[i].Endpoint < failures[j].Endpoint
+	})
+	return failures
+}
+
+// TCPFailures returns the failures of the TCP sockets of the connections of s by endpoint, the
+// endpoints with the most failures first. A nil session has no failures.
+func (s *Session) TCPFailures() []TCPFailures {
+	if s == nil {
+		return []TCPFailures{}
+	}
+	return s.failures.list()
+}
+
+// classifyDialError returns the kind of the failure of a dial that failed with err, if it's one
+// of the classified kinds.
+func classifyDialError(err error) (TCPFailureKind, bool) {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return TCPFailureRefused, true
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, syscall.ETIMEDOUT) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return TCPFailureTimedOut, true
+	}
+	if errors.Is(err, syscall.ECONNRESET) {
+		return TCPFailureReset, true
+	}
+	return "", false
+}
+
+// failedEndpoint returns the address of the peer of the socket that failed with err, if err
+// tells it.
+func failedEndpoint(err error) (string, bool) {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Addr != nil {
+		return opErr.Addr.String(), true
+	}
+	return "", false
+}
+
+// isClientHello returns whether b starts like a TLS handshake record, as sent by the clients.
+func isClientHello(b []byte) bool {
+	// The record type is handshake (22), the major version 3, and the handshake message type
+	// ClientHello (1).
+	return len(b) >= 6 && b[0] == 22 && b[1] == 3 && b[5] == 1
+}
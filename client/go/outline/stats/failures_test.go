@@ -0,0 +1,175 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHello is the start of a TLS ClientHello record.
+var clientHello = []byte{22, 3, 1, 0, 100, 1, 0, 0, 96, 3, 3}
+
+// listenResetting listens on a local TCP port, and resets the connections once it has read
+// n bytes from them, after echoing them if echo is set.
+func listenResetting(t *testing.T, n int, echo bool) string {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(conn, buf); err == nil && echo {
+				conn.Write(buf)
+			}
+			// Give the client the time to read the echo before the reset.
+			time.Sleep(50 * time.Millisecond)
+			conn.SetLinger(0)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSession_TCPFailures(t *testing.T) {
+	s := &Session{start: time.Now()}
+	sd := s.StreamDialer(&transport.TCPDialer{})
+
+	// A port that was just closed refuses the connections.
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+	_, err = sd.DialStream(context.Background(), closed)
+	require.Error(t, err)
+
+	filtering := listenResetting(t, len(clientHello), false)
+	conn, err := sd.DialStream(context.Background(), filtering)
+	require.NoError(t, err)
+	_, err = conn.Write(clientHello)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	conn.Close()
+
+	resetting := listenResetting(t, 5, true)
+	conn, err = sd.DialStream(context.Background(), resetting)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	conn.Close()
+
+	failures := s.TCPFailures()
+	require.Len(t, failures, 3)
+	byDest := make(map[string]TCPFailures)
+	for _, f := range failures {
+		byDest[f.Endpoint] = f
+	}
+	require.Equal(t, int64(1), byDest[closed].Refused)
+	require.Equal(t, int64(1), byDest[filtering].ResetAfterClientHello, "the reset must be counted once")
+	require.Equal(t, int64(1), byDest[resetting].Reset)
+	require.Zero(t, byDest[resetting].ResetAfterClientHello)
+}
+
+func TestSession_TCPFailuresRelayed(t *testing.T) {
+	s := &Session{start: time.Now()}
+	// The connections are relayed through a proxy, which resets them.
+	proxy := listenResetting(t, len(clientHello), false)
+	sd := s.StreamDialer(transport.FuncStreamDialer(func(ctx context.Context, _ string) (transport.StreamConn, error) {
+		return (&transport.TCPDialer{}).DialStream(ctx, proxy)
+	}))
+
+	conn, err := sd.DialStream(context.Background(), "blocked.example:443")
+	require.NoError(t, err)
+	_, err = conn.Write(clientHello)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	conn.Close()
+
+	failures := s.TCPFailures()
+	require.Len(t, failures, 1)
+	require.Equal(t, proxy, failures[0].Endpoint, "the failures must be attributed to the proxy, not the destination")
+	require.Equal(t, int64(1), failures[0].ResetAfterClientHello)
+}
+
+func TestFailureLog_Pattern(t *testing.T) {
+	var l failureLog
+	for range minPatternFailures {
+		l.add("blocked.example:443", TCPFailureResetAfterClientHello)
+	}
+	l.add("blocked.example:443", TCPFailureTimedOut)
+	l.add("flaky.example:443", TCPFailureReset)
+
+	failures := l.list()
+	require.Len(t, failures, 2)
+	require.Equal(t, "blocked.example:443", failures[0].Endpoint, "the most failing endpoint must be first")
+	require.Equal(t, PatternTLSFiltering, failures[0].Pattern)
+	require.Equal(t, "flaky.example:443", failures[1].Endpoint)
+	require.Empty(t, failures[1].Pattern, "a single failure is no pattern")
+}
+
+func TestFailureLog_Eviction(t *testing.T) {
+	var l failureLog
+	for i := range maxFailureEndpoints + 1 {
+		l.add(fmt.Sprintf("host%d:443", i), TCPFailureTimedOut)
+	}
+	failures := l.list()
+	require.Len(t, failures, maxFailureEndpoints)
+	for _, f := range failures {
+		require.NotEqual(t, "host0:443", f.Endpoint, "the oldest endpoint must be evicted")
+	}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	kind, ok := classifyDialError(context.DeadlineExceeded)
+	require.True(t, ok)
+	require.Equal(t, TCPFailureTimedOut, kind)
+
+	_, ok = classifyDialError(io.ErrUnexpectedEOF)
+	require.False(t, ok)
+}
+
+func TestFailedEndpoint(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+	endpoint, ok := failedEndpoint(fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Net: "tcp", Addr: addr, Err: context.DeadlineExceeded}))
+	require.True(t, ok)
+	require.Equal(t, "192.0.2.1:443", endpoint)
+
+	_, ok = failedEndpoint(context.DeadlineExceeded)
+	require.False(t, ok, "an error without its socket can't be attributed")
+}
+
+func TestSession_TCPFailuresNil(t *testing.T) {
+	var s *Session
+	require.Empty(t, s.TCPFailures())
+}
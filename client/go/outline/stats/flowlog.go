@@ -0,0 +1,80 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// flowLogSize is the number of most recent flows kept in a [Session].
+const flowLogSize = 256
+
+// Flow describes a single connection (TCP) or session (UDP) relayed by the tunnel.
+type Flow struct {
+	// Destination is the host:port the flow was connecting to. For UDP, it is the destination
+	// of the first packet.
+	Destination string `json:"destination"`
+
+	// Protocol is either "tcp" or "udp".
+	Protocol string `json:"protocol"`
+
+	TxBytes    int64     `json:"txBytes"`
+	RxBytes    int64     `json:"rxBytes"`
+	StartTime  time.Time `json:"startTime"`
+	DurationMs int64     `json:"durationMs"`
+
+	// CloseReason is the first error the flow encountered, or empty if it was closed normally.
+	CloseReason string `json:"closeReason,omitempty"`
+}
+
+// flowLog is a fixed size ring buffer of the most recent flows.
+// The zero value is an empty log ready to use.
+type flowLog struct {
+	mu    sync.Mutex
+	flows [flowLogSize]Flow
+	next  int
+	full  bool
+}
+
+// add appends f to the log, overwriting the oldest flow if the log is full.
+func (l *flowLog) add(f Flow) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flows[l.next] = f
+	l.next = (l.next + 1) % flowLogSize
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the flows in the log, from the oldest to the newest.
+func (l *flowLog) list() []Flow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]Flow{}, l.flows[:l.next]...)
+	}
+	return append(append(make([]Flow, 0, flowLogSize), l.flows[l.next:]...), l.flows[:l.next]...)
+}
+
+// Flows returns the most recent flows of s that have been closed, from the oldest to the newest.
+// A nil session has no flows.
+func (s *Session) Flows() []Flow {
+	if s == nil {
+		return []Flow{}
+	}
+	return s.flows.list()
+}
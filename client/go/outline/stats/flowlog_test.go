@@ -0,0 +1,76 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowLog_Wraparound(t *testing.T) {
+	var l flowLog
+	require.Empty(t, l.list())
+	require.NotNil(t, l.list())
+
+	for i := 0; i < flowLogSize+10; i++ {
+		l.add(Flow{Destination: fmt.Sprint(i)})
+	}
+	flows := l.list()
+	require.Len(t, flows, flowLogSize)
+	require.Equal(t, "10", flows[0].Destination)
+	require.Equal(t, fmt.Sprint(flowLogSize+9), flows[flowLogSize-1].Destination)
+}
+
+func TestSession_Flows(t *testing.T) {
+	s := &Session{}
+	conn, err := s.PacketListener(&transport.UDPListener{Address: "127.0.0.1:0"}).ListenPacket(context.Background())
+	require.NoError(t, err)
+	_, err = conn.WriteTo([]byte("ping"), conn.LocalAddr())
+	require.NoError(t, err)
+	_, _, err = conn.ReadFrom(make([]byte, 16))
+	require.NoError(t, err)
+	conn.Close()
+	conn.Close()
+
+	dialErr := errors.New("dial failed")
+	failing := transport.FuncStreamDialer(func(context.Context, string) (transport.StreamConn, error) {
+		return nil, dialErr
+	})
+	_, err = s.StreamDialer(failing).DialStream(context.Background(), "example.com:443")
+	require.ErrorIs(t, err, dialErr)
+
+	flows := s.Flows()
+	require.Len(t, flows, 2)
+	require.Equal(t, "udp", flows[0].Protocol)
+	require.Equal(t, conn.LocalAddr().(*net.UDPAddr).String(), flows[0].Destination)
+	require.Equal(t, int64(4), flows[0].TxBytes)
+	require.Equal(t, int64(4), flows[0].RxBytes)
+	require.Empty(t, flows[0].CloseReason)
+	require.Equal(t, Flow{
+		Destination: "example.com:443",
+		Protocol:    "tcp",
+		StartTime:   flows[1].StartTime,
+		DurationMs:  flows[1].DurationMs,
+		CloseReason: "dial failed",
+	}, flows[1])
+
+	require.Empty(t, (*Session)(nil).Flows())
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// QuotaThresholds are the percentages of the data allowance at which a [Quota] warns.
+var QuotaThresholds = []int{80, 100}
+
+// Quota tracks the traffic of a transport against the data allowance of its provider, and calls
+// its callback once when the usage reaches each of the [QuotaThresholds].
+type Quota struct {
+	allowed int64
+	resetAt time.Time
+
+	used atomic.Int64
+	// next is the index of the next threshold to reach.
+	next atomic.Int32
+
+	onThreshold func(QuotaUsage)
+}
+
+// QuotaUsage is the usage of a [Quota].
+type QuotaUsage struct {
+	// BytesAllowed is the data allowance of the period.
+	BytesAllowed int64 `json:"bytesAllowed"`
+
+	// BytesUsed is the usage reported by the provider, plus the traffic relayed since.
+	BytesUsed int64 `json:"bytesUsed"`
+
+	// Percent is the threshold of [QuotaThresholds] the usage reached.
+	Percent int `json:"percent"`
+
+	// ResetTime is when the allowance is renewed, if the provider set it.
+	ResetTime *time.Time `json:"resetTime,omitempty"`
+}
+
+// NewQuota creates a [Quota] of allowed bytes, of which used were already used, until resetAt,
+// which can be zero. The usage reported by the provider is outdated once resetAt has passed, so
+// the count starts over from zero. onThreshold is called from the goroutine relaying the traffic
+// and must not block.
+func NewQuota(allowed, used int64, resetAt time.Time, onThreshold func(QuotaUsage)) *Quota {
+	q := &Quota{allowed: allowed, resetAt: resetAt, onThreshold: onThreshold}
+	if resetAt.IsZero() || time.Now().Before(resetAt) {
+		q.used.Store(used)
+	}
+	return q
+}
+
+// Usage returns the current usage of q. Percent is the last threshold reached, or 0.
+func (q *Quota) Usage() QuotaUsage {
+	usage := QuotaUsage{BytesAllowed: q.allowed, BytesUsed: q.used.Load()}
+	if next := int(q.next.Load()); next > 0 {
+		usage.Percent = QuotaThresholds[next-1]
+	}
+	if !q.resetAt.IsZero() {
+		resetAt := q.resetAt
+		usage.ResetTime = &resetAt
+	}
+	return usage
+}
+
+// add counts n more bytes, and calls the callback for the thresholds the usage reached.
+func (q *Quota) add(n int64) {
+	used := q.used.Add(n)
+	for {
+		next := q.next.Load()
+		if int(next) >= len(QuotaThresholds) || used*100 < q.allowed*int64(QuotaThresholds[next]) {
+			return
+		}
+		if q.next.CompareAndSwap(next, next+1) {
+			usage := q.Usage()
+			usage.BytesUsed = used
+			usage.Percent = QuotaThresholds[next]
+			q.onThreshold(usage)
+		}
+	}
+}
+
+// StreamDialer returns a [transport.StreamDialer] counting the traffic of sd in q.
+func (q *Quota) StreamDialer(sd transport.StreamDialer) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		conn, err := sd.DialStream(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &quotaStreamConn{StreamConn: conn, q: q}, nil
+	})
+}
+
+// PacketListener returns a [transport.PacketListener] counting the traffic of pl in q.
+func (q *Quota) PacketListener(pl transport.PacketListener) transport.PacketListener {
+	return &quotaPacketListener{pl: pl, q: q}
+}
+
+type quotaPacketListener struct {
+	pl transport.PacketListener
+	q  *Quota
+}
+
+func (l *quotaPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.pl.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaPacketConn{PacketConn: conn, q: l.q}, nil
+}
+
+type quotaStreamConn struct {
+	transport.StreamConn
+	q *Quota
+}
+
+func (c *quotaStreamConn) Read(b []byte) (int, error) {
+	n, err := c.StreamConn.Read(b)
+	c.q.add(int64(n))
+	return n, err
+}
+
+func (c *quotaStreamConn) Write(b []byte) (int, error) {
+	n, err := c.StreamConn.Write(b)
+	c.q.add(int64(n))
+	return n, err
+}
+
+// NetConn returns the wrapped connection, for the relays splicing sockets.
+func (c *quotaStreamConn) NetConn() net.Conn { return c.StreamConn }
+
+// CountRead counts n bytes read from the wrapped connection.
+func (c *quotaStreamConn) CountRead(n int64) { c.q.add(n) }
+
+// CountWritten counts n bytes written to the wrapped connection.
+func (c *quotaStreamConn) CountWritten(n int64) { c.q.add(n) }
+
+type quotaPacketConn struct {
+	net.PacketConn
+	q *Quota
+}
+
+func (c *quotaPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.q.add(int64(n))
+	return n, addr, err
+}
+
+func (c *quotaPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.q.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota_Thresholds(t *testing.T) {
+	var warnings []QuotaUsage
+	q := NewQuota(100, 70, time.Time{}, func(usage QuotaUsage) { warnings = append(warnings, usage) })
+	q.add(5)
+	require.Empty(t, warnings)
+	q.add(5)
+	require.Equal(t, []QuotaUsage{{BytesAllowed: 100, BytesUsed: 80, Percent: 80}}, warnings)
+	q.add(5)
+	require.Len(t, warnings, 1)
+	// A single jump crosses the last threshold once.
+	q.add(50)
+	require.Len(t, warnings, 2)
+	require.Equal(t, QuotaUsage{BytesAllowed: 100, BytesUsed: 135, Percent: 100}, warnings[1])
+	q.add(50)
+	require.Len(t, warnings, 2)
+	require.Equal(t, 100, q.Usage().Percent)
+}
+
+func TestQuota_ResetPassed(t *testing.T) {
+	resetAt := time.Now().Add(-time.Hour)
+	q := NewQuota(100, 90, resetAt, func(QuotaUsage) {})
+	usage := q.Usage()
+	require.Equal(t, int64(0), usage.BytesUsed)
+	require.Equal(t, resetAt, *usage.ResetTime)
+
+	q = NewQuota(100, 90, time.Now().Add(time.Hour), func(QuotaUsage) {})
+	require.Equal(t, int64(90), q.Usage().BytesUsed)
+}
+
+func TestQuota_PacketListener(t *testing.T) {
+	var warnings []QuotaUsage
+	q := NewQuota(10, 0, time.Time{}, func(usage QuotaUsage) { warnings = append(warnings, usage) })
+	conn, err := q.PacketListener(&transport.UDPListener{Address: "127.0.0.1:0"}).ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.WriteTo([]byte("ping"), conn.LocalAddr())
+	require.NoError(t, err)
+	_, _, err = conn.ReadFrom(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), q.Usage().BytesUsed)
+	require.Equal(t, []QuotaUsage{{BytesAllowed: 10, BytesUsed: 8, Percent: 80}}, warnings)
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stats collects traffic statistics of the active tunnel session.
+//
+// Tunnels wrap the [transport.StreamDialer] and [transport.PacketListener] they relay traffic
+// through with a [Session], and the statistics can be read from [Current].
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/nat"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// Session counts the traffic relayed by a tunnel.
+type Session struct {
+	start time.Time
+
+	txBytes, rxBytes atomic.Int64
+	tcpConns         atomic.Int64
+	udpSessions      atomic.Int64
+	udpEvictions     atomic.Int64
+	maxUDPSessions   atomic.Int64
+	mtu              atomic.Int64
+
+	flows    flowLog
+	failures failureLog
+	dnsCache dnsCacheSource
+}
+
+// Snapshot is a point-in-time copy of the statistics of a [Session].
+type Snapshot struct {
+	// TxBytes is the number of bytes sent to the proxy.
+	TxBytes int64 `json:"txBytes"`
+
+	// RxBytes is the number of bytes received from the proxy.
+	RxBytes int64 `json:"rxBytes"`
+
+	// ActiveTCPConns is the number of open TCP connections.
+	ActiveTCPConns int64 `json:"activeTcpConns"`
+
+	// ActiveUDPSessions is the number of open UDP sessions.
+	ActiveUDPSessions int64 `json:"activeUdpSessions"`
+
+	// MaxUDPSessions is the size of the UDP NAT table, if the tunnel has one.
+	MaxUDPSessions int64 `json:"maxUdpSessions,omitempty"`
+
+	// EvictedUDPSessions is the number of UDP sessions closed to make room in the full NAT table.
+	EvictedUDPSessions int64 `json:"evictedUdpSessions"`
+
+	// DurationMs is how long the session has been running, in milliseconds.
+	DurationMs int64 `json:"durationMs"`
+
+	// MTU is the MTU of the TUN device, if the tunnel has set it. The TCP segments are clamped
+	// to fit in it.
+	MTU int64 `json:"mtu,omitempty"`
+}
+
+// The session of the active tunnel.
+var mu sync.Mutex
+var current *Session
+
+// StartSession creates a new [Session] and makes it the current one.
+func StartSession() *Session {
+	s := &Session{start: time.Now()}
+	mu.Lock()
+	defer mu.Unlock()
+	current = s
+	return s
+}
+
+// EndSession clears the current session if it is s.
+func EndSession(s *Session) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == s {
+		current = nil
+	}
+}
+
+// Current returns the current [Session], or nil if there is no active tunnel.
+func Current() *Session {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// Snapshot returns the current statistics of s. A nil session has zero statistics.
+func (s *Session) Snapshot() Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	return Snapshot{
+		TxBytes:            s.txBytes.Load(),
+		RxBytes:            s.rxBytes.Load(),
+		ActiveTCPConns:     s.tcpConns.Load(),
+		ActiveUDPSessions:  s.udpSessions.Load(),
+		MaxUDPSessions:     s.maxUDPSessions.Load(),
+		EvictedUDPSessions: s.udpEvictions.Load(),
+		DurationMs:         time.Since(s.start).Milliseconds(),
+		MTU:                s.mtu.Load(),
+	}
+}
+
+// NewNATTable creates the UDP NAT table of the tunnel of s, counting its evictions. See
+// [nat.NewTable] for maxSessions.
+func (s *Session) NewNATTable(maxSessions int) *nat.Table {
+	table := nat.NewTable(maxSessions, func() { s.udpEvictions.Add(1) })
+	s.maxUDPSessions.Store(int64(table.MaxSessions()))
+	return table
+}
+
+// SetMTU records the MTU of the TUN device of s.
+func (s *Session) SetMTU(mtu int) {
+	s.mtu.Store(int64(mtu))
+}
+
+// StreamDialer returns a [transport.StreamDialer] counting the traffic of sd in s.
+func (s *Session) StreamDialer(sd transport.StreamDialer) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		start := time.Now()
+		conn, err := sd.DialStream(ctx, addr)
+		if err != nil {
+			s.flows.add(Flow{
+				Destination: addr,
+				Protocol:    "tcp",
+				StartTime:   start,
+				DurationMs:  time.Since(start).Milliseconds(),
+				CloseReason: err.Error(),
+			})
+			// The failures are attributed to the socket that failed, e.g. to the proxy server,
+			// not to addr. The ones that don't tell their socket aren't counted.
+			if kind, ok := classifyDialError(err); ok {
+				if endpoint, ok := failedEndpoint(err); ok {
+					s.failures.add(endpoint, kind)
+				}
+			}
+			return nil, err
+		}
+		s.tcpConns.Add(1)
+		return &streamConn{StreamConn: conn, s: s, flow: newFlowCounter(addr, start)}, nil
+	})
+}
+
+// PacketListener returns a [transport.PacketListener] counting the traffic of pl in s.
+func (s *Session) PacketListener(pl transport.PacketListener) transport.PacketListener {
+	return &packetListener{pl: pl, s: s}
+}
+
+// flowCounter tracks the traffic of a single flow, to be recorded in the flow log once closed.
+type flowCounter struct {
+	dest             atomic.Pointer[string]
+	start            time.Time
+	txBytes, rxBytes atomic.Int64
+
+	errMu sync.Mutex
+	err   error
+}
+
+func newFlowCounter(dest string, start time.Time) *flowCounter {
+	f := &flowCounter{start: start}
+	if dest != "" {
+		f.dest.Store(&dest)
+	}
+	return f
+}
+
+// setErr records err as the close reason, unless it is nil, EOF or another error is recorded.
+func (f *flowCounter) setErr(err error) {
+	if err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+	f.errMu.Lock()
+	defer f.errMu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *flowCounter) toFlow(protocol string) Flow {
+	flow := Flow{
+		Protocol:   protocol,
+		TxBytes:    f.txBytes.Load(),
+		RxBytes:    f.rxBytes.Load(),
+		StartTime:  f.start,
+		DurationMs: time.Since(f.start).Milliseconds(),
+	}
+	if dest := f.dest.Load(); dest != nil {
+		flow.Destination = *dest
+	}
+	f.errMu.Lock()
+	defer f.errMu.Unlock()
+	if f.err != nil {
+		flow.CloseReason = f.err.Error()
+	}
+	return flow
+}
+
+type streamConn struct {
+	transport.StreamConn
+	s      *Session
+	flow   *flowCounter
+	closed atomic.Bool
+
+	// clientHello is whether the first bytes written were a TLS ClientHello.
+	clientHello atomic.Bool
+	// reset is whether a reset of the connection was counted in the failures of s.
+	reset atomic.Bool
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.StreamConn.Read(b)
+	c.CountRead(int64(n))
+	c.flow.setErr(err)
+	c.checkReset(err)
+	return n, err
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	if c.flow.txBytes.Load() == 0 && isClientHello(b) {
+		c.clientHello.Store(true)
+	}
+	n, err := c.StreamConn.Write(b)
+	c.CountWritten(int64(n))
+	c.flow.setErr(err)
+	c.checkReset(err)
+	return n, err
+}
+
+// checkReset counts the first reset of the connection in the failures of the session, as a reset
+// after the ClientHello if nothing was received. The reset is attributed to the peer of the
+// socket, e.g. the proxy server for the relayed connections.
+func (c *streamConn) checkReset(err error) {
+	if err == nil || !errors.Is(err, syscall.ECONNRESET) || !c.reset.CompareAndSwap(false, true) {
+		return
+	}
+	kind := TCPFailureReset
+	if c.clientHello.Load() && c.flow.rxBytes.Load() == 0 {
+		kind = TCPFailureResetAfterClientHello
+	}
+	endpoint, ok := failedEndpoint(err)
+	if remote := c.RemoteAddr(); !ok && remote != nil {
+		endpoint, ok = remote.String(), true
+	}
+	if ok {
+		c.s.failures.add(endpoint, kind)
+	}
+}
+
+// NetConn returns the wrapped connection, for the relays splicing sockets. They report the
+// traffic with CountRead and CountWritten.
+func (c *streamConn) NetConn() net.Conn {
+	return c.StreamConn
+}
+
+// CountRead counts n bytes read from the wrapped connection.
+func (c *streamConn) CountRead(n int64) {
+	c.s.rxBytes.Add(n)
+	c.flow.rxBytes.Add(n)
+}
+
+// CountWritten counts n bytes written to the wrapped connection.
+func (c *streamConn) CountWritten(n int64) {
+	c.s.txBytes.Add(n)
+	c.flow.txBytes.Add(n)
+}
+
+func (c *streamConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.s.tcpConns.Add(-1)
+		c.s.flows.add(c.flow.toFlow("tcp"))
+	}
+	return c.StreamConn.Close()
+}
+
+type packetListener struct {
+	pl transport.PacketListener
+	s  *Session
+}
+
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.pl.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.s.udpSessions.Add(1)
+	return &packetConn{PacketConn: conn, s: l.s, flow: newFlowCounter("", time.Now())}, nil
+}
+
+type packetConn struct {
+	net.PacketConn
+	s      *Session
+	flow   *flowCounter
+	closed atomic.Bool
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.s.rxBytes.Add(int64(n))
+	c.flow.rxBytes.Add(int64(n))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.flow.setErr(err)
+	}
+	return n, addr, err
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.flow.dest.Load() == nil && addr != nil {
+		dest := addr.String()
+		c.flow.dest.CompareAndSwap(nil, &dest)
+	}
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.s.txBytes.Add(int64(n))
+	c.flow.txBytes.Add(int64(n))
+	c.flow.setErr(err)
+	return n, err
+}
+
+func (c *packetConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.s.udpSessions.Add(-1)
+		c.s.flows.add(c.flow.toFlow("udp"))
+	}
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_StreamDialer(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	s := StartSession()
+	defer EndSession(s)
+	require.Same(t, s, Current())
+
+	conn, err := s.StreamDialer(&transport.TCPDialer{}).DialStream(context.Background(), listener.Addr().String())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), s.Snapshot().ActiveTCPConns)
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	conn.Close()
+
+	snapshot := s.Snapshot()
+	require.Equal(t, int64(5), snapshot.TxBytes)
+	require.Equal(t, int64(5), snapshot.RxBytes)
+	require.Equal(t, int64(0), snapshot.ActiveTCPConns)
+}
+
+func TestSession_PacketListener(t *testing.T) {
+	s := &Session{}
+	conn, err := s.PacketListener(&transport.UDPListener{Address: "127.0.0.1:0"}).ListenPacket(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), s.Snapshot().ActiveUDPSessions)
+
+	_, err = conn.WriteTo([]byte("ping"), conn.LocalAddr())
+	require.NoError(t, err)
+	n, _, err := conn.ReadFrom(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	conn.Close()
+	conn.Close()
+
+	snapshot := s.Snapshot()
+	require.Equal(t, int64(4), snapshot.TxBytes)
+	require.Equal(t, int64(4), snapshot.RxBytes)
+	require.Equal(t, int64(0), snapshot.ActiveUDPSessions)
+}
+
+func TestEndSession(t *testing.T) {
+	s1 := StartSession()
+	s2 := StartSession()
+	EndSession(s1)
+	require.Same(t, s2, Current())
+	EndSession(s2)
+	require.Nil(t, Current())
+	require.Equal(t, Snapshot{}, Current().Snapshot())
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/kvstore"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// StorageCipher encrypts the storage of the Go layer at rest, e.g. with a key of the Android
+// Keystore or the macOS Keychain.
+type StorageCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+var storageMu sync.Mutex
+
+// goStorage keeps the cookies and cached resources of the fetches. It's in memory until
+// [SetStorage] is called.
+var goStorage = kvstore.NewMemory()
+
+// SetStorage sets the file the Go layer persists its state to, e.g. in the data directory of the
+// app. The file is encrypted with cipher, unless it is nil. A file that can't be read, e.g. because
+// the key of cipher changed, is replaced with an empty store.
+func SetStorage(path string, cipher StorageCipher) *platerrors.PlatformError {
+	if !filepath.IsAbs(path) {
+		return &platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "storage file must be an absolute path",
+			Details: platerrors.ErrorDetails{"path": path},
+		}
+	}
+	var kvCipher kvstore.Cipher
+	if cipher != nil {
+		kvCipher = cipher
+	}
+	store, err := kvstore.Open(path, kvCipher)
+	if err != nil {
+		logger.Warn("failed to open the storage, starting over", "err", err)
+		store = kvstore.New(path, kvCipher)
+	}
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	goStorage = store
+	return nil
+}
+
+// storage returns the store of the Go layer.
+func storage() *kvstore.Store {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	return goStorage
+}
+
+// setStorageFile sets the unencrypted storage file, for the platforms calling [InvokeMethod]
+// only, see [MethodSetStorageFile].
+func setStorageFile(path string) error {
+	if perr := SetStorage(path, nil); perr != nil {
+		return perr
+	}
+	return nil
+}
+
+// The key prefixes of the storage.
+const (
+	storageCookiesPrefix = "cookies:"
+	storageFetchPrefix   = "fetch:"
+)
+
+// storedCookieJar is an [http.CookieJar] kept in the storage, so that the cookies providers set
+// on their config URLs survive restarts. The cookies are host-only: the Domain attribute is
+// ignored.
+type storedCookieJar struct {
+	mu sync.Mutex
+}
+
+var _ http.CookieJar = (*storedCookieJar)(nil)
+
+var fetchCookieJar = &storedCookieJar{}
+
+// storedCookie is a cookie of a host in the storage.
+type storedCookie struct {
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+	Path    string    `json:"path,omitempty"`
+	Secure  bool      `json:"secure,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (j *storedCookieJar) load(host string) []storedCookie {
+	var cookies []storedCookie
+	if data, ok := storage().Get(storageCookiesPrefix + host); ok {
+		if err := json.Unmarshal(data, &cookies); err != nil {
+			logger.Warn("failed to parse the stored cookies", "err", err)
+		}
+	}
+	return cookies
+}
+
+func (j *storedCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	stored := j.load(host)
+	for _, c := range cookies {
+		sc := storedCookie{Name: c.Name, Value: c.Value, Path: c.Path, Secure: c.Secure, Expires: c.Expires}
+		if sc.Path == "" || !strings.HasPrefix(sc.Path, "/") {
+			sc.Path = "/"
+		}
+		if c.MaxAge > 0 {
+			sc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		remove := c.MaxAge < 0 || (!sc.Expires.IsZero() && !sc.Expires.After(now))
+		kept := stored[:0]
+		for _, old := range stored {
+			if old.Name != sc.Name || old.Path != sc.Path {
+				kept = append(kept, old)
+			}
+		}
+		stored = kept
+		if !remove {
+			stored = append(stored, sc)
+		}
+	}
+	if len(stored) == 0 {
+		storage().Delete(storageCookiesPrefix + host)
+		return
+	}
+	data, err := json.Marshal(stored)
+	if err == nil {
+		err = storage().Set(storageCookiesPrefix+host, data)
+	}
+	if err != nil {
+		logger.Warn("failed to store the cookies", "err", err)
+	}
+}
+
+func (j *storedCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var cookies []*http.Cookie
+	for _, sc := range j.load(strings.ToLower(u.Hostname())) {
+		if !sc.Expires.IsZero() && !sc.Expires.After(now) {
+			continue
+		}
+		if sc.Secure && u.Scheme != "https" {
+			continue
+		}
+		if !pathMatches(path, sc.Path) {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: sc.Name, Value: sc.Value})
+	}
+	return cookies
+}
+
+// pathMatches reports whether the request path is in the cookie path, see RFC 6265 section 5.1.4.
+func pathMatches(path, cookiePath string) bool {
+	if path == cookiePath {
+		return true
+	}
+	return strings.HasPrefix(path, cookiePath) &&
+		(strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/')
+}
+
+// withFetchCache stores the content of a successful fetch of rawURL, or returns the stored content
+// of the last successful fetch if this one failed because the server couldn't be reached.
+func withFetchCache(rawURL, content string, err error) (string, error) {
+	key := storageFetchPrefix + rawURL
+	if err == nil {
+		if err := storage().Set(key, []byte(content)); err != nil {
+			logger.Warn("failed to cache the fetched resource", "err", err)
+		}
+		return content, nil
+	}
+	perr := platerrors.ToPlatformError(err)
+	if perr == nil || (perr.Code != platerrors.FetchConfigFailed && perr.Code != platerrors.ResolveIPFailed) {
+		return "", err
+	}
+	cached, ok := storage().Get(key)
+	if !ok {
+		return "", err
+	}
+	logger.Warn("failed to fetch the resource, using the cached one", "err", err)
+	return string(cached), nil
+}
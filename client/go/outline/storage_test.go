@@ -0,0 +1,119 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/kvstore"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestStorage replaces the storage with a file in a temporary directory for the test.
+func useTestStorage(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	require.Nil(t, SetStorage(path, nil))
+	t.Cleanup(func() {
+		storageMu.Lock()
+		goStorage = kvstore.NewMemory()
+		storageMu.Unlock()
+	})
+	return path
+}
+
+func TestSetStorage(t *testing.T) {
+	perr := SetStorage("storage.json", nil)
+	require.NotNil(t, perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+
+	path := useTestStorage(t)
+	require.NoError(t, storage().Set("key", []byte("value")))
+	require.Nil(t, SetStorage(path, nil))
+	value, ok := storage().Get("key")
+	require.True(t, ok)
+	require.Equal(t, []byte("value"), value)
+}
+
+func TestFetchResource_Cookies(t *testing.T) {
+	path := useTestStorage(t)
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err == nil {
+			got = append(got, c.Value)
+		} else {
+			got = append(got, "")
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		w.Write([]byte("ss://key"))
+	}))
+	defer server.Close()
+
+	_, err := fetchResource(server.URL + "/sub")
+	require.NoError(t, err)
+	// Reload the storage from its file, like after a restart.
+	require.Nil(t, SetStorage(path, nil))
+	_, err = fetchResource(server.URL + "/sub")
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "abc"}, got)
+}
+
+func TestStoredCookieJar(t *testing.T) {
+	useTestStorage(t)
+	u := mustParseURL(t, "https://example.com/a/b")
+	jar := &storedCookieJar{}
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "root", Value: "1"},
+		{Name: "other", Value: "2", Path: "/x"},
+		{Name: "secure", Value: "3", Secure: true},
+	})
+	require.Len(t, jar.Cookies(u), 2)
+	require.Len(t, jar.Cookies(mustParseURL(t, "http://example.com/")), 1)
+	require.Len(t, jar.Cookies(mustParseURL(t, "https://example.com/x/y")), 3)
+	require.Empty(t, jar.Cookies(mustParseURL(t, "https://other.example/")))
+
+	jar.SetCookies(u, []*http.Cookie{{Name: "root", MaxAge: -1}})
+	require.Len(t, jar.Cookies(u), 1)
+}
+
+func TestFetchResource_CacheFallback(t *testing.T) {
+	useTestStorage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ss://cached"))
+	}))
+	input := `{"url": "` + server.URL + `", "cacheFallback": true}`
+	got := InvokeMethod(MethodFetchResource, input)
+	require.Nil(t, got.Error)
+	server.Close()
+
+	got = InvokeMethod(MethodFetchResource, input)
+	require.Nil(t, got.Error)
+	require.Equal(t, "ss://cached", got.Value)
+
+	// Without the option, the error is returned.
+	got = InvokeMethod(MethodFetchResource, server.URL)
+	require.NotNil(t, got.Error)
+	require.Equal(t, platerrors.FetchConfigFailed, got.Error.Code)
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
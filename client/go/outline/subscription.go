@@ -0,0 +1,85 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// parseSubscription extracts the access keys of a subscription body, and returns them as a JSON
+// array of strings.
+//
+// The body is a list of keys separated by new lines. Many subscription endpoints encode it with
+// base64 or base64url, or percent-encode it, which is detected and decoded.
+func parseSubscription(body string) (string, error) {
+	text, err := decodeSubscription(strings.TrimSpace(body))
+	if err != nil {
+		return "", err
+	}
+	keys := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	if len(keys) == 0 {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "subscription contains no access keys",
+		}
+	}
+	out, err := json.Marshal(keys)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the subscription keys",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// decodeSubscription returns the plain text of an encoded subscription body. A body that already
+// contains keys, like "ss://...", is returned as is.
+func decodeSubscription(body string) (string, error) {
+	if strings.Contains(body, "://") {
+		return body, nil
+	}
+	if strings.Contains(body, "%3A%2F%2F") || strings.Contains(body, "%3a%2f%2f") {
+		text, err := url.QueryUnescape(body)
+		if err != nil {
+			return "", platerrors.PlatformError{
+				Code:    platerrors.IllegalConfig,
+				Message: "subscription is not correctly percent-encoded",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+		return text, nil
+	}
+	// Encoders often wrap the base64 text in lines.
+	decoded, err := decodeBase64(strings.Join(strings.Fields(body), ""))
+	if err != nil || !strings.Contains(string(decoded), "://") {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "subscription is neither a list of access keys nor base64-encoded",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(decoded), nil
+}
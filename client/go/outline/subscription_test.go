@@ -0,0 +1,65 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscription(t *testing.T) {
+	const keys = "ss://YWVzLTEyOC1nY206cHc@example.com:443/#Server%201\r\n\n" +
+		"# comment\n" +
+		"ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%3D%3D@example.com:443/?prefix=%16%03%01\n"
+	want := []string{
+		"ss://YWVzLTEyOC1nY206cHc@example.com:443/#Server%201",
+		"ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%3D%3D@example.com:443/?prefix=%16%03%01",
+	}
+	wrapped := base64.StdEncoding.EncodeToString([]byte(keys))
+	wrapped = wrapped[:40] + "\n" + wrapped[40:]
+
+	tests := map[string]string{
+		"plain":     keys,
+		"base64":    base64.StdEncoding.EncodeToString([]byte(keys)),
+		"base64url": base64.RawURLEncoding.EncodeToString([]byte(keys)),
+		"wrapped":   wrapped,
+		"percent":   url.QueryEscape(keys),
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := parseSubscription(body)
+			require.NoError(t, err)
+			var got []string
+			require.NoError(t, json.Unmarshal([]byte(out), &got))
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestParseSubscription_Errors(t *testing.T) {
+	for _, body := range []string{
+		"",
+		"not a subscription",
+		base64.StdEncoding.EncodeToString([]byte("no keys here")),
+		"# only comments\n",
+	} {
+		_, err := parseSubscription(body)
+		require.Error(t, err, body)
+	}
+}
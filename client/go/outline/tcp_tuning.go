@@ -0,0 +1,106 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"net"
+	"regexp"
+	"sync"
+	"syscall"
+)
+
+const (
+	minTCPSocketBuffer = 4 * 1024
+	maxTCPSocketBuffer = 16 * 1024 * 1024
+)
+
+// congestionControlPattern matches the names of the congestion control algorithms of the kernel,
+// which are shorter than 16 characters (TCP_CA_NAME_MAX).
+var congestionControlPattern = regexp.MustCompile(`^[a-z0-9_]{1,15}$`)
+
+// proxyTCPTuningConfigJSON is the "proxyTcpTuning" section of a transport config. It tunes the TCP
+// sockets of the device to the proxy server, or to its first hop, whose defaults underperform on
+// links with a large bandwidth-delay product. The sockets of the connections routed directly
+// aren't tuned, and neither is the TCP stack of the TUN device, lwIP, whose window is fixed at
+// build time: its connections are local to the device.
+type proxyTCPTuningConfigJSON struct {
+	// CongestionControl is the congestion control algorithm of the kernel, like "bbr" or "cubic".
+	// It's only available on Linux and Android, where the algorithm must be in
+	// net.ipv4.tcp_allowed_congestion_control, or the default one is kept.
+	CongestionControl string `json:"congestionControl,omitempty"`
+
+	// SendBufferBytes and ReceiveBufferBytes are the sizes of the socket buffers, within range
+	// [4096..16777216]. The kernel tunes them by default, and stops tuning the ones that are set.
+	// The receive buffer bounds the window advertised to the server.
+	SendBufferBytes    int `json:"sendBufferBytes,omitempty"`
+	ReceiveBufferBytes int `json:"receiveBufferBytes,omitempty"`
+}
+
+// validate checks the config.
+func (c *proxyTCPTuningConfigJSON) validate() error {
+	if c.CongestionControl != "" && !congestionControlPattern.MatchString(c.CongestionControl) {
+		return newIllegalConfigErrorWithDetails("congestion control is not valid",
+			"proxyTcpTuning.congestionControl", c.CongestionControl, `the name of an algorithm of the kernel, like "bbr"`, nil)
+	}
+	for _, f := range []struct {
+		name  string
+		bytes int
+	}{
+		{"proxyTcpTuning.sendBufferBytes", c.SendBufferBytes},
+		{"proxyTcpTuning.receiveBufferBytes", c.ReceiveBufferBytes},
+	} {
+		if f.bytes != 0 && (f.bytes < minTCPSocketBuffer || f.bytes > maxTCPSocketBuffer) {
+			return newIllegalConfigErrorWithDetails("socket buffer size is not valid",
+				f.name, f.bytes, "a number of bytes within range [4096..16777216]", nil)
+		}
+	}
+	return nil
+}
+
+// applyToDialer makes d tune its sockets, before its own Control function, so that the buffers
+// are set before the connection and the window scale is negotiated accordingly. The options the
+// platform rejects are skipped, with a warning for the first socket. c may be nil.
+func (c *proxyTCPTuningConfigJSON) applyToDialer(d *net.Dialer) {
+	if c == nil || *c == (proxyTCPTuningConfigJSON{}) {
+		return
+	}
+	control := d.Control
+	var warnOnce sync.Once
+	d.Control = func(network, address string, rc syscall.RawConn) error {
+		var errs []error
+		if err := rc.Control(func(fd uintptr) {
+			if c.CongestionControl != "" {
+				errs = append(errs, setCongestionControl(fd, c.CongestionControl))
+			}
+			if c.SendBufferBytes > 0 {
+				errs = append(errs, setSocketBuffer(fd, syscall.SO_SNDBUF, c.SendBufferBytes))
+			}
+			if c.ReceiveBufferBytes > 0 {
+				errs = append(errs, setSocketBuffer(fd, syscall.SO_RCVBUF, c.ReceiveBufferBytes))
+			}
+		}); err != nil {
+			return err
+		}
+		for _, err := range errs {
+			if err != nil {
+				warnOnce.Do(func() { logger.Warn("failed to tune a TCP socket", "err", err) })
+			}
+		}
+		if control != nil {
+			return control(network, address, rc)
+		}
+		return nil
+	}
+}
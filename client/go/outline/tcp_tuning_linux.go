@@ -0,0 +1,29 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setCongestionControl sets the congestion control algorithm of the socket fd.
+func setCongestionControl(fd uintptr, algorithm string) error {
+	if err := unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, algorithm); err != nil {
+		return fmt.Errorf("congestion control %q is not available: %w", algorithm, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestProxyTCPTuningConfig_ApplyToDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// reno is always allowed. The unavailable algorithms don't fail the dials.
+	for _, algorithm := range []string{"reno", "unknown"} {
+		var d net.Dialer
+		(&proxyTCPTuningConfigJSON{CongestionControl: algorithm, ReceiveBufferBytes: 256 * 1024}).applyToDialer(&d)
+		conn, err := d.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		require.NoError(t, err)
+		var got string
+		var rcvbuf int
+		require.NoError(t, raw.Control(func(fd uintptr) {
+			got, _ = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+			rcvbuf, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		}))
+		if algorithm == "reno" {
+			require.Equal(t, "reno", got)
+		} else {
+			require.NotEqual(t, algorithm, got)
+		}
+		// Linux doubles the buffer sizes for its bookkeeping.
+		require.Equal(t, 2*256*1024, rcvbuf)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package outline
+
+import "syscall"
+
+// setSocketBuffer sets the size of the SO_SNDBUF or SO_RCVBUF buffer of the socket fd.
+func setSocketBuffer(fd uintptr, opt, bytes int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, opt, bytes)
+}
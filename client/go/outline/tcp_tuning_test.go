@@ -0,0 +1,40 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyTCPTuningConfig(t *testing.T) {
+	for _, tuning := range []string{
+		`{"congestionControl":"BBR"}`,
+		`{"congestionControl":"a_very_long_algorithm"}`,
+		`{"sendBufferBytes":1024}`,
+		`{"receiveBufferBytes":-1}`,
+		`{"receiveBufferBytes":33554432}`,
+	} {
+		config := `{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234","proxyTcpTuning":` + tuning + `}`
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+	got := NewClient(`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+		`"proxyTcpTuning":{"congestionControl":"bbr","sendBufferBytes":4194304,"receiveBufferBytes":4194304}}`)
+	require.Nil(t, got.Error)
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+const (
+	defaultDialTimeout    = 30 * time.Second
+	defaultUDPIdleTimeout = 30 * time.Second
+)
+
+// timeoutsConfigJSON is the "timeouts" section of a transport config. It allows longer timeouts on
+// high-latency links, e.g. satellite or congested mobile networks.
+type timeoutsConfigJSON struct {
+	// DialSeconds limits how long establishing a TCP connection takes, to each address of the
+	// proxy server or of a direct destination. Defaults to 30 seconds.
+	DialSeconds int `json:"dialSeconds,omitempty"`
+
+	// HandshakeSeconds limits how long connecting through the proxy takes as a whole, including
+	// resolving the server host name and the transport handshake. There is no limit by default,
+	// other than DialSeconds.
+	HandshakeSeconds int `json:"handshakeSeconds,omitempty"`
+
+	// UDPIdleSeconds is how long a UDP session is kept without outgoing traffic, before its NAT
+	// mapping is removed. Defaults to 30 seconds.
+	UDPIdleSeconds int `json:"udpIdleSeconds,omitempty"`
+
+	// KeepAliveSeconds enables TCP keep-alives to the proxy server with this interval. They are
+	// disabled by default, see RFC 1122 section 4.2.3.6.
+	KeepAliveSeconds int `json:"keepAliveSeconds,omitempty"`
+}
+
+// timeouts holds the validated timeouts of a config, with the defaults applied.
+type timeouts struct {
+	dial, handshake, udpIdle, keepAlive time.Duration
+}
+
+// timeouts validates the "timeouts" section of the config, and returns it with the defaults
+// applied.
+func (conf *configJSON) timeouts() (timeouts, error) {
+	t := timeouts{dial: defaultDialTimeout, udpIdle: defaultUDPIdleTimeout}
+	if conf.Timeouts == nil {
+		return t, nil
+	}
+	fields := []struct {
+		name    string
+		seconds int
+		value   *time.Duration
+	}{
+		{"timeouts.dialSeconds", conf.Timeouts.DialSeconds, &t.dial},
+		{"timeouts.handshakeSeconds", conf.Timeouts.HandshakeSeconds, &t.handshake},
+		{"timeouts.udpIdleSeconds", conf.Timeouts.UDPIdleSeconds, &t.udpIdle},
+		{"timeouts.keepAliveSeconds", conf.Timeouts.KeepAliveSeconds, &t.keepAlive},
+	}
+	for _, f := range fields {
+		if f.seconds < 0 {
+			return timeouts{}, newIllegalConfigErrorWithDetails("timeout is not valid",
+				f.name, f.seconds, "a positive number of seconds", nil)
+		}
+		if f.seconds > 0 {
+			*f.value = time.Duration(f.seconds) * time.Second
+		}
+	}
+	return t, nil
+}
+
+// applyToDialers sets the dial timeout of the base dialers, and the keep-alive of the TCP one.
+func (t timeouts) applyToDialers(tcpDialer, udpDialer *net.Dialer) {
+	tcpDialer.Timeout = t.dial
+	udpDialer.Timeout = t.dial
+	if t.keepAlive > 0 {
+		tcpDialer.KeepAlive = t.keepAlive
+	}
+}
+
+// handshakeTimeoutDialer is a [transport.StreamDialer] that fails if dialing takes longer than
+// timeout.
+type handshakeTimeoutDialer struct {
+	transport.StreamDialer
+	timeout time.Duration
+}
+
+// withHandshakeTimeout wraps sd in a [handshakeTimeoutDialer], if the timeout is set.
+func (t timeouts) withHandshakeTimeout(sd transport.StreamDialer) transport.StreamDialer {
+	if t.handshake <= 0 {
+		return sd
+	}
+	return &handshakeTimeoutDialer{StreamDialer: sd, timeout: t.handshake}
+}
+
+func (d *handshakeTimeoutDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	return d.StreamDialer.DialStream(ctx, addr)
+}
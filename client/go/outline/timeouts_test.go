@@ -0,0 +1,106 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  timeouts
+	}{
+		{
+			name:  "defaults",
+			input: `{}`,
+			want:  timeouts{dial: defaultDialTimeout, udpIdle: defaultUDPIdleTimeout},
+		},
+		{
+			name:  "all",
+			input: `{"timeouts": {"dialSeconds": 60, "handshakeSeconds": 90, "udpIdleSeconds": 120, "keepAliveSeconds": 15}}`,
+			want:  timeouts{dial: 60 * time.Second, handshake: 90 * time.Second, udpIdle: 120 * time.Second, keepAlive: 15 * time.Second},
+		},
+		{
+			name:  "partial",
+			input: `{"timeouts": {"udpIdleSeconds": 300}}`,
+			want:  timeouts{dial: defaultDialTimeout, udpIdle: 300 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := parseConfigFromJSON(tt.input)
+			require.NoError(t, err)
+			got, err := conf.timeouts()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfigTimeouts_Negative(t *testing.T) {
+	conf, err := parseConfigFromJSON(`{"timeouts": {"dialSeconds": -1}}`)
+	require.NoError(t, err)
+	_, err = conf.timeouts()
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+	require.Equal(t, "timeouts.dialSeconds", perr.Details["field"])
+}
+
+func TestNewClient_Timeouts(t *testing.T) {
+	client, err := newClientWithBaseDialers(
+		`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234",`+
+			`"timeouts":{"handshakeSeconds":5,"udpIdleSeconds":120}}`,
+		net.Dialer{KeepAlive: -1}, net.Dialer{})
+	require.NoError(t, err)
+	require.Equal(t, 120*time.Second, client.UDPIdleTimeout)
+
+	client, err = newClientWithBaseDialers(
+		`{"host":"192.0.2.1","port":12345,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`,
+		net.Dialer{KeepAlive: -1}, net.Dialer{})
+	require.NoError(t, err)
+	require.Equal(t, defaultUDPIdleTimeout, client.UDPIdleTimeout)
+}
+
+func TestTimeouts_ApplyToDialers(t *testing.T) {
+	tcp, udp := net.Dialer{KeepAlive: -1}, net.Dialer{}
+	timeouts{dial: time.Minute}.applyToDialers(&tcp, &udp)
+	require.Equal(t, net.Dialer{Timeout: time.Minute, KeepAlive: -1}, tcp)
+	require.Equal(t, net.Dialer{Timeout: time.Minute}, udp)
+
+	timeouts{dial: time.Minute, keepAlive: 15 * time.Second}.applyToDialers(&tcp, &udp)
+	require.Equal(t, 15*time.Second, tcp.KeepAlive)
+}
+
+func TestHandshakeTimeoutDialer(t *testing.T) {
+	blocking := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.IsType(t, blocking, timeouts{}.withHandshakeTimeout(blocking))
+
+	sd := timeouts{handshake: 10 * time.Millisecond}.withHandshakeTimeout(blocking)
+	_, err := sd.DialStream(context.Background(), "example.com:443")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
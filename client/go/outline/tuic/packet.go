@@ -0,0 +1,372 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/quic-go/quic-go"
+)
+
+// packetHeaderLen is the size of the header of the packet commands, before their address.
+const packetHeaderLen = 2 + 2 + 2 + 1 + 1 + 2
+
+// maxPacketCommandLen is the size of the largest packet command: a header, a domain name address
+// and a payload of the largest UDP packet.
+const maxPacketCommandLen = packetHeaderLen + 1 + 1 + 255 + 2 + 65535
+
+// maxFragments is the number of fragments a packet too large for a datagram can be split into.
+const maxFragments = 255
+
+// packetQueueSize is the number of received packets a packet conn buffers before dropping them.
+const packetQueueSize = 128
+
+// ListenPacket creates a UDP association relaying packets to any destination. The packets are
+// relayed with the packet command, as datagrams or over streams depending on the relay mode:
+//
+//	[header][uint16 association ID][uint16 packet ID][uint8 fragment count][uint8 fragment ID]
+//	[uint16 fragment size][address][fragment]
+func (c *Client) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc := &packetConn{conn: conn, packets: make(chan packet, packetQueueSize), closed: make(chan struct{})}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.packetConns == nil {
+		// The connection is closed.
+		return nil, net.ErrClosed
+	}
+	for i := 0; ; i++ {
+		if i > 0xffff {
+			return nil, errors.New("too many TUIC UDP associations")
+		}
+		conn.nextAssocID++
+		if _, ok := conn.packetConns[conn.nextAssocID]; !ok {
+			break
+		}
+	}
+	pc.id = conn.nextAssocID
+	conn.packetConns[pc.id] = pc
+	conn.relays.Add(1)
+	return pc, nil
+}
+
+// packetCommand is a fragment of a packet.
+type packetCommand struct {
+	assocID    uint16
+	packetID   uint16
+	fragments  uint8
+	fragmentID uint8
+	// addr is nil in the fragments but the first.
+	addr    net.Addr
+	payload []byte
+}
+
+// appendPacketCommand appends the command of a fragment of a packet to b.
+func appendPacketCommand(b []byte, cmd *packetCommand) ([]byte, error) {
+	b = append(b, version, cmdPacket)
+	b = binary.BigEndian.AppendUint16(b, cmd.assocID)
+	b = binary.BigEndian.AppendUint16(b, cmd.packetID)
+	b = append(b, cmd.fragments, cmd.fragmentID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(cmd.payload)))
+	if cmd.addr == nil {
+		b = append(b, addrTypeNone)
+	} else {
+		var err error
+		if b, err = addrFormat.AppendAddr(b, cmd.addr); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, cmd.payload...), nil
+}
+
+// parsePacketCommand parses a packet command, whose payload it refers to.
+func parsePacketCommand(b []byte) (*packetCommand, error) {
+	if len(b) < packetHeaderLen+1 || b[0] != version || b[1] != cmdPacket {
+		return nil, errors.New("TUIC packet command is not valid")
+	}
+	cmd := &packetCommand{
+		assocID:    binary.BigEndian.Uint16(b[2:]),
+		packetID:   binary.BigEndian.Uint16(b[4:]),
+		fragments:  b[6],
+		fragmentID: b[7],
+	}
+	size := int(binary.BigEndian.Uint16(b[8:]))
+	// The fragments of a packet but the first have no address.
+	n := 1
+	if b[packetHeaderLen] != addrTypeNone {
+		var err error
+		if cmd.addr, n, err = addrFormat.Parse(b[packetHeaderLen:]); err != nil {
+			return nil, err
+		}
+	}
+	if b = b[packetHeaderLen+n:]; len(b) != size {
+		return nil, fmt.Errorf("TUIC packet of %d bytes has %d bytes", size, len(b))
+	}
+	cmd.payload = b
+	return cmd, nil
+}
+
+// receiveDatagrams handles the commands of the datagrams of the connection, until it's closed.
+func (c *conn) receiveDatagrams() {
+	defer c.closePacketConns()
+	for {
+		b, err := c.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		c.handleCommand(b)
+	}
+}
+
+// receiveUniStreams handles the commands of the unidirectional streams of the connection, which
+// carry the packets in the "quic" relay mode, until it's closed.
+func (c *conn) receiveUniStreams() {
+	for {
+		str, err := c.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		resources.Go(resources.SubsystemTUIC, func() {
+			b, err := io.ReadAll(io.LimitReader(str, maxPacketCommandLen))
+			if err != nil {
+				str.CancelRead(0)
+				return
+			}
+			c.handleCommand(b)
+		})
+	}
+}
+
+// handleCommand dispatches a packet command to the packet conn of its association. The server
+// sends no other command.
+func (c *conn) handleCommand(b []byte) {
+	cmd, err := parsePacketCommand(b)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	pc := c.packetConns[cmd.assocID]
+	c.mu.Unlock()
+	if pc != nil {
+		pc.receive(cmd)
+	}
+}
+
+// closePacketConns ends the packet conns of the closed connection.
+func (c *conn) closePacketConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pc := range c.packetConns {
+		pc.closeOnce.Do(func() { close(pc.closed) })
+	}
+	c.packetConns = nil
+}
+
+type packet struct {
+	payload []byte
+	addr    net.Addr
+}
+
+// packetConn is a UDP association of a connection.
+type packetConn struct {
+	conn         *conn
+	id           uint16
+	nextPacketID atomic.Uint32
+
+	packets   chan packet
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// fragmentsMu guards the received fragments of the packet with the ID fragmentsID, which
+	// different streams can carry.
+	fragmentsMu sync.Mutex
+	fragmentsID uint16
+	fragments   []*packetCommand
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// receive queues the packet of cmd, once all its fragments are received. The packet is dropped
+// if the queue is full.
+func (c *packetConn) receive(cmd *packetCommand) {
+	p := packet{payload: cmd.payload, addr: cmd.addr}
+	if cmd.fragments > 1 {
+		if p = c.defragment(cmd); p.addr == nil {
+			return
+		}
+	}
+	if p.addr == nil {
+		return
+	}
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+// defragment returns the packet of the fragment cmd, or an empty packet until all its fragments
+// are received. Only the fragments of the latest packet are kept.
+func (c *packetConn) defragment(cmd *packetCommand) packet {
+	c.fragmentsMu.Lock()
+	defer c.fragmentsMu.Unlock()
+	if cmd.fragmentID >= cmd.fragments {
+		return packet{}
+	}
+	if c.fragments == nil || cmd.packetID != c.fragmentsID || len(c.fragments) != int(cmd.fragments) {
+		c.fragmentsID = cmd.packetID
+		c.fragments = make([]*packetCommand, cmd.fragments)
+	}
+	c.fragments[cmd.fragmentID] = cmd
+	var p packet
+	for _, fragment := range c.fragments {
+		if fragment == nil {
+			return packet{}
+		}
+		p.payload = append(p.payload, fragment.payload...)
+	}
+	p.addr = c.fragments[0].addr
+	c.fragments = nil
+	return p
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.packets:
+		return copy(b, p.payload), p.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends the packet b to addr. In the "native" relay mode, the packet is split into
+// fragments if it's too large for a datagram.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	cmd := &packetCommand{
+		assocID:   c.id,
+		packetID:  uint16(c.nextPacketID.Add(1)),
+		fragments: 1,
+		addr:      addr,
+		payload:   b,
+	}
+	msg, err := appendPacketCommand(nil, cmd)
+	if err != nil {
+		return 0, err
+	}
+	if c.conn.relayMode == UDPRelayQUIC {
+		if err := sendUniStream(c.conn, msg); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	err = c.conn.SendDatagram(msg)
+	var tooLarge *quic.DatagramTooLargeError
+	if !errors.As(err, &tooLarge) {
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	maxPayload := int(tooLarge.MaxDatagramPayloadSize) - (len(msg) - len(b))
+	if maxPayload <= 0 || (len(b)+maxPayload-1)/maxPayload > maxFragments {
+		return 0, fmt.Errorf("packet of %d bytes is too large", len(b))
+	}
+	cmd.fragments = uint8((len(b) + maxPayload - 1) / maxPayload)
+	for i := 0; i < int(cmd.fragments); i++ {
+		cmd.fragmentID = uint8(i)
+		cmd.payload = b[i*maxPayload : min(len(b), (i+1)*maxPayload)]
+		if i > 0 {
+			cmd.addr = nil
+		}
+		if msg, err = appendPacketCommand(nil, cmd); err != nil {
+			return 0, err
+		}
+		if err := c.conn.SendDatagram(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close ends the association, telling the server with the dissociate command:
+//
+//	[header][uint16 association ID]
+func (c *packetConn) Close() error {
+	c.conn.mu.Lock()
+	delete(c.conn.packetConns, c.id)
+	c.conn.mu.Unlock()
+	closed := false
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	if !closed {
+		return net.ErrClosed
+	}
+	c.conn.relays.Add(-1)
+	if c.conn.Context().Err() != nil {
+		return nil
+	}
+	return sendUniStream(c.conn, binary.BigEndian.AppendUint16([]byte{version, cmdDissociate}, c.id))
+}
+
+func (c *packetConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, as the packets are sent without blocking.
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tuic implements the client of version 5 of the TUIC protocol, which relays the streams
+// and the packets over a QUIC connection. The client authenticates with a token exported from
+// the TLS session of the connection, so the password never goes over the network.
+//
+// The commands of the protocol start with a header:
+//
+//	[uint8 version 5][uint8 command type]
+package tuic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/quicconn"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/quic-go/quic-go"
+)
+
+const version = 5
+
+// Types of the commands.
+const (
+	cmdAuthenticate = 0x00
+	cmdConnect      = 0x01
+	cmdPacket       = 0x02
+	cmdDissociate   = 0x03
+	cmdHeartbeat    = 0x04
+)
+
+// Address types of the commands.
+const (
+	addrTypeFQDN = 0x00
+	addrTypeIPv4 = 0x01
+	addrTypeIPv6 = 0x02
+	// addrTypeNone is the address of the fragments of a packet but the first.
+	addrTypeNone = 0xff
+)
+
+var addrFormat = wire.AddrFormat{IPv4: addrTypeIPv4, IPv6: addrTypeIPv6, Domain: addrTypeFQDN}
+
+// tokenLen is the size of the authentication token exported from the TLS session.
+const tokenLen = 32
+
+// heartbeatInterval is the interval of the heartbeats that keep the connection alive while it
+// relays streams or packets.
+const heartbeatInterval = 3 * time.Second
+
+// Error codes of the connections.
+const closeErrCodeOK = 0
+
+// quicConfig has larger flow control windows than the defaults of quic-go, like the TUIC
+// clients, so that the windows don't limit the throughput of high-latency links.
+var quicConfig = &quic.Config{
+	InitialStreamReceiveWindow:     8 << 20,
+	MaxStreamReceiveWindow:         8 << 20,
+	InitialConnectionReceiveWindow: 16 << 20,
+	MaxConnectionReceiveWindow:     16 << 20,
+	MaxIdleTimeout:                 30 * time.Second,
+	EnableDatagrams:                true,
+}
+
+// UDPRelayMode selects how the packets are relayed.
+type UDPRelayMode string
+
+const (
+	// UDPRelayNative relays the packets as QUIC datagrams, which can be lost like UDP packets.
+	// It is the default.
+	UDPRelayNative UDPRelayMode = "native"
+
+	// UDPRelayQUIC relays each packet over its own QUIC stream, so that it's never lost.
+	UDPRelayQUIC UDPRelayMode = "quic"
+)
+
+// Config configures a [Client].
+type Config struct {
+	// Dial returns the packet conn of a new connection to the server, and the address of the server.
+	Dial func(ctx context.Context) (net.PacketConn, net.Addr, error)
+
+	// TLSConfig is the TLS config of the connections, with the ALPN protocols of the server.
+	TLSConfig *tls.Config
+
+	// UUID and Password are the credentials of the user.
+	UUID     [16]byte
+	Password string
+
+	// UDPRelayMode selects how the packets are relayed. Defaults to [UDPRelayNative].
+	UDPRelayMode UDPRelayMode
+}
+
+// Client relays the streams and the packets over a QUIC connection to a TUIC server, dialed on
+// the first use and redialed once it's closed.
+type Client struct {
+	config Config
+
+	mu   sync.Mutex
+	conn *conn
+}
+
+var (
+	_ transport.StreamDialer   = (*Client)(nil)
+	_ transport.PacketListener = (*Client)(nil)
+)
+
+// NewClient creates a [Client] with config.
+func NewClient(config Config) *Client {
+	if config.UDPRelayMode == "" {
+		config.UDPRelayMode = UDPRelayNative
+	}
+	return &Client{config: config}
+}
+
+// conn is an authenticated connection to the server.
+type conn struct {
+	quic.Connection
+	relayMode UDPRelayMode
+
+	// relays is the number of open streams and packet conns, which the heartbeats keep alive.
+	relays atomic.Int32
+
+	mu          sync.Mutex
+	packetConns map[uint16]*packetConn
+	nextAssocID uint16
+}
+
+// connection returns the connection to the server, dialing a new one if there's none open.
+func (c *Client) connection(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// connect dials a new connection to the server, and sends the authentication. The server closes
+// the connection if the authentication fails: it has no response.
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	pc, addr, err := c.config.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{}
+	if c.config.TLSConfig != nil {
+		tlsConf = c.config.TLSConfig.Clone()
+	}
+	qc, err := quicconn.Dial(ctx, pc, addr, tlsConf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The authentication: [uuid][token].
+	tlsState := qc.ConnectionState().TLS
+	token, err := tlsState.ExportKeyingMaterial(string(c.config.UUID[:]), []byte(c.config.Password), tokenLen)
+	if err != nil {
+		qc.CloseWithError(closeErrCodeOK, "")
+		return nil, err
+	}
+	msg := append([]byte{version, cmdAuthenticate}, c.config.UUID[:]...)
+	if err := sendUniStream(qc, append(msg, token...)); err != nil {
+		qc.CloseWithError(closeErrCodeOK, "")
+		return nil, err
+	}
+
+	conn := &conn{Connection: qc, relayMode: c.config.UDPRelayMode, packetConns: make(map[uint16]*packetConn)}
+	resources.Go(resources.SubsystemTUIC, conn.receiveDatagrams)
+	resources.Go(resources.SubsystemTUIC, conn.receiveUniStreams)
+	resources.Go(resources.SubsystemTUIC, conn.sendHeartbeats)
+	return conn, nil
+}
+
+// sendUniStream sends msg over a new unidirectional stream of conn.
+func sendUniStream(conn quic.Connection, msg []byte) error {
+	str, err := conn.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	if _, err := str.Write(msg); err != nil {
+		str.CancelWrite(0)
+		return err
+	}
+	return str.Close()
+}
+
+// sendHeartbeats sends a heartbeat every heartbeatInterval while the connection relays
+// something, until it's closed.
+func (c *conn) sendHeartbeats() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case <-ticker.C:
+			if c.relays.Load() > 0 {
+				c.SendDatagram([]byte{version, cmdHeartbeat})
+			}
+		}
+	}
+}
+
+// Close closes the connection to the server, and the streams and packet conns over it.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.CloseWithError(closeErrCodeOK, "")
+	c.conn = nil
+	return err
+}
+
+// DialStream relays a stream to addr, a host:port address. The server has no response: the
+// stream fails if the server can't connect to addr.
+func (c *Client) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	req, err := addrFormat.AppendHostPort([]byte{version, cmdConnect}, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	str, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := str.Write(req); err != nil {
+		str.CancelRead(0)
+		str.Close()
+		return nil, err
+	}
+	conn.relays.Add(1)
+	return &streamConn{StreamConn: quicconn.NewStreamConn(conn, str), conn: conn}, nil
+}
+
+// streamConn is a relayed stream, counted in the relays of its connection until it's closed.
+type streamConn struct {
+	transport.StreamConn
+	conn      *conn
+	closeOnce sync.Once
+}
+
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() { c.conn.relays.Add(-1) })
+	return c.StreamConn.Close()
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/transporttest"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+var testUUID = [16]byte{0x6c, 0x2f, 0x0b, 0x1e, 0x93, 0x5a, 0x4d, 0x2c, 0x8e, 0x0f, 0x51, 0x3a, 0x77, 0x19, 0xc4, 0x02}
+
+const testPassword = "secret"
+
+// testServer is a TUIC server on the loopback interface echoing the streams and the packets back.
+type testServer struct {
+	*transporttest.QUICServer
+	t *testing.T
+
+	mu            sync.Mutex
+	authenticated int
+	destinations  []string
+	dissociated   []uint16
+	echoConns     map[uint16]*packetConn
+}
+
+func startTestServer(t *testing.T) *testServer {
+	srv := &testServer{t: t}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv.QUICServer = transporttest.StartQUIC(t, pc, "h3", srv.serve)
+	return srv
+}
+
+func (srv *testServer) serve(conn quic.Connection) {
+	go func() {
+		for {
+			str, err := conn.AcceptUniStream(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				b, err := io.ReadAll(str)
+				if err != nil {
+					// The connection is closed.
+					return
+				}
+				srv.handleCommand(conn, b, true)
+			}()
+		}
+	}()
+	go func() {
+		for {
+			b, err := conn.ReceiveDatagram(context.Background())
+			if err != nil {
+				return
+			}
+			srv.handleCommand(conn, b, false)
+		}
+	}()
+	for {
+		str, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go srv.serveStream(str)
+	}
+}
+
+// handleCommand handles a command, received over a unidirectional stream if uni.
+func (srv *testServer) handleCommand(qc quic.Connection, b []byte, uni bool) {
+	require.GreaterOrEqual(srv.t, len(b), 2)
+	require.Equal(srv.t, byte(version), b[0])
+	switch b[1] {
+	case cmdAuthenticate:
+		require.Len(srv.t, b, 2+16+tokenLen)
+		tlsState := qc.ConnectionState().TLS
+		token, err := tlsState.ExportKeyingMaterial(string(b[2:18]), []byte(testPassword), tokenLen)
+		require.NoError(srv.t, err)
+		if !bytes.Equal(b[2:18], testUUID[:]) || !bytes.Equal(b[18:], token) {
+			qc.CloseWithError(1, "authentication failed")
+			return
+		}
+		srv.mu.Lock()
+		srv.authenticated++
+		srv.mu.Unlock()
+	case cmdPacket:
+		cmd, err := parsePacketCommand(b)
+		require.NoError(srv.t, err)
+		// Echo the packets back with a packet conn of the client, which fragments them to the
+		// datagram size of the server.
+		mode := UDPRelayNative
+		if uni {
+			mode = UDPRelayQUIC
+		}
+		echo := &packetConn{conn: &conn{Connection: qc, relayMode: mode}, id: cmd.assocID, closed: make(chan struct{})}
+		srv.mu.Lock()
+		if srv.echoConns == nil {
+			srv.echoConns = make(map[uint16]*packetConn)
+		}
+		if prev, ok := srv.echoConns[cmd.assocID]; ok {
+			echo = prev
+		}
+		srv.echoConns[cmd.assocID] = echo
+		srv.mu.Unlock()
+		p := packet{payload: cmd.payload, addr: cmd.addr}
+		if cmd.fragments > 1 {
+			if p = echo.defragment(cmd); p.addr == nil {
+				return
+			}
+		}
+		_, err = echo.WriteTo(p.payload, p.addr)
+		require.NoError(srv.t, err)
+	case cmdDissociate:
+		srv.mu.Lock()
+		srv.dissociated = append(srv.dissociated, binary.BigEndian.Uint16(b[2:]))
+		srv.mu.Unlock()
+	case cmdHeartbeat:
+	default:
+		srv.t.Errorf("unexpected command %d", b[1])
+	}
+}
+
+func (srv *testServer) serveStream(str quic.Stream) {
+	defer str.Close()
+	// Read the connect command, up to its address, and parse it.
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(str, header); err != nil {
+		// The connection is closed, like when the authentication fails.
+		return
+	}
+	require.Equal(srv.t, []byte{version, cmdConnect}, header[:2])
+	rest := map[byte]int{addrTypeIPv4: 4 + 2, addrTypeIPv6: 16 + 2, addrTypeFQDN: 1}[header[2]]
+	addr := append(header[2:], make([]byte, rest)...)
+	_, err := io.ReadFull(str, addr[1:])
+	require.NoError(srv.t, err)
+	if header[2] == addrTypeFQDN {
+		addr = append(addr, make([]byte, int(addr[1])+2)...)
+		_, err = io.ReadFull(str, addr[2:])
+		require.NoError(srv.t, err)
+	}
+	dest, _, err := addrFormat.Parse(addr)
+	require.NoError(srv.t, err)
+	srv.mu.Lock()
+	srv.destinations = append(srv.destinations, dest.String())
+	srv.mu.Unlock()
+	io.Copy(str, str)
+}
+
+func (srv *testServer) client(password string, mode UDPRelayMode) *Client {
+	return NewClient(Config{
+		Dial:         srv.Dial,
+		TLSConfig:    &tls.Config{RootCAs: srv.Roots, ServerName: "localhost", NextProtos: []string{"h3"}},
+		UUID:         testUUID,
+		Password:     password,
+		UDPRelayMode: mode,
+	})
+}
+
+func TestClient(t *testing.T) {
+	for _, mode := range []UDPRelayMode{UDPRelayNative, UDPRelayQUIC} {
+		srv := startTestServer(t)
+		client := srv.client(testPassword, mode)
+		defer client.Close()
+
+		data := bytes.Repeat([]byte("0123456789"), 100_000)
+		var wg sync.WaitGroup
+		for _, addr := range []string{"example.com:443", "192.0.2.1:80", "[2001:db8::1]:53"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := client.DialStream(context.Background(), addr)
+				require.NoError(t, err)
+				defer conn.Close()
+				require.Equal(t, data, transporttest.Echo(t, conn, data))
+			}()
+		}
+		wg.Wait()
+		require.ElementsMatch(t, []string{"example.com:443", "192.0.2.1:80", "[2001:db8::1]:53"}, srv.destinations)
+
+		pc, err := client.ListenPacket(context.Background())
+		require.NoError(t, err)
+		buf := make([]byte, 65535)
+		// The second packet is too large for a datagram.
+		for _, packet := range [][]byte{[]byte("ping"), bytes.Repeat([]byte("x"), 3000)} {
+			_, err = pc.WriteTo(packet, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53})
+			require.NoError(t, err)
+			pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, addr, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, packet, buf[:n])
+			require.Equal(t, "192.0.2.1:53", addr.String())
+		}
+		require.NoError(t, pc.Close())
+		require.Eventually(t, func() bool {
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			return len(srv.dissociated) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, pc.(*packetConn).id, srv.dissociated[0])
+
+		require.Equal(t, int32(1), srv.Conns.Load())
+		require.Equal(t, 1, srv.authenticated)
+	}
+}
+
+func TestClient_AuthError(t *testing.T) {
+	srv := startTestServer(t)
+	client := srv.client("wrong", UDPRelayNative)
+	defer client.Close()
+	conn, err := client.DialStream(context.Background(), "example.com:443")
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+	}
+	var appErr *quic.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	require.Equal(t, "authentication failed", appErr.ErrorMessage)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	srv := startTestServer(t)
+	client := srv.client(testPassword, UDPRelayNative)
+	defer client.Close()
+	pc, err := client.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer pc.Close()
+
+	client.conn.CloseWithError(closeErrCodeOK, "")
+	_, _, err = pc.ReadFrom(make([]byte, 1))
+	require.ErrorIs(t, err, net.ErrClosed)
+
+	conn, err := client.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, []byte("ping"), transporttest.Echo(t, conn, []byte("ping")))
+	require.Equal(t, int32(2), srv.Conns.Load())
+}
+
+func TestPacketCommand(t *testing.T) {
+	for _, addr := range []net.Addr{
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+		&wire.DomainAddr{Host: "example.com", Port: 8080},
+		nil,
+	} {
+		cmd := &packetCommand{assocID: 7, packetID: 9, fragments: 2, fragmentID: 1, addr: addr, payload: []byte("payload")}
+		b, err := appendPacketCommand(nil, cmd)
+		require.NoError(t, err)
+		got, err := parsePacketCommand(b)
+		require.NoError(t, err)
+		require.Equal(t, cmd, got)
+
+		_, err = parsePacketCommand(b[:len(b)-1])
+		require.Error(t, err)
+	}
+}
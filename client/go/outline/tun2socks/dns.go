@@ -0,0 +1,49 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"context"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
+	"github.com/eycorsican/go-tun2socks/core"
+)
+
+type dnsInterceptUDPHandler struct {
+	core.UDPConnHandler
+	forwarder *dnsintercept.Forwarder
+}
+
+// NewDNSInterceptUDPHandler returns a UDP connection handler answering the DNS queries with
+// `forwarder`, and handling all other packets with `base`.
+func NewDNSInterceptUDPHandler(forwarder *dnsintercept.Forwarder, base core.UDPConnHandler) core.UDPConnHandler {
+	return &dnsInterceptUDPHandler{UDPConnHandler: base, forwarder: forwarder}
+}
+
+// ReceiveTo answers the packets sent to the DNS port, and passes the others to the base handler.
+func (h *dnsInterceptUDPHandler) ReceiveTo(tunConn core.UDPConn, data []byte, destAddr *net.UDPAddr) error {
+	if destAddr.Port != dnsintercept.DNSPort {
+		return h.UDPConnHandler.ReceiveTo(tunConn, data, destAddr)
+	}
+	query := append([]byte(nil), data...)
+	resources.Go(resources.SubsystemDNS, func() {
+		if answer := h.forwarder.Answer(context.Background(), query); answer != nil {
+			tunConn.WriteFrom(answer, destAddr)
+		}
+	})
+	return nil
+}
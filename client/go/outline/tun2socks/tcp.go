@@ -16,9 +16,10 @@ package tun2socks
 
 import (
 	"context"
-	"io"
 	"net"
 
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 	"github.com/eycorsican/go-tun2socks/core"
 )
@@ -38,12 +39,13 @@ func (h *tcpHandler) Handle(conn net.Conn, target *net.TCPAddr) error {
 		return err
 	}
 	// TODO: Request upstream to make `conn` a `core.TCPConn` so we can avoid this type assertion.
-	go relay(conn.(core.TCPConn), proxyConn)
+	tunConn := conn.(core.TCPConn)
+	resources.Go(resources.SubsystemTun2socks, func() { relay(tunConn, proxyConn) })
 	return nil
 }
 
 func copyOneWay(leftConn, rightConn transport.StreamConn) (int64, error) {
-	n, err := io.Copy(leftConn, rightConn)
+	n, err := bufpool.Copy(leftConn, rightConn)
 	// Send FIN to indicate EOF
 	leftConn.CloseWrite()
 	// Release reader resources
@@ -62,10 +64,10 @@ func relay(leftConn, rightConn transport.StreamConn) (int64, int64, error) {
 	}
 	ch := make(chan res)
 
-	go func() {
+	resources.Go(resources.SubsystemTun2socks, func() {
 		n, err := copyOneWay(rightConn, leftConn)
 		ch <- res{n, err}
-	}()
+	})
 
 	n, err := copyOneWay(leftConn, rightConn)
 	rs := <-ch
@@ -15,6 +15,7 @@
 package tun2socks
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -25,8 +26,15 @@ import (
 
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/icmpecho"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pcap"
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/quic"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
 	"github.com/Jigsaw-Code/outline-apps/client/go/tunnel"
 )
 
@@ -57,48 +65,99 @@ type outlinetunnel struct {
 	streamDialer transport.StreamDialer
 	packetDialer transport.PacketListener
 	isUDPEnabled bool // Whether the tunnel supports proxying UDP.
+	stats        *stats.Session
+	dnsForwarder *dnsintercept.Forwarder
+	udpFallback  transport.PacketListener
+	udpTimeout   time.Duration
+	udpMax       int       // The size of the UDP NAT table.
+	input        io.Writer // Where the packets from the TUN device go.
 }
 
-// newTunnel connects a tunnel to the given stream and packet dialers and returns an `outline.Tunnel`.
+// newTunnel connects a tunnel to the given Outline client and returns an `outline.Tunnel`.
 //
-// `streamDialer` is the StreamDialer to proxy TCP traffic.
-// `packetListener` is the PacketListener tp proxy UDP traffic.
+// `client` proxies the TCP and UDP traffic. Its DNSForwarder answers the DNS queries if it is not
+// nil, and its UDPFallback relays the UDP traffic when UDP is disabled if it is not nil.
 // `isUDPEnabled` indicates if the Outline proxy and the network support proxying UDP traffic.
 // `tunWriter` is used to output packets back to the TUN device.  OutlineTunnel.Disconnect() will close `tunWriter`.
-func newTunnel(streamDialer transport.StreamDialer, packetListener transport.PacketListener, isUDPEnabled bool, tunWriter io.WriteCloser) (Tunnel, error) {
+func newTunnel(client *outline.Client, isUDPEnabled bool, tunWriter io.WriteCloser) (Tunnel, error) {
 	if tunWriter == nil {
 		return nil, errors.New("must provide a TUN writer")
 	}
 	core.RegisterOutputFn(func(data []byte) (int, error) {
+		pcap.Record(false, data)
 		return tunWriter.Write(data)
 	})
 	lwipStack := core.NewLWIPStack()
 	base := tunnel.NewTunnel(tunWriter, lwipStack)
-	t := &outlinetunnel{base, lwipStack, streamDialer, packetListener, isUDPEnabled}
+	t := &outlinetunnel{
+		Tunnel:       base,
+		lwipStack:    lwipStack,
+		streamDialer: client.StreamDialer,
+		packetDialer: client.PacketListener,
+		isUDPEnabled: isUDPEnabled,
+		stats:        stats.StartSession(),
+		dnsForwarder: client.DNSForwarder,
+		udpFallback:  client.UDPFallback,
+		udpTimeout:   client.UDPIdleTimeout,
+		udpMax:       client.UDPMaxSessions,
+	}
+	if client.DNSForwarder != nil {
+		t.stats.SetDNSCache(client.DNSForwarder)
+	}
+	var input io.Writer = base
+	if client.BlockQUIC {
+		input = quic.NewBlockingWriter(input, tunWriter)
+	}
+	input = icmpecho.NewWriter(input, tunWriter, client.ICMPEcho, icmpecho.NewTLSProber(client.StreamDialer))
+	t.input = pcap.NewTapWriter(input, true)
 	t.registerConnectionHandlers()
+	t.emitUDPSupportChanged()
 	return t, nil
 }
 
 func (t *outlinetunnel) UpdateUDPSupport() bool {
 	resolverAddr := &net.UDPAddr{IP: net.ParseIP("1.1.1.1"), Port: 53}
-	isUDPEnabled := connectivity.CheckUDPConnectivityWithDNS(t.packetDialer, resolverAddr) == nil
+	isUDPEnabled := connectivity.CheckUDPConnectivityWithDNS(context.Background(), t.packetDialer, resolverAddr) == nil
 	if t.isUDPEnabled != isUDPEnabled {
 		t.isUDPEnabled = isUDPEnabled
 		t.lwipStack.Close() // Close existing connections to avoid using the previous handlers.
 		t.registerConnectionHandlers()
+		t.emitUDPSupportChanged()
 	}
 	return isUDPEnabled
 }
 
+func (t *outlinetunnel) emitUDPSupportChanged() {
+	event.Emit(event.UDPSupportChanged, event.UDPSupportChangedData{
+		SupportsUDP: t.isUDPEnabled,
+		OverTCP:     !t.isUDPEnabled && t.udpFallback != nil,
+	})
+}
+
+// Write writes a packet from the TUN device to the tunnel.
+func (t *outlinetunnel) Write(pkt []byte) (int, error) {
+	return t.input.Write(pkt)
+}
+
+func (t *outlinetunnel) Disconnect() {
+	stats.EndSession(t.stats)
+	t.Tunnel.Disconnect()
+}
+
 // Registers UDP and TCP connection handlers to the tunnel's host and port.
-// Registers a DNS/TCP fallback UDP handler when UDP is disabled.
+// Registers a UDP/TCP, or else DNS/TCP, fallback UDP handler when UDP is disabled.
 func (t *outlinetunnel) registerConnectionHandlers() {
 	var udpHandler core.UDPConnHandler
 	if t.isUDPEnabled {
-		udpHandler = NewUDPHandler(t.packetDialer, 30*time.Second)
+		udpHandler = NewUDPHandler(t.stats.PacketListener(t.packetDialer), t.udpTimeout, t.stats.NewNATTable(t.udpMax))
+	} else if t.udpFallback != nil {
+		udpHandler = NewUDPHandler(t.stats.PacketListener(t.udpFallback), t.udpTimeout, t.stats.NewNATTable(t.udpMax))
 	} else {
 		udpHandler = dnsfallback.NewUDPHandler()
 	}
-	core.RegisterTCPConnHandler(NewTCPHandler(t.streamDialer))
+	if t.dnsForwarder != nil {
+		udpHandler = NewDNSInterceptUDPHandler(t.dnsForwarder, udpHandler)
+	}
+	core.RegisterTCPConnHandler(NewTCPHandler(t.stats.StreamDialer(t.streamDialer)))
 	core.RegisterUDPConnHandler(udpHandler)
 }
@@ -39,9 +39,12 @@ func init() {
 //   - `client` is the Outline client (created by [outline.NewClient]).
 //   - `isUDPEnabled` indicates whether the tunnel and/or network enable UDP proxying.
 //
-// Returns an error if the TUN file descriptor cannot be opened, or if the tunnel fails to
-// connect.
+// Returns an error if the routing rules of `client` cannot apply to the VPN, if the TUN file
+// descriptor cannot be opened, or if the tunnel fails to connect.
 func ConnectOutlineTunnel(fd int, client *outline.Client, isUDPEnabled bool) *ConnectOutlineTunnelResult {
+	if err := client.CheckVPNRouting(); err != nil {
+		return &ConnectOutlineTunnelResult{Error: platerrors.ToPlatformError(err)}
+	}
 	tun, err := tunnel.MakeTunFile(fd)
 	if err != nil {
 		return &ConnectOutlineTunnelResult{Error: &platerrors.PlatformError{
@@ -51,7 +54,7 @@ func ConnectOutlineTunnel(fd int, client *outline.Client, isUDPEnabled bool) *Co
 		}}
 	}
 
-	t, err := newTunnel(client, client, isUDPEnabled, tun)
+	t, err := newTunnel(client, isUDPEnabled, tun)
 	if err != nil {
 		return &ConnectOutlineTunnelResult{Error: &platerrors.PlatformError{
 			Code:    platerrors.SetupTrafficHandlerFailed,
@@ -49,7 +49,8 @@ func init() {
 // `client` is the Outline client (created by [outline.NewClient]).
 // `isUDPEnabled` indicates whether the tunnel and/or network enable UDP proxying.
 //
-// Sets an error if the tunnel fails to connect.
+// Sets an error if the routing rules of `client` cannot apply to the VPN, or if the tunnel fails to
+// connect.
 func ConnectOutlineTunnel(tunWriter TunWriter, client *outline.Client, isUDPEnabled bool) *ConnectOutlineTunnelResult {
 	if tunWriter == nil {
 		return &ConnectOutlineTunnelResult{Error: &platerrors.PlatformError{
@@ -62,8 +63,11 @@ func ConnectOutlineTunnel(tunWriter TunWriter, client *outline.Client, isUDPEnab
 			Message: "must provide a client instance",
 		}}
 	}
+	if err := client.CheckVPNRouting(); err != nil {
+		return &ConnectOutlineTunnelResult{Error: platerrors.ToPlatformError(err)}
+	}
 
-	t, err := newTunnel(client, client, isUDPEnabled, tunWriter)
+	t, err := newTunnel(client, isUDPEnabled, tunWriter)
 	if err != nil {
 		return &ConnectOutlineTunnelResult{Error: &platerrors.PlatformError{
 			Code:    platerrors.SetupTrafficHandlerFailed,
@@ -21,6 +21,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/nat"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 	"github.com/eycorsican/go-tun2socks/core"
 )
@@ -36,19 +38,30 @@ type udpHandler struct {
 	// is closed.
 	timeout time.Duration
 
+	// Limits the number of sessions, evicting the least recently used ones.
+	table *nat.Table
+
 	// Maps connections from TUN to connections to the proxy.
-	conns map[core.UDPConn]net.PacketConn
+	conns map[core.UDPConn]udpSession
+}
+
+// udpSession is the connection to the proxy of a TUN connection, and its NAT table entry.
+type udpSession struct {
+	proxyConn net.PacketConn
+	entry     *nat.Entry
 }
 
 // NewUDPHandler returns a UDP connection handler.
 //
 // `listener` provides the packet proxying functionality.
 // `timeout` is the UDP read and write timeout.
-func NewUDPHandler(listener transport.PacketListener, timeout time.Duration) core.UDPConnHandler {
+// `table` limits the number of sessions.
+func NewUDPHandler(listener transport.PacketListener, timeout time.Duration, table *nat.Table) core.UDPConnHandler {
 	return &udpHandler{
 		listener: listener,
 		timeout:  timeout,
-		conns:    make(map[core.UDPConn]net.PacketConn, 8),
+		table:    table,
+		conns:    make(map[core.UDPConn]udpSession, 8),
 	}
 }
 
@@ -57,10 +70,11 @@ func (h *udpHandler) Connect(tunConn core.UDPConn, target *net.UDPAddr) error {
 	if err != nil {
 		return err
 	}
+	entry := h.table.Add(func() { h.close(tunConn) })
 	h.Lock()
-	h.conns[tunConn] = proxyConn
+	h.conns[tunConn] = udpSession{proxyConn, entry}
 	h.Unlock()
-	go h.relayPacketsFromProxy(tunConn, proxyConn)
+	resources.Go(resources.SubsystemTun2socks, func() { h.relayPacketsFromProxy(tunConn, proxyConn) })
 	return nil
 }
 
@@ -92,13 +106,14 @@ func (h *udpHandler) relayPacketsFromProxy(tunConn core.UDPConn, proxyConn net.P
 // ReceiveTo relays packets from the TUN device to the proxy. It's called by tun2socks.
 func (h *udpHandler) ReceiveTo(tunConn core.UDPConn, data []byte, destAddr *net.UDPAddr) error {
 	h.Lock()
-	proxyConn, ok := h.conns[tunConn]
+	session, ok := h.conns[tunConn]
 	h.Unlock()
 	if !ok {
 		return fmt.Errorf("connection %v->%v does not exist", tunConn.LocalAddr(), destAddr)
 	}
-	proxyConn.SetDeadline(time.Now().Add(h.timeout))
-	_, err := proxyConn.WriteTo(data, destAddr)
+	session.entry.Touch()
+	session.proxyConn.SetDeadline(time.Now().Add(h.timeout))
+	_, err := session.proxyConn.WriteTo(data, destAddr)
 	return err
 }
 
@@ -106,7 +121,9 @@ func (h *udpHandler) close(tunConn core.UDPConn) {
 	tunConn.Close()
 	h.Lock()
 	defer h.Unlock()
-	if proxyConn, ok := h.conns[tunConn]; ok {
-		proxyConn.Close()
+	if session, ok := h.conns[tunConn]; ok {
+		session.proxyConn.Close()
+		session.entry.Remove()
+		delete(h.conns, tunConn)
 	}
 }
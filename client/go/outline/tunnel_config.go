@@ -0,0 +1,529 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/utf8"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// parsedTunnelConfigJSON is one item of the [MethodParseTunnelConfigs] output.
+type parsedTunnelConfigJSON struct {
+	// Index of the tunnel config in the input list.
+	Index int `json:"index"`
+
+	// Name is the name of the server in the fragment of an access key, or in the name or
+	// remarks of a JSON config, if any.
+	Name string `json:"name,omitempty"`
+	// Comment and Tags are the other display metadata of a JSON config, if any.
+	Comment string   `json:"comment,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	// Transport is the transport config, of any transport type.
+	Transport json.RawMessage           `json:"transport,omitempty"`
+	Error     *platerrors.PlatformError `json:"error,omitempty"`
+
+	// FirstHopTCP, FirstHopUDP and FirstHopICMP are the hosts the transport connects to directly,
+	// by protocol, so that the app can exclude them all from the VPN. They are omitted if the
+	// transport doesn't use the protocol, or if they are unknown, like for the transports
+	// registered with [RegisterTransport].
+	FirstHopTCP  []firstHopJSON `json:"firstHopTcp,omitempty"`
+	FirstHopUDP  []firstHopJSON `json:"firstHopUdp,omitempty"`
+	FirstHopICMP []firstHopJSON `json:"firstHopIcmp,omitempty"`
+
+	// Canonical is the transport as canonical JSON, if requested, so that the app can compare
+	// configs as strings.
+	Canonical string `json:"canonical,omitempty"`
+}
+
+// parseTunnelConfigsRequestJSON is the input of [MethodParseTunnelConfigs]. It can also be a plain
+// JSON array of the configs.
+type parseTunnelConfigsRequestJSON struct {
+	// Configs are the tunnel config texts.
+	Configs []string `json:"configs"`
+
+	// Canonical sets the Canonical field of the results.
+	Canonical bool `json:"canonical,omitempty"`
+}
+
+func (r *parseTunnelConfigsRequestJSON) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		*r = parseTunnelConfigsRequestJSON{}
+		return json.Unmarshal(trimmed, &r.Configs)
+	}
+	type request parseTunnelConfigsRequestJSON
+	return json.Unmarshal(data, (*request)(r))
+}
+
+// sip008ConfigJSON is the JSON format of a tunnel config, as served by dynamic access keys.
+type sip008ConfigJSON struct {
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+	Method     string `json:"method"`
+	Password   string `json:"password"`
+	Prefix     string `json:"prefix"`
+	Plugin     string `json:"plugin"`
+
+	// Remarks is the name of the server in SIP008. Name takes precedence.
+	Remarks string   `json:"remarks"`
+	Name    string   `json:"name"`
+	Comment string   `json:"comment"`
+	Tags    []string `json:"tags"`
+
+	// Error is set by the providers instead of the config, e.g. when the subscription expired.
+	Error *providerErrorJSON `json:"error"`
+}
+
+// serverMetadataJSON is the display metadata of a server in its tunnel config, so that the app
+// can title the imported servers.
+type serverMetadataJSON struct {
+	Name    string
+	Comment string
+	Tags    []string
+}
+
+// normalizeTags trims the tags, and removes the empty and duplicate ones.
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// providerErrorJSON is the "error" section a provider returns instead of a tunnel config. It
+// mirrors ProviderErrorJson of the app: the optional fields of the wrong type or value are
+// dropped, not rejected.
+type providerErrorJSON struct {
+	Message string
+	// Messages are the localized messages by language code, e.g. {"en": "...", "fa": "..."}.
+	Messages map[string]string
+	Details  string
+	// URL is an HTTPS page of the provider, e.g. to renew the subscription.
+	URL string
+	// Action is what the user can do about the error, "renew" or "contact".
+	Action string
+}
+
+func (e *providerErrorJSON) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Message  string `json:"message"`
+		Messages any    `json:"messages"`
+		Details  string `json:"details"`
+		URL      any    `json:"url"`
+		Action   any    `json:"action"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = providerErrorJSON{Message: raw.Message, Details: raw.Details}
+	if messages, ok := raw.Messages.(map[string]any); ok {
+		for language, message := range messages {
+			if message, ok := message.(string); ok {
+				if e.Messages == nil {
+					e.Messages = make(map[string]string)
+				}
+				e.Messages[language] = message
+			}
+		}
+	}
+	// The app only opens HTTPS pages, so that a provider can't make it open other kinds of links.
+	if rawURL, ok := raw.URL.(string); ok {
+		if u, err := url.Parse(rawURL); err == nil && u.Scheme == "https" && u.Host != "" {
+			e.URL = rawURL
+		}
+	}
+	if action, ok := raw.Action.(string); ok && (action == "renew" || action == "contact") {
+		e.Action = action
+	}
+	return nil
+}
+
+// details returns the details of the platform error of e.
+func (e *providerErrorJSON) details() platerrors.ErrorDetails {
+	details := platerrors.ErrorDetails{
+		"message": e.Message,
+		"details": e.Details,
+	}
+	if len(e.Messages) > 0 {
+		details["messages"] = e.Messages
+	}
+	if e.URL != "" {
+		details["url"] = e.URL
+	}
+	if e.Action != "" {
+		details["action"] = e.Action
+	}
+	return details
+}
+
+// parseTunnelConfigs parses the tunnel config texts of the parseTunnelConfigsRequestJSON input
+// concurrently, and returns a JSON array of parsedTunnelConfigJSON in the same order. A config
+// that fails to parse only sets the error of its own item.
+func parseTunnelConfigs(input string) (string, error) {
+	var req parseTunnelConfigsRequestJSON
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "invalid parse tunnel configs request",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	texts := req.Configs
+	results := make([]parsedTunnelConfigJSON, len(texts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(texts)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				meta, transport, canonical, err := parseTunnelConfigTransport(texts[i], req.Canonical)
+				results[i] = parsedTunnelConfigJSON{Index: i, Name: meta.Name, Comment: meta.Comment, Tags: meta.Tags,
+					Transport: transport, Canonical: canonical, Error: platerrors.ToPlatformError(err)}
+				if hops, ok := transportFirstHops(transport); err == nil && ok {
+					results[i].FirstHopTCP, results[i].FirstHopUDP, results[i].FirstHopICMP = hops.TCP, hops.UDP, hops.ICMP
+				}
+			}
+		}()
+	}
+	for i := range texts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	out, err := json.Marshal(results)
+	if err != nil {
+		return "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the parsed tunnel configs",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	return string(out), nil
+}
+
+// transportURLParsers parse the URLs of the transports other than Shadowsocks, by scheme, into
+// their transport configs.
+var transportURLParsers = map[string]func(u *url.URL) (any, error){
+	"hysteria2": parseHysteria2URL,
+	"hy2":       parseHysteria2URL,
+	"tuic":      parseTUICURL,
+}
+
+// parseTunnelConfigTransport parses a tunnel config text like parseTunnelConfig, or the URL of
+// another transport, into its transport config JSON and the display metadata of the server. It
+// also returns the canonical JSON of the transport if canonical is set.
+func parseTunnelConfigTransport(text string, canonical bool) (meta serverMetadataJSON, transport json.RawMessage, canonicalText string, err error) {
+	text = strings.TrimSpace(text)
+	var conf any
+	scheme, _, _ := strings.Cut(text, "://")
+	if parseURL, ok := transportURLParsers[strings.ToLower(scheme)]; ok {
+		meta.Name, conf, err = parseTransportURL(text, parseURL)
+	} else {
+		var ssConf *configJSON
+		meta, ssConf, err = parseTunnelConfig(text)
+		if err == nil && canonical {
+			canonicalText, err = ssConf.canonicalJSON()
+		}
+		conf = ssConf
+	}
+	if err != nil {
+		return meta, nil, "", err
+	}
+	if transport, err = json.Marshal(conf); err != nil {
+		return meta, nil, "", platerrors.PlatformError{
+			Code:    platerrors.InternalError,
+			Message: "failed to marshal the config",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	if canonical && canonicalText == "" {
+		// Decode the config into maps, which are marshaled with sorted keys.
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(transport))
+		decoder.UseNumber()
+		err = decoder.Decode(&value)
+		var out []byte
+		if err == nil {
+			out, err = canonicalJSON(value)
+		}
+		if err != nil {
+			return meta, nil, "", platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: "failed to marshal the canonical config",
+				Cause:   platerrors.ToPlatformError(err),
+			}
+		}
+		canonicalText = string(out)
+	}
+	return meta, transport, canonicalText, nil
+}
+
+// parseTransportURL parses the URL of a transport with parseURL, and returns the name of the
+// server in its fragment.
+func parseTransportURL(text string, parseURL func(u *url.URL) (any, error)) (name string, conf any, err error) {
+	scheme, _, _ := strings.Cut(text, "://")
+	text, ports := cutURLPortRange(text)
+	u, err := url.Parse(text)
+	if err == nil && u.Host == "" {
+		err = errors.New("missing host")
+	}
+	if err != nil {
+		return "", nil, newIllegalConfigErrorWithDetails("transport URL is not valid",
+			"url", scheme+"://...", "a "+scheme+":// URL", err)
+	}
+	if ports != "" {
+		query := u.Query()
+		query.Set("mport", ports)
+		u.RawQuery = query.Encode()
+	}
+	if conf, err = parseURL(u); err != nil {
+		return "", nil, err
+	}
+	return u.Fragment, conf, nil
+}
+
+// cutURLPortRange removes the port of the URL in text if it is a list of ports and port ranges,
+// like in hysteria2://auth@host:443,20000-30000/, which net/url doesn't parse, and returns it.
+func cutURLPortRange(text string) (string, string) {
+	_, rest, _ := strings.Cut(text, "://")
+	authority := rest
+	if end := strings.IndexAny(rest, "/?#"); end >= 0 {
+		authority = rest[:end]
+	}
+	hostStart := strings.LastIndex(authority, "@") + 1
+	colon := strings.LastIndex(authority, ":")
+	if colon < hostStart || strings.Contains(authority[colon:], "]") {
+		return text, ""
+	}
+	ports := authority[colon+1:]
+	if !strings.ContainsAny(ports, "-,") {
+		return text, ""
+	}
+	authorityStart := len(text) - len(rest)
+	return text[:authorityStart+colon] + text[authorityStart+len(authority):], ports
+}
+
+// urlPort returns the port of u, or defaultPort if it has none.
+func urlPort(u *url.URL, defaultPort uint16) (uint16, error) {
+	if u.Port() == "" {
+		return defaultPort, nil
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil || port == 0 {
+		return 0, newIllegalConfigErrorWithDetails("port is not valid", "port", u.Port(), "within range [1..65535]", err)
+	}
+	return uint16(port), nil
+}
+
+// isTrueQueryValue returns whether the value of a boolean URL parameter is true, as "1" or "true".
+func isTrueQueryValue(v string) bool {
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// parseTunnelConfig parses a tunnel config text, which is either an ss:// access key or a JSON
+// object, into a Shadowsocks transport config and the display metadata of the server. It mirrors
+// parseTunnelConfig of the app.
+func parseTunnelConfig(text string) (meta serverMetadataJSON, conf *configJSON, err error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "ss://") {
+		meta.Name, conf, err = parseAccessKey(text)
+		return meta, conf, err
+	}
+
+	if err := checkConfigLimits(text); err != nil {
+		return meta, nil, err
+	}
+	var sip008 sip008ConfigJSON
+	if err := json.Unmarshal([]byte(text), &sip008); err != nil {
+		return meta, nil, newInvalidJSONError("tunnel config is neither an access key nor a valid JSON", text, err)
+	}
+	if sip008.Error != nil {
+		return meta, nil, platerrors.PlatformError{
+			Code:    platerrors.IllegalConfig,
+			Message: "the provider returned an error instead of a tunnel config",
+			Details: sip008.Error.details(),
+		}
+	}
+	conf = &configJSON{
+		Host:     sip008.Server,
+		Port:     sip008.ServerPort,
+		Method:   sip008.Method,
+		Password: sip008.Password,
+		Prefix:   sip008.Prefix,
+	}
+	if err := validateTunnelConfig(conf, sip008.Plugin); err != nil {
+		return meta, nil, err
+	}
+	meta = serverMetadataJSON{
+		Name:    strings.TrimSpace(sip008.Name),
+		Comment: strings.TrimSpace(sip008.Comment),
+		Tags:    normalizeTags(sip008.Tags),
+	}
+	if meta.Name == "" {
+		meta.Name = strings.TrimSpace(sip008.Remarks)
+	}
+	return meta, conf, nil
+}
+
+// parseAccessKey parses an ss:// access key, in either the SIP002 or the legacy format:
+//
+//	ss://base64url(method:password)@host:port/?prefix=...#name
+//	ss://method:password@host:port/#name (2022 ciphers, percent-encoded)
+//	ss://base64(method:password@host:port)#name
+func parseAccessKey(key string) (name string, conf *configJSON, err error) {
+	authority, fragment, _ := strings.Cut(strings.TrimPrefix(key, "ss://"), "#")
+	var u *url.URL
+	if !strings.Contains(authority, "@") {
+		u, err = parseLegacyAccessKey(authority)
+	} else {
+		u, err = url.Parse(key)
+	}
+	if err == nil && strings.Contains(u.Hostname(), ":") && !strings.HasPrefix(u.Host, "[") {
+		// Otherwise "::1" would be the host ":" and the port 1.
+		err = errors.New("IPv6 address is not in brackets")
+	}
+	if err != nil {
+		perr := newIllegalConfigErrorWithDetails("access key is not valid",
+			"access-key", "ss://...", "SIP002 ss:// URL", err)
+		if offending := offendingURLPart(err); offending != "" {
+			if offset := strings.Index(key, offending); offset >= 0 {
+				for k, v := range positionDetails(key, offset, offending) {
+					perr.Details[k] = v
+				}
+			}
+		}
+		return "", nil, perr
+	}
+	if fragment != "" {
+		if name, err = url.PathUnescape(fragment); err != nil {
+			name = fragment
+		}
+	}
+
+	method := u.User.Username()
+	password, ok := u.User.Password()
+	if !ok {
+		userInfo, err := decodeBase64(method)
+		if err != nil {
+			return "", nil, newIllegalConfigErrorWithDetails("access key user info is not valid",
+				"userinfo", "...", "base64(method:password)", err)
+		}
+		method, password, _ = strings.Cut(string(userInfo), ":")
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		perr := newIllegalConfigErrorWithDetails("port is not valid", "port", u.Port(), "within range [1..65535]", err)
+		if offset := strings.LastIndex(authority, ":"+u.Port()); offset >= 0 && u.Port() != "" {
+			for k, v := range positionDetails(key, len("ss://")+offset+1, u.Port()) {
+				perr.Details[k] = v
+			}
+		}
+		return "", nil, perr
+	}
+	query := u.Query()
+	conf = &configJSON{
+		Host:     u.Hostname(),
+		Port:     uint16(port),
+		Method:   method,
+		Password: password,
+		Prefix:   utf8.EncodeRawBytesToUTF8Codepoints([]byte(query.Get("prefix"))),
+	}
+	if err := validateTunnelConfig(conf, query.Get("plugin")); err != nil {
+		return "", nil, err
+	}
+	return name, conf, nil
+}
+
+// parseLegacyAccessKey parses the authority of a legacy access key, base64(method:password@host:port).
+func parseLegacyAccessKey(authority string) (*url.URL, error) {
+	decoded, err := decodeBase64(authority)
+	if err != nil {
+		return nil, err
+	}
+	// The password may contain "@", but the host can't.
+	at := bytes.LastIndexByte(decoded, '@')
+	if at < 0 {
+		return nil, errors.New("missing user info")
+	}
+	// Parse the host like in the SIP002 keys, to reject the same invalid hosts.
+	u, err := url.Parse("ss://" + string(decoded[at+1:]))
+	if err != nil {
+		return nil, err
+	}
+	method, password, _ := strings.Cut(string(decoded[:at]), ":")
+	u.User = url.UserPassword(method, password)
+	return u, nil
+}
+
+// offendingURLPart returns the part of a URL that made [url.Parse] fail, if known.
+func offendingURLPart(err error) string {
+	var escapeErr url.EscapeError
+	var hostErr url.InvalidHostError
+	switch {
+	case errors.As(err, &escapeErr):
+		return string(escapeErr)
+	case errors.As(err, &hostErr):
+		return string(hostErr)
+	}
+	return ""
+}
+
+// decodeBase64 decodes the standard or URL-safe base64 string s, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// validateTunnelConfig validates the Shadowsocks server of conf. Plugins are not supported.
+func validateTunnelConfig(conf *configJSON, plugin string) error {
+	if plugin != "" {
+		return newIllegalConfigErrorWithDetails("plugins are not supported", "plugin", plugin, "no plugin", nil)
+	}
+	return validateConfig(conf.Host, int(conf.Port), conf.Method, conf.Password)
+}
+
+// canonicalJSON marshals v with sorted object keys, without whitespace or HTML escaping.
+func canonicalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
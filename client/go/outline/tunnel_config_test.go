@@ -0,0 +1,291 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTunnelConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantName string
+		want     configJSON
+	}{
+		{
+			name:  "SIP002",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTphYmNkMTIzNA@192.0.2.1:8080/",
+			want:  configJSON{Host: "192.0.2.1", Port: 8080, Method: "chacha20-ietf-poly1305", Password: "abcd1234"},
+		},
+		{
+			name:     "SIP002 with IPv6, prefix and name",
+			input:    " ss://YWVzLTEyOC1nY206cHc=@[2001:db8::1]:443/?prefix=%16%03%01%20a#My%20server\n",
+			wantName: "My server",
+			want:     configJSON{Host: "2001:db8::1", Port: 443, Method: "aes-128-gcm", Password: "pw", Prefix: "\u0016\u0003\u0001 a"},
+		},
+		{
+			name:  "2022 cipher",
+			input: "ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%3D%3D@example.com:443/",
+			want:  configJSON{Host: "example.com", Port: 443, Method: "2022-blake3-aes-128-gcm", Password: "YctPZ6U7xPPcU+gp3u+0tx=="},
+		},
+		{
+			name:     "legacy",
+			input:    "ss://YWVzLTEyOC1nY206cEBzc0BleGFtcGxlLmNvbTo0NDM=#legacy",
+			wantName: "legacy",
+			want:     configJSON{Host: "example.com", Port: 443, Method: "aes-128-gcm", Password: "p@ss"},
+		},
+		{
+			name:  "JSON",
+			input: `{"server": "example.com", "server_port": 443, "method": "aes-128-gcm", "password": "pw", "prefix": "POST "}`,
+			want:  configJSON{Host: "example.com", Port: 443, Method: "aes-128-gcm", Password: "pw", Prefix: "POST "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, conf, err := parseTunnelConfig(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, meta.Name)
+			require.Equal(t, tt.want, *conf)
+		})
+	}
+}
+
+func TestParseTunnelConfig_Metadata(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  serverMetadataJSON
+	}{
+		{
+			name:  "access key",
+			input: "ss://YWVzLTEyOC1nY206cHc@example.com:443#Frankfurt%20%F0%9F%87%A9%F0%9F%87%AA",
+			want:  serverMetadataJSON{Name: "Frankfurt 🇩🇪"},
+		},
+		{
+			name: "JSON",
+			input: `{"server": "example.com", "server_port": 443, "method": "aes-128-gcm", "password": "pw",
+				"name": " Frankfurt ", "comment": "Fast, no UDP", "tags": ["eu", " fast", "", "eu"]}`,
+			want: serverMetadataJSON{Name: "Frankfurt", Comment: "Fast, no UDP", Tags: []string{"eu", "fast"}},
+		},
+		{
+			name:  "SIP008 remarks",
+			input: `{"server": "example.com", "server_port": 443, "method": "aes-128-gcm", "password": "pw", "remarks": "Tokyo"}`,
+			want:  serverMetadataJSON{Name: "Tokyo"},
+		},
+		{
+			name: "name over remarks",
+			input: `{"server": "example.com", "server_port": 443, "method": "aes-128-gcm", "password": "pw",
+				"remarks": "Tokyo", "name": "Osaka"}`,
+			want: serverMetadataJSON{Name: "Osaka"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, _, err := parseTunnelConfig(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, meta)
+		})
+	}
+
+	out, err := parseTunnelConfigs(`["{\"server\": \"example.com\", \"server_port\": 443, \"method\": \"aes-128-gcm\", \"password\": \"pw\", \"name\": \"A\", \"tags\": [\"x\"]}"]`)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"index": 0, "name": "A", "tags": ["x"], "transport": {
+		"host": "example.com", "port": 443, "method": "aes-128-gcm", "password": "pw", "prefix": ""},
+		"firstHopTcp": [{"host": "example.com", "port": 443}], "firstHopUdp": [{"host": "example.com", "port": 443}]}]`, out)
+}
+
+func TestParseTunnelConfig_RoundTrip(t *testing.T) {
+	key, err := exportAccessKey(`{"transport":{"host":"example.com","port":443,"method":"aes-128-gcm","password":"pw","prefix":"\u0016\u0003\u0001ÿ"},"name":"Server 1"}`)
+	require.NoError(t, err)
+	meta, conf, err := parseTunnelConfig(key)
+	require.NoError(t, err)
+	require.Equal(t, "Server 1", meta.Name)
+	require.Equal(t, "\u0016\u0003\u0001ÿ", conf.Prefix)
+}
+
+func TestParseTunnelConfig_Errors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"ss://",
+		"ss://bm90IGEga2V5",
+		"ss://YWVzLTEyOC1nY206cHc@example.com/",
+		"ss://YWVzLTEyOC1nY206cHc@example.com:443/?plugin=obfs-local",
+		"ss://dW5zdXBwb3J0ZWQ6cHc@example.com:443/",
+		`{"server": "example.com", "server_port": 443, "method": "aes-128-gcm", "password": "pw", "plugin": "v2ray-plugin"}`,
+		`{"error": {"message": "Your subscription has expired"}}`,
+	} {
+		_, _, err := parseTunnelConfig(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestParseTunnelConfig_ProviderError(t *testing.T) {
+	_, _, err := parseTunnelConfig(`{"error": {"message": "Your subscription has expired", "details": "since May",
+		"messages": {"en": "Your subscription has expired", "fa": "اشتراک شما منقضی شده است", "de": 1},
+		"url": "https://provider.example/renew", "action": "renew"}}`)
+	perr := platerrors.ToPlatformError(err)
+	require.Equal(t, platerrors.IllegalConfig, perr.Code)
+	require.Equal(t, platerrors.ErrorDetails{
+		"message":  "Your subscription has expired",
+		"details":  "since May",
+		"messages": map[string]string{"en": "Your subscription has expired", "fa": "اشتراک شما منقضی شده است"},
+		"url":      "https://provider.example/renew",
+		"action":   "renew",
+	}, perr.Details)
+
+	// Like the app, the invalid optional fields are dropped.
+	for _, providerError := range []string{
+		`{"message": "expired", "messages": "expired", "url": "http://provider.example/renew", "action": "pay"}`,
+		`{"message": "expired", "messages": ["expired"], "url": "javascript:alert(1)", "action": 1}`,
+		`{"message": "expired", "url": 42}`,
+	} {
+		_, _, err := parseTunnelConfig(`{"error": ` + providerError + `}`)
+		perr := platerrors.ToPlatformError(err)
+		require.Equal(t, platerrors.IllegalConfig, perr.Code, providerError)
+		require.Equal(t, platerrors.ErrorDetails{"message": "expired", "details": ""}, perr.Details, providerError)
+	}
+}
+
+func TestParseTunnelConfigs(t *testing.T) {
+	var inputs []string
+	for i := 0; i < 50; i++ {
+		inputs = append(inputs, fmt.Sprintf("ss://YWVzLTEyOC1nY206cHc@example.com:%d/#%d", 1000+i, i))
+	}
+	inputs[7] = "invalid"
+
+	input, err := json.Marshal(inputs)
+	require.NoError(t, err)
+	out, err := parseTunnelConfigs(string(input))
+	require.NoError(t, err)
+	var results []parsedTunnelConfigJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, len(inputs))
+	for i, res := range results {
+		require.Equal(t, i, res.Index)
+		if i == 7 {
+			require.Nil(t, res.Transport)
+			require.Equal(t, platerrors.IllegalConfig, res.Error.Code)
+			continue
+		}
+		require.Nil(t, res.Error)
+		require.Equal(t, fmt.Sprint(i), res.Name)
+		var conf configJSON
+		require.NoError(t, json.Unmarshal(res.Transport, &conf))
+		require.Equal(t, uint16(1000+i), conf.Port)
+	}
+
+	_, err = parseTunnelConfigs("not json")
+	require.Error(t, err)
+}
+
+func TestParseTunnelConfigs_Canonical(t *testing.T) {
+	out, err := parseTunnelConfigs(`{"configs": [
+		"ss://YWVzLTEyOC1nY206cHc@example.com:443",
+		"{\"server_port\": 443, \"password\": \"pw\", \"method\": \"AES-128-GCM\", \"server\": \"example.com\"}",
+		"ss://YWVzLTEyOC1nY206cHc@example.com:443/?prefix=%3C%16"
+	], "canonical": true}`)
+	require.NoError(t, err)
+	var results []parsedTunnelConfigJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 3)
+	require.Equal(t, `{"host":"example.com","method":"aes-128-gcm","password":"pw","port":443}`, results[0].Canonical)
+	require.Equal(t, results[0].Canonical, results[1].Canonical)
+	require.Equal(t, `{"host":"example.com","method":"aes-128-gcm","password":"pw","port":443,"prefix":"<\u0016"}`,
+		results[2].Canonical)
+
+	out, err = parseTunnelConfigs(`["ss://YWVzLTEyOC1nY206cHc@example.com:443"]`)
+	require.NoError(t, err)
+	require.NotContains(t, out, "canonical")
+}
+
+func TestParseTunnelConfigs_FirstHops(t *testing.T) {
+	out, err := parseTunnelConfigs(`["ss://YWVzLTEyOC1nY206cHc@example.com:443", "hysteria2://auth@example.net:443,20000-30000/"]`)
+	require.NoError(t, err)
+	var results []parsedTunnelConfigJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 2)
+	require.Equal(t, []firstHopJSON{{Host: "example.com", Port: 443}}, results[0].FirstHopTCP)
+	require.Equal(t, []firstHopJSON{{Host: "example.com", Port: 443}}, results[0].FirstHopUDP)
+	require.Nil(t, results[1].FirstHopTCP)
+	require.Equal(t, []firstHopJSON{{Host: "example.net"}}, results[1].FirstHopUDP)
+}
+
+func TestConfigJSON_CanonicalJSON(t *testing.T) {
+	conf, err := parseConfigFromJSON(`{
+		"routing": {"rules": [{"action": "direct", "domains": ["example.org"]}]},
+		"port": 8388, "host": "192.0.2.1", "method": "chacha20-ietf-poly1305", "password": "x"
+	}`)
+	require.NoError(t, err)
+	got, err := conf.canonicalJSON()
+	require.NoError(t, err)
+	again, err := parseConfigFromJSON(got)
+	require.NoError(t, err)
+	gotAgain, err := again.canonicalJSON()
+	require.NoError(t, err)
+	require.Equal(t, got, gotAgain)
+	require.Regexp(t, `^\{"host":"192.0.2.1","method":"chacha20-ietf-poly1305","password":"x","port":8388,"routing":\{`, got)
+}
+
+func TestParseTunnelConfig_ErrorPosition(t *testing.T) {
+	_, _, err := parseTunnelConfig("ss://YWVzLTEyOC1nY206cHc@example.com:99999/")
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, 1, perr.Details["line"])
+	require.Equal(t, 38, perr.Details["column"])
+	require.Equal(t, "99999", perr.Details["snippet"])
+
+	_, _, err = parseTunnelConfig("ss://YWVzLTEyOC1nY206cHc@exa%zzmple.com:443/")
+	perr = platerrors.PlatformError{}
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, 29, perr.Details["column"])
+	require.Equal(t, "%zz", perr.Details["snippet"])
+}
+
+// FuzzParseTunnelConfig checks that no tunnel config crashes the parser, that the errors are
+// config errors, and that the configs it accepts are exported to access keys parsed back to
+// configs exported to the same keys. The corpus in testdata/fuzz has malformed keys seen in the wild.
+func FuzzParseTunnelConfig(f *testing.F) {
+	for _, seed := range []string{
+		"ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTphYmNkMTIzNA@192.0.2.1:8080/",
+		"ss://YWVzLTEyOC1nY206cHc=@[2001:db8::1]:443/?prefix=%16%03%01%20a#My%20server",
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0QGV4YW1wbGUuY29tOjQ0Mw#legacy",
+		`{"server":"example.com","server_port":443,"method":"aes-256-gcm","password":"secret","prefix":"\u0016\u0003\u0001"}`,
+		`{"error":{"message":"expired","details":"renew"}}`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		meta, conf, err := parseTunnelConfig(text)
+		if err != nil {
+			require.NotEqual(t, platerrors.InternalError, platerrors.ToPlatformError(err).Code, err.Error())
+			return
+		}
+		key, err := conf.accessKey(meta.Name)
+		if err != nil {
+			return
+		}
+		gotMeta, got, err := parseTunnelConfig(key)
+		require.NoError(t, err, key)
+		require.Equal(t, meta.Name, gotMeta.Name, key)
+		gotKey, err := got.accessKey(gotMeta.Name)
+		require.NoError(t, err, key)
+		require.Equal(t, key, gotKey)
+	})
+}
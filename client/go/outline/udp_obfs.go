@@ -0,0 +1,100 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+const (
+	// udpPaddedSizeLimit is the payload size the packets are not padded beyond, so that the padded
+	// packets still fit in the path MTU.
+	udpPaddedSizeLimit = 1200
+
+	// maxUDPJitter is the largest delay of the packets that can be configured. Longer delays
+	// would break the latency-sensitive traffic, like calls and games.
+	maxUDPJitter = 100 * time.Millisecond
+)
+
+// udpObfsConfigJSON is the "udpObfs" section of the transport config. It randomizes the sizes and
+// the timing of the UDP packets to the proxy server, whose patterns give Shadowsocks away to some
+// DPI systems.
+type udpObfsConfigJSON struct {
+	// MaxPadding is the largest number of random bytes padding a packet. Packets are not padded
+	// beyond 1200 bytes of payload. Only the Shadowsocks 2022 ciphers support padding.
+	MaxPadding int `json:"maxPadding,omitempty"`
+
+	// MaxJitterMs is the largest random delay of a packet, in milliseconds, up to 100.
+	MaxJitterMs int `json:"maxJitterMs,omitempty"`
+}
+
+// validate checks the budgets of the config.
+func (c *udpObfsConfigJSON) validate() error {
+	if c.MaxPadding < 0 || c.MaxPadding > udpPaddedSizeLimit {
+		return newIllegalConfigErrorWithDetails("UDP padding is not valid",
+			"udpObfs.maxPadding", c.MaxPadding, "a number of bytes within range [0..1200]", nil)
+	}
+	if c.MaxJitterMs < 0 || time.Duration(c.MaxJitterMs)*time.Millisecond > maxUDPJitter {
+		return newIllegalConfigErrorWithDetails("UDP jitter is not valid",
+			"udpObfs.maxJitterMs", c.MaxJitterMs, "a number of milliseconds within range [0..100]", nil)
+	}
+	return nil
+}
+
+// padding returns the random padding of a packet with a payload of payloadLen bytes.
+func (c *udpObfsConfigJSON) padding(payloadLen int) int {
+	budget := min(c.MaxPadding, udpPaddedSizeLimit-payloadLen)
+	if budget <= 0 {
+		return 0
+	}
+	return rand.Intn(budget + 1)
+}
+
+// packetListener returns pl delaying the packets written by a random jitter, if any. c may be nil.
+func (c *udpObfsConfigJSON) packetListener(pl transport.PacketListener) transport.PacketListener {
+	if c == nil || c.MaxJitterMs == 0 {
+		return pl
+	}
+	return &jitterPacketListener{PacketListener: pl, maxJitter: time.Duration(c.MaxJitterMs) * time.Millisecond}
+}
+
+// jitterPacketListener delays each packet written by a random duration up to maxJitter.
+type jitterPacketListener struct {
+	transport.PacketListener
+	maxJitter time.Duration
+}
+
+func (l *jitterPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.PacketListener.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &jitterPacketConn{PacketConn: conn, maxJitter: l.maxJitter}, nil
+}
+
+type jitterPacketConn struct {
+	net.PacketConn
+	maxJitter time.Duration
+}
+
+func (c *jitterPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	time.Sleep(time.Duration(rand.Int63n(int64(c.maxJitter) + 1)))
+	return c.PacketConn.WriteTo(b, addr)
+}
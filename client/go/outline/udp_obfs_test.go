@@ -0,0 +1,55 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient_UDPObfs(t *testing.T) {
+	ss2022 := `"host":"example.com","port":443,"method":"2022-blake3-aes-128-gcm","password":"YctPZ6U7xPPcU+gp3u+0tx=="`
+	legacy := `"host":"example.com","port":443,"method":"chacha20-ietf-poly1305","password":"abcd"`
+	for _, config := range []string{
+		`{` + ss2022 + `,"udpObfs":{"maxPadding":256,"maxJitterMs":20}}`,
+		`{` + legacy + `,"udpObfs":{"maxJitterMs":100}}`,
+	} {
+		got := NewClient(config)
+		require.Nil(t, got.Error, config)
+	}
+
+	for _, config := range []string{
+		`{` + legacy + `,"udpObfs":{"maxPadding":256}}`,
+		`{` + ss2022 + `,"udpObfs":{"maxPadding":-1}}`,
+		`{` + ss2022 + `,"udpObfs":{"maxPadding":1201}}`,
+		`{` + ss2022 + `,"udpObfs":{"maxJitterMs":101}}`,
+	} {
+		got := NewClient(config)
+		require.NotNil(t, got.Error, config)
+		require.Equal(t, platerrors.IllegalConfig, got.Error.Code, config)
+	}
+}
+
+func TestUDPObfsConfig_Padding(t *testing.T) {
+	c := &udpObfsConfigJSON{MaxPadding: 100}
+	for i := 0; i < 100; i++ {
+		p := c.padding(10)
+		require.True(t, p >= 0 && p <= 100, p)
+		require.LessOrEqual(t, c.padding(udpPaddedSizeLimit-10), 10)
+		require.Equal(t, 0, c.padding(udpPaddedSizeLimit+1))
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package uot relays UDP packets over a TCP stream to the proxy, for networks or servers that
+// block UDP. It implements the client of version 2 of the UDP-over-TCP protocol of sing-box,
+// which Shadowsocks servers like sing-box and shadowsocks-rust support.
+package uot
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/bufpool"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// MagicAddress is the destination requesting a UDP-over-TCP stream from the server.
+const MagicAddress = "sp.v2.udp-over-tcp.arpa:0"
+
+// Address types of the protocol.
+const (
+	addrTypeIPv4 = 0x00
+	addrTypeIPv6 = 0x01
+	addrTypeFQDN = 0x02
+)
+
+var addrFormat = wire.AddrFormat{IPv4: addrTypeIPv4, IPv6: addrTypeIPv6, Domain: addrTypeFQDN}
+
+const maxPacketSize = 65535
+
+// framePool holds the buffers of the written frames, large enough for a domain name destination
+// and a packet of maxPacketSize.
+var framePool = bufpool.New(1 + 1 + 255 + 2 + 2 + maxPacketSize)
+
+type packetListener struct {
+	sd transport.StreamDialer
+}
+
+// NewPacketListener creates a [transport.PacketListener] relaying the packets of each listened
+// connection over its own stream, dialed with sd.
+func NewPacketListener(sd transport.StreamDialer) transport.PacketListener {
+	return &packetListener{sd: sd}
+}
+
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.sd.DialStream(ctx, MagicAddress)
+	if err != nil {
+		return nil, err
+	}
+	// The request: not connected (each packet carries its destination), and an unused destination.
+	req := []byte{0}
+	req, _ = addrFormat.AppendAddr(req, &net.UDPAddr{IP: net.IPv4zero})
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &packetConn{StreamConn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// packetConn is a [net.PacketConn] framing the packets over a stream as:
+//
+//	[destination address][uint16 big-endian length][payload]
+type packetConn struct {
+	transport.StreamConn
+	r *bufio.Reader
+
+	writeMu sync.Mutex
+	readMu  sync.Mutex
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > maxPacketSize {
+		return 0, fmt.Errorf("packet of %d bytes is too large", len(b))
+	}
+	frameBuf := framePool.Get()
+	defer framePool.Put(frameBuf)
+	frame, err := addrFormat.AppendAddr((*frameBuf)[:0], addr)
+	if err != nil {
+		return 0, err
+	}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(b)))
+	frame = append(frame, b...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.StreamConn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads the next packet. If b is too small, the rest of the packet is discarded.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	addr, err := addrFormat.Read(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(c.r, lenBuf[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf[:]))
+	n, err := io.ReadFull(c.r, b[:min(size, len(b))])
+	if err != nil {
+		return n, addr, err
+	}
+	if _, err := c.r.Discard(size - n); err != nil {
+		return n, addr, err
+	}
+	return n, addr, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uot
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/internal/wire"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// serveEcho accepts a UDP-over-TCP stream and echoes its packets to their destinations back.
+func serveEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	isConnect, err := r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte(0), isConnect)
+	_, err = addrFormat.Read(r)
+	require.NoError(t, err)
+
+	pc := &packetConn{r: r}
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err)
+		frame, err := addrFormat.AppendAddr(nil, addr)
+		require.NoError(t, err)
+		frame = append(frame, byte(n>>8), byte(n))
+		_, err = conn.Write(append(frame, buf[:n]...))
+		require.NoError(t, err)
+	}
+}
+
+func TestPacketListener(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			serveEcho(t, conn)
+		}
+	}()
+	var dialed string
+	sd := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		dialed = addr
+		return (&transport.TCPDialer{}).DialStream(ctx, listener.Addr().String())
+	})
+
+	conn, err := NewPacketListener(sd).ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, MagicAddress, dialed)
+
+	buf := make([]byte, 16)
+	for _, dest := range []net.Addr{
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+		&wire.DomainAddr{Host: "example.com", Port: 8080},
+	} {
+		n, err := conn.WriteTo([]byte("hello"), dest)
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+
+		n, addr, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+		require.Equal(t, dest.String(), addr.String())
+	}
+
+	// Packets larger than the buffer are truncated, and the next packet is read correctly.
+	_, err = conn.WriteTo([]byte("a long packet"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53})
+	require.NoError(t, err)
+	_, err = conn.WriteTo([]byte("short"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53})
+	require.NoError(t, err)
+	n, _, err := conn.ReadFrom(buf[:6])
+	require.NoError(t, err)
+	require.Equal(t, "a long", string(buf[:n]))
+	n, _, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "short", string(buf[:n]))
+}
+
+// discardStreamConn is a [transport.StreamConn] discarding the written data.
+type discardStreamConn struct {
+	transport.StreamConn
+}
+
+func (discardStreamConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func BenchmarkPacketConn_WriteTo(b *testing.B) {
+	conn := &packetConn{StreamConn: discardStreamConn{}}
+	dest := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+	payload := make([]byte, 1200)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.WriteTo(payload, dest); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
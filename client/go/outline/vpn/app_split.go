@@ -0,0 +1,57 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import "fmt"
+
+// AppSplitTunnelMode tells whether the applications of an [AppSplitTunnelConfig] are kept out of
+// the VPN, or are the only ones using it.
+type AppSplitTunnelMode string
+
+const (
+	// AppSplitTunnelExclude routes the listed applications outside of the VPN.
+	AppSplitTunnelExclude AppSplitTunnelMode = "exclude"
+
+	// AppSplitTunnelInclude routes only the listed applications through the VPN.
+	AppSplitTunnelInclude AppSplitTunnelMode = "include"
+)
+
+// AppSplitTunnelConfig selects the applications whose traffic bypasses (or exclusively uses) the
+// VPN. How applications are identified depends on the platform:
+//   - Linux: user names, UIDs like "1000", or UID ranges like "1000-1999".
+//
+// The other platforms fail to establish the VPN with the FeatureNotSupported error code: the Go
+// code of Android apps can't add the UID routing rules, which only the VpnService of the app
+// controls, and macOS has no per-application routing for utun devices.
+type AppSplitTunnelConfig struct {
+	Mode AppSplitTunnelMode `json:"mode"`
+	Apps []string           `json:"apps"`
+}
+
+// validate checks the platform-independent parts of the config.
+func (c *AppSplitTunnelConfig) validate() error {
+	if c.Mode != AppSplitTunnelExclude && c.Mode != AppSplitTunnelInclude {
+		return errIllegalConfig("app split tunnel mode must be exclude or include", "mode", c.Mode)
+	}
+	if len(c.Apps) == 0 {
+		return errIllegalConfig("app split tunnel must list at least one app")
+	}
+	for i, app := range c.Apps {
+		if app == "" {
+			return errIllegalConfig("app split tunnel app must not be empty", "app", fmt.Sprintf("apps[%d]", i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// uidRange is an inclusive range of Linux user IDs, which is how Linux policy routing identifies
+// the owner of a socket.
+type uidRange struct {
+	start, end uint32
+}
+
+// parseAppUIDRanges converts the apps of conf to UID ranges. Apps can be user names, UIDs or
+// UID ranges.
+func parseAppUIDRanges(conf *AppSplitTunnelConfig) ([]uidRange, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	ranges := make([]uidRange, 0, len(conf.Apps))
+	for _, app := range conf.Apps {
+		r, err := parseUIDRange(app)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseUIDRange(app string) (uidRange, error) {
+	if first, last, ok := strings.Cut(app, "-"); ok {
+		start, err1 := strconv.ParseUint(first, 10, 32)
+		end, err2 := strconv.ParseUint(last, 10, 32)
+		if err1 != nil || err2 != nil || end < start {
+			return uidRange{}, errIllegalConfig("app split tunnel UID range is not valid", "app", app)
+		}
+		return uidRange{uint32(start), uint32(end)}, nil
+	}
+	if uid, err := strconv.ParseUint(app, 10, 32); err == nil {
+		return uidRange{uint32(uid), uint32(uid)}, nil
+	}
+	u, err := user.Lookup(app)
+	if err != nil {
+		return uidRange{}, errIllegalConfig("app split tunnel user does not exist", "app", app)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return uidRange{}, errIllegalConfig("app split tunnel user has no numeric UID", "app", app)
+	}
+	return uidRange{uint32(uid), uint32(uid)}, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseAppUIDRanges(t *testing.T) {
+	ranges, err := parseAppUIDRanges(&AppSplitTunnelConfig{
+		Mode: AppSplitTunnelExclude,
+		Apps: []string{"1000", "2000-2999", "root"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uidRange{{1000, 1000}, {2000, 2999}, {0, 0}}, ranges)
+
+	for _, conf := range []*AppSplitTunnelConfig{
+		{Mode: "bypass", Apps: []string{"1000"}},
+		{Mode: AppSplitTunnelInclude},
+		{Mode: AppSplitTunnelInclude, Apps: []string{"2999-2000"}},
+		{Mode: AppSplitTunnelInclude, Apps: []string{"no-such-user-for-outline-test"}},
+	} {
+		_, err := parseAppUIDRanges(conf)
+		require.Error(t, err, conf)
+	}
+}
+
+func TestNewRoutingRules(t *testing.T) {
+	opts := &nmConnectionOptions{FWMark: 0x711E, RoutingTable: 113, RoutingPriority: 456}
+	rules := nmRoutingRules(newRoutingRules(opts, unix.AF_INET))
+	require.Len(t, rules, 1)
+	require.Equal(t, true, rules[0]["invert"])
+
+	opts.AppSplitMode = AppSplitTunnelExclude
+	opts.AppUIDRanges = []uidRange{{1000, 1000}}
+	rules = nmRoutingRules(newRoutingRules(opts, unix.AF_INET))
+	require.Len(t, rules, 2)
+	require.Equal(t, uint32(unix.RT_TABLE_MAIN), rules[0]["table"])
+	require.Equal(t, uint32(455), rules[0]["priority"])
+	require.Equal(t, uint32(1000), rules[0]["uid-range-start"])
+	require.Equal(t, true, rules[1]["invert"])
+
+	opts.AppSplitMode = AppSplitTunnelInclude
+	rules = nmRoutingRules(newRoutingRules(opts, unix.AF_INET))
+	require.Len(t, rules, 2)
+	require.Equal(t, uint32(0x711E), rules[0]["fwmark"])
+	require.Equal(t, uint32(unix.RT_TABLE_MAIN), rules[0]["table"])
+	require.Equal(t, uint32(113), rules[1]["table"])
+	require.Equal(t, uint32(1000), rules[1]["uid-range-end"])
+}
@@ -18,10 +18,16 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Jigsaw-Code/outline-apps/client/go/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/event"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/nat"
 	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/stats"
 	"github.com/Jigsaw-Code/outline-sdk/network"
 	"github.com/Jigsaw-Code/outline-sdk/network/dnstruncate"
 	"github.com/Jigsaw-Code/outline-sdk/network/lwip2transport"
@@ -32,15 +38,49 @@ import (
 type RemoteDevice struct {
 	io.ReadWriteCloser
 
+	// mu guards the transport, which [RemoteDevice.ReplaceTransport] can replace. It is not held
+	// while checking the connectivity of the transport.
+	mu sync.Mutex
 	sd transport.StreamDialer
 	pl transport.PacketListener
+	// generation is incremented when the transport is replaced, so that the result of a check of
+	// the previous transport is discarded.
+	generation uint64
 
+	dialer           *delegateStreamDialer
+	dns              network.DelegatePacketProxy // The DNS forwarder, if any, in front of pkt.
 	pkt              network.DelegatePacketProxy
 	remote, fallback network.PacketProxy
+	supportsUDP      bool
+	overTCP          bool // Whether fallback relays UDP over TCP.
+
+	// blocked drops the new connections and UDP sessions, see [RemoteDevice.SetBlocked].
+	blocked atomic.Bool
+
+	// udpIdleTimeout is how long the UDP sessions are kept without outgoing traffic, if positive.
+	udpIdleTimeout time.Duration
+
+	// nat limits the number of UDP sessions, evicting the least recently used ones.
+	nat *nat.Table
+
+	// sessions are the live connections and UDP sessions, see
+	// [RemoteDevice.ResumeAfterNetworkChange].
+	sessions *sessionTracker
+
+	stats *stats.Session
 }
 
+// ConnectRemoteDevice creates a [RemoteDevice] relaying the traffic through sd and pl, once the
+// server is reachable. The DNS queries are answered by dnsForwarder if it is not nil. The UDP
+// traffic is relayed with udpFallback, if it is not nil, when pl cannot reach the server. The UDP
+// sessions are removed after udpIdleTimeout without outgoing traffic, or 30 seconds if it is not
+// positive, and the least recently used ones are evicted when there are more than udpMaxSessions,
+// or [nat.DefaultMaxSessions] if it is not positive. The packets are handled by stack, or by lwIP
+// if it is nil.
 func ConnectRemoteDevice(
 	ctx context.Context, sd transport.StreamDialer, pl transport.PacketListener,
+	dnsForwarder *dnsintercept.Forwarder, udpFallback transport.PacketListener,
+	udpIdleTimeout time.Duration, udpMaxSessions int, stack NetworkStack,
 ) (_ *RemoteDevice, err error) {
 	if sd == nil {
 		return nil, errors.New("StreamDialer must be provided")
@@ -52,62 +92,229 @@ func ConnectRemoteDevice(
 		return nil, errCancelled(ctx.Err())
 	}
 
-	dev := &RemoteDevice{sd: sd, pl: pl}
-
-	dev.remote, err = network.NewPacketProxyFromPacketListener(pl)
-	if err != nil {
-		return nil, errSetupHandler("failed to create remote UDP handler", err)
+	dev := &RemoteDevice{
+		sd: sd, pl: pl, udpIdleTimeout: udpIdleTimeout, sessions: newSessionTracker(), stats: stats.StartSession(),
 	}
-	slog.Debug("remote device remote UDP handler created")
+	defer func() {
+		if err != nil {
+			stats.EndSession(dev.stats)
+		}
+	}()
 
-	if dev.fallback, err = dnstruncate.NewPacketProxy(); err != nil {
-		return nil, errSetupHandler("failed to create UDP handler for DNS-fallback", err)
+	if dev.remote, dev.fallback, dev.overTCP, err = dev.newPacketProxies(pl, udpFallback); err != nil {
+		return nil, err
 	}
-	slog.Debug("remote device local DNS-fallback UDP handler created")
 
 	if err = dev.RefreshConnectivity(ctx); err != nil {
 		return
 	}
 
-	dev.ReadWriteCloser, err = lwip2transport.ConfigureDevice(sd, dev.pkt)
+	if dev.dns, err = network.NewDelegatePacketProxy(dev.pkt); err != nil {
+		return nil, errSetupHandler("failed to create DNS datagram handler", err)
+	}
+	if err = dev.setDNSForwarder(dnsForwarder); err != nil {
+		return nil, err
+	}
+	dev.dialer = &delegateStreamDialer{sd: dev.stats.StreamDialer(sd), blocked: &dev.blocked, sessions: dev.sessions}
+	dev.nat = dev.stats.NewNATTable(udpMaxSessions)
+	pkt := &blockablePacketProxy{PacketProxy: &natPacketProxy{PacketProxy: dev.dns, table: dev.nat}, blocked: &dev.blocked}
+	if stack == nil {
+		stack = lwip2transport.ConfigureDevice
+	}
+	dev.ReadWriteCloser, err = stack(dev.dialer, pkt)
 	if err != nil {
 		return nil, errSetupHandler("remote device failed to configure network stack", err)
 	}
-	slog.Debug("remote device lwIP network stack configured")
+	logger.Debug("remote device network stack configured")
 
 	return dev, nil
 }
 
+// newPacketProxies creates the UDP handlers relaying through pl, and the fallback UDP handler
+// for when pl cannot reach the server, which relays through udpFallback if it is not nil.
+func (dev *RemoteDevice) newPacketProxies(pl, udpFallback transport.PacketListener) (remote, fallback network.PacketProxy, overTCP bool, err error) {
+	if remote, err = dev.newPacketListenerProxy(pl); err != nil {
+		return nil, nil, false, errSetupHandler("failed to create remote UDP handler", err)
+	}
+	logger.Debug("remote device remote UDP handler created")
+
+	if udpFallback != nil {
+		if fallback, err = dev.newPacketListenerProxy(udpFallback); err != nil {
+			return nil, nil, false, errSetupHandler("failed to create UDP handler for UDP-over-TCP fallback", err)
+		}
+		logger.Debug("remote device UDP-over-TCP fallback UDP handler created")
+		return remote, fallback, true, nil
+	}
+	if fallback, err = dnstruncate.NewPacketProxy(); err != nil {
+		return nil, nil, false, errSetupHandler("failed to create UDP handler for DNS-fallback", err)
+	}
+	logger.Debug("remote device local DNS-fallback UDP handler created")
+	return remote, fallback, false, nil
+}
+
+// newPacketListenerProxy creates a UDP handler relaying through pl. Its UDP sessions are counted
+// in the statistics, and can be moved to a new network.
+func (dev *RemoteDevice) newPacketListenerProxy(pl transport.PacketListener) (network.PacketProxy, error) {
+	var options []func(*network.PacketListenerProxy) error
+	if dev.udpIdleTimeout > 0 {
+		options = append(options, network.WithPacketListenerWriteIdleTimeout(dev.udpIdleTimeout))
+	}
+	return network.NewPacketProxyFromPacketListener(
+		&resumablePacketListener{pl: dev.stats.PacketListener(pl), tracker: dev.sessions}, options...)
+}
+
+// setDNSForwarder makes dnsForwarder answer the DNS queries, or relays them if it is nil.
+func (dev *RemoteDevice) setDNSForwarder(dnsForwarder *dnsintercept.Forwarder) error {
+	var pkt network.PacketProxy = dev.pkt
+	if dnsForwarder != nil {
+		pkt = dnsForwarder.PacketProxy(pkt)
+		dev.stats.SetDNSCache(dnsForwarder)
+		logger.Debug("remote device DNS queries are intercepted")
+	} else {
+		dev.stats.SetDNSCache(nil)
+	}
+	if err := dev.dns.SetProxy(pkt); err != nil {
+		return errSetupHandler("failed to update DNS datagram handler", err)
+	}
+	return nil
+}
+
+// ReplaceTransport makes the device relay the new connections and UDP sessions through sd and
+// pl, like [ConnectRemoteDevice], without closing the device. The existing TCP connections and
+// UDP sessions keep using the previous transport until they are closed. If the new server is
+// not reachable, the device keeps the previous transport and returns an error.
+func (dev *RemoteDevice) ReplaceTransport(
+	ctx context.Context, sd transport.StreamDialer, pl transport.PacketListener,
+	dnsForwarder *dnsintercept.Forwarder, udpFallback transport.PacketListener,
+) error {
+	if sd == nil {
+		return errors.New("StreamDialer must be provided")
+	}
+	if pl == nil {
+		return errors.New("PacketListener must be provided")
+	}
+	if ctx.Err() != nil {
+		return errCancelled(ctx.Err())
+	}
+	remote, fallback, overTCP, err := dev.newPacketProxies(pl, udpFallback)
+	if err != nil {
+		return err
+	}
+	tcpErr, udpErr := checkConnectivity(ctx, sd, pl)
+	if ctx.Err() != nil {
+		return errCancelled(ctx.Err())
+	}
+	if tcpErr != nil {
+		logger.Warn("remote device server connectivity test failed", "err", tcpErr)
+		return tcpErr
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	prevSD, prevPL, prevRemote, prevFallback, prevOverTCP := dev.sd, dev.pl, dev.remote, dev.fallback, dev.overTCP
+	dev.sd, dev.pl, dev.remote, dev.fallback, dev.overTCP = sd, pl, remote, fallback, overTCP
+	if err := dev.applyConnectivity(udpErr); err != nil {
+		dev.sd, dev.pl, dev.remote, dev.fallback, dev.overTCP = prevSD, prevPL, prevRemote, prevFallback, prevOverTCP
+		return err
+	}
+	dev.generation++
+	dev.dialer.set(dev.stats.StreamDialer(sd))
+	if err := dev.setDNSForwarder(dnsForwarder); err != nil {
+		return err
+	}
+	logger.Info("remote device transport replaced")
+	return nil
+}
+
+// SetBlocked makes the device drop the new TCP connections and UDP sessions, including the
+// ones routed outside of the proxy, while blocked is true. It keeps capturing the traffic, so
+// that it doesn't leak outside of the VPN while the server is unreachable (kill switch).
+func (dev *RemoteDevice) SetBlocked(blocked bool) {
+	if dev.blocked.Swap(blocked) != blocked {
+		logger.Info("remote device traffic blocking changed", "blocked", blocked)
+	}
+}
+
+// ResumeAfterNetworkChange moves the traffic to the network the device just switched to. The TCP
+// connections, which are bound to the previous network, are closed so that the apps reconnect
+// right away instead of waiting for them to time out. The UDP sessions are re-dialed, keeping
+// their NAT mappings in the network stack, so the apps don't notice the change.
+//
+// The server host name is resolved again by the new connections. The caller should refresh the
+// connectivity afterwards, see [RemoteDevice.RefreshConnectivity].
+func (dev *RemoteDevice) ResumeAfterNetworkChange(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return errCancelled(ctx.Err())
+	}
+	streams := dev.sessions.closeStreams()
+	packets := dev.sessions.rebindPackets(ctx)
+	logger.Info("remote device resumed after network change", "closedTCP", streams, "resumedUDP", packets)
+	return nil
+}
+
 // Close closes the connection to the Outline server.
 func (dev *RemoteDevice) Close() (err error) {
+	stats.EndSession(dev.stats)
 	if dev.ReadWriteCloser != nil {
 		err = dev.ReadWriteCloser.Close()
 	}
 	return
 }
 
-// RefreshConnectivity refreshes the connectivity to the Outline server.
+// RefreshConnectivity refreshes the connectivity to the Outline server. It returns early, with an
+// [perrs.OperationCanceled] error, once ctx is done.
 func (d *RemoteDevice) RefreshConnectivity(ctx context.Context) (err error) {
 	if ctx.Err() != nil {
 		return errCancelled(ctx.Err())
 	}
+	d.mu.Lock()
+	sd, pl, generation := d.sd, d.pl, d.generation
+	d.mu.Unlock()
 
-	slog.Debug("remote device is testing connectivity of server...")
-	tcpErr, udpErr := connectivity.CheckTCPAndUDPConnectivity(d.sd, d.pl)
+	tcpErr, udpErr := checkConnectivity(ctx, sd, pl)
+	if ctx.Err() != nil {
+		return errCancelled(ctx.Err())
+	}
 	if tcpErr != nil {
-		slog.Warn("remote device server connectivity test failed", "err", tcpErr)
+		logger.Warn("remote device server connectivity test failed", "err", tcpErr)
 		return tcpErr
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.generation != generation {
+		// The transport was replaced during the check, and the replacement checked the new one.
+		return nil
+	}
+	return d.applyConnectivity(udpErr)
+}
+
+// checkConnectivity checks whether sd and pl can relay traffic, in parallel. It returns early once
+// ctx is done.
+func checkConnectivity(ctx context.Context, sd transport.StreamDialer, pl transport.PacketListener) (tcpErr, udpErr error) {
+	logger.Debug("remote device is testing connectivity of server...")
+	udpErrCh := make(chan error, 1)
+	go func() {
+		udpErrCh <- connectivity.CheckUDPConnectivity(ctx, pl)
+	}()
+	tcpErr = connectivity.CheckTCPConnectivity(ctx, sd)
+	return tcpErr, <-udpErrCh
+}
+
+// applyConnectivity relays the UDP traffic through the proxy, or through the fallback if udpErr
+// is not nil, i.e. if the server cannot handle UDP traffic. d.mu must be held.
+func (d *RemoteDevice) applyConnectivity(udpErr error) (err error) {
 	var proxy network.PacketProxy
 	if udpErr != nil {
-		slog.Warn("remote device server cannot handle UDP traffic", "err", udpErr)
+		logger.Warn("remote device server cannot handle UDP traffic", "err", udpErr)
 		proxy = d.fallback
 	} else {
-		slog.Debug("remote device server can handle UDP traffic")
+		logger.Debug("remote device server can handle UDP traffic")
 		proxy = d.remote
 	}
 
+	supportsUDP := proxy == d.remote
+	changed := d.pkt == nil || supportsUDP != d.supportsUDP
 	if d.pkt == nil {
 		if d.pkt, err = network.NewDelegatePacketProxy(proxy); err != nil {
 			return errSetupHandler("failed to create combined datagram handler", err)
@@ -117,13 +324,66 @@ func (d *RemoteDevice) RefreshConnectivity(ctx context.Context) (err error) {
 			return errSetupHandler("failed to update combined datagram handler", err)
 		}
 	}
+	d.supportsUDP = supportsUDP
+	if changed {
+		event.Emit(event.UDPSupportChanged, event.UDPSupportChangedData{
+			SupportsUDP: supportsUDP,
+			OverTCP:     !supportsUDP && d.overTCP,
+		})
+	}
 
-	slog.Info("remote device server connectivity test done", "supportsUDP", proxy == d.remote)
+	logger.Info("remote device server connectivity test done", "supportsUDP", supportsUDP)
 	return nil
 }
 
+// errBlocked is the error of the connections dropped by [RemoteDevice.SetBlocked].
+var errBlocked = errors.New("traffic is blocked while the server is unreachable")
+
+// delegateStreamDialer dials with a [transport.StreamDialer] that can be replaced. The
+// connections already dialed are not affected. It fails while blocked is set, and registers
+// the connections it dials in sessions.
+type delegateStreamDialer struct {
+	mu       sync.RWMutex
+	sd       transport.StreamDialer
+	blocked  *atomic.Bool
+	sessions *sessionTracker
+}
+
+func (d *delegateStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	if d.blocked.Load() {
+		return nil, errBlocked
+	}
+	d.mu.RLock()
+	sd := d.sd
+	d.mu.RUnlock()
+	conn, err := sd.DialStream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.sessions.trackStream(conn), nil
+}
+
+func (d *delegateStreamDialer) set(sd transport.StreamDialer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sd = sd
+}
+
+// blockablePacketProxy is a [network.PacketProxy] refusing the new sessions while blocked is set.
+type blockablePacketProxy struct {
+	network.PacketProxy
+	blocked *atomic.Bool
+}
+
+func (p *blockablePacketProxy) NewSession(r network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	if p.blocked.Load() {
+		return nil, errBlocked
+	}
+	return p.PacketProxy.NewSession(r)
+}
+
 func errSetupHandler(msg string, cause error) error {
-	slog.Error(msg, "err", cause)
+	logger.Error(msg, "err", cause)
 	return perrs.PlatformError{
 		Code:    perrs.SetupTrafficHandlerFailed,
 		Message: msg,
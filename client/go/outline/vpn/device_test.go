@@ -0,0 +1,65 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingTransport never connects, until the context of the dial is done.
+type hangingTransport struct {
+	dialing chan struct{}
+}
+
+func (h *hangingTransport) DialStream(ctx context.Context, _ string) (transport.StreamConn, error) {
+	h.dialing <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (h *hangingTransport) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRemoteDevice_RefreshConnectivityCanceled(t *testing.T) {
+	h := &hangingTransport{dialing: make(chan struct{}, 1)}
+	dev := &RemoteDevice{sd: h, pl: h}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- dev.RefreshConnectivity(ctx) }()
+
+	<-h.dialing
+	// The lock is not held during the check.
+	require.True(t, dev.mu.TryLock())
+	dev.mu.Unlock()
+
+	cancel()
+	select {
+	case err := <-done:
+		var perr perrs.PlatformError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, perrs.OperationCanceled, perr.Code)
+	case <-time.After(time.Second):
+		t.Fatal("RefreshConnectivity didn't return once canceled")
+	}
+}
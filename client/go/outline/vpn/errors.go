@@ -15,13 +15,11 @@
 package vpn
 
 import (
-	"log/slog"
-
 	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
 )
 
 func errCancelled(cause error) error {
-	slog.Warn("operation was cancelled", "cause", cause)
+	logger.Warn("operation was cancelled", "cause", cause)
 	return perrs.PlatformError{
 		Code:  perrs.OperationCanceled,
 		Cause: perrs.ToPlatformError(cause),
@@ -32,6 +30,10 @@ func errIllegalConfig(msg string, params ...any) error {
 	return errPlatError(perrs.IllegalConfig, msg, nil, params...)
 }
 
+func errFeatureNotSupported(msg string, params ...any) error {
+	return errPlatError(perrs.FeatureNotSupported, msg, nil, params...)
+}
+
 func errSetupVPN(msg string, cause error, params ...any) error {
 	return errPlatError(perrs.SetupSystemVPNFailed, msg, cause, params...)
 }
@@ -42,7 +44,7 @@ func errCloseVPN(msg string, cause error, params ...any) error {
 
 func errPlatError(code perrs.ErrorCode, msg string, cause error, params ...any) error {
 	logParams := append(params, "err", cause)
-	slog.Error(msg, logParams...)
+	logger.Error(msg, logParams...)
 
 	details := perrs.ErrorDetails{}
 	for i := 1; i < len(params); i += 2 {
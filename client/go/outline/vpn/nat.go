@@ -0,0 +1,81 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/nat"
+	"github.com/Jigsaw-Code/outline-sdk/network"
+)
+
+// natPacketProxy is a [network.PacketProxy] registering the sessions of its PacketProxy in a
+// NAT table, which closes the least recently used ones when it's full.
+type natPacketProxy struct {
+	network.PacketProxy
+	table *nat.Table
+}
+
+func (p *natPacketProxy) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	s := &natSession{}
+	sender, err := p.PacketProxy.NewSession(&natResponseReceiver{PacketResponseReceiver: resp, session: s})
+	if err != nil {
+		return nil, err
+	}
+	s.PacketRequestSender = sender
+	entry := p.table.Add(func() { s.Close() })
+	s.entry.Store(entry)
+	// The PacketProxy may have closed the session already.
+	if s.closed.Load() {
+		entry.Remove()
+	}
+	return s, nil
+}
+
+// natSession is a UDP session in a NAT table.
+type natSession struct {
+	network.PacketRequestSender
+	entry  atomic.Pointer[nat.Entry]
+	closed atomic.Bool // Whether the PacketProxy closed the session.
+}
+
+func (s *natSession) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	s.entry.Load().Touch()
+	return s.PacketRequestSender.WriteTo(p, destination)
+}
+
+func (s *natSession) Close() error {
+	// The entry is not stored yet if it's evicted right after being added.
+	if entry := s.entry.Load(); entry != nil {
+		entry.Remove()
+	}
+	return s.PacketRequestSender.Close()
+}
+
+// natResponseReceiver removes its session from the NAT table when the PacketProxy closes it,
+// e.g. after the idle timeout.
+type natResponseReceiver struct {
+	network.PacketResponseReceiver
+	session *natSession
+}
+
+func (r *natResponseReceiver) Close() error {
+	r.session.closed.Store(true)
+	if entry := r.session.entry.Load(); entry != nil {
+		entry.Remove()
+	}
+	return r.PacketResponseReceiver.Close()
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/nat"
+	"github.com/Jigsaw-Code/outline-sdk/network"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePacketProxy creates sessions recording whether they are closed.
+type fakePacketProxy struct {
+	senders   []*fakeSender
+	receivers []network.PacketResponseReceiver
+}
+
+type fakeSender struct {
+	closed bool
+}
+
+func (s *fakeSender) WriteTo(p []byte, _ netip.AddrPort) (int, error) { return len(p), nil }
+func (s *fakeSender) Close() error                                    { s.closed = true; return nil }
+
+func (p *fakePacketProxy) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	s := &fakeSender{}
+	p.senders = append(p.senders, s)
+	p.receivers = append(p.receivers, resp)
+	return s, nil
+}
+
+type nopReceiver struct{}
+
+func (nopReceiver) WriteFrom(p []byte, _ net.Addr) (int, error) { return len(p), nil }
+func (nopReceiver) Close() error                                { return nil }
+
+func TestNATPacketProxy_EvictsLeastRecentlyUsed(t *testing.T) {
+	var evictions int
+	base := &fakePacketProxy{}
+	table := nat.NewTable(2, func() { evictions++ })
+	proxy := &natPacketProxy{PacketProxy: base, table: table}
+	dest := netip.MustParseAddrPort("192.0.2.1:443")
+
+	first, err := proxy.NewSession(nopReceiver{})
+	require.NoError(t, err)
+	_, err = proxy.NewSession(nopReceiver{})
+	require.NoError(t, err)
+	_, err = first.WriteTo([]byte("ping"), dest)
+	require.NoError(t, err)
+
+	_, err = proxy.NewSession(nopReceiver{})
+	require.NoError(t, err)
+	require.False(t, base.senders[0].closed)
+	require.True(t, base.senders[1].closed)
+	require.False(t, base.senders[2].closed)
+	require.Equal(t, 1, evictions)
+	require.Equal(t, 2, table.Len())
+}
+
+func TestNATPacketProxy_RemovesClosedSessions(t *testing.T) {
+	base := &fakePacketProxy{}
+	table := nat.NewTable(2, nil)
+	proxy := &natPacketProxy{PacketProxy: base, table: table}
+
+	session, err := proxy.NewSession(nopReceiver{})
+	require.NoError(t, err)
+	_, err = proxy.NewSession(nopReceiver{})
+	require.NoError(t, err)
+	require.Equal(t, 2, table.Len())
+
+	// Closed by the application.
+	require.NoError(t, session.Close())
+	require.Equal(t, 1, table.Len())
+
+	// Closed by the PacketProxy, e.g. after the idle timeout.
+	require.NoError(t, base.receivers[1].Close())
+	require.Equal(t, 0, table.Len())
+}
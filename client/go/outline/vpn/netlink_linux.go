@@ -0,0 +1,162 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtnetlink configures the links, addresses, routes and rules of the system through a netlink
+// route socket, like "ip link", "ip address", "ip route" and "ip rule".
+type rtnetlink struct {
+	fd  int
+	seq uint32
+}
+
+func openRTNetlink() (*rtnetlink, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &rtnetlink{fd: fd}, nil
+}
+
+func (n *rtnetlink) Close() error {
+	return unix.Close(n.fd)
+}
+
+// setLinkUp brings up the link of ifindex.
+func (n *rtnetlink) setLinkUp(ifindex int) error {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(msg[4:], uint32(ifindex))
+	binary.NativeEndian.PutUint32(msg[8:], unix.IFF_UP)  // Flags
+	binary.NativeEndian.PutUint32(msg[12:], unix.IFF_UP) // Change
+	return n.request(unix.RTM_NEWLINK, 0, msg)
+}
+
+// addAddress adds the host address ip to the link of ifindex.
+func (n *rtnetlink) addAddress(ifindex int, ip net.IP) error {
+	family, ip := ipFamily(ip)
+	msg := []byte{byte(family), byte(len(ip) * 8), 0, unix.RT_SCOPE_UNIVERSE, 0, 0, 0, 0}
+	binary.NativeEndian.PutUint32(msg[4:], uint32(ifindex))
+	msg = appendAttr(msg, unix.IFA_LOCAL, ip)
+	msg = appendAttr(msg, unix.IFA_ADDRESS, ip)
+	return n.request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, msg)
+}
+
+// addDefaultRoute routes all the traffic of family to the link of ifindex in table.
+func (n *rtnetlink) addDefaultRoute(ifindex int, family int, table uint32) error {
+	msg := []byte{byte(family), 0, 0, 0, 0, unix.RTPROT_STATIC, unix.RT_SCOPE_LINK, unix.RTN_UNICAST, 0, 0, 0, 0}
+	msg = appendAttr(msg, unix.RTA_TABLE, binary.NativeEndian.AppendUint32(nil, table))
+	msg = appendAttr(msg, unix.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(ifindex)))
+	return n.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, msg)
+}
+
+// addRule adds the policy routing rule r.
+func (n *rtnetlink) addRule(r routingRule) error {
+	return n.request(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, encodeRule(r))
+}
+
+// deleteRule deletes the policy routing rule r. It fails with ENOENT if there is no such rule.
+func (n *rtnetlink) deleteRule(r routingRule) error {
+	return n.request(unix.RTM_DELRULE, 0, encodeRule(r))
+}
+
+// encodeRule encodes r as a fib_rule_hdr followed by its attributes.
+func encodeRule(r routingRule) []byte {
+	msg := []byte{byte(r.family), 0, 0, 0, 0, 0, 0, unix.FR_ACT_TO_TBL, 0, 0, 0, 0}
+	if r.invert {
+		binary.NativeEndian.PutUint32(msg[8:], unix.FIB_RULE_INVERT)
+	}
+	msg = appendAttr(msg, unix.FRA_PRIORITY, binary.NativeEndian.AppendUint32(nil, r.priority))
+	msg = appendAttr(msg, unix.FRA_TABLE, binary.NativeEndian.AppendUint32(nil, r.table))
+	if r.fwmark != 0 {
+		msg = appendAttr(msg, unix.FRA_FWMARK, binary.NativeEndian.AppendUint32(nil, r.fwmark))
+		msg = appendAttr(msg, unix.FRA_FWMASK, binary.NativeEndian.AppendUint32(nil, 0xFFFFFFFF))
+	}
+	if r.uids != nil {
+		uids := binary.NativeEndian.AppendUint32(nil, r.uids.start)
+		msg = appendAttr(msg, unix.FRA_UID_RANGE, binary.NativeEndian.AppendUint32(uids, r.uids.end))
+	}
+	return msg
+}
+
+// request sends a request of type typ with body, and waits for its acknowledgement.
+func (n *rtnetlink) request(typ uint16, flags uint16, body []byte) error {
+	n.seq++
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(body))
+	binary.NativeEndian.PutUint32(msg[0:], uint32(unix.SizeofNlMsghdr+len(body)))
+	binary.NativeEndian.PutUint16(msg[4:], typ)
+	binary.NativeEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK|flags)
+	binary.NativeEndian.PutUint32(msg[8:], n.seq)
+	msg = append(msg, body...)
+	if err := unix.Sendto(n.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		nr, _, err := unix.Recvfrom(n.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:nr])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != n.seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return errors.New("truncated netlink acknowledgement")
+			}
+			// The error is a negative errno, or 0 for an acknowledgement.
+			if code := int32(binary.NativeEndian.Uint32(m.Data)); code != 0 {
+				return fmt.Errorf("netlink request %d failed: %w", typ, unix.Errno(-code))
+			}
+			return nil
+		}
+	}
+}
+
+// appendAttr appends the route attribute typ with data to msg, padded to 4 bytes.
+func appendAttr(msg []byte, typ uint16, data []byte) []byte {
+	msg = binary.NativeEndian.AppendUint16(msg, uint16(unix.SizeofRtAttr+len(data)))
+	msg = binary.NativeEndian.AppendUint16(msg, typ)
+	msg = append(msg, data...)
+	for len(msg)%unix.NLMSG_ALIGNTO != 0 {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+// ipFamily returns the address family of ip, and ip in the length of that family.
+func ipFamily(ip net.IP) (int, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return unix.AF_INET, ip4
+	}
+	return unix.AF_INET6, ip.To16()
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// parseAttrs parses the route attributes of msg into a map from their type to their data.
+func parseAttrs(t *testing.T, msg []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(msg) > 0 {
+		require.GreaterOrEqual(t, len(msg), unix.SizeofRtAttr)
+		size := int(binary.NativeEndian.Uint16(msg))
+		typ := binary.NativeEndian.Uint16(msg[2:])
+		attrs[typ] = msg[unix.SizeofRtAttr:size]
+		msg = msg[(size+unix.NLMSG_ALIGNTO-1)&^(unix.NLMSG_ALIGNTO-1):]
+	}
+	return attrs
+}
+
+func TestEncodeRule(t *testing.T) {
+	msg := encodeRule(routingRule{family: unix.AF_INET6, priority: 456, fwmark: 0x711E, invert: true, table: 113})
+	require.Equal(t, byte(unix.AF_INET6), msg[0])
+	require.Equal(t, byte(unix.FR_ACT_TO_TBL), msg[7])
+	require.Equal(t, uint32(unix.FIB_RULE_INVERT), binary.NativeEndian.Uint32(msg[8:]))
+	attrs := parseAttrs(t, msg[12:])
+	require.Equal(t, uint32(456), binary.NativeEndian.Uint32(attrs[unix.FRA_PRIORITY]))
+	require.Equal(t, uint32(113), binary.NativeEndian.Uint32(attrs[unix.FRA_TABLE]))
+	require.Equal(t, uint32(0x711E), binary.NativeEndian.Uint32(attrs[unix.FRA_FWMARK]))
+	require.Equal(t, uint32(0xFFFFFFFF), binary.NativeEndian.Uint32(attrs[unix.FRA_FWMASK]))
+	require.NotContains(t, attrs, uint16(unix.FRA_UID_RANGE))
+
+	msg = encodeRule(routingRule{family: unix.AF_INET, priority: 455, uids: &uidRange{1000, 1999}, table: unix.RT_TABLE_MAIN})
+	require.Zero(t, binary.NativeEndian.Uint32(msg[8:]))
+	attrs = parseAttrs(t, msg[12:])
+	require.NotContains(t, attrs, uint16(unix.FRA_FWMARK))
+	require.Equal(t, uint32(1000), binary.NativeEndian.Uint32(attrs[unix.FRA_UID_RANGE]))
+	require.Equal(t, uint32(1999), binary.NativeEndian.Uint32(attrs[unix.FRA_UID_RANGE][4:]))
+}
+
+func TestAppendAttr_Padding(t *testing.T) {
+	msg := appendAttr(nil, unix.IFA_LOCAL, []byte{10, 0, 85, 1, 2})
+	require.Len(t, msg, 12)
+	require.Equal(t, uint16(9), binary.NativeEndian.Uint16(msg))
+	require.Equal(t, map[uint16][]byte{unix.IFA_LOCAL: {10, 0, 85, 1, 2}}, parseAttrs(t, msg))
+}
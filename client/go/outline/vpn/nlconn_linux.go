@@ -0,0 +1,141 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+
+	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// netlinkVPNConn implements a platformVPNConn on the Linux systems without NetworkManager. It
+// configures the TUN device, its routes and rules with netlink, and its DNS servers with
+// systemd-resolved.
+//
+// The addresses, routes and DNS servers belong to the TUN device, so the kernel drops them with
+// the device if the process crashes. Only the routing rules outlive it, and they are replaced by
+// the next connection.
+type netlinkVPNConn struct {
+	opts    *nmConnectionOptions
+	tun     io.ReadWriteCloser
+	ifindex int
+	nl      *rtnetlink
+	bus     *dbus.Conn
+	rules   []routingRule
+}
+
+var _ platformVPNConn = (*netlinkVPNConn)(nil)
+
+// TUN returns the Linux L3 TUN device.
+func (c *netlinkVPNConn) TUN() io.ReadWriteCloser { return c.tun }
+
+// Establish tries to create the TUN device and route all traffic to it.
+func (c *netlinkVPNConn) Establish(ctx context.Context) (err error) {
+	if ctx.Err() != nil {
+		return perrs.PlatformError{Code: perrs.OperationCanceled}
+	}
+
+	if c.tun, err = newTUNDevice(c.opts.TUNName); err != nil {
+		return errSetupVPN("failed to create tun device", err, "name", c.opts.TUNName)
+	}
+	logger.Info("tun device created", "name", c.opts.TUNName)
+	if c.opts.TUNMTU > 0 {
+		if err = setTUNDeviceMTU(c.opts.TUNName, c.opts.TUNMTU); err != nil {
+			return errSetupVPN("failed to set tun device MTU", err, "name", c.opts.TUNName, "mtu", c.opts.TUNMTU)
+		}
+	}
+	iface, err := net.InterfaceByName(c.opts.TUNName)
+	if err != nil {
+		return errSetupVPN("failed to find tun device", err, "name", c.opts.TUNName)
+	}
+	c.ifindex = iface.Index
+
+	if c.nl, err = openRTNetlink(); err != nil {
+		return errSetupVPN("failed to open netlink socket", err)
+	}
+	if err = c.nl.setLinkUp(c.ifindex); err != nil {
+		return errSetupVPN("failed to bring up tun device", err, "name", c.opts.TUNName, "api", "netlink")
+	}
+	families := []int{unix.AF_INET}
+	addrs := []net.IP{c.opts.TUNAddr4}
+	if c.opts.TUNAddr6 != nil {
+		families = append(families, unix.AF_INET6)
+		addrs = append(addrs, c.opts.TUNAddr6)
+	}
+	for i, family := range families {
+		if err = c.nl.addAddress(c.ifindex, addrs[i]); err != nil {
+			return errSetupVPN("failed to add tun device address", err, "addr", addrs[i].String(), "api", "netlink")
+		}
+		if err = c.nl.addDefaultRoute(c.ifindex, family, c.opts.RoutingTable); err != nil {
+			return errSetupVPN("failed to add default route", err, "table", c.opts.RoutingTable, "api", "netlink")
+		}
+		for _, rule := range newRoutingRules(c.opts, family) {
+			// Delete the copies left by a crashed process, which would never be deleted otherwise.
+			for c.nl.deleteRule(rule) == nil {
+			}
+			if err = c.nl.addRule(rule); err != nil {
+				return errSetupVPN("failed to add routing rule", err, "priority", rule.priority, "api", "netlink")
+			}
+			c.rules = append(c.rules, rule)
+		}
+	}
+	logger.Info("tun device routes configured", "name", c.opts.TUNName, "table", c.opts.RoutingTable)
+
+	if c.bus, err = dbus.ConnectSystemBus(); err != nil {
+		return errSetupVPN("failed to connect system DBus", err)
+	}
+	servers := append(append([]net.IP{}, c.opts.DNSServers4...), c.opts.DNSServers6...)
+	if err = setLinkDNS(c.bus, c.ifindex, servers); err != nil {
+		return errSetupVPN("failed to set DNS servers", err, "name", c.opts.TUNName, "api", "systemd-resolved")
+	}
+	logger.Info("tun device DNS servers configured", "name", c.opts.TUNName)
+	return nil
+}
+
+// Close tries to restore the routing and DNS, and deletes the TUN device.
+func (c *netlinkVPNConn) Close() (err error) {
+	if c == nil {
+		return nil
+	}
+	if c.bus != nil {
+		if err := revertLinkDNS(c.bus, c.ifindex); err != nil {
+			logger.Warn("failed to revert tun device DNS servers", "err", err)
+		}
+		c.bus.Close()
+	}
+	if c.nl != nil {
+		for _, rule := range c.rules {
+			if err := c.nl.deleteRule(rule); err != nil && !errors.Is(err, unix.ENOENT) {
+				logger.Warn("failed to delete routing rule", "err", err, "priority", rule.priority)
+			}
+		}
+		c.nl.Close()
+	}
+	if c.tun != nil {
+		// this is the only error that matters, the routes go with the device
+		if err = c.tun.Close(); err != nil {
+			err = errCloseVPN("failed to delete tun device", err, "name", c.opts.TUNName)
+		} else {
+			logger.Info("tun device deleted", "name", c.opts.TUNName)
+		}
+	}
+	return
+}
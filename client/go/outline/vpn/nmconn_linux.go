@@ -16,7 +16,6 @@ package vpn
 
 import (
 	"encoding/binary"
-	"log/slog"
 	"net"
 	"time"
 
@@ -27,11 +26,22 @@ import (
 type nmConnectionOptions struct {
 	Name            string
 	TUNName         string
+	TUNMTU          int
 	TUNAddr4        net.IP
 	DNSServers4     []net.IP
 	FWMark          uint32
 	RoutingTable    uint32
 	RoutingPriority uint32
+
+	// TUNAddr6 is the ULA address of the TUN device. IPv6 traffic is routed to the VPN only if it
+	// is set.
+	TUNAddr6    net.IP
+	DNSServers6 []net.IP
+
+	// AppUIDRanges are the UIDs that bypass the VPN, or the only ones using it, depending on
+	// AppSplitMode. All traffic is routed to the VPN if it is empty.
+	AppSplitMode AppSplitTunnelMode
+	AppUIDRanges []uidRange
 }
 
 func establishNMConnection(nm gonm.NetworkManager, opts *nmConnectionOptions) (ac gonm.ActiveConnection, err error) {
@@ -49,27 +59,28 @@ func establishNMConnection(nm gonm.NetworkManager, opts *nmConnectionOptions) (a
 	if err != nil {
 		return nil, errSetupVPN("failed to find tun device", err, "tun", opts.TUNName, "api", "NetworkManager")
 	}
-	slog.Debug("located tun device in NetworkManager", "tun", opts.TUNName, "dev", dev.GetPath())
+	logger.Debug("located tun device in NetworkManager", "tun", opts.TUNName, "dev", dev.GetPath())
 
 	if err = dev.SetPropertyManaged(true); err != nil {
 		return nil, errSetupVPN("failed to manage tun device", err, "dev", dev.GetPath(), "api", "NetworkManager")
 	}
-	slog.Debug("NetworkManager now manages the tun device", "dev", dev.GetPath())
+	logger.Debug("NetworkManager now manages the tun device", "dev", dev.GetPath())
 
 	props := make(map[string]map[string]interface{})
 	configureCommonProps(props, opts)
 	configureTUNProps(props)
 	configureIPv4Props(props, opts)
-	slog.Debug("populated NetworkManager connection settings", "settings", props)
+	configureIPv6Props(props, opts)
+	logger.Debug("populated NetworkManager connection settings", "settings", props)
 
 	// The previous SetPropertyManaged call needs some time to take effect (typically within 50ms)
 	for retries := 20; retries > 0; retries-- {
-		slog.Debug("trying to create NetworkManager connection for tun device...", "dev", dev.GetPath())
+		logger.Debug("trying to create NetworkManager connection for tun device...", "dev", dev.GetPath())
 		ac, err = nm.AddAndActivateConnection(props, dev)
 		if err == nil {
 			break
 		}
-		slog.Debug("failed to create NetworkManager connection, will retry later", "err", err)
+		logger.Debug("failed to create NetworkManager connection, will retry later", "err", err)
 		time.Sleep(50 * time.Millisecond)
 	}
 	if err != nil {
@@ -87,9 +98,9 @@ func closeNMConnection(nm gonm.NetworkManager, ac gonm.ActiveConnection) error {
 	}
 
 	if err := nm.DeactivateConnection(ac); err != nil {
-		slog.Warn("failed to deactivate NetworkManager connection", "err", err, "conn", ac.GetPath())
+		logger.Warn("failed to deactivate NetworkManager connection", "err", err, "conn", ac.GetPath())
 	}
-	slog.Debug("deactivated NetworkManager connection", "conn", ac.GetPath())
+	logger.Debug("deactivated NetworkManager connection", "conn", ac.GetPath())
 
 	conn, err := ac.GetPropertyConnection()
 	if err == nil {
@@ -98,7 +109,7 @@ func closeNMConnection(nm gonm.NetworkManager, ac gonm.ActiveConnection) error {
 	if err != nil {
 		return errCloseVPN("failed to delete NetworkManager connection", err, "conn", ac.GetPath())
 	}
-	slog.Info("NetworkManager connection deleted", "conn", ac.GetPath())
+	logger.Info("NetworkManager connection deleted", "conn", ac.GetPath())
 
 	return nil
 }
@@ -163,16 +174,68 @@ func configureIPv4Props(props map[string]map[string]interface{}, opts *nmConnect
 		// Array of dictionaries for routing rules. Each routing rule supports the following options:
 		// action (y), dport-end (q), dport-start (q), family (i), from (s), from-len (y), fwmark (u), fwmask (u),
 		// iifname (s), invert (b), ipproto (s), oifname (s), priority (u), sport-end (q), sport-start (q),
-		// supress-prefixlength (i), table (u), to (s), tos (y), to-len (y), range-end (u), range-start (u).
-		//
-		//   - not fwmark "0x711E" table "113" priority "456"
-		"routing-rules": []map[string]interface{}{{
-			"family":   unix.AF_INET,
-			"priority": opts.RoutingPriority,
-			"fwmark":   opts.FWMark,
-			"fwmask":   uint32(0xFFFFFFFF),
-			"invert":   true,
+		// supress-prefixlength (i), table (u), to (s), tos (y), to-len (y), range-end (u), range-start (u),
+		// uid-range-end (u), uid-range-start (u).
+		"routing-rules": nmRoutingRules(newRoutingRules(opts, unix.AF_INET)),
+	}
+}
+
+func configureIPv6Props(props map[string]map[string]interface{}, opts *nmConnectionOptions) {
+	if opts.TUNAddr6 == nil {
+		return
+	}
+	dnsList := make([][]byte, 0, len(opts.DNSServers6))
+	for _, dns := range opts.DNSServers6 {
+		dnsList = append(dnsList, []byte(dns.To16()))
+	}
+
+	props["ipv6"] = map[string]interface{}{
+		"method": "manual",
+
+		// Same as the IPv4 settings, except that the DNS servers are byte arrays.
+		"address-data": []map[string]interface{}{{
+			"address": opts.TUNAddr6.String(),
+			"prefix":  uint32(128),
+		}},
+		"dns":          dnsList,
+		"dns-priority": -99,
+		"dns-search":   []string{"~."},
+
+		// NetworkManager will add these routing entries:
+		//   - default via fd00:0:85::1 dev outline-tun0 table 13579 proto static metric 450
+		//   - fd00:0:85::1 dev outline-tun0 table 13579 proto static metric 450
+		"route-data": []map[string]interface{}{{
+			"dest":     "::",
+			"prefix":   uint32(0),
+			"next-hop": opts.TUNAddr6.String(),
 			"table":    opts.RoutingTable,
 		}},
+
+		"routing-rules": nmRoutingRules(newRoutingRules(opts, unix.AF_INET6)),
+	}
+}
+
+// nmRoutingRules converts rules to NetworkManager routing rules.
+func nmRoutingRules(rules []routingRule) []map[string]interface{} {
+	props := make([]map[string]interface{}, 0, len(rules))
+	for _, r := range rules {
+		rule := map[string]interface{}{
+			"family":   int32(r.family),
+			"priority": r.priority,
+			"table":    r.table,
+		}
+		if r.fwmark != 0 {
+			rule["fwmark"] = r.fwmark
+			rule["fwmask"] = uint32(0xFFFFFFFF)
+		}
+		if r.invert {
+			rule["invert"] = true
+		}
+		if r.uids != nil {
+			rule["uid-range-start"] = r.uids.start
+			rule["uid-range-end"] = r.uids.end
+		}
+		props = append(props, rule)
 	}
+	return props
 }
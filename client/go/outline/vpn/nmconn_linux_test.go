@@ -0,0 +1,41 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestConfigureIPv6Props(t *testing.T) {
+	opts := &nmConnectionOptions{FWMark: 0x711E, RoutingTable: 113, RoutingPriority: 456}
+	props := make(map[string]map[string]interface{})
+	configureIPv6Props(props, opts)
+	require.NotContains(t, props, "ipv6")
+
+	opts.TUNAddr6 = net.ParseIP("fd00:0:85::1")
+	opts.DNSServers6 = []net.IP{net.ParseIP("2620:fe::fe")}
+	configureIPv6Props(props, opts)
+	require.Equal(t, "manual", props["ipv6"]["method"])
+	require.Equal(t, [][]byte{net.ParseIP("2620:fe::fe")}, props["ipv6"]["dns"])
+	route := props["ipv6"]["route-data"].([]map[string]interface{})[0]
+	require.Equal(t, "::", route["dest"])
+	require.Equal(t, "fd00:0:85::1", route["next-hop"])
+	rules := props["ipv6"]["routing-rules"].([]map[string]interface{})
+	require.Equal(t, int32(unix.AF_INET6), rules[0]["family"])
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"net"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	resolvedBusName    = "org.freedesktop.resolve1"
+	resolvedObjectPath = "/org/freedesktop/resolve1"
+	resolvedManager    = "org.freedesktop.resolve1.Manager"
+)
+
+// resolvedLinkDNS is an address of a DNS server in the SetLinkDNS call of systemd-resolved.
+type resolvedLinkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedLinkDomain is a domain in the SetLinkDomains call of systemd-resolved.
+type resolvedLinkDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// setLinkDNS makes systemd-resolved send all the DNS queries of the system to servers through
+// the link of ifindex, like the "dns-search: ~." of the NetworkManager connection. The settings
+// are dropped when the link is deleted.
+func setLinkDNS(bus *dbus.Conn, ifindex int, servers []net.IP) error {
+	addrs := make([]resolvedLinkDNS, 0, len(servers))
+	for _, server := range servers {
+		family, ip := ipFamily(server)
+		addrs = append(addrs, resolvedLinkDNS{Family: int32(family), Address: ip})
+	}
+	resolved := bus.Object(resolvedBusName, resolvedObjectPath)
+	if err := resolved.Call(resolvedManager+".SetLinkDNS", 0, int32(ifindex), addrs).Err; err != nil {
+		return err
+	}
+	domains := []resolvedLinkDomain{{Domain: ".", RoutingOnly: true}}
+	if err := resolved.Call(resolvedManager+".SetLinkDomains", 0, int32(ifindex), domains).Err; err != nil {
+		return err
+	}
+	return resolved.Call(resolvedManager+".SetLinkDefaultRoute", 0, int32(ifindex), true).Err
+}
+
+// revertLinkDNS drops the DNS settings of the link of ifindex.
+func revertLinkDNS(bus *dbus.Conn, ifindex int) error {
+	return bus.Object(resolvedBusName, resolvedObjectPath).Call(resolvedManager+".RevertLink", 0, int32(ifindex)).Err
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// sessionTracker keeps track of the live TCP connections and UDP sessions of a [RemoteDevice],
+// so that they can be moved to a new network, see [RemoteDevice.ResumeAfterNetworkChange].
+type sessionTracker struct {
+	mu      sync.Mutex
+	streams map[*trackedStreamConn]struct{}
+	packets map[*resumablePacketConn]struct{}
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		streams: make(map[*trackedStreamConn]struct{}),
+		packets: make(map[*resumablePacketConn]struct{}),
+	}
+}
+
+// closeStreams closes all the live TCP connections, and returns how many there were.
+func (t *sessionTracker) closeStreams() int {
+	t.mu.Lock()
+	streams := make([]*trackedStreamConn, 0, len(t.streams))
+	for c := range t.streams {
+		streams = append(streams, c)
+	}
+	t.mu.Unlock()
+	for _, c := range streams {
+		c.Close()
+	}
+	return len(streams)
+}
+
+// rebindPackets re-dials all the live UDP sessions, and returns how many there were.
+func (t *sessionTracker) rebindPackets(ctx context.Context) int {
+	t.mu.Lock()
+	packets := make([]*resumablePacketConn, 0, len(t.packets))
+	for c := range t.packets {
+		packets = append(packets, c)
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range packets {
+		wg.Add(1)
+		go func(c *resumablePacketConn) {
+			defer wg.Done()
+			if err := c.rebind(ctx); err != nil {
+				logger.Debug("failed to resume UDP session, closing it", "err", err)
+				c.Close()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return len(packets)
+}
+
+// trackedStreamConn is a [transport.StreamConn] registered in a [sessionTracker] until closed.
+type trackedStreamConn struct {
+	transport.StreamConn
+	tracker *sessionTracker
+}
+
+func (t *sessionTracker) trackStream(conn transport.StreamConn) transport.StreamConn {
+	c := &trackedStreamConn{StreamConn: conn, tracker: t}
+	t.mu.Lock()
+	t.streams[c] = struct{}{}
+	t.mu.Unlock()
+	return c
+}
+
+func (c *trackedStreamConn) Close() error {
+	c.tracker.mu.Lock()
+	delete(c.tracker.streams, c)
+	c.tracker.mu.Unlock()
+	return c.StreamConn.Close()
+}
+
+// resumablePacketListener is a [transport.PacketListener] whose connections can be re-dialed
+// without the UDP session noticing.
+type resumablePacketListener struct {
+	pl      transport.PacketListener
+	tracker *sessionTracker
+}
+
+var _ transport.PacketListener = (*resumablePacketListener)(nil)
+
+func (l *resumablePacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.pl.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &resumablePacketConn{pl: l.pl, tracker: l.tracker, conn: conn}
+	l.tracker.mu.Lock()
+	l.tracker.packets[c] = struct{}{}
+	l.tracker.mu.Unlock()
+	return c, nil
+}
+
+// resumablePacketConn is a [net.PacketConn] that can switch to a new underlying connection, e.g.
+// after a network change. The local side of the UDP session, and so its NAT mapping in the
+// network stack, stays the same.
+type resumablePacketConn struct {
+	pl      transport.PacketListener
+	tracker *sessionTracker
+
+	mu                          sync.Mutex
+	conn                        net.PacketConn
+	readDeadline, writeDeadline time.Time
+	closed                      bool
+}
+
+var _ net.PacketConn = (*resumablePacketConn)(nil)
+
+// rebind replaces the underlying connection with a new one from the listener, and closes the
+// previous one.
+func (c *resumablePacketConn) rebind(ctx context.Context) error {
+	conn, err := c.pl.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return conn.Close()
+	}
+	if !c.readDeadline.IsZero() {
+		conn.SetReadDeadline(c.readDeadline)
+	}
+	if !c.writeDeadline.IsZero() {
+		conn.SetWriteDeadline(c.writeDeadline)
+	}
+	prev := c.conn
+	c.conn = conn
+	c.mu.Unlock()
+	return prev.Close()
+}
+
+func (c *resumablePacketConn) current() net.PacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *resumablePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		conn := c.current()
+		n, addr, err := conn.ReadFrom(p)
+		if err != nil {
+			c.mu.Lock()
+			rebound := !c.closed && c.conn != conn
+			c.mu.Unlock()
+			if rebound {
+				// The read was interrupted by rebind, continue with the new connection.
+				continue
+			}
+		}
+		return n, addr, err
+	}
+}
+
+func (c *resumablePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(p, addr)
+}
+
+func (c *resumablePacketConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *resumablePacketConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.conn.SetDeadline(t)
+}
+
+func (c *resumablePacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *resumablePacketConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *resumablePacketConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	c.tracker.mu.Lock()
+	delete(c.tracker.packets, c)
+	c.tracker.mu.Unlock()
+	return conn.Close()
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumablePacketConn_Rebind(t *testing.T) {
+	tracker := newSessionTracker()
+	l := &resumablePacketListener{pl: &transport.UDPListener{Address: "127.0.0.1:0"}, tracker: tracker}
+	conn, err := l.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	prevAddr := conn.LocalAddr()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer peer.Close()
+
+	received := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(buf[:n])
+	}()
+
+	// Let the read start on the previous connection.
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 1, tracker.rebindPackets(context.Background()))
+	require.NotEqual(t, prevAddr, conn.LocalAddr())
+
+	_, err = peer.WriteTo([]byte("hello"), conn.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, "hello", <-received)
+
+	_, err = conn.WriteTo([]byte("world"), peer.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, addr, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(buf[:n]))
+	require.Equal(t, conn.LocalAddr().String(), addr.String())
+
+	require.NoError(t, conn.Close())
+	require.Empty(t, tracker.packets)
+}
+
+func TestSessionTracker_CloseStreams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	tracker := newSessionTracker()
+	dialer := &transport.TCPDialer{}
+	var conns []transport.StreamConn
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.DialStream(context.Background(), listener.Addr().String())
+		require.NoError(t, err)
+		conns = append(conns, tracker.trackStream(conn))
+	}
+	require.NoError(t, conns[0].Close())
+	require.Len(t, tracker.streams, 1)
+
+	require.Equal(t, 1, tracker.closeStreams())
+	require.Empty(t, tracker.streams)
+	_, err = conns[1].Write([]byte("x"))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
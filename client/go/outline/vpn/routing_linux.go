@@ -0,0 +1,73 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import "golang.org/x/sys/unix"
+
+// routingRule is a Linux policy routing rule, like the ones of "ip rule".
+type routingRule struct {
+	family   int
+	priority uint32
+
+	// fwmark, if not 0, matches the packets with exactly this mark, or without it if invert.
+	fwmark uint32
+	invert bool
+
+	// uids, if not nil, matches the packets of the sockets of these users.
+	uids *uidRange
+
+	table uint32
+}
+
+// newRoutingRules creates the routing rules of the address family sending the traffic to the VPN
+// routing table:
+//
+//   - by default: not fwmark "0x711E" table "113" priority "456"
+//   - excluded apps: uidrange "1000-1000" table main priority "455", followed by the default rule
+//   - included apps: fwmark "0x711E" table main priority "455", uidrange "1000-1000" table "113"
+//     priority "456"
+//
+// The DNS queries are made by systemd-resolved on behalf of the apps, so they are not affected by
+// the app rules.
+func newRoutingRules(opts *nmConnectionOptions, family int) []routingRule {
+	if opts.AppSplitMode == AppSplitTunnelInclude && len(opts.AppUIDRanges) > 0 {
+		rules := []routingRule{{
+			family:   family,
+			priority: opts.RoutingPriority - 1,
+			fwmark:   opts.FWMark,
+			table:    unix.RT_TABLE_MAIN,
+		}}
+		for _, r := range opts.AppUIDRanges {
+			r := r
+			rules = append(rules, routingRule{family: family, priority: opts.RoutingPriority, uids: &r, table: opts.RoutingTable})
+		}
+		return rules
+	}
+
+	var rules []routingRule
+	if opts.AppSplitMode == AppSplitTunnelExclude {
+		for _, r := range opts.AppUIDRanges {
+			r := r
+			rules = append(rules, routingRule{family: family, priority: opts.RoutingPriority - 1, uids: &r, table: unix.RT_TABLE_MAIN})
+		}
+	}
+	return append(rules, routingRule{
+		family:   family,
+		priority: opts.RoutingPriority,
+		fwmark:   opts.FWMark,
+		invert:   true,
+		table:    opts.RoutingTable,
+	})
+}
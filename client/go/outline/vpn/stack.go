@@ -0,0 +1,70 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/network"
+	"github.com/Jigsaw-Code/outline-sdk/network/lwip2transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// NetworkStack configures the user space network stack of a [RemoteDevice], which terminates the
+// TCP connections and UDP sessions of the packets of the TUN device, and relays them through sd
+// and pp. The device it returns takes the packets from the TUN device, and gives the packets to
+// the TUN device.
+type NetworkStack func(sd transport.StreamDialer, pp network.PacketProxy) (network.IPDevice, error)
+
+// NetworkStackLWIP is the name of the lwIP network stack, which is the default.
+const NetworkStackLWIP = "lwip"
+
+var networkStacksMu sync.RWMutex
+var networkStacks = map[string]NetworkStack{
+	NetworkStackLWIP: lwip2transport.ConfigureDevice,
+}
+
+// RegisterNetworkStack registers a network stack that [Config].NetworkStack can select, e.g. one
+// built on the netstack of gVisor. name must not be registered already.
+func RegisterNetworkStack(name string, stack NetworkStack) error {
+	if name == "" {
+		return fmt.Errorf("network stack name must not be empty")
+	}
+	if stack == nil {
+		return fmt.Errorf("network stack %q is nil", name)
+	}
+	networkStacksMu.Lock()
+	defer networkStacksMu.Unlock()
+	if _, ok := networkStacks[name]; ok {
+		return fmt.Errorf("network stack %q is already registered", name)
+	}
+	networkStacks[name] = stack
+	return nil
+}
+
+// lookupNetworkStack returns the network stack registered as name, which defaults to lwIP.
+func lookupNetworkStack(name string) (NetworkStack, error) {
+	if name == "" {
+		name = NetworkStackLWIP
+	}
+	networkStacksMu.RLock()
+	defer networkStacksMu.RUnlock()
+	stack, ok := networkStacks[name]
+	if !ok {
+		return nil, errIllegalConfig("network stack is not supported", "networkStack", name)
+	}
+	return stack, nil
+}
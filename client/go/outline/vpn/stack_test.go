@@ -0,0 +1,55 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"errors"
+	"testing"
+
+	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-sdk/network"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkStacks(t *testing.T) {
+	stack, err := lookupNetworkStack("")
+	require.NoError(t, err)
+	require.NotNil(t, stack)
+
+	_, err = lookupNetworkStack("test")
+	var perr perrs.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, perrs.IllegalConfig, perr.Code)
+
+	errTest := errors.New("test stack")
+	require.NoError(t, RegisterNetworkStack("test", func(transport.StreamDialer, network.PacketProxy) (network.IPDevice, error) {
+		return nil, errTest
+	}))
+	t.Cleanup(func() {
+		networkStacksMu.Lock()
+		defer networkStacksMu.Unlock()
+		delete(networkStacks, "test")
+	})
+	stack, err = lookupNetworkStack("test")
+	require.NoError(t, err)
+	_, err = stack(nil, nil)
+	require.ErrorIs(t, err, errTest)
+
+	require.Error(t, RegisterNetworkStack("test", stack))
+	require.Error(t, RegisterNetworkStack(NetworkStackLWIP, stack))
+	require.Error(t, RegisterNetworkStack("", stack))
+	require.Error(t, RegisterNetworkStack("nil", nil))
+}
@@ -17,11 +17,11 @@ package vpn
 import (
 	"fmt"
 	"io"
-	"log/slog"
 	"time"
 
 	gonm "github.com/Wifx/gonetworkmanager/v2"
 	"github.com/songgao/water"
+	"golang.org/x/sys/unix"
 )
 
 // newTUNDevice creates a non-persist layer 3 TUN device with the given name.
@@ -42,16 +42,31 @@ func newTUNDevice(name string) (io.ReadWriteCloser, error) {
 	return tun, nil
 }
 
+// setTUNDeviceMTU sets the MTU of the TUN device with the given name.
+func setTUNDeviceMTU(name string, mtu int) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint32(uint32(mtu))
+	return unix.IoctlIfreq(fd, unix.SIOCSIFMTU, ifr)
+}
+
 // waitForTUNDeviceToBeAvailable waits for the TUN device with the given name to be available
 // in the specific NetworkManager.
 func waitForTUNDeviceToBeAvailable(nm gonm.NetworkManager, name string) (dev gonm.Device, err error) {
 	for retries := 20; retries > 0; retries-- {
-		slog.Debug("trying to find tun device in NetworkManager...", "tun", name)
+		logger.Debug("trying to find tun device in NetworkManager...", "tun", name)
 		dev, err = nm.GetDeviceByIpIface(name)
 		if dev != nil && err == nil {
 			return
 		}
-		slog.Debug("waiting for tun device to be available in NetworkManager", "err", err)
+		logger.Debug("waiting for tun device to be available in NetworkManager", "err", err)
 		time.Sleep(50 * time.Millisecond)
 	}
 	return nil, errSetupVPN("failed to find tun device in NetworkManager", err, "tun", name)
@@ -0,0 +1,106 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// The kernel control constants of the utun driver, see <net/if_utun.h> and <sys/kern_control.h>.
+const (
+	sysprotoControl = 2
+	utunControlName = "com.apple.net.utun_control"
+	utunOptIfname   = 2
+)
+
+// utunDevice is a macOS utun device. Each packet read from or written to the kernel is prefixed
+// with the 4-byte address family of the packet, which utunDevice strips and adds.
+type utunDevice struct {
+	name string
+	f    *os.File
+	rbuf []byte
+}
+
+var _ io.ReadWriteCloser = (*utunDevice)(nil)
+
+// newUTUNDevice creates a new utun device with a name picked by the kernel, e.g. "utun5". The
+// device is deleted, together with its routes, when it is closed.
+func newUTUNDevice() (*utunDevice, error) {
+	fd, err := unix.Socket(unix.AF_SYSTEM, unix.SOCK_DGRAM, sysprotoControl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the control socket: %w", err)
+	}
+	unix.CloseOnExec(fd)
+	info := &unix.CtlInfo{}
+	copy(info.Name[:], utunControlName)
+	if err := unix.IoctlCtlInfo(fd, info); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to find the utun control: %w", err)
+	}
+	// Unit 0 lets the kernel pick the first free utun device.
+	if err := unix.Connect(fd, &unix.SockaddrCtl{ID: info.Id, Unit: 0}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create the utun device: %w", err)
+	}
+	name, err := unix.GetsockoptString(fd, sysprotoControl, utunOptIfname)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to get the utun device name: %w", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &utunDevice{name: name, f: os.NewFile(uintptr(fd), name), rbuf: make([]byte, 4+65535)}, nil
+}
+
+// Name returns the interface name of the device.
+func (d *utunDevice) Name() string { return d.name }
+
+// Read reads an IP packet without its address family header.
+func (d *utunDevice) Read(p []byte) (int, error) {
+	n, err := d.f.Read(d.rbuf)
+	if n < 4 {
+		return 0, err
+	}
+	return copy(p, d.rbuf[4:n]), err
+}
+
+// Write writes the IP packet p, prefixed with its address family header.
+func (d *utunDevice) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 4+len(p))
+	family := uint32(unix.AF_INET)
+	if p[0]>>4 == 6 {
+		family = unix.AF_INET6
+	}
+	binary.BigEndian.PutUint32(buf, family)
+	copy(buf[4:], p)
+	n, err := d.f.Write(buf)
+	if n < 4 {
+		return 0, err
+	}
+	return n - 4, err
+}
+
+// Close deletes the device.
+func (d *utunDevice) Close() error { return d.f.Close() }
@@ -17,9 +17,16 @@ package vpn
 import (
 	"context"
 	"io"
-	"log/slog"
 	"sync"
+	"time"
 
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/dnsintercept"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/icmpecho"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/logging"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/mtu"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/pcap"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/quic"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/resources"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 )
 
@@ -33,6 +40,41 @@ type Config struct {
 	RoutingTableId  uint32   `json:"routingTableId"`
 	RoutingPriority uint32   `json:"routingPriority"`
 	ProtectionMark  uint32   `json:"protectionMark"`
+
+	// IPv6Address is the IPv6 address of the TUN interface, which must be a unique local address
+	// (fc00::/7), e.g. "fd00:0:85::1". The VPN carries IPv6 traffic only if it is set, otherwise
+	// IPv6 traffic is left to the system.
+	IPv6Address string `json:"ipv6Address,omitempty"`
+
+	// MTU is the MTU of the TUN interface. The system default is used if it is 0.
+	MTU int `json:"mtu,omitempty"`
+
+	// ProbeMTU discovers the largest MTU the proxy path carries without loss, and uses it instead
+	// of MTU if the discovery succeeds.
+	ProbeMTU bool `json:"probeMtu,omitempty"`
+
+	// BlockQUIC rejects QUIC (UDP port 443) traffic with ICMP port unreachable, so that browsers
+	// use TCP.
+	BlockQUIC bool `json:"blockQuic,omitempty"`
+
+	// ICMPEcho is how the pings are answered, as the proxy doesn't relay them: "off" (default),
+	// "reply" or "probe", see [icmpecho.Mode].
+	ICMPEcho string `json:"icmpEcho,omitempty"`
+
+	// UDPIdleTimeoutSeconds is how long a UDP session is kept without outgoing traffic. Defaults
+	// to 30 seconds.
+	UDPIdleTimeoutSeconds int `json:"udpIdleTimeoutSeconds,omitempty"`
+
+	// UDPMaxSessions is the size of the UDP NAT table. When it's full, the least recently used
+	// session is closed to make room for a new one. Defaults to 1024.
+	UDPMaxSessions int `json:"udpMaxSessions,omitempty"`
+
+	// AppSplitTunnel optionally selects the applications that bypass (or exclusively use) the VPN.
+	AppSplitTunnel *AppSplitTunnelConfig `json:"appSplitTunnel,omitempty"`
+
+	// NetworkStack selects the user space network stack handling the packets: "lwip", the
+	// default, or a stack registered with [RegisterNetworkStack].
+	NetworkStack string `json:"networkStack,omitempty"`
 }
 
 // platformVPNConn is an interface representing an OS-specific VPN connection.
@@ -58,6 +100,8 @@ type VPNConnection struct {
 	platform platformVPNConn
 }
 
+var logger = logging.Module("vpn")
+
 // The global singleton VPN connection.
 // This package allows at most one active VPN connection at the same time.
 var mu sync.Mutex
@@ -67,9 +111,12 @@ var conn *VPNConnection
 // with the given VPN [Config].
 // It first closes any active [VPNConnection] using [CloseVPN], and then marks the
 // newly created [VPNConnection] as the currently active connection.
+// The DNS queries are answered by dnsForwarder if it is not nil. The UDP traffic is relayed with
+// udpFallback, if it is not nil, when pl cannot reach the server.
 // It returns the new [VPNConnection], or an error if the connection fails.
 func EstablishVPN(
 	ctx context.Context, conf *Config, sd transport.StreamDialer, pl transport.PacketListener,
+	dnsForwarder *dnsintercept.Forwarder, udpFallback transport.PacketListener,
 ) (_ *VPNConnection, err error) {
 	if conf == nil {
 		panic("a VPN config must be provided")
@@ -81,9 +128,29 @@ func EstablishVPN(
 		panic("a PacketListener must be provided")
 	}
 
+	stack, err := lookupNetworkStack(conf.NetworkStack)
+	if err != nil {
+		return nil, err
+	}
+	icmpEcho, err := icmpecho.ParseMode(conf.ICMPEcho)
+	if err != nil {
+		return nil, errIllegalConfig("ICMP echo mode must be off, reply or probe", "icmpEcho", conf.ICMPEcho)
+	}
+
 	c := &VPNConnection{ID: conf.ID}
 	ctx, c.cancelEst = context.WithCancel(ctx)
 
+	if conf.ProbeMTU {
+		if probed, err := mtu.Probe(ctx, pl, mtu.DefaultProbeResolver); err != nil {
+			logger.Warn("failed to probe the MTU", "err", err)
+		} else {
+			logger.Info("probed the MTU", "mtu", probed)
+			probedConf := *conf
+			probedConf.MTU = probed
+			conf = &probedConf
+		}
+	}
+
 	if c.platform, err = newPlatformVPNConn(conf); err != nil {
 		return
 	}
@@ -96,37 +163,94 @@ func EstablishVPN(
 		return
 	}
 
-	slog.Debug("establishing vpn connection ...", "id", c.ID)
+	logger.Debug("establishing vpn connection ...", "id", c.ID)
 
-	if c.proxy, err = ConnectRemoteDevice(ctx, sd, pl); err != nil {
-		slog.Error("failed to connect to the remote device", "err", err)
+	udpIdleTimeout := time.Duration(conf.UDPIdleTimeoutSeconds) * time.Second
+	if c.proxy, err = ConnectRemoteDevice(ctx, sd, pl, dnsForwarder, udpFallback, udpIdleTimeout, conf.UDPMaxSessions, stack); err != nil {
+		logger.Error("failed to connect to the remote device", "err", err)
 		return
 	}
-	slog.Info("connected to the remote device")
+	logger.Info("connected to the remote device")
+	if conf.MTU > 0 {
+		c.proxy.stats.SetMTU(conf.MTU)
+	}
 
 	if err = c.platform.Establish(ctx); err != nil {
 		// No need to call c.platform.Close() cuz it's already tracked in the global conn
 		return
 	}
 
+	var toProxy, toTUN io.Writer = c.proxy, c.platform.TUN()
+	// The packet captures see the packets as the TUN device does.
+	toProxy, toTUN = pcap.NewTapWriter(toProxy, true), pcap.NewTapWriter(toTUN, false)
+	if conf.MTU > 0 {
+		// Keep the TCP segments in both directions within the MTU.
+		toProxy, toTUN = mtu.NewMSSClampingWriter(toProxy, conf.MTU), mtu.NewMSSClampingWriter(toTUN, conf.MTU)
+	}
+	if conf.BlockQUIC {
+		toProxy = quic.NewBlockingWriter(toProxy, toTUN)
+	}
+	// The probes follow the transport replaced by [ReplaceTransport].
+	toProxy = icmpecho.NewWriter(toProxy, toTUN, icmpEcho, icmpecho.NewTLSProber(c.proxy.dialer))
 	c.wgCopy.Add(2)
-	go func() {
+	resources.Go(resources.SubsystemVPN, func() {
 		defer c.wgCopy.Done()
-		slog.Debug("copying traffic from tun device -> remote device...")
-		n, err := io.Copy(c.proxy, c.platform.TUN())
-		slog.Debug("tun device -> remote device traffic done", "n", n, "err", err)
-	}()
-	go func() {
+		logger.Debug("copying traffic from tun device -> remote device...")
+		n, err := io.Copy(toProxy, c.platform.TUN())
+		logger.Debug("tun device -> remote device traffic done", "n", n, "err", err)
+	})
+	resources.Go(resources.SubsystemVPN, func() {
 		defer c.wgCopy.Done()
-		slog.Debug("copying traffic from remote device -> tun device...")
-		n, err := io.Copy(c.platform.TUN(), c.proxy)
-		slog.Debug("remote device -> tun device traffic done", "n", n, "err", err)
-	}()
+		logger.Debug("copying traffic from remote device -> tun device...")
+		n, err := io.Copy(toTUN, c.proxy)
+		logger.Debug("remote device -> tun device traffic done", "n", n, "err", err)
+	})
 
-	slog.Info("vpn connection established", "id", c.ID)
+	logger.Info("vpn connection established", "id", c.ID)
 	return c, nil
 }
 
+// RefreshConnectivity re-checks the connectivity of the remote device, and switches the UDP
+// handler according to whether the server still supports UDP.
+func (c *VPNConnection) RefreshConnectivity(ctx context.Context) error {
+	if c.proxy == nil {
+		return errSetupHandler("remote device is not connected", nil)
+	}
+	return c.proxy.RefreshConnectivity(ctx)
+}
+
+// ReplaceTransport makes the active [VPNConnection] relay the new traffic through sd and pl,
+// without tearing down the VPN, see [RemoteDevice.ReplaceTransport]. The MTU and QUIC policy of
+// the connection, and its UDP idle timeout, don't change.
+func ReplaceTransport(
+	ctx context.Context, sd transport.StreamDialer, pl transport.PacketListener,
+	dnsForwarder *dnsintercept.Forwarder, udpFallback transport.PacketListener,
+) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if conn == nil || conn.proxy == nil {
+		return errSetupHandler("remote device is not connected", nil)
+	}
+	return conn.proxy.ReplaceTransport(ctx, sd, pl, dnsForwarder, udpFallback)
+}
+
+// ResumeAfterNetworkChange moves the traffic of the connection to the new network, see
+// [RemoteDevice.ResumeAfterNetworkChange].
+func (c *VPNConnection) ResumeAfterNetworkChange(ctx context.Context) error {
+	if c.proxy == nil {
+		return errSetupHandler("remote device is not connected", nil)
+	}
+	return c.proxy.ResumeAfterNetworkChange(ctx)
+}
+
+// SetBlocked drops or lets through the new traffic of the connection, see
+// [RemoteDevice.SetBlocked].
+func (c *VPNConnection) SetBlocked(blocked bool) {
+	if c.proxy != nil {
+		c.proxy.SetBlocked(blocked)
+	}
+}
+
 // CloseVPN terminates the currently active [VPNConnection] and disconnects the proxy.
 func CloseVPN() error {
 	mu.Lock()
@@ -138,12 +262,12 @@ func CloseVPN() error {
 func atomicReplaceVPNConn(newConn *VPNConnection) error {
 	mu.Lock()
 	defer mu.Unlock()
-	slog.Debug("replacing the global vpn connection...", "id", newConn.ID)
+	logger.Debug("replacing the global vpn connection...", "id", newConn.ID)
 	if err := closeVPNNoLock(); err != nil {
 		return err
 	}
 	conn = newConn
-	slog.Info("global vpn connection replaced", "id", newConn.ID)
+	logger.Info("global vpn connection replaced", "id", newConn.ID)
 	return nil
 }
 
@@ -156,12 +280,12 @@ func closeVPNNoLock() (err error) {
 
 	defer func() {
 		if err == nil {
-			slog.Info("vpn connection terminated", "id", conn.ID)
+			logger.Info("vpn connection terminated", "id", conn.ID)
 			conn = nil
 		}
 	}()
 
-	slog.Debug("terminating the global vpn connection...", "id", conn.ID)
+	logger.Debug("terminating the global vpn connection...", "id", conn.ID)
 
 	// Cancel the Establish process and wait
 	conn.cancelEst()
@@ -175,9 +299,9 @@ func closeVPNNoLock() (err error) {
 	// We can ignore the following error
 	if conn.proxy != nil {
 		if err2 := conn.proxy.Close(); err2 != nil {
-			slog.Warn("failed to disconnect from the remote device")
+			logger.Warn("failed to disconnect from the remote device")
 		} else {
-			slog.Info("disconnected from the remote device")
+			logger.Info("disconnected from the remote device")
 		}
 	}
 
@@ -0,0 +1,196 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// darwinVPNConn implements a platformVPNConn on macOS with a utun device, for the builds without
+// the Network Extension entitlement. The routes and DNS are configured with the route and scutil
+// commands, so the process must run as root.
+type darwinVPNConn struct {
+	id     string
+	mtu    int
+	addr4  net.IP
+	addr6  net.IP
+	dns    []net.IP
+	tun    *utunDevice
+	dnsKey string
+}
+
+var _ platformVPNConn = (*darwinVPNConn)(nil)
+
+// newPlatformVPNConn creates a new macOS-specific platformVPNConn.
+// You need to call Establish() in order to make it connected.
+func newPlatformVPNConn(conf *Config) (_ platformVPNConn, err error) {
+	c := &darwinVPNConn{
+		id:    conf.ID,
+		mtu:   conf.MTU,
+		addr4: net.ParseIP(conf.IPAddress).To4(),
+	}
+
+	// The utun device name is picked by the kernel, so conf.InterfaceName is not used.
+	if conf.ID == "" {
+		return nil, errIllegalConfig("must provide a valid connection ID")
+	}
+	if c.addr4 == nil {
+		return nil, errIllegalConfig("must provide a valid TUN interface IP(v4)")
+	}
+	if conf.MTU != 0 && (conf.MTU < 576 || conf.MTU > 65535) {
+		return nil, errIllegalConfig("TUN interface MTU must be between 576 and 65535", "mtu", conf.MTU)
+	}
+	if conf.IPv6Address != "" {
+		addr6 := net.ParseIP(conf.IPv6Address)
+		if addr6 == nil || addr6.To4() != nil || !addr6.IsPrivate() {
+			return nil, errIllegalConfig("TUN interface IPv6 must be a valid unique local address", "ipv6", conf.IPv6Address)
+		}
+		c.addr6 = addr6
+	}
+	for _, dns := range conf.DNSServers {
+		dnsIP := net.ParseIP(dns)
+		if dnsIP == nil || (dnsIP.To4() == nil && c.addr6 == nil) {
+			return nil, errIllegalConfig("DNS server must be a valid IP(v4), or IPv6 if IPv6 is enabled", "dns", dns)
+		}
+		c.dns = append(c.dns, dnsIP)
+	}
+	if conf.AppSplitTunnel != nil {
+		return nil, errFeatureNotSupported("app split tunneling is not supported on macOS")
+	}
+	// The scutil key only allows a restricted set of characters.
+	c.dnsKey = "State:/Network/Service/Outline-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, conf.ID) + "/DNS"
+
+	return c, nil
+}
+
+// TUN returns the macOS utun device.
+func (c *darwinVPNConn) TUN() io.ReadWriteCloser { return c.tun }
+
+// Establish tries to create the utun device and route all traffic to it.
+func (c *darwinVPNConn) Establish(ctx context.Context) (err error) {
+	if ctx.Err() != nil {
+		return perrs.PlatformError{Code: perrs.OperationCanceled}
+	}
+
+	// Remove the DNS configuration left behind if the previous process crashed. The routes were
+	// deleted together with its utun device.
+	if err := scutil("remove " + c.dnsKey); err != nil {
+		logger.Debug("no stale DNS configuration to remove", "err", err)
+	}
+
+	if c.tun, err = newUTUNDevice(); err != nil {
+		return errSetupVPN("failed to create utun device", err, "id", c.id)
+	}
+	name := c.tun.Name()
+	logger.Info("utun device created", "name", name)
+
+	args := []string{name, "inet", c.addr4.String(), c.addr4.String(), "netmask", "255.255.255.255"}
+	if c.mtu > 0 {
+		args = append(args, "mtu", strconv.Itoa(c.mtu))
+	}
+	if err = run("ifconfig", append(args, "up")...); err != nil {
+		return errSetupVPN("failed to configure utun device", err, "name", name)
+	}
+	if c.addr6 != nil {
+		if err = run("ifconfig", name, "inet6", c.addr6.String(), "prefixlen", "128"); err != nil {
+			return errSetupVPN("failed to configure utun device IPv6", err, "name", name)
+		}
+	}
+
+	// Two half routes take precedence over the default route without replacing it, so that the
+	// protected sockets can still reach the proxy server through the physical interface.
+	routes := []string{"-inet", "0.0.0.0/1", "-inet", "128.0.0.0/1"}
+	if c.addr6 != nil {
+		routes = append(routes, "-inet6", "::/1", "-inet6", "8000::/1")
+	}
+	for i := 0; i < len(routes); i += 2 {
+		if err = run("route", "-q", "-n", "add", routes[i], routes[i+1], "-interface", name); err != nil {
+			return errSetupVPN("failed to add route", err, "name", name, "route", routes[i+1])
+		}
+	}
+	logger.Info("routes added", "name", name)
+
+	if len(c.dns) > 0 {
+		servers := make([]string, len(c.dns))
+		for i, dns := range c.dns {
+			servers[i] = dns.String()
+		}
+		// The empty supplemental match domain makes the resolver the default one for all domains.
+		if err = scutil(
+			"d.init",
+			"d.add ServerAddresses * "+strings.Join(servers, " "),
+			`d.add SupplementalMatchDomains * ""`,
+			"set "+c.dnsKey,
+		); err != nil {
+			return errSetupVPN("failed to set DNS servers", err, "name", name)
+		}
+		logger.Info("DNS servers set", "name", name, "servers", servers)
+	}
+	return nil
+}
+
+// Close restores the DNS configuration and deletes the utun device, together with its routes.
+func (c *darwinVPNConn) Close() (err error) {
+	if c == nil || c.tun == nil {
+		return nil
+	}
+	if len(c.dns) > 0 {
+		if err := scutil("remove " + c.dnsKey); err != nil {
+			logger.Warn("failed to remove DNS configuration", "err", err)
+		}
+	}
+	// this is the only error that matters
+	if err = c.tun.Close(); err != nil {
+		err = errCloseVPN("failed to delete utun device", err, "name", c.tun.Name())
+	} else {
+		logger.Info("utun device deleted", "name", c.tun.Name())
+	}
+	return
+}
+
+// run runs the command, returning its output in the error if it fails.
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// scutil runs the scutil commands.
+func scutil(cmds ...string) error {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader(strings.Join(append(cmds, "quit"), "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	if err == nil && strings.Contains(string(out), "No such key") {
+		err = fmt.Errorf("no such key")
+	}
+	if err != nil {
+		return fmt.Errorf("scutil: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
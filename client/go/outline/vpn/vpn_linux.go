@@ -17,8 +17,8 @@ package vpn
 import (
 	"context"
 	"io"
-	"log/slog"
 	"net"
+	"runtime"
 
 	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
 	gonm "github.com/Wifx/gonetworkmanager/v2"
@@ -34,13 +34,15 @@ type linuxVPNConn struct {
 
 var _ platformVPNConn = (*linuxVPNConn)(nil)
 
-// newPlatformVPNConn creates a new Linux-specific platformVPNConn.
+// newPlatformVPNConn creates a new Linux-specific platformVPNConn, through NetworkManager if it is
+// running, or else through netlink and systemd-resolved.
 // You need to call Establish() in order to make it connected.
 func newPlatformVPNConn(conf *Config) (_ platformVPNConn, err error) {
 	c := &linuxVPNConn{
 		nmOpts: &nmConnectionOptions{
 			Name:            conf.ConnectionName,
 			TUNName:         conf.InterfaceName,
+			TUNMTU:          conf.MTU,
 			TUNAddr4:        net.ParseIP(conf.IPAddress).To4(),
 			DNSServers4:     make([]net.IP, 0, 2),
 			FWMark:          conf.ProtectionMark,
@@ -58,18 +60,47 @@ func newPlatformVPNConn(conf *Config) (_ platformVPNConn, err error) {
 	if c.nmOpts.TUNAddr4 == nil {
 		return nil, errIllegalConfig("must provide a valid TUN interface IP(v4)")
 	}
+	if conf.MTU != 0 && (conf.MTU < 576 || conf.MTU > 65535) {
+		return nil, errIllegalConfig("TUN interface MTU must be between 576 and 65535", "mtu", conf.MTU)
+	}
+	if conf.IPv6Address != "" {
+		addr6 := net.ParseIP(conf.IPv6Address)
+		if addr6 == nil || addr6.To4() != nil || !addr6.IsPrivate() {
+			return nil, errIllegalConfig("TUN interface IPv6 must be a valid unique local address", "ipv6", conf.IPv6Address)
+		}
+		c.nmOpts.TUNAddr6 = addr6
+	}
 	for _, dns := range conf.DNSServers {
-		dnsIP := net.ParseIP(dns).To4()
-		if dnsIP == nil {
-			return nil, errIllegalConfig("DNS server must be a valid IP(v4)", "dns", dns)
+		dnsIP := net.ParseIP(dns)
+		if dnsIP4 := dnsIP.To4(); dnsIP4 != nil {
+			c.nmOpts.DNSServers4 = append(c.nmOpts.DNSServers4, dnsIP4)
+		} else if dnsIP != nil && c.nmOpts.TUNAddr6 != nil {
+			c.nmOpts.DNSServers6 = append(c.nmOpts.DNSServers6, dnsIP)
+		} else {
+			return nil, errIllegalConfig("DNS server must be a valid IP(v4), or IPv6 if IPv6 is enabled", "dns", dns)
 		}
-		c.nmOpts.DNSServers4 = append(c.nmOpts.DNSServers4, dnsIP)
+	}
+	if conf.AppSplitTunnel != nil {
+		// Android builds are linux builds too, but only the VpnService of the app may route the
+		// apps.
+		if runtime.GOOS == "android" {
+			return nil, errFeatureNotSupported("app split tunneling is not supported on Android")
+		}
+		if c.nmOpts.AppUIDRanges, err = parseAppUIDRanges(conf.AppSplitTunnel); err != nil {
+			return nil, err
+		}
+		c.nmOpts.AppSplitMode = conf.AppSplitTunnel.Mode
 	}
 
-	if c.nm, err = gonm.NewNetworkManager(); err != nil {
-		return nil, errSetupVPN("failed to connect NetworkManager DBus", err)
+	if c.nm, err = gonm.NewNetworkManager(); err == nil {
+		// The client is created even if NetworkManager is not running.
+		_, err = c.nm.GetPropertyVersion()
+	}
+	if err != nil {
+		logger.Info("NetworkManager is not available, configuring the VPN with netlink", "err", err)
+		return &netlinkVPNConn{opts: c.nmOpts}, nil
 	}
-	slog.Debug("NetworkManager DBus connected")
+	logger.Debug("NetworkManager DBus connected")
 
 	return c, nil
 }
@@ -86,12 +117,19 @@ func (c *linuxVPNConn) Establish(ctx context.Context) (err error) {
 	if c.tun, err = newTUNDevice(c.nmOpts.TUNName); err != nil {
 		return errSetupVPN("failed to create tun device", err, "name", c.nmOpts.Name)
 	}
-	slog.Info("tun device created", "name", c.nmOpts.TUNName)
+	logger.Info("tun device created", "name", c.nmOpts.TUNName)
+
+	if c.nmOpts.TUNMTU > 0 {
+		if err = setTUNDeviceMTU(c.nmOpts.TUNName, c.nmOpts.TUNMTU); err != nil {
+			return errSetupVPN("failed to set tun device MTU", err, "name", c.nmOpts.TUNName, "mtu", c.nmOpts.TUNMTU)
+		}
+		logger.Info("tun device MTU set", "name", c.nmOpts.TUNName, "mtu", c.nmOpts.TUNMTU)
+	}
 
 	if c.ac, err = establishNMConnection(c.nm, c.nmOpts); err != nil {
 		return
 	}
-	slog.Info("successfully configured NetworkManager connection", "conn", c.ac.GetPath())
+	logger.Info("successfully configured NetworkManager connection", "conn", c.ac.GetPath())
 	return nil
 }
 
@@ -107,7 +145,7 @@ func (c *linuxVPNConn) Close() (err error) {
 		if err = c.tun.Close(); err != nil {
 			err = errCloseVPN("failed to delete tun device", err, "name", c.nmOpts.TUNName)
 		} else {
-			slog.Info("tun device deleted", "name", c.nmOpts.TUNName)
+			logger.Info("tun device deleted", "name", c.nmOpts.TUNName)
 		}
 	}
 
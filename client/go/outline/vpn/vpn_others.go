@@ -12,10 +12,10 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build !linux
+//go:build !linux && !darwin
 
 package vpn
 
 func newPlatformVPNConn(conf *Config) (_ platformVPNConn, err error) {
-	panic("VPN connection not supported on non-Linux OS")
+	panic("VPN connection not supported on this OS")
 }
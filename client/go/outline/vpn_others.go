@@ -12,11 +12,41 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build !linux
+//go:build !linux && (!darwin || ios)
 
 package outline
 
-import "errors"
+import (
+	"errors"
+	"net"
 
-func establishVPN(configStr string) error { return errors.ErrUnsupported }
-func closeVPN() error                     { return errors.ErrUnsupported }
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+)
+
+// killSwitchSupported is false: the VPN of these platforms runs tun2socks in the apps, which can't
+// drop the traffic while the server is unreachable.
+const killSwitchSupported = false
+
+// establishVPN fails with [platerrors.FeatureNotSupported]: the apps of these platforms set up the
+// VPN themselves, e.g. with the VpnService on Android or the routing service on Windows, which
+// don't support the VPN config options like app split tunneling.
+func establishVPN(configStr string) error {
+	return platerrors.PlatformError{
+		Code:    platerrors.FeatureNotSupported,
+		Message: "the VPN is set up by the app on this platform",
+	}
+}
+
+func closeVPN() error { return errors.ErrUnsupported }
+
+func replaceVPNTransport(transportConfig string) error { return errors.ErrUnsupported }
+func applyKillSwitch()                                 {}
+func resumeVPN()                                       {}
+
+// newDirectDialer creates a TCP dialer whose connections bypass the VPN, which the apps of these
+// platforms exclude themselves from.
+func newDirectDialer() net.Dialer {
+	d := net.Dialer{KeepAlive: -1}
+	withSocketProtector(&d)
+	return d
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || (darwin && !ios)
+
+package outline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	perrs "github.com/Jigsaw-Code/outline-apps/client/go/outline/platerrors"
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/vpn"
+)
+
+// The health monitor of the active VPN connection.
+var vpnHealthMu sync.Mutex
+var vpnHealth *healthMonitor
+var vpnConn *vpn.VPNConnection
+var vpnTransport *transportDescriptionJSON
+var vpnProtectionMark uint32
+
+// vpnRequested is the VPN config of the active connection, as requested by the app, and
+// vpnApplied are the settings of the device, completed with the transport config.
+var vpnRequested vpn.Config
+var vpnApplied vpnDeviceSettings
+
+// vpnDeviceSettings are the settings of a VPN device that come from the transport config when the
+// VPN config doesn't set them. They cannot change without reconnecting the VPN.
+type vpnDeviceSettings struct {
+	BlockQUIC             bool
+	ICMPEcho              string
+	UDPIdleTimeoutSeconds int
+	UDPMaxSessions        int
+}
+
+// applyTransportSettings completes conf with the settings of the transport config of c that conf
+// doesn't set, and returns the resulting device settings.
+func applyTransportSettings(conf *vpn.Config, c *Client) vpnDeviceSettings {
+	if c.BlockQUIC {
+		conf.BlockQUIC = true
+	}
+	if conf.ICMPEcho == "" {
+		conf.ICMPEcho = string(c.ICMPEcho)
+	}
+	if conf.UDPIdleTimeoutSeconds == 0 {
+		conf.UDPIdleTimeoutSeconds = int(c.UDPIdleTimeout / time.Second)
+	}
+	if conf.UDPMaxSessions == 0 {
+		conf.UDPMaxSessions = c.UDPMaxSessions
+	}
+	return vpnDeviceSettings{
+		BlockQUIC:             conf.BlockQUIC,
+		ICMPEcho:              conf.ICMPEcho,
+		UDPIdleTimeoutSeconds: conf.UDPIdleTimeoutSeconds,
+		UDPMaxSessions:        conf.UDPMaxSessions,
+	}
+}
+
+// establishVPN establishes a VPN connection using the given configuration string.
+// The configuration string should be a JSON object containing the VPN configuration
+// and the transport configuration.
+//
+// The function returns a non-nil error if the connection fails.
+func establishVPN(configStr string) error {
+	var conf vpnConfigJSON
+	if err := json.Unmarshal([]byte(configStr), &conf); err != nil {
+		return perrs.PlatformError{
+			Code:    perrs.IllegalConfig,
+			Message: "invalid VPN config format",
+			Cause:   perrs.ToPlatformError(err),
+		}
+	}
+
+	tcp := newProtectedTCPDialer(conf.VPNConfig.ProtectionMark)
+	udp := newProtectedUDPDialer(conf.VPNConfig.ProtectionMark)
+	c, err := newClientWithBaseDialers(conf.TransportConfig, tcp, udp)
+	if err != nil {
+		return err
+	}
+	if err := c.CheckVPNRouting(); err != nil {
+		return err
+	}
+
+	requested := conf.VPNConfig
+	applied := applyTransportSettings(&conf.VPNConfig, c)
+	conn, err := vpn.EstablishVPN(context.Background(), &conf.VPNConfig, c, c, c.DNSForwarder, c.UDPFallback)
+	if err != nil {
+		return err
+	}
+
+	vpnHealthMu.Lock()
+	defer vpnHealthMu.Unlock()
+	if vpnHealth != nil {
+		vpnHealth.stop()
+	}
+	vpnConn = conn
+	vpnHealth = newHealthMonitor(conn.RefreshConnectivity, defaultHealthCheckInterval, c.reconnect, func(status string) {
+		conn.SetBlocked(killSwitchEnabled() && status != ConnectionStatusConnected)
+	})
+	vpnTransport = c.description
+	vpnProtectionMark = conf.VPNConfig.ProtectionMark
+	vpnRequested, vpnApplied = requested, applied
+	setActiveTransport(vpnTransport)
+	return nil
+}
+
+// killSwitchSupported is true: the VPN connections block their traffic with
+// [vpn.VPNConnection.SetBlocked].
+const killSwitchSupported = true
+
+// applyKillSwitch blocks the traffic of the active VPN connection if the kill switch is enabled
+// and the connection is not healthy, and unblocks it otherwise.
+func applyKillSwitch() {
+	vpnHealthMu.Lock()
+	defer vpnHealthMu.Unlock()
+	if vpnConn != nil && vpnHealth != nil {
+		vpnConn.SetBlocked(killSwitchEnabled() && vpnHealth.Status() != ConnectionStatusConnected)
+		// Check right away rather than at the next interval, so that a connection that gave up
+		// reconnecting isn't blocked for longer than needed.
+		vpnHealth.checkNow()
+	}
+}
+
+// resumeTimeout bounds how long re-dialing the UDP sessions takes after a network change.
+const resumeTimeout = 10 * time.Second
+
+// resumeVPN moves the active VPN connection, if any, to the network the device just switched to,
+// and makes its health monitor check the connectivity right away. It doesn't block.
+func resumeVPN() {
+	vpnHealthMu.Lock()
+	conn, health := vpnConn, vpnHealth
+	vpnHealthMu.Unlock()
+	if conn == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+		defer cancel()
+		if err := conn.ResumeAfterNetworkChange(ctx); err != nil {
+			logger.Warn("failed to resume the VPN after network change", "err", err)
+		}
+		if health != nil {
+			health.checkNow()
+		}
+	}()
+}
+
+// replaceVPNTransport makes the active VPN connection relay through the transport config,
+// without tearing down the VPN, so that switching servers keeps the VPN up.
+//
+// The transport config must not change the settings of the device, like the QUIC policy, which
+// only apply when the VPN connects.
+func replaceVPNTransport(transportConfig string) error {
+	vpnHealthMu.Lock()
+	mark := vpnProtectionMark
+	requested, applied := vpnRequested, vpnApplied
+	vpnHealthMu.Unlock()
+
+	tcp := newProtectedTCPDialer(mark)
+	udp := newProtectedUDPDialer(mark)
+	c, err := newClientWithBaseDialers(transportConfig, tcp, udp)
+	if err != nil {
+		return err
+	}
+	if err := c.CheckVPNRouting(); err != nil {
+		return err
+	}
+	if settings := applyTransportSettings(&requested, c); settings != applied {
+		return newIllegalConfigErrorWithDetails(
+			"the transport config changes settings that only apply when the VPN connects, reconnect instead",
+			"transport", fmt.Sprintf("%+v", settings), fmt.Sprintf("%+v", applied), nil)
+	}
+	if err := vpn.ReplaceTransport(context.Background(), c, c, c.DNSForwarder, c.UDPFallback); err != nil {
+		return err
+	}
+
+	vpnHealthMu.Lock()
+	defer vpnHealthMu.Unlock()
+	clearActiveTransport(vpnTransport)
+	vpnTransport = c.description
+	setActiveTransport(vpnTransport)
+	if vpnHealth != nil {
+		// The new transport may reach a server the previous one couldn't.
+		vpnHealth.checkNow()
+	}
+	return nil
+}
+
+// newDirectDialer creates a TCP dialer whose connections bypass the active VPN connection, if any.
+func newDirectDialer() net.Dialer {
+	vpnHealthMu.Lock()
+	mark := vpnProtectionMark
+	vpnHealthMu.Unlock()
+	d := newProtectedTCPDialer(mark)
+	withSocketProtector(&d)
+	return d
+}
+
+// closeVPN closes the currently active VPN connection.
+func closeVPN() error {
+	vpnHealthMu.Lock()
+	if vpnHealth != nil {
+		vpnHealth.stop()
+		vpnHealth = nil
+	}
+	vpnConn = nil
+	clearActiveTransport(vpnTransport)
+	vpnTransport = nil
+	vpnHealthMu.Unlock()
+	return vpn.CloseVPN()
+}
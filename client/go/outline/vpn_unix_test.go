@@ -0,0 +1,34 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || (darwin && !ios)
+
+package outline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-apps/client/go/outline/vpn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTransportSettings(t *testing.T) {
+	c := &Client{BlockQUIC: true, ICMPEcho: "reply", UDPIdleTimeout: time.Minute, UDPMaxSessions: 64}
+	conf := vpn.Config{ICMPEcho: "off"}
+	require.Equal(t, vpnDeviceSettings{BlockQUIC: true, ICMPEcho: "off", UDPIdleTimeoutSeconds: 60, UDPMaxSessions: 64},
+		applyTransportSettings(&conf, c))
+	require.True(t, conf.BlockQUIC)
+	require.Equal(t, 60, conf.UDPIdleTimeoutSeconds)
+}
@@ -0,0 +1,20 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wintun creates TUN devices on Windows with the Wintun driver, see
+// https://www.wintun.net. Unlike the TAP-Windows6 driver, Wintun doesn't need to be installed:
+// wintun.dll installs it on demand, and exchanges the packets with it through shared ring buffers.
+//
+// wintun.dll must be next to the executable.
+package wintun
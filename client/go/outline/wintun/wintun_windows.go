@@ -0,0 +1,244 @@
+// Copyright 2024 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package wintun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// tunnelType is the type of the adapters, shown in the network settings.
+	tunnelType = "Outline"
+
+	// ringCapacity is the size of the ring buffers of a session: a power of 2 between 128 KiB and
+	// 64 MiB.
+	ringCapacity = 4 << 20
+)
+
+// procs are the functions of wintun.dll.
+var procs struct {
+	createAdapter        uintptr
+	closeAdapter         uintptr
+	startSession         uintptr
+	endSession           uintptr
+	getReadWaitEvent     uintptr
+	receivePacket        uintptr
+	releaseReceivePacket uintptr
+	allocateSendPacket   uintptr
+	sendPacket           uintptr
+}
+
+var loadOnce = sync.OnceValue(load)
+
+// load loads wintun.dll from the directory of the executable, never from the current directory
+// or the PATH.
+func load() error {
+	dll, err := windows.LoadLibraryEx("wintun.dll", 0,
+		windows.LOAD_LIBRARY_SEARCH_APPLICATION_DIR|windows.LOAD_LIBRARY_SEARCH_SYSTEM32)
+	if err != nil {
+		return fmt.Errorf("failed to load wintun.dll: %w", err)
+	}
+	for name, proc := range map[string]*uintptr{
+		"WintunCreateAdapter":        &procs.createAdapter,
+		"WintunCloseAdapter":         &procs.closeAdapter,
+		"WintunStartSession":         &procs.startSession,
+		"WintunEndSession":           &procs.endSession,
+		"WintunGetReadWaitEvent":     &procs.getReadWaitEvent,
+		"WintunReceivePacket":        &procs.receivePacket,
+		"WintunReleaseReceivePacket": &procs.releaseReceivePacket,
+		"WintunAllocateSendPacket":   &procs.allocateSendPacket,
+		"WintunSendPacket":           &procs.sendPacket,
+	} {
+		if *proc, err = windows.GetProcAddress(dll, name); err != nil {
+			windows.FreeLibrary(dll)
+			return fmt.Errorf("failed to find %s in wintun.dll: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Device is a Wintun adapter with an active session. Each Read returns one IP packet of the
+// system, and each Write sends one IP packet to the system.
+type Device struct {
+	name    string
+	adapter uintptr
+	session uintptr
+	readEv  windows.Handle
+
+	// closeEv wakes up the pending reads when the device is closed. The reads and writes hold mu
+	// for reading, so that Close only ends the session once they are done with it.
+	closeEv   windows.Handle
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+}
+
+// Create creates the Wintun adapter called name, with the IPv4 address addr in the network of
+// mask, and the DNS servers dns. The adapter is removed when the device is closed.
+func Create(name, addr, mask string, dns []string) (*Device, error) {
+	if err := loadOnce(); err != nil {
+		return nil, err
+	}
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	type16, err := windows.UTF16PtrFromString(tunnelType)
+	if err != nil {
+		return nil, err
+	}
+	adapter, _, errno := syscall.SyscallN(procs.createAdapter,
+		uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(type16)), 0)
+	if adapter == 0 {
+		return nil, fmt.Errorf("failed to create Wintun adapter %q: %w", name, errno)
+	}
+	d := &Device{name: name, adapter: adapter}
+	if err := configure(name, addr, mask, dns); err != nil {
+		d.release()
+		return nil, err
+	}
+	if d.session, _, errno = syscall.SyscallN(procs.startSession, adapter, ringCapacity); d.session == 0 {
+		d.release()
+		return nil, fmt.Errorf("failed to start Wintun session: %w", errno)
+	}
+	ev, _, _ := syscall.SyscallN(procs.getReadWaitEvent, d.session)
+	d.readEv = windows.Handle(ev)
+	if d.closeEv, err = windows.CreateEvent(nil, 1, 0, nil); err != nil {
+		d.release()
+		return nil, err
+	}
+	return d, nil
+}
+
+// configure sets the address and the DNS servers of the adapter called name.
+func configure(name, addr, mask string, dns []string) error {
+	commands := [][]string{
+		{"interface", "ipv4", "set", "address", "name=" + name, "source=static", "address=" + addr, "mask=" + mask, "gateway=none"},
+		{"interface", "ipv4", "set", "dnsservers", "name=" + name, "source=static", "address=none", "validate=no", "register=none"},
+	}
+	for i, server := range dns {
+		commands = append(commands, []string{"interface", "ipv4", "add", "dnsservers", "name=" + name,
+			"address=" + server, fmt.Sprintf("index=%d", i+1), "validate=no"})
+	}
+	for _, args := range commands {
+		if out, err := exec.Command("netsh", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to configure Wintun adapter: netsh %s: %w: %s",
+				strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// Read reads the next IP packet into b, truncated if b is too small.
+func (d *Device) Read(b []byte) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for {
+		if d.closed {
+			return 0, os.ErrClosed
+		}
+		var size uint32
+		packet, _, errno := syscall.SyscallN(procs.receivePacket, d.session, uintptr(unsafe.Pointer(&size)))
+		if packet != 0 {
+			n := copy(b, unsafe.Slice(bytePointer(packet), size))
+			syscall.SyscallN(procs.releaseReceivePacket, d.session, packet)
+			return n, nil
+		}
+		switch errno {
+		case windows.ERROR_NO_MORE_ITEMS:
+			event, err := windows.WaitForMultipleObjects([]windows.Handle{d.readEv, d.closeEv}, false, windows.INFINITE)
+			if err != nil {
+				return 0, err
+			}
+			if event == windows.WAIT_OBJECT_0+1 {
+				return 0, os.ErrClosed
+			}
+		case windows.ERROR_HANDLE_EOF:
+			return 0, os.ErrClosed
+		default:
+			return 0, fmt.Errorf("failed to receive packet: %w", errno)
+		}
+	}
+}
+
+// Write sends the IP packet b. The packet is dropped if the ring buffer is full, like a
+// congested network would.
+func (d *Device) Write(b []byte) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+	packet, _, errno := syscall.SyscallN(procs.allocateSendPacket, d.session, uintptr(len(b)))
+	if packet == 0 {
+		switch errno {
+		case windows.ERROR_BUFFER_OVERFLOW:
+			return len(b), nil
+		case windows.ERROR_HANDLE_EOF:
+			return 0, os.ErrClosed
+		default:
+			return 0, fmt.Errorf("failed to allocate packet: %w", errno)
+		}
+	}
+	copy(unsafe.Slice(bytePointer(packet), len(b)), b)
+	syscall.SyscallN(procs.sendPacket, d.session, packet)
+	return len(b), nil
+}
+
+// Close ends the session and removes the adapter.
+func (d *Device) Close() error {
+	err := os.ErrClosed
+	d.closeOnce.Do(func() {
+		windows.SetEvent(d.closeEv)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.closed = true
+		d.release()
+		err = nil
+	})
+	return err
+}
+
+// release ends the session and closes the adapter, if they were created.
+func (d *Device) release() {
+	if d.session != 0 {
+		syscall.SyscallN(procs.endSession, d.session)
+		d.session = 0
+	}
+	if d.adapter != 0 {
+		syscall.SyscallN(procs.closeAdapter, d.adapter)
+		d.adapter = 0
+	}
+	if d.closeEv != 0 {
+		windows.CloseHandle(d.closeEv)
+		d.closeEv = 0
+	}
+}
+
+// bytePointer converts the address of a packet in the ring buffers, which the Go runtime doesn't
+// manage, to a pointer.
+func bytePointer(addr uintptr) *byte {
+	return *(**byte)(unsafe.Pointer(&addr))
+}
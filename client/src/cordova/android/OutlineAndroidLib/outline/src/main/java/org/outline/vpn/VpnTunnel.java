@@ -81,6 +81,9 @@ public class VpnTunnel {
                   VPN_INTERFACE_PREFIX_LENGTH)
               .addDnsServer(dnsResolverAddress)
               .setBlocking(true)
+              // The sockets of the Outline clients are protected by the service, but the system
+              // resolver used to look up the server hostnames is not, so the app still bypasses
+              // the VPN.
               .addDisallowedApplication(vpnService.getPackageName());
 
       if (Build.VERSION.SDK_INT >= Build.VERSION_CODES.M) {
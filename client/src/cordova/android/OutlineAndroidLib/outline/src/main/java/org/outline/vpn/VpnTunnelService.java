@@ -42,6 +42,7 @@ import org.outline.DetailedJsonError;
 import org.outline.log.SentryErrorReporter;
 import outline.NewClientResult;
 import outline.Outline;
+import outline.SocketProtector;
 import outline.TCPAndUDPConnectivityResult;
 import platerrors.Platerrors;
 import platerrors.PlatformError;
@@ -119,6 +120,14 @@ public class VpnTunnelService extends VpnService {
     vpnTunnel = new VpnTunnel(this);
     networkConnectivityMonitor = new NetworkConnectivityMonitor();
     tunnelStore = new VpnTunnelStore(VpnTunnelService.this);
+    // Protect the sockets of the Outline clients from the VPN, so their traffic does not loop
+    // back into the tunnel.
+    Outline.setSocketProtector(new SocketProtector() {
+      @Override
+      public boolean protect(long fd) {
+        return VpnTunnelService.this.protect((int) fd);
+      }
+    });
   }
 
   @Override
@@ -166,6 +175,7 @@ public class VpnTunnelService extends VpnService {
   public void onDestroy() {
     LOG.info("Destroying VPN service.");
     tearDownActiveTunnel();
+    Outline.setSocketProtector(null);
   }
 
   public VpnService.Builder newBuilder() {
@@ -17,7 +17,7 @@ import {OperationTimedOut} from '@outline/infrastructure/timeout_promise';
 
 import {Clipboard} from './clipboard';
 import {EnvironmentVariables} from './environment';
-import {localizeErrorCode} from './error_localizer';
+import {localizeErrorCode, localizeErrorHint} from './error_localizer';
 import {OutlineServerRepository} from './outline_server_repository';
 import * as config from './outline_server_repository/config';
 import {Settings, SettingsKey} from './settings';
@@ -301,6 +301,12 @@ export class App {
         'cipher',
         error.cipher
       );
+    } else if (error instanceof errors.ShadowsocksUnsupportedPlugin) {
+      toastMessage = this.localize(
+        'error-shadowsocks-unsupported-plugin',
+        'plugin',
+        error.plugin
+      );
     } else if (error instanceof errors.ServerAccessKeyInvalid) {
       toastMessage = this.localize('error-connection-configuration');
       buttonMessage = this.localize('error-details');
@@ -329,8 +335,21 @@ export class App {
       buttonHandler = () => {
         this.showErrorDetailsDialog(error.details);
       };
+      if (error.url) {
+        const url = error.url;
+        buttonMessage = this.localize(
+          error.action ? `error-provider-action-${error.action}` : 'learn-more'
+        );
+        buttonHandler = () => {
+          globalThis.open(url);
+        };
+      }
     } else if (error instanceof PlatformError) {
       toastMessage = localizeErrorCode(error.code, this.localize);
+      const hint = localizeErrorHint(error, this.localize);
+      if (hint) {
+        toastMessage = `${toastMessage} ${hint}`;
+      }
       buttonMessage = this.localize('error-details');
       buttonHandler = () => this.showErrorDetailsDialog(error.toString());
     } else {
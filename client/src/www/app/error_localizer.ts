@@ -19,7 +19,23 @@ import * as perr from '../model/platform_error';
 const errCodeMapping = new Map<perr.ErrorCode, string>([
   [perr.FETCH_CONFIG_FAILED, 'error-connection-configuration-fetch'],
   [perr.ILLEGAL_CONFIG, 'error-connection-configuration'],
+  [perr.CONFIG_REQUIRES_NEWER_APP, 'error-connection-configuration-newer-app'],
+  [
+    perr.CONFIG_SIGNATURE_INVALID,
+    'error-connection-configuration-signature',
+  ],
+  [perr.CONFIG_TOO_LARGE, 'error-connection-configuration'],
   [perr.PROXY_SERVER_UNREACHABLE, 'outline-plugin-error-server-unreachable'],
+  [
+    perr.PROXY_SERVER_UDP_NOT_SUPPORTED,
+    'outline-plugin-error-udp-forwarding-not-enabled',
+  ],
+  [perr.UNAUTHENTICATED, 'outline-plugin-error-invalid-server-credentials'],
+  [perr.QUOTA_EXCEEDED, 'error-quota-exceeded'],
+  [perr.DEVICE_CLOCK_SKEWED, 'error-device-clock-skewed'],
+  [perr.RESOLVE_IP_FAILED, 'error-resolve-ip'],
+  [perr.TLS_INTERCEPTED, 'error-tls-intercepted'],
+  [perr.CERTIFICATE_PIN_MISMATCH, 'error-tls-intercepted'],
   [
     perr.VPN_PERMISSION_NOT_GRANTED,
     'outline-plugin-error-vpn-permission-not-granted',
@@ -32,3 +48,25 @@ export function localizeErrorCode(
 ): string {
   return localize(errCodeMapping.get(code) || 'error-unexpected');
 }
+
+const hintMapping = new Map<perr.Hint, string>([
+  [perr.HINT_CHECK_INTERNET, 'error-hint-check-internet'],
+  [perr.HINT_CHECK_ACCESS_KEY, 'error-hint-check-access-key'],
+  [perr.HINT_UNTRUSTED_NETWORK, 'error-hint-untrusted-network'],
+  [perr.HINT_ENABLE_UDP_OVER_TCP, 'error-hint-enable-udp-over-tcp'],
+  [perr.HINT_CONTACT_PROVIDER, 'error-hint-contact-provider'],
+  [perr.HINT_FIX_DEVICE_CLOCK, 'error-hint-fix-device-clock'],
+]);
+
+/**
+ * Returns the localized guidance for the remediation hint of the error, or undefined if it has no
+ * known hint.
+ */
+export function localizeErrorHint(
+  error: perr.PlatformError,
+  localize: Localizer
+): string | undefined {
+  const hint = error.details?.[perr.HINT_DETAILS_KEY];
+  const messageKey = typeof hint === 'string' && hintMapping.get(hint);
+  return messageKey ? localize(messageKey) : undefined;
+}
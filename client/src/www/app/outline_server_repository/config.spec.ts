@@ -12,8 +12,11 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+import {languageList} from '@outline/infrastructure/i18n';
 import {makeConfig, SIP002_URI} from 'ShadowsocksConfig';
 
+import * as errors from '../../model/errors';
+
 import * as config from './config';
 
 describe('getAddressFromTransport', () => {
@@ -43,6 +46,51 @@ describe('getAddressFromTransport', () => {
   });
 });
 
+describe('getFirstHopsFromTransportConfig', () => {
+  const server = {host: 'example.com', port: 443};
+
+  it('returns the server for TCP and UDP', () => {
+    expect(config.TEST_ONLY.getFirstHopsFromTransportConfig(server)).toEqual({
+      firstHopTcp: [server],
+      firstHopUdp: [server],
+    });
+  });
+
+  it('returns the outbound proxy for TCP', () => {
+    expect(
+      config.TEST_ONLY.getFirstHopsFromTransportConfig({
+        ...server,
+        outboundProxy: 'socks5://[2001:db8::1]',
+      })
+    ).toEqual({
+      firstHopTcp: [{host: '2001:db8::1', port: 1080}],
+      firstHopUdp: [server],
+    });
+    expect(
+      config.TEST_ONLY.getFirstHopsFromTransportConfig({
+        ...server,
+        outboundProxy: 'system',
+      })
+    ).toEqual({firstHopUdp: [server]});
+  });
+
+  it('returns only UDP for QUIC transports', () => {
+    expect(
+      config.TEST_ONLY.getFirstHopsFromTransportConfig({
+        $type: 'hysteria2',
+        ...server,
+        ports: '20000-30000',
+      })
+    ).toEqual({firstHopUdp: [{host: 'example.com', port: undefined}]});
+  });
+
+  it('returns nothing for unknown transports', () => {
+    expect(
+      config.TEST_ONLY.getFirstHopsFromTransportConfig({$type: 'multi'})
+    ).toEqual({});
+  });
+});
+
 describe('setTransportHost', () => {
   it('sets host', () => {
     expect(
@@ -84,6 +132,8 @@ describe('parseTunnelConfig', () => {
         host: 'example.com',
         port: 443,
       },
+      firstHopTcp: [{host: 'example.com', port: 443}],
+      firstHopUdp: [{host: 'example.com', port: 443}],
       transport: {
         host: 'example.com',
         port: 443,
@@ -103,6 +153,8 @@ describe('parseTunnelConfig', () => {
         host: 'example.com',
         port: 443,
       },
+      firstHopTcp: [{host: 'example.com', port: 443}],
+      firstHopUdp: [{host: 'example.com', port: 443}],
       transport: {
         host: 'example.com',
         port: 443,
@@ -127,6 +179,8 @@ describe('parseTunnelConfig', () => {
         host: 'example.com',
         port: 443,
       },
+      firstHopTcp: [{host: 'example.com', port: 443}],
+      firstHopUdp: [{host: 'example.com', port: 443}],
       transport: {
         host: 'example.com',
         port: 443,
@@ -136,6 +190,99 @@ describe('parseTunnelConfig', () => {
     });
   });
 
+  it('parses Shadowsocks 2022 URL', () => {
+    expect(
+      config.parseTunnelConfig(
+        'ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%3D%3D@[2001:db8::1]:8388/?prefix=POST%20#name'
+      )
+    ).toEqual({
+      firstHop: {
+        host: '2001:db8::1',
+        port: 8388,
+      },
+      firstHopTcp: [{host: '2001:db8::1', port: 8388}],
+      firstHopUdp: [{host: '2001:db8::1', port: 8388}],
+      transport: {
+        host: '2001:db8::1',
+        port: 8388,
+        method: '2022-blake3-aes-128-gcm',
+        password: 'YctPZ6U7xPPcU+gp3u+0tx==',
+        prefix: 'POST ',
+      },
+    });
+  });
+
+  it('rejects plugins', () => {
+    expect(() =>
+      config.parseTunnelConfig(
+        'ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpQQVNTV09SRA@example.com:443/?plugin=obfs-local%3Bobfs%3Dhttp'
+      )
+    ).toThrowError(errors.ShadowsocksUnsupportedPlugin);
+    expect(() =>
+      config.parseTunnelConfig(
+        '{"server": "example.com", "server_port": 443, "method": "chacha20-ietf-poly1305", "password": "PASSWORD", "plugin": "v2ray-plugin"}'
+      )
+    ).toThrowError(errors.ShadowsocksUnsupportedPlugin);
+  });
+
+  it('localizes provider errors', () => {
+    const providerError = JSON.stringify({
+      error: {
+        message: 'Your subscription expired',
+        messages: {
+          en: 'Your subscription has expired',
+          'fa-IR': 'اشتراک شما تمام شد',
+        },
+        details: 'expired on 2024-01-01',
+      },
+    });
+    const parse = (languages: string[]): errors.SessionProviderError => {
+      try {
+        config.parseTunnelConfig(providerError, languageList(languages));
+      } catch (e) {
+        return e as errors.SessionProviderError;
+      }
+      throw new Error('expected a provider error');
+    };
+
+    const fa = parse(['fa']);
+    expect(fa).toBeInstanceOf(errors.SessionProviderError);
+    expect(fa.message).toEqual('اشتراک شما تمام شد');
+    expect(fa.details).toEqual('expired on 2024-01-01');
+    expect(parse(['my', 'en-US']).message).toEqual(
+      'Your subscription has expired'
+    );
+    expect(parse(['my']).message).toEqual('Your subscription expired');
+  });
+
+  it('passes provider error URL and action through', () => {
+    const parse = (error: object): errors.SessionProviderError => {
+      try {
+        config.parseTunnelConfig(JSON.stringify({error}));
+      } catch (e) {
+        return e as errors.SessionProviderError;
+      }
+      throw new Error('expected a provider error');
+    };
+
+    const renew = parse({
+      message: 'Your subscription has expired',
+      url: 'https://example.com/renew',
+      action: 'renew',
+    });
+    expect(renew.url).toEqual('https://example.com/renew');
+    expect(renew.action).toEqual('renew');
+
+    const invalid = parse({
+      message: 'Your subscription has expired',
+      url: 'javascript:alert(1)',
+      action: 'delete',
+    });
+    expect(invalid.message).toEqual('Your subscription has expired');
+    expect(invalid.url).toBeUndefined();
+    expect(invalid.action).toBeUndefined();
+  });
+
   it('parses URL with blanks', () => {
     const ssUrl = SIP002_URI.stringify(
       makeConfig({
@@ -150,6 +297,8 @@ describe('parseTunnelConfig', () => {
         host: 'example.com',
         port: 443,
       },
+      firstHopTcp: [{host: 'example.com', port: 443}],
+      firstHopUdp: [{host: 'example.com', port: 443}],
       transport: {
         host: 'example.com',
         port: 443,
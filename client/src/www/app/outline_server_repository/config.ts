@@ -12,12 +12,19 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+import {
+  getBrowserLanguages,
+  LanguageCode,
+  LanguageMatcher,
+  languageList,
+} from '@outline/infrastructure/i18n';
 import {SHADOWSOCKS_URI} from 'ShadowsocksConfig';
 
 import * as errors from '../../model/errors';
 
 export const TEST_ONLY = {
   getAddressFromTransportConfig: getAddressFromTransportConfig,
+  getFirstHopsFromTransportConfig: getFirstHopsFromTransportConfig,
   serviceNameFromAccessKey: serviceNameFromAccessKey,
 };
 
@@ -60,7 +67,15 @@ class EndpointAddress {
  * This is where VPN-layer parameters would go (e.g. interface IP, routes, dns, etc.).
  */
 export interface TunnelConfigJson {
+  /** firstHop is the address of the tunnel server, shown in the UI. */
   firstHop: EndpointAddress | undefined;
+  /**
+   * firstHopTcp and firstHopUdp are the hosts the transport connects to directly, by protocol,
+   * which must all be excluded from the tunnel. They differ when e.g. the TCP connections go
+   * through an outbound proxy but the UDP packets go to the server. They are undefined if unknown.
+   */
+  firstHopTcp?: EndpointAddress[];
+  firstHopUdp?: EndpointAddress[];
   /** transport describes how to establish connections to the destinations.
    * See https://github.com/Jigsaw-Code/outline-apps/blob/master/client/go/outline/config.go for format. */
   transport: TransportConfigJson;
@@ -87,6 +102,65 @@ function getAddressFromTransportConfig(
   }
 }
 
+/**
+ * getFirstHopsFromTransportConfig returns the hosts the transport connects to directly, by
+ * protocol, like FirstHopTCP and FirstHopUDP of the parsed tunnel configs of the Go code.
+ */
+function getFirstHopsFromTransportConfig(
+  transport: TransportConfigJson
+): Pick<TunnelConfigJson, 'firstHopTcp' | 'firstHopUdp'> {
+  const config: {
+    $type?: string;
+    host?: string;
+    port?: number;
+    ports?: string;
+    outboundProxy?: string;
+  } = transport;
+  if (!config.host) {
+    return {};
+  }
+  switch (config.$type ?? 'shadowsocks') {
+    case 'shadowsocks': {
+      const server = {host: config.host, port: config.port};
+      if (!config.outboundProxy || config.outboundProxy === 'direct') {
+        return {firstHopTcp: [server], firstHopUdp: [server]};
+      }
+      // The proxy of the system settings is only known to the networking layer.
+      if (config.outboundProxy === 'system') {
+        return {firstHopUdp: [server]};
+      }
+      const proxyUrl = new URL(config.outboundProxy);
+      const defaultPort = proxyUrl.protocol === 'http:' ? 80 : 1080;
+      const proxy = {
+        host: proxyUrl.hostname.replace(/^\[(.*)\]$/, '$1'),
+        port: proxyUrl.port ? Number(proxyUrl.port) : defaultPort,
+      };
+      return {firstHopTcp: [proxy], firstHopUdp: [server]};
+    }
+    case 'hysteria2':
+    case 'tuic':
+      // The port is omitted when the transport hops between ports.
+      return {
+        firstHopUdp: [
+          {host: config.host, port: config.ports ? undefined : config.port},
+        ],
+      };
+    default:
+      return {};
+  }
+}
+
+/**
+ * newTunnelConfig returns the TunnelConfigJson of the transport, with its first hops.
+ */
+function newTunnelConfig(transport: TransportConfigJson): TunnelConfigJson {
+  return {
+    transport,
+    firstHop: getAddressFromTransportConfig(transport),
+    ...getFirstHopsFromTransportConfig(transport),
+  };
+}
+
 /**
  * setTransportConfigHost returns a new TransportConfigJson with the given host as the tunnel server.
  * Should only be set if getHostFromTransportConfig returns one.
@@ -103,14 +177,31 @@ export function setTransportConfigHost(
   return {...transport, host: newHost};
 }
 
+/**
+ * ProviderErrorJson is the `error` section a provider returns instead of a tunnel config.
+ */
+interface ProviderErrorJson {
+  message: string;
+  /** messages are the localized messages by language code, e.g. {"en": "...", "fa": "..."}. */
+  messages?: {[languageCode: string]: string};
+  details?: string;
+  /** url is an HTTPS page of the provider, e.g. to renew the subscription. */
+  url?: string;
+  action?: errors.ProviderErrorAction;
+}
+
 /**
  * parseTunnelConfig parses the given tunnel config as text and returns a new TunnelConfigJson.
  * The config text may be a "ss://" link or a JSON object.
  * This is used by the server to parse the config fetched from the dynamic key, and to parse
  * static keys as tunnel configs (which may be present in the dynamic config).
+ *
+ * If the config is a provider error, the message is localized to the first of userLanguages the
+ * provider has a message for. It defaults to the languages the app is displayed in.
  */
 export function parseTunnelConfig(
-  tunnelConfigText: string
+  tunnelConfigText: string,
+  userLanguages?: LanguageCode[]
 ): TunnelConfigJson | null {
   tunnelConfigText = tunnelConfigText.trim();
   if (tunnelConfigText.startsWith('ss://')) {
@@ -120,12 +211,22 @@ export function parseTunnelConfig(
   const responseJson = JSON.parse(tunnelConfigText);
 
   if ('error' in responseJson) {
+    const providerError: ProviderErrorJson = responseJson.error;
     throw new errors.SessionProviderError(
-      responseJson.error.message,
-      responseJson.error.details
+      localizeProviderErrorMessage(
+        providerError,
+        userLanguages ?? getUserLanguages()
+      ),
+      providerError.details,
+      {
+        url: validateProviderErrorUrl(providerError.url),
+        action: validateProviderErrorAction(providerError.action),
+      }
     );
   }
 
+  checkShadowsocksPlugin(responseJson.plugin);
+
   // TODO(fortuna): stop converting to the Go format. Let the Go code convert.
   // We don't validate the method because that's already done in the Go code as
   // part of the Dynamic Key connection flow.
@@ -138,20 +239,78 @@ export function parseTunnelConfig(
   if (responseJson.prefix) {
     (transport as {prefix?: string}).prefix = responseJson.prefix;
   }
-  return {
-    transport,
-    firstHop: getAddressFromTransportConfig(transport),
-  };
+  return newTunnelConfig(transport);
+}
+
+/**
+ * localizeProviderErrorMessage returns the message of the provider error that best matches the
+ * user languages, falling back to the untranslated message.
+ */
+function localizeProviderErrorMessage(
+  providerError: ProviderErrorJson,
+  userLanguages: LanguageCode[]
+): string {
+  const messages = providerError.messages;
+  if (!messages || typeof messages !== 'object') {
+    return providerError.message;
+  }
+  const matcher = new LanguageMatcher(
+    languageList(
+      Object.keys(messages).filter(
+        language => typeof messages[language] === 'string'
+      )
+    )
+  );
+  const language = matcher.getBestSupportedLanguage(userLanguages);
+  return language ? messages[language.string()] : providerError.message;
+}
+
+/**
+ * validateProviderErrorUrl returns the URL of a provider error if it's a valid HTTPS URL, so that
+ * a provider can't make the app open other kinds of links.
+ */
+function validateProviderErrorUrl(url: unknown): string | undefined {
+  if (typeof url !== 'string') {
+    return undefined;
+  }
+  try {
+    return new URL(url).protocol === 'https:' ? url : undefined;
+  } catch {
+    return undefined;
+  }
+}
+
+/** validateProviderErrorAction returns the action of a provider error if it's a known one. */
+function validateProviderErrorAction(
+  action: unknown
+): errors.ProviderErrorAction | undefined {
+  return action === 'renew' || action === 'contact' ? action : undefined;
+}
+
+/** getUserLanguages returns the languages of the user, starting with the one they picked. */
+function getUserLanguages(): LanguageCode[] {
+  const languages = getBrowserLanguages();
+  const overrideLanguage =
+    globalThis.localStorage?.getItem('overrideLanguage');
+  if (overrideLanguage) {
+    languages.unshift(new LanguageCode(overrideLanguage));
+  }
+  return languages;
 }
 
 /** Parses an access key string into a TunnelConfig object. */
 function staticKeyToTunnelConfig(staticKey: string): TunnelConfigJson {
+  const ss2022Config = parseShadowsocks2022Key(staticKey);
+  if (ss2022Config) {
+    return ss2022Config;
+  }
   const config = SHADOWSOCKS_URI.parse(staticKey);
   if (!isShadowsocksCipherSupported(config.method.data)) {
     throw new errors.ShadowsocksUnsupportedCipher(
       config.method.data || 'unknown'
     );
   }
+  checkShadowsocksPlugin(config.extra?.['plugin']);
   const transport: TransportConfigJson = {
     host: config.host.data,
     port: config.port.data,
@@ -161,10 +320,34 @@ function staticKeyToTunnelConfig(staticKey: string): TunnelConfigJson {
   if (config.extra?.['prefix']) {
     (transport as {prefix?: string}).prefix = config.extra?.['prefix'];
   }
-  return {
-    transport,
-    firstHop: getAddressFromTransportConfig(transport),
+  return newTunnelConfig(transport);
+}
+
+/**
+ * parseShadowsocks2022Key parses a SIP002 key of a Shadowsocks 2022 cipher, whose user info is
+ * percent-encoded instead of base64-encoded. It returns null for the other keys.
+ */
+function parseShadowsocks2022Key(staticKey: string): TunnelConfigJson | null {
+  const url = new URL(staticKey.replace(/^ss:\/\//, 'https://'));
+  const method = decodeURIComponent(url.username).toLowerCase();
+  if (!method.startsWith('2022-')) {
+    return null;
+  }
+  if (!isShadowsocksCipherSupported(method)) {
+    throw new errors.ShadowsocksUnsupportedCipher(method);
+  }
+  checkShadowsocksPlugin(url.searchParams.get('plugin'));
+  const transport: TransportConfigJson = {
+    host: url.hostname.replace(/^\[(.*)\]$/, '$1'),
+    port: url.port ? Number(url.port) : 443,
+    method,
+    password: decodeURIComponent(url.password),
   };
+  const prefix = url.searchParams.get('prefix');
+  if (prefix) {
+    (transport as {prefix?: string}).prefix = prefix;
+  }
+  return newTunnelConfig(transport);
 }
 
 export function parseAccessKey(accessKey: string): ServiceConfig {
@@ -204,19 +387,34 @@ export function validateAccessKey(accessKey: string) {
   parseAccessKey(accessKey);
 }
 
-// We only support AEAD ciphers for Shadowsocks.
+// We only support AEAD ciphers for Shadowsocks, including the Shadowsocks 2022 ones.
 // See https://shadowsocks.org/en/spec/AEAD-Ciphers.html
 const SUPPORTED_SHADOWSOCKS_CIPHERS = [
   'chacha20-ietf-poly1305',
   'aes-128-gcm',
   'aes-192-gcm',
   'aes-256-gcm',
+  '2022-blake3-aes-128-gcm',
+  '2022-blake3-aes-256-gcm',
+  '2022-blake3-chacha20-poly1305',
 ];
 
 function isShadowsocksCipherSupported(cipher?: string): boolean {
   return SUPPORTED_SHADOWSOCKS_CIPHERS.includes(cipher);
 }
 
+/**
+ * checkShadowsocksPlugin throws if a SIP003 plugin is set, as in "plugin=obfs-local;obfs=http".
+ * No plugin is supported: ignoring it would silently fail to connect to the server.
+ */
+function checkShadowsocksPlugin(plugin?: string | null) {
+  if (!plugin) {
+    return;
+  }
+  const name = plugin.split(';')[0].trim();
+  throw new errors.ShadowsocksUnsupportedPlugin(name || plugin);
+}
+
 /**
  * serviceNameFromAccessKey extracts the service name from the access key.
  * This is done by getting parsing the fragment hash in the URL and returning the
@@ -28,6 +28,12 @@ export class ShadowsocksUnsupportedCipher extends CustomError {
   }
 }
 
+export class ShadowsocksUnsupportedPlugin extends CustomError {
+  constructor(readonly plugin: string) {
+    super();
+  }
+}
+
 export class ServerIncompatible extends CustomError {
   constructor(message: string) {
     super(message);
@@ -52,13 +58,25 @@ export class SessionConfigError extends CustomError {
   }
 }
 
+/** ProviderErrorAction is what the provider asks the user to do about a {@link SessionProviderError}. */
+export type ProviderErrorAction = 'renew' | 'contact';
+
 export class SessionProviderError extends CustomError {
   readonly details: string | undefined;
-
-  constructor(message: string, details?: string) {
+  /** url is the page of the provider where the user can take the action, e.g. renew. */
+  readonly url: string | undefined;
+  readonly action: ProviderErrorAction | undefined;
+
+  constructor(
+    message: string,
+    details?: string,
+    options?: {url?: string; action?: ProviderErrorAction}
+  ) {
     super(message);
 
     this.details = details;
+    this.url = options?.url;
+    this.action = options?.action;
   }
 }
 
@@ -239,14 +239,53 @@ export class PlatformError extends CustomError {
 export type ErrorCode = string;
 
 export const INTERNAL_ERROR: ErrorCode = 'ERR_INTERNAL_ERROR';
+export const UNKNOWN_METHOD: ErrorCode = 'ERR_UNKNOWN_METHOD';
+export const INVALID_METHOD_ARGUMENTS: ErrorCode =
+  'ERR_INVALID_METHOD_ARGUMENTS';
+export const FEATURE_NOT_SUPPORTED: ErrorCode = 'ERR_FEATURE_NOT_SUPPORTED';
 
 export const FETCH_CONFIG_FAILED: ErrorCode = 'ERR_FETCH_CONFIG_FAILURE';
 export const ILLEGAL_CONFIG: ErrorCode = 'ERR_ILLEGAL_CONFIG';
+export const CONFIG_REQUIRES_NEWER_APP: ErrorCode =
+  'ERR_CONFIG_REQUIRES_NEWER_APP';
+export const CONFIG_SIGNATURE_INVALID: ErrorCode =
+  'ERR_CONFIG_SIGNATURE_INVALID';
+export const CONFIG_TOO_LARGE: ErrorCode = 'ERR_CONFIG_TOO_LARGE';
 
 export const VPN_PERMISSION_NOT_GRANTED = 'ERR_VPN_PERMISSION_NOT_GRANTED';
 
 export const PROXY_SERVER_UNREACHABLE: ErrorCode =
   'ERR_PROXY_SERVER_UNREACHABLE';
+export const PROXY_SERVER_UDP_NOT_SUPPORTED: ErrorCode =
+  'ERR_PROXY_SERVER_UDP_NOT_SUPPORTED';
+export const UNAUTHENTICATED: ErrorCode = 'ERR_CLIENT_UNAUTHENTICATED';
+export const QUOTA_EXCEEDED: ErrorCode = 'ERR_QUOTA_EXCEEDED';
+export const DEVICE_CLOCK_SKEWED: ErrorCode = 'ERR_DEVICE_CLOCK_SKEWED';
+
+export const RESOLVE_IP_FAILED: ErrorCode = 'ERR_RESOLVE_IP_FAILURE';
+export const TLS_INTERCEPTED: ErrorCode = 'ERR_TLS_INTERCEPTED';
+export const CERTIFICATE_PIN_MISMATCH: ErrorCode =
+  'ERR_CERTIFICATE_PIN_MISMATCH';
 
 /** Indicates that the OS routing service is not running (electron only). */
 export const ROUTING_SERVICE_NOT_RUNNING = 'ERR_ROUTING_SERVICE_NOT_RUNNING';
+
+//////
+// Remediation Hints
+// They should be identical to the ones defined in Go's `platerrors` package.
+//////
+
+/**
+ * Hint is a machine-readable remediation of a {@link PlatformError}, set in the `hint` entry of
+ * its details.
+ */
+export type Hint = string;
+
+export const HINT_DETAILS_KEY = 'hint';
+
+export const HINT_CHECK_INTERNET: Hint = 'check-internet';
+export const HINT_CHECK_ACCESS_KEY: Hint = 'check-access-key';
+export const HINT_UNTRUSTED_NETWORK: Hint = 'untrusted-network';
+export const HINT_ENABLE_UDP_OVER_TCP: Hint = 'enable-udp-over-tcp';
+export const HINT_CONTACT_PROVIDER: Hint = 'contact-provider';
+export const HINT_FIX_DEVICE_CLOCK: Hint = 'fix-device-clock';